@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// AdmissionDeniedError is returned when an admission webhook
+// has rejected the resource, e.g. a policy engine
+type AdmissionDeniedError struct {
+	// Webhook is the name of the webhook that rejected the resource
+	Webhook string
+	// Message is the reason of the rejection reported by the webhook
+	Message string
+	// Object identifies the rejected resource, if known
+	Object string
+}
+
+// Error returns the webhook and its reason of the rejection
+func (e *AdmissionDeniedError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = "no reason given"
+	}
+	if e.Object != "" {
+		return fmt.Sprintf("admission webhook %v denied %v: %v", e.Webhook, e.Object, message)
+	}
+	return fmt.Sprintf("admission webhook %v denied the request: %v", e.Webhook, message)
+}
+
+// IsAdmissionDenied returns true if the error indicates
+// that an admission webhook has rejected the resource
+func IsAdmissionDenied(err error) bool {
+	_, ok := trace.Unwrap(err).(*AdmissionDeniedError)
+	return ok
+}
+
+// AdmissionDenial returns the admission webhook rejection the error is caused by
+// or nil if the error is not caused by an admission webhook
+func AdmissionDenial(err error) *AdmissionDeniedError {
+	denied, _ := trace.Unwrap(err).(*AdmissionDeniedError)
+	return denied
+}
+
+// admissionDeniedPattern matches the message of the API server
+// for requests rejected by admission webhooks
+var admissionDeniedPattern = regexp.MustCompile(`admission webhook "([^"]+)" denied the request(?::\s*([^\n]*)| without explanation)`)
+
+// parseAdmissionDenied returns AdmissionDeniedError if the message, e.g. the status
+// message of the API server or the kubectl error output, reports that
+// an admission webhook has rejected the request, nil otherwise
+func parseAdmissionDenied(message string) *AdmissionDeniedError {
+	match := admissionDeniedPattern.FindStringSubmatch(message)
+	if match == nil {
+		return nil
+	}
+	return &AdmissionDeniedError{
+		Webhook: match[1],
+		Message: strings.TrimSpace(match[2]),
+	}
+}
+
+// admissionDenied returns AdmissionDeniedError if the API server
+// has rejected the request because of an admission webhook
+func admissionDenied(statusErr *errors.StatusError) *AdmissionDeniedError {
+	status := statusErr.Status()
+	denied := parseAdmissionDenied(status.Message)
+	if denied == nil {
+		return nil
+	}
+	if details := status.Details; details != nil && details.Name != "" {
+		denied.Object = details.Name
+		if details.Kind != "" {
+			denied.Object = fmt.Sprintf("%v %v", details.Kind, details.Name)
+		}
+	}
+	return denied
+}
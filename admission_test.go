@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"net/http"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type AdmissionSuite struct{}
+
+var _ = Suite(&AdmissionSuite{})
+
+func (s *AdmissionSuite) TestConvertError(c *C) {
+	err := ConvertError(&errors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusForbidden,
+		Message: `admission webhook "policy.example.com" denied the request: image registry is not allowed`,
+		Details: &metav1.StatusDetails{Kind: KindDeployment, Name: "app"},
+	}})
+	c.Assert(IsAdmissionDenied(err), Equals, true, Commentf("%v", err))
+	c.Assert(AdmissionDenial(err), DeepEquals, &AdmissionDeniedError{
+		Webhook: "policy.example.com",
+		Message: "image registry is not allowed",
+		Object:  "Deployment app",
+	})
+
+	err = ConvertError(&errors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusForbidden,
+		Message: `deployments.apps "app" is forbidden: User "bob" cannot create deployments.apps`,
+	}})
+	c.Assert(IsAdmissionDenied(err), Equals, false)
+}
+
+func (s *AdmissionSuite) TestParseKubectlOutput(c *C) {
+	denied := parseAdmissionDenied(`Error from server: error when creating "STDIN": admission webhook "validate.example.com" denied the request without explanation` + "\n")
+	c.Assert(denied, DeepEquals, &AdmissionDeniedError{Webhook: "validate.example.com"})
+	c.Assert(denied.Error(), Equals, "admission webhook validate.example.com denied the request: no reason given")
+	c.Assert(parseAdmissionDenied(`Error from server (NotFound): namespaces "apps" not found`), IsNil)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultApprovalPollPeriod is the default period of approval checks
+	DefaultApprovalPollPeriod = 10 * time.Second
+	// ApprovalApprove is the file contents and prompt answer approving the plan
+	ApprovalApprove = "approve"
+	// ApprovalReject is the file contents and prompt answer rejecting the plan
+	ApprovalReject = "reject"
+)
+
+// Approver approves the plan before it is applied
+type Approver interface {
+	// Approve blocks until the plan is approved,
+	// returns AccessDenied if the plan is rejected
+	Approve(ctx context.Context, plan *ChangePlan) error
+}
+
+// PromptApprover shows the plan and asks for approval interactively
+type PromptApprover struct {
+	// In is the input with the answer, defaults to the standard input
+	In io.Reader
+	// Out is the output the plan is written to, defaults to the standard output
+	Out io.Writer
+}
+
+// Approve writes the plan and waits for the answer, only "yes" approves the plan
+func (p PromptApprover) Approve(ctx context.Context, plan *ChangePlan) error {
+	in, out := p.In, p.Out
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	if err := plan.WriteTable(out); err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Fprintf(out, "\nApply these changes? Only 'yes' will be accepted: ")
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(in).ReadString('\n')
+		answer <- strings.TrimSpace(line)
+	}()
+	select {
+	case <-ctx.Done():
+		return trace.Wrap(ctx.Err())
+	case line := <-answer:
+		if line != "yes" {
+			return trace.AccessDenied("plan rejected")
+		}
+		return nil
+	}
+}
+
+// WebhookApprover posts the plan as JSON to the URL. The webhook responds
+// with {"approved": true} or {"approved": false, "reason": "..."}, or with
+// 202 Accepted if the decision is pending, in which case the plan is posted
+// again after the poll period
+type WebhookApprover struct {
+	// URL is the webhook URL
+	URL string
+	// Header is added to the requests, e.g. the authorization header
+	Header http.Header
+	// Client is the HTTP client, defaults to a client with DefaultNotifyTimeout
+	Client *http.Client
+	// PollPeriod is the period between requests while the decision
+	// is pending, defaults to DefaultApprovalPollPeriod
+	PollPeriod time.Duration
+}
+
+// approval is the webhook decision
+type approval struct {
+	// Approved is set if the plan is approved
+	Approved bool `json:"approved"`
+	// Reason is the optional reason of the decision
+	Reason string `json:"reason,omitempty"`
+}
+
+// Approve posts the plan until the webhook makes a decision
+func (w WebhookApprover) Approve(ctx context.Context, plan *ChangePlan) error {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	period := w.PollPeriod
+	if period == 0 {
+		period = DefaultApprovalPollPeriod
+	}
+	for {
+		decision, err := w.post(ctx, data)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if decision != nil {
+			if !decision.Approved {
+				return trace.AccessDenied("plan rejected: %v", decision.Reason)
+			}
+			return nil
+		}
+		log.Infof("approval of plan %v is pending, check again in %v", plan.Bundle, period)
+		select {
+		case <-ctx.Done():
+			return trace.Wrap(ctx.Err())
+		case <-time.After(period):
+		}
+	}
+}
+
+// post posts the plan and returns the decision, nil if it is pending
+func (w WebhookApprover) post(ctx context.Context, data []byte) (*approval, error) {
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultNotifyTimeout}
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	for name, values := range w.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+		return nil, nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, trace.BadParameter("approval webhook returned %v: %s", resp.Status, body)
+	}
+	var decision approval
+	if err := json.Unmarshal(body, &decision); err != nil {
+		return nil, trace.BadParameter("invalid approval webhook response %q: %v", body, err)
+	}
+	return &decision, nil
+}
+
+// FileApprover writes the plan next to the approval file and waits for the
+// approval file to appear. The file approves the plan if it contains
+// "approve" and rejects it if it contains "reject" optionally followed by
+// the reason
+type FileApprover struct {
+	// Path is the path to the approval file, the plan is written to <path>.plan
+	Path string
+	// PollPeriod is the period of the file checks,
+	// defaults to DefaultApprovalPollPeriod
+	PollPeriod time.Duration
+}
+
+// Approve waits for the approval file
+func (f FileApprover) Approve(ctx context.Context, plan *ChangePlan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := ioutil.WriteFile(f.Path+".plan", data, 0644); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	period := f.PollPeriod
+	if period == 0 {
+		period = DefaultApprovalPollPeriod
+	}
+	log.Infof("plan written to %v.plan, waiting for %q or %q in %v", f.Path, ApprovalApprove, ApprovalReject, f.Path)
+	for {
+		decision, err := ioutil.ReadFile(f.Path)
+		if err == nil {
+			return trace.Wrap(parseApproval(string(decision)))
+		}
+		if !os.IsNotExist(err) {
+			return trace.ConvertSystemError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return trace.Wrap(ctx.Err())
+		case <-time.After(period):
+		}
+	}
+}
+
+// parseApproval parses the approval file contents
+func parseApproval(decision string) error {
+	decision = strings.TrimSpace(decision)
+	switch {
+	case decision == ApprovalApprove:
+		return nil
+	case strings.HasPrefix(decision, ApprovalReject):
+		reason := strings.TrimSpace(strings.TrimPrefix(decision, ApprovalReject))
+		return trace.AccessDenied("plan rejected: %v", reason)
+	}
+	return trace.BadParameter("unexpected approval %q, expected %q or %q", decision, ApprovalApprove, ApprovalReject)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type ApproveSuite struct{}
+
+var _ = Suite(&ApproveSuite{})
+
+func (s *ApproveSuite) TestPromptApprover(c *C) {
+	plan := &ChangePlan{Bundle: "app"}
+	var out bytes.Buffer
+	err := PromptApprover{In: strings.NewReader("yes\n"), Out: &out}.Approve(context.TODO(), plan)
+	c.Assert(err, IsNil)
+	c.Assert(out.String(), Matches, "(?s).*Apply these changes.*")
+	err = PromptApprover{In: strings.NewReader("y\n"), Out: &out}.Approve(context.TODO(), plan)
+	c.Assert(trace.IsAccessDenied(err), Equals, true)
+}
+
+func (s *ApproveSuite) TestWebhookApprover(c *C) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var plan ChangePlan
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil || plan.Bundle != "app" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Write([]byte(`{"approved": false, "reason": "change freeze"}`))
+	}))
+	defer server.Close()
+	err := WebhookApprover{URL: server.URL, PollPeriod: time.Millisecond}.Approve(context.TODO(), &ChangePlan{Bundle: "app"})
+	c.Assert(trace.IsAccessDenied(err), Equals, true, Commentf("%v", err))
+	c.Assert(err, ErrorMatches, ".*change freeze.*")
+	c.Assert(requests, Equals, 2)
+}
+
+func (s *ApproveSuite) TestFileApprover(c *C) {
+	path := filepath.Join(c.MkDir(), "approval")
+	c.Assert(ioutil.WriteFile(path, []byte("approve\n"), 0644), IsNil)
+	err := FileApprover{Path: path}.Approve(context.TODO(), &ChangePlan{Bundle: "app"})
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadFile(path + ".plan")
+	c.Assert(err, IsNil)
+
+	c.Assert(parseApproval("reject not now"), ErrorMatches, ".*not now")
+	c.Assert(trace.IsBadParameter(parseApproval("maybe")), Equals, true)
+}
@@ -0,0 +1,479 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	goyaml "github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BundleMetadataFile is the name of the bundle metadata file in the archive
+	BundleMetadataFile = "bundle.yaml"
+	// HookPreApply hooks run before the first wave is applied
+	HookPreApply = "pre-apply"
+	// HookPostApply hooks run after the last wave is ready
+	HookPostApply = "post-apply"
+	// DefaultMaxUnpackedSize is the default maximum total size
+	// of the files unpacked from a bundle archive
+	DefaultMaxUnpackedSize = 256 << 20
+)
+
+// BundleMetadata describes the contents of a bundle archive
+type BundleMetadata struct {
+	// Name is the bundle name
+	Name string `json:"name"`
+	// Version is the bundle version
+	Version string `json:"version"`
+	// Waves lists groups of manifests applied in order, each wave
+	// is applied once the previous one is ready. If omitted, all manifests
+	// except hooks are applied in a single wave
+	Waves []Wave `json:"waves,omitempty"`
+	// Hooks lists jobs run before or after the waves are applied
+	Hooks []Hook `json:"hooks,omitempty"`
+	// Requires lists names of bundles applied before this bundle
+	// when bundles are composed, see ComposeBundles
+	Requires []string `json:"requires,omitempty"`
+}
+
+// Wave is a group of manifests applied together
+type Wave struct {
+	// Name is the wave name
+	Name string `json:"name"`
+	// Files lists manifest files of the wave, entries ending with /
+	// include all manifests in the directory
+	Files []string `json:"files"`
+	// Gate is an optional external condition waited for
+	// after the previous wave is ready and before the wave is applied
+	Gate *GateSpec `json:"gate,omitempty"`
+}
+
+// Hook is a job run at a specific phase of the bundle apply
+type Hook struct {
+	// Name is the hook name
+	Name string `json:"name"`
+	// Phase is either pre-apply or post-apply
+	Phase string `json:"phase"`
+	// File is the job manifest file
+	File string `json:"file"`
+}
+
+// CheckAndSetDefaults validates the metadata and sets defaults
+func (m *BundleMetadata) CheckAndSetDefaults() error {
+	var errors []error
+	if m.Name == "" {
+		errors = append(errors, trace.BadParameter("missing parameter name"))
+	}
+	if m.Version == "" {
+		errors = append(errors, trace.BadParameter("missing parameter version"))
+	}
+	for _, wave := range m.Waves {
+		if len(wave.Files) == 0 {
+			errors = append(errors, trace.BadParameter("wave %q has no files", wave.Name))
+		}
+		if wave.Gate != nil {
+			if err := wave.Gate.Check(); err != nil {
+				errors = append(errors, trace.Wrap(err, "wave %q", wave.Name))
+			}
+		}
+	}
+	for _, name := range m.Requires {
+		if name == m.Name {
+			errors = append(errors, trace.BadParameter("bundle %q requires itself", name))
+		}
+	}
+	for _, hook := range m.Hooks {
+		if hook.Phase != HookPreApply && hook.Phase != HookPostApply {
+			errors = append(errors, trace.BadParameter("hook %q has unsupported phase %q", hook.Name, hook.Phase))
+		}
+		if hook.File == "" {
+			errors = append(errors, trace.BadParameter("hook %q has no file", hook.Name))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// BundleArchive is an unpacked bundle archive
+type BundleArchive struct {
+	// Metadata describes the bundle
+	Metadata BundleMetadata
+	// Files maps slash separated paths relative to the archive root to contents
+	Files map[string][]byte
+}
+
+// Pack writes the bundle in the specified directory to w as a tar.gz archive.
+// The directory should contain the bundle.yaml metadata file.
+// The archive is reproducible: files are sorted and timestamps are omitted
+func Pack(dir string, w io.Writer) error {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	archive, err := newBundleArchive(files)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(archive.Write(w))
+}
+
+// Unpack reads a tar.gz bundle archive of at most DefaultMaxUnpackedSize
+// bytes uncompressed, see UnpackWithLimit
+func Unpack(r io.Reader) (*BundleArchive, error) {
+	return UnpackWithLimit(r, DefaultMaxUnpackedSize)
+}
+
+// UnpackWithLimit reads a tar.gz bundle archive, returns LimitExceeded
+// if the uncompressed archive is larger than maxSize bytes,
+// so that a small compressed archive can not exhaust the memory
+func UnpackWithLimit(r io.Reader, maxSize int64) (*BundleArchive, error) {
+	if maxSize <= 0 {
+		return nil, trace.BadParameter("maximum archive size should be positive, got %v", maxSize)
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer gz.Close()
+	files := make(map[string][]byte)
+	limited := &io.LimitedReader{R: gz, N: maxSize + 1}
+	reader := tar.NewReader(limited)
+	for {
+		header, err := reader.Next()
+		if limited.N <= 0 {
+			return nil, trace.LimitExceeded("archive is larger than %v bytes uncompressed", maxSize)
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, trace.Wrap(err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return nil, trace.BadParameter("archive entry %q is outside of the archive root", header.Name)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if limited.N <= 0 {
+			return nil, trace.LimitExceeded("archive is larger than %v bytes uncompressed", maxSize)
+		}
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		files[name] = data
+	}
+	return newBundleArchive(files)
+}
+
+func newBundleArchive(files map[string][]byte) (*BundleArchive, error) {
+	data, ok := files[BundleMetadataFile]
+	if !ok {
+		return nil, trace.NotFound("bundle has no %v", BundleMetadataFile)
+	}
+	archive := &BundleArchive{Files: files}
+	if err := goyaml.Unmarshal(data, &archive.Metadata); err != nil {
+		return nil, trace.Wrap(err, "failed to parse %v", BundleMetadataFile)
+	}
+	if err := archive.Metadata.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, wave := range archive.Waves() {
+		for _, pattern := range wave.Files {
+			if len(archive.match(pattern)) == 0 {
+				return nil, trace.NotFound("wave %q: no manifests match %q", wave.Name, pattern)
+			}
+		}
+	}
+	for _, hook := range archive.Metadata.Hooks {
+		if _, ok := files[hook.File]; !ok {
+			return nil, trace.NotFound("hook %q: file %q not found", hook.Name, hook.File)
+		}
+	}
+	return archive, nil
+}
+
+// Write writes the archive to w as tar.gz
+func (a *BundleArchive) Write(w io.Writer) error {
+	return writeTarGz(w, a.Files)
+}
+
+// writeTarGz writes the files to w as tar.gz in the order of their names
+func writeTarGz(w io.Writer, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	gz := gzip.NewWriter(w)
+	writer := tar.NewWriter(gz)
+	for _, name := range names {
+		data := files[name]
+		err := writer.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Unix(0, 0),
+		})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(gz.Close())
+}
+
+// Waves returns the waves of the bundle, if the metadata has no waves,
+// a single wave with all manifests except hooks is returned
+func (a *BundleArchive) Waves() []Wave {
+	if len(a.Metadata.Waves) != 0 {
+		return a.Metadata.Waves
+	}
+	hooks := make(map[string]bool)
+	for _, hook := range a.Metadata.Hooks {
+		hooks[hook.File] = true
+	}
+	wave := Wave{Name: a.Metadata.Name}
+	for _, name := range a.names() {
+		if name != BundleMetadataFile && !hooks[name] && isManifest(name) {
+			wave.Files = append(wave.Files, name)
+		}
+	}
+	return []Wave{wave}
+}
+
+// Manifests returns the manifest stream of the wave
+func (a *BundleArchive) Manifests(wave Wave) []byte {
+	var buf bytes.Buffer
+	for _, pattern := range wave.Files {
+		for _, name := range a.match(pattern) {
+			buf.WriteString("---\n")
+			buf.Write(a.Files[name])
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// Bundle returns all resources of the archive waves
+func (a *BundleArchive) Bundle(namespace string) (*Bundle, error) {
+	var buf bytes.Buffer
+	for _, wave := range a.Waves() {
+		buf.Write(a.Manifests(wave))
+	}
+	return NewBundle(a.Metadata.Name, namespace, buf.Bytes())
+}
+
+// ApplyConfig configures application of a bundle archive
+type ApplyConfig struct {
+	// Changeset is the changeset client
+	Changeset *Changeset
+	// ChangesetNamespace is the namespace of the changeset
+	ChangesetNamespace string
+	// ChangesetName is the name of the changeset, defaults to
+	// the bundle name and version
+	ChangesetName string
+	// RetryAttempts is the number of status attempts for each wave and hook
+	RetryAttempts int
+	// RetryPeriod is the period between status attempts
+	RetryPeriod time.Duration
+	// Filter optionally selects the resources of waves and hooks to apply,
+	// steps left without resources are skipped
+	Filter *ResourceFilter
+	// Transformers modify the manifests of waves and hooks before they are applied
+	Transformers []Transformer
+	// ImageCheck optionally verifies that the images of all waves and hooks
+	// exist in their registries before anything is applied
+	ImageCheck *ImageCheckConfig
+	// Approver approves the continuation of the rollout at approval gates
+	Approver Approver
+}
+
+// Apply runs pre-apply hooks, applies waves in order waiting for each
+// wave to become ready and for the gate of the next wave, if any,
+// runs post-apply hooks and freezes the changeset.
+// If any step fails, the changeset is reverted and RollbackError is returned
+func (a *BundleArchive) Apply(ctx context.Context, config ApplyConfig) error {
+	if config.Changeset == nil {
+		return trace.BadParameter("missing parameter Changeset")
+	}
+	if config.ChangesetName == "" {
+		config.ChangesetName = a.Metadata.Name + "-" + a.Metadata.Version
+	}
+	config.ChangesetNamespace = Namespace(config.ChangesetNamespace)
+	entry := log.WithFields(log.Fields{
+		"bundle": a.Metadata.Name + ":" + a.Metadata.Version,
+	})
+	if config.ImageCheck != nil {
+		if err := a.checkImages(ctx, config); err != nil {
+			return trace.Wrap(err, "image preflight failed")
+		}
+	}
+	err := a.apply(ctx, config, entry)
+	if err == nil {
+		return trace.Wrap(config.Changeset.Freeze(ctx, config.ChangesetNamespace, config.ChangesetName))
+	}
+	entry.Warningf("apply failed, reverting: %v", err)
+	errRevert := config.Changeset.Revert(ctx, config.ChangesetNamespace, config.ChangesetName)
+	if errRevert != nil {
+		entry.Errorf("failed to revert: %v", trace.DebugReport(errRevert))
+	}
+	return trace.Wrap(&RollbackError{Err: err, RevertErr: errRevert})
+}
+
+func (a *BundleArchive) apply(ctx context.Context, config ApplyConfig, entry *log.Entry) error {
+	step := func(name string, data []byte) error {
+		data, err := Transform(data, config.Transformers...)
+		if err != nil {
+			return trace.Wrap(err, "failed to transform %v", name)
+		}
+		data, ok, err := filterManifests(config.Filter, data)
+		if err != nil {
+			return trace.Wrap(err, "failed to filter %v", name)
+		}
+		if !ok {
+			entry.Infof("skip %v: no resources match the filter", name)
+			return nil
+		}
+		entry.Infof("apply %v", name)
+		err = config.Changeset.Upsert(ctx, config.ChangesetNamespace, config.ChangesetName, data)
+		if err != nil {
+			return trace.Wrap(err, "failed to apply %v", name)
+		}
+		err = config.Changeset.Status(ctx, config.ChangesetNamespace, config.ChangesetName, config.RetryAttempts, config.RetryPeriod)
+		return trace.Wrap(err, "%v is not ready", name)
+	}
+	if err := a.runHooks(HookPreApply, step); err != nil {
+		return trace.Wrap(err)
+	}
+	for _, wave := range a.Waves() {
+		if wave.Gate != nil {
+			gate, err := wave.Gate.gate(config.Changeset.Client, config.Approver, "wave "+wave.Name)
+			if err != nil {
+				return trace.Wrap(err)
+			}
+			entry.Infof("wait for gate %v before wave %v", gate, wave.Name)
+			if err := waitGate(ctx, gate, wave.Gate.timeout()); err != nil {
+				return trace.Wrap(err, "gate before wave %v", wave.Name)
+			}
+		}
+		if err := step("wave "+wave.Name, a.Manifests(wave)); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return trace.Wrap(a.runHooks(HookPostApply, step))
+}
+
+// checkImages verifies the images of all waves and hooks
+func (a *BundleArchive) checkImages(ctx context.Context, config ApplyConfig) error {
+	imageCheck := *config.ImageCheck
+	if imageCheck.Client == nil {
+		imageCheck.Client = config.Changeset.Client
+	}
+	var buf bytes.Buffer
+	for _, wave := range a.Waves() {
+		buf.Write(a.Manifests(wave))
+	}
+	for _, hook := range a.Metadata.Hooks {
+		buf.WriteString("---\n")
+		buf.Write(a.Files[hook.File])
+		buf.WriteString("\n")
+	}
+	data, err := Transform(buf.Bytes(), config.Transformers...)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(PreflightImages(ctx, imageCheck, data))
+}
+
+func (a *BundleArchive) runHooks(phase string, step func(name string, data []byte) error) error {
+	for _, hook := range a.Metadata.Hooks {
+		if hook.Phase != phase {
+			continue
+		}
+		if err := step("hook "+hook.Name, a.Files[hook.File]); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// match returns sorted names of the files matching the pattern,
+// patterns ending with / match all manifests in the directory
+func (a *BundleArchive) match(pattern string) []string {
+	if !strings.HasSuffix(pattern, "/") {
+		if _, ok := a.Files[pattern]; ok {
+			return []string{pattern}
+		}
+		return nil
+	}
+	var names []string
+	for _, name := range a.names() {
+		if strings.HasPrefix(name, pattern) && isManifest(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (a *BundleArchive) names() []string {
+	names := make([]string, 0, len(a.Files))
+	for name := range a.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
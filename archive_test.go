@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type ArchiveSuite struct{}
+
+var _ = Suite(&ArchiveSuite{})
+
+func (s *ArchiveSuite) TestPackUnpack(c *C) {
+	dir := c.MkDir()
+	files := map[string]string{
+		BundleMetadataFile: `
+name: app
+version: 1.0.0
+waves:
+- name: config
+  files: [config.yaml]
+- name: workloads
+  files: [workloads/]
+hooks:
+- name: migrate
+  phase: pre-apply
+  file: hooks/migrate.yaml
+`,
+		"config.yaml":         "kind: ConfigMap",
+		"workloads/a.yaml":    "kind: Deployment",
+		"workloads/b.yaml":    "kind: Service",
+		"workloads/README.md": "docs",
+		"hooks/migrate.yaml":  "kind: Job",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(data), 0644), IsNil)
+	}
+
+	var first, second bytes.Buffer
+	c.Assert(Pack(dir, &first), IsNil)
+	c.Assert(Pack(dir, &second), IsNil)
+	c.Assert(first.Bytes(), DeepEquals, second.Bytes(), Commentf("archive should be reproducible"))
+
+	archive, err := Unpack(&first)
+	c.Assert(err, IsNil)
+	c.Assert(archive.Metadata.Name, Equals, "app")
+	c.Assert(archive.Files, HasLen, len(files))
+	waves := archive.Waves()
+	c.Assert(waves, HasLen, 2)
+	c.Assert(string(archive.Manifests(waves[1])), Equals, "---\nkind: Deployment\n---\nkind: Service\n")
+
+	_, err = UnpackWithLimit(bytes.NewReader(second.Bytes()), 1024)
+	c.Assert(trace.IsLimitExceeded(err), Equals, true, Commentf("%v", err))
+	_, err = UnpackWithLimit(bytes.NewReader(second.Bytes()), 1<<20)
+	c.Assert(err, IsNil)
+}
+
+func (s *ArchiveSuite) TestMissingWaveFiles(c *C) {
+	dir := c.MkDir()
+	metadata := "name: app\nversion: 1.0.0\nwaves:\n- name: main\n  files: [missing.yaml]\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, BundleMetadataFile), []byte(metadata), 0644), IsNil)
+	var buf bytes.Buffer
+	err := Pack(dir, &buf)
+	c.Assert(err, NotNil)
+}
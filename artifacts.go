@@ -0,0 +1,338 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultArtifactsHelperImage is the image of the helper pods
+// reading artifacts of exited job containers from their volumes
+const DefaultArtifactsHelperImage = "busybox:1.36"
+
+// ArtifactsConfig configures extraction of job artifacts
+type ArtifactsConfig struct {
+	// Client is k8s client
+	Client *kubernetes.Clientset
+	// Kubectl runs kubectl exec to stream the artifacts
+	Kubectl Kubectl
+	// Container is the job container that produced the artifacts,
+	// defaults to the first container of the job pod
+	Container string
+	// HelperImage is the image of the helper pod, defaults to DefaultArtifactsHelperImage.
+	// The image should provide sleep and tar
+	HelperImage string
+	// RetryAttempts is the number of attempts to wait for the helper pod to start
+	RetryAttempts int
+	// RetryPeriod is the period between the attempts
+	RetryPeriod time.Duration
+}
+
+// CheckAndSetDefaults validates the config and sets defaults
+func (c *ArtifactsConfig) CheckAndSetDefaults() error {
+	if c.Client == nil {
+		return trace.BadParameter("missing parameter Client")
+	}
+	if c.HelperImage == "" {
+		c.HelperImage = DefaultArtifactsHelperImage
+	}
+	if c.RetryAttempts == 0 {
+		c.RetryAttempts = DefaultRetryAttempts
+	}
+	if c.RetryPeriod == 0 {
+		c.RetryPeriod = DefaultRetryPeriod
+	}
+	return nil
+}
+
+// ExtractJobArtifacts copies the file or directory at containerPath out of
+// the most recent succeeded pod of the job, or the most recent pod if none
+// has succeeded, to the dest directory via a tar stream of kubectl exec.
+// The pods must not have been cleaned up yet.
+//
+// Containers cannot be exec'd into once they have exited, so unless the
+// container is still running, the path must be on a persistent volume claim
+// mounted in the container. The claim is then mounted read-only in a helper
+// pod on the node of the job pod, which is deleted once the artifacts are copied
+func ExtractJobArtifacts(ctx context.Context, config ArtifactsConfig, job *batchv1.Job, containerPath, dest string) error {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	if !path.IsAbs(containerPath) {
+		return trace.BadParameter("expected absolute container path, got %q", containerPath)
+	}
+	containerPath = path.Clean(containerPath)
+	entry := log.WithField("job", FormatMeta(job.ObjectMeta))
+	var matchLabels map[string]string
+	if job.Spec.Selector != nil {
+		matchLabels = job.Spec.Selector.MatchLabels
+	}
+	pods, err := CollectPods(job.Namespace, matchLabels, entry, config.Client, func(ref metav1.OwnerReference) bool {
+		return ref.Kind == KindJob && ref.UID == job.UID
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	pod := artifactsPod(pods)
+	if pod == nil {
+		return trace.NotFound("job %v has no pods", FormatMeta(job.ObjectMeta))
+	}
+	container, err := artifactsContainer(*pod, config.Container)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if containerRunning(*pod, container.Name) {
+		entry.Infof("copy %v from container %v of pod %v", containerPath, container.Name, pod.Name)
+		return trace.Wrap(copyArtifacts(config.Kubectl, pod.Namespace, pod.Name, container.Name, containerPath, dest))
+	}
+	mount, volume, err := artifactsVolume(*pod, *container, containerPath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	helper, err := createArtifactsHelper(config, *pod, mount, volume)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	helpers := config.Client.CoreV1().Pods(helper.Namespace)
+	defer func() {
+		if err := helpers.Delete(helper.Name, nil); err != nil {
+			entry.Warningf("failed to delete helper pod %v: %v", helper.Name, ConvertError(err))
+		}
+	}()
+	err = retry(ctx, config.RetryAttempts, config.RetryPeriod, func() error {
+		current, err := helpers.Get(helper.Name, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		if current.Status.Phase != v1.PodRunning {
+			return trace.CompareFailed("helper pod %v is %v", helper.Name, current.Status.Phase)
+		}
+		return nil
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	entry.Infof("copy %v from volume %v of pod %v", containerPath, volume.Name, pod.Name)
+	return trace.Wrap(copyArtifacts(config.Kubectl, helper.Namespace, helper.Name, helper.Spec.Containers[0].Name, containerPath, dest))
+}
+
+// artifactsPod returns the most recent succeeded pod,
+// or the most recent pod if none has succeeded
+func artifactsPod(pods []v1.Pod) *v1.Pod {
+	var found *v1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if found == nil {
+			found = pod
+			continue
+		}
+		succeeded, foundSucceeded := pod.Status.Phase == v1.PodSucceeded, found.Status.Phase == v1.PodSucceeded
+		if succeeded != foundSucceeded {
+			if succeeded {
+				found = pod
+			}
+			continue
+		}
+		if newerPod(pod, found) {
+			found = pod
+		}
+	}
+	return found
+}
+
+// artifactsContainer returns the named container of the pod,
+// or the first container if the name is empty
+func artifactsContainer(pod v1.Pod, name string) (*v1.Container, error) {
+	for i, container := range pod.Spec.Containers {
+		if name == "" || container.Name == name {
+			return &pod.Spec.Containers[i], nil
+		}
+	}
+	return nil, trace.NotFound("pod %v has no container %q", FormatMeta(pod.ObjectMeta), name)
+}
+
+// containerRunning returns true if the named container of the pod is running
+func containerRunning(pod v1.Pod, name string) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == name {
+			return status.State.Running != nil
+		}
+	}
+	return false
+}
+
+// artifactsVolume returns the mount of the container the path is on,
+// the mounted volume must be a persistent volume claim
+func artifactsVolume(pod v1.Pod, container v1.Container, containerPath string) (v1.VolumeMount, v1.Volume, error) {
+	var found *v1.VolumeMount
+	for i, mount := range container.VolumeMounts {
+		mountPath := path.Clean(mount.MountPath)
+		if containerPath != mountPath && !strings.HasPrefix(containerPath, strings.TrimSuffix(mountPath, "/")+"/") {
+			continue
+		}
+		if found == nil || len(mountPath) > len(path.Clean(found.MountPath)) {
+			found = &container.VolumeMounts[i]
+		}
+	}
+	if found == nil {
+		return v1.VolumeMount{}, v1.Volume{}, trace.BadParameter(
+			"container %v has exited and %v is not on a volume", container.Name, containerPath)
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name != found.Name {
+			continue
+		}
+		if volume.PersistentVolumeClaim == nil {
+			return v1.VolumeMount{}, v1.Volume{}, trace.BadParameter(
+				"container %v has exited and volume %v with %v is not a persistent volume claim", container.Name, volume.Name, containerPath)
+		}
+		return *found, volume, nil
+	}
+	return v1.VolumeMount{}, v1.Volume{}, trace.NotFound("pod %v has no volume %v", FormatMeta(pod.ObjectMeta), found.Name)
+}
+
+// createArtifactsHelper creates a pod mounting the volume read-only
+// on the node of the pod at the path it is mounted at in the pod
+func createArtifactsHelper(config ArtifactsConfig, pod v1.Pod, mount v1.VolumeMount, volume v1.Volume) (*v1.Pod, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	volume.PersistentVolumeClaim.ReadOnly = true
+	mount.ReadOnly = true
+	helper := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name + "-artifacts-" + hex.EncodeToString(suffix),
+			Namespace: pod.Namespace,
+		},
+		Spec: v1.PodSpec{
+			NodeName:      pod.Spec.NodeName,
+			RestartPolicy: v1.RestartPolicyNever,
+			Tolerations:   pod.Spec.Tolerations,
+			// run as the pod did so the helper can read the files it wrote
+			SecurityContext: pod.Spec.SecurityContext,
+			Containers: []v1.Container{{
+				Name:         "artifacts",
+				Image:        config.HelperImage,
+				Command:      []string{"sleep", "3600"},
+				VolumeMounts: []v1.VolumeMount{mount},
+			}},
+			Volumes: []v1.Volume{volume},
+		},
+	}
+	log.Infof("create helper pod %v to read volume %v", FormatMeta(helper.ObjectMeta), volume.Name)
+	helper, err := config.Client.CoreV1().Pods(pod.Namespace).Create(helper)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	return helper, nil
+}
+
+// copyArtifacts streams the path out of the container with tar
+// and extracts it to the dest directory
+func copyArtifacts(kubectl Kubectl, namespace, pod, container, containerPath, dest string) error {
+	cmd := kubectl.Command("exec", "--namespace", namespace, pod, "--container", container,
+		"--", "tar", "cf", "-", "-C", path.Dir(containerPath), path.Base(containerPath))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	if err := untar(stdout, dest); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return trace.Wrap(err)
+	}
+	// drain the padding after the end of the archive so tar can exit
+	io.Copy(ioutil.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return trace.Wrap(err, "kubectl exec: %s", bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// untar extracts regular files and directories of the tar stream
+// to the dest directory, entries outside of the directory are rejected
+func untar(r io.Reader, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(header.Name))
+		rel, err := filepath.Rel(dest, target)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return trace.BadParameter("archive entry %q is outside of %v", header.Name, dest)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return trace.ConvertSystemError(err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeArtifact(target, header.FileInfo().Mode().Perm(), reader); err != nil {
+				return trace.Wrap(err)
+			}
+		default:
+			log.Debugf("skip archive entry %v of type %c", header.Name, header.Typeflag)
+		}
+	}
+}
+
+func writeArtifact(target string, mode os.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	return trace.ConvertSystemError(f.Close())
+}
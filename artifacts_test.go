@@ -0,0 +1,132 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type ArtifactsSuite struct{}
+
+var _ = Suite(&ArtifactsSuite{})
+
+func (s *ArtifactsSuite) TestUntar(c *C) {
+	archive := func(names ...string) *bytes.Buffer {
+		var buf bytes.Buffer
+		writer := tar.NewWriter(&buf)
+		for _, name := range names {
+			data := []byte("report of " + name)
+			c.Assert(writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}), IsNil)
+			_, err := writer.Write(data)
+			c.Assert(err, IsNil)
+		}
+		c.Assert(writer.Close(), IsNil)
+		return &buf
+	}
+
+	dest := filepath.Join(c.MkDir(), "reports")
+	c.Assert(untar(archive("reports/summary.txt", "reports/tables/users.txt"), dest), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(dest, "reports", "tables", "users.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "report of reports/tables/users.txt")
+
+	err = untar(archive("../../etc/passwd"), dest)
+	c.Assert(trace.IsBadParameter(err), Equals, true)
+}
+
+func (s *ArtifactsSuite) TestCopyArtifacts(c *C) {
+	dir := c.MkDir()
+	reports := filepath.Join(dir, "container", "reports")
+	c.Assert(os.MkdirAll(reports, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(reports, "summary.txt"), []byte("passed"), 0644), IsNil)
+	path := filepath.Join(dir, "kubectl")
+	// the fake kubectl archives the last argument from the local directory
+	script := "#!/bin/sh\nfor last; do :; done\ncd " + filepath.Join(dir, "container") + "\nexec tar cf - \"$last\"\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+
+	dest := filepath.Join(dir, "artifacts")
+	err := copyArtifacts(Kubectl{Path: path}, "default", "migrate", "migrate", "/reports", dest)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(dest, "reports", "summary.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "passed")
+
+	script = "#!/bin/sh\necho container not found >&2\nexit 1\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+	err = copyArtifacts(Kubectl{Path: path}, "default", "migrate", "migrate", "/reports", dest)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, "(?s).*container not found.*")
+}
+
+func (s *ArtifactsSuite) TestArtifactsPod(c *C) {
+	now := time.Now()
+	pod := func(name string, phase v1.PodPhase, created time.Time) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+	}
+	c.Assert(artifactsPod(nil), IsNil)
+	found := artifactsPod([]v1.Pod{
+		pod("failed", v1.PodFailed, now.Add(-time.Hour)),
+		pod("succeeded", v1.PodSucceeded, now.Add(-time.Minute)),
+		pod("running", v1.PodRunning, now),
+	})
+	c.Assert(found.Name, Equals, "succeeded")
+	found = artifactsPod([]v1.Pod{
+		pod("failed", v1.PodFailed, now.Add(-time.Hour)),
+		pod("running", v1.PodRunning, now),
+	})
+	c.Assert(found.Name, Equals, "running")
+}
+
+func (s *ArtifactsSuite) TestArtifactsVolume(c *C) {
+	pod := v1.Pod{
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "migrations"}}},
+				{Name: "tmp", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+			},
+		},
+	}
+	container := v1.Container{
+		Name: "migrate",
+		VolumeMounts: []v1.VolumeMount{
+			{Name: "data", MountPath: "/var/lib/migrate"},
+			{Name: "tmp", MountPath: "/var/lib/migrate/tmp/"},
+		},
+	}
+	mount, volume, err := artifactsVolume(pod, container, "/var/lib/migrate/reports")
+	c.Assert(err, IsNil)
+	c.Assert(mount.Name, Equals, "data")
+	c.Assert(volume.PersistentVolumeClaim.ClaimName, Equals, "migrations")
+
+	_, _, err = artifactsVolume(pod, container, "/var/lib/migrate/tmp/report.txt")
+	c.Assert(trace.IsBadParameter(err), Equals, true)
+	_, _, err = artifactsVolume(pod, container, "/var/lib/migrated")
+	c.Assert(trace.IsBadParameter(err), Equals, true)
+}
@@ -0,0 +1,259 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// Bundle is a named set of resources applied together
+type Bundle struct {
+	// Name identifies the bundle
+	Name string
+	// Namespace is the namespace of the bundle inventory and
+	// the default namespace of namespaced resources
+	Namespace string
+	// Objects lists the bundle resources
+	Objects []*unstructured.Unstructured
+	// Discovery optionally resolves the scope of the bundle resources
+	// served by the API server, e.g. of custom resources. The scope of
+	// the well-known kinds, see IsClusterScoped, is used if unset
+	Discovery ResourceDiscovery
+
+	// scopes caches the scopes of the kinds served by the API server
+	scopes map[schema.GroupKind]bool
+}
+
+// NewBundle decodes the manifest stream into a new bundle
+func NewBundle(name, namespace string, data []byte) (*Bundle, error) {
+	if name == "" {
+		return nil, trace.BadParameter("missing parameter name")
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Bundle{
+		Name:      name,
+		Namespace: Namespace(namespace),
+		Objects:   objects,
+	}, nil
+}
+
+// Refs returns sorted references to all bundle resources
+func (b *Bundle) Refs() []ObjectRef {
+	refs := make([]ObjectRef, 0, len(b.Objects))
+	for _, object := range b.Objects {
+		refs = append(refs, b.Ref(object))
+	}
+	SortRefs(refs)
+	return refs
+}
+
+// Ref returns a reference to the specified bundle resource, namespaced
+// resources without namespace default to the bundle namespace
+func (b *Bundle) Ref(object *unstructured.Unstructured) ObjectRef {
+	ref := ObjectRefFor(object)
+	if b.isClusterScoped(ref) {
+		ref.Namespace = ""
+	} else if ref.Namespace == "" {
+		ref.Namespace = Namespace(b.Namespace)
+	}
+	return ref
+}
+
+// ObjectRef references a resource by kind, namespace and name
+type ObjectRef struct {
+	// APIVersion is the API version of the resource
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind is the resource kind
+	Kind string `json:"kind"`
+	// Namespace is the resource namespace, empty for cluster-scoped resources
+	Namespace string `json:"namespace,omitempty"`
+	// Name is the resource name
+	Name string `json:"name"`
+}
+
+// String returns a human readable reference, e.g. Deployment/kube-system/dns
+func (r ObjectRef) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%v/%v", r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%v/%v/%v", r.Kind, r.Namespace, r.Name)
+}
+
+// ObjectRefFor returns a reference to the object
+func ObjectRefFor(object *unstructured.Unstructured) ObjectRef {
+	return ObjectRef{
+		APIVersion: object.GetAPIVersion(),
+		Kind:       object.GetKind(),
+		Namespace:  object.GetNamespace(),
+		Name:       object.GetName(),
+	}
+}
+
+// ParseObjectRef parses the reference in the format returned by ObjectRef.String,
+// i.e. kind/name for cluster-scoped resources or kind/namespace/name
+func ParseObjectRef(in string) (*ObjectRef, error) {
+	parts := strings.Split(in, "/")
+	for _, part := range parts {
+		if part == "" {
+			return nil, trace.BadParameter("expected kind/name or kind/namespace/name, got %q", in)
+		}
+	}
+	switch len(parts) {
+	case 2:
+		return &ObjectRef{Kind: parts[0], Name: parts[1]}, nil
+	case 3:
+		return &ObjectRef{Kind: parts[0], Namespace: parts[1], Name: parts[2]}, nil
+	}
+	return nil, trace.BadParameter("expected kind/name or kind/namespace/name, got %q", in)
+}
+
+// ManifestRef returns the reference to the resource in the manifest
+func ManifestRef(data []byte) (*ObjectRef, error) {
+	header, err := ParseResourceHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &ObjectRef{
+		APIVersion: header.APIVersion,
+		Kind:       header.Kind,
+		Namespace:  header.Namespace,
+		Name:       header.Name,
+	}, nil
+}
+
+// key identifies the resource regardless of its API version,
+// resources of the same kind in different API groups are told apart
+func (r ObjectRef) key() string {
+	return r.group() + ":" + r.String()
+}
+
+// group returns the API group of the resource, the group of the preferred
+// version of the kinds managed by rigging if the version is deprecated or missing
+func (r ObjectRef) group() string {
+	if r.APIVersion == "" {
+		r.APIVersion = APIVersionFor(r.Kind)
+	}
+	gvk := schema.FromAPIVersionAndKind(r.APIVersion, r.Kind)
+	if _, ok := legacyGVKs[gvk]; ok {
+		gvk = schema.FromAPIVersionAndKind(APIVersionFor(r.Kind), r.Kind)
+	}
+	return gvk.Group
+}
+
+// SortRefs sorts references by kind, namespace and name
+func SortRefs(refs []ObjectRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Kind != refs[j].Kind {
+			return refs[i].Kind < refs[j].Kind
+		}
+		if refs[i].Namespace != refs[j].Namespace {
+			return refs[i].Namespace < refs[j].Namespace
+		}
+		return refs[i].Name < refs[j].Name
+	})
+}
+
+// isClusterScoped returns true if the resource is not namespaced according to
+// the API discovery, or the custom resource definitions of the bundle,
+// or the well-known cluster-scoped kinds otherwise
+func (b *Bundle) isClusterScoped(ref ObjectRef) bool {
+	if b.scopes == nil {
+		b.scopes = bundleScopes(b.Objects)
+		if b.Discovery != nil {
+			scopes, err := DiscoverScopes(b.Discovery)
+			if err != nil {
+				log.Warningf("failed to discover the scopes of the resources: %v", err)
+			}
+			for kind, clusterScoped := range scopes {
+				b.scopes[kind] = clusterScoped
+			}
+		}
+	}
+	kind := schema.GroupKind{Group: ref.group(), Kind: ref.Kind}
+	if clusterScoped, ok := b.scopes[kind]; ok {
+		return clusterScoped
+	}
+	return IsClusterScoped(ref.Kind)
+}
+
+// bundleScopes returns the scopes of the custom resources
+// defined by the custom resource definitions in the objects
+func bundleScopes(objects []*unstructured.Unstructured) map[schema.GroupKind]bool {
+	scopes := make(map[schema.GroupKind]bool)
+	for _, object := range objects {
+		if object.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(object.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(object.Object, "spec", "names", "kind")
+		scope, _, _ := unstructured.NestedString(object.Object, "spec", "scope")
+		if kind != "" {
+			scopes[schema.GroupKind{Group: group, Kind: kind}] = scope == "Cluster"
+		}
+	}
+	return scopes
+}
+
+// DiscoverScopes returns whether the kinds served by the API server
+// are cluster-scoped, keyed by API group and kind
+func DiscoverScopes(client ResourceDiscovery) (map[schema.GroupKind]bool, error) {
+	lists, err := client.ServerResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) || len(lists) == 0 {
+			return nil, ConvertError(err)
+		}
+		log.Warningf("partial API discovery: %v", err)
+	}
+	scopes := make(map[schema.GroupKind]bool)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			scopes[schema.GroupKind{Group: gv.Group, Kind: resource.Kind}] = !resource.Namespaced
+		}
+	}
+	return scopes, nil
+}
+
+// IsClusterScoped returns true if resources of the specified kind are not namespaced,
+// only the well-known cluster-scoped kinds are recognized, see Bundle.Discovery
+func IsClusterScoped(kind string) bool {
+	switch kind {
+	case KindNamespace, KindClusterRole, KindClusterRoleBinding, KindPodSecurityPolicy,
+		"Node", "PersistentVolume", "StorageClass", "CustomResourceDefinition",
+		"APIService", "PriorityClass", "ValidatingWebhookConfiguration", "MutatingWebhookConfiguration":
+		return true
+	}
+	return false
+}
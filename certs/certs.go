@@ -0,0 +1,282 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certs generates self-signed CA and server certificates
+// and manages them as Kubernetes TLS secrets
+package certs
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"net"
+
+	"github.com/gravitational/rigging"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/cert"
+)
+
+const (
+	// CACertKey is the secret key with the PEM-encoded CA certificate
+	CACertKey = "ca.crt"
+	// CAKeyKey is the secret key with the PEM-encoded CA private key
+	CAKeyKey = "ca.key"
+)
+
+// Config is a certificate secret configuration
+type Config struct {
+	// SecretName is the name of the TLS secret
+	SecretName string
+	// Namespace is the namespace of the secret and dependent deployments
+	Namespace string
+	// CommonName is the common name of the server certificate
+	CommonName string
+	// Organization is the optional organization of the certificates
+	Organization []string
+	// DNSNames lists additional DNS names of the server certificate
+	DNSNames []string
+	// IPs lists IP addresses of the server certificate
+	IPs []net.IP
+	// Dependents lists names of deployments restarted after certificate rotation
+	Dependents []string
+	// RetryAttempts is the number of status attempts for each restarted deployment
+	RetryAttempts int
+	// Client is k8s client
+	Client *kubernetes.Clientset
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *Config) CheckAndSetDefaults() error {
+	var errors []error
+	if c.SecretName == "" {
+		errors = append(errors, trace.BadParameter("missing parameter SecretName"))
+	}
+	if c.CommonName == "" {
+		errors = append(errors, trace.BadParameter("missing parameter CommonName"))
+	}
+	if c.Client == nil {
+		errors = append(errors, trace.BadParameter("missing parameter Client"))
+	}
+	c.Namespace = rigging.Namespace(c.Namespace)
+	return trace.NewAggregate(errors...)
+}
+
+// New returns a new instance of the certificate secret manager
+func New(config Config) (*Manager, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Manager{
+		Config: config,
+		Entry: log.WithFields(log.Fields{
+			"secret": config.Namespace + "/" + config.SecretName,
+		}),
+	}, nil
+}
+
+// Manager issues certificates and stores them in a TLS secret
+type Manager struct {
+	Config
+	*log.Entry
+}
+
+// Ensure creates the certificate secret if it does not exist yet
+func (m *Manager) Ensure(ctx context.Context) error {
+	_, err := m.Client.CoreV1().Secrets(m.Namespace).Get(m.SecretName, metav1.GetOptions{})
+	err = rigging.ConvertError(err)
+	if err == nil {
+		m.Debug("certificate secret already exists")
+		return nil
+	}
+	if !trace.IsNotFound(err) {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(m.issue(ctx))
+}
+
+// Rotate reissues the server certificate signed by the CA from the secret,
+// updates the secret and restarts dependent deployments waiting for them
+// to replace their pods. The CA is kept so that clients trusting it, e.g.
+// webhook configurations, keep working, use RotateCA to replace it too
+func (m *Manager) Rotate(ctx context.Context) error {
+	secret, err := m.Client.CoreV1().Secrets(m.Namespace).Get(m.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return rigging.ConvertError(err)
+	}
+	data, err := GenerateWithCA(m.Config, secret.Data[CACertKey], secret.Data[CAKeyKey])
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := m.upsert(ctx, data); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(m.restartDependents(ctx))
+}
+
+// RotateCA reissues the CA and server certificates, updates the secret
+// and restarts dependent deployments waiting for them to replace their pods
+func (m *Manager) RotateCA(ctx context.Context) error {
+	if err := m.issue(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(m.restartDependents(ctx))
+}
+
+// restartDependents restarts the dependent deployments one by one and waits
+// for each of them to replace its pods and for the new pods to become ready
+func (m *Manager) restartDependents(ctx context.Context) error {
+	for _, name := range m.Dependents {
+		deployment, err := m.Client.AppsV1().Deployments(m.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return rigging.ConvertError(err)
+		}
+		control, err := rigging.NewDeploymentControl(rigging.DeploymentConfig{
+			Deployment: deployment,
+			Client:     m.Client,
+		})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if err := control.Restart(ctx); err != nil {
+			return trace.Wrap(err)
+		}
+		err = rigging.WaitRestarted(ctx, m.RetryAttempts, rigging.DefaultRetryPeriod, control)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// CA returns the PEM-encoded CA certificate from the secret,
+// e.g. to be used as a webhook CA bundle
+func (m *Manager) CA(ctx context.Context) ([]byte, error) {
+	secret, err := m.Client.CoreV1().Secrets(m.Namespace).Get(m.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, rigging.ConvertError(err)
+	}
+	caCert, ok := secret.Data[CACertKey]
+	if !ok {
+		return nil, trace.NotFound("secret %v has no %v", m.SecretName, CACertKey)
+	}
+	return caCert, nil
+}
+
+// issue generates a new certificate authority and server certificate
+// and upserts the secret
+func (m *Manager) issue(ctx context.Context) error {
+	m.Info("issue certificates")
+	data, err := Generate(m.Config)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(m.upsert(ctx, data))
+}
+
+// upsert upserts the secret with the specified certificate data
+func (m *Manager) upsert(ctx context.Context, data map[string][]byte) error {
+	control, err := rigging.NewSecretControl(rigging.SecretConfig{
+		Secret: &v1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       rigging.KindSecret,
+				APIVersion: rigging.V1,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.SecretName,
+				Namespace: m.Namespace,
+			},
+			Type: v1.SecretTypeTLS,
+			Data: data,
+		},
+		Client: m.Client,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(control.Upsert(ctx))
+}
+
+// Generate generates a self-signed CA and a server certificate signed by it.
+// Returns the secret data with PEM-encoded certificates and keys
+func Generate(config Config) (map[string][]byte, error) {
+	caKey, err := cert.NewPrivateKey()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	caCert, err := cert.NewSelfSignedCACert(cert.Config{
+		CommonName:   config.CommonName + "-ca",
+		Organization: config.Organization,
+	}, caKey)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return generateServer(config, caCert, caKey)
+}
+
+// GenerateWithCA generates a server certificate signed by the specified
+// PEM-encoded CA certificate and key. Returns the secret data with
+// PEM-encoded certificates and keys
+func GenerateWithCA(config Config, caCertPEM, caKeyPEM []byte) (map[string][]byte, error) {
+	if len(caCertPEM) == 0 || len(caKeyPEM) == 0 {
+		return nil, trace.NotFound("secret %v has no CA certificate and key", config.SecretName)
+	}
+	caCerts, err := cert.ParseCertsPEM(caCertPEM)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	key, err := cert.ParsePrivateKeyPEM(caKeyPEM)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	caKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, trace.BadParameter("expected RSA CA key, got %T", key)
+	}
+	return generateServer(config, caCerts[0], caKey)
+}
+
+// generateServer generates a server certificate signed by the CA
+// and returns it with the CA as the secret data
+func generateServer(config Config, caCert *x509.Certificate, caKey *rsa.PrivateKey) (map[string][]byte, error) {
+	key, err := cert.NewPrivateKey()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	dnsNames := append([]string{config.CommonName}, config.DNSNames...)
+	serverCert, err := cert.NewSignedCert(cert.Config{
+		CommonName:   config.CommonName,
+		Organization: config.Organization,
+		AltNames: cert.AltNames{
+			DNSNames: dnsNames,
+			IPs:      config.IPs,
+		},
+		Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, key, caCert, caKey)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return map[string][]byte{
+		v1.TLSCertKey:       cert.EncodeCertPEM(serverCert),
+		v1.TLSPrivateKeyKey: cert.EncodePrivateKeyPEM(key),
+		CACertKey:           cert.EncodeCertPEM(caCert),
+		CAKeyKey:            cert.EncodePrivateKeyPEM(caKey),
+	}, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/util/cert"
+)
+
+func TestCerts(t *testing.T) { TestingT(t) }
+
+type CertsSuite struct{}
+
+var _ = Suite(&CertsSuite{})
+
+func (s *CertsSuite) TestGenerateWithCA(c *C) {
+	config := Config{SecretName: "webhook-tls", CommonName: "webhook.default.svc"}
+	data, err := Generate(config)
+	c.Assert(err, IsNil)
+
+	rotated, err := GenerateWithCA(config, data[CACertKey], data[CAKeyKey])
+	c.Assert(err, IsNil)
+	c.Assert(rotated[CACertKey], DeepEquals, data[CACertKey])
+	c.Assert(rotated[CAKeyKey], DeepEquals, data[CAKeyKey])
+	c.Assert(rotated[v1.TLSCertKey], Not(DeepEquals), data[v1.TLSCertKey])
+	c.Assert(rotated[v1.TLSPrivateKeyKey], Not(DeepEquals), data[v1.TLSPrivateKeyKey])
+
+	caCerts, err := cert.ParseCertsPEM(data[CACertKey])
+	c.Assert(err, IsNil)
+	serverCerts, err := cert.ParseCertsPEM(rotated[v1.TLSCertKey])
+	c.Assert(err, IsNil)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCerts[0])
+	_, err = serverCerts[0].Verify(x509.VerifyOptions{
+		DNSName:   config.CommonName,
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	c.Assert(err, IsNil)
+
+	_, err = GenerateWithCA(config, nil, nil)
+	c.Assert(trace.IsNotFound(err), Equals, true)
+}
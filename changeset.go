@@ -17,7 +17,8 @@ package rigging
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"reflect"
@@ -27,15 +28,12 @@ import (
 	goyaml "github.com/ghodss/yaml"
 	"github.com/gravitational/trace"
 	log "github.com/sirupsen/logrus"
-	apiextensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	serializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 )
 
@@ -44,15 +42,114 @@ type ChangesetConfig struct {
 	Client *kubernetes.Clientset
 	// Config is rest client config
 	Config *rest.Config
+	// Store persists changesets, defaults to the changeset custom resource
+	// in the cluster, Config is not required if set
+	Store ChangesetStore
+	// Notifiers are notified when a changeset completes, fails or is rolled back
+	Notifiers []Notifier
+	// ReportWriters store failure reports generated when the changeset fails
+	ReportWriters []ReportWriter
+	// Objects reads the live state of resources, e.g. for failure reports
+	// and ownership checks. Defaults to the REST API of the server with Config,
+	// or to kubectl if Config is not set
+	Objects ObjectInterface
+	// SlowOperationThreshold is the duration of a status wait after which
+	// a warning with suggested causes is logged, DefaultSlowOperationThreshold if unset
+	SlowOperationThreshold time.Duration
+	// DebugImage is an optional image of the ephemeral debug container
+	// attached to pods that are not ready when the status wait is slow
+	DebugImage string
+	// StrictReadiness, if set, makes status checks of daemon sets, deployments
+	// and stateful sets also require that no container has restarted within this window
+	StrictReadiness time.Duration
+	// SampleMetrics queries metrics-server for the CPU and memory usage of pods
+	// that are not ready during status waits and includes it in the warnings
+	// and the failure errors, e.g. to tell OOM crash loops from application bugs
+	SampleMetrics bool
+	// PendingTimeout is how long job pods may wait to be scheduled or for their
+	// images to be pulled without consuming status attempts, DefaultPendingTimeout if unset
+	PendingTimeout time.Duration
+	// Owner identifies the application managing the resources with this changeset,
+	// e.g. the bundle name. It is recorded on the upserted resources and upserting
+	// a resource recorded with another owner fails unless ForceAdopt is set
+	Owner string
+	// ForceAdopt takes over resources owned by another application
+	// or managed by another tool instead of failing
+	ForceAdopt bool
+	// FailurePolicy governs whether Upsert stops at the first resource
+	// that fails to apply, stops at the first failure by default
+	FailurePolicy FailurePolicy
+	// RecordStatusHistory records the statuses observed during status waits
+	// in the changeset operations, so that post-mortems can reconstruct
+	// how the state of the resources evolved
+	RecordStatusHistory bool
+	// HelmPolicy governs whether Upsert warns about or fails on resources
+	// managed by Helm releases, HelmIgnore if unset
+	HelmPolicy HelmPolicy
+	// AutoRecreate replaces the pods of updated deployments with the Recreate
+	// strategy if host ports or ReadWriteOnce volumes prevent a rolling update
+	AutoRecreate bool
+	// PreserveClaims rebinds the volumes of the claims of replaced stateful sets
+	// that the new stateful sets would not use to their matching new claims
+	PreserveClaims bool
+	// Snapshots, if set, takes snapshots of the volumes of the workloads
+	// before they are replaced or deleted and records them in the changeset
+	Snapshots *SnapshotConfig
+	// Verbosity defines which messages the changeset operations and the
+	// controls they use log, the standard logger decides if unset
+	Verbosity Verbosity
+	// RestartDependents restarts the daemon sets, stateful sets and deployments
+	// in the namespace of an upserted config map or secret that reference it
+	// if its data changes, and waits for them to roll out the restarted pods
+	RestartDependents bool
+	// Initiator identifies who initiates the changesets and operations, e.g. the
+	// user or service account, recorded for audit. Defaults to the identity of Config
+	Initiator string
 }
 
 func (c *ChangesetConfig) CheckAndSetDefaults() error {
 	if c.Client == nil {
 		return trace.BadParameter("missing parameter Client")
 	}
-	if c.Config == nil {
+	if c.Config == nil && c.Store == nil {
 		return trace.BadParameter("missing parameter Config")
 	}
+	if c.SlowOperationThreshold == 0 {
+		c.SlowOperationThreshold = DefaultSlowOperationThreshold
+	}
+	if c.Objects == nil && c.Config != nil {
+		objects, err := NewDynamicClient(DynamicConfig{Config: c.Config})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		c.Objects = objects
+	}
+	if c.Objects == nil {
+		c.Objects = KubectlObjects{}
+	}
+	if c.PendingTimeout == 0 {
+		c.PendingTimeout = DefaultPendingTimeout
+	}
+	if err := c.FailurePolicy.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.Snapshots != nil {
+		if err := c.Snapshots.CheckAndSetDefaults(); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if c.HelmPolicy == "" {
+		c.HelmPolicy = HelmIgnore
+	}
+	if err := c.HelmPolicy.Check(); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := c.Verbosity.Check(); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.Initiator == "" {
+		c.Initiator = restConfigInitiator(c.Config)
+	}
 	return nil
 }
 
@@ -60,26 +157,15 @@ func NewChangeset(ctx context.Context, config ChangesetConfig) (*Changeset, erro
 	if err := config.CheckAndSetDefaults(); err != nil {
 		return nil, trace.Wrap(err)
 	}
-	cfg := *config.Config
-	cfg.APIPath = "/apis"
-	if cfg.UserAgent == "" {
-		cfg.UserAgent = rest.DefaultKubernetesUserAgent()
-	}
-
-	cfg.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}
-	cfg.GroupVersion = &schema.GroupVersion{Group: ChangesetGroup, Version: ChangesetVersion}
-
-	clt, err := rest.RESTClientFor(&cfg)
-	if err != nil {
-		return nil, ConvertError(err)
-	}
-
-	apiclient, err := apiextensionsclientset.NewForConfig(&cfg)
-	if err != nil {
-		return nil, ConvertError(err)
+	cs := &Changeset{ChangesetConfig: config}
+	if cs.Store == nil {
+		store, err := NewCRDStore(config.Config)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		cs.Store = store
+		cs.APIExtensionsClient = store.APIExtensionsClient
 	}
-
-	cs := &Changeset{ChangesetConfig: config, client: clt, APIExtensionsClient: apiclient}
 	if err := cs.Init(ctx); err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -91,44 +177,144 @@ func NewChangeset(ctx context.Context, config ChangesetConfig) (*Changeset, erro
 // changes to the system
 type Changeset struct {
 	ChangesetConfig
-	client *rest.RESTClient
-	// APIExtensionsClient is a client for the extensions server
+	// APIExtensionsClient is a client for the extensions server,
+	// set if changesets are stored in the cluster
 	APIExtensionsClient *apiextensionsclientset.Clientset
 }
 
-// Upsert upserts resource in a context of a changeset
+// UpsertSource upserts the resources loaded from the source in a context of a changeset, see Upsert
+func (cs *Changeset) UpsertSource(ctx context.Context, changesetNamespace, changesetName string, source Source) error {
+	data, err := source.Load(ctx)
+	if err != nil {
+		return trace.Wrap(err, "failed to load %v", source)
+	}
+	return trace.Wrap(cs.Upsert(ctx, changesetNamespace, changesetName, data))
+}
+
+// Upsert upserts resource in a context of a changeset.
+// Metadata of all resources is validated, and resources managed by Helm releases
+// are detected according to the Helm policy, before any changes are made.
+// Resources rejected because of an exhausted resource quota are deferred
+// until the rest of the stream is applied and retried for the quota wait of the failure policy
 func (cs *Changeset) Upsert(ctx context.Context, changesetNamespace, changesetName string, data []byte) error {
+	ctx = cs.logContext(ctx)
+	if err := ValidateManifests(data); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := cs.checkHelmReleases(ctx, data); err != nil {
+		return trace.Wrap(err)
+	}
 	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), DefaultBufferSize)
 
+	var outcomes []ResourceOutcome
+	var deferred []*deferredResource
+	var failed bool
+	// record adds the outcome of the resource according to the failure policy,
+	// returns the error if the changeset has to fail immediately
+	record := func(outcome ResourceOutcome, err error) error {
+		switch {
+		case err == nil:
+		case cs.FailurePolicy.ignores(outcome.Ref.Kind):
+			contextLogger(ctx).Warningf("ignoring failure of %v: %v", outcome.Ref, err)
+			outcome.Status, outcome.Error = OutcomeIgnored, err
+		case cs.FailurePolicy.Mode == ContinueAndReport:
+			contextLogger(ctx).Warningf("failed to apply %v, continuing: %v", outcome.Ref, err)
+			outcome.Status, outcome.Error = OutcomeFailed, err
+			failed = true
+		default:
+			return err
+		}
+		outcomes = append(outcomes, outcome)
+		return nil
+	}
 	for {
 		var raw runtime.Unknown
 		err := decoder.Decode(&raw)
 		if err != nil {
 			if err == io.EOF {
-				return nil
+				break
 			}
 			return trace.Wrap(err)
 		}
+		outcome := ResourceOutcome{Ref: resourceRef(raw.Raw), Status: OutcomeApplied}
 		err = cs.upsertResource(ctx, changesetNamespace, changesetName, raw.Raw)
-		if err != nil {
+		if cs.FailurePolicy.QuotaWait > 0 && IsQuotaExceeded(err) {
+			contextLogger(ctx).Warningf("%v exceeds resource quota, deferring: %v", outcome.Ref, err)
+			deferred = append(deferred, &deferredResource{outcome: outcome, data: raw.Raw, err: err})
+			continue
+		}
+		if err := record(outcome, err); err != nil {
+			err = newChangesetError(changesetNamespace, changesetName, outcome.Ref, OpPhaseApply, err)
+			cs.failed(ctx, changesetNamespace, changesetName, err)
 			return trace.Wrap(err)
 		}
 	}
+	if len(deferred) != 0 {
+		cs.applyDeferred(ctx, changesetNamespace, changesetName, deferred)
+		for _, resource := range deferred {
+			if err := record(resource.outcome, resource.err); err != nil {
+				err = newChangesetError(changesetNamespace, changesetName, resource.outcome.Ref, OpPhaseApply, err)
+				cs.failed(ctx, changesetNamespace, changesetName, err)
+				return trace.Wrap(err)
+			}
+		}
+	}
+	if !failed {
+		return nil
+	}
+	err := &ApplyError{Outcomes: outcomes}
+	cs.failed(ctx, changesetNamespace, changesetName, err)
+	return trace.Wrap(err)
+}
+
+// operationRef returns the reference to the resource of the operation
+func operationRef(op ChangesetItem) ObjectRef {
+	if op.To != "" {
+		return resourceRef([]byte(op.To))
+	}
+	return resourceRef([]byte(op.From))
+}
+
+// resourceRef returns the reference to the resource in the manifest,
+// the reference is empty if the manifest header cannot be parsed
+func resourceRef(data []byte) ObjectRef {
+	ref, err := ManifestRef(data)
+	if err != nil {
+		return ObjectRef{}
+	}
+	return *ref
 }
 
 func (cs *Changeset) upsertResource(ctx context.Context, changesetNamespace, changesetName string, data []byte) error {
-	tr, err := cs.createOrRead(changesetNamespace, changesetName, ChangesetSpec{Status: ChangesetStatusInProgress})
+	tr, err := cs.createOrRead(ctx, changesetNamespace, changesetName, ChangesetSpec{Status: ChangesetStatusInProgress})
 	if err != nil {
 		return trace.Wrap(err)
 	}
 	if tr.Spec.Status != ChangesetStatusInProgress {
 		return trace.CompareFailed("cannot update changeset - expected status %q, got %q", ChangesetStatusInProgress, tr.Spec.Status)
 	}
+	data, err = cs.claimOwnership(ctx, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
 	var kind metav1.TypeMeta
 	err = yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), DefaultBufferSize).Decode(&kind)
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	header, err := ParseResourceHeader(bytes.NewReader(data))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	key := operationKey(data)
+	if item := completedItem(tr, key, header.Kind, header.Namespace, header.Name); item != nil {
+		// verify that the live state still matches the completed operation
+		if err := cs.status(ctx, []byte(item.To), ""); err == nil {
+			contextLogger(ctx).Infof("%v has already been applied in changeset %v, skipping", kind.Kind, tr.Name)
+			return nil
+		}
+	}
+	ctx = context.WithValue(ctx, operationKeyContext{}, key)
 	switch kind.Kind {
 	case KindJob:
 		_, err = cs.upsertJob(ctx, tr, data)
@@ -166,7 +352,8 @@ func (cs *Changeset) upsertResource(ctx context.Context, changesetNamespace, cha
 
 // Status checks all statuses for all resources updated or added in the context of a given changeset
 func (cs *Changeset) Status(ctx context.Context, changesetNamespace, changesetName string, retryAttempts int, retryPeriod time.Duration) error {
-	tr, err := cs.get(changesetNamespace, changesetName)
+	ctx = cs.logContext(ctx)
+	tr, err := cs.get(ctx, changesetNamespace, changesetName)
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -186,50 +373,291 @@ func (cs *Changeset) Status(ctx context.Context, changesetNamespace, changesetNa
 		retryPeriod = DefaultRetryPeriod
 	}
 
-	return retry(ctx, retryAttempts, retryPeriod, func() error {
-		for _, op := range tr.Spec.Items {
+	var ready, recorded bool
+	var current *ChangesetItem
+	var next int
+	// the operations from the current one on are pending if the wait is cancelled
+	ctx = withPending(ctx, func() []string {
+		if current == nil {
+			return nil
+		}
+		var pending []string
+		for _, op := range tr.Spec.Items[next:] {
+			pending = append(pending, operationRef(op).String())
+		}
+		return pending
+	})
+	debugged := make(map[types.UID]bool)
+	diagnose := func() ([]string, error) {
+		if current == nil {
+			return nil, nil
+		}
+		data := current.To
+		if data == "" {
+			data = current.From
+		}
+		causes, err := diagnoseManifest(cs.Client, []byte(data))
+		if err != nil {
+			return causes, trace.Wrap(err)
+		}
+		causes = append(causes, cs.sampleMetrics([]byte(data))...)
+		if cs.DebugImage == "" {
+			return causes, nil
+		}
+		return append(causes, cs.debugManifest(ctx, []byte(data), debugged)...), nil
+	}
+	pending := func() (bool, error) {
+		if current == nil || current.To == "" {
+			return false, nil
+		}
+		return cs.pending([]byte(current.To))
+	}
+	entry := log.WithFields(log.Fields{
+		"cs": tr.String(),
+	})
+	err = retryPending(ctx, retryAttempts, retryPeriod, cs.PendingTimeout, pending, warnSlow(entry, "status check", cs.SlowOperationThreshold, diagnose, func() error {
+		for i := range tr.Spec.Items {
+			op := &tr.Spec.Items[i]
+			current, next = op, i
 			switch op.Status {
 			case OpStatusCreated:
 				return trace.BadParameter("%v is not completed yet", tr)
 			case OpStatusCompleted, OpStatusReverted:
-				if op.To != "" {
-					err := cs.status(ctx, []byte(op.To), "")
-					if err != nil {
-						if op.Status != OpStatusReverted || !trace.IsNotFound(err) {
-							return trace.Wrap(err)
-						}
-					}
-				} else {
-					info, err := GetOperationInfo(op)
-					if err != nil {
-						return trace.Wrap(err)
-					}
-					err = cs.status(ctx, []byte(op.From), op.UID)
-					if err == nil || !trace.IsNotFound(err) {
-						return trace.CompareFailed("%v with UID %q still active: %v",
-							formatMeta(info.From.ObjectMeta), op.UID, err)
-					}
+				err := cs.operationStatus(ctx, *op)
+				if cs.RecordStatusHistory {
+					op.recordStatus(time.Now().UTC(), err)
+					recorded = true
+				}
+				if err != nil {
+					return trace.Wrap(err)
 				}
 			default:
 				return trace.BadParameter("unsupported operation status: %v", op.Status)
 			}
+			if op.ReadyTimestamp == nil {
+				now := time.Now().UTC()
+				op.ReadyTimestamp = &now
+				ready = true
+			}
+		}
+		current = nil
+		return nil
+	}))
+	if err != nil {
+		if current != nil && cs.SampleMetrics {
+			data := current.To
+			if data == "" {
+				data = current.From
+			}
+			if samples := cs.sampleMetrics([]byte(data)); len(samples) != 0 {
+				err = trace.Wrap(err, "%v, resource usage: %v", err.Error(), strings.Join(samples, "; "))
+			}
+		}
+		if current != nil {
+			err = newChangesetError(changesetNamespace, changesetName, operationRef(*current), OpPhaseWait, err)
+		}
+		if recorded {
+			// the status history is saved before the failure report is generated
+			// so that the report includes it
+			if _, errUpdate := cs.update(ctx, tr); errUpdate != nil {
+				contextLogger(ctx).Warningf("failed to save status history of %v: %v", tr, errUpdate)
+			}
+		}
+		cs.failed(ctx, changesetNamespace, changesetName, err)
+		return trace.Wrap(err)
+	}
+	if !ready && !recorded {
+		return nil
+	}
+	if ready {
+		logOperationDurations(ctx, tr)
+	}
+	_, err = cs.update(ctx, tr)
+	return trace.Wrap(err)
+}
+
+// operationStatus checks the status of the resource of a completed or reverted operation,
+// the resource deleted by the operation has to be gone
+func (cs *Changeset) operationStatus(ctx context.Context, op ChangesetItem) error {
+	if op.To != "" {
+		err := cs.status(ctx, []byte(op.To), "")
+		if err != nil && (op.Status != OpStatusReverted || !trace.IsNotFound(err)) {
+			return trace.Wrap(err)
+		}
+		return nil
+	}
+	info, err := GetOperationInfo(op)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	err = cs.status(ctx, []byte(op.From), op.UID)
+	if err == nil || !trace.IsNotFound(err) {
+		return trace.CompareFailed("%v with UID %q still active: %v",
+			FormatMeta(info.From.ObjectMeta), op.UID, err)
+	}
+	return nil
+}
+
+// needsReplace returns true if the resource in the manifest, with defaults set
+// like the API server does, differs from the live object, so the live object
+// has to be replaced
+func (cs *Changeset) needsReplace(data []byte, live runtime.Object, log *log.Entry) bool {
+	changed, err := specChanged(data, live)
+	if err != nil {
+		log.Warningf("failed to compare with the live object: %v", err)
+		return true
+	}
+	return changed
+}
+
+// sampleMetrics returns the resource usage of the pods of the workload that are not ready
+// if SampleMetrics is set, failures to query metrics are reported as samples
+func (cs *Changeset) sampleMetrics(data []byte) []string {
+	if !cs.SampleMetrics {
+		return nil
+	}
+	samples, err := sampleManifestMetrics(cs.Client, data)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to sample metrics: %v", err)}
+	}
+	return samples
+}
+
+// debugManifest attaches debug containers to the pods of the workload
+// described by the manifest that are not ready
+func (cs *Changeset) debugManifest(ctx context.Context, data []byte, seen map[types.UID]bool) []string {
+	namespace, selector, err := manifestSelector(data)
+	if err != nil || selector == nil {
+		return nil
+	}
+	pods, err := listPods(cs.Client, namespace, selector)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to list pods: %v", err)}
+	}
+	return debugPods(ctx, cs.Client, notReadyPods(pods), cs.DebugImage, seen)
+}
+
+// logContext returns the context making the operations log at the verbosity of the changeset
+func (cs *Changeset) logContext(ctx context.Context) context.Context {
+	if cs.Verbosity == "" {
+		return ctx
+	}
+	return WithVerbosity(ctx, cs.Verbosity)
+}
+
+// failed writes the failure report and notifies about the failure of the changeset
+func (cs *Changeset) failed(ctx context.Context, namespace, name string, err error) {
+	cs.writeReport(ctx, namespace, name, err)
+	notify(ctx, cs.Notifiers, Notification{
+		Event:     EventFailed,
+		Namespace: Namespace(namespace),
+		Changeset: name,
+		Error:     err.Error(),
+	})
+}
+
+// writeReport generates the failure report and stores it with all report writers,
+// failures are logged and do not affect the changeset operation
+func (cs *Changeset) writeReport(ctx context.Context, namespace, name string, cause error) {
+	if len(cs.ReportWriters) == 0 {
+		return
+	}
+	report, err := cs.Report(ctx, namespace, name, cause)
+	if err != nil {
+		contextLogger(ctx).Warningf("failed to generate failure report: %v", trace.DebugReport(err))
+		return
+	}
+	for _, writer := range cs.ReportWriters {
+		if err := writer.WriteReport(ctx, *report); err != nil {
+			contextLogger(ctx).Warningf("failed to write failure report: %v", err)
+		}
+	}
+}
+
+// operationKeyContext is the context key of the operation key
+type operationKeyContext struct{}
+
+// contextOperationKey returns the key of the operation in progress
+func contextOperationKey(ctx context.Context) string {
+	key, _ := ctx.Value(operationKeyContext{}).(string)
+	return key
+}
+
+// operationKey returns the idempotency key of the operation with the specified data
+func operationKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// completedItem returns the changeset item with the key if it is completed
+// and is the last operation on the resource, nil otherwise
+func completedItem(tr *ChangesetResource, key, kind, namespace, name string) *ChangesetItem {
+	for i := len(tr.Spec.Items) - 1; i >= 0; i-- {
+		item := &tr.Spec.Items[i]
+		info, err := GetOperationInfo(*item)
+		if err != nil {
+			continue
+		}
+		header := info.To
+		if header == nil {
+			header = info.From
+		}
+		if header.Kind != kind || Namespace(header.Namespace) != Namespace(namespace) || header.Name != name {
+			continue
+		}
+		if item.Key == key && item.Status == OpStatusCompleted {
+			return item
 		}
 		return nil
+	}
+	return nil
+}
+
+// completeItem marks the changeset item as completed
+func completeItem(item *ChangesetItem) {
+	now := time.Now().UTC()
+	item.Status = OpStatusCompleted
+	item.CompletionTimestamp = &now
+}
+
+// logOperationDurations logs how long each operation of the changeset took
+// to complete and to become ready
+func logOperationDurations(ctx context.Context, tr *ChangesetResource) {
+	log := contextLogger(ctx).WithFields(log.Fields{
+		"cs": tr.String(),
 	})
+	for _, op := range tr.Spec.Items {
+		info, err := GetOperationInfo(op)
+		if err != nil {
+			continue
+		}
+		log.Infof("%v: completed in %v, ready in %v", info, op.Duration(), op.WaitDuration())
+	}
 }
 
 // DeleteResource deletes a resources in the context of a given changeset
 func (cs *Changeset) DeleteResource(ctx context.Context, changesetNamespace, changesetName string, resourceNamespace string, resource Ref, cascade bool) error {
-	tr, err := cs.createOrRead(changesetNamespace, changesetName, ChangesetSpec{Status: ChangesetStatusInProgress})
+	ctx = cs.logContext(ctx)
+	tr, err := cs.createOrRead(ctx, changesetNamespace, changesetName, ChangesetSpec{Status: ChangesetStatusInProgress})
 	if err != nil {
 		return trace.Wrap(err)
 	}
 	if tr.Spec.Status != ChangesetStatusInProgress {
 		return trace.CompareFailed("cannot update changeset - expected status %q, got %q", ChangesetStatusInProgress, tr.Spec.Status)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs": tr.String(),
 	})
+	key := operationKey([]byte(fmt.Sprintf("delete %v/%v", resourceNamespace, resource)))
+	if item := completedItem(tr, key, resource.Kind, resourceNamespace, resource.Name); item != nil {
+		// verify that the deleted resource has not been recreated
+		err := cs.status(ctx, []byte(item.From), item.UID)
+		if trace.IsNotFound(err) {
+			log.Infof("%v/%s has already been deleted, skipping", resourceNamespace, resource)
+			return nil
+		}
+	}
+	ctx = context.WithValue(ctx, operationKeyContext{}, key)
 	log.Infof("Deleting %v/%s", resourceNamespace, resource)
 	switch resource.Kind {
 	case KindDaemonSet:
@@ -266,7 +694,7 @@ func (cs *Changeset) DeleteResource(ctx context.Context, changesetNamespace, cha
 
 // Freeze "freezes" changeset, prohibits adding or removing any changes to it
 func (cs *Changeset) Freeze(ctx context.Context, changesetNamespace, changesetName string) error {
-	tr, err := cs.get(changesetNamespace, changesetName)
+	tr, err := cs.get(ctx, changesetNamespace, changesetName)
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -280,20 +708,25 @@ func (cs *Changeset) Freeze(ctx context.Context, changesetNamespace, changesetNa
 		}
 	}
 	tr.Spec.Status = ChangesetStatusCommitted
-	_, err = cs.update(tr)
-	return trace.Wrap(err)
+	_, err = cs.update(ctx, tr)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	notify(ctx, cs.Notifiers, Notification{Event: EventCompleted, Namespace: changesetNamespace, Changeset: changesetName})
+	return nil
 }
 
 // Revert rolls back all the operations in reverse order they were applied
 func (cs *Changeset) Revert(ctx context.Context, changesetNamespace, changesetName string) error {
-	tr, err := cs.get(changesetNamespace, changesetName)
+	ctx = cs.logContext(ctx)
+	tr, err := cs.get(ctx, changesetNamespace, changesetName)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 	if tr.Spec.Status == ChangesetStatusReverted {
 		return trace.CompareFailed("changeset is already reverted")
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs": tr.String(),
 	})
 	for i := len(tr.Spec.Items) - 1; i >= 0; i-- {
@@ -305,18 +738,27 @@ func (cs *Changeset) Revert(ctx context.Context, changesetNamespace, changesetNa
 		if op.Status != OpStatusCompleted {
 			log.Infof("skipping changeset item %v, status: %v is not the expected %v", info, op.Status, OpStatusCompleted)
 		}
+		if len(op.Snapshots) != 0 && cs.Snapshots != nil && cs.Snapshots.Restore {
+			if err := cs.restoreSnapshots(ctx, *op); err != nil {
+				return trace.Wrap(newChangesetError(changesetNamespace, changesetName, operationRef(*op), OpPhaseRollback, err))
+			}
+		}
 		if err := cs.revert(ctx, op, info); err != nil {
-			return trace.Wrap(err)
+			return trace.Wrap(newChangesetError(changesetNamespace, changesetName, operationRef(*op), OpPhaseRollback, err))
 		}
 		op.Status = OpStatusReverted
-		tr, err = cs.update(tr)
+		tr, err = cs.update(ctx, tr)
 		if err != nil {
 			return trace.Wrap(err)
 		}
 	}
 	tr.Spec.Status = ChangesetStatusReverted
-	_, err = cs.update(tr)
-	return trace.Wrap(err)
+	_, err = cs.update(ctx, tr)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	notify(ctx, cs.Notifiers, Notification{Event: EventRolledBack, Namespace: changesetNamespace, Changeset: changesetName})
+	return nil
 }
 
 func (cs *Changeset) status(ctx context.Context, data []byte, uid string) error {
@@ -371,10 +813,11 @@ func (cs *Changeset) statusDaemonSet(ctx context.Context, data []byte, uid strin
 			return trace.NotFound("daemonset with UID %v not found", uid)
 		}
 	}
-	control, err := NewDSControl(DSConfig{DaemonSet: daemonset, Client: cs.Client})
+	control, err := NewDSControl(DSConfig{DaemonSet: daemonset, Client: cs.Client, StrictReadiness: cs.StrictReadiness})
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -392,10 +835,11 @@ func (cs *Changeset) statusStatefulSet(ctx context.Context, data []byte, uid str
 			return trace.NotFound("statefulset with UID %v not found", uid)
 		}
 	}
-	control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: ss, Client: cs.Client})
+	control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: ss, Client: cs.Client, StrictReadiness: cs.StrictReadiness})
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -413,13 +857,35 @@ func (cs *Changeset) statusJob(ctx context.Context, data []byte, uid string) err
 			return trace.NotFound("job with UID %v not found", uid)
 		}
 	}
-	control, err := NewJobControl(JobConfig{Job: job, Clientset: cs.Client})
+	control, err := NewJobControl(JobConfig{Job: job, Clientset: cs.Client, PendingTimeout: cs.PendingTimeout})
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
+// pending returns true if the resource in the manifest is a job
+// whose pods are waiting to be scheduled or for their images to be pulled
+func (cs *Changeset) pending(data []byte) (bool, error) {
+	header, err := ParseResourceHeader(bytes.NewReader(data))
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if header.Kind != KindJob {
+		return false, nil
+	}
+	job, err := ParseJob(bytes.NewReader(data))
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	control, err := NewJobControl(JobConfig{Job: job, Clientset: cs.Client, PendingTimeout: cs.PendingTimeout})
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	return control.Pending()
+}
+
 func (cs *Changeset) statusRC(ctx context.Context, data []byte, uid string) error {
 	rc, err := ParseReplicationController(bytes.NewReader(data))
 	if err != nil {
@@ -439,6 +905,7 @@ func (cs *Changeset) statusRC(ctx context.Context, data []byte, uid string) erro
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -456,10 +923,11 @@ func (cs *Changeset) statusDeployment(ctx context.Context, data []byte, uid stri
 			return trace.NotFound("deployment with UID %v not found", uid)
 		}
 	}
-	control, err := NewDeploymentControl(DeploymentConfig{Deployment: deployment, Client: cs.Client})
+	control, err := NewDeploymentControl(DeploymentConfig{Deployment: deployment, Client: cs.Client, StrictReadiness: cs.StrictReadiness})
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -481,6 +949,7 @@ func (cs *Changeset) statusService(ctx context.Context, data []byte, uid string)
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -502,6 +971,7 @@ func (cs *Changeset) statusSecret(ctx context.Context, data []byte, uid string)
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -523,6 +993,7 @@ func (cs *Changeset) statusConfigMap(ctx context.Context, data []byte, uid strin
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -544,6 +1015,7 @@ func (cs *Changeset) statusServiceAccount(ctx context.Context, data []byte, uid
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -565,6 +1037,7 @@ func (cs *Changeset) statusRole(ctx context.Context, data []byte, uid string) er
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -586,6 +1059,7 @@ func (cs *Changeset) statusClusterRole(ctx context.Context, data []byte, uid str
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -607,6 +1081,7 @@ func (cs *Changeset) statusRoleBinding(ctx context.Context, data []byte, uid str
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -628,6 +1103,7 @@ func (cs *Changeset) statusClusterRoleBinding(ctx context.Context, data []byte,
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -649,6 +1125,7 @@ func (cs *Changeset) statusPodSecurityPolicy(ctx context.Context, data []byte, u
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	control.Entry = contextEntry(ctx, control.Entry)
 	return control.Status()
 }
 
@@ -657,13 +1134,20 @@ func (cs *Changeset) withDeleteOp(ctx context.Context, tr *ChangesetResource, ob
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	snapshots, err := cs.snapshotVolumes(ctx, obj)
+	if err != nil {
+		return trace.Wrap(err)
+	}
 	tr.Spec.Items = append(tr.Spec.Items, ChangesetItem{
 		From:              string(data),
 		UID:               string(obj.GetUID()),
 		Status:            OpStatusCreated,
 		CreationTimestamp: time.Now().UTC(),
+		Key:               contextOperationKey(ctx),
+		Snapshots:         snapshots,
+		Initiator:         cs.initiator(ctx),
 	})
-	tr, err = cs.update(tr)
+	tr, err = cs.update(ctx, tr)
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -671,8 +1155,8 @@ func (cs *Changeset) withDeleteOp(ctx context.Context, tr *ChangesetResource, ob
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	tr.Spec.Items[len(tr.Spec.Items)-1].Status = OpStatusCompleted
-	_, err = cs.update(tr)
+	completeItem(&tr.Spec.Items[len(tr.Spec.Items)-1])
+	_, err = cs.update(ctx, tr)
 	return err
 }
 
@@ -955,7 +1439,7 @@ func (cs *Changeset) revertStatefulSet(ctx context.Context, item *ChangesetItem)
 		return trace.Wrap(err)
 	}
 
-	control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: statefulSet, Client: cs.Client})
+	control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: statefulSet, Client: cs.Client, PreserveClaims: cs.PreserveClaims})
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -1027,7 +1511,7 @@ func (cs *Changeset) revertDeployment(ctx context.Context, item *ChangesetItem)
 		return err
 	}
 	// this operation either created or updated Deployment, so we create a new version
-	control, err := NewDeploymentControl(DeploymentConfig{Reader: strings.NewReader(item.From), Client: cs.Client})
+	control, err := NewDeploymentControl(DeploymentConfig{Reader: strings.NewReader(item.From), Client: cs.Client, AutoRecreate: cs.AutoRecreate})
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -1301,6 +1785,8 @@ func (cs *Changeset) withUpsertOp(ctx context.Context, tr *ChangesetResource, ol
 		CreationTimestamp: time.Now().UTC(),
 		To:                string(to),
 		Status:            OpStatusCreated,
+		Key:               contextOperationKey(ctx),
+		Initiator:         cs.initiator(ctx),
 	}
 	if !reflect.ValueOf(old).IsNil() {
 		from, err := goyaml.Marshal(old)
@@ -1309,17 +1795,21 @@ func (cs *Changeset) withUpsertOp(ctx context.Context, tr *ChangesetResource, ol
 		}
 		item.From = string(from)
 		item.UID = string(old.GetUID())
+		item.Snapshots, err = cs.snapshotVolumes(ctx, old)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
 	}
 	tr.Spec.Items = append(tr.Spec.Items, item)
-	tr, err = cs.update(tr)
+	tr, err = cs.update(ctx, tr)
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
 	if err := fn(); err != nil {
 		return nil, trace.Wrap(err)
 	}
-	tr.Spec.Items[len(tr.Spec.Items)-1].Status = OpStatusCompleted
-	return cs.update(tr)
+	completeItem(&tr.Spec.Items[len(tr.Spec.Items)-1])
+	return cs.update(ctx, tr)
 }
 
 func (cs *Changeset) upsertJob(ctx context.Context, tr *ChangesetResource, data []byte) (*ChangesetResource, error) {
@@ -1327,11 +1817,11 @@ func (cs *Changeset) upsertJob(ctx context.Context, tr *ChangesetResource, data
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":  tr.String(),
 		"job": fmt.Sprintf("%v/%v", job.Namespace, job.Name),
 	})
-	log.Infof("upsert job %v", formatMeta(job.ObjectMeta))
+	log.Infof("upsert job %v", FormatMeta(job.ObjectMeta))
 
 	jobs := cs.Client.Batch().Jobs(job.Namespace)
 	currentJob, err := jobs.Get(job.Name, metav1.GetOptions{})
@@ -1358,11 +1848,11 @@ func (cs *Changeset) upsertDaemonSet(ctx context.Context, tr *ChangesetResource,
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs": tr.String(),
 		"ds": fmt.Sprintf("%v/%v", ds.Namespace, ds.Name),
 	})
-	log.Infof("upsert daemon set %v", formatMeta(ds.ObjectMeta))
+	log.Infof("upsert daemon set %v", FormatMeta(ds.ObjectMeta))
 	daemons := cs.Client.AppsV1().DaemonSets(ds.Namespace)
 	currentDS, err := daemons.Get(ds.Name, metav1.GetOptions{})
 	err = ConvertError(err)
@@ -1373,6 +1863,13 @@ func (cs *Changeset) upsertDaemonSet(ctx context.Context, tr *ChangesetResource,
 		log.Debug("existing daemonset not found")
 		currentDS = nil
 	}
+	if currentDS != nil && !cs.needsReplace(data, currentDS, log) {
+		log.Infof("daemon set %v is up to date", FormatMeta(ds.ObjectMeta))
+		return tr, nil
+	}
+	if err := setLastApplied(&ds.ObjectMeta, data); err != nil {
+		return nil, trace.Wrap(err)
+	}
 	control, err := NewDSControl(DSConfig{DaemonSet: ds, Client: cs.Client})
 	if err != nil {
 		return nil, trace.Wrap(err)
@@ -1387,11 +1884,11 @@ func (cs *Changeset) upsertStatefulSet(ctx context.Context, tr *ChangesetResourc
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":          tr.String(),
 		"statefulset": fmt.Sprintf("%v/%v", ss.Namespace, ss.Name),
 	})
-	log.Infof("upsert statefulset %v", formatMeta(ss.ObjectMeta))
+	log.Infof("upsert statefulset %v", FormatMeta(ss.ObjectMeta))
 	statefulsets := cs.Client.AppsV1().StatefulSets(ss.Namespace)
 	currentSS, err := statefulsets.Get(ss.Name, metav1.GetOptions{})
 	err = ConvertError(err)
@@ -1402,7 +1899,14 @@ func (cs *Changeset) upsertStatefulSet(ctx context.Context, tr *ChangesetResourc
 		log.Debug("existing statefulset not found")
 		currentSS = nil
 	}
-	control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: ss, Client: cs.Client})
+	if currentSS != nil && !cs.needsReplace(data, currentSS, log) {
+		log.Infof("statefulset %v is up to date", FormatMeta(ss.ObjectMeta))
+		return tr, nil
+	}
+	if err := setLastApplied(&ss.ObjectMeta, data); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: ss, Client: cs.Client, PreserveClaims: cs.PreserveClaims})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -1417,11 +1921,11 @@ func (cs *Changeset) upsertRC(ctx context.Context, tr *ChangesetResource, data [
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs": tr.String(),
 		"rc": fmt.Sprintf("%v/%v", rc.Namespace, rc.Name),
 	})
-	log.Infof("upsert replication controller %v", formatMeta(rc.ObjectMeta))
+	log.Infof("upsert replication controller %v", FormatMeta(rc.ObjectMeta))
 	rcs := cs.Client.Core().ReplicationControllers(rc.Namespace)
 	currentRC, err := rcs.Get(rc.Name, metav1.GetOptions{})
 	err = ConvertError(err)
@@ -1446,11 +1950,11 @@ func (cs *Changeset) upsertDeployment(ctx context.Context, tr *ChangesetResource
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":         tr.String(),
 		"deployment": fmt.Sprintf("%v/%v", deployment.Namespace, deployment.Name),
 	})
-	log.Infof("upsert deployment %v", formatMeta(deployment.ObjectMeta))
+	log.Infof("upsert deployment %v", FormatMeta(deployment.ObjectMeta))
 	deployments := cs.Client.Extensions().Deployments(deployment.Namespace)
 	currentDeployment, err := deployments.Get(deployment.Name, metav1.GetOptions{})
 	err = ConvertError(err)
@@ -1461,7 +1965,7 @@ func (cs *Changeset) upsertDeployment(ctx context.Context, tr *ChangesetResource
 		log.Debug("existing deployment not found")
 		currentDeployment = nil
 	}
-	control, err := NewDeploymentControl(DeploymentConfig{Deployment: deployment, Client: cs.Client})
+	control, err := NewDeploymentControl(DeploymentConfig{Deployment: deployment, Client: cs.Client, AutoRecreate: cs.AutoRecreate})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -1475,11 +1979,11 @@ func (cs *Changeset) upsertService(ctx context.Context, tr *ChangesetResource, d
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":      tr.String(),
 		"service": fmt.Sprintf("%v/%v", service.Namespace, service.Name),
 	})
-	log.Infof("upsert service %v", formatMeta(service.ObjectMeta))
+	log.Infof("upsert service %v", FormatMeta(service.ObjectMeta))
 	services := cs.Client.Core().Services(service.Namespace)
 	currentService, err := services.Get(service.Name, metav1.GetOptions{})
 	err = ConvertError(err)
@@ -1504,9 +2008,9 @@ func (cs *Changeset) upsertServiceAccount(ctx context.Context, tr *ChangesetReso
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":              tr.String(),
-		"service_account": formatMeta(account.ObjectMeta),
+		"service_account": FormatMeta(account.ObjectMeta),
 	})
 	accounts := cs.Client.Core().ServiceAccounts(account.Namespace)
 	currentAccount, err := accounts.Get(account.Name, metav1.GetOptions{})
@@ -1532,9 +2036,9 @@ func (cs *Changeset) upsertRole(ctx context.Context, tr *ChangesetResource, data
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":   tr.String(),
-		"role": formatMeta(role.ObjectMeta),
+		"role": FormatMeta(role.ObjectMeta),
 	})
 	roles := cs.Client.RbacV1().Roles(role.Namespace)
 	currentRole, err := roles.Get(role.Name, metav1.GetOptions{})
@@ -1560,9 +2064,9 @@ func (cs *Changeset) upsertClusterRole(ctx context.Context, tr *ChangesetResourc
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":           tr.String(),
-		"cluster_role": formatMeta(role.ObjectMeta),
+		"cluster_role": FormatMeta(role.ObjectMeta),
 	})
 	roles := cs.Client.RbacV1().ClusterRoles()
 	currentRole, err := roles.Get(role.Name, metav1.GetOptions{})
@@ -1588,9 +2092,9 @@ func (cs *Changeset) upsertRoleBinding(ctx context.Context, tr *ChangesetResourc
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":           tr.String(),
-		"role_binding": formatMeta(binding.ObjectMeta),
+		"role_binding": FormatMeta(binding.ObjectMeta),
 	})
 	bindings := cs.Client.RbacV1().RoleBindings(binding.Namespace)
 	currentBinding, err := bindings.Get(binding.Name, metav1.GetOptions{})
@@ -1616,9 +2120,9 @@ func (cs *Changeset) upsertClusterRoleBinding(ctx context.Context, tr *Changeset
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs": tr.String(),
-		"cluster_role_binding": formatMeta(binding.ObjectMeta),
+		"cluster_role_binding": FormatMeta(binding.ObjectMeta),
 	})
 	bindings := cs.Client.RbacV1().ClusterRoleBindings()
 	currentBinding, err := bindings.Get(binding.Name, metav1.GetOptions{})
@@ -1644,9 +2148,9 @@ func (cs *Changeset) upsertPodSecurityPolicy(ctx context.Context, tr *ChangesetR
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs": tr.String(),
-		"pod_security_policy": formatMeta(policy.ObjectMeta),
+		"pod_security_policy": FormatMeta(policy.ObjectMeta),
 	})
 	policies := cs.Client.ExtensionsV1beta1().PodSecurityPolicies()
 	currentPolicy, err := policies.Get(policy.Name, metav1.GetOptions{})
@@ -1672,11 +2176,11 @@ func (cs *Changeset) upsertConfigMap(ctx context.Context, tr *ChangesetResource,
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":        tr.String(),
 		"configMap": fmt.Sprintf("%v/%v", configMap.Namespace, configMap.Name),
 	})
-	log.Infof("upsert configmap %v", formatMeta(configMap.ObjectMeta))
+	log.Infof("upsert configmap %v", FormatMeta(configMap.ObjectMeta))
 	configMaps := cs.Client.Core().ConfigMaps(configMap.Namespace)
 	currentConfigMap, err := configMaps.Get(configMap.Name, metav1.GetOptions{})
 	err = ConvertError(err)
@@ -1687,7 +2191,14 @@ func (cs *Changeset) upsertConfigMap(ctx context.Context, tr *ChangesetResource,
 		log.Debug("existing configmap not found")
 		currentConfigMap = nil
 	}
-	control, err := NewConfigMapControl(ConfigMapConfig{ConfigMap: configMap, Client: cs.Client})
+	var dependents []Restarter
+	if cs.RestartDependents {
+		dependents, err = findDependents(cs.Client, Namespace(configMap.Namespace), KindConfigMap, configMap.Name)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	control, err := NewConfigMapControl(ConfigMapConfig{ConfigMap: configMap, Client: cs.Client, Dependents: dependents})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -1701,11 +2212,11 @@ func (cs *Changeset) upsertSecret(ctx context.Context, tr *ChangesetResource, da
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
-	log := log.WithFields(log.Fields{
+	log := contextLogger(ctx).WithFields(log.Fields{
 		"cs":     tr.String(),
 		"secret": fmt.Sprintf("%v/%v", secret.Namespace, secret.Name),
 	})
-	log.Infof("upsert secret %v", formatMeta(secret.ObjectMeta))
+	log.Infof("upsert secret %v", FormatMeta(secret.ObjectMeta))
 	secrets := cs.Client.Core().Secrets(secret.Namespace)
 	currentSecret, err := secrets.Get(secret.Name, metav1.GetOptions{})
 	err = ConvertError(err)
@@ -1716,7 +2227,14 @@ func (cs *Changeset) upsertSecret(ctx context.Context, tr *ChangesetResource, da
 		log.Debug("existing secret not found")
 		currentSecret = nil
 	}
-	control, err := NewSecretControl(SecretConfig{Secret: secret, Client: cs.Client})
+	var dependents []Restarter
+	if cs.RestartDependents {
+		dependents, err = findDependents(cs.Client, Namespace(secret.Namespace), KindSecret, secret.Name)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	control, err := NewSecretControl(SecretConfig{Secret: secret, Client: cs.Client, Dependents: dependents})
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -1725,47 +2243,17 @@ func (cs *Changeset) upsertSecret(ctx context.Context, tr *ChangesetResource, da
 	})
 }
 
+// Init initializes the changeset store
 func (cs *Changeset) Init(ctx context.Context) error {
-	log.Debug("changeset init")
-
-	// kubernetes 1.8 or newer
-	crd := &apiextensions.CustomResourceDefinition{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: ChangesetResourceName,
-		},
-		Spec: apiextensions.CustomResourceDefinitionSpec{
-			Group:   ChangesetGroup,
-			Version: ChangesetVersion,
-			Scope:   ChangesetScope,
-			Names: apiextensions.CustomResourceDefinitionNames{
-				Kind:     KindChangeset,
-				Plural:   ChangesetPlural,
-				Singular: ChangesetSingular,
-			},
-		},
-	}
-
-	_, err := cs.APIExtensionsClient.ApiextensionsV1beta1().CustomResourceDefinitions().Create(crd)
-	err = ConvertError(err)
-	if err != nil {
-		if !trace.IsAlreadyExists(err) {
-			return trace.Wrap(err)
-		}
-	}
-	// wait for the controller to init by trying to list stuff
-	return retry(ctx, 30, time.Second, func() error {
-		_, err := cs.list(DefaultNamespace)
-		return err
-	})
-
+	return trace.Wrap(cs.Store.Init(ctx))
 }
 
 func (cs *Changeset) Get(ctx context.Context, namespace, name string) (*ChangesetResource, error) {
-	return cs.get(namespace, name)
+	return cs.get(ctx, namespace, name)
 }
 
 func (cs *Changeset) List(ctx context.Context, namespace string) (*ChangesetList, error) {
-	return cs.list(namespace)
+	return cs.list(ctx, namespace)
 }
 
 // Create creates a new one given the name and namespace.
@@ -1779,133 +2267,69 @@ func (cs *Changeset) Create(ctx context.Context, namespace, name string) (*Chang
 			APIVersion: ChangesetAPIVersion,
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: cs.initiatorAnnotations(ctx),
 		},
 		Spec: ChangesetSpec{
 			Status: ChangesetStatusInProgress,
 		},
 	}
-	return cs.create(res)
+	return cs.create(ctx, res)
 }
 
-func (cs *Changeset) upsert(tr *ChangesetResource) (*ChangesetResource, error) {
-	out, err := cs.create(tr)
+func (cs *Changeset) upsert(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
+	out, err := cs.create(ctx, tr)
 	if err == nil {
 		return out, nil
 	}
 	if !trace.IsAlreadyExists(err) {
 		return nil, err
 	}
-	return cs.update(tr)
+	return cs.update(ctx, tr)
 }
 
-func (cs *Changeset) create(tr *ChangesetResource) (*ChangesetResource, error) {
+func (cs *Changeset) create(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
 	tr.Namespace = Namespace(tr.Namespace)
-	data, err := json.Marshal(tr)
-	if err != nil {
-		return nil, trace.Wrap(err)
-	}
-	var raw runtime.Unknown
-	err = cs.client.Post().
-		SubResource("namespaces", tr.Namespace, ChangesetCollection).
-		Body(data).
-		Do().
-		Into(&raw)
-	if err != nil {
-		return nil, ConvertError(err)
-	}
-	var result ChangesetResource
-	if err := json.Unmarshal(raw.Raw, &result); err != nil {
-		return nil, trace.Wrap(err)
-	}
-	return &result, nil
+	return cs.Store.Create(ctx, tr)
 }
 
-func (cs *Changeset) get(namespace, name string) (*ChangesetResource, error) {
-	var raw runtime.Unknown
-	err := cs.client.Get().
-		SubResource("namespaces", namespace, ChangesetCollection, name).
-		Do().
-		Into(&raw)
-	if err != nil {
-		return nil, ConvertError(err)
-	}
-	var result ChangesetResource
-	if err := json.Unmarshal(raw.Raw, &result); err != nil {
-		return nil, trace.Wrap(err)
-	}
-	return &result, nil
+func (cs *Changeset) get(ctx context.Context, namespace, name string) (*ChangesetResource, error) {
+	return cs.Store.Get(ctx, namespace, name)
 }
 
-func (cs *Changeset) createOrRead(namespace, name string, spec ChangesetSpec) (*ChangesetResource, error) {
+func (cs *Changeset) createOrRead(ctx context.Context, namespace, name string, spec ChangesetSpec) (*ChangesetResource, error) {
 	res := &ChangesetResource{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       KindChangeset,
 			APIVersion: ChangesetAPIVersion,
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: cs.initiatorAnnotations(ctx),
 		},
 		Spec: spec,
 	}
-	out, err := cs.create(res)
+	out, err := cs.create(ctx, res)
 	if err == nil {
 		return out, nil
 	}
 	if !trace.IsAlreadyExists(err) {
 		return nil, trace.Wrap(err)
 	}
-	return cs.get(res.Namespace, res.Name)
+	return cs.get(ctx, res.Namespace, res.Name)
 }
 
 func (cs *Changeset) Delete(ctx context.Context, namespace, name string) error {
-	var raw runtime.Unknown
-	err := cs.client.Delete().
-		SubResource("namespaces", namespace, ChangesetCollection, name).
-		Do().
-		Into(&raw)
-	if err != nil {
-		return ConvertError(err)
-	}
-	return nil
+	return cs.Store.Delete(ctx, namespace, name)
 }
 
-func (cs *Changeset) update(tr *ChangesetResource) (*ChangesetResource, error) {
+func (cs *Changeset) update(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
 	tr.Namespace = Namespace(tr.Namespace)
-	data, err := json.Marshal(tr)
-	if err != nil {
-		return nil, trace.Wrap(err)
-	}
-	var raw runtime.Unknown
-	err = cs.client.Put().
-		SubResource("namespaces", tr.Namespace, ChangesetCollection, tr.Name).
-		Body(data).
-		Do().
-		Into(&raw)
-	if err != nil {
-		return nil, ConvertError(err)
-	}
-	var result ChangesetResource
-	if err := json.Unmarshal(raw.Raw, &result); err != nil {
-		return nil, trace.Wrap(err)
-	}
-	return &result, nil
+	return cs.Store.Update(ctx, tr)
 }
 
-func (cs *Changeset) list(namespace string) (*ChangesetList, error) {
-	var raw runtime.Unknown
-	err := cs.client.Get().
-		SubResource("namespaces", namespace, ChangesetCollection).
-		Do().
-		Into(&raw)
-	if err != nil {
-		return nil, ConvertError(err)
-	}
-	var result ChangesetList
-	if err := json.Unmarshal(raw.Raw, &result); err != nil {
-		return nil, trace.Wrap(err)
-	}
-	return &result, nil
+func (cs *Changeset) list(ctx context.Context, namespace string) (*ChangesetList, error) {
+	return cs.Store.List(ctx, namespace)
 }
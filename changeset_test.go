@@ -0,0 +1,80 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type ChangesetSuite struct{}
+
+var _ = Suite(&ChangesetSuite{})
+
+func (s *ChangesetSuite) TestCompletedItem(c *C) {
+	v1 := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: kube-system\ndata:\n  a: b\n"
+	v2 := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: kube-system\ndata:\n  a: c\n"
+	tr := &ChangesetResource{Spec: ChangesetSpec{Items: []ChangesetItem{
+		{To: v1, Key: operationKey([]byte(v1)), Status: OpStatusCompleted},
+	}}}
+	item := completedItem(tr, operationKey([]byte(v1)), KindConfigMap, "kube-system", "config")
+	c.Assert(item, NotNil)
+	c.Assert(completedItem(tr, operationKey([]byte(v2)), KindConfigMap, "kube-system", "config"), IsNil)
+
+	// a later operation on the same resource invalidates the earlier one
+	tr.Spec.Items = append(tr.Spec.Items, ChangesetItem{To: v2, Key: operationKey([]byte(v2)), Status: OpStatusCompleted})
+	c.Assert(completedItem(tr, operationKey([]byte(v1)), KindConfigMap, "kube-system", "config"), IsNil)
+	c.Assert(completedItem(tr, operationKey([]byte(v2)), KindConfigMap, "kube-system", "config"), NotNil)
+
+	// incomplete operations are not skipped
+	tr.Spec.Items[1].Status = OpStatusCreated
+	c.Assert(completedItem(tr, operationKey([]byte(v2)), KindConfigMap, "kube-system", "config"), IsNil)
+}
+
+func (s *ChangesetSuite) TestLastItems(c *C) {
+	config := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: kube-system\ndata:\n  a: %v\n"
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: config\n  namespace: kube-system\n"
+	items := []ChangesetItem{
+		{To: fmt.Sprintf(config, "b")},
+		{To: secret},
+		{From: fmt.Sprintf(config, "b"), To: fmt.Sprintf(config, "c")},
+	}
+	c.Assert(lastItems(items), DeepEquals, []ChangesetItem{items[2], items[1]})
+}
+
+func (s *ChangesetSuite) TestRecordStatus(c *C) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	var item ChangesetItem
+	item.recordStatus(start, trace.CompareFailed("0 of 1 pods ready"))
+	item.recordStatus(start.Add(time.Second), trace.CompareFailed("0 of 1 pods ready"))
+	item.recordStatus(start.Add(2*time.Second), trace.CompareFailed("pod crashed"))
+	item.recordStatus(start.Add(3*time.Second), nil)
+	c.Assert(item.StatusHistory, DeepEquals, []StatusSnapshot{
+		{Time: start, Count: 2, Message: "0 of 1 pods ready"},
+		{Time: start.Add(2 * time.Second), Count: 1, Message: "pod crashed"},
+		{Time: start.Add(3 * time.Second), Count: 1, Ready: true},
+	})
+
+	for i := 0; i < MaxStatusSnapshots; i++ {
+		item.recordStatus(start.Add(time.Duration(4+i)*time.Second), fmt.Errorf("attempt %v", i))
+	}
+	c.Assert(item.StatusHistory, HasLen, MaxStatusSnapshots)
+	c.Assert(item.StatusHistory[0].Message, Equals, "attempt 0")
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ObjectLister lists resources of any kind
+type ObjectLister interface {
+	// List returns the resources of the kind in the namespace,
+	// resources in all namespaces if the namespace is empty
+	List(ctx context.Context, gvk schema.GroupVersionKind, namespace string) ([]unstructured.Unstructured, error)
+}
+
+// Common kinds of children of workloads
+var (
+	// ReplicaSetKind is the kind of the replica sets of deployments
+	ReplicaSetKind = kinds[KindReplicaSet]
+	// PodKind is the kind of the pods of workloads
+	PodKind = kinds[KindPod]
+	// JobKind is the kind of the jobs of cron jobs
+	JobKind = kinds[KindJob]
+)
+
+// CollectChildren returns the resources of the specified kinds that have
+// an owner reference to the owner, e.g. replica sets of a deployment, pods of
+// a replica set or jobs of a cron job. Children of a namespaced owner are looked up
+// in its namespace, children of a cluster-scoped owner in all namespaces
+func CollectChildren(ctx context.Context, objects ObjectLister, owner metav1.Object, gvks ...schema.GroupVersionKind) ([]unstructured.Unstructured, error) {
+	if owner.GetUID() == "" {
+		return nil, trace.BadParameter("owner %v has no UID", owner.GetName())
+	}
+	var children []unstructured.Unstructured
+	for _, gvk := range gvks {
+		items, err := objects.List(ctx, gvk, owner.GetNamespace())
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, item := range items {
+			if isOwnedBy(&item, owner) {
+				children = append(children, item)
+			}
+		}
+	}
+	return children, nil
+}
+
+// CollectDescendants returns the resources owned by the owner directly or
+// through the intermediate owners of the kinds listed in path, e.g. pods
+// of a deployment with path ReplicaSetKind, PodKind
+func CollectDescendants(ctx context.Context, objects ObjectLister, owner metav1.Object, path ...schema.GroupVersionKind) ([]unstructured.Unstructured, error) {
+	if len(path) == 0 {
+		return nil, trace.BadParameter("missing kinds of descendants")
+	}
+	children, err := CollectChildren(ctx, objects, owner, path[0])
+	if err != nil || len(path) == 1 {
+		return children, trace.Wrap(err)
+	}
+	var descendants []unstructured.Unstructured
+	for i := range children {
+		items, err := CollectDescendants(ctx, objects, &children[i], path[1:]...)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		descendants = append(descendants, items...)
+	}
+	return descendants, nil
+}
+
+// isOwnedBy returns true if the object has an owner reference to the owner
+func isOwnedBy(object metav1.Object, owner metav1.Object) bool {
+	for _, ref := range object.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
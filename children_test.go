@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"sort"
+
+	. "gopkg.in/check.v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type ChildrenSuite struct{}
+
+var _ = Suite(&ChildrenSuite{})
+
+func (s *ChildrenSuite) TestCollectDescendants(c *C) {
+	ctx := context.TODO()
+	objects := memObjects{}
+	add := func(gvk schema.GroupVersionKind, name string, owner *unstructured.Unstructured) *unstructured.Unstructured {
+		object := &unstructured.Unstructured{}
+		object.SetGroupVersionKind(gvk)
+		object.SetNamespace("default")
+		object.SetName(name)
+		object.SetUID(types.UID(name + "-uid"))
+		if owner != nil {
+			object.SetOwnerReferences([]metav1.OwnerReference{{
+				APIVersion: owner.GetAPIVersion(),
+				Kind:       owner.GetKind(),
+				Name:       owner.GetName(),
+				UID:        owner.GetUID(),
+			}})
+		}
+		c.Assert(objects.Apply(ctx, object), IsNil)
+		return object
+	}
+	deployment := add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: KindDeployment}, "app", nil)
+	current := add(ReplicaSetKind, "app-1", deployment)
+	previous := add(ReplicaSetKind, "app-2", deployment)
+	add(ReplicaSetKind, "other", nil)
+	add(PodKind, "app-1-a", current)
+	add(PodKind, "app-1-b", current)
+	add(PodKind, "app-2-a", previous)
+	add(PodKind, "other-a", nil)
+
+	children, err := CollectChildren(ctx, objects, deployment, ReplicaSetKind, PodKind)
+	c.Assert(err, IsNil)
+	c.Assert(objectNames(children), DeepEquals, []string{"app-1", "app-2"})
+
+	pods, err := CollectDescendants(ctx, objects, deployment, ReplicaSetKind, PodKind)
+	c.Assert(err, IsNil)
+	c.Assert(objectNames(pods), DeepEquals, []string{"app-1-a", "app-1-b", "app-2-a"})
+}
+
+func objectNames(objects []unstructured.Unstructured) []string {
+	var out []string
+	for _, object := range objects {
+		out = append(out, object.GetName())
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,229 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// claimMove rebinds the volume of an existing claim to a new claim
+type claimMove struct {
+	// From is the existing claim
+	From v1.PersistentVolumeClaim
+	// To is the new claim
+	To v1.PersistentVolumeClaim
+}
+
+// String returns a human readable description of the move
+func (m claimMove) String() string {
+	return fmt.Sprintf("claim %v/%v to %v (volume %v)", m.From.Namespace, m.From.Name, m.To.Name, m.From.Spec.VolumeName)
+}
+
+// statefulSetClaims returns the claims the stateful set controller
+// creates for the pods of the stateful set from its volume claim templates
+func statefulSetClaims(statefulSet *appsv1.StatefulSet) []v1.PersistentVolumeClaim {
+	var replicas int32 = 1
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	var claims []v1.PersistentVolumeClaim
+	for ordinal := 0; ordinal < int(replicas); ordinal++ {
+		for _, template := range statefulSet.Spec.VolumeClaimTemplates {
+			claim := *template.DeepCopy()
+			claim.Name = fmt.Sprintf("%v-%v-%v", template.Name, statefulSet.Name, ordinal)
+			claim.Namespace = statefulSet.Namespace
+			claim.Labels = make(map[string]string)
+			for key, val := range template.Labels {
+				claim.Labels[key] = val
+			}
+			if statefulSet.Spec.Selector != nil {
+				for key, val := range statefulSet.Spec.Selector.MatchLabels {
+					claim.Labels[key] = val
+				}
+			}
+			claims = append(claims, claim)
+		}
+	}
+	return claims
+}
+
+// matchClaims matches the desired claims that do not exist yet with the bound
+// existing claims that are not desired, so that the volumes of the existing
+// claims are rebound instead of being orphaned. Claims match if they have
+// the same ordinal and the existing claim has all labels of the desired claim
+func matchClaims(existing, desired []v1.PersistentVolumeClaim) []claimMove {
+	wanted := make(map[string]bool, len(desired))
+	for _, claim := range desired {
+		wanted[claim.Name] = true
+	}
+	exists := make(map[string]bool, len(existing))
+	var candidates []v1.PersistentVolumeClaim
+	for _, claim := range existing {
+		exists[claim.Name] = true
+		if !wanted[claim.Name] && claim.Spec.VolumeName != "" {
+			candidates = append(candidates, claim)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+	used := make(map[string]bool)
+	var moves []claimMove
+	for _, claim := range desired {
+		if exists[claim.Name] {
+			continue
+		}
+		ordinal, ok := claimOrdinal(claim.Name)
+		if !ok {
+			continue
+		}
+		selector := labels.SelectorFromSet(claim.Labels)
+		for _, candidate := range candidates {
+			candidateOrdinal, ok := claimOrdinal(candidate.Name)
+			if used[candidate.Name] || !ok || candidateOrdinal != ordinal || !selector.Matches(labels.Set(candidate.Labels)) {
+				continue
+			}
+			used[candidate.Name] = true
+			moves = append(moves, claimMove{From: candidate, To: claim})
+			break
+		}
+	}
+	return moves
+}
+
+// claimOrdinal returns the ordinal of the pod of the stateful set claim
+func claimOrdinal(name string) (int, bool) {
+	index := strings.LastIndex(name, "-")
+	if index == -1 {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(name[index+1:])
+	if err != nil {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// rebindClaim binds the volume of the existing claim to the new claim.
+// The volume is retained while the existing claim is deleted and the new
+// claim is created bound to it, the reclaim policy is restored afterwards
+func rebindClaim(ctx context.Context, client *kubernetes.Clientset, move claimMove, entry *log.Entry) error {
+	entry.Infof("rebinding %v", move)
+	volumes := client.CoreV1().PersistentVolumes()
+	claims := client.CoreV1().PersistentVolumeClaims(move.From.Namespace)
+	volume, err := volumes.Get(move.From.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return ConvertError(err)
+	}
+	reclaimPolicy := volume.Spec.PersistentVolumeReclaimPolicy
+	if reclaimPolicy != v1.PersistentVolumeReclaimRetain {
+		err = updateVolume(ctx, volumes, volume.Name, func(volume *v1.PersistentVolume) {
+			volume.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+		})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if err := ConvertError(claims.Delete(move.From.Name, nil)); err != nil && !trace.IsNotFound(err) {
+		return trace.Wrap(err)
+	}
+	err = waitForObjectDeletion(func() error {
+		_, err := claims.Get(move.From.Name, metav1.GetOptions{})
+		return ConvertError(err)
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	// the volume is released once the claim is deleted, reserve it for the new claim
+	err = updateVolume(ctx, volumes, volume.Name, func(volume *v1.PersistentVolume) {
+		volume.Spec.ClaimRef = &v1.ObjectReference{Namespace: move.To.Namespace, Name: move.To.Name}
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	claim := move.To.DeepCopy()
+	claim.Spec.VolumeName = volume.Name
+	if _, err := claims.Create(claim); err != nil {
+		return ConvertError(err)
+	}
+	if reclaimPolicy == v1.PersistentVolumeReclaimRetain {
+		return nil
+	}
+	return updateVolume(ctx, volumes, volume.Name, func(volume *v1.PersistentVolume) {
+		volume.Spec.PersistentVolumeReclaimPolicy = reclaimPolicy
+	})
+}
+
+// updateVolume applies the mutation to the live volume, see RetryOnConflict
+func updateVolume(ctx context.Context, volumes corev1.PersistentVolumeInterface, name string, mutate func(*v1.PersistentVolume)) error {
+	return RetryOnConflict(ctx, func() error {
+		volume, err := volumes.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		mutate(volume)
+		_, err = volumes.Update(volume)
+		return ConvertError(err)
+	})
+}
+
+// listClaims returns the claims labeled with the selector labels of the stateful set
+func (c *StatefulSetControl) listClaims(statefulSet *appsv1.StatefulSet) ([]v1.PersistentVolumeClaim, error) {
+	if statefulSet.Spec.Selector == nil || len(statefulSet.Spec.Selector.MatchLabels) == 0 {
+		return nil, nil
+	}
+	list, err := c.Client.CoreV1().PersistentVolumeClaims(statefulSet.Namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(statefulSet.Spec.Selector.MatchLabels).String(),
+	})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	return list.Items, nil
+}
+
+// rebindClaims rebinds the volumes of the existing claims that the stateful set
+// no longer uses to the matching claims of the stateful set that do not exist yet
+func (c *StatefulSetControl) rebindClaims(ctx context.Context, existing []v1.PersistentVolumeClaim) error {
+	claims := c.Client.CoreV1().PersistentVolumeClaims(c.StatefulSet.Namespace)
+	for _, move := range matchClaims(existing, statefulSetClaims(c.StatefulSet)) {
+		_, err := claims.Get(move.To.Name, metav1.GetOptions{})
+		if err == nil {
+			c.Infof("claim %v already exists, not rebinding %v", move.To.Name, move)
+			continue
+		}
+		if err := ConvertError(err); !trace.IsNotFound(err) {
+			return trace.Wrap(err)
+		}
+		if err := rebindClaim(ctx, c.Client, move, c.Entry); err != nil {
+			return trace.Wrap(err, "failed to rebind %v: %v", move, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	. "gopkg.in/check.v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type ClaimsSuite struct{}
+
+var _ = Suite(&ClaimsSuite{})
+
+func (s *ClaimsSuite) TestMatchClaims(c *C) {
+	replicas := int32(2)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "storage", Labels: map[string]string{"tier": "data"}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "wal"}},
+			},
+		},
+	}
+	desired := statefulSetClaims(statefulSet)
+	var names []string
+	for _, claim := range desired {
+		names = append(names, claim.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"storage-db-0", "wal-db-0", "storage-db-1", "wal-db-1"})
+	c.Assert(desired[0].Labels, DeepEquals, map[string]string{"app": "db", "tier": "data"})
+	c.Assert(desired[1].Namespace, Equals, "apps")
+
+	claim := func(name, volume string, labels map[string]string) v1.PersistentVolumeClaim {
+		return v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps", Labels: labels},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: volume},
+		}
+	}
+	existing := []v1.PersistentVolumeClaim{
+		claim("data-db-1", "pv-1", map[string]string{"app": "db", "tier": "data"}),
+		claim("data-db-0", "pv-0", map[string]string{"app": "db", "tier": "data"}),
+		claim("wal-db-0", "pv-2", map[string]string{"app": "db"}),
+		claim("logs-db-1", "", map[string]string{"app": "db"}),
+	}
+	var moves []string
+	for _, move := range matchClaims(existing, desired) {
+		moves = append(moves, move.String())
+	}
+	c.Assert(moves, DeepEquals, []string{
+		"claim apps/data-db-0 to storage-db-0 (volume pv-0)",
+		"claim apps/data-db-1 to storage-db-1 (volume pv-1)",
+	})
+}
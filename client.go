@@ -0,0 +1,363 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// DefaultCredentialReloadPeriod is how often the token file is re-read
+	DefaultCredentialReloadPeriod = time.Minute
+	// serviceAccountTokenFile is the token of the pod service account
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// ClientConfig is a Kubernetes client configuration
+type ClientConfig struct {
+	// KubeConfig is the path to kubeconfig, used if the process does not run in a pod
+	KubeConfig string
+	// TokenFile is a file with the bearer token that is reloaded when it rotates,
+	// defaults to the service account token if the process runs in a pod
+	TokenFile string
+	// ReloadPeriod is how often the token file is re-read,
+	// DefaultCredentialReloadPeriod if unset
+	ReloadPeriod time.Duration
+	// Proxy is the URL of the HTTPS proxy the API server is reached through,
+	// e.g. http://bastion:3128, overrides the proxy of the process environment
+	Proxy string
+	// CAFile is the path to the CA bundle used to verify the API server,
+	// overrides the CA of the configuration
+	CAFile string
+	// TLSServerName is the server name used to verify the API server
+	// certificate if it differs from the host the server is reached at
+	TLSServerName string
+}
+
+// CheckAndSetDefaults checks and sets default values
+func (c *ClientConfig) CheckAndSetDefaults() error {
+	if c.ReloadPeriod == 0 {
+		c.ReloadPeriod = DefaultCredentialReloadPeriod
+	}
+	return nil
+}
+
+// NewClient returns a Kubernetes client and its configuration. The in-cluster
+// configuration is used if the process runs in a pod, the kubeconfig otherwise.
+//
+// Credentials that rotate are reloaded, so that long running operations
+// do not fail mid-upgrade: exec credential plugins from the kubeconfig are
+// re-run by the client when their credentials expire, the token file is
+// re-read periodically and the client certificate and key files are re-read
+// when they change
+func NewClient(config ClientConfig) (*kubernetes.Clientset, *rest.Config, error) {
+	restConfig, err := NewRESTConfig(config)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	return client, restConfig, nil
+}
+
+// NewRESTConfig returns the client configuration that reloads rotated credentials,
+// see NewClient for details
+func NewRESTConfig(config ClientConfig) (*rest.Config, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	restConfig, err := rest.InClusterConfig()
+	if err == nil {
+		if config.TokenFile == "" {
+			config.TokenFile = serviceAccountTokenFile
+		}
+	} else {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.KubeConfig)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	if config.CAFile != "" {
+		restConfig.CAFile = config.CAFile
+		restConfig.CAData = nil
+	}
+	if config.TLSServerName != "" {
+		restConfig.ServerName = config.TLSServerName
+	}
+	restConfig, err = WithCredentialReload(restConfig, config.TokenFile, config.ReloadPeriod)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if config.Proxy == "" {
+		return restConfig, nil
+	}
+	return WithProxy(restConfig, config.Proxy)
+}
+
+// WithProxy returns a copy of the configuration that reaches the API server
+// through the proxy instead of the proxy of the process environment.
+// Configurations with a custom transport other than the one set by
+// WithCredentialReload are not supported
+func WithProxy(config *rest.Config, proxy string) (*rest.Config, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, trace.BadParameter("invalid proxy URL %q: %v", proxy, err)
+	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, trace.BadParameter("invalid proxy URL %q, expected scheme://host:port", proxy)
+	}
+	out := rest.CopyConfig(config)
+	if out.Transport == nil {
+		transport, err := newTLSTransport(out.TLSClientConfig)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		out.Transport = transport
+		out.TLSClientConfig = rest.TLSClientConfig{}
+	}
+	transport, ok := out.Transport.(*http.Transport)
+	if !ok {
+		return nil, trace.BadParameter("proxy is not supported with custom transport %T", out.Transport)
+	}
+	out.Transport = utilnet.SetTransportDefaults(&http.Transport{
+		TLSClientConfig: transport.TLSClientConfig,
+		Proxy:           http.ProxyURL(proxyURL),
+	})
+	return out, nil
+}
+
+// WithCredentialReload returns a copy of the configuration that re-reads
+// the bearer token from the token file every period and the client certificate
+// from the certificate files when they change. Configurations with exec
+// credential plugins or auth providers are returned as is, as the client
+// refreshes these credentials itself
+func WithCredentialReload(config *rest.Config, tokenFile string, period time.Duration) (*rest.Config, error) {
+	out := rest.CopyConfig(config)
+	if out.ExecProvider != nil || out.AuthProvider != nil {
+		return out, nil
+	}
+	if tokenFile != "" {
+		tokens, err := newFileTokenSource(tokenFile, period)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		out.BearerToken = ""
+		out.WrapTransport = wrapTransport(out.WrapTransport, func(rt http.RoundTripper) http.RoundTripper {
+			return &tokenRoundTripper{tokens: tokens, next: rt}
+		})
+	}
+	if out.CertFile != "" && out.KeyFile != "" && out.Transport == nil {
+		transport, err := newTLSTransport(out.TLSClientConfig)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		out.Transport = transport
+		// client-go does not allow TLS options with a custom transport
+		out.TLSClientConfig = rest.TLSClientConfig{}
+	}
+	return out, nil
+}
+
+// wrapTransport chains the transport wrappers, the existing wrapper is applied first
+func wrapTransport(existing, fn func(http.RoundTripper) http.RoundTripper) func(http.RoundTripper) http.RoundTripper {
+	if existing == nil {
+		return fn
+	}
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return fn(existing(rt))
+	}
+}
+
+// fileTokenSource returns the token read from the file,
+// the file is re-read after the period
+type fileTokenSource struct {
+	path   string
+	period time.Duration
+	sync.Mutex
+	token  string
+	readAt time.Time
+}
+
+func newFileTokenSource(path string, period time.Duration) (*fileTokenSource, error) {
+	source := &fileTokenSource{path: path, period: period}
+	if _, err := source.Token(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return source, nil
+}
+
+// Token returns the current token, if the file can not be re-read
+// the previous token is returned
+func (s *fileTokenSource) Token() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.token != "" && time.Since(s.readAt) < s.period {
+		return s.token, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err == nil && len(strings.TrimSpace(string(data))) == 0 {
+		err = trace.BadParameter("token file %v is empty", s.path)
+	}
+	if err != nil {
+		if s.token != "" {
+			log.Warningf("failed to reload token from %v, using the previous token: %v", s.path, err)
+			return s.token, nil
+		}
+		return "", trace.ConvertSystemError(err)
+	}
+	s.token = strings.TrimSpace(string(data))
+	s.readAt = time.Now()
+	return s.token, nil
+}
+
+// tokenRoundTripper sets the bearer token from the token source
+type tokenRoundTripper struct {
+	tokens *fileTokenSource
+	next   http.RoundTripper
+}
+
+// RoundTrip sets the authorization header unless the request already has one
+func (rt *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return rt.next.RoundTrip(req)
+	}
+	token, err := rt.tokens.Token()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req = utilnet.CloneRequest(req)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+	return rt.next.RoundTrip(req)
+}
+
+// WrappedRoundTripper returns the wrapped round tripper
+func (rt *tokenRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.next
+}
+
+// newTLSTransport returns a transport with the TLS configuration,
+// the client certificate files are re-read when they change
+func newTLSTransport(config rest.TLSClientConfig) (*http.Transport, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.Insecure,
+		ServerName:         config.ServerName,
+	}
+	switch {
+	case len(config.CertData) != 0 && len(config.KeyData) != 0:
+		cert, err := tls.X509KeyPair(config.CertData, config.KeyData)
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to parse client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case config.CertFile != "" && config.KeyFile != "":
+		certs := &certReloader{certFile: config.CertFile, keyFile: config.KeyFile}
+		if _, err := certs.GetClientCertificate(nil); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		tlsConfig.GetClientCertificate = certs.GetClientCertificate
+	}
+	caData := config.CAData
+	if len(caData) == 0 && config.CAFile != "" {
+		var err error
+		caData, err = ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+	}
+	if len(caData) != 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, trace.BadParameter("failed to parse CA certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return utilnet.SetTransportDefaults(&http.Transport{
+		TLSClientConfig: tlsConfig,
+	}), nil
+}
+
+// certReloader loads the client certificate from the files,
+// the files are re-read when their modification time changes
+type certReloader struct {
+	certFile string
+	keyFile  string
+	sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetClientCertificate returns the current client certificate, if the files
+// can not be re-read the previous certificate is returned
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.Lock()
+	defer r.Unlock()
+	modTime, err := r.lastModified()
+	if err == nil && r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	var cert tls.Certificate
+	if err == nil {
+		cert, err = tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	}
+	if err != nil {
+		if r.cert != nil {
+			log.Warningf("failed to reload client certificate from %v, using the previous certificate: %v", r.certFile, err)
+			return r.cert, nil
+		}
+		return nil, trace.Wrap(err, "failed to load client certificate from %v", r.certFile)
+	}
+	if r.cert != nil {
+		log.Infof("reloaded client certificate from %v", r.certFile)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return r.cert, nil
+}
+
+// lastModified returns the latest modification time of the certificate and key files
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, trace.ConvertSystemError(err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
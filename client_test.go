@@ -0,0 +1,84 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/client-go/rest"
+)
+
+type ClientSuite struct{}
+
+var _ = Suite(&ClientSuite{})
+
+func (s *ClientSuite) TestTokenReload(c *C) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	path := filepath.Join(c.MkDir(), "token")
+	c.Assert(ioutil.WriteFile(path, []byte("first\n"), 0600), IsNil)
+	config, err := WithCredentialReload(&rest.Config{Host: server.URL, BearerToken: "static"}, path, 0)
+	c.Assert(err, IsNil)
+	c.Assert(config.BearerToken, Equals, "")
+	transport, err := rest.TransportFor(config)
+	c.Assert(err, IsNil)
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(authorization, Equals, "Bearer first")
+
+	c.Assert(ioutil.WriteFile(path, []byte("second"), 0600), IsNil)
+	resp, err = client.Get(server.URL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(authorization, Equals, "Bearer second")
+}
+
+func (s *ClientSuite) TestProxy(c *C) {
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.URL.Host
+	}))
+	defer proxy.Close()
+
+	config, err := WithProxy(&rest.Config{Host: "http://apiserver.example.com:8080"}, proxy.URL)
+	c.Assert(err, IsNil)
+	transport, err := rest.TransportFor(config)
+	c.Assert(err, IsNil)
+	resp, err := (&http.Client{Transport: transport}).Get("http://apiserver.example.com:8080/version")
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(host, Equals, "apiserver.example.com:8080")
+
+	_, err = WithProxy(&rest.Config{}, "bastion:3128")
+	c.Assert(err, NotNil)
+}
+
+func (s *ClientSuite) TestProxyEnv(c *C) {
+	env := proxyEnv([]string{"HOME=/root", "https_proxy=http://old:3128", "HTTP_PROXY=http://old:3128", "NO_PROXY=.local"}, "http://bastion:3128")
+	c.Assert(env, DeepEquals, []string{"HOME=/root", "NO_PROXY=.local", "HTTPS_PROXY=http://bastion:3128", "https_proxy=http://bastion:3128"})
+}
@@ -0,0 +1,181 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	goyaml "github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+)
+
+// ComposeBundles composes the bundles into a single bundle applied in the
+// context of one changeset. Bundles are ordered so that each is applied after
+// the bundles it requires, otherwise the order of the arguments is preserved.
+// The waves of every bundle keep their order and gates and are prefixed with
+// the bundle name, as are hooks: pre-apply hooks of all bundles run before the
+// first wave and post-apply hooks after the last one.
+//
+// Resources shared between bundles, e.g. namespaces or custom resource
+// definitions, are applied once with the first bundle defining them,
+// waves left without resources are dropped. Shared resources with
+// different definitions are rejected
+func ComposeBundles(archives ...*BundleArchive) (*BundleArchive, error) {
+	if len(archives) == 0 {
+		return nil, trace.BadParameter("missing parameter archives")
+	}
+	ordered, err := orderBundles(archives)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var names, versions []string
+	for _, archive := range ordered {
+		names = append(names, archive.Metadata.Name)
+		versions = append(versions, archive.Metadata.Version)
+	}
+	metadata := BundleMetadata{
+		Name:    strings.Join(names, "-"),
+		Version: strings.Join(versions, "-"),
+	}
+	files := make(map[string][]byte)
+	defined := make(map[string]sharedResource)
+	for _, archive := range ordered {
+		bundle := archive.Metadata.Name
+		for i, wave := range archive.Waves() {
+			data, err := dedupResources(defined, bundle, archive.Manifests(wave))
+			if err != nil {
+				return nil, trace.Wrap(err, "wave %q of bundle %q", wave.Name, bundle)
+			}
+			if len(data) == 0 {
+				continue
+			}
+			file := fmt.Sprintf("%v/wave-%v.yaml", bundle, i)
+			files[file] = data
+			metadata.Waves = append(metadata.Waves, Wave{
+				Name:  bundle + "/" + wave.Name,
+				Files: []string{file},
+				Gate:  wave.Gate,
+			})
+		}
+		for _, hook := range archive.Metadata.Hooks {
+			file := bundle + "/" + hook.File
+			files[file] = archive.Files[hook.File]
+			metadata.Hooks = append(metadata.Hooks, Hook{
+				Name:  bundle + "/" + hook.Name,
+				Phase: hook.Phase,
+				File:  file,
+			})
+		}
+	}
+	if len(metadata.Waves) == 0 {
+		return nil, trace.BadParameter("bundles %v have no resources", strings.Join(names, ", "))
+	}
+	data, err := goyaml.Marshal(metadata)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	files[BundleMetadataFile] = data
+	return newBundleArchive(files)
+}
+
+// orderBundles returns the bundles ordered by their requirements,
+// bundles with satisfied requirements keep the original order
+func orderBundles(archives []*BundleArchive) ([]*BundleArchive, error) {
+	byName := make(map[string]*BundleArchive, len(archives))
+	for _, archive := range archives {
+		name := archive.Metadata.Name
+		if _, ok := byName[name]; ok {
+			return nil, trace.BadParameter("bundle %q is specified more than once", name)
+		}
+		byName[name] = archive
+	}
+	for _, archive := range archives {
+		for _, name := range archive.Metadata.Requires {
+			if _, ok := byName[name]; !ok {
+				return nil, trace.NotFound("bundle %q requires bundle %q which is not specified", archive.Metadata.Name, name)
+			}
+		}
+	}
+	placed := make(map[string]bool, len(archives))
+	ordered := make([]*BundleArchive, 0, len(archives))
+	for len(ordered) < len(archives) {
+		next := -1
+		for i, archive := range archives {
+			if !placed[archive.Metadata.Name] && requirementsPlaced(archive, placed) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var pending []string
+			for _, archive := range archives {
+				if !placed[archive.Metadata.Name] {
+					pending = append(pending, archive.Metadata.Name)
+				}
+			}
+			return nil, trace.BadParameter("bundles %v have circular requirements", strings.Join(pending, ", "))
+		}
+		placed[archives[next].Metadata.Name] = true
+		ordered = append(ordered, archives[next])
+	}
+	return ordered, nil
+}
+
+func requirementsPlaced(archive *BundleArchive, placed map[string]bool) bool {
+	for _, name := range archive.Metadata.Requires {
+		if !placed[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedResource is a resource defined by one of the composed bundles
+type sharedResource struct {
+	// bundle is the name of the first bundle defining the resource
+	bundle string
+	// object is the resource definition
+	object map[string]interface{}
+}
+
+// dedupResources returns the manifest stream without the resources defined
+// by previous bundles and records the resources defined by the bundle
+func dedupResources(defined map[string]sharedResource, bundle string, data []byte) ([]byte, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var unique Bundle
+	for _, object := range objects {
+		ref := unique.Ref(object)
+		shared, ok := defined[ref.key()]
+		if !ok {
+			defined[ref.key()] = sharedResource{bundle: bundle, object: object.Object}
+			unique.Objects = append(unique.Objects, object)
+			continue
+		}
+		if !reflect.DeepEqual(shared.object, object.Object) {
+			return nil, trace.BadParameter("%v is defined differently by bundles %q and %q", ref, shared.bundle, bundle)
+		}
+	}
+	if len(unique.Objects) == 0 {
+		return nil, nil
+	}
+	return EncodeObjects(unique.Objects)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type ComposeSuite struct{}
+
+var _ = Suite(&ComposeSuite{})
+
+func (s *ComposeSuite) TestComposeBundles(c *C) {
+	platform := newTestArchive(c, map[string]string{
+		BundleMetadataFile: "name: platform\nversion: 1.0.0\n",
+		"namespace.yaml":   "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n",
+		"crd.yaml":         "apiVersion: apiextensions.k8s.io/v1beta1\nkind: CustomResourceDefinition\nmetadata:\n  name: backups.example.com\n",
+	})
+	app := newTestArchive(c, map[string]string{
+		BundleMetadataFile: `
+name: app
+version: 2.0.0
+requires: [platform]
+waves:
+- name: shared
+  files: [namespace.yaml]
+- name: main
+  files: [app.yaml]
+hooks:
+- name: migrate
+  phase: pre-apply
+  file: migrate.yaml
+`,
+		"namespace.yaml": "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: apps\n",
+		"app.yaml":       "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n  namespace: apps\n",
+		"migrate.yaml":   "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\n",
+	})
+
+	composed, err := ComposeBundles(app, platform)
+	c.Assert(err, IsNil)
+	c.Assert(composed.Metadata.Name, Equals, "platform-app")
+	c.Assert(composed.Metadata.Version, Equals, "1.0.0-2.0.0")
+	waves := composed.Waves()
+	c.Assert(waves, HasLen, 2)
+	c.Assert(waves[0].Name, Equals, "platform/platform")
+	c.Assert(waves[1].Name, Equals, "app/main")
+	objects, err := DecodeObjects(composed.Manifests(waves[0]))
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 2)
+	objects, err = DecodeObjects(composed.Manifests(waves[1]))
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 1)
+	c.Assert(objects[0].GetName(), Equals, "app")
+	c.Assert(composed.Metadata.Hooks, DeepEquals, []Hook{{Name: "app/migrate", Phase: HookPreApply, File: "app/migrate.yaml"}})
+}
+
+func (s *ComposeSuite) TestComposeErrors(c *C) {
+	a := newTestArchive(c, map[string]string{
+		BundleMetadataFile: "name: a\nversion: 1.0.0\nrequires: [b]\n",
+		"cm.yaml":          "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: shared\ndata:\n  key: a\n",
+	})
+	b := newTestArchive(c, map[string]string{
+		BundleMetadataFile: "name: b\nversion: 1.0.0\nrequires: [a]\n",
+		"cm.yaml":          "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: shared\ndata:\n  key: b\n",
+	})
+	conflicting := newTestArchive(c, map[string]string{
+		BundleMetadataFile: "name: c\nversion: 1.0.0\n",
+		"cm.yaml":          "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: shared\ndata:\n  key: c\n",
+	})
+
+	_, err := ComposeBundles(a)
+	c.Assert(trace.IsNotFound(err), Equals, true, Commentf("%v", err))
+	_, err = ComposeBundles(a, b)
+	c.Assert(trace.IsBadParameter(err), Equals, true, Commentf("%v", err))
+	_, err = ComposeBundles(conflicting, conflicting)
+	c.Assert(trace.IsBadParameter(err), Equals, true, Commentf("%v", err))
+
+	a.Metadata.Requires = nil
+	_, err = ComposeBundles(a, conflicting)
+	c.Assert(trace.IsBadParameter(err), Equals, true, Commentf("%v", err))
+}
+
+func newTestArchive(c *C, files map[string]string) *BundleArchive {
+	data := make(map[string][]byte, len(files))
+	for name, contents := range files {
+		data[name] = []byte(contents)
+	}
+	archive, err := newBundleArchive(data)
+	c.Assert(err, IsNil)
+	return archive
+}
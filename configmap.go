@@ -17,6 +17,7 @@ package rigging
 import (
 	"context"
 	"io"
+	"reflect"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/gravitational/trace"
@@ -45,7 +46,7 @@ func NewConfigMapControl(config ConfigMapConfig) (*ConfigMapControl, error) {
 		ConfigMapConfig: config,
 		configMap:       *rc,
 		Entry: log.WithFields(log.Fields{
-			"configMap": formatMeta(rc.ObjectMeta),
+			"configMap": FormatMeta(rc.ObjectMeta),
 		}),
 	}, nil
 }
@@ -58,6 +59,10 @@ type ConfigMapConfig struct {
 	ConfigMap *v1.ConfigMap
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// Dependents lists workloads restarted when the configmap data changes
+	Dependents []Restarter
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *ConfigMapConfig) CheckAndSetDefaults() error {
@@ -79,20 +84,22 @@ type ConfigMapControl struct {
 }
 
 func (c *ConfigMapControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.configMap.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.configMap.ObjectMeta))
 
 	err := c.Client.Core().ConfigMaps(c.configMap.Namespace).Delete(c.configMap.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *ConfigMapControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.configMap.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.configMap.ObjectMeta))
 
 	configMaps := c.Client.Core().ConfigMaps(c.configMap.Namespace)
 	c.configMap.UID = ""
 	c.configMap.SelfLink = ""
 	c.configMap.ResourceVersion = ""
-	_, err := configMaps.Get(c.configMap.Name, metav1.GetOptions{})
+	current, err := configMaps.Get(c.configMap.Name, metav1.GetOptions{})
 	err = ConvertError(err)
 	if err != nil {
 		if !trace.IsNotFound(err) {
@@ -102,7 +109,20 @@ func (c *ConfigMapControl) Upsert(ctx context.Context) error {
 		return ConvertError(err)
 	}
 	_, err = configMaps.Update(&c.configMap)
-	return ConvertError(err)
+	if err != nil {
+		return ConvertError(err)
+	}
+	if len(c.Dependents) == 0 || !configMapDataChanged(current, &c.configMap) {
+		return nil
+	}
+	c.Infof("configmap data changed, restarting %v dependent workloads", len(c.Dependents))
+	return trace.Wrap(restartDependents(ctx, c.Dependents))
+}
+
+// UpsertAndWait upserts the config map and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *ConfigMapControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
 }
 
 func (c *ConfigMapControl) Status() error {
@@ -110,3 +130,17 @@ func (c *ConfigMapControl) Status() error {
 	_, err := configMaps.Get(c.configMap.Name, metav1.GetOptions{})
 	return ConvertError(err)
 }
+
+// configMapDataChanged returns true if the data of the config maps differs,
+// empty data is the same as no data as the API server does not return empty maps
+func configMapDataChanged(a, b *v1.ConfigMap) bool {
+	if len(a.Data) != 0 || len(b.Data) != 0 {
+		if !reflect.DeepEqual(a.Data, b.Data) {
+			return true
+		}
+	}
+	if len(a.BinaryData) != 0 || len(b.BinaryData) != 0 {
+		return !reflect.DeepEqual(a.BinaryData, b.BinaryData)
+	}
+	return false
+}
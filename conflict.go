@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// DefaultConflictRetryAttempts is the number of attempts of updates
+	// failing because the object has been modified since it was read
+	DefaultConflictRetryAttempts = 5
+	// DefaultConflictRetryPeriod is the initial delay between the attempts,
+	// doubled after each attempt
+	DefaultConflictRetryPeriod = 100 * time.Millisecond
+)
+
+// IsConflict returns true if the error indicates that the update failed
+// because the object has been modified since it was read
+func IsConflict(err error) bool {
+	return errors.IsConflict(trace.Unwrap(err))
+}
+
+// RetryOnConflict runs update until it does not fail with a conflict, at most
+// DefaultConflictRetryAttempts times with exponential backoff. Every run of update
+// should read the live object, apply the mutation to it and update it,
+// so that the mutation is reapplied to the object as modified concurrently,
+// e.g. by a controller updating its status
+func RetryOnConflict(ctx context.Context, update func() error) error {
+	period := DefaultConflictRetryPeriod
+	for attempt := 1; ; attempt++ {
+		err := update()
+		if err == nil || !IsConflict(err) || attempt >= DefaultConflictRetryAttempts {
+			return trace.Wrap(err)
+		}
+		contextLogger(ctx).Infof("update conflict, attempt %v, retry in %v: %v", attempt, period, err)
+		select {
+		case <-ctx.Done():
+			return trace.Wrap(err)
+		case <-time.After(period):
+		}
+		period *= 2
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type ConflictSuite struct{}
+
+var _ = Suite(&ConflictSuite{})
+
+func (s *ConflictSuite) TestRetryOnConflict(c *C) {
+	conflict := errors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "app", trace.Errorf("the object has been modified"))
+	c.Assert(IsConflict(trace.Wrap(ConvertError(conflict))), Equals, true)
+	c.Assert(IsConflict(errors.NewAlreadyExists(schema.GroupResource{Resource: "pods"}, "app")), Equals, false)
+
+	var attempts int
+	err := RetryOnConflict(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return ConvertError(conflict)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(attempts, Equals, 3)
+
+	attempts = 0
+	err = RetryOnConflict(context.Background(), func() error {
+		attempts++
+		return trace.NotFound("deployment app not found")
+	})
+	c.Assert(trace.IsNotFound(err), Equals, true)
+	c.Assert(attempts, Equals, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	err = RetryOnConflict(ctx, func() error {
+		attempts++
+		return ConvertError(conflict)
+	})
+	c.Assert(IsConflict(err), Equals, true)
+	c.Assert(attempts, Equals, 1)
+}
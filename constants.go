@@ -27,12 +27,19 @@ const (
 	KindServiceAccount        = "ServiceAccount"
 	KindSecret                = "Secret"
 	KindJob                   = "Job"
+	KindCronJob               = "CronJob"
+	KindPod                   = "Pod"
+	KindNamespace             = "Namespace"
 	KindRole                  = "Role"
 	KindClusterRole           = "ClusterRole"
 	KindRoleBinding           = "RoleBinding"
 	KindClusterRoleBinding    = "ClusterRoleBinding"
 	KindPodSecurityPolicy     = "PodSecurityPolicy"
 	ControllerUIDLabel        = "controller-uid"
+	// JobNameLabel is set by the job controller on the pods of a job
+	JobNameLabel = "job-name"
+	// RestartedAtAnnotation is set on pod templates to trigger a rolling restart
+	RestartedAtAnnotation     = "rigging.gravitational.io/restartedAt"
 	OpStatusCreated           = "created"
 	OpStatusCompleted         = "completed"
 	OpStatusReverted          = "reverted"
@@ -60,8 +67,9 @@ func Namespace(namespace string) string {
 	return namespace
 }
 
-// formatMeta formats this meta as text
-func formatMeta(meta metav1.ObjectMeta) string {
+// FormatMeta formats the object metadata as namespace/name for logs and errors,
+// the namespace is omitted for cluster-scoped objects
+func FormatMeta(meta metav1.ObjectMeta) string {
 	if meta.Namespace == "" {
 		return meta.Name
 	}
@@ -0,0 +1,176 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CronJobInstantiateAnnotation marks jobs created from a cron job manually
+	CronJobInstantiateAnnotation = "cronjob.kubernetes.io/instantiate"
+	// maxNameLength is the maximum length of a job name
+	maxNameLength = 63
+)
+
+// NewCronJobControl returns new instance of CronJob controller
+func NewCronJobControl(config CronJobConfig) (*CronJobControl, error) {
+	err := config.checkAndSetDefaults()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &CronJobControl{
+		CronJobConfig: config,
+		Entry: log.WithFields(log.Fields{
+			"cronjob": FormatMeta(config.CronJob.ObjectMeta),
+		}),
+	}, nil
+}
+
+// CronJobControl is a cron job controller
+type CronJobControl struct {
+	CronJobConfig
+	*log.Entry
+}
+
+// CronJobConfig is a CronJob control configuration
+type CronJobConfig struct {
+	CronJob *batchv1beta1.CronJob
+	*kubernetes.Clientset
+}
+
+func (c *CronJobConfig) checkAndSetDefaults() error {
+	if c.CronJob == nil {
+		return trace.BadParameter("missing parameter CronJob")
+	}
+	if c.Clientset == nil {
+		return trace.BadParameter("missing parameter Clientset")
+	}
+	c.CronJob.Kind = KindCronJob
+	if c.CronJob.APIVersion == "" {
+		c.CronJob.APIVersion = batchv1beta1.SchemeGroupVersion.String()
+	}
+	return nil
+}
+
+// TriggerNow creates a job from the job template of the cron job
+// owned by the cron job and waits for the job to complete,
+// like kubectl create job --from=cronjob/name
+func (c *CronJobControl) TriggerNow(ctx context.Context) (*batchv1.Job, error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	cronJob, err := c.getCronJob(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	job := jobFromCronJob(cronJob, time.Now())
+	c.Infof("trigger %v", FormatMeta(job.ObjectMeta))
+	job, err = c.BatchV1().Jobs(job.Namespace).Create(job)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	control, err := NewJobControl(JobConfig{Job: job, Clientset: c.Clientset})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := PollStatus(ctx, 0, 0, control); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return job, nil
+}
+
+// getCronJob returns the cron job from batch/v1, or from batch/v1beta1
+// on clusters that do not serve cron jobs in batch/v1 yet.
+// The vendored clientset has no batch/v1 cron jobs, so batch/v1 is queried
+// directly and decoded into the v1beta1 type, which has the same fields
+func (c *CronJobControl) getCronJob(ctx context.Context) (*batchv1beta1.CronJob, error) {
+	data, err := c.BatchV1().RESTClient().Get().
+		AbsPath(path.Join("/apis", batchv1.SchemeGroupVersion.String(), "namespaces", c.CronJob.Namespace, "cronjobs", c.CronJob.Name)).
+		Context(ctx).
+		Do().
+		Raw()
+	if err == nil {
+		var cronJob batchv1beta1.CronJob
+		if err := json.Unmarshal(data, &cronJob); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		cronJob.APIVersion = batchv1.SchemeGroupVersion.String()
+		return &cronJob, nil
+	}
+	err = ConvertError(err)
+	if !trace.IsNotFound(err) {
+		return nil, trace.Wrap(err)
+	}
+	cronJob, err := c.BatchV1beta1().CronJobs(c.CronJob.Namespace).Get(c.CronJob.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	cronJob.APIVersion = batchv1beta1.SchemeGroupVersion.String()
+	return cronJob, nil
+}
+
+// jobFromCronJob returns a job created from the job template of the cron job
+func jobFromCronJob(cronJob *batchv1beta1.CronJob, now time.Time) *batchv1.Job {
+	template := cronJob.Spec.JobTemplate
+	annotations := map[string]string{CronJobInstantiateAnnotation: "manual"}
+	for key, val := range template.Annotations {
+		annotations[key] = val
+	}
+	labels := make(map[string]string)
+	for key, val := range template.Labels {
+		labels[key] = val
+	}
+	suffix := fmt.Sprintf("-manual-%v", now.Unix())
+	name := cronJob.Name
+	if len(name)+len(suffix) > maxNameLength {
+		name = name[:maxNameLength-len(suffix)]
+	}
+	controller := true
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       KindJob,
+			APIVersion: BatchAPIVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name + suffix,
+			Namespace:   cronJob.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: cronJob.APIVersion,
+					Kind:       KindCronJob,
+					Name:       cronJob.Name,
+					UID:        cronJob.UID,
+					Controller: &controller,
+				},
+			},
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ephemeralContainersMinorVersion is the first Kubernetes 1.x minor version
+	// with ephemeral containers enabled by default and updated
+	// with a pod patch of the ephemeralcontainers subresource
+	ephemeralContainersMinorVersion = 23
+	// DebugContainerPrefix is the name prefix of the attached debug containers
+	DebugContainerPrefix = "debugger-"
+)
+
+// ephemeralContainer is an ephemeral container of the pod spec, the type
+// is not available in the client API version used by rigging
+type ephemeralContainer struct {
+	Name                     string   `json:"name"`
+	Image                    string   `json:"image"`
+	Command                  []string `json:"command,omitempty"`
+	Stdin                    bool     `json:"stdin"`
+	TTY                      bool     `json:"tty"`
+	TargetContainerName      string   `json:"targetContainerName,omitempty"`
+	ImagePullPolicy          string   `json:"imagePullPolicy"`
+	TerminationMessagePolicy string   `json:"terminationMessagePolicy"`
+}
+
+// AttachDebugContainer adds an ephemeral debug container with the image and command
+// to the pod, sharing the process namespace of the pod's first container.
+// Returns the name of the debug container, use kubectl attach to connect to it.
+// Returns NotImplemented if the API server does not support ephemeral containers
+func AttachDebugContainer(ctx context.Context, client *kubernetes.Clientset, pod v1.Pod, image string, command []string) (string, error) {
+	if image == "" {
+		return "", trace.BadParameter("missing parameter image")
+	}
+	if err := checkServerMinorVersion(client, ephemeralContainersMinorVersion, "ephemeral containers"); err != nil {
+		return "", trace.Wrap(err)
+	}
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", trace.Wrap(err)
+	}
+	container := ephemeralContainer{
+		Name:                     DebugContainerPrefix + hex.EncodeToString(suffix),
+		Image:                    image,
+		Command:                  command,
+		Stdin:                    true,
+		TTY:                      true,
+		ImagePullPolicy:          string(v1.PullIfNotPresent),
+		TerminationMessagePolicy: string(v1.TerminationMessageReadFile),
+	}
+	if len(pod.Spec.Containers) != 0 {
+		container.TargetContainerName = pod.Spec.Containers[0].Name
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ephemeralContainers": []ephemeralContainer{container},
+		},
+	})
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	log.Infof("attach debug container %v to pod %v", container.Name, FormatMeta(pod.ObjectMeta))
+	err = client.CoreV1().RESTClient().Patch(types.StrategicMergePatchType).
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("ephemeralcontainers").
+		Body(patch).
+		Do().
+		Error()
+	if err != nil {
+		return "", ConvertErrorWithContext(err, "failed to attach debug container to pod %v", FormatMeta(pod.ObjectMeta))
+	}
+	return container.Name, nil
+}
+
+// debugPods attaches a debug container to each of the pods not seen before,
+// returns the instructions to connect to the debug containers
+func debugPods(ctx context.Context, client *kubernetes.Clientset, pods []v1.Pod, image string, seen map[types.UID]bool) []string {
+	var causes []string
+	for _, pod := range pods {
+		if seen[pod.UID] {
+			continue
+		}
+		seen[pod.UID] = true
+		name, err := AttachDebugContainer(ctx, client, pod, image, nil)
+		if err != nil {
+			causes = append(causes, fmt.Sprintf("pod %v: failed to attach debug container: %v",
+				FormatMeta(pod.ObjectMeta), err))
+			continue
+		}
+		causes = append(causes, fmt.Sprintf("pod %v: attached debug container, run kubectl attach -it -n %v %v -c %v",
+			FormatMeta(pod.ObjectMeta), pod.Namespace, pod.Name, name))
+	}
+	return causes
+}
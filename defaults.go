@@ -0,0 +1,299 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gravitational/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Defaulter is a transformer that sets the defaults of unset fields
+// the same way the API server does, see SetDefaults
+type Defaulter struct{}
+
+// Transform sets defaults on all objects in the manifest stream
+func (Defaulter) Transform(data []byte) ([]byte, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, object := range objects {
+		if err := SetDefaults(object); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return EncodeObjects(objects)
+}
+
+// SetDefaults sets the commonly used API server defaults of workloads,
+// pod templates and services on the fields left unset in the object,
+// so that comparing it with the live object does not report differences
+// in the fields defaulted by the API server
+func SetDefaults(object *unstructured.Unstructured) error {
+	obj := object.Object
+	switch object.GetKind() {
+	case KindDeployment:
+		if object.GetAPIVersion() == "apps/v1" {
+			setDefault(obj, int64(1), "spec", "replicas")
+			setDefault(obj, int64(10), "spec", "revisionHistoryLimit")
+			setDefault(obj, int64(600), "spec", "progressDeadlineSeconds")
+			setDefault(obj, "RollingUpdate", "spec", "strategy", "type")
+			if strategy, _, _ := unstructured.NestedString(obj, "spec", "strategy", "type"); strategy == "RollingUpdate" {
+				setDefault(obj, "25%", "spec", "strategy", "rollingUpdate", "maxSurge")
+				setDefault(obj, "25%", "spec", "strategy", "rollingUpdate", "maxUnavailable")
+			}
+		}
+	case KindDaemonSet:
+		if object.GetAPIVersion() == "apps/v1" {
+			setDefault(obj, int64(10), "spec", "revisionHistoryLimit")
+			setDefault(obj, "RollingUpdate", "spec", "updateStrategy", "type")
+			if strategy, _, _ := unstructured.NestedString(obj, "spec", "updateStrategy", "type"); strategy == "RollingUpdate" {
+				setDefault(obj, int64(1), "spec", "updateStrategy", "rollingUpdate", "maxUnavailable")
+			}
+		}
+	case KindStatefulSet:
+		if object.GetAPIVersion() == "apps/v1" {
+			setDefault(obj, int64(1), "spec", "replicas")
+			setDefault(obj, int64(10), "spec", "revisionHistoryLimit")
+			setDefault(obj, "OrderedReady", "spec", "podManagementPolicy")
+			setDefault(obj, "RollingUpdate", "spec", "updateStrategy", "type")
+			if strategy, _, _ := unstructured.NestedString(obj, "spec", "updateStrategy", "type"); strategy == "RollingUpdate" {
+				setDefault(obj, int64(0), "spec", "updateStrategy", "rollingUpdate", "partition")
+			}
+		}
+	case KindJob:
+		setDefault(obj, int64(6), "spec", "backoffLimit")
+		setDefault(obj, int64(1), "spec", "parallelism")
+	case KindService:
+		setDefault(obj, "ClusterIP", "spec", "type")
+		setDefault(obj, "None", "spec", "sessionAffinity")
+		if err := updateList(obj, setServicePortDefaults, "spec", "ports"); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	path := podSpecPath(object.GetKind())
+	if path == nil {
+		return nil
+	}
+	spec, found, err := unstructured.NestedMap(obj, path...)
+	if err != nil || !found {
+		return trace.Wrap(err)
+	}
+	if err := setPodSpecDefaults(object.GetKind(), spec); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(unstructured.SetNestedMap(obj, spec, path...))
+}
+
+func setPodSpecDefaults(kind string, spec map[string]interface{}) error {
+	if kind != KindJob && kind != KindCronJob {
+		// restart policy of jobs is required
+		setDefault(spec, "Always", "restartPolicy")
+	}
+	setDefault(spec, "ClusterFirst", "dnsPolicy")
+	setDefault(spec, "default-scheduler", "schedulerName")
+	setDefault(spec, int64(30), "terminationGracePeriodSeconds")
+	setDefault(spec, map[string]interface{}{}, "securityContext")
+	for _, field := range []string{"initContainers", "containers"} {
+		if err := updateList(spec, setContainerDefaults, field); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func setContainerDefaults(container map[string]interface{}) error {
+	setDefault(container, "/dev/termination-log", "terminationMessagePath")
+	setDefault(container, "File", "terminationMessagePolicy")
+	image, _, _ := unstructured.NestedString(container, "image")
+	setDefault(container, imagePullPolicy(image), "imagePullPolicy")
+	return trace.Wrap(updateList(container, func(port map[string]interface{}) error {
+		setDefault(port, "TCP", "protocol")
+		return nil
+	}, "ports"))
+}
+
+func setServicePortDefaults(port map[string]interface{}) error {
+	setDefault(port, "TCP", "protocol")
+	if value, ok := port["port"]; ok {
+		setDefault(port, runtime.DeepCopyJSONValue(value), "targetPort")
+	}
+	return nil
+}
+
+// imagePullPolicy returns the default pull policy of the image:
+// Always for images without a tag or with the latest tag
+func imagePullPolicy(image string) string {
+	if strings.Contains(image, "@") {
+		return "IfNotPresent"
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	if i == -1 || name[i+1:] == "latest" {
+		return "Always"
+	}
+	return "IfNotPresent"
+}
+
+// setDefault sets the field at the specified path unless it is already set
+func setDefault(obj map[string]interface{}, value interface{}, fields ...string) {
+	if _, found, _ := unstructured.NestedFieldNoCopy(obj, fields...); found {
+		return
+	}
+	unstructured.SetNestedField(obj, value, fields...)
+}
+
+// updateList calls fn for each map element of the list at the specified path
+func updateList(obj map[string]interface{}, fn func(map[string]interface{}) error, fields ...string) error {
+	items, found, err := unstructured.NestedFieldNoCopy(obj, fields...)
+	if err != nil || !found {
+		return trace.Wrap(err)
+	}
+	list, ok := items.([]interface{})
+	if !ok {
+		return trace.BadParameter("expected list at %v, got %T", strings.Join(fields, "."), items)
+	}
+	for _, item := range list {
+		if element, ok := item.(map[string]interface{}); ok {
+			if err := fn(element); err != nil {
+				return trace.Wrap(err)
+			}
+		}
+	}
+	return nil
+}
+
+// specChanged returns true if the defaulted spec, labels or annotations
+// of the desired object in the manifest differ from the live object,
+// or if fields of the last applied manifest recorded on the live object
+// with setLastApplied are removed from the desired one. Fields set on the
+// live object by the API server or by controllers are not removed fields
+func specChanged(data []byte, live runtime.Object) (bool, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if len(objects) != 1 {
+		return false, trace.BadParameter("expected a single object, got %v", len(objects))
+	}
+	desired := objects[0]
+	if err := SetDefaults(desired); err != nil {
+		return false, trace.Wrap(err)
+	}
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	var applied map[string]interface{}
+	if lastApplied, _, _ := unstructured.NestedString(fields, "metadata", "annotations", LastAppliedAnnotation); lastApplied != "" {
+		if err := json.Unmarshal([]byte(lastApplied), &applied); err != nil {
+			return false, trace.Wrap(err, "invalid last applied configuration")
+		}
+	}
+	for _, path := range [][]string{{"spec"}, {"metadata", "labels"}, {"metadata", "annotations"}} {
+		desiredValue, found, _ := unstructured.NestedFieldNoCopy(desired.Object, path...)
+		liveValue, _, _ := unstructured.NestedFieldNoCopy(fields, path...)
+		if found && len(diffFields(path, desiredValue, liveValue)) != 0 {
+			return true, nil
+		}
+		appliedValue, _, _ := unstructured.NestedFieldNoCopy(applied, path...)
+		if removed := removedFields(path, desiredValue, appliedValue); len(removed) != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setLastApplied records the manifest as the last applied configuration
+// of the object, so that specChanged reports the fields removed from it
+func setLastApplied(meta *metav1.ObjectMeta, data []byte) error {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if len(objects) != 1 {
+		return trace.BadParameter("expected a single object, got %v", len(objects))
+	}
+	object, err := withLastApplied(objects[0])
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[LastAppliedAnnotation] = object.GetAnnotations()[LastAppliedAnnotation]
+	return nil
+}
+
+// removedFields returns the paths of the non-empty fields
+// of the applied value missing from the desired value
+func removedFields(path []string, desired, applied interface{}) []string {
+	switch appliedValue := applied.(type) {
+	case map[string]interface{}:
+		desiredValue, ok := desired.(map[string]interface{})
+		if !ok {
+			desiredValue = nil
+		}
+		var removed []string
+		for _, key := range sortedKeys(appliedValue) {
+			fieldPath := append(path[:len(path):len(path)], key)
+			if isEmptyValue(appliedValue[key]) {
+				continue
+			}
+			if _, ok := desiredValue[key]; !ok {
+				if _, ok := appliedValue[key].(map[string]interface{}); !ok {
+					removed = append(removed, strings.Join(fieldPath, "."))
+					continue
+				}
+			}
+			removed = append(removed, removedFields(fieldPath, desiredValue[key], appliedValue[key])...)
+		}
+		return removed
+	case []interface{}:
+		desiredValue, ok := desired.([]interface{})
+		if !ok || len(desiredValue) != len(appliedValue) {
+			// reported by diffFields
+			return nil
+		}
+		var removed []string
+		for i := range appliedValue {
+			removed = append(removed, removedFields(append(path[:len(path):len(path)], fmt.Sprint(i)), desiredValue[i], appliedValue[i])...)
+		}
+		return removed
+	}
+	return nil
+}
+
+// isEmptyValue returns true for nil values, empty maps and empty lists,
+// which are equivalent to unset fields
+func isEmptyValue(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type DefaultsSuite struct{}
+
+var _ = Suite(&DefaultsSuite{})
+
+const daemonSetManifest = `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+  namespace: kube-system
+spec:
+  selector:
+    matchLabels:
+      app: agent
+  template:
+    metadata:
+      labels:
+        app: agent
+    spec:
+      containers:
+      - name: agent
+        image: agent:1.0
+        ports:
+        - containerPort: 8080
+`
+
+func (s *DefaultsSuite) TestSpecChanged(c *C) {
+	// live object as returned by the API server with all defaults set
+	data, err := Defaulter{}.Transform([]byte(daemonSetManifest))
+	c.Assert(err, IsNil)
+	live, err := ParseDaemonSet(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(live.Spec.Template.Spec.Containers[0].ImagePullPolicy, Equals, v1.PullIfNotPresent)
+	c.Assert(live.Spec.Template.Spec.Containers[0].Ports[0].Protocol, Equals, v1.ProtocolTCP)
+	c.Assert(live.Spec.UpdateStrategy.Type, Equals, appsv1.RollingUpdateDaemonSetStrategyType)
+
+	changed, err := specChanged([]byte(daemonSetManifest), live)
+	c.Assert(err, IsNil)
+	c.Assert(changed, Equals, false)
+
+	live.Spec.Template.Spec.Containers[0].Image = "agent:0.9"
+	changed, err = specChanged([]byte(daemonSetManifest), live)
+	c.Assert(err, IsNil)
+	c.Assert(changed, Equals, true)
+}
+
+func (s *DefaultsSuite) TestImagePullPolicy(c *C) {
+	c.Assert(imagePullPolicy("nginx"), Equals, "Always")
+	c.Assert(imagePullPolicy("nginx:latest"), Equals, "Always")
+	c.Assert(imagePullPolicy("registry:5000/nginx"), Equals, "Always")
+	c.Assert(imagePullPolicy("registry:5000/nginx:1.15"), Equals, "IfNotPresent")
+	c.Assert(imagePullPolicy("nginx@sha256:abcd"), Equals, "IfNotPresent")
+}
+
+func (s *DefaultsSuite) TestSpecChangedRemovedFields(c *C) {
+	data, err := Defaulter{}.Transform([]byte(daemonSetManifest))
+	c.Assert(err, IsNil)
+	live, err := ParseDaemonSet(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	live.Annotations = map[string]string{"deprecated.daemonset.template.generation": "2"}
+	live.Spec.Template.Annotations = map[string]string{RestartedAtAnnotation: "2018-01-01T00:00:00Z"}
+	c.Assert(setLastApplied(&live.ObjectMeta, []byte(daemonSetManifest)), IsNil)
+
+	changed, err := specChanged([]byte(daemonSetManifest), live)
+	c.Assert(err, IsNil)
+	c.Assert(changed, Equals, false, Commentf("annotations set by the server are not removed fields"))
+
+	manifest, err := ParseDaemonSet(strings.NewReader(daemonSetManifest))
+	c.Assert(err, IsNil)
+	manifest.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: KindDaemonSet}
+	tcs := []func(*appsv1.DaemonSet){
+		func(ds *appsv1.DaemonSet) { ds.Spec.Template.Spec.NodeSelector = map[string]string{"role": "edge"} },
+		func(ds *appsv1.DaemonSet) { ds.Labels = map[string]string{"tier": "system"} },
+		func(ds *appsv1.DaemonSet) { ds.Annotations = map[string]string{"owner": "ops"} },
+		func(ds *appsv1.DaemonSet) {
+			ds.Spec.Template.Spec.Containers[0].Resources.Limits = v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}
+		},
+		func(ds *appsv1.DaemonSet) {
+			privileged := true
+			ds.Spec.Template.Spec.Containers[0].SecurityContext = &v1.SecurityContext{Privileged: &privileged}
+		},
+	}
+	for i, tc := range tcs {
+		// the field was applied before and is missing from the manifest now
+		applied := manifest.DeepCopy()
+		tc(applied)
+		data, err := json.Marshal(applied)
+		c.Assert(err, IsNil)
+		withRemoved := live.DeepCopy()
+		tc(withRemoved)
+		c.Assert(setLastApplied(&withRemoved.ObjectMeta, data), IsNil)
+		changed, err := specChanged([]byte(daemonSetManifest), withRemoved)
+		c.Assert(err, IsNil)
+		c.Assert(changed, Equals, true, Commentf("test case %v", i+1))
+
+		// the field was set on the live object by another client
+		withRemoved = live.DeepCopy()
+		tc(withRemoved)
+		changed, err = specChanged([]byte(daemonSetManifest), withRemoved)
+		c.Assert(err, IsNil)
+		c.Assert(changed, Equals, false, Commentf("test case %v", i+1))
+	}
+}
+
+const probeManifest = `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: default
+spec:
+  serviceName: db
+  selector:
+    matchLabels:
+      app: db
+  template:
+    metadata:
+      labels:
+        app: db
+    spec:
+      containers:
+      - name: db
+        image: db:1.0
+        env:
+        - name: POD_NAME
+          valueFrom:
+            fieldRef:
+              fieldPath: metadata.name
+        readinessProbe:
+          httpGet:
+            path: /healthz
+            port: 8080
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      accessModes: [ReadWriteOnce]
+      resources:
+        requests:
+          storage: 1Gi
+`
+
+func (s *DefaultsSuite) TestSpecChangedServerDefaults(c *C) {
+	data, err := Defaulter{}.Transform([]byte(probeManifest))
+	c.Assert(err, IsNil)
+	live, err := ParseStatefulSet(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(setLastApplied(&live.ObjectMeta, []byte(probeManifest)), IsNil)
+	// fields defaulted by the API server that SetDefaults does not set
+	container := &live.Spec.Template.Spec.Containers[0]
+	container.ReadinessProbe.HTTPGet.Scheme = v1.URISchemeHTTP
+	container.ReadinessProbe.TimeoutSeconds = 1
+	container.Env[0].ValueFrom.FieldRef.APIVersion = "v1"
+	storageClass := "standard"
+	live.Spec.VolumeClaimTemplates[0].Spec.StorageClassName = &storageClass
+
+	changed, err := specChanged([]byte(probeManifest), live)
+	c.Assert(err, IsNil)
+	c.Assert(changed, Equals, false)
+
+	container.ReadinessProbe.HTTPGet.Path = "/ready"
+	changed, err = specChanged([]byte(probeManifest), live)
+	c.Assert(err, IsNil)
+	c.Assert(changed, Equals, true)
+}
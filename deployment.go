@@ -16,7 +16,10 @@ package rigging
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/gravitational/trace"
@@ -24,6 +27,7 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -47,7 +51,7 @@ func NewDeploymentControl(config DeploymentConfig) (*DeploymentControl, error) {
 		DeploymentConfig: config,
 		deployment:       *rc,
 		Entry: log.WithFields(log.Fields{
-			"deployment": formatMeta(rc.ObjectMeta),
+			"deployment": FormatMeta(rc.ObjectMeta),
 		}),
 	}, nil
 }
@@ -60,6 +64,18 @@ type DeploymentConfig struct {
 	Deployment *appsv1.Deployment
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// StrictReadiness, if set, makes Status also require that no container
+	// of the deployment pods has restarted within this window
+	StrictReadiness time.Duration
+	// Recorder posts events about the operations on the deployment,
+	// defaults to a recorder using Client
+	Recorder *EventRecorder
+	// AutoRecreate makes Upsert replace the pods of an existing deployment with
+	// the Recreate strategy if host ports or ReadWriteOnce volumes prevent
+	// a rolling update, the update strategy is restored afterwards
+	AutoRecreate bool
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *DeploymentConfig) CheckAndSetDefaults() error {
@@ -69,6 +85,9 @@ func (c *DeploymentConfig) CheckAndSetDefaults() error {
 	if c.Client == nil {
 		return trace.BadParameter("missing parameter Client")
 	}
+	if c.Recorder == nil {
+		c.Recorder = NewEventRecorder(c.Client)
+	}
 	return nil
 }
 
@@ -78,10 +97,14 @@ type DeploymentControl struct {
 	DeploymentConfig
 	deployment appsv1.Deployment
 	*log.Entry
+	// restartGeneration is the generation of the deployment after the last restart
+	restartGeneration int64
 }
 
-func (c *DeploymentControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.deployment.ObjectMeta))
+func (c *DeploymentControl) Delete(ctx context.Context, cascade bool) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Delete", err) }()
+	c.Infof("delete %v", FormatMeta(c.deployment.ObjectMeta))
 
 	deployments := c.Client.Apps().Deployments(c.deployment.Namespace)
 	currentDeployment, err := deployments.Get(c.deployment.Name, metav1.GetOptions{})
@@ -97,11 +120,18 @@ func (c *DeploymentControl) Delete(ctx context.Context, cascade bool) error {
 
 	if cascade {
 		// scale deployment down to delete the pods
-		var replicas int32
-		currentDeployment.Spec.Replicas = &replicas
-		currentDeployment, err = deployments.Update(currentDeployment)
-		if err != nil {
+		err = RetryOnConflict(ctx, func() error {
+			currentDeployment, err := deployments.Get(c.deployment.Name, metav1.GetOptions{})
+			if err != nil {
+				return ConvertError(err)
+			}
+			var replicas int32
+			currentDeployment.Spec.Replicas = &replicas
+			_, err = deployments.Update(currentDeployment)
 			return ConvertError(err)
+		})
+		if err != nil {
+			return trace.Wrap(err)
 		}
 	}
 	deletePolicy := metav1.DeletePropagationForeground
@@ -121,21 +151,23 @@ func (c *DeploymentControl) Delete(ctx context.Context, cascade bool) error {
 	}
 
 	// wait until all Pods have been cleaned up
-	err = waitForPods(pods, currentPods, *c.Entry)
+	err = waitForPodsList(pods, currentPods, *c.Entry)
 	if err != nil {
 		c.Warningf("failed to wait for Pods to clean up: %v", trace.DebugReport(err))
 	}
 	return nil
 }
 
-func (c *DeploymentControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.deployment.ObjectMeta))
+func (c *DeploymentControl) Upsert(ctx context.Context) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Upsert", err) }()
+	c.Infof("upsert %v", FormatMeta(c.deployment.ObjectMeta))
 
 	deployments := c.Client.Apps().Deployments(c.deployment.Namespace)
 	c.deployment.UID = ""
 	c.deployment.SelfLink = ""
 	c.deployment.ResourceVersion = ""
-	_, err := deployments.Get(c.deployment.Name, metav1.GetOptions{})
+	_, err = deployments.Get(c.deployment.Name, metav1.GetOptions{})
 	err = ConvertError(err)
 	if err != nil {
 		if !trace.IsNotFound(err) {
@@ -144,10 +176,78 @@ func (c *DeploymentControl) Upsert(ctx context.Context) error {
 		_, err = deployments.Create(&c.deployment)
 		return ConvertError(err)
 	}
+	if c.AutoRecreate {
+		conflicts, err := c.rollingUpdateConflicts()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if len(conflicts) != 0 {
+			return trace.Wrap(c.recreate(ctx, conflicts))
+		}
+	}
 	_, err = deployments.Update(&c.deployment)
 	return ConvertError(err)
 }
 
+// Restart triggers a rolling restart of the deployment's pods
+// by updating the restart annotation on the pod template
+func (c *DeploymentControl) Restart(ctx context.Context) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Restart", err) }()
+	c.Infof("restart %v", FormatMeta(c.deployment.ObjectMeta))
+
+	deployments := c.Client.AppsV1().Deployments(c.deployment.Namespace)
+	return RetryOnConflict(ctx, func() error {
+		currentDeployment, err := deployments.Get(c.deployment.Name, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		setRestartAnnotation(&currentDeployment.Spec.Template.ObjectMeta)
+		updated, err := deployments.Update(currentDeployment)
+		if err != nil {
+			return ConvertError(err)
+		}
+		c.restartGeneration = updated.Generation
+		return nil
+	})
+}
+
+// RolloutStatus returns CompareFailed until the deployment controller
+// has observed the last restart and replaced the pods of the deployment
+func (c *DeploymentControl) RolloutStatus() error {
+	currentDeployment, err := c.Client.AppsV1().Deployments(c.deployment.Namespace).Get(c.deployment.Name, metav1.GetOptions{})
+	if err != nil {
+		return ConvertError(err)
+	}
+	return trace.Wrap(checkReplaced(currentDeployment, c.restartGeneration))
+}
+
+// PauseRollout pauses the rollout of the deployment, changes to the pod
+// template are not rolled out until the rollout is resumed
+func (c *DeploymentControl) PauseRollout(ctx context.Context) error {
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("pause rollout of %v", FormatMeta(c.deployment.ObjectMeta))
+	return c.setPaused(true)
+}
+
+// ResumeRollout resumes the paused rollout of the deployment
+func (c *DeploymentControl) ResumeRollout(ctx context.Context) error {
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("resume rollout of %v", FormatMeta(c.deployment.ObjectMeta))
+	return c.setPaused(false)
+}
+
+func (c *DeploymentControl) setPaused(paused bool) error {
+	deployments := c.Client.AppsV1().Deployments(c.deployment.Namespace)
+	patch := []byte(fmt.Sprintf(`{"spec":{"paused":%v}}`, paused))
+	_, err := deployments.Patch(c.deployment.Name, types.MergePatchType, patch)
+	if err != nil {
+		return ConvertError(err)
+	}
+	c.deployment.Spec.Paused = paused
+	return nil
+}
+
 func (c *DeploymentControl) nodeSelector() labels.Selector {
 	set := make(labels.Set)
 	for key, val := range c.deployment.Spec.Template.Spec.NodeSelector {
@@ -156,6 +256,12 @@ func (c *DeploymentControl) nodeSelector() labels.Selector {
 	return set.AsSelector()
 }
 
+// UpsertAndWait upserts the deployment and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *DeploymentControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *DeploymentControl) Status() error {
 	deployments := c.Client.Extensions().Deployments(c.deployment.Namespace)
 	currentDeployment, err := deployments.Get(c.deployment.Name, metav1.GetOptions{})
@@ -166,19 +272,45 @@ func (c *DeploymentControl) Status() error {
 	if currentDeployment.Spec.Replicas != nil {
 		replicas = *(currentDeployment.Spec.Replicas)
 	}
-	deployment := formatMeta(c.deployment.ObjectMeta)
+	deployment := FormatMeta(c.deployment.ObjectMeta)
 	if currentDeployment.Status.UpdatedReplicas != replicas {
 		return trace.CompareFailed("deployment %v not successful: expected replicas: %v, updated: %v",
 			deployment, replicas, currentDeployment.Status.UpdatedReplicas)
 	}
 	if currentDeployment.Status.AvailableReplicas != replicas {
-		return trace.CompareFailed("deployment %v not successful: expected replicas: %v, available: %v",
+		err := trace.CompareFailed("deployment %v not successful: expected replicas: %v, available: %v",
 			deployment, replicas, currentDeployment.Status.AvailableReplicas)
+		if currentDeployment.Spec.Selector == nil {
+			return err
+		}
+		return c.withPendingReasons(currentDeployment.Namespace, currentDeployment.Spec.Selector.MatchLabels, err)
 	}
-	return nil
+	if c.StrictReadiness <= 0 || currentDeployment.Spec.Selector == nil {
+		return nil
+	}
+	pods, err := listPods(c.Client, currentDeployment.Namespace, currentDeployment.Spec.Selector.MatchLabels)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return checkRecentRestarts(pods, c.StrictReadiness)
 }
 
-func (c *DeploymentControl) collectPods(deployment *appsv1.Deployment) (map[string]v1.Pod, error) {
+// withPendingReasons adds the scheduling reasons of the pending pods
+// matching the labels to the status error
+func (c *DeploymentControl) withPendingReasons(namespace string, matchLabels map[string]string, err error) error {
+	pods, errList := listPods(c.Client, namespace, matchLabels)
+	if errList != nil {
+		c.Warningf("failed to list pods: %v", errList)
+		return err
+	}
+	reasons := pendingReasons(pods)
+	if len(reasons) == 0 {
+		return err
+	}
+	return trace.CompareFailed("%v: %v", err.Error(), strings.Join(reasons, "; "))
+}
+
+func (c *DeploymentControl) collectPods(deployment *appsv1.Deployment) ([]v1.Pod, error) {
 	var labels map[string]string
 	if deployment.Spec.Selector != nil {
 		labels = deployment.Spec.Selector.MatchLabels
@@ -188,3 +320,25 @@ func (c *DeploymentControl) collectPods(deployment *appsv1.Deployment) (map[stri
 	})
 	return pods, ConvertError(err)
 }
+
+// Diagnose returns the causes of the deployment pods not being ready
+func (c *DeploymentControl) Diagnose() ([]string, error) {
+	var labels map[string]string
+	if c.deployment.Spec.Selector != nil {
+		labels = c.deployment.Spec.Selector.MatchLabels
+	}
+	return DiagnosePods(c.Client, c.deployment.Namespace, labels)
+}
+
+// recordEvent posts an event about the action on the deployment
+func (c *DeploymentControl) recordEvent(action string, err error) {
+	if c.Recorder == nil {
+		return
+	}
+	meta := c.deployment.ObjectMeta
+	current, errGet := c.Client.AppsV1().Deployments(meta.Namespace).Get(meta.Name, metav1.GetOptions{})
+	if errGet == nil {
+		meta = current.ObjectMeta
+	}
+	c.Recorder.Record(objectReference(APIVersionFor(KindDeployment), KindDeployment, meta), action, err)
+}
@@ -0,0 +1,208 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Drift describes out-of-band modifications of a bundle resource
+type Drift struct {
+	// Ref references the drifted resource
+	Ref ObjectRef
+	// Missing is set if the resource does not exist in the cluster
+	Missing bool
+	// Fields lists the fields with live values different from desired ones
+	Fields []FieldDrift
+}
+
+// String returns a human readable drift description
+func (d Drift) String() string {
+	if d.Missing {
+		return fmt.Sprintf("%v: missing", d.Ref)
+	}
+	var fields []string
+	for _, field := range d.Fields {
+		fields = append(fields, field.String())
+	}
+	return fmt.Sprintf("%v: %v", d.Ref, strings.Join(fields, ", "))
+}
+
+// FieldDrift describes a modified field
+type FieldDrift struct {
+	// Path is a dot separated path to the field, e.g. spec.replicas
+	Path string `json:"path"`
+	// Desired is the field value in the bundle
+	Desired interface{} `json:"desired"`
+	// Live is the field value in the cluster
+	Live interface{} `json:"live"`
+	// Manager is the name of the manager that last set the field,
+	// empty if the cluster does not track managed fields
+	Manager string `json:"manager,omitempty"`
+}
+
+// String returns a human readable field drift description
+func (f FieldDrift) String() string {
+	if f.Manager == "" {
+		return fmt.Sprintf("%v: %v -> %v", f.Path, f.Desired, f.Live)
+	}
+	return fmt.Sprintf("%v: %v -> %v (by %v)", f.Path, f.Desired, f.Live, f.Manager)
+}
+
+// DetectDrift compares the bundle resources against the live cluster
+// state and returns the resources modified out of band.
+// Only the fields set in the bundle are compared, so fields defaulted
+// by the API server are not reported
+func DetectDrift(ctx context.Context, bundle *Bundle) ([]Drift, error) {
+	return detectDrift(ctx, KubectlObjects{}, bundle)
+}
+
+func detectDrift(ctx context.Context, objects ObjectInterface, bundle *Bundle) ([]Drift, error) {
+	var drifts []Drift
+	for _, object := range bundle.Objects {
+		if err := ctx.Err(); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		ref := bundle.Ref(object)
+		live, err := objects.Get(ctx, ref)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				drifts = append(drifts, Drift{Ref: ref, Missing: true})
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+		fields := diffObjects(object, live)
+		if len(fields) != 0 {
+			log.Debugf("%v has drifted: %v", ref, fields)
+			drifts = append(drifts, Drift{Ref: ref, Fields: fields})
+		}
+	}
+	return drifts, nil
+}
+
+// diffObjects returns fields set in desired object that differ in the live object.
+// Objects of different API versions are upgraded to the preferred version first
+func diffObjects(desired, live *unstructured.Unstructured) []FieldDrift {
+	desired, live = normalizeVersions(desired, live)
+	var fields []FieldDrift
+	for _, key := range sortedKeys(desired.Object) {
+		switch key {
+		case "apiVersion", "kind", "status":
+			continue
+		case "metadata":
+			for _, meta := range []string{"labels", "annotations"} {
+				desiredMeta, ok, _ := unstructured.NestedFieldNoCopy(desired.Object, "metadata", meta)
+				if !ok {
+					continue
+				}
+				liveMeta, _, _ := unstructured.NestedFieldNoCopy(live.Object, "metadata", meta)
+				fields = append(fields, diffFields([]string{"metadata", meta}, desiredMeta, liveMeta)...)
+			}
+			continue
+		}
+		fields = append(fields, diffFields([]string{key}, desired.Object[key], live.Object[key])...)
+	}
+	managedFields, _, _ := unstructured.NestedSlice(live.Object, "metadata", "managedFields")
+	for i := range fields {
+		fields[i].Manager = fieldManager(managedFields, fields[i].Path)
+	}
+	return fields
+}
+
+// diffFields recursively compares the desired value against the live one
+func diffFields(path []string, desired, live interface{}) []FieldDrift {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		liveValue, ok := live.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var fields []FieldDrift
+		for _, key := range sortedKeys(desiredValue) {
+			fields = append(fields, diffFields(append(path[:len(path):len(path)], key), desiredValue[key], liveValue[key])...)
+		}
+		return fields
+	case []interface{}:
+		liveValue, ok := live.([]interface{})
+		if !ok || len(liveValue) != len(desiredValue) {
+			break
+		}
+		var fields []FieldDrift
+		for i := range desiredValue {
+			fields = append(fields, diffFields(append(path[:len(path):len(path)], fmt.Sprint(i)), desiredValue[i], liveValue[i])...)
+		}
+		return fields
+	default:
+		if reflect.DeepEqual(desired, live) {
+			return nil
+		}
+	}
+	return []FieldDrift{{Path: strings.Join(path, "."), Desired: desired, Live: live}}
+}
+
+// fieldManager returns the manager that last set the field at the specified path
+// according to the managed fields, or an empty string if the field is not tracked.
+// List elements are not resolved, the manager of the enclosing field is returned instead
+func fieldManager(managedFields []interface{}, path string) string {
+	var manager string
+	var depth int
+	for _, item := range managedFields {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fields, ok := entry["fieldsV1"].(map[string]interface{})
+		if !ok {
+			fields, ok = entry["fields"].(map[string]interface{})
+		}
+		if !ok {
+			continue
+		}
+		matched := 0
+		for _, key := range strings.Split(path, ".") {
+			next, ok := fields["f:"+key].(map[string]interface{})
+			if !ok {
+				break
+			}
+			fields = next
+			matched++
+		}
+		if matched > depth {
+			depth = matched
+			manager, _ = entry["manager"].(string)
+		}
+	}
+	return manager
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
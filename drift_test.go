@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type DriftSuite struct{}
+
+var _ = Suite(&DriftSuite{})
+
+func (s *DriftSuite) TestDiffObjects(c *C) {
+	desired, err := DecodeObjects([]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  labels:
+    app: app
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+`))
+	c.Assert(err, IsNil)
+	live, err := DecodeObjects([]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  uid: 1ff2
+  labels:
+    app: app
+  managedFields:
+  - manager: rig
+    fieldsV1:
+      f:spec:
+        f:template: {}
+  - manager: kubectl-scale
+    fieldsV1:
+      f:spec:
+        f:replicas: {}
+spec:
+  replicas: 5
+  revisionHistoryLimit: 10
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.1
+        imagePullPolicy: IfNotPresent
+status:
+  replicas: 5
+`))
+	c.Assert(err, IsNil)
+	fields := diffObjects(desired[0], live[0])
+	c.Assert(fields, DeepEquals, []FieldDrift{
+		{Path: "spec.replicas", Desired: int64(2), Live: int64(5), Manager: "kubectl-scale"},
+		{Path: "spec.template.spec.containers.0.image", Desired: "app:1.0", Live: "app:1.1", Manager: "rig"},
+	})
+}
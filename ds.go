@@ -17,6 +17,7 @@ package rigging
 import (
 	"context"
 	"io"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/gravitational/trace"
@@ -25,6 +26,7 @@ import (
 	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -49,7 +51,7 @@ func NewDSControl(config DSConfig) (*DSControl, error) {
 		DSConfig:  config,
 		daemonSet: *ds,
 		Entry: log.WithFields(log.Fields{
-			"ds": formatMeta(ds.ObjectMeta),
+			"ds": FormatMeta(ds.ObjectMeta),
 		}),
 	}, nil
 }
@@ -62,6 +64,16 @@ type DSConfig struct {
 	DaemonSet *appsv1.DaemonSet
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// Nodes restricts the nodes checked by Status
+	Nodes NodeFilter
+	// StrictReadiness, if set, makes Status also require that no container
+	// of the daemon set pods has restarted within this window
+	StrictReadiness time.Duration
+	// Recorder posts events about the operations on the daemon set,
+	// defaults to a recorder using Client
+	Recorder *EventRecorder
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *DSConfig) CheckAndSetDefaults() error {
@@ -71,6 +83,9 @@ func (c *DSConfig) CheckAndSetDefaults() error {
 	if c.Client == nil {
 		return trace.BadParameter("missing parameter Client")
 	}
+	if c.Recorder == nil {
+		c.Recorder = NewEventRecorder(c.Client)
+	}
 	return nil
 }
 
@@ -80,10 +95,12 @@ type DSControl struct {
 	DSConfig
 	daemonSet appsv1.DaemonSet
 	*log.Entry
+	// restartGeneration is the generation of the daemon set after the last restart
+	restartGeneration int64
 }
 
 // collectPods returns pods created by this daemon set
-func (c *DSControl) collectPods(daemonSet *v1beta1.DaemonSet) (map[string]v1.Pod, error) {
+func (c *DSControl) collectPods(daemonSet *v1beta1.DaemonSet) ([]v1.Pod, error) {
 	var labels map[string]string
 	if daemonSet.Spec.Selector != nil {
 		labels = daemonSet.Spec.Selector.MatchLabels
@@ -94,8 +111,10 @@ func (c *DSControl) collectPods(daemonSet *v1beta1.DaemonSet) (map[string]v1.Pod
 	return pods, trace.Wrap(err)
 }
 
-func (c *DSControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.daemonSet.ObjectMeta))
+func (c *DSControl) Delete(ctx context.Context, cascade bool) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Delete", err) }()
+	c.Infof("delete %v", FormatMeta(c.daemonSet.ObjectMeta))
 
 	daemons := c.Client.Extensions().DaemonSets(c.daemonSet.Namespace)
 	currentDS, err := daemons.Get(c.daemonSet.Name, metav1.GetOptions{})
@@ -127,12 +146,14 @@ func (c *DSControl) Delete(ctx context.Context, cascade bool) error {
 	if !cascade {
 		c.Info("cascade not set, returning")
 	}
-	err = deletePods(pods, currentPods, *c.Entry)
+	err = deletePods(ctx, pods, currentPods, *c.Entry)
 	return trace.Wrap(err)
 }
 
-func (c *DSControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.daemonSet.ObjectMeta))
+func (c *DSControl) Upsert(ctx context.Context) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Upsert", err) }()
+	c.Infof("upsert %v", FormatMeta(c.daemonSet.ObjectMeta))
 
 	daemons := c.Client.Apps().DaemonSets(c.daemonSet.Namespace)
 	currentDS, err := daemons.Get(c.daemonSet.Name, metav1.GetOptions{})
@@ -161,13 +182,59 @@ func (c *DSControl) Upsert(ctx context.Context) error {
 	c.daemonSet.SelfLink = ""
 	c.daemonSet.ResourceVersion = ""
 
-	err = withExponentialBackoff(func() error {
+	var oldUID types.UID
+	if currentDS != nil {
+		oldUID = currentDS.UID
+	}
+	var attempt int
+	err = recreateObject(oldUID, func() (metav1.Object, error) {
+		ds, err := daemons.Get(c.daemonSet.Name, metav1.GetOptions{})
+		return ds, ConvertError(err)
+	}, func() error {
+		attempt++
+		if attempt > 1 {
+			c.Recorder.Eventf(objectReference(APIVersionFor(KindDaemonSet), KindDaemonSet, c.daemonSet.ObjectMeta), v1.EventTypeWarning, ReasonRetry,
+				"Retrying create, attempt %v", attempt)
+		}
 		_, err = daemons.Create(&c.daemonSet)
 		return ConvertError(err)
 	})
 	return trace.Wrap(err)
 }
 
+// Restart triggers a rolling restart of the daemon set's pods
+// by updating the restart annotation on the pod template
+func (c *DSControl) Restart(ctx context.Context) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Restart", err) }()
+	c.Infof("restart %v", FormatMeta(c.daemonSet.ObjectMeta))
+
+	daemons := c.Client.AppsV1().DaemonSets(c.daemonSet.Namespace)
+	return RetryOnConflict(ctx, func() error {
+		currentDS, err := daemons.Get(c.daemonSet.Name, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		setRestartAnnotation(&currentDS.Spec.Template.ObjectMeta)
+		updated, err := daemons.Update(currentDS)
+		if err != nil {
+			return ConvertError(err)
+		}
+		c.restartGeneration = updated.Generation
+		return nil
+	})
+}
+
+// RolloutStatus returns CompareFailed until the daemon set controller
+// has observed the last restart and replaced the pods of the daemon set
+func (c *DSControl) RolloutStatus() error {
+	currentDS, err := c.Client.AppsV1().DaemonSets(c.daemonSet.Namespace).Get(c.daemonSet.Name, metav1.GetOptions{})
+	if err != nil {
+		return ConvertError(err)
+	}
+	return trace.Wrap(checkDaemonSetRolledOut(currentDS, c.restartGeneration))
+}
+
 func (c *DSControl) nodeSelector() labels.Selector {
 	set := make(labels.Set)
 	for key, val := range c.daemonSet.Spec.Template.Spec.NodeSelector {
@@ -176,6 +243,12 @@ func (c *DSControl) nodeSelector() labels.Selector {
 	return set.AsSelector()
 }
 
+// UpsertAndWait upserts the daemon set and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *DSControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *DSControl) Status() error {
 	daemons := c.Client.Extensions().DaemonSets(c.daemonSet.Namespace)
 	currentDS, err := daemons.Get(c.daemonSet.Name, metav1.GetOptions{})
@@ -187,11 +260,35 @@ func (c *DSControl) Status() error {
 		return trace.Wrap(err)
 	}
 
-	nodes, err := c.Client.Core().Nodes().List(metav1.ListOptions{
-		LabelSelector: c.nodeSelector().String(),
-	})
+	nodes, err := ListNodes(context.TODO(), c.Client, c.nodeSelector(), false)
 	if err != nil {
-		return ConvertError(err)
+		return trace.Wrap(err)
+	}
+	nodes = daemonSetNodes(nodes, currentPods, currentDS.Spec.Template.Spec, c.Entry)
+	if err := checkNodes(currentPods, nodes, c.Nodes, c.Entry); err != nil {
+		return trace.Wrap(err)
+	}
+	return checkRecentRestarts(currentPods, c.StrictReadiness)
+}
+
+// Diagnose returns the causes of the daemon set pods not being ready
+func (c *DSControl) Diagnose() ([]string, error) {
+	var labels map[string]string
+	if c.daemonSet.Spec.Selector != nil {
+		labels = c.daemonSet.Spec.Selector.MatchLabels
+	}
+	return DiagnosePods(c.Client, c.daemonSet.Namespace, labels)
+}
+
+// recordEvent posts an event about the action on the daemon set
+func (c *DSControl) recordEvent(action string, err error) {
+	if c.Recorder == nil {
+		return
+	}
+	meta := c.daemonSet.ObjectMeta
+	current, errGet := c.Client.AppsV1().DaemonSets(meta.Namespace).Get(meta.Name, metav1.GetOptions{})
+	if errGet == nil {
+		meta = current.ObjectMeta
 	}
-	return checkRunning(currentPods, nodes.Items, c.Entry)
+	c.Recorder.Record(objectReference(APIVersionFor(KindDaemonSet), KindDaemonSet, meta), action, err)
 }
@@ -0,0 +1,410 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	serializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// DynamicConfig is a dynamic client configuration
+type DynamicConfig struct {
+	// Config is rest client config
+	Config *rest.Config
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *DynamicConfig) CheckAndSetDefaults() error {
+	if c.Config == nil {
+		return trace.BadParameter("missing parameter Config")
+	}
+	return nil
+}
+
+// NewDynamicClient returns a new dynamic client
+func NewDynamicClient(config DynamicConfig) (*DynamicClient, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	cfg := *config.Config
+	cfg.APIPath = ""
+	cfg.GroupVersion = &schema.GroupVersion{}
+	cfg.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	client, err := rest.RESTClientFor(&cfg)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config.Config)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	return &DynamicClient{
+		DynamicConfig: config,
+		client:        client,
+		discovery:     discoveryClient,
+	}, nil
+}
+
+// DynamicClient implements ObjectInterface with the REST API of the server
+// and a REST mapper built from API discovery, without the typed clientset
+// or kubectl. Use it where rigging accepts an ObjectInterface.
+// Changeset uses it in place of kubectl, but still needs the typed clientset
+// to manage the resources of the kinds it supports
+type DynamicClient struct {
+	DynamicConfig
+	client    *rest.RESTClient
+	discovery *discovery.DiscoveryClient
+
+	mu     sync.Mutex
+	mapper meta.RESTMapper
+}
+
+// Get returns the live state of the referenced resource
+func (c *DynamicClient) Get(ctx context.Context, ref ObjectRef) (*unstructured.Unstructured, error) {
+	var object unstructured.Unstructured
+	if err := c.GetInto(ctx, ref, &object); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &object, nil
+}
+
+// GetInto decodes the live state of the referenced resource into out,
+// either a typed object, e.g. *v1.Pod, or *unstructured.Unstructured
+func (c *DynamicClient) GetInto(ctx context.Context, ref ObjectRef, out interface{}) error {
+	resourcePath, err := c.resourcePath(ref)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data, err := c.client.Get().AbsPath(resourcePath).Context(ctx).Do().Raw()
+	if err != nil {
+		return ConvertErrorWithContext(err, "failed to get %v", ref)
+	}
+	return trace.Wrap(json.Unmarshal(data, out))
+}
+
+// Apply creates the object or updates the live object with it like kubectl apply:
+// the fields of the object are set with a JSON merge patch, and the fields
+// of the last applied configuration missing from the object are removed,
+// while the fields set by other clients, e.g. controllers, are left intact.
+// The last applied configuration is recorded in LastAppliedAnnotation,
+// so objects applied with kubectl and with the dynamic client can be mixed
+func (c *DynamicClient) Apply(ctx context.Context, object *unstructured.Unstructured) error {
+	ref := ObjectRefFor(object)
+	live, err := c.Get(ctx, ref)
+	if err != nil && !trace.IsNotFound(err) {
+		return trace.Wrap(err)
+	}
+	object, err = withLastApplied(object)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if live == nil {
+		log.Debugf("create %v", ref)
+		ref.Name = ""
+		collectionPath, err := c.resourcePath(ref)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		data, err := object.MarshalJSON()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		err = c.client.Post().AbsPath(collectionPath).Context(ctx).Body(data).Do().Error()
+		return ConvertErrorWithContext(err, "failed to create %v", ref)
+	}
+	log.Debugf("update %v", ref)
+	var original map[string]interface{}
+	if lastApplied := live.GetAnnotations()[LastAppliedAnnotation]; lastApplied != "" {
+		if err := json.Unmarshal([]byte(lastApplied), &original); err != nil {
+			log.Warningf("ignoring invalid last applied configuration of %v: %v", ref, err)
+			original = nil
+		}
+	}
+	patch := applyPatch(original, object.Object)
+	// fail instead of overwriting the changes made since the live object was read
+	err = unstructured.SetNestedField(patch, live.GetResourceVersion(), "metadata", "resourceVersion")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(c.Patch(ctx, ref, data))
+}
+
+// LastAppliedAnnotation records the last applied configuration of an object,
+// it is shared with kubectl apply
+const LastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// withLastApplied returns a copy of the object without the resource version
+// and with the last applied configuration annotation set to the object
+func withLastApplied(object *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	object = object.DeepCopy()
+	unstructured.RemoveNestedField(object.Object, "metadata", "resourceVersion")
+	annotations := object.GetAnnotations()
+	if _, ok := annotations[LastAppliedAnnotation]; ok {
+		delete(annotations, LastAppliedAnnotation)
+		object.SetAnnotations(annotations)
+	}
+	if len(annotations) == 0 {
+		unstructured.RemoveNestedField(object.Object, "metadata", "annotations")
+	}
+	data, err := object.MarshalJSON()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[LastAppliedAnnotation] = string(bytes.TrimSpace(data))
+	object.SetAnnotations(annotations)
+	return object, nil
+}
+
+// applyPatch returns the JSON merge patch setting the fields of the modified
+// object and removing the fields of the original object missing from it.
+// Lists are replaced as a whole, as JSON merge patches do not merge lists
+func applyPatch(original, modified map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{}, len(modified))
+	for key := range original {
+		if _, ok := modified[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, value := range modified {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			patch[key] = value
+			continue
+		}
+		originalFields, _ := original[key].(map[string]interface{})
+		patch[key] = applyPatch(originalFields, fields)
+	}
+	return patch
+}
+
+// Delete deletes the referenced resource with foreground propagation
+func (c *DynamicClient) Delete(ctx context.Context, ref ObjectRef) error {
+	resourcePath, err := c.resourcePath(ref)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	policy := metav1.DeletePropagationForeground
+	options, err := json.Marshal(metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	err = c.client.Delete().AbsPath(resourcePath).Context(ctx).Body(options).Do().Error()
+	err = ConvertErrorWithContext(err, "failed to delete %v", ref)
+	if err != nil && !trace.IsNotFound(err) {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// Patch applies the JSON merge patch to the referenced resource
+func (c *DynamicClient) Patch(ctx context.Context, ref ObjectRef, patch []byte) error {
+	resourcePath, err := c.resourcePath(ref)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	err = c.client.Patch(types.MergePatchType).AbsPath(resourcePath).Context(ctx).Body(patch).Do().Error()
+	if apierrors.IsConflict(err) {
+		return trace.CompareFailed("failed to patch %v: %v", ref, err)
+	}
+	return ConvertErrorWithContext(err, "failed to patch %v", ref)
+}
+
+// List returns the resources of the kind in the namespace,
+// resources in all namespaces if the namespace is empty
+func (c *DynamicClient) List(ctx context.Context, gvk schema.GroupVersionKind, namespace string) ([]unstructured.Unstructured, error) {
+	var list unstructured.UnstructuredList
+	err := c.ListInto(ctx, gvk, ListOptions{Namespace: namespace, AllNamespaces: namespace == ""}, &list)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return list.Items, nil
+}
+
+// ListOptions selects the resources returned by ListInto
+type ListOptions struct {
+	// Namespace is the namespace of the resources, defaults to the default namespace
+	Namespace string
+	// AllNamespaces lists the resources in all namespaces, Namespace is ignored
+	AllNamespaces bool
+	// LabelSelector selects the resources by labels, e.g. app=web,tier!=cache
+	LabelSelector string
+	// FieldSelector selects the resources by fields, e.g. spec.nodeName=node-1
+	FieldSelector string
+}
+
+// ListInto decodes the resources of the kind selected by the options into out,
+// either a typed list, e.g. *v1.PodList, or *unstructured.UnstructuredList
+func (c *DynamicClient) ListInto(ctx context.Context, gvk schema.GroupVersionKind, options ListOptions, out interface{}) error {
+	namespace := Namespace(options.Namespace)
+	if options.AllNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+	collectionPath, err := c.collectionPath(gvk, namespace)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	request := c.client.Get().AbsPath(collectionPath).Context(ctx)
+	if options.LabelSelector != "" {
+		request = request.Param("labelSelector", options.LabelSelector)
+	}
+	if options.FieldSelector != "" {
+		request = request.Param("fieldSelector", options.FieldSelector)
+	}
+	data, err := request.Do().Raw()
+	if err != nil {
+		return ConvertErrorWithContext(err, "failed to list %v", gvk.Kind)
+	}
+	return trace.Wrap(json.Unmarshal(data, out))
+}
+
+// DetectDrift compares the bundle resources against the live cluster state,
+// see DetectDrift
+func (c *DynamicClient) DetectDrift(ctx context.Context, bundle *Bundle) ([]Drift, error) {
+	return detectDrift(ctx, c, bundle)
+}
+
+// WaitReady waits for all resources of the bundle to become ready, see WaitReady
+func (c *DynamicClient) WaitReady(ctx context.Context, bundle *Bundle, retryAttempts int, retryPeriod time.Duration) error {
+	return waitReady(ctx, c, bundle, retryAttempts, retryPeriod)
+}
+
+// resourcePath returns the REST API path of the referenced resource,
+// or of the resource collection if the name is empty
+func (c *DynamicClient) resourcePath(ref ObjectRef) (string, error) {
+	collectionPath, err := c.collectionPath(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind), Namespace(ref.Namespace))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if ref.Name == "" {
+		return collectionPath, nil
+	}
+	return path.Join(collectionPath, ref.Name), nil
+}
+
+// collectionPath returns the REST API path of the collection of resources
+// of the kind in the namespace, resources in all namespaces if the namespace is empty
+func (c *DynamicClient) collectionPath(gvk schema.GroupVersionKind, namespace string) (string, error) {
+	mapping, err := c.mapping(gvk)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	parts := []string{"/apis", gvk.Group, gvk.Version}
+	if gvk.Group == "" {
+		parts = []string{"/api", gvk.Version}
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace && namespace != "" {
+		parts = append(parts, "namespaces", namespace)
+	}
+	parts = append(parts, mapping.Resource.Resource)
+	return path.Join(parts...), nil
+}
+
+// mapping returns the REST mapping of the kind, the discovery
+// is refreshed once if the kind is not known
+func (c *DynamicClient) mapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mapper != nil {
+		mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err == nil {
+			return mapping, nil
+		}
+		if !meta.IsNoMatchError(err) {
+			return nil, trace.Wrap(err)
+		}
+	}
+	mapper, err := c.discoverMapper()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	c.mapper = mapper
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, trace.NotFound("%v is not served by the API server", gvk)
+		}
+		return nil, trace.Wrap(err)
+	}
+	return mapping, nil
+}
+
+// discoverMapper builds the REST mapper from the resources served by the API server
+func (c *DynamicClient) discoverMapper() (meta.RESTMapper, error) {
+	lists, err := c.discovery.ServerResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) || len(lists) == 0 {
+			return nil, ConvertError(err)
+		}
+		log.Warningf("partial API discovery: %v", err)
+	}
+	var versions []schema.GroupVersion
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		versions = append(versions, gv)
+	}
+	mapper := meta.NewDefaultRESTMapper(versions)
+	for _, list := range lists {
+		gv, _ := schema.ParseGroupVersion(list.GroupVersion)
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				// subresource
+				continue
+			}
+			scope := meta.RESTScopeRoot
+			if resource.Namespaced {
+				scope = meta.RESTScopeNamespace
+			}
+			singular := resource.SingularName
+			if singular == "" {
+				singular = strings.ToLower(resource.Kind)
+			}
+			mapper.AddSpecific(gv.WithKind(resource.Kind), gv.WithResource(resource.Name), gv.WithResource(singular), scope)
+		}
+	}
+	return mapper, nil
+}
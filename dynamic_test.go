@@ -0,0 +1,147 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+type DynamicSuite struct{}
+
+var _ = Suite(&DynamicSuite{})
+
+func (s *DynamicSuite) TestGetAndList(c *C) {
+	var requests []url.URL
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			w.Write([]byte(`{"kind": "APIVersions", "versions": ["v1"]}`))
+		case "/apis":
+			w.Write([]byte(`{"kind": "APIGroupList", "groups": []}`))
+		case "/api/v1":
+			w.Write([]byte(`{"kind": "APIResourceList", "groupVersion": "v1", "resources": [
+				{"name": "pods", "singularName": "pod", "namespaced": true, "kind": "Pod", "verbs": ["get", "list"]}]}`))
+		case "/api/v1/namespaces/kube-system/pods/dns":
+			w.Write([]byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "dns", "namespace": "kube-system"},
+				"spec": {"nodeName": "node-1"}}`))
+		case "/api/v1/pods", "/api/v1/namespaces/default/pods":
+			requests = append(requests, *r.URL)
+			w.Write([]byte(`{"apiVersion": "v1", "kind": "PodList", "items": [
+				{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "dns", "namespace": "kube-system"}}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewDynamicClient(DynamicConfig{Config: &rest.Config{Host: server.URL}})
+	c.Assert(err, IsNil)
+	ctx := context.TODO()
+
+	var pod v1.Pod
+	ref := ObjectRef{APIVersion: V1, Kind: KindPod, Namespace: "kube-system", Name: "dns"}
+	c.Assert(client.GetInto(ctx, ref, &pod), IsNil)
+	c.Assert(pod.Spec.NodeName, Equals, "node-1")
+	object, err := client.Get(ctx, ref)
+	c.Assert(err, IsNil)
+	c.Assert(object.GetNamespace(), Equals, "kube-system")
+
+	gvk := v1.SchemeGroupVersion.WithKind(KindPod)
+	var pods v1.PodList
+	err = client.ListInto(ctx, gvk, ListOptions{
+		AllNamespaces: true,
+		LabelSelector: "app=dns",
+		FieldSelector: "spec.nodeName=node-1",
+	}, &pods)
+	c.Assert(err, IsNil)
+	c.Assert(pods.Items, HasLen, 1)
+	c.Assert(pods.Items[0].Name, Equals, "dns")
+
+	var list unstructured.UnstructuredList
+	c.Assert(client.ListInto(ctx, gvk, ListOptions{}, &list), IsNil)
+	c.Assert(list.Items, HasLen, 1)
+
+	c.Assert(requests, HasLen, 2)
+	c.Assert(requests[0].Path, Equals, "/api/v1/pods")
+	c.Assert(requests[0].Query().Get("labelSelector"), Equals, "app=dns")
+	c.Assert(requests[0].Query().Get("fieldSelector"), Equals, "spec.nodeName=node-1")
+	c.Assert(requests[1].Path, Equals, "/api/v1/namespaces/default/pods")
+}
+
+func (s *DynamicSuite) TestApply(c *C) {
+	var patches []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			w.Write([]byte(`{"kind": "APIVersions", "versions": ["v1"]}`))
+		case "/apis":
+			w.Write([]byte(`{"kind": "APIGroupList", "groups": []}`))
+		case "/api/v1":
+			w.Write([]byte(`{"kind": "APIResourceList", "groupVersion": "v1", "resources": [
+				{"name": "configmaps", "singularName": "configmap", "namespaced": true, "kind": "ConfigMap", "verbs": ["get", "patch"]}]}`))
+		case "/api/v1/namespaces/default/configmaps/app":
+			if r.Method == http.MethodPatch {
+				c.Assert(r.Header.Get("Content-Type"), Equals, string(types.MergePatchType))
+				var patch map[string]interface{}
+				c.Assert(json.NewDecoder(r.Body).Decode(&patch), IsNil)
+				patches = append(patches, patch)
+			}
+			w.Write([]byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "app", "namespace": "default",
+				"resourceVersion": "7", "annotations": {"owner": "controller",
+				"kubectl.kubernetes.io/last-applied-configuration": "{\"apiVersion\":\"v1\",\"kind\":\"ConfigMap\",\"metadata\":{\"name\":\"app\",\"namespace\":\"default\"},\"data\":{\"old\":\"1\",\"port\":\"80\"}}"}},
+				"data": {"old": "1", "port": "80", "added": "by controller"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewDynamicClient(DynamicConfig{Config: &rest.Config{Host: server.URL}})
+	c.Assert(err, IsNil)
+	object, err := DecodeObjects([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: default
+data:
+  port: "8080"
+`))
+	c.Assert(err, IsNil)
+	c.Assert(client.Apply(context.TODO(), object[0]), IsNil)
+
+	c.Assert(patches, HasLen, 1)
+	patch := patches[0]
+	c.Assert(patch["data"], DeepEquals, map[string]interface{}{"old": nil, "port": "8080"})
+	resourceVersion, _, err := unstructured.NestedString(patch, "metadata", "resourceVersion")
+	c.Assert(err, IsNil)
+	c.Assert(resourceVersion, Equals, "7")
+	lastApplied, _, err := unstructured.NestedString(patch, "metadata", "annotations", LastAppliedAnnotation)
+	c.Assert(err, IsNil)
+	c.Assert(lastApplied, Equals, `{"apiVersion":"v1","data":{"port":"8080"},"kind":"ConfigMap","metadata":{"name":"app","namespace":"default"}}`)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// EventComponent is the source component of events posted by rigging
+	EventComponent = "rigging"
+	// ReasonRetry is the reason of events posted when an operation is retried
+	ReasonRetry = "RiggingRetry"
+)
+
+// EventRecorder posts events about rigging operations on the managed
+// objects, so that kubectl describe shows them alongside controller events.
+// Posting events is best-effort, failures are logged.
+// A nil recorder does not post events
+type EventRecorder struct {
+	// Client is k8s client
+	Client *kubernetes.Clientset
+	// Host is the host reported as the event source
+	Host string
+}
+
+// NewEventRecorder returns a new event recorder posting events with the client
+func NewEventRecorder(client *kubernetes.Clientset) *EventRecorder {
+	host, _ := os.Hostname()
+	return &EventRecorder{Client: client, Host: host}
+}
+
+// Eventf posts an event of the type, e.g. v1.EventTypeNormal, on the referenced object
+func (r *EventRecorder) Eventf(ref v1.ObjectReference, eventType, reason, format string, args ...interface{}) {
+	if r == nil || r.Client == nil {
+		return
+	}
+	now := metav1.NewTime(time.Now())
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        fmt.Sprintf(format, args...),
+		Source: v1.EventSource{
+			Component: EventComponent,
+			Host:      r.Host,
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	_, err := r.Client.CoreV1().Events(namespace).Create(event)
+	if err != nil {
+		log.Debugf("failed to post event %v on %v %v: %v", reason, ref.Kind, ref.Name, ConvertError(err))
+	}
+}
+
+// Record posts a normal event if the action, e.g. "Upsert", succeeded,
+// a warning with the error otherwise
+func (r *EventRecorder) Record(ref v1.ObjectReference, action string, err error) {
+	if err != nil {
+		r.Eventf(ref, v1.EventTypeWarning, fmt.Sprintf("Rigging%vFailed", action), "%v failed: %v", action, err)
+		return
+	}
+	r.Eventf(ref, v1.EventTypeNormal, fmt.Sprintf("Rigging%v", action), "%v succeeded", action)
+}
+
+// objectReference returns the reference to the object with the metadata
+func objectReference(apiVersion, kind string, meta metav1.ObjectMeta) v1.ObjectReference {
+	return v1.ObjectReference{
+		APIVersion:      apiVersion,
+		Kind:            kind,
+		Namespace:       Namespace(meta.Namespace),
+		Name:            meta.Name,
+		UID:             meta.UID,
+		ResourceVersion: meta.ResourceVersion,
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// DefaultEvictionParallelism is the default number of pods evicted concurrently
+	DefaultEvictionParallelism = 4
+	// evictionRetryPeriod is the period between eviction attempts
+	// of a pod blocked by a disruption budget
+	evictionRetryPeriod = 5 * time.Second
+)
+
+// EvictionConfig configures bulk pod eviction
+type EvictionConfig struct {
+	// Parallelism is the maximum number of pods evicted concurrently
+	Parallelism int
+	// Timeout limits waiting for a pod blocked by a disruption budget
+	Timeout time.Duration
+	// DeleteOnTimeout deletes a pod directly once its eviction has been
+	// blocked by a disruption budget for Timeout instead of failing
+	DeleteOnTimeout bool
+	// DisableEviction deletes pods directly, bypassing disruption budgets
+	DisableEviction bool
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *EvictionConfig) CheckAndSetDefaults() error {
+	if c.Parallelism < 0 {
+		return trace.BadParameter("parameter Parallelism should be positive")
+	}
+	if c.Parallelism == 0 {
+		c.Parallelism = DefaultEvictionParallelism
+	}
+	if c.Timeout == 0 {
+		c.Timeout = deleteTimeout
+	}
+	return nil
+}
+
+// EvictPods evicts the pods in order of ascending priority: pods with the same
+// priority are evicted concurrently and higher priority pods are only evicted
+// once all lower priority pods are gone. Evictions go through the eviction API,
+// so pod disruption budgets are respected
+func EvictPods(ctx context.Context, podIface corev1.PodInterface, pods []v1.Pod, config EvictionConfig, entry *log.Entry) error {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	for _, group := range groupPodsByPriority(pods) {
+		if err := ctx.Err(); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := evictGroup(ctx, podIface, group, config, entry); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := waitForPodsList(podIface, group, *entry); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// evictGroup evicts pods with at most config.Parallelism evictions in flight
+func evictGroup(ctx context.Context, podIface corev1.PodInterface, pods []v1.Pod, config EvictionConfig, entry *log.Entry) error {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		errs      []error
+		semaphore = make(chan struct{}, config.Parallelism)
+	)
+	for _, pod := range pods {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return trace.Wrap(ctx.Err())
+		}
+		wg.Add(1)
+		go func(pod v1.Pod) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := evictPod(ctx, podIface, pod, config, entry); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(pod)
+	}
+	wg.Wait()
+	return trace.NewAggregate(errs...)
+}
+
+// evictPod evicts the pod retrying while a disruption budget does not allow it
+func evictPod(ctx context.Context, podIface corev1.PodInterface, pod v1.Pod, config EvictionConfig, entry *log.Entry) error {
+	entry.Debugf("evicting pod %v with priority %v", pod.Name, podPriority(pod))
+	if config.DisableEviction {
+		return trace.Wrap(deletePod(podIface, pod))
+	}
+	timeout := time.NewTimer(config.Timeout)
+	defer timeout.Stop()
+	for {
+		err := podIface.Evict(&policy.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		})
+		if !errors.IsTooManyRequests(err) {
+			err = ConvertError(err)
+			if err != nil && !trace.IsNotFound(err) {
+				return trace.Wrap(err)
+			}
+			return nil
+		}
+		entry.Infof("eviction of pod %v is blocked by a disruption budget, retrying", pod.Name)
+		select {
+		case <-time.After(evictionRetryPeriod):
+		case <-timeout.C:
+			if !config.DeleteOnTimeout {
+				return trace.LimitExceeded("timed out evicting pod %v: %v", pod.Name, err)
+			}
+			entry.Warningf("eviction of pod %v has been blocked by a disruption budget for %v, deleting it", pod.Name, config.Timeout)
+			return trace.Wrap(deletePod(podIface, pod))
+		case <-ctx.Done():
+			return trace.Wrap(ctx.Err())
+		}
+	}
+}
+
+// deletePod deletes the pod bypassing disruption budgets
+func deletePod(podIface corev1.PodInterface, pod v1.Pod) error {
+	err := ConvertError(podIface.Delete(pod.Name, nil))
+	if err != nil && !trace.IsNotFound(err) {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// groupPodsByPriority groups pods by priority, lowest priority first
+func groupPodsByPriority(pods []v1.Pod) [][]v1.Pod {
+	sorted := append([]v1.Pod(nil), pods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return podPriority(sorted[i]) < podPriority(sorted[j])
+	})
+	var groups [][]v1.Pod
+	for i, pod := range sorted {
+		if i == 0 || podPriority(pod) != podPriority(sorted[i-1]) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], pod)
+	}
+	return groups
+}
+
+func podPriority(pod v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
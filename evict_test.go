@@ -0,0 +1,110 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type EvictSuite struct{}
+
+var _ = Suite(&EvictSuite{})
+
+func (s *EvictSuite) TestEvictPods(c *C) {
+	priority := func(name string, value int32) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       v1.PodSpec{Priority: &value},
+		}
+	}
+	pods := []v1.Pod{priority("critical", 1000), priority("batch", 0), priority("web", 100)}
+
+	podIface := newFakePods(pods)
+	err := EvictPods(context.TODO(), podIface, pods, EvictionConfig{}, log.NewEntry(log.StandardLogger()))
+	c.Assert(err, IsNil)
+	c.Assert(podIface.evicted, DeepEquals, []string{"batch", "web", "critical"})
+	c.Assert(podIface.deleted, IsNil)
+
+	// eviction is blocked by a disruption budget
+	podIface = newFakePods(pods[:1])
+	podIface.blocked = true
+	err = EvictPods(context.TODO(), podIface, pods[:1], EvictionConfig{Timeout: time.Millisecond}, log.NewEntry(log.StandardLogger()))
+	c.Assert(err, ErrorMatches, "(?s)timed out evicting pod critical.*")
+	c.Assert(podIface.deleted, IsNil)
+
+	err = EvictPods(context.TODO(), podIface, pods[:1], EvictionConfig{Timeout: time.Millisecond, DeleteOnTimeout: true}, log.NewEntry(log.StandardLogger()))
+	c.Assert(err, IsNil)
+	c.Assert(podIface.deleted, DeepEquals, []string{"critical"})
+}
+
+// fakePods is a pod client evicting and deleting pods in memory
+type fakePods struct {
+	corev1.PodInterface
+	sync.Mutex
+	pods map[string]bool
+	// blocked rejects evictions like a disruption budget
+	blocked bool
+	evicted []string
+	deleted []string
+}
+
+func newFakePods(pods []v1.Pod) *fakePods {
+	f := &fakePods{pods: make(map[string]bool)}
+	for _, pod := range pods {
+		f.pods[pod.Name] = true
+	}
+	return f
+}
+
+func (f *fakePods) Get(name string, options metav1.GetOptions) (*v1.Pod, error) {
+	f.Lock()
+	defer f.Unlock()
+	if !f.pods[name] {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "pods"}, name)
+	}
+	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+func (f *fakePods) Evict(eviction *policy.Eviction) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.blocked {
+		return errors.NewTooManyRequests("cannot evict pod as it would violate the pod's disruption budget", 0)
+	}
+	delete(f.pods, eviction.Name)
+	f.evicted = append(f.evicted, eviction.Name)
+	return nil
+}
+
+func (f *fakePods) Delete(name string, options *metav1.DeleteOptions) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.pods, name)
+	f.deleted = append(f.deleted, name)
+	return nil
+}
@@ -0,0 +1,334 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// FailureMode defines whether applying a manifest stream stops
+// at the first resource that fails
+type FailureMode string
+
+const (
+	// FailFast stops at the first resource that fails to apply
+	FailFast FailureMode = "fail-fast"
+	// ContinueAndReport applies all resources and returns an aggregate
+	// error with the outcome of each resource
+	ContinueAndReport FailureMode = "continue"
+)
+
+// ParseFailureMode parses the failure mode, returns BadParameter if the mode is not supported
+func ParseFailureMode(in string) (FailureMode, error) {
+	mode := FailureMode(in)
+	if err := mode.Check(); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return mode, nil
+}
+
+// Check returns BadParameter if the failure mode is not supported
+func (m FailureMode) Check() error {
+	switch m {
+	case FailFast, ContinueAndReport:
+		return nil
+	}
+	return trace.BadParameter("unsupported failure mode %q", string(m))
+}
+
+// FailurePolicy governs how failures of individual resources are handled
+// when a manifest stream is applied
+type FailurePolicy struct {
+	// Mode is the failure mode, FailFast if unset
+	Mode FailureMode
+	// IgnoreKinds lists kinds of the resources whose failures are logged
+	// and ignored regardless of the mode, case-insensitive
+	IgnoreKinds []string
+	// QuotaWait is how long creations rejected because a resource quota
+	// is exhausted are deferred and retried before they fail,
+	// DefaultQuotaWait if unset, negative disables the retries
+	QuotaWait time.Duration
+}
+
+// CheckAndSetDefaults validates the policy and sets defaults
+func (p *FailurePolicy) CheckAndSetDefaults() error {
+	if p.Mode == "" {
+		p.Mode = FailFast
+	}
+	if p.QuotaWait == 0 {
+		p.QuotaWait = DefaultQuotaWait
+	}
+	return trace.Wrap(p.Mode.Check())
+}
+
+// ignores returns true if the failures of the resources of the kind are ignored
+func (p FailurePolicy) ignores(kind string) bool {
+	return matchesKind(p.IgnoreKinds, kind)
+}
+
+// OutcomeStatus is the result of applying a resource
+type OutcomeStatus string
+
+const (
+	// OutcomeApplied means the resource has been applied
+	OutcomeApplied OutcomeStatus = "applied"
+	// OutcomeFailed means the resource failed to apply
+	OutcomeFailed OutcomeStatus = "failed"
+	// OutcomeIgnored means the resource failed to apply,
+	// but the failure is ignored by the policy
+	OutcomeIgnored OutcomeStatus = "ignored"
+)
+
+// ResourceOutcome is the outcome of applying a single resource
+type ResourceOutcome struct {
+	// Ref references the resource
+	Ref ObjectRef
+	// Status is the result of applying the resource
+	Status OutcomeStatus
+	// Error is the failure, if any
+	Error error
+}
+
+// String returns a human readable outcome
+func (o ResourceOutcome) String() string {
+	if o.Error == nil {
+		return fmt.Sprintf("%v: %v", o.Ref, o.Status)
+	}
+	return fmt.Sprintf("%v: %v: %v", o.Ref, o.Status, o.Error)
+}
+
+// ApplyError is returned when some of the resources of a manifest stream
+// failed to apply with ContinueAndReport failure mode
+type ApplyError struct {
+	// Outcomes lists the outcomes of all resources in order
+	Outcomes []ResourceOutcome
+}
+
+// Failed returns the outcomes of the resources that failed to apply
+func (e *ApplyError) Failed() []ResourceOutcome {
+	var out []ResourceOutcome
+	for _, outcome := range e.Outcomes {
+		if outcome.Status == OutcomeFailed {
+			out = append(out, outcome)
+		}
+	}
+	return out
+}
+
+// Error returns the failures of all resources that failed to apply
+func (e *ApplyError) Error() string {
+	failed := e.Failed()
+	messages := make([]string, 0, len(failed))
+	for _, outcome := range failed {
+		messages = append(messages, outcome.String())
+	}
+	return fmt.Sprintf("%v of %v resources failed to apply: %v",
+		len(failed), len(e.Outcomes), strings.Join(messages, "; "))
+}
+
+// RollbackError is returned when a changeset has been reverted
+// after a failure to apply or to become ready
+type RollbackError struct {
+	// Err is the failure that triggered the rollback
+	Err error
+	// RevertErr is the error of the rollback, nil if the rollback succeeded
+	RevertErr error
+}
+
+// Error returns the failure and the outcome of the rollback
+func (e *RollbackError) Error() string {
+	if e.RevertErr != nil {
+		return fmt.Sprintf("%v, rollback failed: %v", e.Err, e.RevertErr)
+	}
+	return fmt.Sprintf("%v, changeset has been rolled back", e.Err)
+}
+
+// IsRolledBack returns true if the error indicates that the changeset
+// has been successfully reverted after a failure
+func IsRolledBack(err error) bool {
+	e, ok := trace.Unwrap(err).(*RollbackError)
+	return ok && e.RevertErr == nil
+}
+
+// IsRollbackFailed returns true if the error indicates that the changeset
+// could not be reverted after a failure
+func IsRollbackFailed(err error) bool {
+	e, ok := trace.Unwrap(err).(*RollbackError)
+	return ok && e.RevertErr != nil
+}
+
+// OperationPhase is the phase of a changeset operation
+type OperationPhase string
+
+const (
+	// OpPhaseApply is the creation, update or deletion of the resource
+	OpPhaseApply OperationPhase = "apply"
+	// OpPhaseWait is the wait for the resource to become ready
+	OpPhaseWait OperationPhase = "wait"
+	// OpPhaseRollback is the revert of the operation
+	OpPhaseRollback OperationPhase = "rollback"
+)
+
+// OperationError is the failure of a changeset operation on a resource
+type OperationError struct {
+	// Ref references the resource of the operation
+	Ref ObjectRef
+	// Phase is the phase of the operation that failed
+	Phase OperationPhase
+	// Err is the failure
+	Err error
+}
+
+// Error returns the phase, the resource and the failure
+func (e OperationError) Error() string {
+	return fmt.Sprintf("%v %v: %v", e.Phase, e.Ref, e.Err)
+}
+
+// ChangesetError is returned when operations of a changeset fail,
+// it lists the failures with the resources and the phases they failed in,
+// so callers do not have to parse the error messages.
+// An error with a single failure unwraps to the failure with trace.Unwrap,
+// so that the failure can still be checked with trace.IsNotFound and alike.
+// Use AsChangesetError to retrieve the error from the returned errors
+type ChangesetError struct {
+	// Namespace is the namespace of the changeset
+	Namespace string
+	// Name is the name of the changeset
+	Name string
+	// Operations lists the failed operations
+	Operations []OperationError
+	// message is the user message added to the error
+	message string
+}
+
+// newChangesetError returns the error of the operation on the resource of the changeset
+func newChangesetError(namespace, name string, ref ObjectRef, phase OperationPhase, err error) error {
+	return &ChangesetError{
+		Namespace:  Namespace(namespace),
+		Name:       name,
+		Operations: []OperationError{{Ref: ref, Phase: phase, Err: err}},
+	}
+}
+
+// Errors returns the failed operations
+func (e *ChangesetError) Errors() []OperationError {
+	return e.Operations
+}
+
+// Failed returns the resources of the failed operations
+func (e *ChangesetError) Failed() []ObjectRef {
+	refs := make([]ObjectRef, 0, len(e.Operations))
+	seen := make(map[string]bool, len(e.Operations))
+	for _, op := range e.Operations {
+		if !seen[op.Ref.key()] {
+			seen[op.Ref.key()] = true
+			refs = append(refs, op.Ref)
+		}
+	}
+	return refs
+}
+
+// Error returns the failures of the operations
+func (e *ChangesetError) Error() string {
+	var message string
+	if len(e.Operations) == 1 {
+		message = e.Operations[0].Error()
+	} else {
+		messages := make([]string, 0, len(e.Operations))
+		for _, op := range e.Operations {
+			messages = append(messages, op.Error())
+		}
+		message = fmt.Sprintf("%v operations of changeset %v/%v failed: %v",
+			len(e.Operations), e.Namespace, e.Name, strings.Join(messages, "; "))
+	}
+	if e.message != "" {
+		return fmt.Sprintf("%v, %v", e.message, message)
+	}
+	return message
+}
+
+// OrigError returns the failure if there is a single failed operation
+func (e *ChangesetError) OrigError() error {
+	if len(e.Operations) == 1 {
+		return trace.Unwrap(e.Operations[0].Err)
+	}
+	return e
+}
+
+// AddUserMessage adds the message to the error
+func (e *ChangesetError) AddUserMessage(formatArg interface{}, rest ...interface{}) {
+	message := fmt.Sprintf(fmt.Sprintf("%v", formatArg), rest...)
+	if e.message != "" {
+		message = e.message + ", " + message
+	}
+	e.message = message
+}
+
+// UserMessage returns the failures of the operations
+func (e *ChangesetError) UserMessage() string {
+	return e.Error()
+}
+
+// DebugReport returns the debug reports of the failures
+func (e *ChangesetError) DebugReport() string {
+	reports := make([]string, 0, len(e.Operations))
+	for _, op := range e.Operations {
+		reports = append(reports, fmt.Sprintf("%v %v: %v", op.Phase, op.Ref, trace.DebugReport(op.Err)))
+	}
+	return strings.Join(reports, "\n")
+}
+
+// AsChangesetError returns the breakdown of the failed operations of the error
+// returned by the changeset operations. Failures of ApplyError and both
+// the failure and the rollback failure of RollbackError are included.
+// Returns false if the error does not describe failed operations
+func AsChangesetError(err error) (*ChangesetError, bool) {
+	for i := 0; i < maxErrorHops && err != nil; i++ {
+		switch e := err.(type) {
+		case *ChangesetError:
+			return e, true
+		case *ApplyError:
+			out := &ChangesetError{}
+			for _, outcome := range e.Failed() {
+				out.Operations = append(out.Operations, OperationError{Ref: outcome.Ref, Phase: OpPhaseApply, Err: outcome.Error})
+			}
+			return out, true
+		case *RollbackError:
+			out, ok := AsChangesetError(e.Err)
+			if !ok {
+				return nil, false
+			}
+			out = &ChangesetError{Namespace: out.Namespace, Name: out.Name, Operations: out.Operations}
+			if revertErr, ok := AsChangesetError(e.RevertErr); ok {
+				out.Operations = append(out.Operations, revertErr.Operations...)
+			}
+			return out, true
+		case *trace.TraceErr:
+			err = e.Err
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// maxErrorHops limits the depth of the wrapped errors AsChangesetError inspects
+const maxErrorHops = 50
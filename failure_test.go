@@ -0,0 +1,96 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type FailureSuite struct{}
+
+var _ = Suite(&FailureSuite{})
+
+func (s *FailureSuite) TestApplyError(c *C) {
+	err := &ApplyError{Outcomes: []ResourceOutcome{
+		{Ref: ObjectRef{Kind: KindConfigMap, Namespace: "kube-system", Name: "config"}, Status: OutcomeApplied},
+		{Ref: ObjectRef{Kind: KindDeployment, Namespace: "kube-system", Name: "api"}, Status: OutcomeFailed, Error: fmt.Errorf("invalid spec")},
+		{Ref: ObjectRef{Kind: KindJob, Namespace: "kube-system", Name: "migrate"}, Status: OutcomeIgnored, Error: fmt.Errorf("timeout")},
+	}}
+	c.Assert(err.Failed(), HasLen, 1)
+	c.Assert(err.Error(), Equals, "1 of 3 resources failed to apply: Deployment/kube-system/api: failed: invalid spec")
+}
+
+func (s *FailureSuite) TestPolicy(c *C) {
+	var policy FailurePolicy
+	c.Assert(policy.CheckAndSetDefaults(), IsNil)
+	c.Assert(policy.Mode, Equals, FailFast)
+
+	policy = FailurePolicy{Mode: "abort"}
+	c.Assert(policy.CheckAndSetDefaults(), NotNil)
+
+	policy = FailurePolicy{Mode: ContinueAndReport, IgnoreKinds: []string{"job"}}
+	c.Assert(policy.CheckAndSetDefaults(), IsNil)
+	c.Assert(policy.ignores(KindJob), Equals, true)
+	c.Assert(policy.ignores(KindDeployment), Equals, false)
+}
+
+func (s *FailureSuite) TestRollbackError(c *C) {
+	cause := trace.CompareFailed("deployment api is not ready")
+	err := trace.Wrap(&RollbackError{Err: cause})
+	c.Assert(IsRolledBack(err), Equals, true)
+	c.Assert(IsRollbackFailed(err), Equals, false)
+	c.Assert(err.Error(), Equals, "deployment api is not ready, changeset has been rolled back")
+
+	err = trace.Wrap(&RollbackError{Err: cause, RevertErr: trace.ConnectionProblem(nil, "connection refused")})
+	c.Assert(IsRolledBack(err), Equals, false)
+	c.Assert(IsRollbackFailed(err), Equals, true)
+
+	c.Assert(IsRolledBack(cause), Equals, false)
+	c.Assert(IsRollbackFailed(cause), Equals, false)
+}
+
+func (s *FailureSuite) TestChangesetError(c *C) {
+	ref := ObjectRef{Kind: KindDeployment, Namespace: "kube-system", Name: "api"}
+	err := trace.Wrap(newChangesetError("", "upgrade", ref, OpPhaseWait, trace.NotFound("deployment api not found")))
+	c.Assert(trace.IsNotFound(err), Equals, true)
+	c.Assert(err.Error(), Equals, "wait Deployment/kube-system/api: deployment api not found")
+	csErr, ok := AsChangesetError(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(csErr.Namespace, Equals, DefaultNamespace)
+	c.Assert(csErr.Failed(), DeepEquals, []ObjectRef{ref})
+
+	revertErr := newChangesetError("", "upgrade", ref, OpPhaseRollback, fmt.Errorf("connection refused"))
+	csErr, ok = AsChangesetError(trace.Wrap(&RollbackError{Err: err, RevertErr: trace.Wrap(revertErr)}))
+	c.Assert(ok, Equals, true)
+	c.Assert(csErr.Failed(), DeepEquals, []ObjectRef{ref})
+	c.Assert(csErr.Errors(), HasLen, 2)
+	c.Assert(csErr.Errors()[1].Phase, Equals, OpPhaseRollback)
+	c.Assert(csErr.Error(), Equals, "2 operations of changeset default/upgrade failed: "+
+		"wait Deployment/kube-system/api: deployment api not found; rollback Deployment/kube-system/api: connection refused")
+
+	csErr, ok = AsChangesetError(&ApplyError{Outcomes: []ResourceOutcome{
+		{Ref: ref, Status: OutcomeFailed, Error: fmt.Errorf("invalid spec")},
+	}})
+	c.Assert(ok, Equals, true)
+	c.Assert(csErr.Errors(), DeepEquals, []OperationError{{Ref: ref, Phase: OpPhaseApply, Err: fmt.Errorf("invalid spec")}})
+
+	_, ok = AsChangesetError(trace.NotFound("not found"))
+	c.Assert(ok, Equals, false)
+}
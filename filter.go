@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ResourceFilter selects the resources of a manifest stream by kind,
+// name and labels, so that only a part of a bundle is applied.
+// Empty filter matches all resources
+type ResourceFilter struct {
+	// IncludeKinds lists kinds of the resources to keep, case-insensitive
+	IncludeKinds []string
+	// ExcludeKinds lists kinds of the resources to drop, case-insensitive
+	ExcludeKinds []string
+	// IncludeNames lists names or glob patterns of the resources to keep
+	IncludeNames []string
+	// ExcludeNames lists names or glob patterns of the resources to drop
+	ExcludeNames []string
+	// Selector selects resources to keep by labels
+	Selector labels.Selector
+	// ExcludeSelector selects resources to drop by labels
+	ExcludeSelector labels.Selector
+}
+
+// IsEmpty returns true if the filter matches all resources
+func (f ResourceFilter) IsEmpty() bool {
+	return len(f.IncludeKinds) == 0 && len(f.ExcludeKinds) == 0 &&
+		len(f.IncludeNames) == 0 && len(f.ExcludeNames) == 0 &&
+		(f.Selector == nil || f.Selector.Empty()) &&
+		(f.ExcludeSelector == nil || f.ExcludeSelector.Empty())
+}
+
+// Match returns true if the object passes the filter.
+// Exclusions take precedence over inclusions
+func (f ResourceFilter) Match(object *unstructured.Unstructured) bool {
+	kind, name := object.GetKind(), object.GetName()
+	set := labels.Set(object.GetLabels())
+	if matchesKind(f.ExcludeKinds, kind) || matchesName(f.ExcludeNames, name) {
+		return false
+	}
+	if f.ExcludeSelector != nil && !f.ExcludeSelector.Empty() && f.ExcludeSelector.Matches(set) {
+		return false
+	}
+	if len(f.IncludeKinds) != 0 && !matchesKind(f.IncludeKinds, kind) {
+		return false
+	}
+	if len(f.IncludeNames) != 0 && !matchesName(f.IncludeNames, name) {
+		return false
+	}
+	if f.Selector != nil && !f.Selector.Matches(set) {
+		return false
+	}
+	return true
+}
+
+// Transform drops the resources not matching the filter from the manifest stream
+func (f ResourceFilter) Transform(data []byte) ([]byte, error) {
+	if f.IsEmpty() {
+		return data, nil
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var matched []*unstructured.Unstructured
+	for _, object := range objects {
+		if f.Match(object) {
+			matched = append(matched, object)
+		}
+	}
+	return EncodeObjects(matched)
+}
+
+// filterManifests applies the optional filter to the manifest stream,
+// returns false if no resources are left
+func filterManifests(filter *ResourceFilter, data []byte) ([]byte, bool, error) {
+	if filter == nil {
+		return data, true, nil
+	}
+	data, err := filter.Transform(data)
+	if err != nil {
+		return nil, false, trace.Wrap(err)
+	}
+	return data, len(bytes.TrimSpace(data)) != 0, nil
+}
+
+func matchesKind(kinds []string, kind string) bool {
+	for _, k := range kinds {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
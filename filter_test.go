@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type FilterSuite struct{}
+
+var _ = Suite(&FilterSuite{})
+
+func (s *FilterSuite) TestTransform(c *C) {
+	data := []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  labels:
+    app: api
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  labels:
+    app: api
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker-1
+  labels:
+    app: worker
+`)
+	tcs := []struct {
+		filter ResourceFilter
+		names  []string
+	}{
+		{
+			filter: ResourceFilter{},
+			names:  []string{"Deployment/api", "Service/api", "Deployment/worker-1"},
+		},
+		{
+			filter: ResourceFilter{IncludeKinds: []string{"deployment"}},
+			names:  []string{"Deployment/api", "Deployment/worker-1"},
+		},
+		{
+			filter: ResourceFilter{IncludeNames: []string{"worker-*"}},
+			names:  []string{"Deployment/worker-1"},
+		},
+		{
+			filter: ResourceFilter{Selector: labels.SelectorFromSet(labels.Set{"app": "api"}), ExcludeKinds: []string{"Service"}},
+			names:  []string{"Deployment/api"},
+		},
+		{
+			filter: ResourceFilter{ExcludeSelector: labels.SelectorFromSet(labels.Set{"app": "api"})},
+			names:  []string{"Deployment/worker-1"},
+		},
+		{
+			filter: ResourceFilter{IncludeNames: []string{"missing"}},
+		},
+	}
+	for i, tc := range tcs {
+		comment := Commentf("test case %v", i+1)
+		out, err := tc.filter.Transform(data)
+		c.Assert(err, IsNil, comment)
+		objects, err := DecodeObjects(out)
+		c.Assert(err, IsNil, comment)
+		var names []string
+		for _, object := range objects {
+			names = append(names, object.GetKind()+"/"+object.GetName())
+		}
+		c.Assert(names, DeepEquals, tc.names, comment)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// DefaultFinalizerTimeout is the default time to wait for the finalizers
+	// of a deleted resource to complete
+	DefaultFinalizerTimeout = 5 * time.Minute
+	// DefaultFinalizerPollPeriod is the default period between checks
+	// of a deleted resource with finalizers
+	DefaultFinalizerPollPeriod = time.Second
+	// finalizerPatchAttempts is the number of attempts to update finalizers
+	// when the patch conflicts with concurrent updates
+	finalizerPatchAttempts = 5
+)
+
+// ObjectPatcher is an ObjectInterface that can patch resources
+type ObjectPatcher interface {
+	ObjectInterface
+	// Patch applies the JSON merge patch to the referenced resource,
+	// returns CompareFailed if the patch conflicts with a concurrent update
+	Patch(ctx context.Context, ref ObjectRef, patch []byte) error
+}
+
+// EnsureFinalizer adds the finalizer to the referenced resource unless it is already set.
+// The patch is conditional on the resource version and is retried on conflicts
+func EnsureFinalizer(ctx context.Context, objects ObjectPatcher, ref ObjectRef, finalizer string) error {
+	return updateFinalizers(ctx, objects, ref, func(finalizers []string) ([]string, bool) {
+		if hasFinalizer(finalizers, finalizer) {
+			return finalizers, false
+		}
+		return append(finalizers, finalizer), true
+	})
+}
+
+// RemoveFinalizer removes the finalizer from the referenced resource if it is set,
+// removing a finalizer from a missing resource is not an error.
+// The patch is conditional on the resource version and is retried on conflicts
+func RemoveFinalizer(ctx context.Context, objects ObjectPatcher, ref ObjectRef, finalizer string) error {
+	err := updateFinalizers(ctx, objects, ref, func(finalizers []string) ([]string, bool) {
+		var out []string
+		for _, f := range finalizers {
+			if f != finalizer {
+				out = append(out, f)
+			}
+		}
+		return out, len(out) != len(finalizers)
+	})
+	if trace.IsNotFound(err) {
+		return nil
+	}
+	return trace.Wrap(err)
+}
+
+// DeleteOptions configures DeleteObject
+type DeleteOptions struct {
+	// WaitForFinalizers blocks until the resource is removed,
+	// i.e. until all of its finalizers have completed
+	WaitForFinalizers bool
+	// Timeout is the time to wait for the finalizers, DefaultFinalizerTimeout if unset
+	Timeout time.Duration
+	// PollPeriod is the period between checks, DefaultFinalizerPollPeriod if unset
+	PollPeriod time.Duration
+}
+
+// CheckAndSetDefaults sets defaults
+func (o *DeleteOptions) CheckAndSetDefaults() error {
+	if o.Timeout == 0 {
+		o.Timeout = DefaultFinalizerTimeout
+	}
+	if o.PollPeriod == 0 {
+		o.PollPeriod = DefaultFinalizerPollPeriod
+	}
+	return nil
+}
+
+// DeleteObject deletes the referenced resource and optionally waits
+// for its finalizers to complete
+func DeleteObject(ctx context.Context, objects ObjectInterface, ref ObjectRef, options DeleteOptions) error {
+	if err := options.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := objects.Delete(ctx, ref); err != nil {
+		return trace.Wrap(err)
+	}
+	if !options.WaitForFinalizers {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+	ticker := time.NewTicker(options.PollPeriod)
+	defer ticker.Stop()
+	var finalizers []string
+	for {
+		object, err := objects.Get(ctx, ref)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				return nil
+			}
+			return trace.Wrap(err)
+		}
+		finalizers = object.GetFinalizers()
+		log.Debugf("%v is waiting for finalizers %v", ref, finalizers)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return trace.LimitExceeded("timed out waiting for finalizers %v of %v", finalizers, ref)
+		}
+	}
+}
+
+// updateFinalizers updates the finalizers of the referenced resource with fn
+// which returns the new finalizers and whether they have changed
+func updateFinalizers(ctx context.Context, objects ObjectPatcher, ref ObjectRef, fn func([]string) ([]string, bool)) error {
+	var err error
+	for attempt := 1; attempt <= finalizerPatchAttempts; attempt++ {
+		var object *unstructured.Unstructured
+		object, err = objects.Get(ctx, ref)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		finalizers, changed := fn(object.GetFinalizers())
+		if !changed {
+			return nil
+		}
+		var patch []byte
+		patch, err = finalizersPatch(object.GetResourceVersion(), finalizers)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		err = objects.Patch(ctx, ref, patch)
+		if !trace.IsCompareFailed(err) {
+			return trace.Wrap(err)
+		}
+		log.Debugf("finalizers of %v have been updated concurrently, attempt %v: %v", ref, attempt, err)
+	}
+	return trace.Wrap(err)
+}
+
+// finalizersPatch returns a merge patch setting the finalizers, the resource version
+// makes the server reject the patch if the resource has been modified
+func finalizersPatch(resourceVersion string, finalizers []string) ([]byte, error) {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": resourceVersion,
+			"finalizers":      finalizers,
+		},
+	}
+	data, err := json.Marshal(patch)
+	return data, trace.Wrap(err)
+}
+
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	for _, f := range finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
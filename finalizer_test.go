@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type FinalizerSuite struct{}
+
+var _ = Suite(&FinalizerSuite{})
+
+func (s *FinalizerSuite) TestFinalizers(c *C) {
+	ctx := context.TODO()
+	object := &unstructured.Unstructured{}
+	object.SetAPIVersion("v1")
+	object.SetKind(KindConfigMap)
+	object.SetNamespace("default")
+	object.SetName("config")
+	object.SetResourceVersion("1")
+	objects := &conflictingPatcher{memObjects: memObjects{}, conflicts: 2}
+	c.Assert(objects.Apply(ctx, object), IsNil)
+	ref := objects.ref(object)
+
+	c.Assert(EnsureFinalizer(ctx, objects, ref, "example.com/cleanup"), IsNil)
+	c.Assert(EnsureFinalizer(ctx, objects, ref, "example.com/cleanup"), IsNil)
+	live, err := objects.Get(ctx, ref)
+	c.Assert(err, IsNil)
+	c.Assert(live.GetFinalizers(), DeepEquals, []string{"example.com/cleanup"})
+	c.Assert(objects.patches, Equals, 3)
+
+	c.Assert(RemoveFinalizer(ctx, objects, ref, "example.com/cleanup"), IsNil)
+	live, err = objects.Get(ctx, ref)
+	c.Assert(err, IsNil)
+	c.Assert(live.GetFinalizers(), HasLen, 0)
+
+	c.Assert(DeleteObject(ctx, objects, ref, DeleteOptions{WaitForFinalizers: true}), IsNil)
+	c.Assert(RemoveFinalizer(ctx, objects, ref, "example.com/cleanup"), IsNil)
+}
+
+// conflictingPatcher is an in-memory ObjectPatcher that fails
+// the specified number of patches with a conflict
+type conflictingPatcher struct {
+	memObjects
+	conflicts int
+	patches   int
+}
+
+func (p *conflictingPatcher) Patch(ctx context.Context, ref ObjectRef, patch []byte) error {
+	p.patches++
+	if p.conflicts > 0 {
+		p.conflicts--
+		return trace.CompareFailed("the object has been modified")
+	}
+	object, err := p.Get(ctx, ref)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var update struct {
+		Metadata struct {
+			Finalizers []string `json:"finalizers"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &update); err != nil {
+		return trace.Wrap(err)
+	}
+	object.SetFinalizers(update.Metadata.Finalizers)
+	return p.Apply(ctx, object)
+}
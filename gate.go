@@ -0,0 +1,305 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultGatePollPeriod is the default period of gate condition checks
+	DefaultGatePollPeriod = 10 * time.Second
+	// DefaultGateTimeout is the default limit of the wait for a gate
+	DefaultGateTimeout = 30 * time.Minute
+)
+
+// Gate is an external condition waited for between waves,
+// e.g. DNS propagation or a billing cutover
+type Gate interface {
+	// Wait blocks until the condition is met or the context is cancelled
+	Wait(ctx context.Context) error
+	// String describes the gate
+	String() string
+}
+
+// SleepGate waits for the duration
+type SleepGate struct {
+	// Duration is the duration of the wait
+	Duration time.Duration
+}
+
+// Wait sleeps for the duration
+func (g SleepGate) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return trace.Wrap(ctx.Err())
+	case <-time.After(g.Duration):
+		return nil
+	}
+}
+
+// String describes the gate
+func (g SleepGate) String() string {
+	return fmt.Sprintf("sleep %v", g.Duration)
+}
+
+// HTTPGate polls the URL until it responds with 200 OK
+type HTTPGate struct {
+	// URL is the polled URL
+	URL string
+	// Header is added to the requests, e.g. the authorization header
+	Header http.Header
+	// Client is the HTTP client, defaults to a client with DefaultNotifyTimeout
+	Client *http.Client
+	// PollPeriod is the period between requests, DefaultGatePollPeriod if unset
+	PollPeriod time.Duration
+}
+
+// Wait polls the URL until it responds with 200 OK
+func (g HTTPGate) Wait(ctx context.Context) error {
+	return pollGate(ctx, g, g.PollPeriod, g.check)
+}
+
+// String describes the gate
+func (g HTTPGate) String() string {
+	return fmt.Sprintf("http %v", g.URL)
+}
+
+// check returns nil if the URL responds with 200 OK
+func (g HTTPGate) check(ctx context.Context) error {
+	client := g.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultNotifyTimeout}
+	}
+	req, err := http.NewRequest(http.MethodGet, g.URL, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	for name, values := range g.Header {
+		req.Header[name] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return trace.CompareFailed("%v returned %v", g.URL, resp.Status)
+	}
+	return nil
+}
+
+// ConfigMapGate waits until the key of the config map equals the value,
+// e.g. set by an external system once it has completed its part
+type ConfigMapGate struct {
+	// Client is k8s client
+	Client *kubernetes.Clientset
+	// Namespace is the namespace of the config map
+	Namespace string
+	// Name is the name of the config map
+	Name string
+	// Key is the data key of the config map
+	Key string
+	// Value is the expected value of the key
+	Value string
+	// PollPeriod is the period between checks, DefaultGatePollPeriod if unset
+	PollPeriod time.Duration
+}
+
+// Wait polls the config map until the key equals the value
+func (g ConfigMapGate) Wait(ctx context.Context) error {
+	if g.Client == nil {
+		return trace.BadParameter("missing parameter Client")
+	}
+	return pollGate(ctx, g, g.PollPeriod, g.check)
+}
+
+// String describes the gate
+func (g ConfigMapGate) String() string {
+	return fmt.Sprintf("configmap %v/%v %v=%q", Namespace(g.Namespace), g.Name, g.Key, g.Value)
+}
+
+// check returns nil if the key of the config map equals the value
+func (g ConfigMapGate) check(ctx context.Context) error {
+	configMap, err := g.Client.CoreV1().ConfigMaps(Namespace(g.Namespace)).Get(g.Name, metav1.GetOptions{})
+	if err != nil {
+		return ConvertError(err)
+	}
+	value, ok := configMap.Data[g.Key]
+	if !ok {
+		return trace.NotFound("configmap %v/%v has no key %v", configMap.Namespace, g.Name, g.Key)
+	}
+	if value != g.Value {
+		return trace.CompareFailed("configmap %v/%v key %v is %q, waiting for %q",
+			configMap.Namespace, g.Name, g.Key, value, g.Value)
+	}
+	return nil
+}
+
+// ApprovalGate waits for the manual approval of the rollout to continue
+type ApprovalGate struct {
+	// Approver approves the continuation
+	Approver Approver
+	// Name describes what is approved, e.g. the next wave
+	Name string
+}
+
+// Wait blocks until the approver approves the continuation,
+// returns AccessDenied if it is rejected
+func (g ApprovalGate) Wait(ctx context.Context) error {
+	if g.Approver == nil {
+		return trace.BadParameter("missing parameter Approver")
+	}
+	return trace.Wrap(g.Approver.Approve(ctx, &ChangePlan{Bundle: g.Name}))
+}
+
+// String describes the gate
+func (g ApprovalGate) String() string {
+	return fmt.Sprintf("approval of %v", g.Name)
+}
+
+// pollGate calls check every period until it succeeds or the context is cancelled
+func pollGate(ctx context.Context, gate Gate, period time.Duration, check func(context.Context) error) error {
+	if period == 0 {
+		period = DefaultGatePollPeriod
+	}
+	for {
+		err := check(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Infof("gate %v is closed, check again in %v: %v", gate, period, err)
+		select {
+		case <-ctx.Done():
+			return trace.Wrap(err, "gate %v is closed: %v", gate, ctx.Err())
+		case <-time.After(period):
+		}
+	}
+}
+
+// GateSpec describes a gate in the bundle metadata,
+// exactly one of the conditions has to be set
+type GateSpec struct {
+	// Sleep waits for the duration, e.g. 5m
+	Sleep string `json:"sleep,omitempty"`
+	// HTTP polls the URL until it responds with 200 OK
+	HTTP string `json:"http,omitempty"`
+	// ConfigMap waits until the key of the config map equals the value
+	ConfigMap *ConfigMapCondition `json:"configMap,omitempty"`
+	// Approval waits for the manual approval, see ApplyConfig.Approver
+	Approval bool `json:"approval,omitempty"`
+	// Timeout limits the wait, e.g. 1h, DefaultGateTimeout if unset
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ConfigMapCondition is a value of the config map key waited for
+type ConfigMapCondition struct {
+	// Namespace is the namespace of the config map
+	Namespace string `json:"namespace,omitempty"`
+	// Name is the name of the config map
+	Name string `json:"name"`
+	// Key is the data key of the config map
+	Key string `json:"key"`
+	// Value is the expected value of the key
+	Value string `json:"value"`
+}
+
+// Check returns BadParameter if the spec is invalid
+func (s GateSpec) Check() error {
+	var conditions int
+	if s.Sleep != "" {
+		conditions++
+		if _, err := time.ParseDuration(s.Sleep); err != nil {
+			return trace.BadParameter("invalid sleep %q: %v", s.Sleep, err)
+		}
+	}
+	if s.HTTP != "" {
+		conditions++
+	}
+	if s.ConfigMap != nil {
+		conditions++
+		if s.ConfigMap.Name == "" || s.ConfigMap.Key == "" {
+			return trace.BadParameter("configMap gate needs name and key")
+		}
+	}
+	if s.Approval {
+		conditions++
+	}
+	if conditions != 1 {
+		return trace.BadParameter("gate needs exactly one of sleep, http, configMap or approval")
+	}
+	if s.Timeout != "" {
+		if _, err := time.ParseDuration(s.Timeout); err != nil {
+			return trace.BadParameter("invalid timeout %q: %v", s.Timeout, err)
+		}
+	}
+	return nil
+}
+
+// timeout returns the limit of the wait
+func (s GateSpec) timeout() time.Duration {
+	timeout, err := time.ParseDuration(s.Timeout)
+	if err != nil || timeout <= 0 {
+		return DefaultGateTimeout
+	}
+	return timeout
+}
+
+// gate returns the gate of the spec, name describes what follows the gate
+func (s GateSpec) gate(client *kubernetes.Clientset, approver Approver, name string) (Gate, error) {
+	if err := s.Check(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	switch {
+	case s.Sleep != "":
+		duration, _ := time.ParseDuration(s.Sleep)
+		return SleepGate{Duration: duration}, nil
+	case s.HTTP != "":
+		return HTTPGate{URL: s.HTTP}, nil
+	case s.ConfigMap != nil:
+		return ConfigMapGate{
+			Client:    client,
+			Namespace: s.ConfigMap.Namespace,
+			Name:      s.ConfigMap.Name,
+			Key:       s.ConfigMap.Key,
+			Value:     s.ConfigMap.Value,
+		}, nil
+	}
+	if approver == nil {
+		return nil, trace.BadParameter("approval gate before %v needs an approver", name)
+	}
+	return ApprovalGate{Approver: approver, Name: name}, nil
+}
+
+// waitGate waits for the gate with the timeout of the spec
+func waitGate(ctx context.Context, gate Gate, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return trace.Wrap(gate.Wait(ctx))
+}
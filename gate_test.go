@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type GateSuite struct{}
+
+var _ = Suite(&GateSuite{})
+
+func (s *GateSuite) TestHTTPGate(c *C) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	gate := HTTPGate{URL: server.URL, PollPeriod: time.Millisecond}
+	c.Assert(waitGate(context.Background(), gate, time.Minute), IsNil)
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(3))
+}
+
+func (s *GateSuite) TestGateTimeout(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := waitGate(context.Background(), HTTPGate{URL: server.URL, PollPeriod: time.Millisecond}, 20*time.Millisecond)
+	c.Assert(err, NotNil)
+
+	err = waitGate(context.Background(), SleepGate{Duration: time.Minute}, time.Millisecond)
+	c.Assert(err, NotNil)
+}
+
+func (s *GateSuite) TestGateSpec(c *C) {
+	tcs := []struct {
+		spec  GateSpec
+		gate  Gate
+		error bool
+	}{
+		{spec: GateSpec{Sleep: "5m"}, gate: SleepGate{Duration: 5 * time.Minute}},
+		{spec: GateSpec{HTTP: "https://dns.example.com/ready"}, gate: HTTPGate{URL: "https://dns.example.com/ready"}},
+		{spec: GateSpec{Approval: true}, gate: ApprovalGate{Approver: FileApprover{Path: "approval"}, Name: "wave app"}},
+		{spec: GateSpec{}, error: true},
+		{spec: GateSpec{Sleep: "5m", Approval: true}, error: true},
+		{spec: GateSpec{Sleep: "soon"}, error: true},
+		{spec: GateSpec{ConfigMap: &ConfigMapCondition{Name: "billing"}}, error: true},
+		{spec: GateSpec{HTTP: "https://dns.example.com/ready", Timeout: "later"}, error: true},
+	}
+	for i, tc := range tcs {
+		comment := Commentf("test case %v", i+1)
+		gate, err := tc.spec.gate(nil, FileApprover{Path: "approval"}, "wave app")
+		if tc.error {
+			c.Assert(err, NotNil, comment)
+			continue
+		}
+		c.Assert(err, IsNil, comment)
+		c.Assert(gate, DeepEquals, tc.gate, comment)
+	}
+	_, err := GateSpec{Approval: true}.gate(nil, nil, "wave app")
+	c.Assert(trace.IsBadParameter(err), Equals, true)
+	c.Assert(GateSpec{Sleep: "1s"}.timeout(), Equals, DefaultGateTimeout)
+	c.Assert(GateSpec{Sleep: "1s", Timeout: "1h"}.timeout(), Equals, time.Hour)
+}
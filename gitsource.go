@@ -0,0 +1,144 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// GitSourceConfig configures loading of manifests from a git repository
+type GitSourceConfig struct {
+	// URL is the repository URL
+	URL string
+	// Ref is the branch, tag or commit to check out, defaults to the remote HEAD
+	Ref string
+	// Path is the manifest file, directory or glob pattern within the repository
+	Path string
+	// Recursive includes manifests in subdirectories of Path
+	Recursive bool
+	// SSHKeyPath is the optional private key used to authenticate over SSH
+	SSHKeyPath string
+	// Token is the optional bearer token used to authenticate over HTTPS
+	Token string
+	// VerifySignature requires the checked out commit to have
+	// a valid signature from a key trusted by the local gpg keyring
+	VerifySignature bool
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *GitSourceConfig) CheckAndSetDefaults() error {
+	if c.URL == "" {
+		return trace.BadParameter("missing parameter URL")
+	}
+	if c.Path == "" {
+		c.Path = "."
+	}
+	if filepath.IsAbs(c.Path) || strings.HasPrefix(filepath.Clean(c.Path), "..") {
+		return trace.BadParameter("path %q should be relative to the repository root", c.Path)
+	}
+	// git would parse arguments with a leading dash as options
+	if strings.HasPrefix(c.URL, "-") {
+		return trace.BadParameter("invalid repository URL %q", c.URL)
+	}
+	if strings.HasPrefix(c.Ref, "-") {
+		return trace.BadParameter("invalid ref %q", c.Ref)
+	}
+	return nil
+}
+
+// LoadGit clones the repository into a temporary directory, checks out
+// the configured ref and returns the manifest stream found at the configured path
+func LoadGit(ctx context.Context, config GitSourceConfig) ([]byte, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	dir, err := ioutil.TempDir("", "rigging-git")
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entry := log.WithFields(log.Fields{"git": config.URL})
+	entry.Infof("clone %v", config.Ref)
+	if err := runGit(ctx, config, "", "clone", "--quiet", "--no-checkout", "--", config.URL, dir); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	ref := config.Ref
+	if ref == "" {
+		ref = "HEAD"
+	} else if err := runGit(ctx, config, dir, "rev-parse", "--quiet", "--verify", "origin/"+ref); err == nil {
+		// branches are only available as remote tracking refs after the clone
+		ref = "origin/" + ref
+	}
+	// the ref is followed by -- so that it is never taken for a path
+	if err := runGit(ctx, config, dir, "checkout", "--quiet", "--detach", ref, "--"); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if config.VerifySignature {
+		if err := runGit(ctx, config, dir, "verify-commit", "HEAD"); err != nil {
+			return nil, trace.Wrap(err, "failed to verify signature of %v", ref)
+		}
+	}
+	return ReadManifests(filepath.Join(dir, config.Path), config.Recursive)
+}
+
+// runGit runs git with the specified arguments and the configured credentials
+func runGit(ctx context.Context, config GitSourceConfig, dir string, args ...string) error {
+	command := args[0]
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), gitEnv(config)...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return trace.Wrap(err, "git %v: %s", command, bytes.TrimSpace(out.Bytes()))
+	}
+	return nil
+}
+
+// gitEnv returns the environment passing the configured credentials to git.
+// The token is passed in the environment rather than on the command line
+// so that it is not visible in the process list
+func gitEnv(config GitSourceConfig) []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if config.Token != "" {
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			fmt.Sprintf("GIT_CONFIG_VALUE_0=Authorization: Bearer %v", config.Token))
+	}
+	if config.SSHKeyPath != "" {
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %v -o IdentitiesOnly=yes", shellQuote(config.SSHKeyPath)))
+	}
+	return env
+}
+
+// shellQuote quotes the value for the shell git runs GIT_SSH_COMMAND with
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type GitSourceSuite struct{}
+
+var _ = Suite(&GitSourceSuite{})
+
+func (s *GitSourceSuite) TestCheckAndSetDefaults(c *C) {
+	tcs := []struct {
+		config GitSourceConfig
+		error  bool
+	}{
+		{config: GitSourceConfig{URL: "https://example.com/app.git", Ref: "v1.0", Path: "deploy"}},
+		{config: GitSourceConfig{}, error: true},
+		{config: GitSourceConfig{URL: "--upload-pack=touch /tmp/pwned"}, error: true},
+		{config: GitSourceConfig{URL: "https://example.com/app.git", Ref: "--orphan"}, error: true},
+		{config: GitSourceConfig{URL: "https://example.com/app.git", Path: "../etc"}, error: true},
+	}
+	for i, tc := range tcs {
+		err := tc.config.CheckAndSetDefaults()
+		if tc.error {
+			c.Assert(trace.IsBadParameter(err), Equals, true, Commentf("test case %v", i+1))
+		} else {
+			c.Assert(err, IsNil, Commentf("test case %v", i+1))
+		}
+	}
+}
+
+func (s *GitSourceSuite) TestGitEnv(c *C) {
+	env := gitEnv(GitSourceConfig{Token: "secret", SSHKeyPath: "/home/ci/my key's"})
+	c.Assert(env, DeepEquals, []string{
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Bearer secret",
+		`GIT_SSH_COMMAND=ssh -i '/home/ci/my key'\''s' -o IdentitiesOnly=yes`,
+	})
+}
@@ -0,0 +1,114 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SecurityHardening is a transformer that enforces a security baseline
+// on pod templates: containers run as non-root with a read-only root
+// filesystem, without privilege escalation and with all capabilities dropped
+type SecurityHardening struct {
+	// ExemptWorkloads lists workloads left intact in form of Kind/name
+	ExemptWorkloads []string
+	// ExemptContainers lists names of containers left intact
+	ExemptContainers []string
+	// AllowedCapabilities lists capabilities containers may add back,
+	// other added capabilities are removed
+	AllowedCapabilities []v1.Capability
+	// WritableRootFilesystem leaves the root filesystem writable
+	WritableRootFilesystem bool
+}
+
+// Transform hardens pod templates in the manifest stream
+func (h SecurityHardening) Transform(data []byte) ([]byte, error) {
+	return PodSpecTransformer(h.harden).Transform(data)
+}
+
+func (h SecurityHardening) harden(object *unstructured.Unstructured, spec *v1.PodSpec) error {
+	if contains(h.ExemptWorkloads, object.GetKind()+"/"+object.GetName()) {
+		return nil
+	}
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &v1.PodSecurityContext{}
+	}
+	if spec.SecurityContext.RunAsUser != nil && *spec.SecurityContext.RunAsUser == 0 {
+		return trace.BadParameter("pod runs as root user")
+	}
+	spec.SecurityContext.RunAsNonRoot = boolPtr(true)
+	for i := range spec.InitContainers {
+		if err := h.hardenContainer(&spec.InitContainers[i]); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	for i := range spec.Containers {
+		if err := h.hardenContainer(&spec.Containers[i]); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func (h SecurityHardening) hardenContainer(container *v1.Container) error {
+	if contains(h.ExemptContainers, container.Name) {
+		return nil
+	}
+	if container.SecurityContext == nil {
+		container.SecurityContext = &v1.SecurityContext{}
+	}
+	sc := container.SecurityContext
+	if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+		return trace.BadParameter("container %v runs as root user", container.Name)
+	}
+	sc.RunAsNonRoot = boolPtr(true)
+	sc.Privileged = boolPtr(false)
+	sc.AllowPrivilegeEscalation = boolPtr(false)
+	if !h.WritableRootFilesystem {
+		sc.ReadOnlyRootFilesystem = boolPtr(true)
+	}
+	if sc.Capabilities == nil {
+		sc.Capabilities = &v1.Capabilities{}
+	}
+	var add []v1.Capability
+	for _, capability := range sc.Capabilities.Add {
+		if !hasCapability(h.AllowedCapabilities, capability) {
+			log.Warningf("remove capability %v from container %v", capability, container.Name)
+			continue
+		}
+		add = append(add, capability)
+	}
+	sc.Capabilities.Add = add
+	sc.Capabilities.Drop = []v1.Capability{"ALL"}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
@@ -0,0 +1,286 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// HelmReleaseNameAnnotation names the Helm release that installed the resource
+	HelmReleaseNameAnnotation = "meta.helm.sh/release-name"
+	// HelmReleaseNamespaceAnnotation is the namespace of the Helm release that installed the resource
+	HelmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+	// helmManagedBy is the value of ManagedByLabel on resources installed by Helm
+	helmManagedBy = "Helm"
+	// instanceLabel is the recommended label naming the instance of the application,
+	// charts set it to the release name
+	instanceLabel = "app.kubernetes.io/instance"
+	// helmHeritageLabel and helmReleaseLabel are set by charts of Helm 2
+	helmHeritageLabel = "heritage"
+	helmReleaseLabel  = "release"
+	// helmReleaseSecretType is the type of the secrets storing Helm releases
+	helmReleaseSecretType = "helm.sh/release.v1"
+	// helmReleaseKey is the key of the encoded release in the release secret
+	helmReleaseKey = "release"
+)
+
+// HelmPolicy governs how resources managed by Helm releases are handled
+// when a manifest stream is applied
+type HelmPolicy string
+
+const (
+	// HelmIgnore does not check whether the resources are managed by Helm
+	HelmIgnore HelmPolicy = "ignore"
+	// HelmWarn logs a warning for each resource managed by a Helm release
+	HelmWarn HelmPolicy = "warn"
+	// HelmFail fails the changeset before any changes are made
+	// if any resource is managed by a Helm release
+	HelmFail HelmPolicy = "fail"
+)
+
+// Check returns BadParameter if the Helm policy is not supported
+func (p HelmPolicy) Check() error {
+	switch p {
+	case HelmIgnore, HelmWarn, HelmFail:
+		return nil
+	}
+	return trace.BadParameter("unsupported Helm policy %q", string(p))
+}
+
+// HelmConflict describes a resource in the manifest stream managed by a Helm release
+type HelmConflict struct {
+	// Ref references the resource
+	Ref ObjectRef
+	// Release is the Helm release managing the resource, namespace/name
+	// if the namespace of the release is known, empty if the name is not known
+	Release string
+	// Reason describes how the release was detected
+	Reason string
+}
+
+// String returns a human readable description of the conflict
+func (c HelmConflict) String() string {
+	if c.Release == "" {
+		return fmt.Sprintf("%v is managed by Helm (%v)", c.Ref, c.Reason)
+	}
+	return fmt.Sprintf("%v is managed by Helm release %v (%v)", c.Ref, c.Release, c.Reason)
+}
+
+// HelmCheckConfig configures the detection of resources managed by Helm releases
+type HelmCheckConfig struct {
+	// Objects reads the live state of the resources, defaults to kubectl
+	Objects ObjectInterface
+	// Secrets lists the Helm release secrets, release secrets are not checked if unset
+	Secrets corev1.SecretsGetter
+}
+
+// FindHelmConflicts returns the resources in the manifest stream managed by Helm releases,
+// according to the release labels and annotations of the live resources or the manifests
+// of the deployed releases stored in release secrets in the namespaces of the resources.
+// Resources without namespace are looked up in the default namespace
+func FindHelmConflicts(ctx context.Context, config HelmCheckConfig, data []byte) ([]HelmConflict, error) {
+	if config.Objects == nil {
+		config.Objects = KubectlObjects{}
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	refs := make([]ObjectRef, 0, len(objects))
+	for _, object := range objects {
+		ref := ObjectRefFor(object)
+		if IsClusterScoped(ref.Kind) {
+			ref.Namespace = ""
+		} else {
+			ref.Namespace = Namespace(ref.Namespace)
+		}
+		refs = append(refs, ref)
+	}
+	var releases map[string]string
+	if config.Secrets != nil {
+		releases, err = helmReleaseObjects(config.Secrets, refNamespaces(refs))
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	var conflicts []HelmConflict
+	for _, ref := range refs {
+		if release, ok := releases[ref.key()]; ok {
+			conflicts = append(conflicts, HelmConflict{Ref: ref, Release: release, Reason: "listed in the release manifest"})
+			continue
+		}
+		live, err := config.Objects.Get(ctx, ref)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return nil, trace.Wrap(err, "failed to check the Helm release of %v: %v", ref, err)
+		}
+		if release, reason, ok := helmReleaseOf(live); ok {
+			conflicts = append(conflicts, HelmConflict{Ref: ref, Release: release, Reason: reason})
+		}
+	}
+	return conflicts, nil
+}
+
+// checkHelmReleases logs a warning or fails for the resources in the manifest stream
+// managed by Helm releases according to the Helm policy of the changeset.
+// Resources are taken over with a warning if the changeset adopts resources
+func (cs *Changeset) checkHelmReleases(ctx context.Context, data []byte) error {
+	if cs.HelmPolicy == "" || cs.HelmPolicy == HelmIgnore {
+		return nil
+	}
+	conflicts, err := FindHelmConflicts(ctx, HelmCheckConfig{Objects: cs.Objects, Secrets: cs.Client.CoreV1()}, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var errors []error
+	for _, conflict := range conflicts {
+		if cs.HelmPolicy == HelmWarn || cs.ForceAdopt {
+			log.Warningf("%v, Helm and rigging may overwrite each other's changes", conflict)
+			continue
+		}
+		errors = append(errors, trace.CompareFailed("%v, uninstall the release or adopt the resource explicitly to take it over", conflict))
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// helmReleaseOf returns the Helm release managing the object according to its labels
+// and annotations, and the description of how the release was detected
+func helmReleaseOf(object *unstructured.Unstructured) (release, reason string, ok bool) {
+	annotations, objectLabels := object.GetAnnotations(), object.GetLabels()
+	if name := annotations[HelmReleaseNameAnnotation]; name != "" {
+		if namespace := annotations[HelmReleaseNamespaceAnnotation]; namespace != "" {
+			name = namespace + "/" + name
+		}
+		return name, "release annotations", true
+	}
+	if objectLabels[ManagedByLabel] == helmManagedBy {
+		return objectLabels[instanceLabel], fmt.Sprintf("label %v=%v", ManagedByLabel, helmManagedBy), true
+	}
+	if heritage := objectLabels[helmHeritageLabel]; heritage == "Tiller" || heritage == helmManagedBy {
+		return objectLabels[helmReleaseLabel], fmt.Sprintf("label %v=%v", helmHeritageLabel, heritage), true
+	}
+	return "", "", false
+}
+
+// helmRelease is the part of the Helm release stored in release secrets used to detect conflicts
+type helmRelease struct {
+	// Name is the release name
+	Name string `json:"name"`
+	// Namespace is the release namespace
+	Namespace string `json:"namespace"`
+	// Manifest is the manifest stream installed by the release
+	Manifest string `json:"manifest"`
+}
+
+// helmReleaseObjects returns the resources installed by the deployed Helm releases
+// stored in the specified namespaces, keyed by the reference key with the release
+// namespace/name as the value
+func helmReleaseObjects(secrets corev1.SecretsGetter, namespaces []string) (map[string]string, error) {
+	selector := labels.Set{"owner": "helm", "status": "deployed"}.AsSelector().String()
+	refs := make(map[string]string)
+	for _, namespace := range namespaces {
+		list, err := secrets.Secrets(namespace).List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, ConvertErrorWithContext(err, "Helm release secrets in namespace %v", namespace)
+		}
+		for _, secret := range list.Items {
+			if secret.Type != helmReleaseSecretType {
+				continue
+			}
+			release, err := decodeHelmRelease(secret.Data[helmReleaseKey])
+			if err != nil {
+				log.Warningf("failed to decode Helm release secret %v: %v", FormatMeta(secret.ObjectMeta), err)
+				continue
+			}
+			if release.Namespace == "" {
+				release.Namespace = secret.Namespace
+			}
+			objects, err := DecodeObjects([]byte(release.Manifest))
+			if err != nil {
+				log.Warningf("failed to decode the manifest of Helm release %v/%v: %v", release.Namespace, release.Name, err)
+				continue
+			}
+			for _, object := range objects {
+				ref := ObjectRefFor(object)
+				if IsClusterScoped(ref.Kind) {
+					ref.Namespace = ""
+				} else if ref.Namespace == "" {
+					ref.Namespace = release.Namespace
+				}
+				refs[ref.key()] = release.Namespace + "/" + release.Name
+			}
+		}
+	}
+	return refs, nil
+}
+
+// decodeHelmRelease decodes the release stored in a release secret,
+// i.e. base64 encoded, optionally gzipped JSON
+func decodeHelmRelease(data []byte) (*helmRelease, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if bytes.HasPrefix(decoded, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		defer reader.Close()
+		decoded, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	var release helmRelease
+	if err := json.Unmarshal(decoded, &release); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &release, nil
+}
+
+// refNamespaces returns the sorted unique namespaces of the namespaced resources
+func refNamespaces(refs []ObjectRef) []string {
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, ref := range refs {
+		if ref.Namespace == "" || seen[ref.Namespace] {
+			continue
+		}
+		seen[ref.Namespace] = true
+		namespaces = append(namespaces, ref.Namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type HelmSuite struct{}
+
+var _ = Suite(&HelmSuite{})
+
+func (s *HelmSuite) TestFindHelmConflicts(c *C) {
+	objects := memObjects{}
+	live, err := DecodeObjects([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: legacy
+  namespace: apps
+  labels:
+    heritage: Tiller
+    release: legacy
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: annotated
+  namespace: apps
+  annotations:
+    meta.helm.sh/release-name: web
+    meta.helm.sh/release-namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: owned
+  namespace: apps
+  labels:
+    app.kubernetes.io/managed-by: rigging
+`))
+	c.Assert(err, IsNil)
+	for _, object := range live {
+		c.Assert(objects.Apply(context.TODO(), object), IsNil)
+	}
+	core := &memCore{objects: map[string]interface{}{
+		"secrets/apps/sh.helm.release.v1.web.v2": helmReleaseSecret(c, "web", "apps", `
+# Source: web/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+`),
+	}}
+
+	conflicts, err := FindHelmConflicts(context.TODO(), HelmCheckConfig{Objects: objects, Secrets: core}, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: legacy
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: annotated
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: owned
+  namespace: apps
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: apps
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: new
+  namespace: apps
+`))
+	c.Assert(err, IsNil)
+	var out []string
+	for _, conflict := range conflicts {
+		out = append(out, conflict.String())
+	}
+	c.Assert(out, DeepEquals, []string{
+		"ConfigMap/apps/legacy is managed by Helm release legacy (label heritage=Tiller)",
+		"ConfigMap/apps/annotated is managed by Helm release apps/web (release annotations)",
+		"Deployment/apps/web is managed by Helm release apps/web (listed in the release manifest)",
+	})
+}
+
+// helmReleaseSecret returns a Helm release secret with the manifest
+func helmReleaseSecret(c *C, name, namespace, manifest string) *v1.Secret {
+	data, err := json.Marshal(helmRelease{Name: name, Namespace: namespace, Manifest: manifest})
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err = writer.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(writer.Close(), IsNil)
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sh.helm.release.v1." + name + ".v2",
+			Namespace: namespace,
+			Labels:    map[string]string{"owner": "helm", "status": "deployed", "name": name},
+		},
+		Type: helmReleaseSecretType,
+		Data: map[string][]byte{helmReleaseKey: []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))},
+	}
+}
+
+func (m *memCore) Secrets(namespace string) corev1.SecretInterface {
+	return memSecrets{core: m, namespace: namespace}
+}
+
+type memSecrets struct {
+	corev1.SecretInterface
+	core      *memCore
+	namespace string
+}
+
+func (m memSecrets) List(options metav1.ListOptions) (*v1.SecretList, error) {
+	selector, err := labels.Parse(options.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	var list v1.SecretList
+	for key, object := range m.core.objects {
+		secret, ok := object.(*v1.Secret)
+		if ok && strings.HasPrefix(key, "secrets/"+m.namespace+"/") && selector.Matches(labels.Set(secret.Labels)) {
+			list.Items = append(list.Items, *secret)
+		}
+	}
+	return &list, nil
+}
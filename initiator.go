@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// InitiatorAnnotation records the identity that created the changeset
+const InitiatorAnnotation = "rigging.gravitational.io/initiator"
+
+// initiatorContext is the context key of the initiator
+type initiatorContext struct{}
+
+// WithInitiator returns a context recording the identity in the changeset
+// operations performed with the context, e.g. the authenticated user of
+// a request. It takes precedence over the initiator of the changeset config
+func WithInitiator(ctx context.Context, initiator string) context.Context {
+	return context.WithValue(ctx, initiatorContext{}, initiator)
+}
+
+// contextInitiator returns the initiator carried by the context
+func contextInitiator(ctx context.Context) string {
+	initiator, _ := ctx.Value(initiatorContext{}).(string)
+	return initiator
+}
+
+// DefaultInitiator returns the identity the client configuration authenticates as:
+// the service account if the process runs in a pod or the subject of the token
+// file, the user of the current context of the kubeconfig otherwise.
+// Returns an empty string if the identity can not be determined
+func DefaultInitiator(config ClientConfig) string {
+	tokenFile := config.TokenFile
+	if _, err := rest.InClusterConfig(); err == nil && tokenFile == "" {
+		tokenFile = serviceAccountTokenFile
+	}
+	if tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err == nil {
+			if subject, err := tokenSubject(strings.TrimSpace(string(token))); err == nil {
+				return subject
+			}
+		}
+	}
+	if config.KubeConfig == "" {
+		return ""
+	}
+	kubeConfig, err := clientcmd.LoadFromFile(config.KubeConfig)
+	if err != nil {
+		return ""
+	}
+	if kubeContext, ok := kubeConfig.Contexts[kubeConfig.CurrentContext]; ok {
+		return kubeContext.AuthInfo
+	}
+	return ""
+}
+
+// restConfigInitiator returns the identity the REST configuration
+// authenticates or impersonates as, if it can be determined
+func restConfigInitiator(config *rest.Config) string {
+	switch {
+	case config == nil:
+		return ""
+	case config.Impersonate.UserName != "":
+		return config.Impersonate.UserName
+	case config.Username != "":
+		return config.Username
+	case config.BearerToken != "":
+		subject, err := tokenSubject(config.BearerToken)
+		if err == nil {
+			return subject
+		}
+	}
+	return ""
+}
+
+// tokenSubject returns the subject of the JWT token,
+// e.g. system:serviceaccount:kube-system:rig for service account tokens
+func tokenSubject(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", trace.BadParameter("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", trace.Wrap(err)
+	}
+	if claims.Subject == "" {
+		return "", trace.NotFound("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// initiator returns the identity initiating the changeset operations
+// performed with the context
+func (cs *Changeset) initiator(ctx context.Context) string {
+	if initiator := contextInitiator(ctx); initiator != "" {
+		return initiator
+	}
+	return cs.Initiator
+}
+
+// initiatorAnnotations returns the annotations recording the initiator
+// of a new changeset, nil if the initiator is unknown
+func (cs *Changeset) initiatorAnnotations(ctx context.Context) map[string]string {
+	initiator := cs.initiator(ctx)
+	if initiator == "" {
+		return nil
+	}
+	return map[string]string{InitiatorAnnotation: initiator}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/base64"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/client-go/rest"
+)
+
+type InitiatorSuite struct{}
+
+var _ = Suite(&InitiatorSuite{})
+
+func (s *InitiatorSuite) TestTokenSubject(c *C) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"system:serviceaccount:kube-system:rig"}`))
+	subject, err := tokenSubject("header." + payload + ".signature")
+	c.Assert(err, IsNil)
+	c.Assert(subject, Equals, "system:serviceaccount:kube-system:rig")
+
+	_, err = tokenSubject("opaque-token")
+	c.Assert(err, NotNil)
+
+	payload = base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"issuer"}`))
+	_, err = tokenSubject("header." + payload + ".signature")
+	c.Assert(err, NotNil)
+}
+
+func (s *InitiatorSuite) TestInitiator(c *C) {
+	config := &rest.Config{Username: "alice"}
+	c.Assert(restConfigInitiator(config), Equals, "alice")
+	config.Impersonate.UserName = "bob"
+	c.Assert(restConfigInitiator(config), Equals, "bob")
+	c.Assert(restConfigInitiator(&rest.Config{BearerToken: "opaque-token"}), Equals, "")
+
+	cs := &Changeset{ChangesetConfig: ChangesetConfig{Initiator: "alice"}}
+	ctx := context.Background()
+	c.Assert(cs.initiatorAnnotations(ctx), DeepEquals, map[string]string{InitiatorAnnotation: "alice"})
+	ctx = WithInitiator(ctx, "carol")
+	c.Assert(cs.initiator(ctx), Equals, "carol")
+
+	cs.Initiator = ""
+	c.Assert(cs.initiatorAnnotations(context.Background()), IsNil)
+}
@@ -0,0 +1,227 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// InventoryLabel marks bundle inventory config maps with the bundle name
+	InventoryLabel = "rigging.gravitational.io/inventory"
+	// InventoryPrefix is the name prefix of bundle inventory config maps
+	InventoryPrefix = "rigging-inventory-"
+	// InventoryObjectsKey is the inventory config map key listing bundle resources
+	InventoryObjectsKey = "objects"
+)
+
+// InventoryConfig is a bundle inventory configuration
+type InventoryConfig struct {
+	// Bundle is the name of the bundle tracked by the inventory
+	Bundle string
+	// Namespace is the namespace of the inventory config map
+	Namespace string
+	// Client is k8s client, if unset the inventory is stored with Objects
+	Client *kubernetes.Clientset
+	// Objects deletes pruned resources, defaults to kubectl
+	Objects ObjectInterface
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *InventoryConfig) CheckAndSetDefaults() error {
+	var errors []error
+	if c.Bundle == "" {
+		errors = append(errors, trace.BadParameter("missing parameter Bundle"))
+	}
+	if c.Client == nil && c.Objects == nil {
+		errors = append(errors, trace.BadParameter("missing parameter Client or Objects"))
+	}
+	if c.Objects == nil {
+		c.Objects = KubectlObjects{}
+	}
+	c.Namespace = Namespace(c.Namespace)
+	return trace.NewAggregate(errors...)
+}
+
+// NewInventory returns a new inventory of the bundle resources
+func NewInventory(config InventoryConfig) (*Inventory, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Inventory{
+		InventoryConfig: config,
+		Entry: log.WithFields(log.Fields{
+			"inventory": config.Namespace + "/" + config.Bundle,
+		}),
+	}, nil
+}
+
+// Inventory records every resource applied by a bundle in a config map,
+// so that resources removed from the bundle can be reliably pruned
+// without relying on labels
+type Inventory struct {
+	InventoryConfig
+	*log.Entry
+}
+
+// Name returns the name of the inventory config map
+func (i *Inventory) Name() string {
+	return InventoryPrefix + i.Bundle
+}
+
+// Get returns the resources recorded in the inventory,
+// the list is empty if the inventory does not exist yet
+func (i *Inventory) Get(ctx context.Context) ([]ObjectRef, error) {
+	configMap, err := i.getConfigMap(ctx)
+	if err != nil {
+		if trace.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, trace.Wrap(err)
+	}
+	var refs []ObjectRef
+	if data, ok := configMap.Data[InventoryObjectsKey]; ok {
+		if err := json.Unmarshal([]byte(data), &refs); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return refs, nil
+}
+
+// Update replaces the resources recorded in the inventory
+func (i *Inventory) Update(ctx context.Context, refs []ObjectRef) error {
+	sorted := append([]ObjectRef(nil), refs...)
+	SortRefs(sorted)
+	data, err := json.Marshal(sorted)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	i.Infof("update inventory with %v resources", len(sorted))
+	configMap := &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       KindConfigMap,
+			APIVersion: V1,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      i.Name(),
+			Namespace: i.Namespace,
+			Labels: map[string]string{
+				InventoryLabel: i.Bundle,
+			},
+		},
+		Data: map[string]string{
+			InventoryObjectsKey: string(data),
+		},
+	}
+	if i.Client == nil {
+		fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(configMap)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(i.Objects.Apply(ctx, &unstructured.Unstructured{Object: fields}))
+	}
+	control, err := NewConfigMapControl(ConfigMapConfig{
+		ConfigMap: configMap,
+		Client:    i.Client,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(control.Upsert(ctx))
+}
+
+// Delete removes the inventory config map
+func (i *Inventory) Delete(ctx context.Context) error {
+	if i.Client == nil {
+		return trace.Wrap(i.Objects.Delete(ctx, i.ref()))
+	}
+	err := i.Client.CoreV1().ConfigMaps(i.Namespace).Delete(i.Name(), nil)
+	return ConvertError(err)
+}
+
+func (i *Inventory) ref() ObjectRef {
+	return ObjectRef{APIVersion: V1, Kind: KindConfigMap, Namespace: i.Namespace, Name: i.Name()}
+}
+
+func (i *Inventory) getConfigMap(ctx context.Context) (*v1.ConfigMap, error) {
+	if i.Client != nil {
+		configMap, err := i.Client.CoreV1().ConfigMaps(i.Namespace).Get(i.Name(), metav1.GetOptions{})
+		return configMap, ConvertError(err)
+	}
+	object, err := i.Objects.Get(ctx, i.ref())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var configMap v1.ConfigMap
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &configMap); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &configMap, nil
+}
+
+// Stale returns the resources recorded in the inventory
+// that are not present in the specified list
+func (i *Inventory) Stale(ctx context.Context, refs []ObjectRef) ([]ObjectRef, error) {
+	recorded, err := i.Get(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return staleRefs(recorded, refs), nil
+}
+
+// Prune deletes the resources recorded in the inventory that are not
+// in the specified list and records the list as the new inventory
+func (i *Inventory) Prune(ctx context.Context, refs []ObjectRef) ([]ObjectRef, error) {
+	stale, err := i.Stale(ctx, refs)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, ref := range stale {
+		i.Infof("prune %v", ref)
+		if err := i.Objects.Delete(ctx, ref); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	if err := i.Update(ctx, refs); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return stale, nil
+}
+
+// staleRefs returns references from recorded missing in current
+func staleRefs(recorded, current []ObjectRef) []ObjectRef {
+	keep := make(map[string]struct{}, len(current))
+	for _, ref := range current {
+		keep[ref.key()] = struct{}{}
+	}
+	var stale []ObjectRef
+	for _, ref := range recorded {
+		if _, ok := keep[ref.key()]; !ok {
+			stale = append(stale, ref)
+		}
+	}
+	return stale
+}
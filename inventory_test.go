@@ -0,0 +1,140 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type InventorySuite struct{}
+
+var _ = Suite(&InventorySuite{})
+
+func (s *InventorySuite) TestPrune(c *C) {
+	ctx := context.TODO()
+	objects := memObjects{}
+	inventory, err := NewInventory(InventoryConfig{Bundle: "app", Namespace: "apps", Objects: objects})
+	c.Assert(err, IsNil)
+	c.Assert(inventory.Name(), Equals, "rigging-inventory-app")
+
+	refs, err := inventory.Get(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0, Commentf("inventory does not exist yet"))
+
+	kept := ObjectRef{APIVersion: V1, Kind: KindConfigMap, Namespace: "apps", Name: "kept"}
+	removed := ObjectRef{APIVersion: V1, Kind: KindConfigMap, Namespace: "apps", Name: "removed"}
+	c.Assert(inventory.Update(ctx, []ObjectRef{removed, kept}), IsNil)
+	refs, err = inventory.Get(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(refs, DeepEquals, []ObjectRef{kept, removed})
+
+	for _, ref := range refs {
+		c.Assert(objects.Apply(ctx, newConfigMapObject(ref)), IsNil)
+	}
+	pruned, err := inventory.Prune(ctx, []ObjectRef{kept})
+	c.Assert(err, IsNil)
+	c.Assert(pruned, DeepEquals, []ObjectRef{removed})
+	_, err = objects.Get(ctx, kept)
+	c.Assert(err, IsNil)
+	_, err = objects.Get(ctx, removed)
+	c.Assert(err, NotNil)
+	refs, err = inventory.Get(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(refs, DeepEquals, []ObjectRef{kept})
+
+	c.Assert(inventory.Delete(ctx), IsNil)
+	refs, err = inventory.Get(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+}
+
+func (s *InventorySuite) TestStaleRefs(c *C) {
+	recorded := []ObjectRef{
+		{APIVersion: "extensions/v1beta1", Kind: KindDeployment, Namespace: "apps", Name: "api"},
+		{APIVersion: "example.com/v1", Kind: "Widget", Namespace: "apps", Name: "w"},
+		{APIVersion: "other.io/v1", Kind: "Widget", Namespace: "apps", Name: "w"},
+		{Kind: KindConfigMap, Namespace: "apps", Name: "config"},
+	}
+	current := []ObjectRef{
+		// same deployment upgraded to the preferred version
+		{APIVersion: "apps/v1", Kind: KindDeployment, Namespace: "apps", Name: "api"},
+		{APIVersion: "example.com/v2", Kind: "Widget", Namespace: "apps", Name: "w"},
+		{APIVersion: V1, Kind: KindConfigMap, Namespace: "apps", Name: "config"},
+	}
+	c.Assert(staleRefs(recorded, current), DeepEquals, []ObjectRef{recorded[2]})
+}
+
+func (s *InventorySuite) TestBundleScopes(c *C) {
+	bundle, err := NewBundle("app", "apps", []byte(`apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: clusterwidgets.example.com
+spec:
+  group: example.com
+  scope: Cluster
+  names:
+    kind: ClusterWidget
+---
+apiVersion: example.com/v1
+kind: ClusterWidget
+metadata:
+  name: cw
+---
+apiVersion: other.io/v1
+kind: Gadget
+metadata:
+  name: g
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+`))
+	c.Assert(err, IsNil)
+	bundle.Discovery = staticDiscovery{
+		{GroupVersion: "other.io/v1", APIResources: []metav1.APIResource{
+			{Name: "gadgets", Kind: "Gadget", Namespaced: false},
+		}},
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: KindConfigMap, Namespaced: true},
+		}},
+	}
+	var refs []string
+	for _, ref := range bundle.Refs() {
+		refs = append(refs, ref.String())
+	}
+	c.Assert(refs, DeepEquals, []string{
+		"ClusterWidget/cw",
+		"ConfigMap/apps/config",
+		"CustomResourceDefinition/clusterwidgets.example.com",
+		"Gadget/g",
+	})
+}
+
+// newConfigMapObject returns a config map object for the reference
+func newConfigMapObject(ref ObjectRef) *unstructured.Unstructured {
+	object := &unstructured.Unstructured{}
+	object.SetAPIVersion(ref.APIVersion)
+	object.SetKind(ref.Kind)
+	object.SetNamespace(ref.Namespace)
+	object.SetName(ref.Name)
+	return object
+}
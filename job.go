@@ -16,6 +16,9 @@ package rigging
 
 import (
 	"context"
+	"fmt"
+	"regexp"
+	"time"
 
 	"github.com/gravitational/trace"
 
@@ -23,6 +26,8 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -35,13 +40,14 @@ func NewJobControl(config JobConfig) (*JobControl, error) {
 	return &JobControl{
 		JobConfig: config,
 		Entry: log.WithFields(log.Fields{
-			"job": formatMeta(config.Job.ObjectMeta),
+			"job": FormatMeta(config.Job.ObjectMeta),
 		}),
 	}, nil
 }
 
 func (c *JobControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.Job.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.Job.ObjectMeta))
 
 	jobs := c.Batch().Jobs(c.Job.Namespace)
 	currentJob, err := jobs.Get(c.Job.Name, metav1.GetOptions{})
@@ -75,12 +81,13 @@ func (c *JobControl) Delete(ctx context.Context, cascade bool) error {
 	if !cascade {
 		c.Info("cascade not set, returning")
 	}
-	err = deletePods(pods, currentPods, *c.Entry)
+	err = deletePods(ctx, pods, currentPods, *c.Entry)
 	return trace.Wrap(err)
 }
 
 func (c *JobControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.Job.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.Job.ObjectMeta))
 
 	jobs := c.Batch().Jobs(c.Job.Namespace)
 	currentJob, err := jobs.Get(c.Job.Name, metav1.GetOptions{})
@@ -108,19 +115,117 @@ func (c *JobControl) Upsert(ctx context.Context) error {
 	c.Job.UID = ""
 	c.Job.SelfLink = ""
 	c.Job.ResourceVersion = ""
+	if c.ActiveDeadline > 0 {
+		c.Job.Spec.ActiveDeadlineSeconds = deadlineSeconds(c.ActiveDeadline)
+	}
 	if c.Job.Spec.Selector != nil {
 		// Remove auto-generated labels
 		delete(c.Job.Spec.Selector.MatchLabels, ControllerUIDLabel)
 		delete(c.Job.Spec.Template.Labels, ControllerUIDLabel)
 	}
 
-	err = withExponentialBackoff(func() error {
+	var oldUID types.UID
+	if currentJob != nil {
+		oldUID = currentJob.UID
+	}
+	err = recreateObject(oldUID, func() (metav1.Object, error) {
+		job, err := jobs.Get(c.Job.Name, metav1.GetOptions{})
+		return job, ConvertError(err)
+	}, func() error {
 		_, err := jobs.Create(c.Job)
 		return ConvertError(err)
 	})
+	if err != nil && currentJob != nil {
+		c.removeOrphans(ctx, currentJob)
+	}
 	return trace.Wrap(err)
 }
 
+// removeOrphans removes the pods of the deleted job which linger
+// after the new job has failed to create, failures are logged
+func (c *JobControl) removeOrphans(ctx context.Context, job *batchv1.Job) {
+	orphans, err := c.collectPods(job)
+	if err != nil {
+		c.Warningf("failed to collect orphaned pods: %v", trace.DebugReport(err))
+		return
+	}
+	if len(orphans) == 0 {
+		return
+	}
+	c.Infof("removing %v orphaned pods of %v", len(orphans), FormatMeta(job.ObjectMeta))
+	err = deletePods(ctx, c.Core().Pods(job.Namespace), orphans, *c.Entry)
+	if err != nil {
+		c.Warningf("failed to remove orphaned pods: %v", trace.DebugReport(err))
+	}
+}
+
+// CleanupOrphans removes pods of jobs that no longer exist in the namespace,
+// all namespaces if empty. Such pods linger if a job has been deleted
+// without its pods, e.g. when replacing the job failed. Returns the removed pods
+func CleanupOrphans(ctx context.Context, client *kubernetes.Clientset, namespace string) ([]v1.Pod, error) {
+	// pods are listed before jobs so that the pods of a job created
+	// in between are not taken for orphans
+	pods, err := client.CoreV1().Pods(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	jobs, err := client.BatchV1().Jobs(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	orphans := orphanedJobPods(pods.Items, jobs.Items)
+	var errors []error
+	for _, pod := range orphans {
+		log.Infof("removing orphaned pod %v", FormatMeta(pod.ObjectMeta))
+		err := deletePods(ctx, client.CoreV1().Pods(pod.Namespace), []v1.Pod{pod}, *log.WithField("pod", FormatMeta(pod.ObjectMeta)))
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return orphans, trace.NewAggregate(errors...)
+}
+
+// orphanedJobPods returns the pods created for jobs missing from the list.
+// The owning job is identified by the owner reference, or by the controller
+// UID label if the owner reference has been removed
+func orphanedJobPods(pods []v1.Pod, jobs []batchv1.Job) []v1.Pod {
+	live := make(map[types.UID]bool, len(jobs))
+	for _, job := range jobs {
+		live[job.UID] = true
+	}
+	var orphans []v1.Pod
+	for _, pod := range pods {
+		uid, ok := jobUID(pod)
+		if ok && !live[uid] {
+			orphans = append(orphans, pod)
+		}
+	}
+	return orphans
+}
+
+// jobUID returns the UID of the job that created the pod
+func jobUID(pod v1.Pod) (types.UID, bool) {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == KindJob {
+			return ref.UID, true
+		}
+	}
+	if len(pod.OwnerReferences) != 0 {
+		return "", false
+	}
+	if _, ok := pod.Labels[JobNameLabel]; !ok {
+		return "", false
+	}
+	uid, ok := pod.Labels[ControllerUIDLabel]
+	return types.UID(uid), ok && uid != ""
+}
+
+// UpsertAndWait upserts the job and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *JobControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *JobControl) Status() error {
 	jobs := c.Batch().Jobs(c.Job.Namespace)
 	job, err := jobs.Get(c.Job.Name, metav1.GetOptions{})
@@ -128,6 +233,10 @@ func (c *JobControl) Status() error {
 		return ConvertError(err)
 	}
 
+	if err := jobTimeout(job); err != nil {
+		return err
+	}
+
 	succeeded := job.Status.Succeeded
 	active := job.Status.Active
 	var complete bool
@@ -146,12 +255,183 @@ func (c *JobControl) Status() error {
 
 	if !complete {
 		return trace.CompareFailed("job %v not yet complete (succeeded: %v, active: %v)",
-			formatMeta(job.ObjectMeta), succeeded, active)
+			FormatMeta(job.ObjectMeta), succeeded, active)
+	}
+	return trace.Wrap(c.checkLogs(job))
+}
+
+// Pending returns true if the job has not run any pods yet and all of its pods
+// are waiting to be scheduled or for their images to be pulled
+func (c *JobControl) Pending() (bool, error) {
+	job, err := c.Batch().Jobs(c.Job.Namespace).Get(c.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, ConvertError(err)
+	}
+	if job.Status.Succeeded != 0 || job.Status.Failed != 0 {
+		return false, nil
+	}
+	pods, err := c.Core().Pods(job.Namespace).List(metav1.ListOptions{
+		LabelSelector: labels.Set{ControllerUIDLabel: string(job.UID)}.String(),
+	})
+	if err != nil {
+		return false, ConvertError(err)
+	}
+	for _, pod := range pods.Items {
+		if uid, ok := jobUID(pod); ok && uid == job.UID && !podPending(pod) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// MaxPending returns how long the job pods may stay pending
+func (c *JobControl) MaxPending() time.Duration {
+	return c.PendingTimeout
+}
+
+// SetParallelism updates the parallelism of the running job and waits
+// for the number of active pods to converge, i.e. for the new pods to be
+// created after raising the parallelism. Lowering the parallelism makes
+// the job controller terminate the excess pods, their work is retried later
+func (c *JobControl) SetParallelism(ctx context.Context, parallelism int32) error {
+	c.Entry = contextEntry(ctx, c.Entry)
+	if parallelism < 0 {
+		return trace.BadParameter("parallelism should not be negative, got %v", parallelism)
 	}
+	c.Infof("set parallelism of %v to %v", FormatMeta(c.Job.ObjectMeta), parallelism)
+
+	jobs := c.Batch().Jobs(c.Job.Namespace)
+	job, err := jobs.Get(c.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		return ConvertError(err)
+	}
+	scaleUp := job.Spec.Parallelism == nil || *job.Spec.Parallelism < parallelism
+	patch := []byte(fmt.Sprintf(`{"spec":{"parallelism":%v}}`, parallelism))
+	_, err = jobs.Patch(c.Job.Name, types.MergePatchType, patch)
+	if err != nil {
+		return ConvertError(err)
+	}
+	c.Job.Spec.Parallelism = &parallelism
+
+	return retry(ctx, DefaultRetryAttempts, DefaultRetryPeriod, func() error {
+		job, err := jobs.Get(c.Job.Name, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		return trace.Wrap(checkParallelism(job, parallelism, scaleUp))
+	})
+}
+
+// checkParallelism returns CompareFailed if the number of active pods of the job
+// has not converged to the parallelism yet: the job should have at most
+// min(parallelism, remaining completions) active pods after scaling down
+// and at least as many after scaling up
+func checkParallelism(job *batchv1.Job, parallelism int32, scaleUp bool) error {
+	if isJobFinished(job) {
+		return nil
+	}
+	expected := parallelism
+	if job.Spec.Completions != nil {
+		remaining := *job.Spec.Completions - job.Status.Succeeded
+		if remaining < expected {
+			expected = remaining
+		}
+	}
+	if scaleUp && job.Status.Active < expected {
+		return trace.CompareFailed("job %v has %v active pods, expected at least %v",
+			FormatMeta(job.ObjectMeta), job.Status.Active, expected)
+	}
+	if !scaleUp && job.Status.Active > expected {
+		return trace.CompareFailed("job %v has %v active pods, expected at most %v",
+			FormatMeta(job.ObjectMeta), job.Status.Active, expected)
+	}
+	return nil
+}
+
+// SetActiveDeadline updates the active deadline of the running job,
+// the job is terminated once it has been active for longer than the deadline
+func (c *JobControl) SetActiveDeadline(ctx context.Context, deadline time.Duration) error {
+	c.Entry = contextEntry(ctx, c.Entry)
+	if deadline <= 0 {
+		return trace.BadParameter("deadline should be positive, got %v", deadline)
+	}
+	seconds := deadlineSeconds(deadline)
+	c.Infof("set active deadline of %v to %v", FormatMeta(c.Job.ObjectMeta), deadline)
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"activeDeadlineSeconds":%v}}`, *seconds))
+	_, err := c.Batch().Jobs(c.Job.Namespace).Patch(c.Job.Name, types.MergePatchType, patch)
+	if err != nil {
+		return ConvertError(err)
+	}
+	c.Job.Spec.ActiveDeadlineSeconds = seconds
 	return nil
 }
 
-func (c *JobControl) collectPods(job *batchv1.Job) (map[string]v1.Pod, error) {
+// deadlineSeconds returns the deadline in seconds rounded up
+func deadlineSeconds(deadline time.Duration) *int64 {
+	seconds := int64((deadline + time.Second - 1) / time.Second)
+	return &seconds
+}
+
+// JobDeadlineExceededReason is the reason of the failed condition
+// of jobs terminated after exceeding their active deadline
+const JobDeadlineExceededReason = "DeadlineExceeded"
+
+// JobTimeoutError is returned by the job status check if the job
+// has been terminated after exceeding its active deadline,
+// as opposed to failing because its pods have failed
+type JobTimeoutError struct {
+	// Job is the namespace and name of the job
+	Job string
+	// Deadline is the active deadline of the job
+	Deadline time.Duration
+	// Message is the message of the failed job condition
+	Message string
+}
+
+// Error returns the job and its deadline
+func (e *JobTimeoutError) Error() string {
+	return fmt.Sprintf("job %v exceeded its active deadline of %v: %v", e.Job, e.Deadline, e.Message)
+}
+
+// IsJobTimeout returns true if the error indicates
+// that the job has exceeded its active deadline
+func IsJobTimeout(err error) bool {
+	_, ok := trace.Unwrap(err).(*JobTimeoutError)
+	return ok
+}
+
+// jobTimeout returns JobTimeoutError if the job
+// has failed after exceeding its active deadline
+func jobTimeout(job *batchv1.Job) error {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type != batchv1.JobFailed || cond.Status != v1.ConditionTrue || cond.Reason != JobDeadlineExceededReason {
+			continue
+		}
+		var deadline time.Duration
+		if job.Spec.ActiveDeadlineSeconds != nil {
+			deadline = time.Duration(*job.Spec.ActiveDeadlineSeconds) * time.Second
+		}
+		return trace.Wrap(&JobTimeoutError{
+			Job:      FormatMeta(job.ObjectMeta),
+			Deadline: deadline,
+			Message:  cond.Message,
+		})
+	}
+	return nil
+}
+
+// isJobFinished returns true if the job has completed or failed
+func isJobFinished(job *batchv1.Job) bool {
+	for _, cond := range job.Status.Conditions {
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) && cond.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *JobControl) collectPods(job *batchv1.Job) ([]v1.Pod, error) {
 	var labels map[string]string
 	if job.Spec.Selector != nil {
 		labels = job.Spec.Selector.MatchLabels
@@ -170,6 +450,24 @@ type JobControl struct {
 type JobConfig struct {
 	Job *batchv1.Job
 	*kubernetes.Clientset
+	// PendingTimeout is how long the job pods may wait to be scheduled
+	// or for their images to be pulled without consuming status attempts,
+	// DefaultPendingTimeout if unset
+	PendingTimeout time.Duration
+	// ActiveDeadline, if set, overrides the active deadline of the job
+	// on Upsert, the job is terminated once it has been active for longer
+	ActiveDeadline time.Duration
+	// SuccessLogPattern, if set, additionally requires the logs of the
+	// succeeded job pods to match for the job to be complete, defaults to
+	// the pattern in the JobSuccessLogAnnotation annotation of the job.
+	// The patterns are matched against the last 10MiB of the log of each container
+	SuccessLogPattern *regexp.Regexp
+	// FailureLogPattern, if set, additionally requires the logs of the
+	// succeeded job pods not to match for the job to be complete, defaults
+	// to the pattern in the JobFailureLogAnnotation annotation of the job
+	FailureLogPattern *regexp.Regexp
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *JobConfig) checkAndSetDefaults() error {
@@ -180,5 +478,8 @@ func (c *JobConfig) checkAndSetDefaults() error {
 	if c.Job.APIVersion == "" {
 		c.Job.APIVersion = BatchAPIVersion
 	}
-	return nil
+	if c.PendingTimeout == 0 {
+		c.PendingTimeout = DefaultPendingTimeout
+	}
+	return trace.Wrap(c.logPatterns())
 }
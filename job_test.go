@@ -0,0 +1,168 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+type JobSuite struct{}
+
+var _ = Suite(&JobSuite{})
+
+func (s *JobSuite) TestJobTimeout(c *C) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default"},
+		Spec:       batchv1.JobSpec{ActiveDeadlineSeconds: deadlineSeconds(90*time.Second + time.Millisecond)},
+		Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{
+			Type:    batchv1.JobFailed,
+			Status:  v1.ConditionTrue,
+			Reason:  "BackoffLimitExceeded",
+			Message: "Job has reached the specified backoff limit",
+		}}},
+	}
+	c.Assert(*job.Spec.ActiveDeadlineSeconds, Equals, int64(91))
+	c.Assert(jobTimeout(job), IsNil)
+
+	job.Status.Conditions[0].Reason = JobDeadlineExceededReason
+	job.Status.Conditions[0].Message = "Job was active longer than specified deadline"
+	err := trace.Wrap(jobTimeout(job), "status check failed")
+	c.Assert(IsJobTimeout(err), Equals, true)
+	c.Assert(trace.Unwrap(err).Error(), Equals,
+		"job default/migrate exceeded its active deadline of 1m31s: Job was active longer than specified deadline")
+	c.Assert(IsJobTimeout(trace.CompareFailed("job is not complete")), Equals, false)
+}
+
+func (s *JobSuite) TestLogPatterns(c *C) {
+	success := regexp.MustCompile(`migrated \d+ tables`)
+	failure := regexp.MustCompile(`(?i)skipping`)
+	c.Assert(matchJobLogs("migrate-x", []byte("migrated 12 tables\n"), success, failure), IsNil)
+	c.Assert(matchJobLogs("migrate-x", []byte("nothing to do\n"), nil, nil), IsNil)
+	err := matchJobLogs("migrate-x", []byte("nothing to do\n"), success, nil)
+	c.Assert(trace.IsCompareFailed(err), Equals, true)
+	err = matchJobLogs("migrate-x", []byte("Skipping migration: lock held\nmigrated 0 tables\n"), success, failure)
+	c.Assert(trace.IsCompareFailed(err), Equals, true)
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name: "migrate",
+		Annotations: map[string]string{
+			JobSuccessLogAnnotation: `migrated \d+ tables`,
+		},
+	}}
+	config := JobConfig{Job: job, Clientset: &kubernetes.Clientset{}, FailureLogPattern: failure}
+	c.Assert(config.checkAndSetDefaults(), IsNil)
+	c.Assert(config.SuccessLogPattern.String(), Equals, `migrated \d+ tables`)
+	c.Assert(config.FailureLogPattern, Equals, failure)
+
+	job.Annotations[JobFailureLogAnnotation] = "("
+	config = JobConfig{Job: job, Clientset: &kubernetes.Clientset{}}
+	c.Assert(trace.IsBadParameter(config.checkAndSetDefaults()), Equals, true)
+}
+
+func (s *JobSuite) TestReadTail(c *C) {
+	var logs bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&logs, "migrating table %v\n", i)
+	}
+	logs.WriteString("migration complete\n")
+
+	tail, err := readTail(bytes.NewReader(logs.Bytes()), 64)
+	c.Assert(err, IsNil)
+	c.Assert(string(tail), Equals, "migrating table 998\nmigrating table 999\nmigration complete\n")
+
+	tail, err = readTail(strings.NewReader("migration complete\n"), 64)
+	c.Assert(err, IsNil)
+	c.Assert(string(tail), Equals, "migration complete\n")
+}
+
+func (s *JobSuite) TestOrphanedJobPods(c *C) {
+	pod := func(name string, refs []metav1.OwnerReference, labels map[string]string) v1.Pod {
+		return v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			OwnerReferences: refs,
+			Labels:          labels,
+		}}
+	}
+	jobRef := func(uid string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{Kind: KindJob, Name: "migrate", UID: types.UID(uid)}}
+	}
+	pods := []v1.Pod{
+		pod("live", jobRef("live-uid"), nil),
+		pod("orphan", jobRef("deleted-uid"), nil),
+		pod("orphan-label", nil, map[string]string{JobNameLabel: "migrate", ControllerUIDLabel: "deleted-uid"}),
+		pod("live-label", nil, map[string]string{JobNameLabel: "migrate", ControllerUIDLabel: "live-uid"}),
+		pod("replica", []metav1.OwnerReference{{Kind: KindReplicaSet, Name: "api-1", UID: "rs-uid"}},
+			map[string]string{JobNameLabel: "migrate", ControllerUIDLabel: "deleted-uid"}),
+		pod("standalone", nil, nil),
+		pod("empty-uid", nil, map[string]string{JobNameLabel: "migrate", ControllerUIDLabel: ""}),
+	}
+	jobs := []batchv1.Job{{ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "default", UID: "live-uid"}}}
+	var names []string
+	for _, orphan := range orphanedJobPods(pods, jobs) {
+		names = append(names, orphan.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"orphan", "orphan-label"})
+
+	uid, ok := jobUID(pods[2])
+	c.Assert(ok, Equals, true)
+	c.Assert(uid, Equals, types.UID("deleted-uid"))
+	_, ok = jobUID(pods[4])
+	c.Assert(ok, Equals, false)
+}
+
+func (s *JobSuite) TestCheckParallelism(c *C) {
+	completions := int32(5)
+	tcs := []struct {
+		active      int32
+		succeeded   int32
+		parallelism int32
+		scaleUp     bool
+		converged   bool
+	}{
+		{active: 1, parallelism: 3, scaleUp: true, converged: false},
+		{active: 3, parallelism: 3, scaleUp: true, converged: true},
+		{active: 2, succeeded: 3, parallelism: 4, scaleUp: true, converged: true},
+		{active: 4, parallelism: 2, scaleUp: false, converged: false},
+		{active: 1, parallelism: 2, scaleUp: false, converged: true},
+	}
+	for i, tc := range tcs {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"},
+			Spec:       batchv1.JobSpec{Completions: &completions},
+			Status:     batchv1.JobStatus{Active: tc.active, Succeeded: tc.succeeded},
+		}
+		err := checkParallelism(job, tc.parallelism, tc.scaleUp)
+		if tc.converged {
+			c.Assert(err, IsNil, Commentf("test case %v", i+1))
+		} else {
+			c.Assert(trace.IsCompareFailed(err), Equals, true, Commentf("test case %v", i+1))
+		}
+	}
+}
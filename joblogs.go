@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+
+	"github.com/gravitational/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// JobSuccessLogAnnotation sets the pattern the logs of the succeeded
+	// job pods must match for the job to be complete, see JobConfig.SuccessLogPattern
+	JobSuccessLogAnnotation = "rigging.gravitational.io/success-log-pattern"
+	// JobFailureLogAnnotation sets the pattern the logs of the succeeded
+	// job pods must not match for the job to be complete, see JobConfig.FailureLogPattern
+	JobFailureLogAnnotation = "rigging.gravitational.io/failure-log-pattern"
+)
+
+// maxJobLogBytes limits the logs of each container checked against the log
+// patterns, the tail of the logs is checked as the logs of successful
+// jobs usually end with a summary
+const maxJobLogBytes = 10 * 1024 * 1024
+
+// logPatterns sets the log patterns of the job config
+// from the job annotations unless set explicitly
+func (c *JobConfig) logPatterns() error {
+	var err error
+	if c.SuccessLogPattern == nil {
+		c.SuccessLogPattern, err = annotationPattern(c.Job, JobSuccessLogAnnotation)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if c.FailureLogPattern == nil {
+		c.FailureLogPattern, err = annotationPattern(c.Job, JobFailureLogAnnotation)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// annotationPattern returns the pattern in the job annotation or nil
+func annotationPattern(job *batchv1.Job, annotation string) (*regexp.Regexp, error) {
+	expr, ok := job.Annotations[annotation]
+	if !ok {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, trace.BadParameter("invalid %v %q: %v", annotation, expr, err)
+	}
+	return pattern, nil
+}
+
+// checkLogs verifies the logs of the succeeded pods
+// of the complete job against the log patterns
+func (c *JobControl) checkLogs(job *batchv1.Job) error {
+	if c.SuccessLogPattern == nil && c.FailureLogPattern == nil {
+		return nil
+	}
+	pods, err := c.collectPods(job)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var checked int
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodSucceeded {
+			continue
+		}
+		logs, err := c.podLogs(pod)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if err := matchJobLogs(pod.Name, logs, c.SuccessLogPattern, c.FailureLogPattern); err != nil {
+			return trace.Wrap(err)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return trace.CompareFailed("job %v has no succeeded pods to check the logs of", FormatMeta(job.ObjectMeta))
+	}
+	return nil
+}
+
+// podLogs returns the last maxJobLogBytes of the logs of each container of the pod
+func (c *JobControl) podLogs(pod v1.Pod) ([]byte, error) {
+	var logs []byte
+	for _, container := range pod.Spec.Containers {
+		stream, err := c.Core().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+			Container: container.Name,
+		}).Stream()
+		if err != nil {
+			return nil, ConvertError(err)
+		}
+		data, err := readTail(stream, maxJobLogBytes)
+		stream.Close()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		logs = append(logs, data...)
+	}
+	return logs, nil
+}
+
+// readTail returns at most the last max bytes read from the reader,
+// starting with a complete line if the data has been truncated
+func readTail(r io.Reader, max int) ([]byte, error) {
+	var tail []byte
+	var truncated bool
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(chunk)
+		tail = append(tail, chunk[:n]...)
+		if len(tail) > 2*max {
+			tail = append(tail[:0], tail[len(tail)-max:]...)
+			truncated = true
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+	}
+	if len(tail) > max {
+		tail = tail[len(tail)-max:]
+		truncated = true
+	}
+	if truncated {
+		if i := bytes.IndexByte(tail, '\n'); i != -1 {
+			tail = tail[i+1:]
+		}
+	}
+	return tail, nil
+}
+
+// matchJobLogs returns an error if the logs of the pod do not match
+// the success pattern or match the failure pattern, patterns may be nil
+func matchJobLogs(pod string, logs []byte, success, failure *regexp.Regexp) error {
+	if success != nil && !success.Match(logs) {
+		return trace.CompareFailed("pod %v exited successfully but its logs do not match %q", pod, success)
+	}
+	if failure == nil {
+		return nil
+	}
+	if match := failure.Find(logs); match != nil {
+		return trace.CompareFailed("pod %v exited successfully but its logs match %q: %q", pod, failure, match)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"strings"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	"k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// kinds maps the kinds managed by rigging to their preferred group and version
+var kinds = map[string]schema.GroupVersionKind{
+	KindChangeset:             {Group: ChangesetGroup, Version: ChangesetVersion, Kind: KindChangeset},
+	KindConfigMap:             v1.SchemeGroupVersion.WithKind(KindConfigMap),
+	KindNamespace:             v1.SchemeGroupVersion.WithKind(KindNamespace),
+	KindPod:                   v1.SchemeGroupVersion.WithKind(KindPod),
+	KindReplicationController: v1.SchemeGroupVersion.WithKind(KindReplicationController),
+	KindSecret:                v1.SchemeGroupVersion.WithKind(KindSecret),
+	KindService:               v1.SchemeGroupVersion.WithKind(KindService),
+	KindServiceAccount:        v1.SchemeGroupVersion.WithKind(KindServiceAccount),
+	KindDaemonSet:             appsv1.SchemeGroupVersion.WithKind(KindDaemonSet),
+	KindDeployment:            appsv1.SchemeGroupVersion.WithKind(KindDeployment),
+	KindReplicaSet:            appsv1.SchemeGroupVersion.WithKind(KindReplicaSet),
+	KindStatefulSet:           appsv1.SchemeGroupVersion.WithKind(KindStatefulSet),
+	KindJob:                   batchv1.SchemeGroupVersion.WithKind(KindJob),
+	KindCronJob:               batchv1beta1.SchemeGroupVersion.WithKind(KindCronJob),
+	KindRole:                  rbacv1.SchemeGroupVersion.WithKind(KindRole),
+	KindClusterRole:           rbacv1.SchemeGroupVersion.WithKind(KindClusterRole),
+	KindRoleBinding:           rbacv1.SchemeGroupVersion.WithKind(KindRoleBinding),
+	KindClusterRoleBinding:    rbacv1.SchemeGroupVersion.WithKind(KindClusterRoleBinding),
+	KindPodSecurityPolicy:     policyv1beta1.SchemeGroupVersion.WithKind(KindPodSecurityPolicy),
+}
+
+// GVKFor returns the preferred group, version and kind of the kind managed by rigging,
+// returns false if the kind is not known
+func GVKFor(kind string) (schema.GroupVersionKind, bool) {
+	gvk, ok := kinds[kind]
+	return gvk, ok
+}
+
+// APIVersionFor returns the preferred API version of the kind managed by rigging,
+// e.g. apps/v1 for Deployment, empty if the kind is not known
+func APIVersionFor(kind string) string {
+	gvk, ok := kinds[kind]
+	if !ok {
+		return ""
+	}
+	return gvk.GroupVersion().String()
+}
+
+// ResourceDiscovery lists the resources served by the API server,
+// implemented by the discovery client
+type ResourceDiscovery interface {
+	// ServerResources returns the resources of all groups and versions
+	ServerResources() ([]*metav1.APIResourceList, error)
+}
+
+// LookupGVK returns the group, version and kind the API server serves the kind with.
+// The preferred version of the kinds managed by rigging is used if served,
+// otherwise another version of the same group, e.g. extensions/v1beta1 for PodSecurityPolicy
+// on older clusters, and then any group serving the kind.
+// Returns NotFound if the kind is not served
+func LookupGVK(client ResourceDiscovery, kind string) (schema.GroupVersionKind, error) {
+	lists, err := client.ServerResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) || len(lists) == 0 {
+			return schema.GroupVersionKind{}, ConvertError(err)
+		}
+		log.Warningf("partial API discovery: %v", err)
+	}
+	var served []schema.GroupVersionKind
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return schema.GroupVersionKind{}, trace.Wrap(err)
+		}
+		for _, resource := range list.APIResources {
+			if resource.Kind == kind && !strings.Contains(resource.Name, "/") {
+				served = append(served, gv.WithKind(kind))
+			}
+		}
+	}
+	if len(served) == 0 {
+		return schema.GroupVersionKind{}, trace.NotFound("kind %v is not served by the API server", kind)
+	}
+	preferred, ok := kinds[kind]
+	if !ok {
+		return served[0], nil
+	}
+	for _, gvk := range served {
+		if gvk == preferred {
+			return gvk, nil
+		}
+	}
+	for _, gvk := range served {
+		if gvk.Group == preferred.Group {
+			return gvk, nil
+		}
+	}
+	return served[0], nil
+}
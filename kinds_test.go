@@ -0,0 +1,96 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type KindsSuite struct{}
+
+var _ = Suite(&KindsSuite{})
+
+// staticDiscovery serves a fixed list of resources
+type staticDiscovery []*metav1.APIResourceList
+
+func (d staticDiscovery) ServerResources() ([]*metav1.APIResourceList, error) {
+	return d, nil
+}
+
+func (s *KindsSuite) TestAPIVersionFor(c *C) {
+	c.Assert(APIVersionFor(KindDeployment), Equals, "apps/v1")
+	c.Assert(APIVersionFor(KindSecret), Equals, "v1")
+	c.Assert(APIVersionFor(KindCronJob), Equals, "batch/v1beta1")
+	c.Assert(APIVersionFor("Widget"), Equals, "")
+}
+
+func (s *KindsSuite) TestLookupGVK(c *C) {
+	server := staticDiscovery{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "pods", Kind: "Pod"},
+			{Name: "pods/eviction", Kind: "Eviction"},
+		}},
+		{GroupVersion: "extensions/v1beta1", APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment"},
+			{Name: "podsecuritypolicies", Kind: "PodSecurityPolicy"},
+		}},
+		{GroupVersion: "apps/v1beta2", APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment"},
+		}},
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment"},
+		}},
+		{GroupVersion: "example.com/v1", APIResources: []metav1.APIResource{
+			{Name: "widgets", Kind: "Widget"},
+		}},
+	}
+	tcs := []struct {
+		kind string
+		gvk  schema.GroupVersionKind
+	}{
+		{kind: KindPod, gvk: schema.GroupVersionKind{Version: "v1", Kind: KindPod}},
+		// preferred version is served
+		{kind: KindDeployment, gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: KindDeployment}},
+		// preferred group is not served, falls back to another group
+		{kind: KindPodSecurityPolicy, gvk: schema.GroupVersionKind{Group: "extensions", Version: "v1beta1", Kind: KindPodSecurityPolicy}},
+		// kinds not managed by rigging use the first served version
+		{kind: "Widget", gvk: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}},
+	}
+	for _, tc := range tcs {
+		gvk, err := LookupGVK(server, tc.kind)
+		c.Assert(err, IsNil, Commentf(tc.kind))
+		c.Assert(gvk, Equals, tc.gvk, Commentf(tc.kind))
+	}
+
+	olderServer := staticDiscovery{
+		{GroupVersion: "apps/v1beta2", APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment"},
+		}},
+		{GroupVersion: "extensions/v1beta1", APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment"},
+		}},
+	}
+	gvk, err := LookupGVK(olderServer, KindDeployment)
+	c.Assert(err, IsNil)
+	c.Assert(gvk, Equals, schema.GroupVersionKind{Group: "apps", Version: "v1beta2", Kind: KindDeployment})
+
+	_, err = LookupGVK(server, "Eviction")
+	c.Assert(trace.IsNotFound(err), Equals, true)
+}
@@ -0,0 +1,516 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const (
+	// KubectlPath is the default path to the kubectl binary
+	KubectlPath = "/usr/local/bin/kubectl"
+	// DefaultKubectlRetryAttempts is the default number of attempts
+	// of kubectl commands failing with transient errors
+	DefaultKubectlRetryAttempts = 3
+	// DefaultKubectlRetryPeriod is the default initial delay between attempts
+	DefaultKubectlRetryPeriod = time.Second
+)
+
+// transientErrors lists kubectl error output fragments of failures
+// caused by temporary API server or network unavailability
+var transientErrors = []string{
+	"connection refused",
+	"connection reset by peer",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"Unable to connect to the server",
+	"etcdserver: leader changed",
+	"etcdserver: request timed out",
+	"the server is currently unable to handle the request",
+	"http2: server sent GOAWAY",
+}
+
+// IsTransientKubectlError returns true if the kubectl error output
+// indicates a temporary failure that is likely to succeed on retry
+func IsTransientKubectlError(stderr []byte) bool {
+	for _, fragment := range transientErrors {
+		if bytes.Contains(stderr, []byte(fragment)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Kubectl runs kubectl commands against a specific kubeconfig, context and
+// namespace without relying on the process environment.
+// The zero value uses the kubectl defaults
+type Kubectl struct {
+	// Path is the path to the kubectl binary, defaults to KubectlPath
+	Path string
+	// Kubeconfig is the path to the kubeconfig file
+	Kubeconfig string
+	// Context is the kubeconfig context to use
+	Context string
+	// Namespace is the default namespace of the resources
+	Namespace string
+	// Proxy is the URL of the HTTPS proxy the API server is reached through,
+	// e.g. http://bastion:3128. It is set in the kubectl environment only
+	// and overrides the proxy of the process environment
+	Proxy string
+	// CAFile is the path to the CA bundle used to verify the API server
+	CAFile string
+	// TLSServerName is the server name used to verify the API server
+	// certificate if it differs from the host the server is reached at
+	TLSServerName string
+	// RetryAttempts is the number of attempts of idempotent commands failing with
+	// transient errors, defaults to DefaultKubectlRetryAttempts, 1 disables retries
+	RetryAttempts int
+	// RetryPeriod is the initial delay between attempts, doubled after
+	// each attempt, defaults to DefaultKubectlRetryPeriod
+	RetryPeriod time.Duration
+	// Output, if set, receives the combined standard output and error
+	// line by line as kubectl runs, e.g. to show the progress of long applies.
+	// The output is still returned in the result
+	Output io.Writer
+	// Plugins maps custom actions to the kubectl subcommands or plugins that
+	// perform them, e.g. Action("neat"): {"neat"} runs kubectl-neat.
+	// The actions are accepted by FromFile, FromStdIn and RunPlugin
+	Plugins map[Action][]string
+}
+
+// Command returns an exec.Command for kubectl with the configured flags
+// followed by the supplied arguments
+func (k Kubectl) Command(args ...string) *exec.Cmd {
+	return k.CommandContext(context.Background(), args...)
+}
+
+// CommandContext returns an exec.Command for kubectl like Command
+// that is killed once the context is done
+func (k Kubectl) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, k.path(), k.argv(args)...)
+	if k.Proxy != "" {
+		cmd.Env = proxyEnv(os.Environ(), k.Proxy)
+	}
+	return cmd
+}
+
+func (k Kubectl) path() string {
+	if k.Path == "" {
+		return KubectlPath
+	}
+	return k.Path
+}
+
+// KubectlVersion is the version of the kubectl client
+// and of the API server it is connected to
+type KubectlVersion struct {
+	// Client is the kubectl client version
+	Client version.Info `json:"clientVersion"`
+	// Server is the API server version
+	Server version.Info `json:"serverVersion"`
+}
+
+// Check verifies that the kubectl binary exists and is executable
+// and that its version is within the supported skew of the API server version,
+// so that the changesets do not fail midway with exec errors.
+// Returns NotFound if the binary is missing, AccessDenied if it is not executable
+// and CompareFailed if the client and server versions are incompatible
+func (k Kubectl) Check() (*KubectlVersion, error) {
+	path := k.path()
+	if !strings.Contains(path, string(os.PathSeparator)) {
+		resolved, err := exec.LookPath(path)
+		if err != nil {
+			return nil, trace.NotFound("kubectl %q not found in PATH", path)
+		}
+		path = resolved
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, trace.NotFound("kubectl not found at %v", path)
+		}
+		return nil, trace.ConvertSystemError(err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return nil, trace.AccessDenied("kubectl at %v is not executable", path)
+	}
+	result, err := k.Run(nil, "version", "--output", "json")
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to determine kubectl version: %v", err)
+	}
+	var versions KubectlVersion
+	if err := json.Unmarshal(result.Stdout, &versions); err != nil {
+		return nil, trace.BadParameter("failed to parse kubectl version output %q: %v", result.Stdout, err)
+	}
+	if err := checkVersionSkew(versions.Client, versions.Server); err != nil {
+		return &versions, trace.Wrap(err)
+	}
+	return &versions, nil
+}
+
+// kubectlVersionSkew is the number of minor versions kubectl
+// is supported to be older or newer than the API server
+const kubectlVersionSkew = 1
+
+// checkVersionSkew returns CompareFailed if the client version
+// is not within the supported skew of the server version
+func checkVersionSkew(client, server version.Info) error {
+	clientMinor, err := parseMinorVersion(client)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	serverMinor, err := parseMinorVersion(server)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	skew := clientMinor - serverMinor
+	if client.Major != server.Major || skew > kubectlVersionSkew || skew < -kubectlVersionSkew {
+		return trace.CompareFailed("kubectl version %v is incompatible with server version %v, "+
+			"kubectl must be within %v minor version of the server",
+			client.GitVersion, server.GitVersion, kubectlVersionSkew)
+	}
+	return nil
+}
+
+// parseMinorVersion returns the minor version, e.g. 11 for "11+"
+func parseMinorVersion(info version.Info) (int, error) {
+	minor, err := strconv.Atoi(strings.TrimRight(info.Minor, "+"))
+	if err != nil {
+		return 0, trace.BadParameter("failed to parse version %q", info.GitVersion)
+	}
+	return minor, nil
+}
+
+// argv returns the arguments with the global flags inserted after the leading
+// subcommand words, e.g. "apply" or the plugin name, as kubectl finds plugins
+// by the first arguments and does not recognize them after flags
+func (k Kubectl) argv(args []string) []string {
+	i := 0
+	for i < len(args) && !strings.HasPrefix(args[i], "-") {
+		i++
+	}
+	argv := append([]string(nil), args[:i]...)
+	argv = append(argv, k.flags()...)
+	return append(argv, args[i:]...)
+}
+
+func (k Kubectl) flags() []string {
+	var flags []string
+	if k.Kubeconfig != "" {
+		flags = append(flags, "--kubeconfig", k.Kubeconfig)
+	}
+	if k.Context != "" {
+		flags = append(flags, "--context", k.Context)
+	}
+	if k.Namespace != "" {
+		flags = append(flags, "--namespace", k.Namespace)
+	}
+	if k.CAFile != "" {
+		flags = append(flags, "--certificate-authority", k.CAFile)
+	}
+	if k.TLSServerName != "" {
+		flags = append(flags, "--tls-server-name", k.TLSServerName)
+	}
+	return flags
+}
+
+// proxyEnv returns the environment with the proxy variables replaced by the proxy
+func proxyEnv(environ []string, proxy string) []string {
+	env := make([]string, 0, len(environ)+2)
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		switch strings.ToUpper(name) {
+		case "HTTPS_PROXY", "HTTP_PROXY":
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, "HTTPS_PROXY="+proxy, "https_proxy="+proxy)
+}
+
+// KubectlResult is the result of a kubectl invocation
+type KubectlResult struct {
+	// Stdout is the standard output, e.g. the applied objects
+	Stdout []byte
+	// Stderr is the standard error with warnings and errors
+	Stderr []byte
+	// ExitCode is the exit code of the command
+	ExitCode int
+}
+
+// Output returns the standard output followed by the standard error
+func (r *KubectlResult) Output() []byte {
+	if r == nil {
+		return nil
+	}
+	return append(append([]byte(nil), r.Stdout...), r.Stderr...)
+}
+
+// Run runs kubectl with the supplied arguments and optional standard input,
+// see RunContext
+func (k Kubectl) Run(stdin io.Reader, args ...string) (*KubectlResult, error) {
+	return k.RunContext(context.Background(), stdin, args...)
+}
+
+// RunContext runs kubectl with the supplied arguments and optional standard input,
+// kubectl is killed once the context is done. Idempotent commands, i.e. get
+// and apply, failing with transient errors are retried with exponential backoff
+// until the context is done. The output of every attempt is streamed as it runs,
+// attempts are separated with a line reporting the retry.
+// The result is returned if the command has started, even if it failed
+func (k Kubectl) RunContext(ctx context.Context, stdin io.Reader, args ...string) (*KubectlResult, error) {
+	if len(args) == 0 {
+		return nil, trace.BadParameter("missing kubectl command")
+	}
+	var input []byte
+	if stdin != nil {
+		var err error
+		input, err = ioutil.ReadAll(stdin)
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+	}
+	if !idempotentCommand(args[0]) {
+		return k.run(ctx, input, args...)
+	}
+	attempts := k.RetryAttempts
+	if attempts <= 0 {
+		attempts = DefaultKubectlRetryAttempts
+	}
+	period := k.RetryPeriod
+	if period == 0 {
+		period = DefaultKubectlRetryPeriod
+	}
+	for i := 1; ; i++ {
+		result, err := k.run(ctx, input, args...)
+		if err == nil || result == nil || i >= attempts || !IsTransientKubectlError(result.Stderr) {
+			return result, trace.Wrap(err)
+		}
+		log.Warningf("kubectl %v failed with a transient error, retry in %v: %s",
+			args[0], period, bytes.TrimSpace(result.Stderr))
+		if k.Output != nil {
+			fmt.Fprintf(k.Output, "kubectl %v failed with a transient error, retry %v of %v in %v\n",
+				args[0], i, attempts-1, period)
+		}
+		select {
+		case <-time.After(period):
+		case <-ctx.Done():
+			return result, trace.Wrap(err)
+		}
+		period *= 2
+	}
+}
+
+// idempotentCommand returns true if the kubectl command can be safely retried
+func idempotentCommand(command string) bool {
+	switch command {
+	case "get", string(ActionApply):
+		return true
+	}
+	return false
+}
+
+func (k Kubectl) run(ctx context.Context, stdin []byte, args ...string) (*KubectlResult, error) {
+	cmd := k.CommandContext(ctx, args...)
+	var stdout, stderr bytes.Buffer
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if k.Output != nil {
+		output := &syncWriter{w: k.Output}
+		stdoutLines, stderrLines := &lineWriter{w: output}, &lineWriter{w: output}
+		defer stdoutLines.Flush()
+		defer stderrLines.Flush()
+		cmd.Stdout = io.MultiWriter(&stdout, stdoutLines)
+		cmd.Stderr = io.MultiWriter(&stderr, stderrLines)
+	}
+	err := cmd.Run()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, trace.Wrap(err)
+		}
+		result := &KubectlResult{
+			Stdout:   stdout.Bytes(),
+			Stderr:   stderr.Bytes(),
+			ExitCode: exitCode(exitErr),
+		}
+		if denied := parseAdmissionDenied(string(result.Stderr)); denied != nil {
+			return result, trace.Wrap(denied, "kubectl %v: %s", args[0], bytes.TrimSpace(result.Stderr))
+		}
+		return result, trace.Wrap(err, "kubectl %v: %s", args[0], bytes.TrimSpace(result.Stderr))
+	}
+	return &KubectlResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+}
+
+// RunFromFile performs action on the Kubernetes resources specified in the path, see FromFile
+func (k Kubectl) RunFromFile(act Action, path string, args ...string) (*KubectlResult, error) {
+	return k.fromPath(act, path, false, args...)
+}
+
+// RunFromStdIn performs action on the Kubernetes resources specified in the string, see FromStdIn
+func (k Kubectl) RunFromStdIn(act Action, data string, args ...string) (*KubectlResult, error) {
+	if err := k.checkAction(act); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	result, err := k.Run(strings.NewReader(data), append(append(k.subcommand(act), "-f", "-"), args...)...)
+	if err != nil {
+		log.Errorf("%v", err)
+		return result, trace.Wrap(err)
+	}
+	return result, nil
+}
+
+// FromFile performs action on the Kubernetes resources specified in the path supplied as an argument.
+// The path is loaded with NewSource: "-" is the standard input, http and https URLs are fetched,
+// anything else is a file, a directory or a glob pattern read in sorted order.
+// Additional arguments are passed to kubectl, e.g. the patch for ActionPatch.
+// Returns the standard output followed by the standard error
+func (k Kubectl) FromFile(act Action, path string, args ...string) ([]byte, error) {
+	result, err := k.fromPath(act, path, false, args...)
+	return result.Output(), err
+}
+
+// FromFileRecursive performs action on the Kubernetes resources specified in the path
+// supplied as an argument including manifests in all subdirectories
+func (k Kubectl) FromFileRecursive(act Action, path string, args ...string) ([]byte, error) {
+	result, err := k.fromPath(act, path, true, args...)
+	return result.Output(), err
+}
+
+func (k Kubectl) fromPath(act Action, path string, recursive bool, args ...string) (*KubectlResult, error) {
+	return k.RunFromSource(context.TODO(), act, NewSource(path, recursive, os.Stdin), args...)
+}
+
+// RunFromSource performs action on the Kubernetes resources loaded from the source,
+// the manifest stream is passed to kubectl on the standard input
+func (k Kubectl) RunFromSource(ctx context.Context, act Action, source Source, args ...string) (*KubectlResult, error) {
+	if err := k.checkAction(act); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	data, err := source.Load(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, trace.NotFound("no manifests found in %v", source)
+	}
+	return k.RunFromStdIn(act, string(data), args...)
+}
+
+// FromSource performs action on the Kubernetes resources loaded from the source.
+// Returns the standard output followed by the standard error
+func (k Kubectl) FromSource(ctx context.Context, act Action, source Source, args ...string) ([]byte, error) {
+	result, err := k.RunFromSource(ctx, act, source, args...)
+	return result.Output(), err
+}
+
+// FromStdIn performs action on the Kubernetes resources specified in the string supplied as an argument.
+// Additional arguments are passed to kubectl.
+// Returns the standard output followed by the standard error
+func (k Kubectl) FromStdIn(act Action, data string, args ...string) ([]byte, error) {
+	result, err := k.RunFromStdIn(act, data, args...)
+	return result.Output(), err
+}
+
+// LineFunc adapts a function to receive kubectl output line by line
+// as Kubectl.Output, lines are passed without the trailing newline
+type LineFunc func(line string)
+
+// Write passes the line to the function
+func (f LineFunc) Write(p []byte) (int, error) {
+	f(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// lineWriter writes complete lines to the underlying writer,
+// buffering incomplete lines until the newline or Flush.
+// Streaming stops after the first write error so that
+// a failing writer does not fail the command
+type lineWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// Write writes the complete lines of p and buffers the rest
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		l.write(l.buf[:i+1])
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// Flush writes the buffered incomplete line terminated by a newline
+func (l *lineWriter) Flush() {
+	if len(l.buf) == 0 {
+		return
+	}
+	line := append(l.buf, '\n')
+	l.buf = nil
+	l.write(line)
+}
+
+func (l *lineWriter) write(line []byte) {
+	if _, err := l.w.Write(line); err != nil {
+		log.Warningf("failed to stream kubectl output: %v", err)
+		l.w = ioutil.Discard
+	}
+}
+
+// syncWriter serializes writes of the standard output and error
+type syncWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// Write writes p to the underlying writer
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.w.Write(p)
+}
+
+// exitCode returns the exit code of the failed command
+func exitCode(err *exec.ExitError) int {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok {
+		return status.ExitStatus()
+	}
+	return -1
+}
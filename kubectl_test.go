@@ -0,0 +1,212 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type KubectlSuite struct{}
+
+var _ = Suite(&KubectlSuite{})
+
+func (s *KubectlSuite) TestStreamOutput(c *C) {
+	path := filepath.Join(c.MkDir(), "kubectl")
+	script := "#!/bin/sh\necho deployment.apps/app configured\necho warning: deprecated >&2\nprintf 'service/app unchanged'\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+
+	var lines []string
+	kubectl := Kubectl{Path: path, Output: LineFunc(func(line string) {
+		lines = append(lines, line)
+	})}
+	result, err := kubectl.Run(nil, "apply")
+	c.Assert(err, IsNil)
+	c.Assert(string(result.Stdout), Equals, "deployment.apps/app configured\nservice/app unchanged")
+	c.Assert(string(result.Stderr), Equals, "warning: deprecated\n")
+	// standard output and error are read concurrently,
+	// so only the order of the lines of each stream is defined
+	sort.Strings(lines)
+	c.Assert(lines, DeepEquals, []string{
+		"deployment.apps/app configured",
+		"service/app unchanged",
+		"warning: deprecated",
+	})
+}
+
+func (s *KubectlSuite) TestCheck(c *C) {
+	dir := c.MkDir()
+	_, err := Kubectl{Path: filepath.Join(dir, "missing")}.Check()
+	c.Assert(trace.IsNotFound(err), Equals, true, Commentf("%v", err))
+
+	path := filepath.Join(dir, "kubectl")
+	c.Assert(ioutil.WriteFile(path, nil, 0644), IsNil)
+	_, err = Kubectl{Path: path}.Check()
+	c.Assert(trace.IsAccessDenied(err), Equals, true, Commentf("%v", err))
+	c.Assert(os.Chmod(path, 0755), IsNil)
+
+	version := func(client, server string) []byte {
+		return []byte(fmt.Sprintf("#!/bin/sh\ncat <<EOF\n"+
+			`{"clientVersion": {"major": "1", "minor": %q, "gitVersion": "v1.%v.0"}, `+
+			`"serverVersion": {"major": "1", "minor": %q, "gitVersion": "v1.%v.0"}}`+
+			"\nEOF\n", client, client, server, server))
+	}
+	c.Assert(ioutil.WriteFile(path, version("12", "11+"), 0755), IsNil)
+	versions, err := Kubectl{Path: path}.Check()
+	c.Assert(err, IsNil)
+	c.Assert(versions.Client.GitVersion, Equals, "v1.12.0")
+	c.Assert(versions.Server.GitVersion, Equals, "v1.11+.0")
+
+	c.Assert(ioutil.WriteFile(path, version("9", "11"), 0755), IsNil)
+	_, err = Kubectl{Path: path}.Check()
+	c.Assert(trace.IsCompareFailed(err), Equals, true, Commentf("%v", err))
+}
+
+func (s *KubectlSuite) TestPlugins(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "kubectl")
+	// the fake kubectl prints its arguments followed by the standard input
+	script := "#!/bin/sh\necho \"$@\"\ncat\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+
+	kubectl := Kubectl{Path: path, Plugins: map[Action][]string{"slice": {"slice", "--stdout"}}}
+	out, err := kubectl.FromStdIn("slice", "kind: Pod\n")
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "slice --stdout -f -\nkind: Pod\n")
+
+	_, err = kubectl.FromStdIn("neat", "kind: Pod\n")
+	c.Assert(trace.IsBadParameter(err), Equals, true, Commentf("%v", err))
+
+	plugin, err := ParseKubectlPlugin(Kubectl{Path: path}, "neat --output yaml")
+	c.Assert(err, IsNil)
+	out, err = plugin.Transform([]byte("kind: Pod\n"))
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "neat --output yaml\nkind: Pod\n")
+
+	// global flags follow the plugin name
+	kubectl.Kubeconfig, kubectl.Context = "/etc/kube/config", "prod"
+	out, err = kubectl.FromStdIn("slice", "kind: Pod\n")
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "slice --kubeconfig /etc/kube/config --context prod --stdout -f -\nkind: Pod\n")
+	result, err := kubectl.Run(nil, "get", "pods", "-o", "name")
+	c.Assert(err, IsNil)
+	c.Assert(string(result.Stdout), Equals, "get pods --kubeconfig /etc/kube/config --context prod -o name\n")
+}
+
+func (s *KubectlSuite) TestRetry(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "kubectl")
+	// the fake kubectl fails with a transient error until it has run twice
+	script := "#!/bin/sh\necho attempt >> " + filepath.Join(dir, "attempts") + "\n" +
+		"if [ $(wc -l < " + filepath.Join(dir, "attempts") + ") -lt 3 ]; then echo 'connection refused' >&2; exit 1; fi\n" +
+		"echo done\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+	attempts := func() int {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "attempts"))
+		c.Assert(err, IsNil)
+		defer os.Remove(filepath.Join(dir, "attempts"))
+		return strings.Count(string(data), "\n")
+	}
+
+	var lines []string
+	kubectl := Kubectl{Path: path, RetryPeriod: time.Millisecond, Output: LineFunc(func(line string) {
+		lines = append(lines, line)
+	})}
+	result, err := kubectl.Run(nil, "get", "pods")
+	c.Assert(err, IsNil)
+	c.Assert(string(result.Stdout), Equals, "done\n")
+	c.Assert(attempts(), Equals, 3)
+	// the output of every attempt is streamed followed by the retry
+	c.Assert(lines, DeepEquals, []string{
+		"connection refused",
+		"kubectl get failed with a transient error, retry 1 of 2 in 1ms",
+		"connection refused",
+		"kubectl get failed with a transient error, retry 2 of 2 in 2ms",
+		"done",
+	})
+
+	// commands that are not idempotent are not retried
+	_, err = kubectl.Run(nil, "create", "-f", "-")
+	c.Assert(err, NotNil)
+	c.Assert(attempts(), Equals, 1)
+
+	// retries stop when the context is done
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	kubectl.RetryPeriod = time.Minute
+	_, err = kubectl.RunContext(ctx, nil, "get", "pods")
+	c.Assert(err, NotNil)
+	c.Assert(attempts(), Equals, 1)
+
+	// running kubectl is killed when the context is done
+	script = "#!/bin/sh\nexec sleep 60\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+	ctx, cancel = context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = kubectl.RunContext(ctx, nil, "apply", "-f", "-")
+	c.Assert(err, NotNil)
+	c.Assert(time.Since(start) < 10*time.Second, Equals, true)
+}
+
+func (s *KubectlSuite) TestFromSource(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "kubectl")
+	// the fake kubectl prints its arguments followed by the standard input
+	script := "#!/bin/sh\necho \"$@\"\ncat\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+	manifests := filepath.Join(dir, "manifests")
+	c.Assert(os.MkdirAll(manifests, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(manifests, "b.yaml"), []byte("kind: Service"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(manifests, "a.yaml"), []byte("kind: Pod"), 0644), IsNil)
+
+	kubectl := Kubectl{Path: path}
+	out, err := kubectl.FromFile(ActionApply, manifests)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "apply -f -\n---\nkind: Pod\n---\nkind: Service\n")
+
+	out, err = kubectl.FromSource(context.TODO(), ActionApply, &ReaderSource{Name: "test", Reader: strings.NewReader("kind: Pod\n")})
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "apply -f -\nkind: Pod\n")
+
+	_, err = kubectl.FromFile(ActionApply, filepath.Join(dir, "*.json"))
+	c.Assert(trace.IsNotFound(err), Equals, true, Commentf("%v", err))
+
+	// plain HTTP URLs are fetched like kubectl does
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "kind: Pod\n")
+	}))
+	defer server.Close()
+	out, err = kubectl.FromFile(ActionApply, server.URL+"/pod.yaml")
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "apply -f -\nkind: Pod\n")
+
+	// credentials are not sent over plain HTTP
+	_, err = (&URLSource{URL: server.URL, Token: "secret", AllowHTTP: true}).Load(context.TODO())
+	c.Assert(trace.IsBadParameter(err), Equals, true, Commentf("%v", err))
+}
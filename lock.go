@@ -0,0 +1,349 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// LockLabel marks lock config maps with the name of the lock
+	LockLabel = "rigging.gravitational.io/lock"
+	// LockPrefix is the name prefix of lock config maps
+	LockPrefix = "rigging-lock-"
+	// LockRecordKey is the lock config map key with the lock record
+	LockRecordKey = "record"
+	// DefaultLockDuration is the default duration after which a lock
+	// that has not been renewed is considered stale and can be taken over
+	DefaultLockDuration = 2 * time.Minute
+)
+
+// LockRecord describes the holder of a lock
+type LockRecord struct {
+	// Holder identifies the operator holding the lock, e.g. alice@laptop/1234
+	Holder string `json:"holder"`
+	// Changeset is the changeset applied under the lock
+	Changeset string `json:"changeset,omitempty"`
+	// AcquiredAt is the time the lock was acquired
+	AcquiredAt time.Time `json:"acquiredAt"`
+	// RenewedAt is the time the lock was last renewed
+	RenewedAt time.Time `json:"renewedAt"`
+	// Duration is how long the lock is valid after it was renewed
+	Duration time.Duration `json:"duration"`
+}
+
+// Expired returns true if the lock has not been renewed in time
+func (r LockRecord) Expired(now time.Time) bool {
+	return now.After(r.RenewedAt.Add(r.Duration))
+}
+
+// String returns a human readable lock description
+func (r LockRecord) String() string {
+	return fmt.Sprintf("held by %v for changeset %q since %v", r.Holder, r.Changeset, r.AcquiredAt.Format(time.RFC3339))
+}
+
+// LockConfig is a lock configuration
+type LockConfig struct {
+	// Name identifies the lock, e.g. the application the changesets update
+	Name string
+	// Namespace is the namespace of the lock config map
+	Namespace string
+	// Holder identifies the lock holder, DefaultLockHolder if unset
+	Holder string
+	// Changeset is the changeset applied under the lock, recorded for other operators
+	Changeset string
+	// Duration is how long the lock stays valid without renewal, DefaultLockDuration if unset
+	Duration time.Duration
+	// ConfigMaps is the client storing the lock config maps
+	ConfigMaps corev1.ConfigMapsGetter
+	// Clock returns the current time, time.Now if unset
+	Clock func() time.Time
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *LockConfig) CheckAndSetDefaults() error {
+	var errors []error
+	if c.Name == "" {
+		errors = append(errors, trace.BadParameter("missing parameter Name"))
+	}
+	if c.ConfigMaps == nil {
+		errors = append(errors, trace.BadParameter("missing parameter ConfigMaps"))
+	}
+	c.Namespace = Namespace(c.Namespace)
+	if c.Holder == "" {
+		c.Holder = DefaultLockHolder()
+	}
+	if c.Duration == 0 {
+		c.Duration = DefaultLockDuration
+	}
+	if c.Clock == nil {
+		c.Clock = time.Now
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// DefaultLockHolder returns the identity of this process, user@host/pid
+func DefaultLockHolder() string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%v@%v/%v", name, host, os.Getpid())
+}
+
+// NewLock returns a new cluster-wide lock
+func NewLock(config LockConfig) (*Lock, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Lock{
+		LockConfig: config,
+		Entry: log.WithFields(log.Fields{
+			"lock": config.Namespace + "/" + config.Name,
+		}),
+	}, nil
+}
+
+// Lock is a cluster-wide lock stored in a config map, it prevents operators
+// from applying conflicting changesets to the same application simultaneously.
+// The holder renews the lock periodically, a lock that has not been renewed
+// within its duration is stale and is taken over by the next operator
+type Lock struct {
+	LockConfig
+	*log.Entry
+}
+
+// ConfigMapName returns the name of the lock config map
+func (l *Lock) ConfigMapName() string {
+	return LockPrefix + l.Name
+}
+
+// Acquire acquires the lock, takes over a stale lock or renews the lock
+// already held by this holder. Returns CompareFailed if the lock is held by another holder
+func (l *Lock) Acquire(ctx context.Context) error {
+	now := l.Clock().UTC()
+	record := LockRecord{
+		Holder:     l.Holder,
+		Changeset:  l.Changeset,
+		AcquiredAt: now,
+		RenewedAt:  now,
+		Duration:   l.Duration,
+	}
+	configMaps := l.ConfigMaps.ConfigMaps(l.Namespace)
+	configMap, err := l.configMap(record)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = configMaps.Create(configMap)
+	err = ConvertError(err)
+	if err == nil {
+		l.Infof("acquired lock for changeset %q", l.Changeset)
+		return nil
+	}
+	if !trace.IsAlreadyExists(err) {
+		return trace.Wrap(err)
+	}
+	current, existing, err := l.get()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	switch {
+	case existing.Holder == l.Holder:
+		record.AcquiredAt = existing.AcquiredAt
+	case existing.Expired(now):
+		l.Warningf("taking over stale lock %v, last renewed at %v", existing, existing.RenewedAt.Format(time.RFC3339))
+	default:
+		return trace.CompareFailed("lock %v is %v", l.Name, existing)
+	}
+	return trace.Wrap(l.update(current, record))
+}
+
+// Renew extends the lock held by this holder.
+// Returns CompareFailed if the lock has been taken over
+func (l *Lock) Renew(ctx context.Context) error {
+	current, existing, err := l.get()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if existing.Holder != l.Holder {
+		return trace.CompareFailed("lock %v has been taken over, it is %v", l.Name, existing)
+	}
+	existing.RenewedAt = l.Clock().UTC()
+	return trace.Wrap(l.update(current, *existing))
+}
+
+// Release releases the lock held by this holder, releasing a lock
+// that does not exist or is held by another holder is a no-op
+func (l *Lock) Release(ctx context.Context) error {
+	current, existing, err := l.get()
+	if err != nil {
+		if trace.IsNotFound(err) {
+			return nil
+		}
+		return trace.Wrap(err)
+	}
+	if existing.Holder != l.Holder {
+		l.Warningf("not releasing lock %v", existing)
+		return nil
+	}
+	uid := current.UID
+	err = l.ConfigMaps.ConfigMaps(l.Namespace).Delete(current.Name, &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+	err = ConvertError(err)
+	if err != nil && !trace.IsNotFound(err) {
+		return trace.Wrap(err)
+	}
+	l.Info("released lock")
+	return nil
+}
+
+// Get returns the current lock record, NotFound if the lock is not held
+func (l *Lock) Get(ctx context.Context) (*LockRecord, error) {
+	_, record, err := l.get()
+	return record, trace.Wrap(err)
+}
+
+// KeepAlive renews the lock periodically until the context is cancelled,
+// onLost is called if the lock could not be renewed before it expired
+func (l *Lock) KeepAlive(ctx context.Context, onLost func(error)) {
+	ticker := time.NewTicker(l.Duration / 3)
+	defer ticker.Stop()
+	renewed := l.Clock()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := l.Renew(ctx)
+		if err == nil {
+			renewed = l.Clock()
+			continue
+		}
+		if trace.IsCompareFailed(err) || l.Clock().After(renewed.Add(l.Duration)) {
+			onLost(err)
+			return
+		}
+		l.Warningf("failed to renew lock, will retry: %v", err)
+	}
+}
+
+// WithLock acquires the lock, runs fn while the lock is renewed and releases the lock.
+// The context passed to fn is cancelled if the lock is lost and the lost lock
+// is reported even if fn succeeds
+func WithLock(ctx context.Context, config LockConfig, fn func(context.Context) error) error {
+	lock, err := NewLock(config)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := lock.Acquire(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lostC := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lock.KeepAlive(ctx, func(err error) {
+			lock.Errorf("lost lock, stopping: %v", err)
+			lostC <- err
+			cancel()
+		})
+	}()
+	err = fn(ctx)
+	cancel()
+	<-done
+	if errRelease := lock.Release(context.Background()); errRelease != nil {
+		lock.Warningf("failed to release lock: %v", errRelease)
+	}
+	select {
+	case lost := <-lostC:
+		if err != nil {
+			return trace.Wrap(err, "lost lock %v: %v: %v", config.Name, lost, err)
+		}
+		// fn has completed without the lock
+		return trace.Wrap(lost, "lost lock %v", config.Name)
+	default:
+	}
+	return trace.Wrap(err)
+}
+
+// get returns the lock config map and the lock record
+func (l *Lock) get() (*v1.ConfigMap, *LockRecord, error) {
+	configMap, err := l.ConfigMaps.ConfigMaps(l.Namespace).Get(l.ConfigMapName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, ConvertError(err)
+	}
+	var record LockRecord
+	if err := json.Unmarshal([]byte(configMap.Data[LockRecordKey]), &record); err != nil {
+		return nil, nil, trace.BadParameter("malformed lock record in %v: %v", FormatMeta(configMap.ObjectMeta), err)
+	}
+	return configMap, &record, nil
+}
+
+// update replaces the lock record, returns CompareFailed
+// if the lock has been modified since it was read
+func (l *Lock) update(current *v1.ConfigMap, record LockRecord) error {
+	configMap, err := l.configMap(record)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	configMap.ResourceVersion = current.ResourceVersion
+	_, err = l.ConfigMaps.ConfigMaps(l.Namespace).Update(configMap)
+	if errors.IsConflict(err) {
+		return trace.CompareFailed("lock %v has been modified concurrently", l.Name)
+	}
+	return ConvertError(err)
+}
+
+// configMap returns the lock config map with the record
+func (l *Lock) configMap(record LockRecord) (*v1.ConfigMap, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       KindConfigMap,
+			APIVersion: APIVersionFor(KindConfigMap),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      l.ConfigMapName(),
+			Namespace: l.Namespace,
+			Labels:    map[string]string{LockLabel: l.Name},
+		},
+		Data: map[string]string{LockRecordKey: string(data)},
+	}, nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type LockSuite struct{}
+
+var _ = Suite(&LockSuite{})
+
+func (s *LockSuite) TestLock(c *C) {
+	ctx := context.TODO()
+	configMaps := newMemConfigMaps()
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	newLock := func(holder string) *Lock {
+		lock, err := NewLock(LockConfig{
+			Name:       "app",
+			Holder:     holder,
+			Changeset:  holder + "-cs",
+			Duration:   time.Minute,
+			ConfigMaps: configMaps,
+			Clock:      clock,
+		})
+		c.Assert(err, IsNil)
+		return lock
+	}
+	alice, bob := newLock("alice"), newLock("bob")
+
+	c.Assert(alice.Acquire(ctx), IsNil)
+	err := bob.Acquire(ctx)
+	c.Assert(trace.IsCompareFailed(err), Equals, true, Commentf("%v", err))
+	c.Assert(alice.Acquire(ctx), IsNil)
+
+	now = now.Add(30 * time.Second)
+	c.Assert(alice.Renew(ctx), IsNil)
+	now = now.Add(45 * time.Second)
+	err = bob.Acquire(ctx)
+	c.Assert(trace.IsCompareFailed(err), Equals, true, Commentf("%v", err))
+
+	// alice stopped renewing, the lock is stale
+	now = now.Add(time.Minute)
+	c.Assert(bob.Acquire(ctx), IsNil)
+	record, err := bob.Get(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(record.Holder, Equals, "bob")
+	c.Assert(record.Changeset, Equals, "bob-cs")
+	err = alice.Renew(ctx)
+	c.Assert(trace.IsCompareFailed(err), Equals, true, Commentf("%v", err))
+
+	// releasing a lock held by another holder is a no-op
+	c.Assert(alice.Release(ctx), IsNil)
+	_, err = bob.Get(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(bob.Release(ctx), IsNil)
+	_, err = bob.Get(ctx)
+	c.Assert(trace.IsNotFound(err), Equals, true)
+}
+
+func (s *LockSuite) TestWithLock(c *C) {
+	configMaps := newMemConfigMaps()
+	config := LockConfig{Name: "app", Holder: "alice", ConfigMaps: configMaps}
+	err := WithLock(context.TODO(), config, func(ctx context.Context) error {
+		lock, err := NewLock(LockConfig{Name: "app", Holder: "bob", ConfigMaps: configMaps})
+		c.Assert(err, IsNil)
+		err = lock.Acquire(ctx)
+		c.Assert(trace.IsCompareFailed(err), Equals, true)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(configMaps.items, HasLen, 0)
+}
+
+func (s *LockSuite) TestWithLockLost(c *C) {
+	configMaps := newMemConfigMaps()
+	config := LockConfig{Name: "app", Holder: "alice", Duration: 30 * time.Millisecond, ConfigMaps: configMaps}
+	err := WithLock(context.TODO(), config, func(ctx context.Context) error {
+		// bob takes over the lock considering it stale
+		lock, err := NewLock(LockConfig{Name: "app", Holder: "bob", ConfigMaps: configMaps, Clock: func() time.Time {
+			return time.Now().Add(time.Hour)
+		}})
+		c.Assert(err, IsNil)
+		c.Assert(lock.Acquire(context.TODO()), IsNil)
+		<-ctx.Done()
+		// fn completes successfully without the lock
+		return nil
+	})
+	c.Assert(trace.IsCompareFailed(err), Equals, true, Commentf("%v", err))
+}
+
+// memConfigMaps is an in-memory config map client with optimistic concurrency
+type memConfigMaps struct {
+	corev1.ConfigMapInterface
+	sync.Mutex
+	items   map[string]*v1.ConfigMap
+	version int
+}
+
+func newMemConfigMaps() *memConfigMaps {
+	return &memConfigMaps{items: make(map[string]*v1.ConfigMap)}
+}
+
+var configMapsResource = schema.GroupResource{Resource: "configmaps"}
+
+func (m *memConfigMaps) ConfigMaps(namespace string) corev1.ConfigMapInterface {
+	return m
+}
+
+func (m *memConfigMaps) Create(in *v1.ConfigMap) (*v1.ConfigMap, error) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.items[in.Name]; ok {
+		return nil, errors.NewAlreadyExists(configMapsResource, in.Name)
+	}
+	m.version++
+	out := in.DeepCopy()
+	out.UID = types.UID(fmt.Sprintf("uid-%v", m.version))
+	out.ResourceVersion = fmt.Sprintf("%v", m.version)
+	m.items[in.Name] = out
+	return out.DeepCopy(), nil
+}
+
+func (m *memConfigMaps) Get(name string, options metav1.GetOptions) (*v1.ConfigMap, error) {
+	m.Lock()
+	defer m.Unlock()
+	current, ok := m.items[name]
+	if !ok {
+		return nil, errors.NewNotFound(configMapsResource, name)
+	}
+	return current.DeepCopy(), nil
+}
+
+func (m *memConfigMaps) Update(in *v1.ConfigMap) (*v1.ConfigMap, error) {
+	m.Lock()
+	defer m.Unlock()
+	current, ok := m.items[in.Name]
+	if !ok {
+		return nil, errors.NewNotFound(configMapsResource, in.Name)
+	}
+	if in.ResourceVersion != current.ResourceVersion {
+		return nil, errors.NewConflict(configMapsResource, in.Name, fmt.Errorf("resource version mismatch"))
+	}
+	m.version++
+	out := in.DeepCopy()
+	out.UID = current.UID
+	out.ResourceVersion = fmt.Sprintf("%v", m.version)
+	m.items[in.Name] = out
+	return out.DeepCopy(), nil
+}
+
+func (m *memConfigMaps) Delete(name string, options *metav1.DeleteOptions) error {
+	m.Lock()
+	defer m.Unlock()
+	current, ok := m.items[name]
+	if !ok {
+		return errors.NewNotFound(configMapsResource, name)
+	}
+	if options != nil && options.Preconditions != nil && options.Preconditions.UID != nil && *options.Preconditions.UID != current.UID {
+		return errors.NewConflict(configMapsResource, name, fmt.Errorf("UID mismatch"))
+	}
+	delete(m.items, name)
+	return nil
+}
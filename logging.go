@@ -0,0 +1,143 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// Verbosity defines which messages rigging operations log
+type Verbosity string
+
+const (
+	// VerbositySilent disables logging
+	VerbositySilent Verbosity = "silent"
+	// VerbosityErrors logs errors only
+	VerbosityErrors Verbosity = "errors"
+	// VerbosityInfo logs errors, warnings and the progress of the operations,
+	// including each status check attempt
+	VerbosityInfo Verbosity = "info"
+	// VerbosityDebug logs all messages
+	VerbosityDebug Verbosity = "debug"
+)
+
+// ParseVerbosity parses the verbosity, returns BadParameter if the verbosity is not supported
+func ParseVerbosity(in string) (Verbosity, error) {
+	verbosity := Verbosity(in)
+	if err := verbosity.Check(); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return verbosity, nil
+}
+
+// Check returns BadParameter if the verbosity is not supported,
+// empty verbosity is valid and leaves the standard logger as is
+func (v Verbosity) Check() error {
+	switch v {
+	case "", VerbositySilent, VerbosityErrors, VerbosityInfo, VerbosityDebug:
+		return nil
+	}
+	return trace.BadParameter("unsupported verbosity %q, supported are %v, %v, %v and %v",
+		string(v), VerbositySilent, VerbosityErrors, VerbosityInfo, VerbosityDebug)
+}
+
+// logger returns the logger writing to the output of the standard logger
+// with its formatter and hooks at the level of the verbosity,
+// the standard logger if the verbosity is empty
+func (v Verbosity) logger() *log.Logger {
+	std := log.StandardLogger()
+	level := std.Level
+	switch v {
+	case "":
+		return std
+	case VerbositySilent:
+		level = log.PanicLevel
+	case VerbosityErrors:
+		level = log.ErrorLevel
+	case VerbosityInfo:
+		level = log.InfoLevel
+	case VerbosityDebug:
+		level = log.DebugLevel
+	}
+	return &log.Logger{
+		Out:       std.Out,
+		Hooks:     std.Hooks,
+		Formatter: std.Formatter,
+		Level:     level,
+	}
+}
+
+const (
+	// LogFieldOperation is the log field with the ID of the caller's operation
+	LogFieldOperation = "operation"
+	// LogFieldTenant is the log field with the tenant the operation is performed for
+	LogFieldTenant = "tenant"
+)
+
+// logFieldsContext is the context key of the log fields
+type logFieldsContext struct{}
+
+// verbosityContext is the context key of the verbosity
+type verbosityContext struct{}
+
+// WithVerbosity returns a context making the operations performed
+// with the context log at the verbosity
+func WithVerbosity(ctx context.Context, verbosity Verbosity) context.Context {
+	return context.WithValue(ctx, verbosityContext{}, verbosity)
+}
+
+// WithLogFields returns a context carrying the fields added to all log entries
+// of the operations performed with the context, e.g. LogFieldOperation or
+// LogFieldTenant. The fields are merged with the fields already in the context
+func WithLogFields(ctx context.Context, fields log.Fields) context.Context {
+	merged := make(log.Fields)
+	for key, val := range LogFields(ctx) {
+		merged[key] = val
+	}
+	for key, val := range fields {
+		merged[key] = val
+	}
+	return context.WithValue(ctx, logFieldsContext{}, merged)
+}
+
+// LogFields returns the log fields carried by the context
+func LogFields(ctx context.Context) log.Fields {
+	fields, _ := ctx.Value(logFieldsContext{}).(log.Fields)
+	return fields
+}
+
+// contextLogger returns the log entry with the verbosity
+// and the log fields carried by the context
+func contextLogger(ctx context.Context) *log.Entry {
+	return contextEntry(ctx, log.NewEntry(log.StandardLogger()))
+}
+
+// contextEntry returns the entry logged with the verbosity
+// and with the log fields carried by the context
+func contextEntry(ctx context.Context, entry *log.Entry) *log.Entry {
+	if verbosity, ok := ctx.Value(verbosityContext{}).(Verbosity); ok && verbosity != "" {
+		entry = log.NewEntry(verbosity.logger()).WithFields(entry.Data)
+	}
+	fields := LogFields(ctx)
+	if len(fields) == 0 {
+		return entry
+	}
+	return entry.WithFields(fields)
+}
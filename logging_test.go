@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+)
+
+type LoggingSuite struct{}
+
+var _ = Suite(&LoggingSuite{})
+
+func (s *LoggingSuite) TestContextFields(c *C) {
+	ctx := context.Background()
+	entry := log.WithField("deployment", "kube-system/api")
+	c.Assert(contextEntry(ctx, entry), Equals, entry)
+
+	ctx = WithLogFields(ctx, log.Fields{LogFieldOperation: "op1", LogFieldTenant: "a"})
+	child := WithLogFields(ctx, log.Fields{LogFieldTenant: "b"})
+	c.Assert(LogFields(ctx), DeepEquals, log.Fields{LogFieldOperation: "op1", LogFieldTenant: "a"})
+	c.Assert(LogFields(child), DeepEquals, log.Fields{LogFieldOperation: "op1", LogFieldTenant: "b"})
+
+	c.Assert(contextEntry(child, entry).Data, DeepEquals, log.Fields{
+		"deployment":      "kube-system/api",
+		LogFieldOperation: "op1",
+		LogFieldTenant:    "b",
+	})
+}
+
+func (s *LoggingSuite) TestVerbosity(c *C) {
+	_, err := ParseVerbosity("verbose")
+	c.Assert(err, NotNil)
+	verbosity, err := ParseVerbosity("errors")
+	c.Assert(err, IsNil)
+	c.Assert(verbosity, Equals, VerbosityErrors)
+
+	entry := log.WithField("deployment", "kube-system/api")
+	ctx := WithLogFields(context.Background(), log.Fields{LogFieldOperation: "op1"})
+	for verbosity, level := range map[Verbosity]log.Level{
+		VerbositySilent: log.PanicLevel,
+		VerbosityErrors: log.ErrorLevel,
+		VerbosityInfo:   log.InfoLevel,
+		VerbosityDebug:  log.DebugLevel,
+	} {
+		out := contextEntry(WithVerbosity(ctx, verbosity), entry)
+		c.Assert(out.Logger.Level, Equals, level, Commentf("verbosity %v", verbosity))
+		c.Assert(out.Data, DeepEquals, log.Fields{"deployment": "kube-system/api", LogFieldOperation: "op1"})
+	}
+	c.Assert(contextEntry(WithVerbosity(ctx, ""), entry).Logger, Equals, log.StandardLogger())
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// ExpandPaths expands the path into a sorted list of manifest files.
+// The path may be a file, a directory or a glob pattern. Directories
+// are expanded into YAML and JSON manifests they contain, including
+// subdirectories if recursive is set. Files named explicitly are returned
+// regardless of their extension. URLs and "-" for the standard input
+// are returned as is for kubectl to read them, and paths that exist
+// are not expanded as patterns even if they contain glob characters
+func ExpandPaths(path string, recursive bool) ([]string, error) {
+	if path == "-" || isURL(path) {
+		return []string{path}, nil
+	}
+	matches := []string{path}
+	if _, err := os.Stat(path); os.IsNotExist(err) && hasGlob(path) {
+		var err error
+		matches, err = filepath.Glob(path)
+		if err != nil {
+			return nil, trace.BadParameter("invalid pattern %q: %v", path, err)
+		}
+		if len(matches) == 0 {
+			return nil, trace.NotFound("no files match %q", path)
+		}
+	}
+	var files []string
+	for _, match := range matches {
+		fi, err := os.Stat(match)
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+		if !fi.IsDir() {
+			files = append(files, match)
+			continue
+		}
+		dirFiles, err := expandDir(match, recursive)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		files = append(files, dirFiles...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// isURL returns true if the path is a URL kubectl reads manifests from
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// expandDir returns manifests in the directory
+func expandDir(dir string, recursive bool) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return trace.ConvertSystemError(err)
+		}
+		if fi.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isManifest(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return files, nil
+}
+
+// ReadManifests reads the manifests at the specified path, see ExpandPaths,
+// and concatenates them into a single manifest stream in file name order
+func ReadManifests(path string, recursive bool) ([]byte, error) {
+	files, err := ExpandPaths(path, recursive)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(files) == 1 && files[0] == path {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+		return data, nil
+	}
+	var buf bytes.Buffer
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, trace.ConvertSystemError(err)
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// isManifest returns true if the file name has a manifest extension
+func isManifest(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func hasGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type ManifestsSuite struct{}
+
+var _ = Suite(&ManifestsSuite{})
+
+func (s *ManifestsSuite) TestExpandPaths(c *C) {
+	dir := c.MkDir()
+	for _, name := range []string{"b.yaml", "a.json", "README.md", "sub/c.yml", "sub/deep/d.yaml"} {
+		path := filepath.Join(dir, name)
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte("kind: ConfigMap"), 0644), IsNil)
+	}
+	rel := func(paths []string) []string {
+		var out []string
+		for _, path := range paths {
+			r, err := filepath.Rel(dir, path)
+			c.Assert(err, IsNil)
+			out = append(out, filepath.ToSlash(r))
+		}
+		return out
+	}
+
+	files, err := ExpandPaths(dir, false)
+	c.Assert(err, IsNil)
+	c.Assert(rel(files), DeepEquals, []string{"a.json", "b.yaml"})
+
+	files, err = ExpandPaths(dir, true)
+	c.Assert(err, IsNil)
+	c.Assert(rel(files), DeepEquals, []string{"a.json", "b.yaml", "sub/c.yml", "sub/deep/d.yaml"})
+
+	files, err = ExpandPaths(filepath.Join(dir, "*"), false)
+	c.Assert(err, IsNil)
+	c.Assert(rel(files), DeepEquals, []string{"README.md", "a.json", "b.yaml", "sub/c.yml"})
+
+	_, err = ExpandPaths(filepath.Join(dir, "*.txt"), false)
+	c.Assert(err, NotNil)
+
+	// existing paths with glob characters are used as is
+	bracketed := filepath.Join(dir, "[prod]")
+	c.Assert(os.MkdirAll(bracketed, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(bracketed, "e.yaml"), []byte("kind: ConfigMap"), 0644), IsNil)
+	files, err = ExpandPaths(bracketed, false)
+	c.Assert(err, IsNil)
+	c.Assert(rel(files), DeepEquals, []string{"[prod]/e.yaml"})
+
+	for _, path := range []string{"-", "https://example.com/manifests/app.yaml"} {
+		files, err = ExpandPaths(path, false)
+		c.Assert(err, IsNil)
+		c.Assert(files, DeepEquals, []string{path})
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// GatewayAPIGroup is the API group of the Gateway API resources
+	GatewayAPIGroup = "gateway.networking.k8s.io"
+	// IstioNetworkingGroup is the API group of the Istio traffic management resources
+	IstioNetworkingGroup = "networking.istio.io"
+	// IstioSecurityGroup is the API group of the Istio security resources
+	IstioSecurityGroup = "security.istio.io"
+)
+
+// gatewayProgrammed returns true if the gateway has been accepted and
+// programmed into the data plane for its current generation.
+// Gateways of API versions preceding the Programmed condition report Ready
+func gatewayProgrammed(object *unstructured.Unstructured) (bool, error) {
+	conditions, _, err := unstructured.NestedSlice(object.Object, "status", "conditions")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	for _, conditionType := range []string{"Programmed", "Ready"} {
+		if condition := findCondition(conditions, conditionType); condition != nil {
+			return conditionMet(object, condition)
+		}
+	}
+	return false, nil
+}
+
+// gatewayClassAccepted returns true if the controller of the gateway class has accepted it
+func gatewayClassAccepted(object *unstructured.Unstructured) (bool, error) {
+	conditions, _, err := unstructured.NestedSlice(object.Object, "status", "conditions")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	condition := findCondition(conditions, "Accepted")
+	if condition == nil {
+		return false, nil
+	}
+	return conditionMet(object, condition)
+}
+
+// routeAccepted returns true if all parent gateways of the route
+// have accepted its current generation
+func routeAccepted(object *unstructured.Unstructured) (bool, error) {
+	parents, _, err := unstructured.NestedSlice(object.Object, "status", "parents")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if len(parents) == 0 {
+		return false, nil
+	}
+	for _, item := range parents {
+		parent, ok := item.(map[string]interface{})
+		if !ok {
+			return false, trace.BadParameter("unexpected route parent status %v", item)
+		}
+		conditions, _, err := unstructured.NestedSlice(parent, "conditions")
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		condition := findCondition(conditions, "Accepted")
+		if condition == nil {
+			return false, nil
+		}
+		if ok, err := conditionMet(object, condition); !ok {
+			return false, trace.Wrap(err)
+		}
+	}
+	return true, nil
+}
+
+// istioValidated returns true unless Istio reports the resource as
+// not reconciled or invalid. Istio only reports the status of resources
+// if status reporting is enabled, otherwise the resources are ready once they exist
+func istioValidated(object *unstructured.Unstructured) (bool, error) {
+	conditions, _, err := unstructured.NestedSlice(object.Object, "status", "conditions")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if condition := findCondition(conditions, "Reconciled"); condition != nil {
+		if ok, err := conditionMet(object, condition); !ok {
+			return false, trace.Wrap(err)
+		}
+	}
+	messages, _, err := unstructured.NestedSlice(object.Object, "status", "validationMessages")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	var errors []string
+	for _, item := range messages {
+		message, ok := item.(map[string]interface{})
+		if !ok || message["level"] != "ERROR" {
+			continue
+		}
+		code, _, _ := unstructured.NestedString(message, "type", "code")
+		description, _ := message["description"].(string)
+		errors = append(errors, code+": "+description)
+	}
+	if len(errors) != 0 {
+		return false, trace.CompareFailed("%v %v is invalid: %v",
+			object.GetKind(), object.GetName(), strings.Join(errors, ", "))
+	}
+	return true, nil
+}
+
+// findCondition returns the condition of the specified type or nil
+func findCondition(conditions []interface{}, conditionType string) map[string]interface{} {
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+	return nil
+}
+
+// conditionMet returns true if the condition has status True and has been
+// observed for the current generation of the object, conditions without
+// observed generation are considered up to date
+func conditionMet(object *unstructured.Unstructured, condition map[string]interface{}) (bool, error) {
+	observed, found, err := unstructured.NestedInt64(condition, "observedGeneration")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if found && observed < object.GetGeneration() {
+		return false, nil
+	}
+	if condition["status"] != "True" {
+		return false, trace.CompareFailed("%v %v is not %v: %v: %v",
+			object.GetKind(), object.GetName(), condition["type"], condition["reason"], condition["message"])
+	}
+	return true, nil
+}
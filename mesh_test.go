@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type MeshSuite struct{}
+
+var _ = Suite(&MeshSuite{})
+
+func (s *MeshSuite) TestReadiness(c *C) {
+	tcs := []struct {
+		comment  string
+		manifest string
+		ready    bool
+		notReady bool
+	}{
+		{
+			comment: "programmed gateway",
+			manifest: `
+apiVersion: gateway.networking.k8s.io/v1
+kind: Gateway
+metadata: {name: web, generation: 2}
+status:
+  conditions:
+  - {type: Accepted, status: "True", observedGeneration: 2}
+  - {type: Programmed, status: "True", observedGeneration: 2}
+`,
+			ready: true,
+		},
+		{
+			comment: "gateway programmed for previous generation",
+			manifest: `
+apiVersion: gateway.networking.k8s.io/v1
+kind: Gateway
+metadata: {name: web, generation: 3}
+status:
+  conditions:
+  - {type: Programmed, status: "True", observedGeneration: 2}
+`,
+		},
+		{
+			comment: "gateway not programmed",
+			manifest: `
+apiVersion: gateway.networking.k8s.io/v1
+kind: Gateway
+metadata: {name: web}
+status:
+  conditions:
+  - {type: Programmed, status: "False", reason: AddressNotAssigned}
+`,
+			notReady: true,
+		},
+		{
+			comment: "route accepted by all parents",
+			manifest: `
+apiVersion: gateway.networking.k8s.io/v1
+kind: HTTPRoute
+metadata: {name: app}
+status:
+  parents:
+  - conditions: [{type: Accepted, status: "True"}]
+  - conditions: [{type: Accepted, status: "True"}]
+`,
+			ready: true,
+		},
+		{
+			comment: "route rejected by a parent",
+			manifest: `
+apiVersion: gateway.networking.k8s.io/v1
+kind: HTTPRoute
+metadata: {name: app}
+status:
+  parents:
+  - conditions: [{type: Accepted, status: "True"}]
+  - conditions: [{type: Accepted, status: "False", reason: NotAllowedByListeners}]
+`,
+			notReady: true,
+		},
+		{
+			comment: "route without status",
+			manifest: `
+apiVersion: gateway.networking.k8s.io/v1beta1
+kind: HTTPRoute
+metadata: {name: app}
+`,
+		},
+		{
+			comment: "virtual service without status reporting",
+			manifest: `
+apiVersion: networking.istio.io/v1beta1
+kind: VirtualService
+metadata: {name: app}
+`,
+			ready: true,
+		},
+		{
+			comment: "invalid destination rule",
+			manifest: `
+apiVersion: networking.istio.io/v1beta1
+kind: DestinationRule
+metadata: {name: app}
+status:
+  validationMessages:
+  - level: ERROR
+    type: {code: IST0101}
+    description: Referenced host not found
+`,
+			notReady: true,
+		},
+	}
+	for _, tc := range tcs {
+		comment := Commentf(tc.comment)
+		objects, err := DecodeObjects([]byte(tc.manifest))
+		c.Assert(err, IsNil, comment)
+		fn, ok := getReadiness(objects[0].GroupVersionKind())
+		c.Assert(ok, Equals, true, comment)
+		ready, err := fn(objects[0])
+		c.Assert(ready, Equals, tc.ready, comment)
+		if tc.notReady {
+			c.Assert(trace.IsCompareFailed(err), Equals, true, comment)
+		} else {
+			c.Assert(err, IsNil, comment)
+		}
+	}
+}
+
+func (s *MeshSuite) TestConditionMet(c *C) {
+	object := &unstructured.Unstructured{}
+	object.SetGeneration(2)
+	ok, err := conditionMet(object, map[string]interface{}{"type": "Accepted", "status": "True"})
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	ok, err = conditionMet(object, map[string]interface{}{"type": "Accepted", "status": "True", "observedGeneration": int64(1)})
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+)
+
+// metricsAPIPath is the path of the resource metrics API served by metrics-server
+const metricsAPIPath = "/apis/metrics.k8s.io/v1beta1"
+
+// ContainerUsage is the resource usage of a container
+type ContainerUsage struct {
+	// Name is the container name
+	Name string `json:"name"`
+	// Usage maps resource names to the used quantities, e.g. cpu and memory
+	Usage v1.ResourceList `json:"usage"`
+}
+
+// PodUsage is the resource usage of a pod sampled by metrics-server
+type PodUsage struct {
+	// Containers lists the usage of the pod containers
+	Containers []ContainerUsage `json:"containers"`
+}
+
+// GetPodUsage returns the current resource usage of the pod from metrics-server,
+// returns NotFound if metrics-server is not installed or has no metrics for the pod yet
+func GetPodUsage(client *kubernetes.Clientset, namespace, name string) (*PodUsage, error) {
+	data, err := client.CoreV1().RESTClient().Get().
+		AbsPath(path.Join(metricsAPIPath, "namespaces", namespace, "pods", name)).
+		Do().
+		Raw()
+	if err != nil {
+		return nil, ConvertErrorWithContext(err, "failed to query metrics of pod %v/%v", namespace, name)
+	}
+	var usage PodUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &usage, nil
+}
+
+// sampleMetrics returns the CPU and memory usage of the pods that are not ready
+// among the pods matching the selector
+func sampleMetrics(client *kubernetes.Clientset, namespace string, matchLabels map[string]string) ([]string, error) {
+	pods, err := listPods(client, namespace, matchLabels)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var samples []string
+	for i, pod := range notReadyPods(pods) {
+		if i == maxDiagnosedPods {
+			break
+		}
+		usage, err := GetPodUsage(client, pod.Namespace, pod.Name)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				samples = append(samples, fmt.Sprintf("pod %v: no metrics available", FormatMeta(pod.ObjectMeta)))
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+		samples = append(samples, describeUsage(pod, *usage)...)
+	}
+	return samples, nil
+}
+
+// sampleManifestMetrics samples metrics of the pods of the workload described by the manifest
+func sampleManifestMetrics(client *kubernetes.Clientset, data []byte) ([]string, error) {
+	namespace, selector, err := manifestSelector(data)
+	if err != nil || selector == nil {
+		return nil, trace.Wrap(err)
+	}
+	return sampleMetrics(client, namespace, selector)
+}
+
+// describeUsage returns the usage of each container compared to its limits,
+// e.g. "pod default/app: container app uses cpu 250m, memory 500Mi (97% of limit 512Mi)"
+func describeUsage(pod v1.Pod, usage PodUsage) []string {
+	limits := make(map[string]v1.ResourceList)
+	for _, container := range pod.Spec.Containers {
+		limits[container.Name] = container.Resources.Limits
+	}
+	var out []string
+	for _, container := range usage.Containers {
+		var parts []string
+		for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+			used, ok := container.Usage[name]
+			if !ok {
+				continue
+			}
+			parts = append(parts, fmt.Sprintf("%v %v", name, formatUsage(used, limits[container.Name], name)))
+		}
+		out = append(out, fmt.Sprintf("pod %v: container %v uses %v",
+			FormatMeta(pod.ObjectMeta), container.Name, strings.Join(parts, ", ")))
+	}
+	return out
+}
+
+func formatUsage(used resource.Quantity, limits v1.ResourceList, name v1.ResourceName) string {
+	limit, ok := limits[name]
+	if !ok || limit.IsZero() {
+		return used.String()
+	}
+	percent := used.MilliValue() * 100 / limit.MilliValue()
+	return fmt.Sprintf("%v (%v%% of limit %v)", used.String(), percent, limit.String())
+}
@@ -0,0 +1,123 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// MonitoringGroup is the API group of the Prometheus Operator resources
+const MonitoringGroup = "monitoring.coreos.com"
+
+// RegisterMonitoringReadiness registers readiness evaluators of the Prometheus
+// Operator resources, so that monitoring stacks can be waited on like native kinds.
+// ServiceMonitor, PodMonitor, Probe and PrometheusRule resources are ready
+// once they exist, Prometheus, Alertmanager and ThanosRuler resources are ready
+// once the operator has reconciled their current generation and all
+// their replicas are available
+func RegisterMonitoringReadiness() {
+	for _, kind := range []string{"ServiceMonitor", "PodMonitor", "Probe", "PrometheusRule"} {
+		RegisterReadiness(schema.GroupVersionKind{Group: MonitoringGroup, Kind: kind}, objectExists)
+	}
+	for _, kind := range []string{"Prometheus", "Alertmanager", "ThanosRuler"} {
+		RegisterReadiness(schema.GroupVersionKind{Group: MonitoringGroup, Kind: kind}, operatorReconciled)
+	}
+}
+
+// objectExists returns true for any object, for resources
+// that have no status and are ready once created
+func objectExists(object *unstructured.Unstructured) (bool, error) {
+	return true, nil
+}
+
+// operatorReconciled returns true if the Prometheus Operator has reconciled
+// the current generation of the object and all its replicas are available.
+// Recent operator versions report Reconciled and Available conditions,
+// older ones only report replica counts
+func operatorReconciled(object *unstructured.Unstructured) (bool, error) {
+	paused, _, err := unstructured.NestedBool(object.Object, "spec", "paused")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if paused {
+		return false, trace.CompareFailed("%v %v is paused", object.GetKind(), object.GetName())
+	}
+	conditions, _, err := unstructured.NestedSlice(object.Object, "status", "conditions")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	var reconciled bool
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if !ok || (condition["type"] != "Reconciled" && condition["type"] != "Available") {
+			continue
+		}
+		if condition["status"] != "True" {
+			return false, trace.CompareFailed("%v %v is not %v: %v: %v",
+				object.GetKind(), object.GetName(), condition["type"], condition["reason"], condition["message"])
+		}
+		if condition["type"] != "Reconciled" {
+			continue
+		}
+		observed, _, err := unstructured.NestedInt64(condition, "observedGeneration")
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		if observed < object.GetGeneration() {
+			return false, nil
+		}
+		reconciled = true
+	}
+	if reconciled {
+		return true, nil
+	}
+	return replicasAvailable(object)
+}
+
+// replicasAvailable returns true if the status of the object reports
+// all desired replicas as updated and available
+func replicasAvailable(object *unstructured.Unstructured) (bool, error) {
+	desired, found, err := unstructured.NestedInt64(object.Object, "spec", "replicas")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if !found {
+		desired = 1
+	}
+	status, found, err := unstructured.NestedMap(object.Object, "status")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if !found {
+		return false, nil
+	}
+	counts := make(map[string]int64)
+	for _, field := range []string{"updatedReplicas", "availableReplicas", "unavailableReplicas"} {
+		count, _, err := unstructured.NestedInt64(status, field)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		counts[field] = count
+	}
+	if counts["updatedReplicas"] < desired || counts["availableReplicas"] < desired || counts["unavailableReplicas"] != 0 {
+		return false, trace.CompareFailed("%v %v has %v updated and %v available out of %v replicas",
+			object.GetKind(), object.GetName(), counts["updatedReplicas"], counts["availableReplicas"], desired)
+	}
+	return true, nil
+}
@@ -15,9 +15,15 @@
 package rigging
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
 )
 
 func GetAllNodes() (*NodeList, error) {
@@ -35,3 +41,104 @@ func GetAllNodes() (*NodeList, error) {
 
 	return &nodes, nil
 }
+
+// ListNodes returns nodes matching the specified selector.
+// If onlyReady is set, only nodes that are Ready and schedulable are returned
+func ListNodes(ctx context.Context, client *kubernetes.Clientset, selector labels.Selector, onlyReady bool) ([]v1.Node, error) {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	if !onlyReady {
+		return nodes.Items, nil
+	}
+	var result []v1.Node
+	for _, node := range nodes.Items {
+		if checkNodeReady(node) == nil {
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
+
+// NewNodesReporter returns a new instance of the nodes status reporter
+func NewNodesReporter(config NodesConfig) (*NodesReporter, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &NodesReporter{
+		NodesConfig: config,
+		Entry: log.WithFields(log.Fields{
+			"nodes": config.Selector.String(),
+		}),
+	}, nil
+}
+
+// NodesConfig is a nodes reporter configuration
+type NodesConfig struct {
+	// Selector selects the nodes to check, defaults to all nodes
+	Selector labels.Selector
+	// Client is k8s client
+	Client *kubernetes.Clientset
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *NodesConfig) CheckAndSetDefaults() error {
+	if c.Client == nil {
+		return trace.BadParameter("missing parameter Client")
+	}
+	if c.Selector == nil {
+		c.Selector = labels.Everything()
+	}
+	return nil
+}
+
+// NodesReporter reports whether all matching nodes are ready
+// and schedulable
+type NodesReporter struct {
+	NodesConfig
+	*log.Entry
+}
+
+// Status returns nil if all matching nodes are ready and schedulable
+func (r *NodesReporter) Status() error {
+	nodes, err := ListNodes(context.TODO(), r.Client, r.Selector, false)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if len(nodes) == 0 {
+		return trace.NotFound("no nodes matching %q found", r.Selector)
+	}
+	var errors []error
+	for _, node := range nodes {
+		if err := checkNodeReady(node); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// checkNodeReady returns nil if the node is ready and schedulable
+func checkNodeReady(node v1.Node) error {
+	if node.Spec.Unschedulable {
+		return trace.CompareFailed("node %v is not schedulable", node.Name)
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != v1.NodeReady {
+			continue
+		}
+		if condition.Status != v1.ConditionTrue {
+			return trace.CompareFailed("node %v is not ready: %v", node.Name, condition.Message)
+		}
+		return nil
+	}
+	return trace.CompareFailed("node %v has no ready condition", node.Name)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"math"
+
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fieldMapping converts fields of an object of a deprecated API version
+// to their equivalent in the preferred version
+type fieldMapping func(object *unstructured.Unstructured) error
+
+// legacyGVKs maps the deprecated group versions of the kinds managed by rigging
+// to the field mappings upgrading them to the preferred versions
+var legacyGVKs = map[schema.GroupVersionKind][]fieldMapping{
+	{Group: "extensions", Version: "v1beta1", Kind: KindDeployment}:                        {defaultSelector, removeField("spec", "rollbackTo"), defaultLegacyRollingUpdate, defaultField(int64(math.MaxInt32), "spec", "revisionHistoryLimit"), defaultField(int64(math.MaxInt32), "spec", "progressDeadlineSeconds")},
+	{Group: "apps", Version: "v1beta1", Kind: KindDeployment}:                              {defaultSelector, removeField("spec", "rollbackTo"), defaultField(int64(2), "spec", "revisionHistoryLimit")},
+	{Group: "apps", Version: "v1beta2", Kind: KindDeployment}:                              {defaultSelector},
+	{Group: "extensions", Version: "v1beta1", Kind: KindDaemonSet}:                         {defaultSelector, removeField("spec", "templateGeneration"), defaultUpdateStrategy},
+	{Group: "apps", Version: "v1beta2", Kind: KindDaemonSet}:                               {defaultSelector, removeField("spec", "templateGeneration")},
+	{Group: "extensions", Version: "v1beta1", Kind: KindReplicaSet}:                        {defaultSelector},
+	{Group: "apps", Version: "v1beta2", Kind: KindReplicaSet}:                              {defaultSelector},
+	{Group: "apps", Version: "v1beta1", Kind: KindStatefulSet}:                             {defaultSelector, defaultUpdateStrategy},
+	{Group: "apps", Version: "v1beta2", Kind: KindStatefulSet}:                             {defaultSelector},
+	{Group: "batch", Version: "v2alpha1", Kind: KindCronJob}:                               nil,
+	{Group: "extensions", Version: "v1beta1", Kind: KindPodSecurityPolicy}:                 nil,
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: KindRole}:               nil,
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: KindClusterRole}:        nil,
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: KindRoleBinding}:        nil,
+	{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: KindClusterRoleBinding}: nil,
+}
+
+// NormalizeObject returns a copy of the object upgraded from a deprecated
+// API version to the preferred one, e.g. extensions/v1beta1 Deployment to apps/v1,
+// with the fields mapped so that the object behaves the same.
+// Null creation timestamps in pod templates are removed as well.
+// Objects of unknown kinds or versions are copied as is
+func NormalizeObject(object *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	out := object.DeepCopy()
+	gvk := out.GroupVersionKind()
+	if mappings, ok := legacyGVKs[gvk]; ok {
+		for _, mapping := range mappings {
+			if err := mapping(out); err != nil {
+				return nil, trace.Wrap(err, "failed to convert %v %v from %v",
+					gvk.Kind, out.GetName(), gvk.GroupVersion())
+			}
+		}
+		out.SetAPIVersion(APIVersionFor(gvk.Kind))
+	}
+	normalizePodTemplate(out)
+	return out, nil
+}
+
+// APIVersionUpgrade is a transformer that upgrades objects
+// from deprecated API versions to the preferred ones
+type APIVersionUpgrade struct{}
+
+// Transform upgrades the objects in the manifest stream
+func (APIVersionUpgrade) Transform(data []byte) ([]byte, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for i, object := range objects {
+		objects[i], err = NormalizeObject(object)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return EncodeObjects(objects)
+}
+
+// defaultSelector sets the selector to the pod template labels if missing,
+// deprecated versions default it while the preferred versions require it
+func defaultSelector(object *unstructured.Unstructured) error {
+	_, ok, err := unstructured.NestedFieldNoCopy(object.Object, "spec", "selector")
+	if err != nil || ok {
+		return trace.Wrap(err)
+	}
+	labels, ok, err := unstructured.NestedStringMap(object.Object, "spec", "template", "metadata", "labels")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if !ok || len(labels) == 0 {
+		return trace.BadParameter("missing selector and pod template labels")
+	}
+	selector := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		selector[key] = value
+	}
+	return trace.Wrap(unstructured.SetNestedMap(object.Object, selector, "spec", "selector", "matchLabels"))
+}
+
+// defaultUpdateStrategy sets the update strategy to OnDelete if missing,
+// deprecated versions default to OnDelete while the preferred versions
+// default to RollingUpdate
+func defaultUpdateStrategy(object *unstructured.Unstructured) error {
+	_, ok, err := unstructured.NestedFieldNoCopy(object.Object, "spec", "updateStrategy", "type")
+	if err != nil || ok {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(unstructured.SetNestedField(object.Object, "OnDelete", "spec", "updateStrategy", "type"))
+}
+
+// defaultLegacyRollingUpdate sets the rolling update parameters of a deployment
+// to 1 if missing, extensions/v1beta1 defaults both maxUnavailable and maxSurge to 1
+// while the preferred version defaults them to 25%
+func defaultLegacyRollingUpdate(object *unstructured.Unstructured) error {
+	strategy, ok, err := unstructured.NestedString(object.Object, "spec", "strategy", "type")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if ok && strategy != "RollingUpdate" {
+		return nil
+	}
+	for _, field := range []string{"maxUnavailable", "maxSurge"} {
+		if err := defaultField(int64(1), "spec", "strategy", "rollingUpdate", field)(object); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// defaultField returns a mapping setting the field to the default
+// of the deprecated version if missing, so that the preferred version
+// does not apply its own different default.
+// Fields unlimited by default in the deprecated version are set to math.MaxInt32,
+// which the API server treats as unlimited as well
+func defaultField(value interface{}, fields ...string) fieldMapping {
+	return func(object *unstructured.Unstructured) error {
+		_, ok, err := unstructured.NestedFieldNoCopy(object.Object, fields...)
+		if err != nil || ok {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(unstructured.SetNestedField(object.Object, value, fields...))
+	}
+}
+
+// removeField returns a mapping removing the field
+// dropped from the preferred version
+func removeField(fields ...string) fieldMapping {
+	return func(object *unstructured.Unstructured) error {
+		unstructured.RemoveNestedField(object.Object, fields...)
+		return nil
+	}
+}
+
+// normalizePodTemplate removes the null creation timestamp
+// added to pod templates by typed encoders
+func normalizePodTemplate(object *unstructured.Unstructured) {
+	paths := [][]string{
+		{"spec", "template", "metadata"},
+		{"spec", "jobTemplate", "spec", "template", "metadata"},
+	}
+	for _, path := range paths {
+		timestamp, ok, _ := unstructured.NestedFieldNoCopy(object.Object, append(path, "creationTimestamp")...)
+		if ok && timestamp == nil {
+			unstructured.RemoveNestedField(object.Object, append(path, "creationTimestamp")...)
+		}
+	}
+}
+
+// normalizeVersions upgrades both objects to the preferred API version
+// if their versions differ so that they can be compared field by field,
+// returns the objects as is if either of them can not be upgraded
+func normalizeVersions(a, b *unstructured.Unstructured) (*unstructured.Unstructured, *unstructured.Unstructured) {
+	if a.GetAPIVersion() == b.GetAPIVersion() {
+		return a, b
+	}
+	normalizedA, err := NormalizeObject(a)
+	if err != nil {
+		return a, b
+	}
+	normalizedB, err := NormalizeObject(b)
+	if err != nil {
+		return a, b
+	}
+	return normalizedA, normalizedB
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"math"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type NormalizeSuite struct{}
+
+var _ = Suite(&NormalizeSuite{})
+
+func (s *NormalizeSuite) TestNormalizeObject(c *C) {
+	objects, err := DecodeObjects([]byte(`apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  rollbackTo:
+    revision: 1
+  template:
+    metadata:
+      creationTimestamp: null
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+---
+apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  templateGeneration: 3
+  template:
+    metadata:
+      labels:
+        app: agent
+---
+apiVersion: extensions/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: restricted
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+`))
+	c.Assert(err, IsNil)
+	var normalized []*unstructured.Unstructured
+	for _, object := range objects {
+		out, err := NormalizeObject(object)
+		c.Assert(err, IsNil)
+		normalized = append(normalized, out)
+	}
+	c.Assert(objects[0].GetAPIVersion(), Equals, "extensions/v1beta1", Commentf("input is not modified"))
+
+	deployment := normalized[0]
+	c.Assert(deployment.GetAPIVersion(), Equals, "apps/v1")
+	selector, _, err := unstructured.NestedStringMap(deployment.Object, "spec", "selector", "matchLabels")
+	c.Assert(err, IsNil)
+	c.Assert(selector, DeepEquals, map[string]string{"app": "web"})
+	_, ok, _ := unstructured.NestedFieldNoCopy(deployment.Object, "spec", "rollbackTo")
+	c.Assert(ok, Equals, false)
+	_, ok, _ = unstructured.NestedFieldNoCopy(deployment.Object, "spec", "template", "metadata", "creationTimestamp")
+	c.Assert(ok, Equals, false)
+	for _, field := range []string{"maxUnavailable", "maxSurge"} {
+		value, _, err := unstructured.NestedInt64(deployment.Object, "spec", "strategy", "rollingUpdate", field)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, int64(1), Commentf(field))
+	}
+	for _, field := range []string{"revisionHistoryLimit", "progressDeadlineSeconds"} {
+		value, _, err := unstructured.NestedInt64(deployment.Object, "spec", field)
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, int64(math.MaxInt32), Commentf(field))
+	}
+
+	ds := normalized[1]
+	c.Assert(ds.GetAPIVersion(), Equals, "apps/v1")
+	strategy, _, err := unstructured.NestedString(ds.Object, "spec", "updateStrategy", "type")
+	c.Assert(err, IsNil)
+	c.Assert(strategy, Equals, "OnDelete")
+	_, ok, _ = unstructured.NestedFieldNoCopy(ds.Object, "spec", "templateGeneration")
+	c.Assert(ok, Equals, false)
+
+	c.Assert(normalized[2].GetAPIVersion(), Equals, "policy/v1beta1")
+	c.Assert(normalized[3].Object, DeepEquals, objects[3].Object)
+
+	broken := objects[1].DeepCopy()
+	unstructured.RemoveNestedField(broken.Object, "spec", "template", "metadata", "labels")
+	_, err = NormalizeObject(broken)
+	c.Assert(err, NotNil)
+}
+
+func (s *NormalizeSuite) TestDiffAcrossVersions(c *C) {
+	objects, err := DecodeObjects([]byte(`apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: web
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  strategy:
+    rollingUpdate:
+      maxSurge: 1
+      maxUnavailable: 1
+  revisionHistoryLimit: 2147483647
+  progressDeadlineSeconds: 2147483647
+  template:
+    metadata:
+      labels:
+        app: web
+`))
+	c.Assert(err, IsNil)
+	fields := diffObjects(objects[0], objects[1])
+	c.Assert(fields, DeepEquals, []FieldDrift{{Path: "spec.replicas", Desired: int64(2), Live: int64(3)}})
+}
@@ -0,0 +1,194 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// ChangesetEvent is a changeset lifecycle event
+type ChangesetEvent string
+
+const (
+	// EventCompleted is sent when the changeset is committed
+	EventCompleted ChangesetEvent = "completed"
+	// EventFailed is sent when applying the changeset or waiting for it fails
+	EventFailed ChangesetEvent = "failed"
+	// EventRolledBack is sent when the changeset is reverted
+	EventRolledBack ChangesetEvent = "rolled-back"
+	// DefaultNotifyTimeout is the default timeout of sending a notification
+	DefaultNotifyTimeout = 10 * time.Second
+)
+
+// Notification describes a changeset event
+type Notification struct {
+	// Event is the changeset event
+	Event ChangesetEvent `json:"event"`
+	// Namespace is the namespace of the changeset
+	Namespace string `json:"namespace"`
+	// Changeset is the name of the changeset
+	Changeset string `json:"changeset"`
+	// Error is the failure reason, set for EventFailed
+	Error string `json:"error,omitempty"`
+	// Time is the time of the event
+	Time time.Time `json:"time"`
+}
+
+// String returns a human readable notification message
+func (n Notification) String() string {
+	message := fmt.Sprintf("changeset %v/%v %v", n.Namespace, n.Changeset, n.Event)
+	if n.Error != "" {
+		message = fmt.Sprintf("%v: %v", message, n.Error)
+	}
+	return message
+}
+
+// Notifier is notified about changeset completion, failure and rollback
+type Notifier interface {
+	// Notify sends the notification
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// WebhookNotifier posts notifications as JSON to the URL
+type WebhookNotifier struct {
+	// URL is the webhook URL
+	URL string
+	// Header is added to the requests, e.g. the authorization header
+	Header http.Header
+	// Client is the HTTP client, defaults to a client with DefaultNotifyTimeout
+	Client *http.Client
+}
+
+// Notify posts the notification to the webhook
+func (w WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	return trace.Wrap(postJSON(ctx, w.Client, w.URL, w.Header, notification))
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook
+type SlackNotifier struct {
+	// WebhookURL is the incoming webhook URL
+	WebhookURL string
+	// Channel optionally overrides the channel of the webhook
+	Channel string
+	// Client is the HTTP client, defaults to a client with DefaultNotifyTimeout
+	Client *http.Client
+}
+
+// Notify posts the notification message to Slack
+func (s SlackNotifier) Notify(ctx context.Context, notification Notification) error {
+	icon := ":white_check_mark:"
+	switch notification.Event {
+	case EventFailed:
+		icon = ":x:"
+	case EventRolledBack:
+		icon = ":leftwards_arrow_with_hook:"
+	}
+	message := struct {
+		Text    string `json:"text"`
+		Channel string `json:"channel,omitempty"`
+	}{
+		Text:    fmt.Sprintf("%v %v", icon, notification),
+		Channel: s.Channel,
+	}
+	return trace.Wrap(postJSON(ctx, s.Client, s.WebhookURL, nil, message))
+}
+
+// EmailNotifier sends notifications by email
+type EmailNotifier struct {
+	// Addr is the SMTP server address, host:port
+	Addr string
+	// Auth is the optional SMTP authentication
+	Auth smtp.Auth
+	// From is the sender address
+	From string
+	// To lists the recipient addresses
+	To []string
+}
+
+// Notify sends the notification email
+func (e EmailNotifier) Notify(ctx context.Context, notification Notification) error {
+	if len(e.To) == 0 {
+		return trace.BadParameter("missing parameter To")
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [rigging] changeset %v/%v %v\r\n", notification.Namespace, notification.Changeset, notification.Event)
+	fmt.Fprintf(&msg, "Date: %v\r\n", notification.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%v\r\n", notification)
+	err := smtp.SendMail(e.Addr, e.Auth, e.From, e.To, msg.Bytes())
+	return trace.Wrap(err, "failed to send email to %v", strings.Join(e.To, ", "))
+}
+
+// notify sends the notification to all notifiers, failures are logged
+// and do not affect the changeset operation
+func notify(ctx context.Context, notifiers []Notifier, notification Notification) {
+	if len(notifiers) == 0 {
+		return
+	}
+	notification.Time = time.Now().UTC()
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(ctx, notification); err != nil {
+			log.Warningf("failed to send notification %q: %v", notification, err)
+		}
+	}
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, value interface{}) error {
+	if url == "" {
+		return trace.BadParameter("missing webhook URL")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: DefaultNotifyTimeout}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return trace.BadParameter("webhook returned %v: %s", resp.Status, body)
+	}
+	return nil
+}
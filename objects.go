@@ -0,0 +1,153 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ObjectInterface reads and modifies resources of any kind
+type ObjectInterface interface {
+	// Get returns the live state of the referenced resource,
+	// returns NotFound if the resource does not exist
+	Get(ctx context.Context, ref ObjectRef) (*unstructured.Unstructured, error)
+	// Apply creates the resource or updates it to the specified state
+	Apply(ctx context.Context, object *unstructured.Unstructured) error
+	// Delete deletes the referenced resource, deleting a missing
+	// resource is not an error
+	Delete(ctx context.Context, ref ObjectRef) error
+}
+
+// KubectlObjects is the default ObjectInterface implemented with kubectl
+type KubectlObjects struct {
+	Kubectl
+}
+
+// Get returns the live state of the referenced resource
+func (k KubectlObjects) Get(ctx context.Context, ref ObjectRef) (*unstructured.Unstructured, error) {
+	args := []string{"get", kubectlResource(ref) + "/" + ref.Name, "--ignore-not-found", "--output", "json"}
+	if ref.Namespace != "" {
+		args = append(args, "--namespace", ref.Namespace)
+	}
+	result, err := k.RunContext(ctx, nil, args...)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to get %v", ref)
+	}
+	if len(bytes.TrimSpace(result.Stdout)) == 0 {
+		return nil, trace.NotFound("%v not found", ref)
+	}
+	var object unstructured.Unstructured
+	if err := object.UnmarshalJSON(result.Stdout); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &object, nil
+}
+
+// Apply applies the object with kubectl apply
+func (k KubectlObjects) Apply(ctx context.Context, object *unstructured.Unstructured) error {
+	data, err := object.MarshalJSON()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = k.RunContext(ctx, bytes.NewReader(data), append(k.subcommand(ActionApply), "-f", "-")...)
+	return trace.Wrap(err, "failed to apply %v", ObjectRefFor(object))
+}
+
+// Delete deletes the referenced resource with kubectl delete.
+// It returns once the deletion is requested like the API does,
+// without waiting for the finalizers, see DeleteObject to wait for them
+func (k KubectlObjects) Delete(ctx context.Context, ref ObjectRef) error {
+	args := []string{string(ActionDelete), kubectlResource(ref) + "/" + ref.Name, "--ignore-not-found", "--wait=false"}
+	if ref.Namespace != "" {
+		args = append(args, "--namespace", ref.Namespace)
+	}
+	_, err := k.RunContext(ctx, nil, args...)
+	return trace.Wrap(err, "failed to delete %v", ref)
+}
+
+// List returns the resources of the kind in the namespace with kubectl get,
+// resources in all namespaces if the namespace is empty
+func (k KubectlObjects) List(ctx context.Context, gvk schema.GroupVersionKind, namespace string) ([]unstructured.Unstructured, error) {
+	args := []string{"get", qualifiedResource(gvk), "--output", "json"}
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	} else {
+		args = append(args, "--all-namespaces")
+	}
+	result, err := k.RunContext(ctx, nil, args...)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to list %v", gvk.Kind)
+	}
+	var list unstructured.UnstructuredList
+	if err := list.UnmarshalJSON(result.Stdout); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return list.Items, nil
+}
+
+// Patch applies the JSON merge patch to the referenced resource with kubectl patch
+func (k KubectlObjects) Patch(ctx context.Context, ref ObjectRef, patch []byte) error {
+	args := []string{string(ActionPatch), kubectlResource(ref) + "/" + ref.Name, "--type", "merge", "--patch", string(patch)}
+	if ref.Namespace != "" {
+		args = append(args, "--namespace", ref.Namespace)
+	}
+	result, err := k.RunContext(ctx, nil, args...)
+	if err == nil {
+		return nil
+	}
+	if result != nil && strings.Contains(string(result.Stderr), conflictMessage) {
+		return trace.CompareFailed("failed to patch %v: %s", ref, bytes.TrimSpace(result.Stderr))
+	}
+	return trace.Wrap(err, "failed to patch %v", ref)
+}
+
+// kubectlResource returns the resource type of the reference for kubectl
+// qualified with the API version, see qualifiedResource. Deprecated and
+// missing versions of the kinds managed by rigging are replaced with
+// the preferred version
+func kubectlResource(ref ObjectRef) string {
+	apiVersion := ref.APIVersion
+	if apiVersion == "" {
+		apiVersion = APIVersionFor(ref.Kind)
+	}
+	gvk := schema.FromAPIVersionAndKind(apiVersion, ref.Kind)
+	if _, ok := legacyGVKs[gvk]; ok {
+		gvk = schema.FromAPIVersionAndKind(APIVersionFor(ref.Kind), ref.Kind)
+	}
+	return qualifiedResource(gvk)
+}
+
+// qualifiedResource returns the resource type qualified with the version
+// and group for kubectl, e.g. Gateway.v1beta1.networking.istio.io,
+// so that kinds served by several API groups are not resolved
+// to the group kubectl prefers
+func qualifiedResource(gvk schema.GroupVersionKind) string {
+	if gvk.Group == "" {
+		return gvk.Kind
+	}
+	return strings.Join([]string{gvk.Kind, gvk.Version, gvk.Group}, ".")
+}
+
+// conflictMessage is reported by the server when an update conflicts
+// with a concurrent modification of the resource
+const conflictMessage = "the object has been modified"
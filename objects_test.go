@@ -0,0 +1,69 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ObjectsSuite struct{}
+
+var _ = Suite(&ObjectsSuite{})
+
+func (s *ObjectsSuite) TestKubectlResource(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "kubectl")
+	calls := filepath.Join(dir, "calls")
+	// the fake kubectl records the action and the resource and returns an empty object
+	script := "#!/bin/sh\necho \"$1 $2\" >> " + calls + "\necho '{\"kind\":\"Gateway\"}'\n"
+	c.Assert(ioutil.WriteFile(path, []byte(script), 0755), IsNil)
+	objects := KubectlObjects{Kubectl: Kubectl{Path: path}}
+
+	refs := []ObjectRef{
+		{APIVersion: "networking.istio.io/v1beta1", Kind: "Gateway", Namespace: "istio-system", Name: "ingress"},
+		{APIVersion: "gateway.networking.k8s.io/v1", Kind: "Gateway", Namespace: "istio-system", Name: "ingress"},
+		{APIVersion: "extensions/v1beta1", Kind: KindDeployment, Namespace: "default", Name: "app"},
+		{Kind: KindConfigMap, Namespace: "default", Name: "app"},
+	}
+	for _, ref := range refs {
+		_, err := objects.Get(context.TODO(), ref)
+		c.Assert(err, IsNil)
+		c.Assert(objects.Delete(context.TODO(), ref), IsNil)
+		c.Assert(objects.Patch(context.TODO(), ref, []byte("{}")), IsNil)
+	}
+	data, err := ioutil.ReadFile(calls)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Split(strings.TrimSpace(string(data)), "\n"), DeepEquals, []string{
+		"get Gateway.v1beta1.networking.istio.io/ingress",
+		"delete Gateway.v1beta1.networking.istio.io/ingress",
+		"patch Gateway.v1beta1.networking.istio.io/ingress",
+		"get Gateway.v1.gateway.networking.k8s.io/ingress",
+		"delete Gateway.v1.gateway.networking.k8s.io/ingress",
+		"patch Gateway.v1.gateway.networking.k8s.io/ingress",
+		"get Deployment.v1.apps/app",
+		"delete Deployment.v1.apps/app",
+		"patch Deployment.v1.apps/app",
+		"get ConfigMap/app",
+		"delete ConfigMap/app",
+		"patch ConfigMap/app",
+	})
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// OwnerAnnotation records the application, e.g. the bundle,
+	// that manages the resource with changesets
+	OwnerAnnotation = "rigging.gravitational.io/owner"
+	// ManagedByLabel is the recommended label naming the tool managing the resource
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// CheckOwnership returns CompareFailed if the live resource is managed
+// by another owner than the one applying the desired state as recorded
+// with OwnerAnnotation. Resources managed by other tools according to
+// ManagedByLabel are governed by the Helm policy of the changeset instead
+func CheckOwnership(live *unstructured.Unstructured, owner string) error {
+	ref := ObjectRefFor(live)
+	if current := live.GetAnnotations()[OwnerAnnotation]; current != "" && current != owner {
+		return trace.CompareFailed("%v is owned by %q, not %q, adopt it explicitly to take it over", ref, current, owner)
+	}
+	return nil
+}
+
+// claimOwnership verifies that the resource in the manifest is not owned
+// by another application, unless the changeset adopts resources, and records
+// the owner of the changeset on the resource. The manifest is returned unchanged
+// without looking up the live resource if the changeset has no owner
+func (cs *Changeset) claimOwnership(ctx context.Context, data []byte) ([]byte, error) {
+	if cs.Owner == "" {
+		return data, nil
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(objects) != 1 {
+		return nil, trace.BadParameter("expected a single resource, got %v", len(objects))
+	}
+	object := objects[0]
+	ref := ObjectRefFor(object)
+	if !IsClusterScoped(ref.Kind) {
+		ref.Namespace = Namespace(ref.Namespace)
+	}
+	live, err := cs.Objects.Get(ctx, ref)
+	if err != nil && !trace.IsNotFound(err) {
+		return nil, trace.Wrap(err, "failed to check the owner of %v: %v", ref, err)
+	}
+	if live != nil {
+		if err := CheckOwnership(live, cs.Owner); err != nil {
+			if !cs.ForceAdopt {
+				return nil, trace.Wrap(err)
+			}
+			log.Warningf("adopting resource: %v", err)
+		}
+	}
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[OwnerAnnotation] = cs.Owner
+	object.SetAnnotations(annotations)
+	return EncodeObjects(objects)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type OwnershipSuite struct{}
+
+var _ = Suite(&OwnershipSuite{})
+
+func (s *OwnershipSuite) TestClaimOwnership(c *C) {
+	live := func(annotations, labels map[string]string) *unstructured.Unstructured {
+		object := &unstructured.Unstructured{}
+		object.SetAPIVersion("apps/v1")
+		object.SetKind(KindDeployment)
+		object.SetNamespace(DefaultNamespace)
+		object.SetName("api")
+		object.SetAnnotations(annotations)
+		object.SetLabels(labels)
+		return object
+	}
+	manifest := []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+`)
+	tcs := []struct {
+		comment string
+		live    *unstructured.Unstructured
+		owner   string
+		adopt   bool
+		error   bool
+	}{
+		{comment: "new resource", owner: "shop"},
+		{comment: "same owner", live: live(map[string]string{OwnerAnnotation: "shop"}, nil), owner: "shop"},
+		{comment: "unowned resource", live: live(nil, nil), owner: "shop"},
+		{comment: "another owner", live: live(map[string]string{OwnerAnnotation: "billing"}, nil), owner: "shop", error: true},
+		{comment: "owned resource without owner", live: live(map[string]string{OwnerAnnotation: "billing"}, nil)},
+		{comment: "another tool", live: live(nil, map[string]string{ManagedByLabel: "Helm"}), owner: "shop"},
+		{comment: "adopted", live: live(map[string]string{OwnerAnnotation: "billing"}, nil), owner: "shop", adopt: true},
+	}
+	for _, tc := range tcs {
+		comment := Commentf(tc.comment)
+		objects := memObjects{}
+		if tc.live != nil {
+			c.Assert(objects.Apply(context.TODO(), tc.live), IsNil)
+		}
+		cs := &Changeset{ChangesetConfig: ChangesetConfig{Objects: objects, Owner: tc.owner, ForceAdopt: tc.adopt}}
+		out, err := cs.claimOwnership(context.TODO(), manifest)
+		if tc.error {
+			c.Assert(trace.IsCompareFailed(err), Equals, true, comment)
+			continue
+		}
+		c.Assert(err, IsNil, comment)
+		result, err := DecodeObjects(out)
+		c.Assert(err, IsNil, comment)
+		c.Assert(result[0].GetAnnotations()[OwnerAnnotation], Equals, tc.owner, comment)
+	}
+}
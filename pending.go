@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// DefaultPendingTimeout is the default duration pods are allowed to wait
+// for scheduling and image pulls before status attempts are counted
+const DefaultPendingTimeout = 10 * time.Minute
+
+// PendingReporter is implemented by status reporters whose pods may wait
+// to be scheduled or for their images to be pulled. Status attempts are not
+// consumed while the resource is pending, up to the pending timeout
+type PendingReporter interface {
+	// Pending returns true if none of the pods of the resource has started yet
+	Pending() (bool, error)
+	// MaxPending returns how long the resource is allowed to stay pending
+	MaxPending() time.Duration
+}
+
+// retryPending retries fn like retry, but the attempts made while pending
+// returns true are not counted. If the resource is still pending after
+// maxPending, the wait fails with LimitExceeded
+func retryPending(ctx context.Context, times int, period, maxPending time.Duration, pending func() (bool, error), fn func() error) error {
+	if times < 1 {
+		return nil
+	}
+	deadline := time.Now().Add(maxPending)
+	var attempt int
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		isPending, errPending := pending()
+		if errPending != nil {
+			log.Warningf("failed to check whether pods are pending: %v", trace.DebugReport(errPending))
+		}
+		switch {
+		case isPending && time.Now().After(deadline):
+			return trace.LimitExceeded("pods are still pending after %v: %v", maxPending, err)
+		case isPending:
+			log.Infof("pods are pending, attempt not counted, result: %v, retry in %v", trace.DebugReport(err), period)
+		default:
+			attempt++
+			if attempt >= times {
+				return err
+			}
+			log.Infof("attempt %v, result: %v, retry in %v", attempt+1, trace.DebugReport(err), period)
+		}
+		select {
+		case <-ctx.Done():
+			warnCancelled(ctx, err)
+			return err
+		case <-time.After(period):
+		}
+	}
+}
+
+// podPending returns true if the pod is waiting to be scheduled
+// or for its containers to start, e.g. while the images are pulled
+func podPending(pod v1.Pod) bool {
+	if pod.Status.Phase != v1.PodPending {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status != v1.ConditionTrue {
+			return true
+		}
+	}
+	return !containersStarted(pod.Status.InitContainerStatuses) && !containersStarted(pod.Status.ContainerStatuses)
+}
+
+// containersStarted returns true if any of the containers is running or has run
+func containersStarted(statuses []v1.ContainerStatus) bool {
+	for _, status := range statuses {
+		if status.State.Running != nil || status.State.Terminated != nil {
+			return true
+		}
+	}
+	return false
+}
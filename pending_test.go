@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+)
+
+type PendingSuite struct{}
+
+var _ = Suite(&PendingSuite{})
+
+func (s *PendingSuite) TestPodPending(c *C) {
+	unscheduled := v1.Pod{Status: v1.PodStatus{
+		Phase:      v1.PodPending,
+		Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionFalse}},
+	}}
+	pulling := v1.Pod{Status: v1.PodStatus{
+		Phase:      v1.PodPending,
+		Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionTrue}},
+		ContainerStatuses: []v1.ContainerStatus{{
+			State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+		}},
+	}}
+	initializing := v1.Pod{Status: v1.PodStatus{
+		Phase:      v1.PodPending,
+		Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionTrue}},
+		InitContainerStatuses: []v1.ContainerStatus{{
+			State: v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		}},
+	}}
+	running := v1.Pod{Status: v1.PodStatus{Phase: v1.PodRunning}}
+	c.Assert(podPending(unscheduled), Equals, true)
+	c.Assert(podPending(pulling), Equals, true)
+	c.Assert(podPending(initializing), Equals, false)
+	c.Assert(podPending(running), Equals, false)
+}
+
+func (s *PendingSuite) TestRetryPending(c *C) {
+	var calls, pendingCalls int
+	fn := func() error {
+		calls++
+		return trace.CompareFailed("not ready")
+	}
+	pending := func() (bool, error) {
+		pendingCalls++
+		return pendingCalls <= 3, nil
+	}
+	err := retryPending(context.TODO(), 2, time.Millisecond, time.Minute, pending, fn)
+	c.Assert(trace.IsCompareFailed(err), Equals, true)
+	// three attempts made while pending are not counted
+	c.Assert(calls, Equals, 5)
+
+	alwaysPending := func() (bool, error) { return true, nil }
+	err = retryPending(context.TODO(), 10, time.Millisecond, 0, alwaysPending, fn)
+	c.Assert(trace.IsLimitExceeded(err), Equals, true)
+}
@@ -0,0 +1,295 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// daemonSetTolerations are the tolerations the daemon set controller
+// adds to the daemon set pods
+var daemonSetTolerations = []v1.Toleration{
+	{Key: "node.kubernetes.io/not-ready", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute},
+	{Key: "node.kubernetes.io/unreachable", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute},
+	{Key: "node.kubernetes.io/disk-pressure", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+	{Key: "node.kubernetes.io/memory-pressure", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+	{Key: "node.kubernetes.io/pid-pressure", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+	{Key: "node.kubernetes.io/unschedulable", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+}
+
+// daemonSetNodes returns the nodes expected to run a pod of the daemon set
+// with the pod spec. Nodes without a pod are skipped if they are cordoned,
+// have taints the pod does not tolerate, do not match the required node
+// affinity or run an operating system the pod can not run on, nodes that already run a pod are always returned
+func daemonSetNodes(nodes []v1.Node, pods []v1.Pod, spec v1.PodSpec, entry *log.Entry) []v1.Node {
+	tolerations := append(append([]v1.Toleration(nil), spec.Tolerations...), daemonSetTolerations...)
+	var result []v1.Node
+	for _, node := range nodes {
+		if _, ok := podOnNode(pods, node.Name); ok {
+			result = append(result, node)
+			continue
+		}
+		if err := canSchedule(node, spec, tolerations); err != nil {
+			entry.Infof("skip node %v: %v", node.Name, err)
+			continue
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// canSchedule returns an error if the pod with the spec and tolerations
+// can not be scheduled on the node
+func canSchedule(node v1.Node, spec v1.PodSpec, tolerations []v1.Toleration) error {
+	if node.Spec.Unschedulable {
+		return trace.BadParameter("node is cordoned")
+	}
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !toleratesTaint(tolerations, taint) {
+			return trace.BadParameter("pod does not tolerate taint %v", taint.ToString())
+		}
+	}
+	if !matchesNodeAffinity(node, spec.Affinity) {
+		return trace.BadParameter("node does not match the required node affinity")
+	}
+	return trace.Wrap(checkNodeOS(node, spec))
+}
+
+// OS labels of the nodes, the beta label is set by older kubelets
+const (
+	nodeOSLabel     = "kubernetes.io/os"
+	nodeOSBetaLabel = "beta.kubernetes.io/os"
+	// defaultPodOS is the operating system of pods
+	// whose templates do not select one
+	defaultPodOS = "linux"
+)
+
+// nodesForOS returns the nodes running an operating system
+// the pods with the spec can run on
+func nodesForOS(nodes []v1.Node, spec v1.PodSpec, entry *log.Entry) []v1.Node {
+	var result []v1.Node
+	for _, node := range nodes {
+		if err := checkNodeOS(node, spec); err != nil {
+			entry.Infof("skip node %v: %v", node.Name, err)
+			continue
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// checkNodeOS returns an error if the node runs an operating system
+// the pods with the spec can not run on, nodes with unknown OS are accepted
+func checkNodeOS(node v1.Node, spec v1.PodSpec) error {
+	os := nodeOS(node)
+	if os == "" {
+		return nil
+	}
+	supported := podOS(spec)
+	for _, name := range supported {
+		if name == os {
+			return nil
+		}
+	}
+	return trace.BadParameter("node runs %v, pod template supports %v", os, supported)
+}
+
+// nodeOS returns the operating system of the node from its labels
+// or node info, returns empty string if unknown
+func nodeOS(node v1.Node) string {
+	for _, label := range []string{nodeOSLabel, nodeOSBetaLabel} {
+		if os := node.Labels[label]; os != "" {
+			return os
+		}
+	}
+	return node.Status.NodeInfo.OperatingSystem
+}
+
+// podOS returns the operating systems the pods with the spec can run on,
+// taken from the node selector or the required node affinity,
+// defaults to linux if the spec does not select one
+func podOS(spec v1.PodSpec) []string {
+	for _, label := range []string{nodeOSLabel, nodeOSBetaLabel} {
+		if os := spec.NodeSelector[label]; os != "" {
+			return []string{os}
+		}
+	}
+	var result []string
+	affinity := spec.Affinity
+	if affinity != nil && affinity.NodeAffinity != nil &&
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				if expr.Operator == v1.NodeSelectorOpIn && (expr.Key == nodeOSLabel || expr.Key == nodeOSBetaLabel) {
+					result = append(result, expr.Values...)
+				}
+			}
+		}
+	}
+	if len(result) == 0 {
+		return []string{defaultPodOS}
+	}
+	return result
+}
+
+func toleratesTaint(tolerations []v1.Toleration, taint *v1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNodeAffinity returns true if the node matches any of the terms
+// of the required node affinity or if there is no required node affinity
+func matchesNodeAffinity(node v1.Node, affinity *v1.Affinity) bool {
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for _, term := range terms {
+		if matchesNodeSelectorTerm(node, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesNodeSelectorTerm returns true if the node matches all requirements of the term
+func matchesNodeSelectorTerm(node v1.Node, term v1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for _, expr := range term.MatchExpressions {
+		if !matchesRequirement(labels.Set(node.Labels), expr) {
+			return false
+		}
+	}
+	for _, expr := range term.MatchFields {
+		if expr.Key != "metadata.name" || !matchesRequirement(labels.Set{expr.Key: node.Name}, expr) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesRequirement(set labels.Set, expr v1.NodeSelectorRequirement) bool {
+	var op selection.Operator
+	switch expr.Operator {
+	case v1.NodeSelectorOpIn:
+		op = selection.In
+	case v1.NodeSelectorOpNotIn:
+		op = selection.NotIn
+	case v1.NodeSelectorOpExists:
+		op = selection.Exists
+	case v1.NodeSelectorOpDoesNotExist:
+		op = selection.DoesNotExist
+	case v1.NodeSelectorOpGt:
+		op = selection.GreaterThan
+	case v1.NodeSelectorOpLt:
+		op = selection.LessThan
+	default:
+		return false
+	}
+	requirement, err := labels.NewRequirement(expr.Key, op, expr.Values)
+	if err != nil {
+		return false
+	}
+	return requirement.Matches(set)
+}
+
+// NodeFilter restricts the nodes checked by pod readiness checks,
+// e.g. to keep the results stable while an autoscaler adds nodes
+type NodeFilter struct {
+	// Names lists the nodes to check, all nodes are checked if empty
+	Names []string
+	// Selector selects the nodes to check in addition to the pod node selector
+	Selector labels.Selector
+	// ExpectedCount is the number of nodes expected to run ready pods.
+	// If set, the check succeeds once that many nodes run ready pods
+	// regardless of the nodes without pods
+	ExpectedCount int
+}
+
+// filter returns the nodes matching the filter, returns NotFound
+// if any of the named nodes is missing
+func (f NodeFilter) filter(nodes []v1.Node) ([]v1.Node, error) {
+	if len(f.Names) == 0 && f.Selector == nil {
+		return nodes, nil
+	}
+	names := make(map[string]bool, len(f.Names))
+	for _, name := range f.Names {
+		names[name] = false
+	}
+	var result []v1.Node
+	for _, node := range nodes {
+		if f.Selector != nil && !f.Selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		if len(names) != 0 {
+			if _, ok := names[node.Name]; !ok {
+				continue
+			}
+			names[node.Name] = true
+		}
+		result = append(result, node)
+	}
+	var missing []string
+	for _, name := range f.Names {
+		if !names[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) != 0 {
+		return nil, trace.NotFound("nodes %v not found", missing)
+	}
+	return result, nil
+}
+
+// checkNodes checks that pods are running and ready on the nodes matching the filter
+func checkNodes(pods []v1.Pod, nodes []v1.Node, filter NodeFilter, entry *log.Entry) error {
+	nodes, err := filter.filter(nodes)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if filter.ExpectedCount == 0 {
+		return checkRunning(pods, nodes, entry)
+	}
+	var ready int
+	for _, node := range nodes {
+		pod, ok := podOnNode(pods, node.Name)
+		if ok && pod.Status.Phase == v1.PodRunning && isPodReadyConditionTrue(pod.Status) {
+			ready++
+		}
+	}
+	if ready < filter.ExpectedCount {
+		return trace.CompareFailed("pods are running and ready on %v of %v expected nodes",
+			ready, filter.ExpectedCount)
+	}
+	entry.Infof("pods are running and ready on %v nodes, expected %v", ready, filter.ExpectedCount)
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type PlacementSuite struct{}
+
+var _ = Suite(&PlacementSuite{})
+
+func (s *PlacementSuite) TestDaemonSetNodes(c *C) {
+	node := func(name string, labels map[string]string, unschedulable bool, taints ...v1.Taint) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       v1.NodeSpec{Unschedulable: unschedulable, Taints: taints},
+		}
+	}
+	master := v1.Taint{Key: masterRoleLabel, Effect: v1.TaintEffectNoSchedule}
+	nodes := []v1.Node{
+		node("worker", map[string]string{"zone": "a"}, false),
+		node("master", map[string]string{"zone": "a"}, false, master),
+		node("cordoned", map[string]string{"zone": "a"}, true),
+		node("cordoned-with-pod", map[string]string{"zone": "a"}, true),
+		node("other-zone", map[string]string{"zone": "b"}, false),
+		node("not-ready", map[string]string{"zone": "a"}, false,
+			v1.Taint{Key: "node.kubernetes.io/not-ready", Effect: v1.TaintEffectNoExecute}),
+		node("preferred", map[string]string{"zone": "a"}, false,
+			v1.Taint{Key: "dedicated", Effect: v1.TaintEffectPreferNoSchedule}),
+	}
+	pods := []v1.Pod{{Spec: v1.PodSpec{NodeName: "cordoned-with-pod"}}}
+	spec := v1.PodSpec{
+		Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{
+						Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a"},
+					}},
+				}},
+			},
+		}},
+	}
+	entry := log.WithField("test", "placement")
+	c.Assert(nodeNames(daemonSetNodes(nodes, pods, spec, entry)), DeepEquals,
+		[]string{"worker", "cordoned-with-pod", "not-ready", "preferred"})
+
+	spec.Tolerations = []v1.Toleration{{Key: masterRoleLabel, Operator: v1.TolerationOpExists}}
+	c.Assert(nodeNames(daemonSetNodes(nodes, pods, spec, entry)), DeepEquals,
+		[]string{"worker", "master", "cordoned-with-pod", "not-ready", "preferred"})
+}
+
+func (s *PlacementSuite) TestNodeOS(c *C) {
+	nodes := []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "linux", Labels: map[string]string{nodeOSLabel: "linux"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "windows", Labels: map[string]string{nodeOSBetaLabel: "windows"}}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "windows-info"},
+			Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{OperatingSystem: "windows"}},
+		},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unknown"}},
+	}
+	entry := log.WithField("test", "placement")
+	c.Assert(nodeNames(daemonSetNodes(nodes, nil, v1.PodSpec{}, entry)), DeepEquals,
+		[]string{"linux", "unknown"})
+
+	windows := v1.PodSpec{NodeSelector: map[string]string{nodeOSLabel: "windows"}}
+	c.Assert(nodeNames(daemonSetNodes(nodes, nil, windows, entry)), DeepEquals,
+		[]string{"windows", "windows-info", "unknown"})
+
+	mixed := v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{
+					Key: nodeOSBetaLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"linux", "windows"},
+				}},
+			}},
+		},
+	}}}
+	c.Assert(nodeNames(nodesForOS(nodes, mixed, entry)), DeepEquals,
+		[]string{"linux", "windows", "windows-info", "unknown"})
+}
+
+func (s *PlacementSuite) TestPodOnNode(c *C) {
+	now := time.Now()
+	old := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "old", UID: "1", CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))}}
+	terminating := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "terminating", UID: "2", CreationTimestamp: metav1.NewTime(now), DeletionTimestamp: &metav1.Time{Time: now}}}
+	replacement := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "replacement", UID: "3", CreationTimestamp: metav1.NewTime(now.Add(-time.Minute))}}
+	pods := []v1.Pod{podOn("a", old), podOn("a", terminating), podOn("a", replacement), podOn("b", old)}
+
+	pod, ok := podOnNode(pods, "a")
+	c.Assert(ok, Equals, true)
+	c.Assert(pod.Name, Equals, "replacement")
+	pod, ok = podOnNode(pods, "b")
+	c.Assert(ok, Equals, true)
+	c.Assert(pod.Name, Equals, "old")
+	_, ok = podOnNode(pods, "c")
+	c.Assert(ok, Equals, false)
+}
+
+func podOn(node string, pod v1.Pod) v1.Pod {
+	pod.Spec.NodeName = node
+	return pod
+}
+
+func nodeNames(nodes []v1.Node) []string {
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+func (s *PlacementSuite) TestCheckNodes(c *C) {
+	ready := v1.Pod{Status: v1.PodStatus{
+		Phase:      v1.PodRunning,
+		Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+	}}
+	nodes := []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"pool": "default"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Labels: map[string]string{"pool": "default"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "new", Labels: map[string]string{"pool": "autoscaled"}}},
+	}
+	pods := []v1.Pod{podOn("a", ready), podOn("b", ready)}
+	entry := log.WithField("test", "placement")
+
+	c.Assert(checkNodes(pods, nodes, NodeFilter{ExpectedCount: 2}, entry), IsNil)
+	c.Assert(checkNodes(pods, nodes, NodeFilter{ExpectedCount: 3}, entry), NotNil)
+	c.Assert(checkNodes(pods, nodes, NodeFilter{Names: []string{"a", "b"}, ExpectedCount: 2}, entry), IsNil)
+	c.Assert(checkNodes(pods, nodes, NodeFilter{Names: []string{"a", "missing"}}, entry), NotNil)
+
+	filter := NodeFilter{Selector: labels.SelectorFromSet(labels.Set{"pool": "default"})}
+	filtered, err := filter.filter(nodes)
+	c.Assert(err, IsNil)
+	c.Assert(nodeNames(filtered), DeepEquals, []string{"a", "b"})
+}
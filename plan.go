@@ -0,0 +1,172 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gravitational/trace"
+)
+
+// PlanAction is an action the plan intends to take on a resource
+type PlanAction string
+
+const (
+	// PlanCreate creates a resource missing in the cluster
+	PlanCreate PlanAction = "create"
+	// PlanUpdate updates a resource that differs from the bundle
+	PlanUpdate PlanAction = "update"
+	// PlanDelete deletes a resource removed from the bundle
+	PlanDelete PlanAction = "delete"
+	// PlanNoop leaves a resource matching the bundle intact
+	PlanNoop PlanAction = "noop"
+)
+
+// PlannedChange is an intended action on a resource
+type PlannedChange struct {
+	// Action is the intended action
+	Action PlanAction `json:"action"`
+	// Ref references the resource
+	Ref ObjectRef `json:"ref"`
+	// Diff lists the fields changed by an update
+	Diff []FieldDrift `json:"diff,omitempty"`
+}
+
+// ChangePlan is an ordered list of actions applying the bundle would take
+type ChangePlan struct {
+	// Bundle is the name of the bundle
+	Bundle string `json:"bundle"`
+	// Changes lists the actions in the order they are taken
+	Changes []PlannedChange `json:"changes"`
+}
+
+// Plan compares the bundle against the live cluster state and returns
+// the actions applying the bundle would take without changing the cluster.
+// Resources are deleted if they are recorded in the bundle inventory but
+// are no longer in the bundle
+func Plan(ctx context.Context, bundle *Bundle) (*ChangePlan, error) {
+	objects := KubectlObjects{}
+	inventory, err := NewInventory(InventoryConfig{
+		Bundle:    bundle.Name,
+		Namespace: bundle.Namespace,
+		Objects:   objects,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return plan(ctx, objects, inventory, bundle)
+}
+
+// PlanUpsert compares the resources against the live cluster state and returns
+// the creations and updates upserting them would take without changing the cluster.
+// Unlike Plan, it does not consult the bundle inventory, so no deletions are planned
+func PlanUpsert(ctx context.Context, bundle *Bundle) (*ChangePlan, error) {
+	return planObjects(ctx, KubectlObjects{}, bundle)
+}
+
+func plan(ctx context.Context, objects ObjectInterface, inventory *Inventory, bundle *Bundle) (*ChangePlan, error) {
+	result, err := planObjects(ctx, objects, bundle)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	stale, err := inventory.Stale(ctx, bundle.Refs())
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, ref := range stale {
+		result.Changes = append(result.Changes, PlannedChange{Action: PlanDelete, Ref: ref})
+	}
+	return result, nil
+}
+
+// planObjects returns the creations and updates of the bundle resources
+func planObjects(ctx context.Context, objects ObjectInterface, bundle *Bundle) (*ChangePlan, error) {
+	result := &ChangePlan{Bundle: bundle.Name}
+	for _, object := range bundle.Objects {
+		if err := ctx.Err(); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		ref := bundle.Ref(object)
+		live, err := objects.Get(ctx, ref)
+		if err != nil {
+			if !trace.IsNotFound(err) {
+				return nil, trace.Wrap(err)
+			}
+			result.Changes = append(result.Changes, PlannedChange{Action: PlanCreate, Ref: ref})
+			continue
+		}
+		change := PlannedChange{Action: PlanNoop, Ref: ref, Diff: diffObjects(object, live)}
+		if len(change.Diff) != 0 {
+			change.Action = PlanUpdate
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	return result, nil
+}
+
+// Count returns the number of changes with the action
+func (p *ChangePlan) Count(action PlanAction) int {
+	var count int
+	for _, change := range p.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// HasChanges returns true if the plan modifies any resources
+func (p *ChangePlan) HasChanges() bool {
+	return p.Count(PlanNoop) != len(p.Changes)
+}
+
+// String returns the plan summary, e.g. "1 to create, 2 to update, 0 to delete"
+func (p *ChangePlan) String() string {
+	return fmt.Sprintf("%v to create, %v to update, %v to delete",
+		p.Count(PlanCreate), p.Count(PlanUpdate), p.Count(PlanDelete))
+}
+
+// WriteTable writes the plan as a human readable table
+// with the changed fields of updated resources
+func (p *ChangePlan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, '\t', 0)
+	fmt.Fprintf(tw, "Action\tResource\tChanges\n")
+	for _, change := range p.Changes {
+		var fields []string
+		for _, field := range change.Diff {
+			fields = append(fields, fmt.Sprintf("%v: %v -> %v", field.Path, field.Live, field.Desired))
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", change.Action, change.Ref, strings.Join(fields, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	_, err := fmt.Fprintf(w, "\nPlan: %v\n", p)
+	return trace.ConvertSystemError(err)
+}
+
+// WriteJSON writes the plan as JSON
+func (p *ChangePlan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return trace.Wrap(encoder.Encode(p))
+}
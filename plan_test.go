@@ -0,0 +1,140 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type PlanSuite struct{}
+
+var _ = Suite(&PlanSuite{})
+
+func (s *PlanSuite) TestPlan(c *C) {
+	ctx := context.TODO()
+	objects := memObjects{}
+	bundle, err := NewBundle("app", "default", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: new
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: changed
+data:
+  a: b
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: same
+data:
+  a: b
+`))
+	c.Assert(err, IsNil)
+	live, err := DecodeObjects([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: changed
+  namespace: default
+data:
+  a: c
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: same
+  namespace: default
+data:
+  a: b
+`))
+	c.Assert(err, IsNil)
+	for _, object := range live {
+		c.Assert(objects.Apply(ctx, object), IsNil)
+	}
+	inventory, err := NewInventory(InventoryConfig{Bundle: "app", Namespace: "default", Objects: objects})
+	c.Assert(err, IsNil)
+	removed := ObjectRef{APIVersion: "v1", Kind: KindConfigMap, Namespace: "default", Name: "removed"}
+	c.Assert(inventory.Update(ctx, append(bundle.Refs(), removed)), IsNil)
+
+	result, err := plan(ctx, objects, inventory, bundle)
+	c.Assert(err, IsNil)
+	var actions []PlanAction
+	for _, change := range result.Changes {
+		actions = append(actions, change.Action)
+	}
+	c.Assert(actions, DeepEquals, []PlanAction{PlanCreate, PlanUpdate, PlanNoop, PlanDelete})
+	c.Assert(result.Changes[1].Diff, DeepEquals, []FieldDrift{{Path: "data.a", Desired: "b", Live: "c"}})
+	c.Assert(result.Changes[3].Ref, DeepEquals, removed)
+	c.Assert(result.HasChanges(), Equals, true)
+	c.Assert(result.String(), Equals, "1 to create, 1 to update, 1 to delete")
+
+	var out bytes.Buffer
+	c.Assert(result.WriteTable(&out), IsNil)
+	c.Assert(out.String(), Matches, "(?s).*update\\s+ConfigMap/default/changed\\s+data.a: c -> b\n.*")
+
+	result, err = planObjects(ctx, objects, bundle)
+	c.Assert(err, IsNil)
+	c.Assert(result.String(), Equals, "1 to create, 1 to update, 0 to delete")
+}
+
+// memObjects is an in-memory ObjectInterface
+type memObjects map[string]*unstructured.Unstructured
+
+func (m memObjects) Get(ctx context.Context, ref ObjectRef) (*unstructured.Unstructured, error) {
+	object, ok := m[ref.key()]
+	if !ok {
+		return nil, trace.NotFound("%v not found", ref)
+	}
+	return object.DeepCopy(), nil
+}
+
+func (m memObjects) Apply(ctx context.Context, object *unstructured.Unstructured) error {
+	m[m.ref(object).key()] = object.DeepCopy()
+	return nil
+}
+
+func (m memObjects) Delete(ctx context.Context, ref ObjectRef) error {
+	delete(m, ref.key())
+	return nil
+}
+
+func (m memObjects) List(ctx context.Context, gvk schema.GroupVersionKind, namespace string) ([]unstructured.Unstructured, error) {
+	var items []unstructured.Unstructured
+	for _, object := range m {
+		if object.GroupVersionKind() == gvk && (namespace == "" || object.GetNamespace() == namespace) {
+			items = append(items, *object.DeepCopy())
+		}
+	}
+	return items, nil
+}
+
+func (m memObjects) ref(object *unstructured.Unstructured) ObjectRef {
+	return ObjectRef{
+		APIVersion: object.GetAPIVersion(),
+		Kind:       object.GetKind(),
+		Namespace:  object.GetNamespace(),
+		Name:       object.GetName(),
+	}
+}
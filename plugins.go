@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// checkAction returns BadParameter if the action is neither
+// built-in nor mapped to a plugin
+func (k Kubectl) checkAction(act Action) error {
+	if _, ok := k.Plugins[act]; ok {
+		return nil
+	}
+	return trace.Wrap(act.Check())
+}
+
+// subcommand returns the kubectl arguments performing the action
+func (k Kubectl) subcommand(act Action) []string {
+	if args, ok := k.Plugins[act]; ok && len(args) != 0 {
+		return append([]string(nil), args...)
+	}
+	return []string{string(act)}
+}
+
+// RunPlugin runs the kubectl subcommand or plugin mapped to the action
+// with the supplied arguments and the data on the standard input, if any.
+// Returns BadParameter if the action is not mapped to a plugin
+func (k Kubectl) RunPlugin(act Action, data []byte, args ...string) (*KubectlResult, error) {
+	if _, ok := k.Plugins[act]; !ok {
+		return nil, trace.BadParameter("action %q is not mapped to a kubectl plugin", string(act))
+	}
+	var stdin io.Reader
+	if data != nil {
+		stdin = bytes.NewReader(data)
+	}
+	return k.Run(stdin, append(k.subcommand(act), args...)...)
+}
+
+// KubectlPlugin is a transformer passing the manifest stream through
+// a kubectl plugin, e.g. kubectl-neat to strip the fields set by the cluster.
+// The stream is written to the standard input of the plugin
+// and replaced with its standard output
+type KubectlPlugin struct {
+	// Kubectl runs the plugin
+	Kubectl
+	// Action is the action mapped to the plugin in Plugins of Kubectl,
+	// the action name itself is run as the subcommand if it is not mapped
+	Action Action
+	// Args are the additional arguments of the plugin
+	Args []string
+}
+
+// ParseKubectlPlugin parses the plugin command line, e.g. "neat" or
+// "slice --include-kind Deployment", into the transformer running it
+func ParseKubectlPlugin(kubectl Kubectl, in string) (*KubectlPlugin, error) {
+	fields := strings.Fields(in)
+	if len(fields) == 0 {
+		return nil, trace.BadParameter("missing kubectl plugin")
+	}
+	return &KubectlPlugin{Kubectl: kubectl, Action: Action(fields[0]), Args: fields[1:]}, nil
+}
+
+// Transform returns the output of the plugin for the manifest stream
+func (p KubectlPlugin) Transform(data []byte) ([]byte, error) {
+	kubectl := p.Kubectl
+	if _, ok := kubectl.Plugins[p.Action]; !ok {
+		plugins := make(map[Action][]string, len(kubectl.Plugins)+1)
+		for act, args := range kubectl.Plugins {
+			plugins[act] = args
+		}
+		plugins[p.Action] = []string{string(p.Action)}
+		kubectl.Plugins = plugins
+	}
+	result, err := kubectl.RunPlugin(p.Action, data, p.Args...)
+	if err != nil {
+		return nil, trace.Wrap(err, "kubectl %v failed", p.Action)
+	}
+	return result.Stdout, nil
+}
@@ -0,0 +1,329 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodSecurityLevel is a pod security standards level
+type PodSecurityLevel string
+
+const (
+	// PodSecurityPrivileged is an unrestricted level
+	PodSecurityPrivileged PodSecurityLevel = "privileged"
+	// PodSecurityBaseline prevents known privilege escalations
+	PodSecurityBaseline PodSecurityLevel = "baseline"
+	// PodSecurityRestricted enforces pod hardening best practices
+	PodSecurityRestricted PodSecurityLevel = "restricted"
+)
+
+const (
+	// PodSecurityEnforceLabel is the namespace label for the enforced level
+	PodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	// PodSecurityWarnLabel is the namespace label for the warning level
+	PodSecurityWarnLabel = "pod-security.kubernetes.io/warn"
+	// PodSecurityAuditLabel is the namespace label for the audit level
+	PodSecurityAuditLabel = "pod-security.kubernetes.io/audit"
+	// PodSecurityVersionLatest pins the policy to the latest version
+	PodSecurityVersionLatest = "latest"
+)
+
+// ParsePodSecurityLevel parses the pod security level from a string
+func ParsePodSecurityLevel(level string) (PodSecurityLevel, error) {
+	switch PodSecurityLevel(level) {
+	case PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted:
+		return PodSecurityLevel(level), nil
+	}
+	return "", trace.BadParameter("unsupported pod security level %q, supported are: %v, %v, %v",
+		level, PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted)
+}
+
+// PodSecurityConfig defines pod security admission settings for a namespace
+type PodSecurityConfig struct {
+	// Namespace is the namespace to label
+	Namespace string
+	// Enforce is the level enforced by admission
+	Enforce PodSecurityLevel
+	// Warn is the level that generates user-facing warnings, defaults to Enforce
+	Warn PodSecurityLevel
+	// Audit is the level that generates audit annotations, defaults to Enforce
+	Audit PodSecurityLevel
+	// Client is k8s client
+	Client *kubernetes.Clientset
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *PodSecurityConfig) CheckAndSetDefaults() error {
+	var errors []error
+	if c.Namespace == "" {
+		errors = append(errors, trace.BadParameter("missing parameter Namespace"))
+	}
+	if c.Client == nil {
+		errors = append(errors, trace.BadParameter("missing parameter Client"))
+	}
+	if _, err := ParsePodSecurityLevel(string(c.Enforce)); err != nil {
+		errors = append(errors, err)
+	}
+	if c.Warn == "" {
+		c.Warn = c.Enforce
+	}
+	if c.Audit == "" {
+		c.Audit = c.Enforce
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// LabelNamespacePodSecurity sets pod security admission labels on the namespace
+func LabelNamespacePodSecurity(ctx context.Context, config PodSecurityConfig) error {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	namespaces := config.Client.CoreV1().Namespaces()
+	levels := map[string]PodSecurityLevel{
+		PodSecurityEnforceLabel: config.Enforce,
+		PodSecurityWarnLabel:    config.Warn,
+		PodSecurityAuditLabel:   config.Audit,
+	}
+	log.WithField("namespace", config.Namespace).Infof("set pod security level %v", config.Enforce)
+	return RetryOnConflict(ctx, func() error {
+		namespace, err := namespaces.Get(config.Namespace, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		if namespace.Labels == nil {
+			namespace.Labels = make(map[string]string)
+		}
+		for label, level := range levels {
+			namespace.Labels[label] = string(level)
+			namespace.Labels[label+"-version"] = PodSecurityVersionLatest
+		}
+		_, err = namespaces.Update(namespace)
+		return ConvertErrorWithContext(err, "cannot label namespace %q", config.Namespace)
+	})
+}
+
+// PodSecurityPolicyForLevel returns a pod security policy approximating
+// the specified pod security level for clusters without pod security admission.
+// The result can be applied with PodSecurityPolicyControl
+func PodSecurityPolicyForLevel(level PodSecurityLevel) (*v1beta1.PodSecurityPolicy, error) {
+	if _, err := ParsePodSecurityLevel(string(level)); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	policy := &v1beta1.PodSecurityPolicy{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       KindPodSecurityPolicy,
+			APIVersion: ExtensionsAPIVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("rigging-%v", level),
+		},
+		Spec: v1beta1.PodSecurityPolicySpec{
+			SELinux:            v1beta1.SELinuxStrategyOptions{Rule: v1beta1.SELinuxStrategyRunAsAny},
+			SupplementalGroups: v1beta1.SupplementalGroupsStrategyOptions{Rule: v1beta1.SupplementalGroupsStrategyRunAsAny},
+			FSGroup:            v1beta1.FSGroupStrategyOptions{Rule: v1beta1.FSGroupStrategyRunAsAny},
+			RunAsUser:          v1beta1.RunAsUserStrategyOptions{Rule: v1beta1.RunAsUserStrategyRunAsAny},
+			Volumes:            []v1beta1.FSType{v1beta1.All},
+		},
+	}
+	switch level {
+	case PodSecurityPrivileged:
+		policy.Spec.Privileged = true
+		policy.Spec.HostNetwork = true
+		policy.Spec.HostPID = true
+		policy.Spec.HostIPC = true
+		policy.Spec.AllowedCapabilities = []v1.Capability{"*"}
+		policy.Spec.HostPorts = []v1beta1.HostPortRange{{Min: 0, Max: 65535}}
+	case PodSecurityBaseline:
+		policy.Spec.AllowedCapabilities = baselineCapabilities
+		policy.Spec.Volumes = baselineVolumes
+	case PodSecurityRestricted:
+		allowEscalation := false
+		policy.Spec.AllowPrivilegeEscalation = &allowEscalation
+		policy.Spec.RequiredDropCapabilities = []v1.Capability{"ALL"}
+		policy.Spec.AllowedCapabilities = []v1.Capability{"NET_BIND_SERVICE"}
+		policy.Spec.RunAsUser = v1beta1.RunAsUserStrategyOptions{Rule: v1beta1.RunAsUserStrategyMustRunAsNonRoot}
+		policy.Spec.Volumes = restrictedVolumes
+	}
+	return policy, nil
+}
+
+// CheckPodSecurity verifies that all workloads in the manifest stream
+// satisfy the specified pod security level.
+// Returns BadParameter listing every violation
+func CheckPodSecurity(level PodSecurityLevel, data []byte) error {
+	if _, err := ParsePodSecurityLevel(string(level)); err != nil {
+		return trace.Wrap(err)
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var violations []string
+	for _, object := range objects {
+		spec, err := GetPodSpec(object)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return trace.Wrap(err)
+		}
+		for _, violation := range podSecurityViolations(level, *spec) {
+			violations = append(violations, fmt.Sprintf("%v %v: %v",
+				object.GetKind(), FormatMeta(metav1.ObjectMeta{Namespace: object.GetNamespace(), Name: object.GetName()}), violation))
+		}
+	}
+	if len(violations) != 0 {
+		return trace.BadParameter("workloads violate pod security level %q:\n%v",
+			level, strings.Join(violations, "\n"))
+	}
+	return nil
+}
+
+// podSecurityViolations returns the list of violations of the pod security level
+// in the specified pod spec
+func podSecurityViolations(level PodSecurityLevel, spec v1.PodSpec) (violations []string) {
+	if level == PodSecurityPrivileged {
+		return nil
+	}
+	if spec.HostNetwork {
+		violations = append(violations, "host network is not allowed")
+	}
+	if spec.HostPID {
+		violations = append(violations, "host PID namespace is not allowed")
+	}
+	if spec.HostIPC {
+		violations = append(violations, "host IPC namespace is not allowed")
+	}
+	for _, volume := range spec.Volumes {
+		if !volumeAllowed(level, volume) {
+			violations = append(violations, fmt.Sprintf("volume %q has disallowed type", volume.Name))
+		}
+	}
+	podNonRoot := spec.SecurityContext != nil && spec.SecurityContext.RunAsNonRoot != nil && *spec.SecurityContext.RunAsNonRoot
+	podRoot := spec.SecurityContext != nil && spec.SecurityContext.RunAsUser != nil && *spec.SecurityContext.RunAsUser == 0
+	if level == PodSecurityRestricted && podRoot {
+		violations = append(violations, "pod must not run as user 0")
+	}
+	containers := append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, violation := range containerSecurityViolations(level, container, podNonRoot) {
+			violations = append(violations, fmt.Sprintf("container %q: %v", container.Name, violation))
+		}
+	}
+	return violations
+}
+
+func containerSecurityViolations(level PodSecurityLevel, container v1.Container, podNonRoot bool) (violations []string) {
+	sc := container.SecurityContext
+	if sc == nil {
+		sc = &v1.SecurityContext{}
+	}
+	if sc.Privileged != nil && *sc.Privileged {
+		violations = append(violations, "privileged containers are not allowed")
+	}
+	for _, port := range container.Ports {
+		if port.HostPort != 0 {
+			violations = append(violations, fmt.Sprintf("host port %v is not allowed", port.HostPort))
+		}
+	}
+	allowedCapabilities := baselineCapabilities
+	if level == PodSecurityRestricted {
+		allowedCapabilities = []v1.Capability{"NET_BIND_SERVICE"}
+	}
+	if sc.Capabilities != nil {
+		for _, capability := range sc.Capabilities.Add {
+			if !hasCapability(allowedCapabilities, capability) {
+				violations = append(violations, fmt.Sprintf("capability %v is not allowed", capability))
+			}
+		}
+	}
+	if level != PodSecurityRestricted {
+		return violations
+	}
+	if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+		violations = append(violations, "allowPrivilegeEscalation must be set to false")
+	}
+	if sc.Capabilities == nil || !hasCapability(sc.Capabilities.Drop, "ALL") {
+		violations = append(violations, "capabilities must drop ALL")
+	}
+	nonRoot := podNonRoot
+	if sc.RunAsNonRoot != nil {
+		nonRoot = *sc.RunAsNonRoot
+	}
+	if !nonRoot {
+		violations = append(violations, "runAsNonRoot must be set to true")
+	}
+	if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+		violations = append(violations, "container must not run as user 0")
+	}
+	return violations
+}
+
+func hasCapability(capabilities []v1.Capability, capability v1.Capability) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeAllowed returns true if the volume type is allowed on the specified level
+func volumeAllowed(level PodSecurityLevel, volume v1.Volume) bool {
+	if volume.HostPath != nil {
+		return false
+	}
+	if level != PodSecurityRestricted {
+		return true
+	}
+	return volume.ConfigMap != nil || volume.Secret != nil || volume.EmptyDir != nil ||
+		volume.DownwardAPI != nil || volume.Projected != nil || volume.PersistentVolumeClaim != nil
+}
+
+// fsTypeProjected is the projected volume type missing from the extensions API
+const fsTypeProjected v1beta1.FSType = "projected"
+
+var (
+	// baselineCapabilities lists capabilities that can be added on the baseline level
+	baselineCapabilities = []v1.Capability{
+		"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+		"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+	}
+	// baselineVolumes lists volume types allowed on the baseline level
+	baselineVolumes = []v1beta1.FSType{
+		v1beta1.ConfigMap, v1beta1.Secret, v1beta1.EmptyDir, v1beta1.DownwardAPI,
+		fsTypeProjected, v1beta1.PersistentVolumeClaim,
+		v1beta1.NFS, v1beta1.ISCSI, v1beta1.RBD, v1beta1.Cinder, v1beta1.AWSElasticBlockStore,
+		v1beta1.GCEPersistentDisk, v1beta1.AzureDisk, v1beta1.AzureFile, v1beta1.FC,
+		v1beta1.Flocker, v1beta1.FlexVolume, v1beta1.CephFS, v1beta1.Glusterfs,
+	}
+	// restrictedVolumes lists volume types allowed on the restricted level
+	restrictedVolumes = []v1beta1.FSType{
+		v1beta1.ConfigMap, v1beta1.Secret, v1beta1.EmptyDir, v1beta1.DownwardAPI,
+		fsTypeProjected, v1beta1.PersistentVolumeClaim,
+	}
+)
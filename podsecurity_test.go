@@ -0,0 +1,89 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type PodSecuritySuite struct{}
+
+var _ = Suite(&PodSecuritySuite{})
+
+func (s *PodSecuritySuite) TestCheckPodSecurity(c *C) {
+	const privileged = `apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+  namespace: kube-system
+spec:
+  template:
+    spec:
+      hostNetwork: true
+      containers:
+      - name: agent
+        image: agent:1.0
+        securityContext:
+          privileged: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: value
+`
+	const restricted = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      securityContext:
+        runAsNonRoot: true
+      containers:
+      - name: migrate
+        image: migrate:1.0
+        securityContext:
+          allowPrivilegeEscalation: false
+          capabilities:
+            drop: ["ALL"]
+`
+	tcs := []struct {
+		level PodSecurityLevel
+		data  string
+		error bool
+	}{
+		{level: PodSecurityPrivileged, data: privileged},
+		{level: PodSecurityBaseline, data: privileged, error: true},
+		{level: PodSecurityBaseline, data: restricted},
+		{level: PodSecurityRestricted, data: restricted},
+		{level: PodSecurityLevel("unknown"), data: restricted, error: true},
+	}
+	for i, tc := range tcs {
+		comment := Commentf("test case %v", i+1)
+		err := CheckPodSecurity(tc.level, []byte(tc.data))
+		if tc.error {
+			c.Assert(err, NotNil, comment)
+			c.Assert(trace.IsBadParameter(err), Equals, true, comment)
+		} else {
+			c.Assert(err, IsNil, comment)
+		}
+	}
+}
@@ -34,7 +34,7 @@ func NewPodSecurityPolicyControl(config PodSecurityPolicyConfig) (*PodSecurityPo
 		PodSecurityPolicyConfig: config,
 		PodSecurityPolicy:       config.Policy,
 		Entry: log.WithFields(log.Fields{
-			"pod_security_policy": formatMeta(config.Policy.ObjectMeta),
+			"pod_security_policy": FormatMeta(config.Policy.ObjectMeta),
 		}),
 	}, nil
 }
@@ -45,6 +45,8 @@ type PodSecurityPolicyConfig struct {
 	Policy v1beta1.PodSecurityPolicy
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *PodSecurityPolicyConfig) CheckAndSetDefaults() error {
@@ -65,14 +67,16 @@ type PodSecurityPolicyControl struct {
 }
 
 func (c *PodSecurityPolicyControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.ObjectMeta))
 
 	err := c.Client.ExtensionsV1beta1().PodSecurityPolicies().Delete(c.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *PodSecurityPolicyControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.ObjectMeta))
 
 	policies := c.Client.ExtensionsV1beta1().PodSecurityPolicies()
 	c.UID = ""
@@ -85,12 +89,18 @@ func (c *PodSecurityPolicyControl) Upsert(ctx context.Context) error {
 			return trace.Wrap(err)
 		}
 		_, err = policies.Create(&c.Policy)
-		return ConvertErrorWithContext(err, "cannot create pod security policy %q", formatMeta(c.ObjectMeta))
+		return ConvertErrorWithContext(err, "cannot create pod security policy %q", FormatMeta(c.ObjectMeta))
 	}
 	_, err = policies.Update(&c.Policy)
 	return ConvertError(err)
 }
 
+// UpsertAndWait upserts the pod security policy and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *PodSecurityPolicyControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *PodSecurityPolicyControl) Status() error {
 	policies := c.Client.ExtensionsV1beta1().PodSecurityPolicies()
 	_, err := policies.Get(c.Name, metav1.GetOptions{})
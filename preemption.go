@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// schedulingReason returns why the pending pod is not running yet:
+// the reason the scheduler gave for not scheduling it and the node
+// it has been nominated to after preempting lower priority pods.
+// Returns empty string if the pod is not pending or the reason is unknown
+func schedulingReason(pod v1.Pod) string {
+	if pod.Status.Phase != v1.PodPending {
+		return ""
+	}
+	var reasons []string
+	if _, cond := getPodCondition(&pod.Status, v1.PodScheduled); cond != nil && cond.Status != v1.ConditionTrue {
+		reasons = append(reasons, strings.TrimSpace(fmt.Sprintf("%v %v", cond.Reason, cond.Message)))
+	}
+	if pod.Status.NominatedNodeName != "" {
+		reasons = append(reasons, fmt.Sprintf("nominated to node %v, waiting for preemption of lower priority pods",
+			pod.Status.NominatedNodeName))
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	if pod.Spec.PriorityClassName != "" {
+		reasons = append(reasons, fmt.Sprintf("priority class %v (%v)", pod.Spec.PriorityClassName, podPriority(pod)))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// pendingReasons returns the scheduling reasons of the pending pods,
+// e.g. "pod default/app: Unschedulable 0/3 nodes are available: 3 Insufficient cpu."
+func pendingReasons(pods []v1.Pod) []string {
+	var reasons []string
+	for _, pod := range pods {
+		if reason := schedulingReason(pod); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("pod %v: %v", FormatMeta(pod.ObjectMeta), reason))
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// preemptionCauses returns the pods the pod is waiting on to be preempted
+// from the node it has been nominated to
+func preemptionCauses(client *kubernetes.Clientset, pod v1.Pod) ([]string, error) {
+	if pod.Status.NominatedNodeName == "" {
+		return nil, nil
+	}
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", pod.Status.NominatedNodeName).String(),
+	})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	victims := preemptionVictims(pod, pods.Items)
+	if len(victims) == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("pod %v: waiting for preemption of pods %v on node %v",
+		FormatMeta(pod.ObjectMeta), strings.Join(victims, ", "), pod.Status.NominatedNodeName)}, nil
+}
+
+// preemptionVictims returns the names of the pods with lower priority
+// than the preemptor that are being terminated on its nominated node
+func preemptionVictims(preemptor v1.Pod, pods []v1.Pod) []string {
+	priority := podPriority(preemptor)
+	var victims []string
+	for _, pod := range pods {
+		if pod.Spec.NodeName != preemptor.Status.NominatedNodeName || pod.DeletionTimestamp == nil {
+			continue
+		}
+		if podPriority(pod) < priority {
+			victims = append(victims, FormatMeta(pod.ObjectMeta))
+		}
+	}
+	sort.Strings(victims)
+	return victims
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type PreemptionSuite struct{}
+
+var _ = Suite(&PreemptionSuite{})
+
+func (s *PreemptionSuite) TestSchedulingReason(c *C) {
+	high := int32(1000)
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       v1.PodSpec{PriorityClassName: "critical", Priority: &high},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{{
+				Type:    v1.PodScheduled,
+				Status:  v1.ConditionFalse,
+				Reason:  v1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available: 3 Insufficient cpu.",
+			}},
+			NominatedNodeName: "node-1",
+		},
+	}
+	running := pod
+	running.Status = v1.PodStatus{Phase: v1.PodRunning}
+	c.Assert(pendingReasons([]v1.Pod{pod, running}), DeepEquals, []string{
+		"pod default/app: Unschedulable 0/3 nodes are available: 3 Insufficient cpu., " +
+			"nominated to node node-1, waiting for preemption of lower priority pods, priority class critical (1000)",
+	})
+
+	now := metav1.Now()
+	low := int32(10)
+	victim := func(name, node string, priority *int32, deleted *metav1.Time) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "batch", DeletionTimestamp: deleted},
+			Spec:       v1.PodSpec{NodeName: node, Priority: priority},
+		}
+	}
+	c.Assert(preemptionVictims(pod, []v1.Pod{
+		victim("low", "node-1", &low, &now),
+		victim("default", "node-1", nil, &now),
+		victim("running", "node-1", &low, nil),
+		victim("high", "node-1", &high, &now),
+		victim("other-node", "node-2", &low, &now),
+	}), DeepEquals, []string{"batch/default", "batch/low"})
+}
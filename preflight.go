@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// dryRunMinorVersion is the first Kubernetes 1.x minor version
+// with server-side dry-run enabled by default
+const dryRunMinorVersion = 13
+
+// PreflightPodTemplates creates a pod from the template of each workload
+// in the manifest stream with server-side dry-run, so that invalid specs
+// and admission webhook rejections are caught before the workloads are applied.
+// Workloads without namespace are checked in the specified namespace.
+// Workloads in a namespace or with a service account created by the manifest
+// stream itself are skipped until the namespace or service account exists,
+// as their pods would be rejected before the stream is applied.
+// Returns NotImplemented if the API server does not support dry-run
+func PreflightPodTemplates(ctx context.Context, client *kubernetes.Clientset, namespace string, data []byte) error {
+	return trace.Wrap(preflightPodTemplates(ctx, client, namespace, data, data))
+}
+
+// preflightPodTemplates dry-runs the pod templates of the workloads in data,
+// skipping the workloads depending on the resources created by the bundle
+// manifest stream that do not exist yet
+func preflightPodTemplates(ctx context.Context, client *kubernetes.Clientset, namespace string, data, bundle []byte) error {
+	if err := checkDryRunSupported(client); err != nil {
+		return trace.Wrap(err)
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	provided, err := providedResources(bundle, namespace)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var errors []error
+	for _, object := range objects {
+		if err := ctx.Err(); err != nil {
+			return trace.Wrap(err)
+		}
+		pod, err := podFromTemplate(object, namespace)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return trace.Wrap(err)
+		}
+		missing, err := missingDependencies(client, podDependencies(pod, provided))
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if len(missing) != 0 {
+			log.Infof("skip dry-run of %v %v: %v created by the bundle do not exist yet",
+				object.GetKind(), object.GetName(), missing)
+			continue
+		}
+		data, err := pod.MarshalJSON()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		log.Debugf("dry-run pod of %v %v", object.GetKind(), object.GetName())
+		err = client.CoreV1().RESTClient().Post().
+			Namespace(pod.GetNamespace()).
+			Resource("pods").
+			Param("dryRun", "All").
+			SetHeader("Content-Type", "application/json").
+			Body(data).
+			Do().
+			Error()
+		if err != nil {
+			errors = append(errors, ConvertErrorWithContext(err, "%v %v pod template", object.GetKind(), object.GetName()))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// providedResources returns the namespaces and service accounts
+// created by the manifest stream
+func providedResources(data []byte, namespace string) (map[ObjectRef]bool, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	provided := make(map[ObjectRef]bool)
+	for _, object := range objects {
+		switch object.GetKind() {
+		case KindNamespace:
+			provided[ObjectRef{Kind: KindNamespace, Name: object.GetName()}] = true
+		case KindServiceAccount:
+			objectNamespace := object.GetNamespace()
+			if objectNamespace == "" {
+				objectNamespace = Namespace(namespace)
+			}
+			provided[ObjectRef{Kind: KindServiceAccount, Namespace: objectNamespace, Name: object.GetName()}] = true
+		}
+	}
+	return provided, nil
+}
+
+// podDependencies returns the provided resources the pod depends on:
+// its namespace and its service account
+func podDependencies(pod *unstructured.Unstructured, provided map[ObjectRef]bool) []ObjectRef {
+	serviceAccount, _, _ := unstructured.NestedString(pod.Object, "spec", "serviceAccountName")
+	if serviceAccount == "" {
+		serviceAccount, _, _ = unstructured.NestedString(pod.Object, "spec", "serviceAccount")
+	}
+	refs := []ObjectRef{{Kind: KindNamespace, Name: pod.GetNamespace()}}
+	if serviceAccount != "" {
+		refs = append(refs, ObjectRef{Kind: KindServiceAccount, Namespace: pod.GetNamespace(), Name: serviceAccount})
+	}
+	var dependencies []ObjectRef
+	for _, ref := range refs {
+		if provided[ref] {
+			dependencies = append(dependencies, ref)
+		}
+	}
+	return dependencies
+}
+
+// missingDependencies returns the dependencies that do not exist yet
+func missingDependencies(client *kubernetes.Clientset, dependencies []ObjectRef) ([]ObjectRef, error) {
+	var missing []ObjectRef
+	for _, ref := range dependencies {
+		var err error
+		switch ref.Kind {
+		case KindNamespace:
+			_, err = client.CoreV1().Namespaces().Get(ref.Name, metav1.GetOptions{})
+		case KindServiceAccount:
+			_, err = client.CoreV1().ServiceAccounts(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		}
+		err = ConvertError(err)
+		if err != nil && !trace.IsNotFound(err) {
+			return nil, trace.Wrap(err)
+		}
+		if err != nil {
+			missing = append(missing, ref)
+		}
+	}
+	return missing, nil
+}
+
+// podFromTemplate returns a pod with the metadata and spec of the workload pod template.
+// The spec is copied as is so fields unknown to the client types are validated too.
+// Returns NotFound if the object does not have a pod template
+func podFromTemplate(object *unstructured.Unstructured, namespace string) (*unstructured.Unstructured, error) {
+	path := podSpecPath(object.GetKind())
+	if path == nil {
+		return nil, trace.NotFound("%v %v has no pod template", object.GetKind(), object.GetName())
+	}
+	spec, found, err := unstructured.NestedMap(object.Object, path...)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if !found {
+		return nil, trace.NotFound("%v %v has no pod template", object.GetKind(), object.GetName())
+	}
+	pod := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	pod.SetKind(KindPod)
+	pod.SetAPIVersion(V1)
+	if object.GetKind() == KindPod {
+		pod.SetLabels(object.GetLabels())
+		pod.SetAnnotations(object.GetAnnotations())
+	} else {
+		metaPath := append(append([]string(nil), path[:len(path)-1]...), "metadata")
+		labels, _, err := unstructured.NestedStringMap(object.Object, append(metaPath, "labels")...)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		annotations, _, err := unstructured.NestedStringMap(object.Object, append(metaPath, "annotations")...)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		pod.SetLabels(labels)
+		pod.SetAnnotations(annotations)
+	}
+	pod.SetGenerateName(object.GetName() + "-preflight-")
+	podNamespace := object.GetNamespace()
+	if podNamespace == "" {
+		podNamespace = Namespace(namespace)
+	}
+	pod.SetNamespace(podNamespace)
+	return pod, nil
+}
+
+// checkDryRunSupported returns NotImplemented if the API server is older
+// than the first version with server-side dry-run enabled by default.
+// Older servers ignore the dryRun parameter and would create the objects
+func checkDryRunSupported(client *kubernetes.Clientset) error {
+	return trace.Wrap(checkServerMinorVersion(client, dryRunMinorVersion, "dry-run"))
+}
+
+// checkServerMinorVersion returns NotImplemented if the API server is older than 1.minor
+func checkServerMinorVersion(client *kubernetes.Clientset, minorVersion int, feature string) error {
+	info, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return ConvertError(err)
+	}
+	minor, err := parseMinorVersion(*info)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if info.Major != "1" || minor < minorVersion {
+		return trace.NotImplemented("server version %v does not support %v", info.GitVersion, feature)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type PreflightSuite struct{}
+
+var _ = Suite(&PreflightSuite{})
+
+func (s *PreflightSuite) TestPodDependencies(c *C) {
+	provided, err := providedResources([]byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: api
+  namespace: apps
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: agent
+`), "")
+	c.Assert(err, IsNil)
+	c.Assert(provided, DeepEquals, map[ObjectRef]bool{
+		{Kind: KindNamespace, Name: "apps"}:                                    true,
+		{Kind: KindServiceAccount, Namespace: "apps", Name: "api"}:             true,
+		{Kind: KindServiceAccount, Namespace: DefaultNamespace, Name: "agent"}: true,
+	})
+
+	objects, err := DecodeObjects([]byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: apps
+spec:
+  template:
+    spec:
+      serviceAccountName: api
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      serviceAccountName: agent
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: web
+spec:
+  template:
+    spec:
+      serviceAccountName: api
+`))
+	c.Assert(err, IsNil)
+	expected := [][]ObjectRef{
+		{{Kind: KindNamespace, Name: "apps"}, {Kind: KindServiceAccount, Namespace: "apps", Name: "api"}},
+		{{Kind: KindServiceAccount, Namespace: DefaultNamespace, Name: "agent"}},
+		nil,
+	}
+	for i, object := range objects {
+		pod, err := podFromTemplate(object, "")
+		c.Assert(err, IsNil)
+		c.Assert(podDependencies(pod, provided), DeepEquals, expected[i], Commentf("test case %v", i+1))
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// DefaultDNSNamespace is the namespace of the cluster DNS service
+	DefaultDNSNamespace = metav1.NamespaceSystem
+	// DefaultDNSService is the name of the cluster DNS service,
+	// used by both kube-dns and CoreDNS deployments
+	DefaultDNSService = "kube-dns"
+	// DefaultMinReadyNodes is the default number of nodes
+	// required to be ready by the cluster prerequisites
+	DefaultMinReadyNodes = 1
+	// defaultServiceAccount is the service account created in every namespace
+	defaultServiceAccount = "default"
+)
+
+// ClusterPrereqsConfig is a cluster prerequisites checker configuration
+type ClusterPrereqsConfig struct {
+	// Client is the core API client
+	Client corev1.CoreV1Interface
+	// DNSNamespace is the namespace of the DNS service, defaults to DefaultDNSNamespace
+	DNSNamespace string
+	// DNSService is the name of the DNS service, defaults to DefaultDNSService
+	DNSService string
+	// Namespace is the namespace required to have the default service account,
+	// defaults to the default namespace
+	Namespace string
+	// MinReadyNodes is the number of nodes required to be ready,
+	// defaults to DefaultMinReadyNodes
+	MinReadyNodes int
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *ClusterPrereqsConfig) CheckAndSetDefaults() error {
+	if c.Client == nil {
+		return trace.BadParameter("missing parameter Client")
+	}
+	if c.MinReadyNodes < 0 {
+		return trace.BadParameter("MinReadyNodes should not be negative, got %v", c.MinReadyNodes)
+	}
+	if c.DNSNamespace == "" {
+		c.DNSNamespace = DefaultDNSNamespace
+	}
+	if c.DNSService == "" {
+		c.DNSService = DefaultDNSService
+	}
+	c.Namespace = Namespace(c.Namespace)
+	if c.MinReadyNodes == 0 {
+		c.MinReadyNodes = DefaultMinReadyNodes
+	}
+	return nil
+}
+
+// NewClusterPrereqs returns a new cluster prerequisites checker
+func NewClusterPrereqs(config ClusterPrereqsConfig) (*ClusterPrereqs, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &ClusterPrereqs{
+		ClusterPrereqsConfig: config,
+		Entry:                log.WithField("prereqs", config.Namespace),
+	}, nil
+}
+
+// ClusterPrereqs checks that a freshly bootstrapped cluster is able to run
+// the workloads and hook jobs of a changeset: the DNS service has ready endpoints,
+// the default service account has been created and enough nodes are ready.
+// Use it with PollStatus to wait for the cluster before applying a changeset
+type ClusterPrereqs struct {
+	ClusterPrereqsConfig
+	*log.Entry
+}
+
+// Status returns nil if all prerequisites are met
+func (p *ClusterPrereqs) Status() error {
+	var errors []error
+	for _, check := range []func() error{p.checkDNS, p.checkServiceAccount, p.checkNodes} {
+		if err := check(); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// checkDNS returns an error if the DNS service has no ready endpoints
+func (p *ClusterPrereqs) checkDNS() error {
+	name := p.DNSNamespace + "/" + p.DNSService
+	_, err := p.Client.Services(p.DNSNamespace).Get(p.DNSService, metav1.GetOptions{})
+	if err != nil {
+		return ConvertErrorWithContext(err, "DNS service %v", name)
+	}
+	endpoints, err := p.Client.Endpoints(p.DNSNamespace).Get(p.DNSService, metav1.GetOptions{})
+	if err != nil {
+		return ConvertErrorWithContext(err, "DNS service %v endpoints", name)
+	}
+	if readyAddresses(endpoints) == 0 {
+		return trace.CompareFailed("DNS service %v has no ready endpoints", name)
+	}
+	return nil
+}
+
+// checkServiceAccount returns an error if the default service account
+// has not been created in the namespace yet
+func (p *ClusterPrereqs) checkServiceAccount() error {
+	_, err := p.Client.ServiceAccounts(p.Namespace).Get(defaultServiceAccount, metav1.GetOptions{})
+	return ConvertErrorWithContext(err, "service account %v/%v", p.Namespace, defaultServiceAccount)
+}
+
+// checkNodes returns an error if fewer nodes than required are ready
+func (p *ClusterPrereqs) checkNodes() error {
+	nodes, err := p.Client.Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return ConvertError(err)
+	}
+	var ready int
+	for _, node := range nodes.Items {
+		if checkNodeReady(node) == nil {
+			ready++
+		}
+	}
+	if ready < p.MinReadyNodes {
+		return trace.CompareFailed("%v of %v nodes are ready, expected at least %v",
+			ready, len(nodes.Items), p.MinReadyNodes)
+	}
+	return nil
+}
+
+// readyAddresses returns the number of ready addresses of the endpoints
+func readyAddresses(endpoints *v1.Endpoints) int {
+	var count int
+	for _, subset := range endpoints.Subsets {
+		count += len(subset.Addresses)
+	}
+	return count
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+type PrereqsSuite struct{}
+
+var _ = Suite(&PrereqsSuite{})
+
+func (s *PrereqsSuite) TestClusterPrereqs(c *C) {
+	core := &memCore{objects: make(map[string]interface{})}
+	prereqs, err := NewClusterPrereqs(ClusterPrereqsConfig{Client: core, MinReadyNodes: 2})
+	c.Assert(err, IsNil)
+
+	err = prereqs.Status()
+	c.Assert(err, NotNil)
+	for _, message := range []string{
+		"DNS service kube-system/kube-dns",
+		"service account default/default",
+		"0 of 0 nodes are ready, expected at least 2",
+	} {
+		c.Assert(strings.Contains(err.Error(), message), Equals, true, Commentf("%v", err))
+	}
+
+	core.objects["services/kube-system/kube-dns"] = &v1.Service{}
+	core.objects["endpoints/kube-system/kube-dns"] = &v1.Endpoints{Subsets: []v1.EndpointSubset{{
+		NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.10"}},
+	}}}
+	core.objects["serviceaccounts/default/default"] = &v1.ServiceAccount{}
+	ready := v1.Node{Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}}}
+	core.nodes = []v1.Node{ready, {}}
+	err = prereqs.Status()
+	c.Assert(err, ErrorMatches, `.*DNS service kube-system/kube-dns has no ready endpoints.*1 of 2 nodes are ready.*`)
+
+	core.objects["endpoints/kube-system/kube-dns"] = &v1.Endpoints{Subsets: []v1.EndpointSubset{{
+		Addresses: []v1.EndpointAddress{{IP: "10.0.0.10"}},
+	}}}
+	core.nodes = []v1.Node{ready, ready}
+	c.Assert(prereqs.Status(), IsNil)
+}
+
+// memCore is an in-memory core API client serving the objects
+// read by the cluster prerequisites checks
+type memCore struct {
+	corev1.CoreV1Interface
+	// objects maps resource/namespace/name to the object
+	objects map[string]interface{}
+	nodes   []v1.Node
+}
+
+func (m *memCore) get(resource, namespace, name string) (interface{}, error) {
+	object, ok := m.objects[resource+"/"+namespace+"/"+name]
+	if !ok {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: resource}, name)
+	}
+	return object, nil
+}
+
+func (m *memCore) Services(namespace string) corev1.ServiceInterface {
+	return memServices{core: m, namespace: namespace}
+}
+
+func (m *memCore) Endpoints(namespace string) corev1.EndpointsInterface {
+	return memEndpoints{core: m, namespace: namespace}
+}
+
+func (m *memCore) ServiceAccounts(namespace string) corev1.ServiceAccountInterface {
+	return memServiceAccounts{core: m, namespace: namespace}
+}
+
+func (m *memCore) Nodes() corev1.NodeInterface {
+	return memNodes{core: m}
+}
+
+type memServices struct {
+	corev1.ServiceInterface
+	core      *memCore
+	namespace string
+}
+
+func (m memServices) Get(name string, options metav1.GetOptions) (*v1.Service, error) {
+	object, err := m.core.get("services", m.namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return object.(*v1.Service), nil
+}
+
+type memEndpoints struct {
+	corev1.EndpointsInterface
+	core      *memCore
+	namespace string
+}
+
+func (m memEndpoints) Get(name string, options metav1.GetOptions) (*v1.Endpoints, error) {
+	object, err := m.core.get("endpoints", m.namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return object.(*v1.Endpoints), nil
+}
+
+type memServiceAccounts struct {
+	corev1.ServiceAccountInterface
+	core      *memCore
+	namespace string
+}
+
+func (m memServiceAccounts) Get(name string, options metav1.GetOptions) (*v1.ServiceAccount, error) {
+	object, err := m.core.get("serviceaccounts", m.namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	return object.(*v1.ServiceAccount), nil
+}
+
+type memNodes struct {
+	corev1.NodeInterface
+	core *memCore
+}
+
+func (m memNodes) List(options metav1.ListOptions) (*v1.NodeList, error) {
+	return &v1.NodeList{Items: m.core.nodes}, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DefaultImagePullSecretName is the default name of the propagated image pull secret
+const DefaultImagePullSecretName = "rigging-registry"
+
+// ImagePullSecret is a transformer that propagates private registry credentials
+// across a manifest stream: it adds a docker registry secret to every namespace
+// with resources in the stream and attaches it to all service accounts and pod templates
+type ImagePullSecret struct {
+	// Name is the secret name, DefaultImagePullSecretName if unset
+	Name string
+	// DockerConfig is the docker config JSON with the registry credentials
+	DockerConfig []byte
+}
+
+// NewImagePullSecret returns an image pull secret with the credentials of the registry server
+func NewImagePullSecret(name, server, username, password string) (*ImagePullSecret, error) {
+	if server == "" {
+		return nil, trace.BadParameter("missing parameter server")
+	}
+	if username == "" {
+		return nil, trace.BadParameter("missing parameter username")
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	config, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{
+			server: map[string]string{
+				"username": username,
+				"password": password,
+				"auth":     auth,
+			},
+		},
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &ImagePullSecret{Name: name, DockerConfig: config}, nil
+}
+
+// Transform adds the secret before the first resource of each namespace
+// and references it from service accounts and pod templates
+func (s ImagePullSecret) Transform(data []byte) ([]byte, error) {
+	if len(s.DockerConfig) == 0 {
+		return nil, trace.BadParameter("missing parameter DockerConfig")
+	}
+	name := s.name()
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		if object.GetKind() == KindSecret && object.GetName() == name {
+			seen[Namespace(object.GetNamespace())] = true
+		}
+	}
+	out := make([]*unstructured.Unstructured, 0, len(objects))
+	for _, object := range objects {
+		if !IsClusterScoped(object.GetKind()) {
+			namespace := Namespace(object.GetNamespace())
+			if !seen[namespace] {
+				secret, err := s.secret(namespace)
+				if err != nil {
+					return nil, trace.Wrap(err)
+				}
+				out = append(out, secret)
+				seen[namespace] = true
+			}
+		}
+		if err := s.attach(object); err != nil {
+			return nil, trace.Wrap(err, "failed to transform %v %v", object.GetKind(), object.GetName())
+		}
+		out = append(out, object)
+	}
+	return EncodeObjects(out)
+}
+
+// attach references the secret from the service account or the pod template of the object
+func (s ImagePullSecret) attach(object *unstructured.Unstructured) error {
+	name := s.name()
+	if object.GetKind() == KindServiceAccount {
+		secrets, _, err := unstructured.NestedSlice(object.Object, "imagePullSecrets")
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		for _, secret := range secrets {
+			if ref, ok := secret.(map[string]interface{}); ok && ref["name"] == name {
+				return nil
+			}
+		}
+		secrets = append(secrets, map[string]interface{}{"name": name})
+		return trace.Wrap(unstructured.SetNestedSlice(object.Object, secrets, "imagePullSecrets"))
+	}
+	spec, err := GetPodSpec(object)
+	if err != nil {
+		if trace.IsNotFound(err) {
+			return nil
+		}
+		return trace.Wrap(err)
+	}
+	for _, ref := range spec.ImagePullSecrets {
+		if ref.Name == name {
+			return nil
+		}
+	}
+	spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1.LocalObjectReference{Name: name})
+	return trace.Wrap(SetPodSpec(object, *spec))
+}
+
+// secret returns the docker registry secret in the namespace
+func (s ImagePullSecret) secret(namespace string) (*unstructured.Unstructured, error) {
+	secret := &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: APIVersionFor(KindSecret),
+			Kind:       KindSecret,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name(),
+			Namespace: namespace,
+		},
+		Type: v1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			v1.DockerConfigJsonKey: s.DockerConfig,
+		},
+	}
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &unstructured.Unstructured{Object: fields}, nil
+}
+
+func (s ImagePullSecret) name() string {
+	if s.Name == "" {
+		return DefaultImagePullSecretName
+	}
+	return s.Name
+}
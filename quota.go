@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// DefaultQuotaWait is the default duration creations rejected
+	// because of an exhausted resource quota are retried
+	DefaultQuotaWait = 5 * time.Minute
+	// DefaultQuotaRetryPeriod is the period of the retries of creations
+	// rejected because of an exhausted resource quota
+	DefaultQuotaRetryPeriod = 10 * time.Second
+)
+
+// IsQuotaExceeded returns true if the error is the API server rejecting
+// a request because it would exceed a resource quota of the namespace
+func IsQuotaExceeded(err error) bool {
+	if err == nil {
+		return false
+	}
+	err = trace.Unwrap(err)
+	if statusErr, ok := err.(*errors.StatusError); ok {
+		return errors.IsForbidden(statusErr) && isQuotaMessage(statusErr.ErrStatus.Message)
+	}
+	return trace.IsAccessDenied(err) && isQuotaMessage(err.Error())
+}
+
+// isQuotaMessage returns true if the message is the quota admission
+// rejection, e.g. "exceeded quota: compute, requested: pods=1, used: pods=10, limited: pods=10"
+func isQuotaMessage(message string) bool {
+	return strings.Contains(message, "exceeded quota")
+}
+
+// deferredResource is a resource whose creation was rejected
+// because of an exhausted resource quota
+type deferredResource struct {
+	outcome ResourceOutcome
+	data    []byte
+	err     error
+}
+
+// applyDeferred retries the deferred resources in order until all of them
+// are applied, the quota wait elapses or the context is cancelled.
+// The error of each resource is updated with the result of the last attempt
+func (cs *Changeset) applyDeferred(ctx context.Context, changesetNamespace, changesetName string, resources []*deferredResource) {
+	ticker := time.NewTicker(DefaultQuotaRetryPeriod)
+	defer ticker.Stop()
+	deadline := time.Now().Add(cs.FailurePolicy.QuotaWait)
+	pending := resources
+	for len(pending) != 0 {
+		var refs []string
+		for _, resource := range pending {
+			refs = append(refs, resource.outcome.Ref.String())
+		}
+		log.Infof("%v of %v resources are waiting for resource quota: %v",
+			len(pending), len(resources), strings.Join(refs, ", "))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, resource := range pending {
+				resource.err = trace.Wrap(ctx.Err(), "cancelled while waiting for resource quota: %v", resource.err)
+			}
+			return
+		}
+		var remaining []*deferredResource
+		for _, resource := range pending {
+			resource.err = cs.upsertResource(ctx, changesetNamespace, changesetName, resource.data)
+			if IsQuotaExceeded(resource.err) {
+				remaining = append(remaining, resource)
+				continue
+			}
+			if resource.err == nil {
+				log.Infof("%v applied after waiting for resource quota", resource.outcome.Ref)
+			}
+		}
+		pending = remaining
+		if len(pending) != 0 && time.Now().After(deadline) {
+			for _, resource := range pending {
+				resource.err = trace.LimitExceeded("resource quota is still exhausted after %v: %v",
+					cs.FailurePolicy.QuotaWait, resource.err)
+			}
+			return
+		}
+	}
+}
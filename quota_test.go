@@ -0,0 +1,51 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type QuotaSuite struct{}
+
+var _ = Suite(&QuotaSuite{})
+
+func (s *QuotaSuite) TestIsQuotaExceeded(c *C) {
+	pods := schema.GroupResource{Resource: "pods"}
+	quotaErr := errors.NewForbidden(pods, "api", fmt.Errorf("exceeded quota: compute, requested: pods=1, used: pods=10, limited: pods=10"))
+	rbacErr := errors.NewForbidden(pods, "api", fmt.Errorf("User \"bob\" cannot create pods in the namespace \"apps\""))
+	tcs := []struct {
+		err      error
+		exceeded bool
+	}{
+		{err: nil},
+		{err: quotaErr, exceeded: true},
+		{err: ConvertError(quotaErr), exceeded: true},
+		{err: trace.Wrap(ConvertError(quotaErr)), exceeded: true},
+		{err: rbacErr},
+		{err: ConvertError(rbacErr)},
+		{err: trace.BadParameter("exceeded quota")},
+	}
+	for i, tc := range tcs {
+		c.Assert(IsQuotaExceeded(tc.err), Equals, tc.exceeded, Commentf("test case %v", i+1))
+	}
+}
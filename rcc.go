@@ -24,6 +24,7 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -60,6 +61,8 @@ type RCConfig struct {
 	ReplicationController *v1.ReplicationController
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *RCConfig) CheckAndSetDefaults() error {
@@ -89,15 +92,12 @@ func (c *RCControl) collectPods(replicationController *v1.ReplicationController)
 	pods, err := CollectPods(replicationController.Namespace, set, c.Entry, c.Client, func(ref metav1.OwnerReference) bool {
 		return ref.Kind == KindReplicationController && ref.UID == replicationController.UID
 	})
-	var podList []v1.Pod
-	for _, pod := range pods {
-		podList = append(podList, pod)
-	}
-	return podList, trace.Wrap(err)
+	return pods, trace.Wrap(err)
 }
 
 func (c *RCControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.replicationController.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.replicationController.ObjectMeta))
 
 	rcs := c.Client.Core().ReplicationControllers(c.replicationController.Namespace)
 	currentRC, err := rcs.Get(c.replicationController.Name, metav1.GetOptions{})
@@ -129,12 +129,13 @@ func (c *RCControl) Delete(ctx context.Context, cascade bool) error {
 	if !cascade {
 		c.Info("cascade not set, returning")
 	}
-	err = deletePodsList(pods, currentPods, *c.Entry)
+	err = deletePods(ctx, pods, currentPods, *c.Entry)
 	return trace.Wrap(err)
 }
 
 func (c *RCControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.replicationController.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.replicationController.ObjectMeta))
 
 	rcs := c.Client.Core().ReplicationControllers(c.replicationController.Namespace)
 	currentRC, err := rcs.Get(c.replicationController.Name, metav1.GetOptions{})
@@ -161,7 +162,14 @@ func (c *RCControl) Upsert(ctx context.Context) error {
 	c.replicationController.SelfLink = ""
 	c.replicationController.ResourceVersion = ""
 
-	err = withExponentialBackoff(func() error {
+	var oldUID types.UID
+	if currentRC != nil {
+		oldUID = currentRC.UID
+	}
+	err = recreateObject(oldUID, func() (metav1.Object, error) {
+		rc, err := rcs.Get(c.replicationController.Name, metav1.GetOptions{})
+		return rc, ConvertError(err)
+	}, func() error {
 		_, err = rcs.Create(&c.replicationController)
 		return ConvertError(err)
 	})
@@ -176,6 +184,12 @@ func (c *RCControl) nodeSelector() labels.Selector {
 	return set.AsSelector()
 }
 
+// UpsertAndWait upserts the replication controller and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *RCControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *RCControl) Status() error {
 	rcs := c.Client.Core().ReplicationControllers(c.replicationController.Namespace)
 	currentRC, err := rcs.Get(c.replicationController.Name, metav1.GetOptions{})
@@ -0,0 +1,175 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ReadinessFunc returns true if the object is ready.
+// Returned errors are treated as not ready and the check is retried
+type ReadinessFunc func(object *unstructured.Unstructured) (bool, error)
+
+// RegisterReadiness registers the readiness evaluator of resources of the
+// specified group, version and kind, replacing a previously registered one.
+// An empty version registers the evaluator for all versions of the kind,
+// evaluators of specific versions take precedence
+func RegisterReadiness(gvk schema.GroupVersionKind, fn ReadinessFunc) {
+	readinessMu.Lock()
+	defer readinessMu.Unlock()
+	readinessEvaluators[gvk] = fn
+}
+
+// getReadiness returns the readiness evaluator of the group, version and kind
+func getReadiness(gvk schema.GroupVersionKind) (ReadinessFunc, bool) {
+	readinessMu.RLock()
+	defer readinessMu.RUnlock()
+	if fn, ok := readinessEvaluators[gvk]; ok {
+		return fn, true
+	}
+	fn, ok := readinessEvaluators[schema.GroupVersionKind{Group: gvk.Group, Kind: gvk.Kind}]
+	return fn, ok
+}
+
+var (
+	readinessMu sync.RWMutex
+	// readinessEvaluators maps resource group, version and kind to readiness evaluators
+	readinessEvaluators = map[schema.GroupVersionKind]ReadinessFunc{
+		{Group: "cert-manager.io", Kind: "Certificate"}:      conditionReady,
+		{Group: "cert-manager.io", Kind: "Issuer"}:           conditionReady,
+		{Group: "cert-manager.io", Kind: "ClusterIssuer"}:    conditionReady,
+		{Group: "certmanager.k8s.io", Kind: "Certificate"}:   conditionReady,
+		{Group: "certmanager.k8s.io", Kind: "Issuer"}:        conditionReady,
+		{Group: "certmanager.k8s.io", Kind: "ClusterIssuer"}: conditionReady,
+
+		// Gateway API, see mesh.go
+		{Group: GatewayAPIGroup, Kind: "GatewayClass"}: gatewayClassAccepted,
+		{Group: GatewayAPIGroup, Kind: "Gateway"}:      gatewayProgrammed,
+		{Group: GatewayAPIGroup, Kind: "HTTPRoute"}:    routeAccepted,
+		{Group: GatewayAPIGroup, Kind: "GRPCRoute"}:    routeAccepted,
+		{Group: GatewayAPIGroup, Kind: "TLSRoute"}:     routeAccepted,
+		{Group: GatewayAPIGroup, Kind: "TCPRoute"}:     routeAccepted,
+		{Group: GatewayAPIGroup, Kind: "UDPRoute"}:     routeAccepted,
+
+		// Istio, see mesh.go
+		{Group: IstioNetworkingGroup, Kind: "VirtualService"}:      istioValidated,
+		{Group: IstioNetworkingGroup, Kind: "DestinationRule"}:     istioValidated,
+		{Group: IstioNetworkingGroup, Kind: "Gateway"}:             istioValidated,
+		{Group: IstioNetworkingGroup, Kind: "ServiceEntry"}:        istioValidated,
+		{Group: IstioNetworkingGroup, Kind: "Sidecar"}:             istioValidated,
+		{Group: IstioSecurityGroup, Kind: "PeerAuthentication"}:    istioValidated,
+		{Group: IstioSecurityGroup, Kind: "AuthorizationPolicy"}:   istioValidated,
+		{Group: IstioSecurityGroup, Kind: "RequestAuthentication"}: istioValidated,
+	}
+)
+
+// conditionReady returns true if the object has an up to date Ready condition with status True
+func conditionReady(object *unstructured.Unstructured) (bool, error) {
+	if generation := object.GetGeneration(); generation != 0 {
+		observed, found, err := unstructured.NestedInt64(object.Object, "status", "observedGeneration")
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		if found && observed < generation {
+			return false, nil
+		}
+	}
+	conditions, _, err := unstructured.NestedSlice(object.Object, "status", "conditions")
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		if condition["status"] == "True" {
+			return true, nil
+		}
+		return false, trace.CompareFailed("%v %v is not ready: %v: %v",
+			object.GetKind(), object.GetName(), condition["reason"], condition["message"])
+	}
+	return false, nil
+}
+
+// NewObjectReporter returns a status reporter of the referenced resource of any kind
+func NewObjectReporter(ref ObjectRef) *ObjectReporter {
+	return &ObjectReporter{
+		Ref: ref,
+		Entry: log.WithFields(log.Fields{
+			"object": ref.String(),
+		}),
+	}
+}
+
+// ObjectReporter reports the readiness of a resource of any kind.
+// Resources of kinds with a registered readiness evaluator are ready
+// once the evaluator says so, other resources are ready once they exist
+type ObjectReporter struct {
+	// Ref references the resource
+	Ref ObjectRef
+	// Objects reads the live state of the resource, defaults to kubectl
+	Objects ObjectInterface
+	*log.Entry
+}
+
+// Status returns nil if the resource is ready
+func (r *ObjectReporter) Status() error {
+	objects := r.Objects
+	if objects == nil {
+		objects = KubectlObjects{}
+	}
+	object, err := objects.Get(context.TODO(), r.Ref)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	evaluate, ok := getReadiness(object.GroupVersionKind())
+	if !ok {
+		return nil
+	}
+	ready, err := evaluate(object)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if !ready {
+		return trace.CompareFailed("%v is not ready", r.Ref)
+	}
+	return nil
+}
+
+// WaitReady waits for all resources of the bundle to become ready, see ObjectReporter
+func WaitReady(ctx context.Context, bundle *Bundle, retryAttempts int, retryPeriod time.Duration) error {
+	return waitReady(ctx, KubectlObjects{}, bundle, retryAttempts, retryPeriod)
+}
+
+func waitReady(ctx context.Context, objects ObjectInterface, bundle *Bundle, retryAttempts int, retryPeriod time.Duration) error {
+	for _, ref := range bundle.Refs() {
+		reporter := NewObjectReporter(ref)
+		reporter.Objects = objects
+		if err := PollStatus(ctx, retryAttempts, retryPeriod, reporter); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
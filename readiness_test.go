@@ -0,0 +1,171 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type ReadinessSuite struct{}
+
+var _ = Suite(&ReadinessSuite{})
+
+func (s *ReadinessSuite) TestRegisterReadiness(c *C) {
+	allVersions := func(*unstructured.Unstructured) (bool, error) { return false, nil }
+	v2 := func(*unstructured.Unstructured) (bool, error) { return true, nil }
+	RegisterReadiness(schema.GroupVersionKind{Group: "example.com", Kind: "Widget"}, allVersions)
+	RegisterReadiness(schema.GroupVersionKind{Group: "example.com", Version: "v2", Kind: "Widget"}, v2)
+
+	fn, ok := getReadiness(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+	c.Assert(ok, Equals, true)
+	ready, _ := fn(nil)
+	c.Assert(ready, Equals, false)
+
+	fn, ok = getReadiness(schema.GroupVersionKind{Group: "example.com", Version: "v2", Kind: "Widget"})
+	c.Assert(ok, Equals, true)
+	ready, _ = fn(nil)
+	c.Assert(ready, Equals, true)
+
+	_, ok = getReadiness(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Gadget"})
+	c.Assert(ok, Equals, false)
+}
+
+func (s *ReadinessSuite) TestConditionReady(c *C) {
+	objects, err := DecodeObjects([]byte(`
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: tls
+  generation: 2
+status:
+  observedGeneration: 2
+  conditions:
+  - type: Ready
+    status: "False"
+    reason: Pending
+    message: Issuing certificate
+`))
+	c.Assert(err, IsNil)
+	fn, ok := getReadiness(objects[0].GroupVersionKind())
+	c.Assert(ok, Equals, true)
+	ready, err := fn(objects[0])
+	c.Assert(ready, Equals, false)
+	c.Assert(trace.IsCompareFailed(err), Equals, true)
+
+	conditions, _, _ := unstructured.NestedSlice(objects[0].Object, "status", "conditions")
+	conditions[0].(map[string]interface{})["status"] = "True"
+	c.Assert(unstructured.SetNestedSlice(objects[0].Object, conditions, "status", "conditions"), IsNil)
+	ready, err = fn(objects[0])
+	c.Assert(err, IsNil)
+	c.Assert(ready, Equals, true)
+}
+
+func (s *ReadinessSuite) TestRecentRestarts(c *C) {
+	pod := func(restarts int32, finished time.Time) v1.Pod {
+		return v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Status: v1.PodStatus{
+				ContainerStatuses: []v1.ContainerStatus{{
+					Name:         "app",
+					RestartCount: restarts,
+					LastTerminationState: v1.ContainerState{
+						Terminated: &v1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finished)},
+					},
+				}},
+			},
+		}
+	}
+	now := time.Now()
+	c.Assert(checkRecentRestarts([]v1.Pod{pod(0, time.Time{})}, time.Minute), IsNil)
+	c.Assert(checkRecentRestarts([]v1.Pod{pod(3, now.Add(-time.Hour))}, time.Minute), IsNil)
+	err := checkRecentRestarts([]v1.Pod{pod(3, now.Add(-10*time.Second))}, time.Minute)
+	c.Assert(trace.IsCompareFailed(err), Equals, true)
+	c.Assert(checkRecentRestarts([]v1.Pod{pod(3, now)}, 0), IsNil)
+}
+
+func (s *ReadinessSuite) TestMonitoringReadiness(c *C) {
+	RegisterMonitoringReadiness()
+	objects, err := DecodeObjects([]byte(`
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: app
+---
+apiVersion: monitoring.coreos.com/v1
+kind: Prometheus
+metadata:
+  name: k8s
+  generation: 3
+spec:
+  replicas: 2
+status:
+  conditions:
+  - type: Available
+    status: "True"
+  - type: Reconciled
+    status: "True"
+    observedGeneration: 2
+---
+apiVersion: monitoring.coreos.com/v1
+kind: Alertmanager
+metadata:
+  name: main
+spec:
+  replicas: 3
+status:
+  updatedReplicas: 3
+  availableReplicas: 2
+  unavailableReplicas: 1
+`))
+	c.Assert(err, IsNil)
+	ready := func(object *unstructured.Unstructured) (bool, error) {
+		fn, ok := getReadiness(object.GroupVersionKind())
+		c.Assert(ok, Equals, true)
+		return fn(object)
+	}
+	serviceMonitor, prometheus, alertmanager := objects[0], objects[1], objects[2]
+
+	ok, err := ready(serviceMonitor)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	ok, err = ready(prometheus)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false, Commentf("generation 3 is not reconciled"))
+	prometheus.SetGeneration(2)
+	ok, err = ready(prometheus)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(unstructured.SetNestedField(prometheus.Object, true, "spec", "paused"), IsNil)
+	_, err = ready(prometheus)
+	c.Assert(trace.IsCompareFailed(err), Equals, true)
+
+	_, err = ready(alertmanager)
+	c.Assert(trace.IsCompareFailed(err), Equals, true)
+	c.Assert(unstructured.SetNestedField(alertmanager.Object, int64(3), "status", "availableReplicas"), IsNil)
+	c.Assert(unstructured.SetNestedField(alertmanager.Object, int64(0), "status", "unavailableReplicas"), IsNil)
+	ok, err = ready(alertmanager)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+}
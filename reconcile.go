@@ -0,0 +1,215 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// DefaultReconcileInterval is the default period between drift checks
+	DefaultReconcileInterval = 30 * time.Second
+	// DefaultReconcileQPS is the default rate of re-applied resources per second
+	DefaultReconcileQPS = 1
+	// DefaultReconcileBurst is the default burst of re-applied resources
+	DefaultReconcileBurst = 5
+	// DefaultReconcileMaxBackoff is the maximum delay before retrying
+	// to re-apply a resource that failed to apply
+	DefaultReconcileMaxBackoff = 5 * time.Minute
+)
+
+// ReconcilerConfig is a reconciler configuration
+type ReconcilerConfig struct {
+	// Bundle is the desired state
+	Bundle *Bundle
+	// Interval is the period between drift checks
+	Interval time.Duration
+	// QPS limits the rate of re-applied resources
+	QPS float32
+	// Burst is the maximum burst of re-applied resources
+	Burst int
+	// MaxBackoff is the maximum delay before retrying a failed resource
+	MaxBackoff time.Duration
+	// Objects reads the live state of resources, defaults to kubectl
+	Objects ObjectInterface
+	// Apply applies the desired state of the resource,
+	// defaults to Objects.Apply
+	Apply func(ctx context.Context, object *unstructured.Unstructured) error
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *ReconcilerConfig) CheckAndSetDefaults() error {
+	if c.Bundle == nil {
+		return trace.BadParameter("missing parameter Bundle")
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultReconcileInterval
+	}
+	if c.QPS == 0 {
+		c.QPS = DefaultReconcileQPS
+	}
+	if c.Burst == 0 {
+		c.Burst = DefaultReconcileBurst
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultReconcileMaxBackoff
+	}
+	if c.Objects == nil {
+		c.Objects = KubectlObjects{}
+	}
+	if c.Apply == nil {
+		c.Apply = c.Objects.Apply
+	}
+	return nil
+}
+
+// NewReconciler returns a new reconciler of the bundle
+func NewReconciler(config ReconcilerConfig) (*Reconciler, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	objects := make(map[string]*unstructured.Unstructured, len(config.Bundle.Objects))
+	for _, object := range config.Bundle.Objects {
+		// apply the resources to the namespace of the bundle
+		// when the manifests do not set it
+		ref := config.Bundle.Ref(object)
+		object = object.DeepCopy()
+		object.SetNamespace(ref.Namespace)
+		objects[ref.key()] = object
+	}
+	return &Reconciler{
+		ReconcilerConfig: config,
+		Entry: log.WithFields(log.Fields{
+			"bundle": config.Bundle.Name,
+		}),
+		objects: objects,
+		queue:   newRefQueue(),
+		limiter: rate.NewLimiter(rate.Limit(config.QPS), config.Burst),
+		backoff: flowcontrol.NewBackOff(config.Interval, config.MaxBackoff),
+	}, nil
+}
+
+// Reconciler periodically compares the bundle against the live cluster
+// and re-applies the desired state of resources that have drifted
+// or have been deleted
+type Reconciler struct {
+	ReconcilerConfig
+	*log.Entry
+	objects map[string]*unstructured.Unstructured
+	queue   *refQueue
+	limiter *rate.Limiter
+	backoff *flowcontrol.Backoff
+}
+
+// Run reconciles the bundle until the context is cancelled
+func (r *Reconciler) Run(ctx context.Context) error {
+	r.Infof("start reconciling %v resources every %v", len(r.objects), r.Interval)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Reconcile(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			r.Warningf("reconcile failed: %v", trace.DebugReport(err))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Reconcile detects drift once and re-applies drifted resources,
+// resources that failed to apply are retried with exponential backoff
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+	drifts, err := detectDrift(ctx, r.Objects, r.Bundle)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, drift := range drifts {
+		r.Infof("detected drift: %v", drift)
+		r.queue.add(drift.Ref)
+	}
+	r.backoff.GC()
+	for _, ref := range r.queue.list() {
+		if err := ctx.Err(); err != nil {
+			return trace.Wrap(err)
+		}
+		key := ref.key()
+		now := time.Now()
+		if r.backoff.IsInBackOffSinceUpdate(key, now) {
+			r.Debugf("%v is in backoff for %v", ref, r.backoff.Get(key))
+			continue
+		}
+		if err := r.limiter.Wait(ctx); err != nil {
+			return trace.Wrap(err)
+		}
+		r.Infof("re-apply %v", ref)
+		if err := r.Apply(ctx, r.objects[key]); err != nil {
+			r.backoff.Next(key, now)
+			r.Warningf("failed to re-apply %v, retry in %v: %v", ref, r.backoff.Get(key), err)
+			continue
+		}
+		r.backoff.Reset(key)
+		r.queue.remove(ref)
+	}
+	return nil
+}
+
+// refQueue is a queue of unique resource references
+type refQueue struct {
+	refs   []ObjectRef
+	queued map[string]struct{}
+}
+
+func newRefQueue() *refQueue {
+	return &refQueue{queued: make(map[string]struct{})}
+}
+
+// add adds the reference to the queue unless it is already queued
+func (q *refQueue) add(ref ObjectRef) {
+	if _, ok := q.queued[ref.key()]; ok {
+		return
+	}
+	q.queued[ref.key()] = struct{}{}
+	q.refs = append(q.refs, ref)
+}
+
+// remove removes the reference from the queue
+func (q *refQueue) remove(ref ObjectRef) {
+	delete(q.queued, ref.key())
+	for i := range q.refs {
+		if q.refs[i].key() == ref.key() {
+			q.refs = append(q.refs[:i], q.refs[i+1:]...)
+			return
+		}
+	}
+}
+
+// list returns a copy of the queued references in order
+func (q *refQueue) list() []ObjectRef {
+	return append([]ObjectRef(nil), q.refs...)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReconcileSuite struct{}
+
+var _ = Suite(&ReconcileSuite{})
+
+func (s *ReconcileSuite) TestReconcileSetsNamespace(c *C) {
+	bundle, err := NewBundle("app", "apps", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: value
+`))
+	c.Assert(err, IsNil)
+	objects := memObjects{}
+	reconciler, err := NewReconciler(ReconcilerConfig{Bundle: bundle, Objects: objects})
+	c.Assert(err, IsNil)
+	c.Assert(reconciler.Reconcile(context.TODO()), IsNil)
+
+	live, err := objects.Get(context.TODO(), ObjectRef{APIVersion: V1, Kind: KindConfigMap, Namespace: "apps", Name: "config"})
+	c.Assert(err, IsNil)
+	c.Assert(live.GetNamespace(), Equals, "apps")
+	c.Assert(reconciler.queue.list(), HasLen, 0)
+	// the bundle manifest is left intact
+	c.Assert(bundle.Objects[0].GetNamespace(), Equals, "")
+}
+
+func (s *ReconcileSuite) TestReconcileCancelled(c *C) {
+	bundle, err := NewBundle("app", "apps", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+`))
+	c.Assert(err, IsNil)
+	reconciler, err := NewReconciler(ReconcilerConfig{Bundle: bundle, Objects: memObjects{}, QPS: 0.001, Burst: 1})
+	c.Assert(err, IsNil)
+	// exhaust the burst so that the next apply has to wait
+	c.Assert(reconciler.limiter.Allow(), Equals, true)
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	c.Assert(reconciler.Reconcile(ctx), NotNil)
+	c.Assert(reconciler.queue.list(), HasLen, 1)
+}
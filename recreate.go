@@ -0,0 +1,156 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gravitational/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// rollingUpdateConflicts returns the reasons the pods of the deployment
+// cannot be replaced with a rolling update, i.e. the old and the new pods
+// cannot run side by side. Returns nothing if the deployment is recreated anyway
+func (c *DeploymentControl) rollingUpdateConflicts() ([]string, error) {
+	if c.deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return nil, nil
+	}
+	claims := c.Client.CoreV1().PersistentVolumeClaims(c.deployment.Namespace)
+	return podSpecConflicts(c.deployment.Spec.Template.Spec, func(name string) (*v1.PersistentVolumeClaim, error) {
+		claim, err := claims.Get(name, metav1.GetOptions{})
+		return claim, ConvertError(err)
+	})
+}
+
+// podSpecConflicts returns the reasons two pods with the spec cannot run side by side:
+// host ports and volumes from claims that can be mounted by a single node only.
+// Missing claims are not conflicts, the pods will not start anyway
+func podSpecConflicts(spec v1.PodSpec, getClaim func(name string) (*v1.PersistentVolumeClaim, error)) ([]string, error) {
+	var conflicts []string
+	for _, container := range spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort != 0 {
+				conflicts = append(conflicts, fmt.Sprintf("container %v uses host port %v", container.Name, port.HostPort))
+			}
+		}
+	}
+	for _, volume := range spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claim, err := getClaim(volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+		if readWriteOnce(claim) {
+			conflicts = append(conflicts, fmt.Sprintf("volume %v uses ReadWriteOnce claim %v", volume.Name, claim.Name))
+		}
+	}
+	return conflicts, nil
+}
+
+// readWriteOnce returns true if the claim can be mounted by a single node only
+func readWriteOnce(claim *v1.PersistentVolumeClaim) bool {
+	modes := claim.Status.AccessModes
+	if len(modes) == 0 {
+		modes = claim.Spec.AccessModes
+	}
+	for _, mode := range modes {
+		if mode != v1.ReadWriteOnce {
+			return false
+		}
+	}
+	return len(modes) != 0
+}
+
+// recreate updates the deployment with the Recreate strategy, so that the old pods
+// are deleted before the new pods are created, waits until all pods are replaced
+// and restores the update strategy of the deployment
+func (c *DeploymentControl) recreate(ctx context.Context, conflicts []string) (err error) {
+	c.Infof("rolling update is not possible: %v, switching to Recreate strategy", strings.Join(conflicts, "; "))
+	deployments := c.Client.AppsV1().Deployments(c.deployment.Namespace)
+	deployment := c.deployment.DeepCopy()
+	deployment.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	updated, err := deployments.Update(deployment)
+	if err != nil {
+		return ConvertError(err)
+	}
+	defer func() {
+		errRestore := c.restoreStrategy()
+		if errRestore == nil {
+			return
+		}
+		if err != nil {
+			c.Warningf("failed to restore update strategy: %v", errRestore)
+			return
+		}
+		err = errRestore
+	}()
+	err = retry(ctx, DefaultRetryAttempts, DefaultRetryPeriod, func() error {
+		current, err := deployments.Get(c.deployment.Name, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		return checkReplaced(current, updated.Generation)
+	})
+	return trace.Wrap(err)
+}
+
+// restoreStrategy restores the update strategy of the deployment to the one
+// from the manifest, RollingUpdate if the manifest does not specify it
+func (c *DeploymentControl) restoreStrategy() error {
+	strategy := c.deployment.Spec.Strategy
+	if strategy.Type == "" {
+		strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+	}
+	c.Infof("restoring %v update strategy", strategy.Type)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"strategy": strategy},
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = c.Client.AppsV1().Deployments(c.deployment.Namespace).Patch(c.deployment.Name, types.MergePatchType, patch)
+	return ConvertError(err)
+}
+
+// checkReplaced returns CompareFailed until the deployment controller has observed
+// the generation of the deployment and all pods of the deployment are updated
+func checkReplaced(deployment *appsv1.Deployment, generation int64) error {
+	if deployment.Status.ObservedGeneration < generation {
+		return trace.CompareFailed("deployment %v generation %v not observed yet", FormatMeta(deployment.ObjectMeta), generation)
+	}
+	var replicas int32 = 1
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if deployment.Status.UpdatedReplicas != replicas || deployment.Status.Replicas != replicas {
+		return trace.CompareFailed("deployment %v: %v of %v pods replaced, %v pods in total", FormatMeta(deployment.ObjectMeta),
+			deployment.Status.UpdatedReplicas, replicas, deployment.Status.Replicas)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type RecreateSuite struct{}
+
+var _ = Suite(&RecreateSuite{})
+
+func (s *RecreateSuite) TestPodSpecConflicts(c *C) {
+	claims := map[string]*v1.PersistentVolumeClaim{
+		"data": {
+			ObjectMeta: metav1.ObjectMeta{Name: "data"},
+			Spec:       v1.PersistentVolumeClaimSpec{AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}},
+		},
+		"shared": {
+			ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+			Spec:       v1.PersistentVolumeClaimSpec{AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadWriteMany}},
+		},
+	}
+	getClaim := func(name string) (*v1.PersistentVolumeClaim, error) {
+		claim, ok := claims[name]
+		if !ok {
+			return nil, trace.NotFound("claim %v not found", name)
+		}
+		return claim, nil
+	}
+	volume := func(name, claim string) v1.Volume {
+		return v1.Volume{Name: name, VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		}}
+	}
+	spec := v1.PodSpec{
+		Containers: []v1.Container{
+			{Name: "app", Ports: []v1.ContainerPort{{ContainerPort: 8080}, {ContainerPort: 53, HostPort: 53}}},
+		},
+		Volumes: []v1.Volume{volume("data", "data"), volume("shared", "shared"), volume("missing", "missing")},
+	}
+	conflicts, err := podSpecConflicts(spec, getClaim)
+	c.Assert(err, IsNil)
+	c.Assert(conflicts, DeepEquals, []string{
+		"container app uses host port 53",
+		"volume data uses ReadWriteOnce claim data",
+	})
+
+	conflicts, err = podSpecConflicts(v1.PodSpec{Volumes: []v1.Volume{volume("shared", "shared")}}, getClaim)
+	c.Assert(err, IsNil)
+	c.Assert(conflicts, HasLen, 0)
+}
+
+func (s *RecreateSuite) TestCheckReplaced(c *C) {
+	replicas := int32(2)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 3},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2},
+	}
+	c.Assert(trace.IsCompareFailed(checkReplaced(deployment, 3)), Equals, true)
+
+	deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 3, Replicas: 3, UpdatedReplicas: 2}
+	c.Assert(trace.IsCompareFailed(checkReplaced(deployment, 3)), Equals, true)
+
+	deployment.Status.Replicas = 2
+	c.Assert(checkReplaced(deployment, 3), IsNil)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	. "gopkg.in/check.v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type RefSuite struct{}
+
+var _ = Suite(&RefSuite{})
+
+func (s *RefSuite) TestFormatMeta(c *C) {
+	c.Assert(FormatMeta(metav1.ObjectMeta{Name: "admin"}), Equals, "admin")
+	c.Assert(FormatMeta(metav1.ObjectMeta{Namespace: "kube-system", Name: "dns"}), Equals, "kube-system/dns")
+}
+
+func (s *RefSuite) TestParseObjectRef(c *C) {
+	tcs := []struct {
+		in    string
+		ref   *ObjectRef
+		error bool
+	}{
+		{in: "ClusterRole/admin", ref: &ObjectRef{Kind: "ClusterRole", Name: "admin"}},
+		{in: "Deployment/kube-system/dns", ref: &ObjectRef{Kind: "Deployment", Namespace: "kube-system", Name: "dns"}},
+		{in: "Deployment", error: true},
+		{in: "Deployment//dns", error: true},
+		{in: "a/b/c/d", error: true},
+	}
+	for _, tc := range tcs {
+		comment := Commentf(tc.in)
+		ref, err := ParseObjectRef(tc.in)
+		if tc.error {
+			c.Assert(err, NotNil, comment)
+			continue
+		}
+		c.Assert(err, IsNil, comment)
+		c.Assert(ref, DeepEquals, tc.ref, comment)
+		c.Assert(ref.String(), Equals, tc.in, comment)
+	}
+}
+
+func (s *RefSuite) TestManifestRef(c *C) {
+	ref, err := ManifestRef([]byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: dns
+  namespace: kube-system
+`))
+	c.Assert(err, IsNil)
+	c.Assert(*ref, DeepEquals, ObjectRef{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "kube-system", Name: "dns"})
+
+	_, err = ManifestRef([]byte("kind: ["))
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,453 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultRegistryTimeout limits each request to an image registry
+	DefaultRegistryTimeout = 30 * time.Second
+	// dockerHubRegistry is the registry of images without a registry host
+	dockerHubRegistry = "docker.io"
+	// dockerHubEndpoint is the registry API endpoint of Docker Hub
+	dockerHubEndpoint = "registry-1.docker.io"
+)
+
+// manifestMediaTypes lists manifest media types accepted from registries
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+}
+
+// ImageCheckConfig configures PreflightImages
+type ImageCheckConfig struct {
+	// Client is k8s client used to read the image pull secrets referenced
+	// by pod templates, the secrets are only looked up in the manifest stream if unset
+	Client *kubernetes.Clientset
+	// Namespace is the namespace of workloads without namespace
+	Namespace string
+	// DockerConfigs lists additional docker config JSONs with registry credentials
+	DockerConfigs [][]byte
+	// InsecureRegistries lists registries accessed over plain HTTP
+	InsecureRegistries []string
+	// HTTPClient sends the registry requests, defaults to a client
+	// with DefaultRegistryTimeout
+	HTTPClient *http.Client
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *ImageCheckConfig) CheckAndSetDefaults() error {
+	c.Namespace = Namespace(c.Namespace)
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: DefaultRegistryTimeout}
+	}
+	return nil
+}
+
+// PreflightImages verifies that every image referenced by the pod templates
+// of the manifest stream exists in its registry, so that mistyped images and tags
+// are caught before they cause ImagePullBackOff in the middle of a rollout.
+// Registries are queried with the credentials of the image pull secrets of each pod template
+func PreflightImages(ctx context.Context, config ImageCheckConfig, data []byte) error {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	checker := &imageChecker{
+		ImageCheckConfig: config,
+		streamSecrets:    make(map[string]*v1.Secret),
+		checked:          make(map[string]error),
+	}
+	for _, object := range objects {
+		if object.GetKind() != KindSecret {
+			continue
+		}
+		var secret v1.Secret
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &secret); err != nil {
+			return trace.Wrap(err)
+		}
+		checker.streamSecrets[Namespace(secret.Namespace)+"/"+secret.Name] = &secret
+	}
+	var errors []error
+	for _, object := range objects {
+		if err := ctx.Err(); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := checker.checkObject(ctx, object); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// imageChecker checks images of pod templates, caching the results
+type imageChecker struct {
+	ImageCheckConfig
+	// streamSecrets maps namespace/name to the secrets of the manifest stream
+	streamSecrets map[string]*v1.Secret
+	// checked maps images to the results of their checks
+	checked map[string]error
+}
+
+func (c *imageChecker) checkObject(ctx context.Context, object *unstructured.Unstructured) error {
+	spec, err := GetPodSpec(object)
+	if err != nil {
+		if trace.IsNotFound(err) {
+			return nil
+		}
+		return trace.Wrap(err)
+	}
+	namespace := c.Namespace
+	if object.GetNamespace() != "" {
+		namespace = object.GetNamespace()
+	}
+	auths, err := c.credentials(namespace, spec.ImagePullSecrets)
+	if err != nil {
+		return trace.Wrap(err, "%v %v", object.GetKind(), object.GetName())
+	}
+	var errors []error
+	containers := append(append([]v1.Container(nil), spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		result, ok := c.checked[container.Image]
+		if !ok {
+			log.Debugf("check image %v of %v %v", container.Image, object.GetKind(), object.GetName())
+			result = c.checkImage(ctx, container.Image, auths)
+			c.checked[container.Image] = result
+		}
+		if result != nil {
+			errors = append(errors, trace.Wrap(result, "%v %v container %v", object.GetKind(), object.GetName(), container.Name))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// credentials returns the registry credentials of the pull secrets and additional docker configs
+func (c *imageChecker) credentials(namespace string, refs []v1.LocalObjectReference) (registryAuths, error) {
+	auths := make(registryAuths)
+	for _, config := range c.DockerConfigs {
+		if err := auths.add(config); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	for _, ref := range refs {
+		secret, ok := c.streamSecrets[namespace+"/"+ref.Name]
+		if !ok && c.Client != nil {
+			var err error
+			secret, err = c.Client.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, ConvertErrorWithContext(err, "failed to read image pull secret %v/%v", namespace, ref.Name)
+			}
+		}
+		if secret == nil {
+			log.Warningf("image pull secret %v/%v not found", namespace, ref.Name)
+			continue
+		}
+		for _, key := range []string{v1.DockerConfigJsonKey, v1.DockerConfigKey} {
+			if data, ok := secret.Data[key]; ok {
+				if err := auths.add(data); err != nil {
+					return nil, trace.Wrap(err, "invalid image pull secret %v/%v", namespace, ref.Name)
+				}
+			}
+		}
+	}
+	return auths, nil
+}
+
+// checkImage sends a HEAD request for the image manifest to the registry,
+// returns NotFound if the image does not exist
+func (c *imageChecker) checkImage(ctx context.Context, image string, auths registryAuths) error {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	scheme := "https"
+	for _, registry := range c.InsecureRegistries {
+		if registry == ref.Registry {
+			scheme = "http"
+		}
+	}
+	endpoint := ref.Registry
+	if endpoint == dockerHubRegistry {
+		endpoint = dockerHubEndpoint
+	}
+	manifestURL := fmt.Sprintf("%v://%v/v2/%v/manifests/%v", scheme, endpoint, ref.Repository, ref.Reference)
+	auth, hasAuth := auths.lookup(ref.Registry)
+	resp, err := c.headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err := c.authorize(ctx, resp.Header.Get("WWW-Authenticate"), auth, hasAuth)
+		if err != nil {
+			return trace.Wrap(err, "failed to authenticate to registry %v", ref.Registry)
+		}
+		resp, err = c.headManifest(ctx, manifestURL, authorization)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return trace.NotFound("image %v not found in registry %v", image, ref.Registry)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// registries report missing repositories as unauthorized to anonymous users
+		return trace.AccessDenied("access to image %v denied by registry %v, the image may not exist", image, ref.Registry)
+	}
+	return trace.BadParameter("unexpected response %v from registry %v for image %v", resp.Status, ref.Registry, image)
+}
+
+func (c *imageChecker) headManifest(ctx context.Context, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// authorize answers the authentication challenge of the registry,
+// returns the value of the Authorization header
+func (c *imageChecker) authorize(ctx context.Context, challenge string, auth registryAuth, hasAuth bool) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasAuth {
+			return "", trace.AccessDenied("registry requires credentials")
+		}
+		return "Basic " + auth.encode(), nil
+	case "bearer":
+		return c.bearerToken(ctx, params, auth, hasAuth)
+	}
+	return "", trace.BadParameter("unsupported authentication challenge %q", challenge)
+}
+
+// bearerToken requests a token from the token service of the registry
+func (c *imageChecker) bearerToken(ctx context.Context, params map[string]string, auth registryAuth, hasAuth bool) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", trace.BadParameter("missing realm in the authentication challenge")
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	query := tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	if hasAuth {
+		req.Header.Set("Authorization", "Basic "+auth.encode())
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", trace.AccessDenied("token service %v returned %v", realm, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", trace.Wrap(err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", trace.AccessDenied("token service %v returned no token", realm)
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseChallenge parses the WWW-Authenticate header, e.g.
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	for _, param := range splitParams(parts[1]) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+	return parts[0], params
+}
+
+// splitParams splits the comma separated parameters ignoring commas in quoted values
+func splitParams(in string) []string {
+	var out []string
+	var quoted bool
+	start := 0
+	for i, r := range in {
+		switch r {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				out = append(out, in[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, in[start:])
+}
+
+// imageRef is a parsed image reference
+type imageRef struct {
+	// Registry is the registry host
+	Registry string
+	// Repository is the repository in the registry
+	Repository string
+	// Reference is the tag or digest
+	Reference string
+}
+
+// parseImageRef parses the image reference, images without registry host
+// refer to Docker Hub and images without tag or digest to the latest tag
+func parseImageRef(image string) (*imageRef, error) {
+	if image == "" {
+		return nil, trace.BadParameter("missing image")
+	}
+	name, reference := image, "latest"
+	if i := strings.Index(image, "@"); i != -1 {
+		name, reference = image[:i], image[i+1:]
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, reference = image[:i], image[i+1:]
+	}
+	if name == "" || reference == "" {
+		return nil, trace.BadParameter("invalid image %q", image)
+	}
+	registry, repository := dockerHubRegistry, name
+	if i := strings.Index(name, "/"); i != -1 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			registry, repository = host, name[i+1:]
+		}
+	}
+	if registry == dockerHubRegistry && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return &imageRef{Registry: registry, Repository: repository, Reference: reference}, nil
+}
+
+// registryAuth is the registry credentials of a docker config
+type registryAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// encode returns the base64 encoded username:password
+func (a registryAuth) encode() string {
+	if a.Auth != "" {
+		return a.Auth
+	}
+	return base64.StdEncoding.EncodeToString([]byte(a.Username + ":" + a.Password))
+}
+
+// registryAuths maps registry hosts to credentials
+type registryAuths map[string]registryAuth
+
+// add adds the credentials of the docker config JSON,
+// both the config.json and the legacy .dockercfg formats are supported
+func (r registryAuths) add(data []byte) error {
+	var config struct {
+		Auths map[string]registryAuth `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return trace.Wrap(err)
+	}
+	if config.Auths == nil {
+		if err := json.Unmarshal(data, &config.Auths); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	for server, auth := range config.Auths {
+		r[registryHost(server)] = auth
+	}
+	return nil
+}
+
+// lookup returns the credentials of the registry
+func (r registryAuths) lookup(registry string) (registryAuth, bool) {
+	auth, ok := r[registry]
+	return auth, ok
+}
+
+// registryHost returns the registry host of the docker config server entry,
+// e.g. https://index.docker.io/v1/ is docker.io
+func registryHost(server string) string {
+	host := server
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "index.docker.io", dockerHubEndpoint:
+		return dockerHubRegistry
+	}
+	return host
+}
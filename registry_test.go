@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type RegistrySuite struct{}
+
+var _ = Suite(&RegistrySuite{})
+
+func (s *RegistrySuite) TestParseImageRef(c *C) {
+	tcs := []struct {
+		image string
+		ref   imageRef
+	}{
+		{image: "nginx", ref: imageRef{Registry: "docker.io", Repository: "library/nginx", Reference: "latest"}},
+		{image: "nginx:1.15", ref: imageRef{Registry: "docker.io", Repository: "library/nginx", Reference: "1.15"}},
+		{image: "gravitational/rig:1.0", ref: imageRef{Registry: "docker.io", Repository: "gravitational/rig", Reference: "1.0"}},
+		{image: "localhost:5000/app", ref: imageRef{Registry: "localhost:5000", Repository: "app", Reference: "latest"}},
+		{image: "quay.io/org/app@sha256:abc", ref: imageRef{Registry: "quay.io", Repository: "org/app", Reference: "sha256:abc"}},
+	}
+	for _, tc := range tcs {
+		ref, err := parseImageRef(tc.image)
+		c.Assert(err, IsNil, Commentf(tc.image))
+		c.Assert(*ref, DeepEquals, tc.ref, Commentf(tc.image))
+	}
+}
+
+func (s *RegistrySuite) TestPreflightImages(c *C) {
+	var registry *httptest.Server
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+		case r.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%v/token",service="registry",scope="repository:app:pull"`, registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/app/manifests/1.0":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+	u, err := url.Parse(registry.URL)
+	c.Assert(err, IsNil)
+	host := u.Host
+
+	secret, err := NewImagePullSecret("registry", host, "user", "pass")
+	c.Assert(err, IsNil)
+	manifest := func(tag string) []byte {
+		return []byte(strings.Replace(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: `+host+`/app:TAG
+`, "TAG", tag, 1))
+	}
+	config := ImageCheckConfig{InsecureRegistries: []string{host}}
+
+	data, err := secret.Transform(manifest("1.0"))
+	c.Assert(err, IsNil)
+	c.Assert(PreflightImages(context.TODO(), config, data), IsNil)
+
+	data, err = secret.Transform(manifest("1.1"))
+	c.Assert(err, IsNil)
+	err = PreflightImages(context.TODO(), config, data)
+	c.Assert(err, ErrorMatches, "(?s).*image .*/app:1.1 not found.*")
+
+	err = PreflightImages(context.TODO(), config, manifest("1.0"))
+	c.Assert(err, NotNil)
+}
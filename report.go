@@ -0,0 +1,357 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ReportConfigMapKey is the key of the report in the config map
+	ReportConfigMapKey = "report.json"
+	// maxReportLogLines limits the number of log lines collected per container
+	maxReportLogLines = 200
+	// maxReportLogBytes limits the size of the log collected per container
+	maxReportLogBytes = 64 * 1024
+	// maxReportEvents limits the number of events collected per object
+	maxReportEvents = 20
+	// maxConfigMapSize is the maximum size of the config map data
+	maxConfigMapSize = 1024 * 1024
+)
+
+// FailureReport is a machine-readable report of a failed changeset
+// with everything needed to debug the failure from a single attachment
+type FailureReport struct {
+	// Namespace is the namespace of the changeset
+	Namespace string `json:"namespace"`
+	// Changeset is the name of the changeset
+	Changeset string `json:"changeset"`
+	// Status is the changeset status
+	Status string `json:"status"`
+	// Error is the failure reason
+	Error string `json:"error,omitempty"`
+	// Time is the time the report was generated
+	Time time.Time `json:"time"`
+	// Resources lists the resources of the changeset that are not ready
+	Resources []ResourceReport `json:"resources"`
+}
+
+// ResourceReport describes a failing resource
+type ResourceReport struct {
+	// Ref references the resource
+	Ref ObjectRef `json:"ref"`
+	// Operation is the changeset operation, e.g. "upsert Deployment kube-system/dns"
+	Operation string `json:"operation"`
+	// OperationStatus is the status of the operation
+	OperationStatus string `json:"operationStatus"`
+	// Error is the result of the status check
+	Error string `json:"error,omitempty"`
+	// Spec is the desired state of the resource
+	Spec *unstructured.Unstructured `json:"spec,omitempty"`
+	// Live is the current state of the resource including the status
+	Live *unstructured.Unstructured `json:"live,omitempty"`
+	// Events are the recent events of the resource
+	Events []v1.Event `json:"events,omitempty"`
+	// Pods lists pods of the workload that are not ready
+	Pods []PodReport `json:"pods,omitempty"`
+	// StatusHistory lists the statuses of the resource observed during status waits
+	StatusHistory []StatusSnapshot `json:"statusHistory,omitempty"`
+}
+
+// PodReport describes a pod that is not ready
+type PodReport struct {
+	// Name is the pod name
+	Name string `json:"name"`
+	// Node is the node the pod is scheduled on
+	Node string `json:"node,omitempty"`
+	// Status is the pod status
+	Status v1.PodStatus `json:"status"`
+	// Events are the recent events of the pod
+	Events []v1.Event `json:"events,omitempty"`
+	// Logs maps container names to the tail of their logs
+	Logs map[string]string `json:"logs,omitempty"`
+}
+
+// ReportWriter stores failure reports
+type ReportWriter interface {
+	// WriteReport stores the report
+	WriteReport(ctx context.Context, report FailureReport) error
+}
+
+// FileReportWriter writes failure reports as JSON files to the directory,
+// named <namespace>-<changeset>-<unix time>.json
+type FileReportWriter struct {
+	// Dir is the directory of the reports
+	Dir string
+}
+
+// WriteReport writes the report file
+func (w FileReportWriter) WriteReport(ctx context.Context, report FailureReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	path := filepath.Join(w.Dir, report.fileName())
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	return nil
+}
+
+// ConfigMapReportWriter stores failure reports in config maps named
+// <changeset>-failure-report under the ReportConfigMapKey
+type ConfigMapReportWriter struct {
+	// Client is k8s client
+	Client *kubernetes.Clientset
+	// Namespace is the namespace of the config maps,
+	// defaults to the namespace of the changeset
+	Namespace string
+}
+
+// WriteReport creates or updates the report config map
+func (w ConfigMapReportWriter) WriteReport(ctx context.Context, report FailureReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if len(data) > maxConfigMapSize {
+		return trace.LimitExceeded("report of %v bytes exceeds the config map size limit of %v bytes",
+			len(data), maxConfigMapSize)
+	}
+	namespace := w.Namespace
+	if namespace == "" {
+		namespace = report.Namespace
+	}
+	configMap := &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       KindConfigMap,
+			APIVersion: V1,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      report.Changeset + "-failure-report",
+			Namespace: Namespace(namespace),
+		},
+		Data: map[string]string{
+			ReportConfigMapKey: string(data),
+		},
+	}
+	configMaps := w.Client.CoreV1().ConfigMaps(configMap.Namespace)
+	_, err = configMaps.Create(configMap)
+	err = ConvertError(err)
+	if trace.IsAlreadyExists(err) {
+		_, err = configMaps.Update(configMap)
+		err = ConvertError(err)
+	}
+	return trace.Wrap(err)
+}
+
+func (r FailureReport) fileName() string {
+	return fmt.Sprintf("%v-%v-%v.json", r.Namespace, r.Changeset, r.Time.Unix())
+}
+
+// Report collects specs, live state, events and pod logs of the changeset
+// resources that are not ready into a failure report, cause is the failure reason
+func (cs *Changeset) Report(ctx context.Context, changesetNamespace, changesetName string, cause error) (*FailureReport, error) {
+	tr, err := cs.get(ctx, changesetNamespace, changesetName)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	report := &FailureReport{
+		Namespace: tr.Namespace,
+		Changeset: tr.Name,
+		Status:    tr.Spec.Status,
+		Time:      time.Now().UTC(),
+	}
+	if cause != nil {
+		report.Error = cause.Error()
+	}
+	for _, item := range lastItems(tr.Spec.Items) {
+		resource, err := cs.reportItem(ctx, item)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if resource != nil {
+			report.Resources = append(report.Resources, *resource)
+		}
+	}
+	return report, nil
+}
+
+// reportItem returns the report of the resource modified by the operation,
+// nil if the resource is ready
+func (cs *Changeset) reportItem(ctx context.Context, item ChangesetItem) (*ResourceReport, error) {
+	data := item.To
+	if data == "" {
+		data = item.From
+	}
+	objects, err := DecodeObjects([]byte(data))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+	var statusErr error
+	if item.To != "" {
+		statusErr = cs.status(ctx, []byte(item.To), "")
+	} else {
+		statusErr = cs.status(ctx, []byte(item.From), item.UID)
+		if trace.IsNotFound(statusErr) {
+			// the resource has been deleted as requested
+			statusErr = nil
+		} else {
+			statusErr = trace.CompareFailed("resource with UID %q still active: %v", item.UID, statusErr)
+		}
+	}
+	if statusErr == nil && item.Status != OpStatusCreated {
+		return nil, nil
+	}
+	info, err := GetOperationInfo(item)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	spec := objects[0]
+	ref := ObjectRef{
+		APIVersion: spec.GetAPIVersion(),
+		Kind:       spec.GetKind(),
+		Namespace:  Namespace(spec.GetNamespace()),
+		Name:       spec.GetName(),
+	}
+	if IsClusterScoped(ref.Kind) {
+		ref.Namespace = ""
+	}
+	resource := &ResourceReport{
+		Ref:             ref,
+		Operation:       info.String(),
+		OperationStatus: item.Status,
+		StatusHistory:   item.StatusHistory,
+	}
+	if item.To != "" {
+		resource.Spec = spec
+	}
+	if statusErr != nil {
+		resource.Error = statusErr.Error()
+	}
+	// the report is best-effort, failures to collect a part of it are recorded
+	// in the report instead of failing it
+	live, err := cs.Objects.Get(ctx, ref)
+	if err != nil && !trace.IsNotFound(err) {
+		resource.Error = fmt.Sprintf("%v, failed to get live object: %v", resource.Error, err)
+	}
+	resource.Live = live
+	resource.Events, err = recentEvents(cs.Client, ref.Namespace, ref.Kind, ref.Name, maxReportEvents)
+	if err != nil {
+		resource.Error = fmt.Sprintf("%v, failed to get events: %v", resource.Error, err)
+	}
+	selector, ok := podSelector(spec)
+	if !ok {
+		return resource, nil
+	}
+	pods, err := listPods(cs.Client, ref.Namespace, selector)
+	if err != nil {
+		resource.Error = fmt.Sprintf("%v, failed to list pods: %v", resource.Error, err)
+		return resource, nil
+	}
+	for i, pod := range notReadyPods(pods) {
+		if i == maxDiagnosedPods {
+			break
+		}
+		resource.Pods = append(resource.Pods, reportPod(cs.Client, pod))
+	}
+	return resource, nil
+}
+
+// reportPod collects the status, events and container logs of the pod
+func reportPod(client *kubernetes.Clientset, pod v1.Pod) PodReport {
+	report := PodReport{
+		Name:   pod.Name,
+		Node:   pod.Spec.NodeName,
+		Status: pod.Status,
+		Logs:   reportPodLogs(client, pod),
+	}
+	events, err := recentEvents(client, pod.Namespace, KindPod, pod.Name, maxReportEvents)
+	if err == nil {
+		report.Events = events
+	}
+	return report
+}
+
+// reportPodLogs returns the tail of the logs of the pod containers
+// by container name, or the reason the logs could not be retrieved
+func reportPodLogs(client *kubernetes.Clientset, pod v1.Pod) map[string]string {
+	logs := make(map[string]string)
+	var containers []v1.Container
+	containers = append(containers, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+	tailLines := int64(maxReportLogLines)
+	limitBytes := int64(maxReportLogBytes)
+	for _, container := range containers {
+		data, err := client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+			Container:  container.Name,
+			TailLines:  &tailLines,
+			LimitBytes: &limitBytes,
+		}).DoRaw()
+		if err != nil {
+			logs[container.Name] = fmt.Sprintf("failed to get logs: %v", ConvertError(err))
+			continue
+		}
+		logs[container.Name] = string(data)
+	}
+	return logs
+}
+
+// lastItems returns the last operation on each resource, ordered by the first
+// operation on the resource
+func lastItems(items []ChangesetItem) []ChangesetItem {
+	last := make(map[string]int)
+	var keys []string
+	for i, item := range items {
+		info, err := GetOperationInfo(item)
+		if err != nil {
+			continue
+		}
+		header := info.To
+		if header == nil {
+			header = info.From
+		}
+		key := fmt.Sprintf("%v/%v/%v", info.Kind(), Namespace(header.Namespace), header.Name)
+		if _, ok := last[key]; !ok {
+			keys = append(keys, key)
+		}
+		last[key] = i
+	}
+	out := make([]ChangesetItem, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, items[last[key]])
+	}
+	return out
+}
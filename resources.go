@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ResourceDefaults is a transformer that sets default resource requests
+// and limits on containers lacking them
+type ResourceDefaults struct {
+	// Default is applied to containers of all workloads
+	Default v1.ResourceRequirements
+	// Namespaces overrides the defaults for workloads in specific namespaces
+	Namespaces map[string]v1.ResourceRequirements
+}
+
+// Transform sets default resources on pod templates in the manifest stream
+func (r ResourceDefaults) Transform(data []byte) ([]byte, error) {
+	return PodSpecTransformer(r.setDefaults).Transform(data)
+}
+
+func (r ResourceDefaults) setDefaults(object *unstructured.Unstructured, spec *v1.PodSpec) error {
+	defaults, ok := r.Namespaces[Namespace(object.GetNamespace())]
+	if !ok {
+		defaults = r.Default
+	}
+	for i := range spec.InitContainers {
+		setDefaultResources(&spec.InitContainers[i].Resources, defaults)
+	}
+	for i := range spec.Containers {
+		setDefaultResources(&spec.Containers[i].Resources, defaults)
+	}
+	return nil
+}
+
+// setDefaultResources sets missing requests and limits, default requests
+// never exceed the limits set on the container
+func setDefaultResources(resources *v1.ResourceRequirements, defaults v1.ResourceRequirements) {
+	for name, quantity := range defaults.Limits {
+		if _, ok := resources.Limits[name]; ok {
+			continue
+		}
+		if request, ok := resources.Requests[name]; ok && request.Cmp(quantity) > 0 {
+			quantity = request
+		}
+		if resources.Limits == nil {
+			resources.Limits = make(v1.ResourceList)
+		}
+		resources.Limits[name] = quantity
+	}
+	for name, quantity := range defaults.Requests {
+		if _, ok := resources.Requests[name]; ok {
+			continue
+		}
+		if limit, ok := resources.Limits[name]; ok && limit.Cmp(quantity) < 0 {
+			quantity = limit
+		}
+		if resources.Requests == nil {
+			resources.Requests = make(v1.ResourceList)
+		}
+		resources.Requests[name] = quantity
+	}
+}
+
+// ParseResourceList parses resources in form of cpu=100m,memory=128Mi
+func ParseResourceList(in string) (v1.ResourceList, error) {
+	list := make(v1.ResourceList)
+	for _, pair := range strings.Split(in, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, trace.BadParameter("expected resource=quantity, got %q", pair)
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, trace.BadParameter("invalid quantity %q of %v: %v", parts[1], parts[0], err)
+		}
+		list[v1.ResourceName(strings.TrimSpace(parts[0]))] = quantity
+	}
+	return list, nil
+}
@@ -0,0 +1,343 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Restarter is a workload that can be restarted to pick up
+// configuration changes
+type Restarter interface {
+	StatusReporter
+	// Restart triggers a rolling restart of the workload's pods
+	Restart(ctx context.Context) error
+	// RolloutStatus returns CompareFailed until the controller of the workload
+	// has observed the last restart and replaced the pods of the workload
+	RolloutStatus() error
+}
+
+// restartDependents restarts the specified workloads one by one
+// and waits for each of them to roll out the restarted pods
+func restartDependents(ctx context.Context, dependents []Restarter) error {
+	for _, dependent := range dependents {
+		if err := dependent.Restart(ctx); err != nil {
+			return trace.Wrap(err)
+		}
+		if err := WaitRestarted(ctx, 0, 0, dependent); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// WaitRestarted waits for the restarted workload to replace its pods
+// and for the replaced pods to become ready
+func WaitRestarted(ctx context.Context, retryAttempts int, retryPeriod time.Duration, workload Restarter) error {
+	return PollStatus(ctx, retryAttempts, retryPeriod, rolloutReporter{workload})
+}
+
+// rolloutReporter reports the status of a restarted workload,
+// which is not ready until its pods have been replaced
+type rolloutReporter struct {
+	Restarter
+}
+
+// Status returns the status of the workload once its pods have been replaced
+func (r rolloutReporter) Status() error {
+	if err := r.RolloutStatus(); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(r.Restarter.Status())
+}
+
+// checkDaemonSetRolledOut returns CompareFailed until the daemon set controller
+// has observed the generation of the daemon set and all scheduled pods are updated and available
+func checkDaemonSetRolledOut(ds *appsv1.DaemonSet, generation int64) error {
+	if ds.Status.ObservedGeneration < generation {
+		return trace.CompareFailed("daemon set %v generation %v not observed yet", FormatMeta(ds.ObjectMeta), generation)
+	}
+	if ds.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+		return nil
+	}
+	desired := ds.Status.DesiredNumberScheduled
+	if ds.Status.UpdatedNumberScheduled < desired {
+		return trace.CompareFailed("daemon set %v: %v of %v pods updated", FormatMeta(ds.ObjectMeta),
+			ds.Status.UpdatedNumberScheduled, desired)
+	}
+	if ds.Status.NumberAvailable < desired {
+		return trace.CompareFailed("daemon set %v: %v of %v pods available", FormatMeta(ds.ObjectMeta),
+			ds.Status.NumberAvailable, desired)
+	}
+	return nil
+}
+
+// checkStatefulSetRolledOut returns CompareFailed until the stateful set controller
+// has observed the generation of the stateful set and all pods above the partition
+// are updated to the update revision and ready
+func checkStatefulSetRolledOut(statefulSet *appsv1.StatefulSet, generation int64) error {
+	if statefulSet.Status.ObservedGeneration < generation {
+		return trace.CompareFailed("stateful set %v generation %v not observed yet", FormatMeta(statefulSet.ObjectMeta), generation)
+	}
+	if statefulSet.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return nil
+	}
+	var replicas, partition int32 = 1, 0
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	if update := statefulSet.Spec.UpdateStrategy.RollingUpdate; update != nil && update.Partition != nil {
+		partition = *update.Partition
+	}
+	if statefulSet.Status.UpdatedReplicas < replicas-partition {
+		return trace.CompareFailed("stateful set %v: %v of %v pods updated", FormatMeta(statefulSet.ObjectMeta),
+			statefulSet.Status.UpdatedReplicas, replicas-partition)
+	}
+	if partition == 0 && statefulSet.Status.CurrentRevision != statefulSet.Status.UpdateRevision {
+		return trace.CompareFailed("stateful set %v: revision %v not rolled out yet", FormatMeta(statefulSet.ObjectMeta),
+			statefulSet.Status.UpdateRevision)
+	}
+	if statefulSet.Status.ReadyReplicas < replicas {
+		return trace.CompareFailed("stateful set %v: %v of %v pods ready", FormatMeta(statefulSet.ObjectMeta),
+			statefulSet.Status.ReadyReplicas, replicas)
+	}
+	return nil
+}
+
+// HealthChecker verifies the cluster health between restart batches
+type HealthChecker func(ctx context.Context) error
+
+// RollingRestart restarts daemon sets, stateful sets and deployments
+// matching the selector in all namespaces in batches of batchSize workloads.
+// Daemon sets are restarted first, then stateful sets, then deployments.
+// After each batch, it waits for the restarted workloads to become ready
+// and runs the optional checker, the restart is aborted if either fails
+func RollingRestart(ctx context.Context, client *kubernetes.Clientset, selector labels.Selector, batchSize int, checker HealthChecker) error {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	workloads, err := listRestarters(client, selector)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	log.Infof("restarting %v workloads matching %q in batches of %v", len(workloads), selector, batchSize)
+	for start := 0; start < len(workloads); start += batchSize {
+		end := start + batchSize
+		if end > len(workloads) {
+			end = len(workloads)
+		}
+		batch := workloads[start:end]
+		log.Infof("restarting batch %v-%v of %v: %v", start+1, end, len(workloads), batch)
+		if err := restartBatch(ctx, batch, checker); err != nil {
+			return trace.Wrap(err, "restart aborted after batch %v-%v of %v: %v", start+1, end, len(workloads), batch)
+		}
+	}
+	return nil
+}
+
+// restartBatch restarts the workloads, waits for them to replace their pods
+// and for the replaced pods to become ready, and checks the cluster health
+func restartBatch(ctx context.Context, batch []namedRestarter, checker HealthChecker) error {
+	for _, workload := range batch {
+		if err := workload.Restart(ctx); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	for _, workload := range batch {
+		if err := WaitRestarted(ctx, 0, 0, workload.Restarter); err != nil {
+			return trace.Wrap(err, "%v has not rolled out the restarted pods", workload)
+		}
+	}
+	if checker == nil {
+		return nil
+	}
+	return trace.Wrap(checker(ctx))
+}
+
+// namedRestarter is a restarter with a human readable name
+type namedRestarter struct {
+	Restarter
+	name string
+}
+
+// String returns the workload name, e.g. DaemonSet kube-system/dns
+func (r namedRestarter) String() string {
+	return r.name
+}
+
+// listRestarters returns the workloads matching the selector in restart order
+func listRestarters(client *kubernetes.Clientset, selector labels.Selector) ([]namedRestarter, error) {
+	options := metav1.ListOptions{LabelSelector: selector.String()}
+	var workloads []namedRestarter
+	daemonSets, err := client.AppsV1().DaemonSets(metav1.NamespaceAll).List(options)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	sort.Slice(daemonSets.Items, func(i, j int) bool {
+		return FormatMeta(daemonSets.Items[i].ObjectMeta) < FormatMeta(daemonSets.Items[j].ObjectMeta)
+	})
+	for i := range daemonSets.Items {
+		ds := &daemonSets.Items[i]
+		control, err := NewDSControl(DSConfig{DaemonSet: ds, Client: client})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		workloads = append(workloads, namedRestarter{control, fmt.Sprintf("%v %v", KindDaemonSet, FormatMeta(ds.ObjectMeta))})
+	}
+	statefulSets, err := client.AppsV1().StatefulSets(metav1.NamespaceAll).List(options)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	sort.Slice(statefulSets.Items, func(i, j int) bool {
+		return FormatMeta(statefulSets.Items[i].ObjectMeta) < FormatMeta(statefulSets.Items[j].ObjectMeta)
+	})
+	for i := range statefulSets.Items {
+		statefulSet := &statefulSets.Items[i]
+		control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: statefulSet, Client: client})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		workloads = append(workloads, namedRestarter{control, fmt.Sprintf("%v %v", KindStatefulSet, FormatMeta(statefulSet.ObjectMeta))})
+	}
+	deployments, err := client.AppsV1().Deployments(metav1.NamespaceAll).List(options)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	sort.Slice(deployments.Items, func(i, j int) bool {
+		return FormatMeta(deployments.Items[i].ObjectMeta) < FormatMeta(deployments.Items[j].ObjectMeta)
+	})
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		control, err := NewDeploymentControl(DeploymentConfig{Deployment: deployment, Client: client})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		workloads = append(workloads, namedRestarter{control, fmt.Sprintf("%v %v", KindDeployment, FormatMeta(deployment.ObjectMeta))})
+	}
+	return workloads, nil
+}
+
+// findDependents returns the daemon sets, stateful sets and deployments in the namespace
+// with pod templates that reference the config map or secret of the specified kind and name
+func findDependents(client *kubernetes.Clientset, namespace, kind, name string) ([]Restarter, error) {
+	var dependents []Restarter
+	daemonSets, err := client.AppsV1().DaemonSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	for i := range daemonSets.Items {
+		ds := &daemonSets.Items[i]
+		if !podSpecReferences(ds.Spec.Template.Spec, kind, name) {
+			continue
+		}
+		control, err := NewDSControl(DSConfig{DaemonSet: ds, Client: client})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		dependents = append(dependents, control)
+	}
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	for i := range statefulSets.Items {
+		statefulSet := &statefulSets.Items[i]
+		if !podSpecReferences(statefulSet.Spec.Template.Spec, kind, name) {
+			continue
+		}
+		control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: statefulSet, Client: client})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		dependents = append(dependents, control)
+	}
+	deployments, err := client.AppsV1().Deployments(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		if !podSpecReferences(deployment.Spec.Template.Spec, kind, name) {
+			continue
+		}
+		control, err := NewDeploymentControl(DeploymentConfig{Deployment: deployment, Client: client})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		dependents = append(dependents, control)
+	}
+	return dependents, nil
+}
+
+// podSpecReferences returns true if the pod spec mounts or reads environment
+// variables from the config map or secret of the specified kind and name
+func podSpecReferences(spec v1.PodSpec, kind, name string) bool {
+	for _, volume := range spec.Volumes {
+		switch {
+		case kind == KindConfigMap && volume.ConfigMap != nil && volume.ConfigMap.Name == name:
+			return true
+		case kind == KindSecret && volume.Secret != nil && volume.Secret.SecretName == name:
+			return true
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if kind == KindConfigMap && source.ConfigMap != nil && source.ConfigMap.Name == name {
+					return true
+				}
+				if kind == KindSecret && source.Secret != nil && source.Secret.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	containers := append(append([]v1.Container(nil), spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, source := range container.EnvFrom {
+			if kind == KindConfigMap && source.ConfigMapRef != nil && source.ConfigMapRef.Name == name {
+				return true
+			}
+			if kind == KindSecret && source.SecretRef != nil && source.SecretRef.Name == name {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if kind == KindConfigMap && env.ValueFrom.ConfigMapKeyRef != nil && env.ValueFrom.ConfigMapKeyRef.Name == name {
+				return true
+			}
+			if kind == KindSecret && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type RestartSuite struct{}
+
+var _ = Suite(&RestartSuite{})
+
+func (s *RestartSuite) TestSecretDataChanged(c *C) {
+	live := &v1.Secret{Data: map[string][]byte{"password": []byte("secret")}}
+	tcs := []struct {
+		desired *v1.Secret
+		changed bool
+	}{
+		{desired: &v1.Secret{Data: map[string][]byte{"password": []byte("secret")}}},
+		{desired: &v1.Secret{StringData: map[string]string{"password": "secret"}}},
+		{desired: &v1.Secret{StringData: map[string]string{"password": "other"}}, changed: true},
+		{
+			desired: &v1.Secret{
+				Data:       map[string][]byte{"password": []byte("old")},
+				StringData: map[string]string{"password": "secret"},
+			},
+		},
+		{desired: &v1.Secret{}, changed: true},
+	}
+	for i, tc := range tcs {
+		c.Assert(secretDataChanged(live, tc.desired), Equals, tc.changed, Commentf("test case %v", i+1))
+	}
+	c.Assert(secretDataChanged(&v1.Secret{}, &v1.Secret{Data: map[string][]byte{}}), Equals, false)
+}
+
+func (s *RestartSuite) TestConfigMapDataChanged(c *C) {
+	live := &v1.ConfigMap{Data: map[string]string{"config.yaml": "debug: true"}}
+	tcs := []struct {
+		live    *v1.ConfigMap
+		desired *v1.ConfigMap
+		changed bool
+	}{
+		{live: live, desired: &v1.ConfigMap{Data: map[string]string{"config.yaml": "debug: true"}}},
+		{live: live, desired: &v1.ConfigMap{Data: map[string]string{"config.yaml": "debug: false"}}, changed: true},
+		{live: live, desired: &v1.ConfigMap{}, changed: true},
+		{live: live, desired: &v1.ConfigMap{
+			Data:       map[string]string{"config.yaml": "debug: true"},
+			BinaryData: map[string][]byte{},
+		}},
+		{live: live, desired: &v1.ConfigMap{
+			Data:       map[string]string{"config.yaml": "debug: true"},
+			BinaryData: map[string][]byte{"ca.der": []byte("der")},
+		}, changed: true},
+		// the API server returns no data for data: {}
+		{live: &v1.ConfigMap{}, desired: &v1.ConfigMap{Data: map[string]string{}, BinaryData: map[string][]byte{}}},
+	}
+	for i, tc := range tcs {
+		c.Assert(configMapDataChanged(tc.live, tc.desired), Equals, tc.changed, Commentf("test case %v", i+1))
+	}
+}
+
+func (s *RestartSuite) TestPodSpecReferences(c *C) {
+	spec := v1.PodSpec{
+		Volumes: []v1.Volume{
+			{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "app-config"},
+			}}},
+			{Name: "projected", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{
+				Sources: []v1.VolumeProjection{{Secret: &v1.SecretProjection{
+					LocalObjectReference: v1.LocalObjectReference{Name: "app-tls"},
+				}}},
+			}}},
+		},
+		InitContainers: []v1.Container{{
+			Name: "init",
+			EnvFrom: []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "app-init"},
+			}}},
+		}},
+		Containers: []v1.Container{{
+			Name: "app",
+			Env: []v1.EnvVar{{Name: "PASSWORD", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: "app-password"},
+				Key:                  "password",
+			}}}},
+		}},
+	}
+	tcs := []struct {
+		kind       string
+		name       string
+		references bool
+	}{
+		{kind: KindConfigMap, name: "app-config", references: true},
+		{kind: KindSecret, name: "app-config"},
+		{kind: KindSecret, name: "app-tls", references: true},
+		{kind: KindSecret, name: "app-init", references: true},
+		{kind: KindSecret, name: "app-password", references: true},
+		{kind: KindConfigMap, name: "app-password"},
+		{kind: KindSecret, name: "other"},
+	}
+	for i, tc := range tcs {
+		c.Assert(podSpecReferences(spec, tc.kind, tc.name), Equals, tc.references, Commentf("test case %v", i+1))
+	}
+}
+
+func (s *RestartSuite) TestRolledOut(c *C) {
+	meta := metav1.ObjectMeta{Name: "app", Namespace: DefaultNamespace, Generation: 2}
+	replicas := int32(3)
+	// old pods are ready but the restarted pods are not
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: meta,
+		Status: appsv1.DaemonSetStatus{
+			ObservedGeneration:     2,
+			DesiredNumberScheduled: 3,
+			UpdatedNumberScheduled: 1,
+			NumberReady:            3,
+			NumberAvailable:        3,
+		},
+	}
+	c.Assert(trace.IsCompareFailed(checkDaemonSetRolledOut(ds, 2)), Equals, true)
+	ds.Status.UpdatedNumberScheduled = 3
+	ds.Status.NumberAvailable = 2
+	c.Assert(trace.IsCompareFailed(checkDaemonSetRolledOut(ds, 2)), Equals, true)
+	ds.Status.NumberAvailable = 3
+	c.Assert(checkDaemonSetRolledOut(ds, 2), IsNil)
+	c.Assert(trace.IsCompareFailed(checkDaemonSetRolledOut(ds, 3)), Equals, true)
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: meta,
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status: appsv1.StatefulSetStatus{
+			ObservedGeneration: 2,
+			ReadyReplicas:      3,
+			UpdatedReplicas:    1,
+			CurrentRevision:    "app-1",
+			UpdateRevision:     "app-2",
+		},
+	}
+	c.Assert(trace.IsCompareFailed(checkStatefulSetRolledOut(statefulSet, 2)), Equals, true)
+	statefulSet.Status.UpdatedReplicas = 3
+	c.Assert(trace.IsCompareFailed(checkStatefulSetRolledOut(statefulSet, 2)), Equals, true)
+	statefulSet.Status.CurrentRevision = "app-2"
+	c.Assert(checkStatefulSetRolledOut(statefulSet, 2), IsNil)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: meta,
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+			Replicas:           3,
+			UpdatedReplicas:    3,
+			ReadyReplicas:      3,
+		},
+	}
+	c.Assert(trace.IsCompareFailed(checkReplaced(deployment, 2)), Equals, true)
+	deployment.Status.ObservedGeneration = 2
+	deployment.Status.Replicas = 4
+	deployment.Status.UpdatedReplicas = 1
+	c.Assert(trace.IsCompareFailed(checkReplaced(deployment, 2)), Equals, true)
+	deployment.Status.Replicas = 3
+	deployment.Status.UpdatedReplicas = 3
+	c.Assert(checkReplaced(deployment, 2), IsNil)
+}
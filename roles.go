@@ -34,7 +34,7 @@ func NewRoleControl(config RoleConfig) (*RoleControl, error) {
 		RoleConfig: config,
 		Role:       config.Role,
 		Entry: log.WithFields(log.Fields{
-			"role": formatMeta(config.Role.ObjectMeta),
+			"role": FormatMeta(config.Role.ObjectMeta),
 		}),
 	}, nil
 }
@@ -45,6 +45,8 @@ type RoleConfig struct {
 	Role v1.Role
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *RoleConfig) CheckAndSetDefaults() error {
@@ -65,14 +67,16 @@ type RoleControl struct {
 }
 
 func (c *RoleControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.ObjectMeta))
 
 	err := c.Client.RbacV1().Roles(c.Namespace).Delete(c.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *RoleControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.ObjectMeta))
 
 	roles := c.Client.RbacV1().Roles(c.Namespace)
 	c.UID = ""
@@ -85,12 +89,18 @@ func (c *RoleControl) Upsert(ctx context.Context) error {
 			return trace.Wrap(err)
 		}
 		_, err = roles.Create(&c.Role)
-		return ConvertErrorWithContext(err, "cannot create role %q", formatMeta(c.ObjectMeta))
+		return ConvertErrorWithContext(err, "cannot create role %q", FormatMeta(c.ObjectMeta))
 	}
 	_, err = roles.Update(&c.Role)
 	return ConvertError(err)
 }
 
+// UpsertAndWait upserts the role and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *RoleControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *RoleControl) Status() error {
 	roles := c.Client.RbacV1().Roles(c.Namespace)
 	_, err := roles.Get(c.Name, metav1.GetOptions{})
@@ -107,7 +117,7 @@ func NewClusterRoleControl(config ClusterRoleConfig) (*ClusterRoleControl, error
 		ClusterRoleConfig: config,
 		ClusterRole:       config.Role,
 		Entry: log.WithFields(log.Fields{
-			"cluster_role": formatMeta(config.Role.ObjectMeta),
+			"cluster_role": FormatMeta(config.Role.ObjectMeta),
 		}),
 	}, nil
 }
@@ -118,6 +128,8 @@ type ClusterRoleConfig struct {
 	Role v1.ClusterRole
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *ClusterRoleConfig) CheckAndSetDefaults() error {
@@ -138,14 +150,16 @@ type ClusterRoleControl struct {
 }
 
 func (c *ClusterRoleControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.ObjectMeta))
 
 	err := c.Client.RbacV1().ClusterRoles().Delete(c.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *ClusterRoleControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.ObjectMeta))
 
 	roles := c.Client.RbacV1().ClusterRoles()
 	c.UID = ""
@@ -158,12 +172,18 @@ func (c *ClusterRoleControl) Upsert(ctx context.Context) error {
 			return trace.Wrap(err)
 		}
 		_, err = roles.Create(&c.Role)
-		return ConvertErrorWithContext(err, "cannot create cluster role %q", formatMeta(c.ObjectMeta))
+		return ConvertErrorWithContext(err, "cannot create cluster role %q", FormatMeta(c.ObjectMeta))
 	}
 	_, err = roles.Update(&c.Role)
 	return ConvertError(err)
 }
 
+// UpsertAndWait upserts the cluster role and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *ClusterRoleControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *ClusterRoleControl) Status() error {
 	roles := c.Client.RbacV1().ClusterRoles()
 	_, err := roles.Get(c.Name, metav1.GetOptions{})
@@ -180,7 +200,7 @@ func NewRoleBindingControl(config RoleBindingConfig) (*RoleBindingControl, error
 		RoleBindingConfig: config,
 		RoleBinding:       config.Binding,
 		Entry: log.WithFields(log.Fields{
-			"role_binding": formatMeta(config.Binding.ObjectMeta),
+			"role_binding": FormatMeta(config.Binding.ObjectMeta),
 		}),
 	}, nil
 }
@@ -191,6 +211,8 @@ type RoleBindingConfig struct {
 	Binding v1.RoleBinding
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *RoleBindingConfig) CheckAndSetDefaults() error {
@@ -211,14 +233,16 @@ type RoleBindingControl struct {
 }
 
 func (c *RoleBindingControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.ObjectMeta))
 
 	err := c.Client.RbacV1().RoleBindings(c.Namespace).Delete(c.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *RoleBindingControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.ObjectMeta))
 
 	bindings := c.Client.RbacV1().RoleBindings(c.Namespace)
 	c.UID = ""
@@ -231,12 +255,18 @@ func (c *RoleBindingControl) Upsert(ctx context.Context) error {
 			return trace.Wrap(err)
 		}
 		_, err = bindings.Create(&c.RoleBinding)
-		return ConvertErrorWithContext(err, "cannot create role binding %q", formatMeta(c.ObjectMeta))
+		return ConvertErrorWithContext(err, "cannot create role binding %q", FormatMeta(c.ObjectMeta))
 	}
 	_, err = bindings.Update(&c.RoleBinding)
 	return ConvertError(err)
 }
 
+// UpsertAndWait upserts the role binding and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *RoleBindingControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *RoleBindingControl) Status() error {
 	bindings := c.Client.RbacV1().RoleBindings(c.Namespace)
 	_, err := bindings.Get(c.Name, metav1.GetOptions{})
@@ -253,7 +283,7 @@ func NewClusterRoleBindingControl(config ClusterRoleBindingConfig) (*ClusterRole
 		ClusterRoleBindingConfig: config,
 		ClusterRoleBinding:       config.Binding,
 		Entry: log.WithFields(log.Fields{
-			"cluster_role_binding": formatMeta(config.Binding.ObjectMeta),
+			"cluster_role_binding": FormatMeta(config.Binding.ObjectMeta),
 		}),
 	}, nil
 }
@@ -264,6 +294,8 @@ type ClusterRoleBindingConfig struct {
 	Binding v1.ClusterRoleBinding
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *ClusterRoleBindingConfig) CheckAndSetDefaults() error {
@@ -284,14 +316,16 @@ type ClusterRoleBindingControl struct {
 }
 
 func (c *ClusterRoleBindingControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.ObjectMeta))
 
 	err := c.Client.RbacV1().ClusterRoleBindings().Delete(c.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *ClusterRoleBindingControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.ObjectMeta))
 
 	bindings := c.Client.RbacV1().ClusterRoleBindings()
 	c.UID = ""
@@ -304,12 +338,18 @@ func (c *ClusterRoleBindingControl) Upsert(ctx context.Context) error {
 			return trace.Wrap(err)
 		}
 		_, err = bindings.Create(&c.ClusterRoleBinding)
-		return ConvertErrorWithContext(err, "cannot create cluster role binding %q", formatMeta(c.ObjectMeta))
+		return ConvertErrorWithContext(err, "cannot create cluster role binding %q", FormatMeta(c.ObjectMeta))
 	}
 	_, err = bindings.Update(&c.ClusterRoleBinding)
 	return ConvertError(err)
 }
 
+// UpsertAndWait upserts the cluster role binding and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *ClusterRoleBindingControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *ClusterRoleBindingControl) Status() error {
 	bindings := c.Client.RbacV1().ClusterRoleBindings()
 	_, err := bindings.Get(c.Name, metav1.GetOptions{})
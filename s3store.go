@@ -0,0 +1,385 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// S3StoreConfig is an S3 changeset store configuration
+type S3StoreConfig struct {
+	// Bucket is the name of the bucket
+	Bucket string
+	// Prefix is the key prefix of changeset objects
+	Prefix string
+	// Region is the bucket region
+	Region string
+	// Endpoint is the S3 endpoint, defaults to the AWS endpoint of the region.
+	// Set it to use S3 compatible storage
+	Endpoint string
+	// AccessKeyID is the access key, defaults to AWS_ACCESS_KEY_ID
+	AccessKeyID string
+	// SecretAccessKey is the secret key, defaults to AWS_SECRET_ACCESS_KEY
+	SecretAccessKey string
+	// SessionToken is the optional session token, defaults to AWS_SESSION_TOKEN
+	SessionToken string
+	// Client is the HTTP client, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *S3StoreConfig) CheckAndSetDefaults() error {
+	if c.AccessKeyID == "" {
+		c.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if c.SecretAccessKey == "" {
+		c.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if c.SessionToken == "" {
+		c.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if c.Region == "" {
+		c.Region = os.Getenv("AWS_REGION")
+	}
+	var errors []error
+	if c.Bucket == "" {
+		errors = append(errors, trace.BadParameter("missing parameter Bucket"))
+	}
+	if c.Region == "" {
+		errors = append(errors, trace.BadParameter("missing parameter Region"))
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		errors = append(errors, trace.BadParameter("missing S3 credentials"))
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = fmt.Sprintf("https://s3.%v.amazonaws.com", c.Region)
+	}
+	if c.Client == nil {
+		c.Client = http.DefaultClient
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// NewS3Store returns a changeset store keeping changesets as JSON objects
+// in the S3 bucket, the resource version of a changeset is the object ETag
+func NewS3Store(config S3StoreConfig) (*S3Store, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &S3Store{S3StoreConfig: config, endpoint: endpoint}, nil
+}
+
+// S3Store stores changesets in an S3 bucket
+type S3Store struct {
+	S3StoreConfig
+	endpoint *url.URL
+}
+
+// Init checks that the bucket is accessible
+func (s *S3Store) Init(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil, nil)
+	if err != nil {
+		return trace.Wrap(err, "failed to access bucket %v", s.Bucket)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Create creates a new changeset
+func (s *S3Store) Create(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
+	out := *tr
+	out.ResourceVersion = ""
+	out.CreationTimestamp = metav1.Now()
+	return s.put(ctx, &out, http.Header{"If-None-Match": []string{"*"}})
+}
+
+// Get returns the changeset
+func (s *S3Store) Get(ctx context.Context, namespace, name string) (*ChangesetResource, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(namespace, name), nil, nil, nil)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defer resp.Body.Close()
+	var tr ChangesetResource
+	if err := json.NewDecoder(io.LimitReader(resp.Body, MaxManifestSize)).Decode(&tr); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	tr.ResourceVersion = etag(resp)
+	return &tr, nil
+}
+
+// Update updates the changeset if the object has not been modified
+// since the changeset was read
+func (s *S3Store) Update(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
+	header := http.Header{}
+	if tr.ResourceVersion != "" {
+		header.Set("If-Match", `"`+tr.ResourceVersion+`"`)
+	} else if _, err := s.Get(ctx, tr.Namespace, tr.Name); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return s.put(ctx, tr, header)
+}
+
+// Delete deletes the changeset
+func (s *S3Store) Delete(ctx context.Context, namespace, name string) error {
+	key := s.key(namespace, name)
+	// S3 does not report deletion of missing objects
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	resp.Body.Close()
+	resp, err = s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns changesets in the namespace sorted by name
+func (s *S3Store) List(ctx context.Context, namespace string) (*ChangesetList, error) {
+	prefix := s.key(namespace, "")
+	var names []string
+	var token string
+	for {
+		query := url.Values{"list-type": []string{"2"}, "prefix": []string{prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, prefix)
+			if strings.HasSuffix(name, ".json") && !strings.Contains(name, "/") {
+				names = append(names, strings.TrimSuffix(name, ".json"))
+			}
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	list := &ChangesetList{
+		TypeMeta: metav1.TypeMeta{Kind: "ChangesetList", APIVersion: ChangesetAPIVersion},
+	}
+	for _, name := range names {
+		tr, err := s.Get(ctx, namespace, name)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		list.Items = append(list.Items, *tr)
+	}
+	return list, nil
+}
+
+// listBucketResult is the response of the ListObjectsV2 request
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) put(ctx context.Context, tr *ChangesetResource, header http.Header) (*ChangesetResource, error) {
+	data, err := json.Marshal(tr)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	header.Set("Content-Type", "application/json")
+	resp, err := s.do(ctx, http.MethodPut, s.key(tr.Namespace, tr.Name), nil, header, data)
+	if err != nil {
+		if trace.IsCompareFailed(err) && header.Get("If-None-Match") != "" {
+			return nil, trace.AlreadyExists("changeset %v/%v already exists", tr.Namespace, tr.Name)
+		}
+		return nil, trace.Wrap(err)
+	}
+	resp.Body.Close()
+	out := *tr
+	out.ResourceVersion = etag(resp)
+	return &out, nil
+}
+
+func (s *S3Store) key(namespace, name string) string {
+	key := path.Join(s.Prefix, namespace) + "/"
+	if name != "" {
+		key += name + ".json"
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// do sends the signed request for the object key, or for the bucket
+// if the key is empty, and converts error responses
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = path.Join("/", u.Path, s.Bucket, key)
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	sum := sha256.Sum256(body)
+	signV4(req, hex.EncodeToString(sum[:]), s.S3StoreConfig, time.Now())
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, trace.NotFound("%v not found in bucket %v", key, s.Bucket)
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return nil, trace.CompareFailed("%v has been modified: %s", key, message)
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return nil, trace.AccessDenied("access to %v in bucket %v denied: %s", key, s.Bucket, message)
+	}
+	return nil, trace.BadParameter("%v %v: %v: %s", method, key, resp.Status, message)
+}
+
+func etag(resp *http.Response) string {
+	return strings.Trim(resp.Header.Get("ETag"), `"`)
+}
+
+// signV4 signs the request with AWS signature version 4,
+// all headers set on the request are signed
+func signV4(req *http.Request, payloadHash string, config S3StoreConfig, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%v:%v\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, config.Region, "s3", "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretAccessKey), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsURIEncode encodes all characters except the unreserved ones,
+// slashes are kept unless encodeSlash is set
+func awsURIEncode(s string, encodeSlash bool) string {
+	var out bytes.Buffer
+	for _, b := range []byte(s) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			out.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			out.WriteByte(b)
+		default:
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+	return out.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
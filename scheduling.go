@@ -0,0 +1,158 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"reflect"
+
+	goyaml "github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// ProfileControlPlane schedules workloads on master nodes
+	ProfileControlPlane = "controlplane"
+	// ProfileWorker schedules workloads on nodes other than masters
+	ProfileWorker = "worker"
+	// ProfileGPU allows workloads on tainted GPU nodes
+	ProfileGPU = "gpu"
+	// masterRoleLabel is the label and taint key of master nodes
+	masterRoleLabel = "node-role.kubernetes.io/master"
+	// gpuResource is the extended resource and taint key of GPU nodes
+	gpuResource = "nvidia.com/gpu"
+)
+
+// SchedulingProfile is a transformer that overlays node selector,
+// affinity and tolerations onto pod templates of all workloads
+type SchedulingProfile struct {
+	// Name is the profile name
+	Name string `json:"name"`
+	// NodeSelector labels are merged into the pod node selector
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Affinity replaces node, pod and pod anti-affinity set in the profile
+	Affinity *v1.Affinity `json:"affinity,omitempty"`
+	// Tolerations are added to the pod tolerations
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+}
+
+// BuiltinSchedulingProfiles returns the built-in scheduling profiles
+func BuiltinSchedulingProfiles() []SchedulingProfile {
+	return []SchedulingProfile{
+		{
+			Name:         ProfileControlPlane,
+			NodeSelector: map[string]string{masterRoleLabel: ""},
+			Tolerations: []v1.Toleration{{
+				Key:      masterRoleLabel,
+				Operator: v1.TolerationOpExists,
+				Effect:   v1.TaintEffectNoSchedule,
+			}},
+		},
+		{
+			Name: ProfileWorker,
+			Affinity: &v1.Affinity{
+				NodeAffinity: &v1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+						NodeSelectorTerms: []v1.NodeSelectorTerm{{
+							MatchExpressions: []v1.NodeSelectorRequirement{{
+								Key:      masterRoleLabel,
+								Operator: v1.NodeSelectorOpDoesNotExist,
+							}},
+						}},
+					},
+				},
+			},
+		},
+		{
+			Name: ProfileGPU,
+			Tolerations: []v1.Toleration{{
+				Key:      gpuResource,
+				Operator: v1.TolerationOpExists,
+				Effect:   v1.TaintEffectNoSchedule,
+			}},
+		},
+	}
+}
+
+// FindSchedulingProfile returns the profile with the specified name,
+// profiles override built-in profiles with the same name
+func FindSchedulingProfile(name string, profiles []SchedulingProfile) (*SchedulingProfile, error) {
+	for _, profile := range append(profiles, BuiltinSchedulingProfiles()...) {
+		if profile.Name == name {
+			return &profile, nil
+		}
+	}
+	return nil, trace.NotFound("scheduling profile %q not found", name)
+}
+
+// ParseSchedulingProfiles parses a YAML or JSON list of scheduling profiles
+func ParseSchedulingProfiles(data []byte) ([]SchedulingProfile, error) {
+	var profiles []SchedulingProfile
+	if err := goyaml.Unmarshal(data, &profiles); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return nil, trace.BadParameter("missing scheduling profile name")
+		}
+	}
+	return profiles, nil
+}
+
+// Transform overlays the profile onto pod templates in the manifest stream
+func (p SchedulingProfile) Transform(data []byte) ([]byte, error) {
+	return PodSpecTransformer(p.overlay).Transform(data)
+}
+
+func (p SchedulingProfile) overlay(object *unstructured.Unstructured, spec *v1.PodSpec) error {
+	for key, value := range p.NodeSelector {
+		if spec.NodeSelector == nil {
+			spec.NodeSelector = make(map[string]string)
+		}
+		spec.NodeSelector[key] = value
+	}
+	if p.Affinity != nil {
+		if spec.Affinity == nil {
+			spec.Affinity = &v1.Affinity{}
+		}
+		if p.Affinity.NodeAffinity != nil {
+			spec.Affinity.NodeAffinity = p.Affinity.NodeAffinity.DeepCopy()
+		}
+		if p.Affinity.PodAffinity != nil {
+			spec.Affinity.PodAffinity = p.Affinity.PodAffinity.DeepCopy()
+		}
+		if p.Affinity.PodAntiAffinity != nil {
+			spec.Affinity.PodAntiAffinity = p.Affinity.PodAntiAffinity.DeepCopy()
+		}
+	}
+	for _, toleration := range p.Tolerations {
+		if !hasToleration(spec.Tolerations, toleration) {
+			spec.Tolerations = append(spec.Tolerations, toleration)
+		}
+	}
+	return nil
+}
+
+func hasToleration(tolerations []v1.Toleration, toleration v1.Toleration) bool {
+	for _, t := range tolerations {
+		if reflect.DeepEqual(t, toleration) {
+			return true
+		}
+	}
+	return false
+}
@@ -17,6 +17,7 @@ package rigging
 import (
 	"context"
 	"io"
+	"reflect"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/gravitational/trace"
@@ -45,7 +46,7 @@ func NewSecretControl(config SecretConfig) (*SecretControl, error) {
 		SecretConfig: config,
 		secret:       *rc,
 		Entry: log.WithFields(log.Fields{
-			"secret": formatMeta(rc.ObjectMeta),
+			"secret": FormatMeta(rc.ObjectMeta),
 		}),
 	}, nil
 }
@@ -58,6 +59,10 @@ type SecretConfig struct {
 	Secret *v1.Secret
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// Dependents lists workloads restarted when the secret data changes
+	Dependents []Restarter
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *SecretConfig) CheckAndSetDefaults() error {
@@ -79,20 +84,22 @@ type SecretControl struct {
 }
 
 func (c *SecretControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.secret.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.secret.ObjectMeta))
 
 	err := c.Client.Core().Secrets(c.secret.Namespace).Delete(c.secret.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *SecretControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.secret.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.secret.ObjectMeta))
 
 	secrets := c.Client.Core().Secrets(c.secret.Namespace)
 	c.secret.UID = ""
 	c.secret.SelfLink = ""
 	c.secret.ResourceVersion = ""
-	_, err := secrets.Get(c.secret.Name, metav1.GetOptions{})
+	current, err := secrets.Get(c.secret.Name, metav1.GetOptions{})
 	err = ConvertError(err)
 	if err != nil {
 		if !trace.IsNotFound(err) {
@@ -102,7 +109,20 @@ func (c *SecretControl) Upsert(ctx context.Context) error {
 		return ConvertError(err)
 	}
 	_, err = secrets.Update(&c.secret)
-	return ConvertError(err)
+	if err != nil {
+		return ConvertError(err)
+	}
+	if len(c.Dependents) == 0 || !secretDataChanged(current, &c.secret) {
+		return nil
+	}
+	c.Infof("secret data changed, restarting %v dependent workloads", len(c.Dependents))
+	return trace.Wrap(restartDependents(ctx, c.Dependents))
+}
+
+// UpsertAndWait upserts the secret and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *SecretControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
 }
 
 func (c *SecretControl) Status() error {
@@ -110,3 +130,30 @@ func (c *SecretControl) Status() error {
 	_, err := secrets.Get(c.secret.Name, metav1.GetOptions{})
 	return ConvertError(err)
 }
+
+// secretDataChanged returns true if the data of the secrets differs.
+// The write-only StringData is folded into Data the way the API server does,
+// as the live secret only has Data
+func secretDataChanged(a, b *v1.Secret) bool {
+	dataA, dataB := secretData(a), secretData(b)
+	if len(dataA) == 0 && len(dataB) == 0 {
+		return false
+	}
+	return !reflect.DeepEqual(dataA, dataB)
+}
+
+// secretData returns the data of the secret with StringData merged into it,
+// StringData takes precedence over Data for the same key
+func secretData(secret *v1.Secret) map[string][]byte {
+	if len(secret.StringData) == 0 {
+		return secret.Data
+	}
+	data := make(map[string][]byte, len(secret.Data)+len(secret.StringData))
+	for key, value := range secret.Data {
+		data[key] = value
+	}
+	for key, value := range secret.StringData {
+		data[key] = []byte(value)
+	}
+	return data
+}
@@ -0,0 +1,460 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// maxRequestSize limits the size of the manifests posted to the server
+const maxRequestSize = 32 << 20
+
+// DefaultMaxStatusWait is the default maximum time a status request may wait
+const DefaultMaxStatusWait = 10 * time.Minute
+
+// RequestIDHeader is the request header with the ID of the caller's operation,
+// it is added to the logs of the changeset operations as LogFieldOperation
+const RequestIDHeader = "X-Request-ID"
+
+// Authenticator authenticates the requests to the server
+type Authenticator interface {
+	// Authenticate returns the identity of the caller,
+	// AccessDenied if the request is not authenticated
+	Authenticate(r *http.Request) (string, error)
+}
+
+// Authorizer authorizes the authenticated requests to the server
+type Authorizer interface {
+	// Authorize returns AccessDenied if the caller with the identity may not
+	// perform the action, e.g. ActionApply, on the changesets in the namespace
+	Authorize(identity, namespace string, action ServerAction) error
+}
+
+// AuthorizerFunc is a function implementing Authorizer
+type AuthorizerFunc func(identity, namespace string, action ServerAction) error
+
+// Authorize calls the function
+func (fn AuthorizerFunc) Authorize(identity, namespace string, action ServerAction) error {
+	return fn(identity, namespace, action)
+}
+
+// ServerAction is an operation of the server API authorized by Authorizer
+type ServerAction string
+
+const (
+	// ServerActionList lists changesets
+	ServerActionList ServerAction = "list"
+	// ServerActionGet returns a changeset
+	ServerActionGet ServerAction = "get"
+	// ServerActionDeleteChangeset deletes a changeset
+	ServerActionDeleteChangeset ServerAction = "delete-changeset"
+	// ServerActionStatus checks the status of a changeset
+	ServerActionStatus ServerAction = "status"
+	// ServerActionApply applies the manifests in the context of a changeset
+	ServerActionApply ServerAction = "apply"
+	// ServerActionDelete deletes a resource in the context of a changeset
+	ServerActionDelete ServerAction = "delete"
+	// ServerActionFreeze freezes a changeset
+	ServerActionFreeze ServerAction = "freeze"
+	// ServerActionRevert reverts a changeset
+	ServerActionRevert ServerAction = "revert"
+)
+
+// AuthenticatorFunc is a function implementing Authenticator
+type AuthenticatorFunc func(r *http.Request) (string, error)
+
+// Authenticate calls the function
+func (fn AuthenticatorFunc) Authenticate(r *http.Request) (string, error) {
+	return fn(r)
+}
+
+// TokenAuthenticator authenticates requests with bearer tokens
+type TokenAuthenticator struct {
+	// Tokens maps the accepted tokens to the identities of their holders
+	Tokens map[string]string
+}
+
+// Authenticate returns the identity of the holder of the bearer token of the request
+func (a TokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", trace.AccessDenied("missing bearer token")
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	for candidate, identity := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			return identity, nil
+		}
+	}
+	return "", trace.AccessDenied("invalid bearer token")
+}
+
+// ServerConfig is the configuration of the API server
+type ServerConfig struct {
+	// Changeset performs the changeset operations
+	Changeset *Changeset
+	// Authenticator authenticates the requests, required unless
+	// AllowUnauthenticated is set
+	Authenticator Authenticator
+	// AllowUnauthenticated serves requests without authentication
+	AllowUnauthenticated bool
+	// Authorizer optionally authorizes the actions of the authenticated callers,
+	// all callers may perform all actions if unset
+	Authorizer Authorizer
+	// MaxStatusWait caps the time a status request may wait for the changeset
+	// as the product of its retry attempts and period, DefaultMaxStatusWait if unset
+	MaxStatusWait time.Duration
+	// Locks stores the locks held while changing a changeset, so that concurrent
+	// requests to change the same changeset fail instead of interleaving.
+	// Defaults to the client of Changeset, changes are not serialized if neither is set
+	Locks corev1.ConfigMapsGetter
+}
+
+func (c *ServerConfig) CheckAndSetDefaults() error {
+	var errors []error
+	if c.Changeset == nil {
+		errors = append(errors, trace.BadParameter("missing parameter Changeset"))
+	}
+	if c.Authenticator == nil && !c.AllowUnauthenticated {
+		errors = append(errors, trace.BadParameter("missing parameter Authenticator"))
+	}
+	if c.MaxStatusWait == 0 {
+		c.MaxStatusWait = DefaultMaxStatusWait
+	}
+	if c.Locks == nil && c.Changeset != nil && c.Changeset.Client != nil {
+		c.Locks = c.Changeset.Client.CoreV1()
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// NewServer returns a new HTTP handler exposing changeset operations
+// as a JSON API over HTTP, so tools not written in Go can drive rigging
+// remotely. There is no gRPC API, clients use plain HTTP and JSON:
+//
+//	GET    /healthz
+//	GET    /v1/namespaces/{namespace}/changesets
+//	GET    /v1/namespaces/{namespace}/changesets/{name}
+//	DELETE /v1/namespaces/{namespace}/changesets/{name}
+//	POST   /v1/namespaces/{namespace}/changesets/{name}/apply  - body is the manifest stream
+//	POST   /v1/namespaces/{namespace}/changesets/{name}/delete - body is a DeleteRequest
+//	GET    /v1/namespaces/{namespace}/changesets/{name}/status?retry-attempts=1&retry-period=1s
+//	POST   /v1/namespaces/{namespace}/changesets/{name}/freeze
+//	POST   /v1/namespaces/{namespace}/changesets/{name}/revert
+//
+// Errors are returned as {"message": "..."} with the status code of the error type,
+// apply failures with the ContinueAndReport mode include the outcome of each resource.
+// Requests changing a changeset hold a lock on it for their duration, concurrent
+// requests changing the same changeset fail with 412 Precondition Failed
+func NewServer(config ServerConfig) (*Server, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &Server{ServerConfig: config}, nil
+}
+
+// Server serves the changeset API
+type Server struct {
+	ServerConfig
+	// requests counts the requests to tell apart the lock holders
+	requests int64
+}
+
+// DeleteRequest is the body of the request deleting a resource in the context of a changeset
+type DeleteRequest struct {
+	// Kind is the resource kind
+	Kind string `json:"kind"`
+	// Namespace is the resource namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Name is the resource name
+	Name string `json:"name"`
+	// Cascade deletes the dependent resources, e.g. pods of a daemon set
+	Cascade bool `json:"cascade,omitempty"`
+}
+
+// OutcomeResponse is the outcome of applying a resource returned by the server
+type OutcomeResponse struct {
+	// Ref references the resource
+	Ref ObjectRef `json:"ref"`
+	// Status is the result of applying the resource
+	Status OutcomeStatus `json:"status"`
+	// Error is the failure message, if any
+	Error string `json:"error,omitempty"`
+}
+
+// ServeHTTP authenticates and serves the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	entry := log.WithFields(log.Fields{"method": r.Method, "path": r.URL.Path})
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		// attribute the logs of the changeset operations to the request
+		entry = entry.WithField(LogFieldOperation, id)
+		r = r.WithContext(WithLogFields(r.Context(), log.Fields{LogFieldOperation: id}))
+	}
+	var identity string
+	if s.Authenticator != nil {
+		var err error
+		identity, err = s.Authenticator.Authenticate(r)
+		if err != nil {
+			entry.Warningf("unauthenticated request: %v", err)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		entry = entry.WithField("user", identity)
+		r = r.WithContext(WithInitiator(r.Context(), identity))
+	}
+	entry.Info("serving request")
+	if err := s.serve(w, r, identity); err != nil {
+		entry.Warningf("request failed: %v", trace.DebugReport(err))
+		s.writeFailure(w, err)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, identity string) error {
+	// /v1/namespaces/{namespace}/changesets[/{name}[/{action}]]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 || len(parts) > 6 || parts[0] != "v1" || parts[1] != "namespaces" || parts[3] != "changesets" {
+		return trace.NotFound("%v is not found", r.URL.Path)
+	}
+	ctx := r.Context()
+	namespace := parts[2]
+	if len(parts) == 4 {
+		if r.Method != http.MethodGet {
+			return trace.BadParameter("unsupported method %v", r.Method)
+		}
+		if err := s.authorize(identity, namespace, ServerActionList); err != nil {
+			return trace.Wrap(err)
+		}
+		changesets, err := s.Changeset.List(ctx, namespace)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		writeJSON(w, http.StatusOK, changesets)
+		return nil
+	}
+	name := parts[4]
+	if len(parts) == 5 {
+		switch r.Method {
+		case http.MethodGet:
+			if err := s.authorize(identity, namespace, ServerActionGet); err != nil {
+				return trace.Wrap(err)
+			}
+			changeset, err := s.Changeset.Get(ctx, namespace, name)
+			if err != nil {
+				return trace.Wrap(err)
+			}
+			writeJSON(w, http.StatusOK, changeset)
+			return nil
+		case http.MethodDelete:
+			if err := s.authorize(identity, namespace, ServerActionDeleteChangeset); err != nil {
+				return trace.Wrap(err)
+			}
+			err := s.withLock(ctx, namespace, name, identity, func(ctx context.Context) error {
+				return s.Changeset.Delete(ctx, namespace, name)
+			})
+			if err != nil {
+				return trace.Wrap(err)
+			}
+			writeOK(w)
+			return nil
+		}
+		return trace.BadParameter("unsupported method %v", r.Method)
+	}
+	action := ServerAction(parts[5])
+	switch action {
+	case ServerActionStatus, ServerActionApply, ServerActionDelete, ServerActionFreeze, ServerActionRevert:
+		if err := s.authorize(identity, namespace, action); err != nil {
+			return trace.Wrap(err)
+		}
+	default:
+		return trace.NotFound("%v is not found", r.URL.Path)
+	}
+	if action == ServerActionStatus {
+		if r.Method != http.MethodGet {
+			return trace.BadParameter("unsupported method %v", r.Method)
+		}
+		attempts, period, err := s.statusParams(r)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if err := s.Changeset.Status(ctx, namespace, name, attempts, period); err != nil {
+			return trace.Wrap(err)
+		}
+		writeOK(w)
+		return nil
+	}
+	if r.Method != http.MethodPost {
+		return trace.BadParameter("unsupported method %v", r.Method)
+	}
+	return trace.Wrap(s.withLock(ctx, namespace, name, identity, func(ctx context.Context) error {
+		return s.change(ctx, w, r, namespace, name, action)
+	}))
+}
+
+// change performs the action changing the changeset
+func (s *Server) change(ctx context.Context, w http.ResponseWriter, r *http.Request, namespace, name string, action ServerAction) error {
+	switch action {
+	case ServerActionApply:
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			return trace.BadParameter("failed to read manifests: %v", err)
+		}
+		if err := s.Changeset.Upsert(ctx, namespace, name, data); err != nil {
+			return trace.Wrap(err)
+		}
+		writeOK(w)
+		return nil
+	case ServerActionDelete:
+		var req DeleteRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+			return trace.BadParameter("failed to decode delete request: %v", err)
+		}
+		if req.Kind == "" || req.Name == "" {
+			return trace.BadParameter("missing kind or name of the resource to delete")
+		}
+		err := s.Changeset.DeleteResource(ctx, namespace, name, Namespace(req.Namespace), Ref{Kind: req.Kind, Name: req.Name}, req.Cascade)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		writeOK(w)
+		return nil
+	case ServerActionFreeze:
+		if err := s.Changeset.Freeze(ctx, namespace, name); err != nil {
+			return trace.Wrap(err)
+		}
+		writeOK(w)
+		return nil
+	case ServerActionRevert:
+		if err := s.Changeset.Revert(ctx, namespace, name); err != nil {
+			return trace.Wrap(err)
+		}
+		writeOK(w)
+		return nil
+	}
+	return trace.NotFound("%v is not found", r.URL.Path)
+}
+
+// authorize returns AccessDenied if the caller may not perform the action
+func (s *Server) authorize(identity, namespace string, action ServerAction) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	if err := s.Authorizer.Authorize(identity, namespace, action); err != nil {
+		return trace.AccessDenied("%q may not %v changesets in namespace %v: %v", identity, action, namespace, err)
+	}
+	return nil
+}
+
+// withLock runs fn holding the lock of the changeset, if locks are configured
+func (s *Server) withLock(ctx context.Context, namespace, name, identity string, fn func(context.Context) error) error {
+	if s.Locks == nil {
+		return fn(ctx)
+	}
+	holder := fmt.Sprintf("%v/%v", DefaultLockHolder(), atomic.AddInt64(&s.requests, 1))
+	if identity != "" {
+		holder = fmt.Sprintf("%v for %v", holder, identity)
+	}
+	return trace.Wrap(WithLock(ctx, LockConfig{
+		Name:       "changeset-" + name,
+		Namespace:  namespace,
+		Holder:     holder,
+		Changeset:  name,
+		ConfigMaps: s.Locks,
+	}, fn))
+}
+
+// statusParams parses the retry parameters of the status request.
+// Returns BadParameter if the request would wait longer than MaxStatusWait
+func (s *Server) statusParams(r *http.Request) (attempts int, period time.Duration, err error) {
+	query := r.URL.Query()
+	attempts = 1
+	if value := query.Get("retry-attempts"); value != "" {
+		attempts, err = strconv.Atoi(value)
+		if err != nil {
+			return 0, 0, trace.BadParameter("invalid retry-attempts %q", value)
+		}
+	}
+	if value := query.Get("retry-period"); value != "" {
+		period, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, 0, trace.BadParameter("invalid retry-period %q", value)
+		}
+	}
+	if attempts < 0 || period < 0 {
+		return 0, 0, trace.BadParameter("retry-attempts and retry-period should not be negative")
+	}
+	wait := period
+	if wait == 0 {
+		wait = DefaultRetryPeriod
+	}
+	if time.Duration(attempts)*wait > s.MaxStatusWait {
+		return 0, 0, trace.BadParameter("status wait of %v attempts every %v exceeds the maximum of %v", attempts, wait, s.MaxStatusWait)
+	}
+	return attempts, period, nil
+}
+
+// writeFailure writes the error, apply errors include the outcomes of all resources
+func (s *Server) writeFailure(w http.ResponseWriter, err error) {
+	applyErr, ok := trace.Unwrap(err).(*ApplyError)
+	if !ok {
+		writeError(w, trace.ErrorToCode(err), err)
+		return
+	}
+	outcomes := make([]OutcomeResponse, 0, len(applyErr.Outcomes))
+	for _, outcome := range applyErr.Outcomes {
+		response := OutcomeResponse{Ref: outcome.Ref, Status: outcome.Status}
+		if outcome.Error != nil {
+			response.Error = trace.UserMessage(outcome.Error)
+		}
+		outcomes = append(outcomes, response)
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"message":  applyErr.Error(),
+		"outcomes": outcomes,
+	})
+}
+
+func writeOK(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"message": trace.UserMessage(err)})
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Warningf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type ServerSuite struct{}
+
+var _ = Suite(&ServerSuite{})
+
+func (s *ServerSuite) TestServer(c *C) {
+	store, err := NewFileStore(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(store.Init(context.TODO()), IsNil)
+	cs := &Changeset{ChangesetConfig: ChangesetConfig{Store: store}}
+	_, err = cs.Create(context.TODO(), "apps", "cs1")
+	c.Assert(err, IsNil)
+
+	server, err := NewServer(ServerConfig{
+		Changeset:     cs,
+		Authenticator: TokenAuthenticator{Tokens: map[string]string{"secret": "ci"}},
+	})
+	c.Assert(err, IsNil)
+
+	tcs := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{method: "GET", path: "/healthz", code: http.StatusOK},
+		{method: "GET", path: "/v1/namespaces/apps/changesets", code: http.StatusUnauthorized},
+		{method: "GET", path: "/v1/namespaces/apps/changesets", token: "wrong", code: http.StatusUnauthorized},
+		{method: "GET", path: "/v1/namespaces/apps/changesets", token: "secret", code: http.StatusOK},
+		{method: "GET", path: "/v1/namespaces/apps/changesets/cs1", token: "secret", code: http.StatusOK},
+		{method: "GET", path: "/v1/namespaces/apps/changesets/missing", token: "secret", code: http.StatusNotFound},
+		{method: "PUT", path: "/v1/namespaces/apps/changesets/cs1", token: "secret", code: http.StatusBadRequest},
+		{method: "POST", path: "/v1/namespaces/apps/changesets/cs1/unknown", token: "secret", code: http.StatusNotFound},
+		{method: "GET", path: "/v1/other", token: "secret", code: http.StatusNotFound},
+	}
+	for _, tc := range tcs {
+		comment := Commentf("%v %v", tc.method, tc.path)
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, tc.code, comment)
+	}
+
+	req := httptest.NewRequest("GET", "/v1/namespaces/apps/changesets/cs1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	var changeset ChangesetResource
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &changeset), IsNil)
+	c.Assert(changeset.Name, Equals, "cs1")
+	c.Assert(changeset.Spec.Status, Equals, ChangesetStatusInProgress)
+}
+
+func (s *ServerSuite) TestServerRequiresAuthenticator(c *C) {
+	_, err := NewServer(ServerConfig{Changeset: &Changeset{}})
+	c.Assert(err, NotNil)
+	_, err = NewServer(ServerConfig{Changeset: &Changeset{}, AllowUnauthenticated: true})
+	c.Assert(err, IsNil)
+}
+
+func (s *ServerSuite) TestServerAuthorizer(c *C) {
+	store, err := NewFileStore(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(store.Init(context.TODO()), IsNil)
+	cs := &Changeset{ChangesetConfig: ChangesetConfig{Store: store}}
+	_, err = cs.Create(context.TODO(), "apps", "cs1")
+	c.Assert(err, IsNil)
+
+	server, err := NewServer(ServerConfig{
+		Changeset:     cs,
+		Authenticator: TokenAuthenticator{Tokens: map[string]string{"secret": "ci", "viewer": "dashboard"}},
+		Authorizer: AuthorizerFunc(func(identity, namespace string, action ServerAction) error {
+			if identity == "ci" || action == ServerActionGet || action == ServerActionList {
+				return nil
+			}
+			return trace.AccessDenied("read only")
+		}),
+		MaxStatusWait: time.Minute,
+	})
+	c.Assert(err, IsNil)
+
+	tcs := []struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{method: "GET", path: "/v1/namespaces/apps/changesets/cs1", token: "viewer", code: http.StatusOK},
+		{method: "POST", path: "/v1/namespaces/apps/changesets/cs1/freeze", token: "viewer", code: http.StatusForbidden},
+		{method: "DELETE", path: "/v1/namespaces/apps/changesets/cs1", token: "viewer", code: http.StatusForbidden},
+		{method: "GET", path: "/v1/namespaces/apps/changesets/cs1/status?retry-attempts=1000&retry-period=1s", token: "secret", code: http.StatusBadRequest},
+		{method: "GET", path: "/v1/namespaces/apps/changesets/cs1/status?retry-attempts=-1", token: "secret", code: http.StatusBadRequest},
+	}
+	for _, tc := range tcs {
+		comment := Commentf("%v %v", tc.method, tc.path)
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, tc.code, comment)
+	}
+}
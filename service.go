@@ -45,7 +45,7 @@ func NewServiceControl(config ServiceConfig) (*ServiceControl, error) {
 		ServiceConfig: config,
 		service:       *rc,
 		Entry: log.WithFields(log.Fields{
-			"service": formatMeta(rc.ObjectMeta),
+			"service": FormatMeta(rc.ObjectMeta),
 		}),
 	}, nil
 }
@@ -58,6 +58,8 @@ type ServiceConfig struct {
 	Service *v1.Service
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *ServiceConfig) CheckAndSetDefaults() error {
@@ -79,14 +81,16 @@ type ServiceControl struct {
 }
 
 func (c *ServiceControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.service.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.service.ObjectMeta))
 
 	err := c.Client.Core().Services(c.service.Namespace).Delete(c.service.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *ServiceControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.service.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.service.ObjectMeta))
 
 	services := c.Client.Core().Services(c.service.Namespace)
 	currentService, err := services.Get(c.service.Name, metav1.GetOptions{})
@@ -107,6 +111,12 @@ func (c *ServiceControl) Upsert(ctx context.Context) error {
 	return ConvertError(err)
 }
 
+// UpsertAndWait upserts the service and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *ServiceControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *ServiceControl) Status() error {
 	services := c.Client.Core().Services(c.service.Namespace)
 	_, err := services.Get(c.service.Name, metav1.GetOptions{})
@@ -34,7 +34,7 @@ func NewServiceAccountControl(config ServiceAccountConfig) (*ServiceAccountContr
 		ServiceAccountConfig: config,
 		ServiceAccount:       config.Account,
 		Entry: log.WithFields(log.Fields{
-			"service_account": formatMeta(config.Account.ObjectMeta),
+			"service_account": FormatMeta(config.Account.ObjectMeta),
 		}),
 	}, nil
 }
@@ -45,6 +45,8 @@ type ServiceAccountConfig struct {
 	Account v1.ServiceAccount
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 func (c *ServiceAccountConfig) CheckAndSetDefaults() error {
@@ -65,14 +67,16 @@ type ServiceAccountControl struct {
 }
 
 func (c *ServiceAccountControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("delete %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("delete %v", FormatMeta(c.ObjectMeta))
 
 	err := c.Client.Core().ServiceAccounts(c.Namespace).Delete(c.Name, nil)
 	return ConvertError(err)
 }
 
 func (c *ServiceAccountControl) Upsert(ctx context.Context) error {
-	c.Infof("upsert %v", formatMeta(c.ObjectMeta))
+	c.Entry = contextEntry(ctx, c.Entry)
+	c.Infof("upsert %v", FormatMeta(c.ObjectMeta))
 
 	accounts := c.Client.Core().ServiceAccounts(c.Namespace)
 	c.UID = ""
@@ -91,6 +95,12 @@ func (c *ServiceAccountControl) Upsert(ctx context.Context) error {
 	return ConvertError(err)
 }
 
+// UpsertAndWait upserts the service account and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *ServiceAccountControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 func (c *ServiceAccountControl) Status() error {
 	accounts := c.Client.Core().ServiceAccounts(c.Namespace)
 	_, err := accounts.Get(c.Name, metav1.GetOptions{})
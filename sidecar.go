@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"reflect"
+
+	goyaml "github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// InjectSidecarsAnnotation set to "false" on a workload opts it out of sidecar injection
+const InjectSidecarsAnnotation = "rigging.gravitational.io/inject-sidecars"
+
+// SidecarInjector is a transformer that adds a sidecar container
+// and its volumes to pod templates of the selected workloads
+type SidecarInjector struct {
+	// Container is the sidecar container
+	Container v1.Container `json:"container"`
+	// Volumes lists volumes used by the sidecar
+	Volumes []v1.Volume `json:"volumes,omitempty"`
+	// Selector selects workloads by their labels, empty selector matches all workloads
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+// ParseSidecarInjector parses the YAML or JSON sidecar injector specification
+func ParseSidecarInjector(data []byte) (*SidecarInjector, error) {
+	var injector SidecarInjector
+	if err := goyaml.Unmarshal(data, &injector); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if injector.Container.Name == "" {
+		return nil, trace.BadParameter("missing sidecar container name")
+	}
+	if injector.Container.Image == "" {
+		return nil, trace.BadParameter("missing sidecar container image")
+	}
+	return &injector, nil
+}
+
+// Transform injects the sidecar into pod templates in the manifest stream
+func (s SidecarInjector) Transform(data []byte) ([]byte, error) {
+	return PodSpecTransformer(s.inject).Transform(data)
+}
+
+func (s SidecarInjector) inject(object *unstructured.Unstructured, spec *v1.PodSpec) error {
+	if object.GetAnnotations()[InjectSidecarsAnnotation] == "false" {
+		return nil
+	}
+	if !labels.SelectorFromSet(s.Selector).Matches(labels.Set(object.GetLabels())) {
+		return nil
+	}
+	for _, container := range spec.Containers {
+		if container.Name == s.Container.Name {
+			// already injected or defined in the manifest
+			return nil
+		}
+	}
+	for _, volume := range s.Volumes {
+		existing := findVolume(spec.Volumes, volume.Name)
+		if existing == nil {
+			spec.Volumes = append(spec.Volumes, volume)
+			continue
+		}
+		if !reflect.DeepEqual(existing.VolumeSource, volume.VolumeSource) {
+			return trace.BadParameter("sidecar %v volume %v conflicts with the pod volume", s.Container.Name, volume.Name)
+		}
+	}
+	spec.Containers = append(spec.Containers, s.Container)
+	return nil
+}
+
+func findVolume(volumes []v1.Volume, name string) *v1.Volume {
+	for i := range volumes {
+		if volumes[i].Name == name {
+			return &volumes[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// SignatureExtension is the extension of detached signature files
+const SignatureExtension = ".sig"
+
+// Sign returns a base64-encoded detached signature of the SHA-256 digest
+// of the data. The signature format is compatible with cosign sign-blob
+func Sign(data []byte, key crypto.Signer) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(signature)))
+	base64.StdEncoding.Encode(out, signature)
+	return out, nil
+}
+
+// VerifySignature verifies the base64-encoded detached signature of the data
+// against the trusted public keys. Returns AccessDenied if the signature
+// does not match any of the keys
+func VerifySignature(data, signature []byte, keys []crypto.PublicKey) error {
+	if len(keys) == 0 {
+		return trace.BadParameter("no trusted public keys")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return trace.AccessDenied("malformed signature: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsaVerifyASN1(key, digest[:], decoded) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], decoded) == nil {
+				return nil
+			}
+		}
+	}
+	return trace.AccessDenied("signature does not match any of the trusted keys")
+}
+
+// ecdsaVerifyASN1 verifies the ASN.1 encoded ECDSA signature of the digest
+func ecdsaVerifyASN1(key *ecdsa.PublicKey, digest, signature []byte) bool {
+	var values struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(signature, &values)
+	if err != nil || len(rest) != 0 {
+		return false
+	}
+	return ecdsa.Verify(key, digest, values.R, values.S)
+}
+
+// VerifyFile verifies the file against the detached signature
+// stored next to it with the .sig extension
+func VerifyFile(path string, keys []crypto.PublicKey) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	signature, err := ioutil.ReadFile(path + SignatureExtension)
+	if err != nil {
+		err = trace.ConvertSystemError(err)
+		if trace.IsNotFound(err) {
+			return nil, trace.AccessDenied("%v is not signed", path)
+		}
+		return nil, trace.Wrap(err)
+	}
+	if err := VerifySignature(data, signature, keys); err != nil {
+		return nil, trace.Wrap(err, "failed to verify %v", path)
+	}
+	return data, nil
+}
+
+// VerifyChecksum verifies that the data has the expected hex-encoded SHA-256 checksum
+func VerifyChecksum(data []byte, checksum string) error {
+	digest := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(digest[:]), strings.TrimSpace(checksum)) {
+		return trace.AccessDenied("checksum mismatch: expected %v, got %x", checksum, digest)
+	}
+	return nil
+}
+
+// ParsePublicKeys parses all PEM-encoded public keys in the data
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, trace.BadParameter("no PEM-encoded public keys found")
+	}
+	return keys, nil
+}
+
+// ParsePrivateKey parses a PEM-encoded unencrypted ECDSA or RSA private key
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, trace.BadParameter("no PEM-encoded private key found")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		return key, trace.Wrap(err)
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return key, trace.Wrap(err)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, trace.BadParameter("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	return nil, trace.BadParameter("unsupported private key %q", block.Type)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type SignatureSuite struct{}
+
+var _ = Suite(&SignatureSuite{})
+
+func (s *SignatureSuite) TestSignAndVerify(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	c.Assert(err, IsNil)
+	keys, err := ParsePublicKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	c.Assert(err, IsNil)
+
+	data := []byte("kind: Deployment")
+	signature, err := Sign(data, key)
+	c.Assert(err, IsNil)
+	c.Assert(VerifySignature(data, signature, keys), IsNil)
+
+	err = VerifySignature([]byte("kind: DaemonSet"), signature, keys)
+	c.Assert(trace.IsAccessDenied(err), Equals, true, Commentf("%v", err))
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	err = VerifySignature(data, signature, []crypto.PublicKey{other.Public()})
+	c.Assert(trace.IsAccessDenied(err), Equals, true, Commentf("%v", err))
+}
+
+func (s *SignatureSuite) TestVerifyChecksum(c *C) {
+	data := []byte("kind: Deployment")
+	digest := sha256.Sum256(data)
+	c.Assert(VerifyChecksum(data, hex.EncodeToString(digest[:])), IsNil)
+	c.Assert(trace.IsAccessDenied(VerifyChecksum(data, "00")), Equals, true)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WaitWarning describes a status wait cancelled through the context
+// before the resources have become ready
+type WaitWarning struct {
+	// Reason is the reason of the cancellation, e.g. context deadline exceeded
+	Reason string
+	// Pending lists the resources that have not become ready
+	Pending []string
+	// LastError is the result of the last status check
+	LastError string
+}
+
+// String returns a human readable warning
+func (w WaitWarning) String() string {
+	message := fmt.Sprintf("wait cancelled (%v)", w.Reason)
+	if len(w.Pending) != 0 {
+		message = fmt.Sprintf("%v, still pending: %v", message, strings.Join(w.Pending, ", "))
+	}
+	if w.LastError != "" {
+		message = fmt.Sprintf("%v, last status: %v", message, w.LastError)
+	}
+	return message
+}
+
+// EventSink receives warnings about status waits cancelled through the context,
+// e.g. to show the resources that have never become ready to the user
+type EventSink interface {
+	// Warning is called when a status wait is cancelled
+	Warning(warning WaitWarning)
+}
+
+// EventSinkFunc adapts a function to EventSink
+type EventSinkFunc func(warning WaitWarning)
+
+// Warning calls the function with the warning
+func (f EventSinkFunc) Warning(warning WaitWarning) {
+	f(warning)
+}
+
+// eventSinkContext is the context key of the event sink
+type eventSinkContext struct{}
+
+// pendingContext is the context key of the function listing pending resources
+type pendingContext struct{}
+
+// WithEventSink returns a context whose status waits report to the sink
+// if they are cancelled, by default the warnings are logged
+func WithEventSink(ctx context.Context, sink EventSink) context.Context {
+	return context.WithValue(ctx, eventSinkContext{}, sink)
+}
+
+// withPending returns a context whose status waits report the resources
+// returned by pending as still pending if they are cancelled
+func withPending(ctx context.Context, pending func() []string) context.Context {
+	return context.WithValue(ctx, pendingContext{}, pending)
+}
+
+// warnCancelled reports the status wait cancelled with the context
+// to the event sink of the context, err is the last status
+func warnCancelled(ctx context.Context, err error) {
+	warning := WaitWarning{Reason: fmt.Sprintf("%v", ctx.Err())}
+	if pending, ok := ctx.Value(pendingContext{}).(func() []string); ok {
+		warning.Pending = pending()
+	}
+	if err != nil {
+		warning.LastError = err.Error()
+	}
+	if sink, ok := ctx.Value(eventSinkContext{}).(EventSink); ok {
+		sink.Warning(warning)
+		return
+	}
+	contextLogger(ctx).Warning(warning.String())
+}
+
+// entryResources returns the resources identified by the fields
+// of the control log entry, e.g. "ds default/app"
+func entryResources(fields map[string]interface{}) []string {
+	var resources []string
+	for key, value := range fields {
+		switch key {
+		case LogFieldOperation, LogFieldTenant:
+			continue
+		}
+		resources = append(resources, fmt.Sprintf("%v %v", key, value))
+	}
+	sort.Strings(resources)
+	return resources
+}
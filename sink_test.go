@@ -0,0 +1,57 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type SinkSuite struct{}
+
+var _ = Suite(&SinkSuite{})
+
+func (s *SinkSuite) TestWarnCancelled(c *C) {
+	var warnings []WaitWarning
+	ctx, cancel := context.WithCancel(WithEventSink(context.Background(), EventSinkFunc(func(warning WaitWarning) {
+		warnings = append(warnings, warning)
+	})))
+	ctx = withPending(ctx, func() []string { return []string{"Deployment/default/app"} })
+	err := retry(ctx, 10, time.Minute, func() error {
+		cancel()
+		return trace.CompareFailed("deployment default/app is not ready")
+	})
+	c.Assert(err, NotNil)
+	c.Assert(warnings, DeepEquals, []WaitWarning{{
+		Reason:    "context canceled",
+		Pending:   []string{"Deployment/default/app"},
+		LastError: "deployment default/app is not ready",
+	}})
+	c.Assert(warnings[0].String(), Equals, "wait cancelled (context canceled), still pending: "+
+		"Deployment/default/app, last status: deployment default/app is not ready")
+}
+
+func (s *SinkSuite) TestEntryResources(c *C) {
+	resources := entryResources(map[string]interface{}{
+		"ds":              "default/agent",
+		LogFieldOperation: "req-1",
+	})
+	c.Assert(resources, DeepEquals, []string{"ds default/agent"})
+}
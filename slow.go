@@ -0,0 +1,293 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// DefaultSlowOperationThreshold is the default duration of a status wait
+	// after which a warning with diagnostics is logged
+	DefaultSlowOperationThreshold = 2 * time.Minute
+	// maxDiagnosedPods limits the number of pods included in diagnostics
+	maxDiagnosedPods = 5
+	// maxPodEvents limits the number of recent events reported per pod
+	maxPodEvents = 3
+)
+
+// Diagnoser is implemented by status reporters that can explain
+// why the resource is not ready yet
+type Diagnoser interface {
+	// Diagnose returns human readable causes of the resource not being ready,
+	// e.g. pod conditions and recent events
+	Diagnose() ([]string, error)
+}
+
+// PollStatusWithThreshold polls status periodically like PollStatus and logs
+// a warning with suggested causes every time the wait exceeds the threshold.
+// If the reporter implements Diagnoser, the causes are collected with it.
+// If the reporter implements PendingReporter, attempts made while it is pending are not counted
+func PollStatusWithThreshold(ctx context.Context, retryAttempts int, retryPeriod, threshold time.Duration, reporter StatusReporter) error {
+	if retryAttempts == 0 {
+		retryAttempts = DefaultRetryAttempts
+	}
+	if retryPeriod == 0 {
+		retryPeriod = DefaultRetryPeriod
+	}
+	reporter.Infof("Checking status retryAttempts=%v, retryPeriod=%v", retryAttempts, retryPeriod)
+
+	var diagnose func() ([]string, error)
+	if diagnoser, ok := reporter.(Diagnoser); ok {
+		diagnose = diagnoser.Diagnose
+	}
+	entry := log.NewEntry(log.StandardLogger())
+	if logger, ok := reporter.(fieldLogger); ok {
+		entry = logger.WithFields(log.Fields{})
+	}
+	if _, ok := ctx.Value(pendingContext{}).(func() []string); !ok {
+		ctx = withPending(ctx, func() []string { return entryResources(entry.Data) })
+	}
+	fn := warnSlow(entry, "status check", threshold, diagnose, reporter.Status)
+	if pending, ok := reporter.(PendingReporter); ok {
+		return retryPending(ctx, retryAttempts, retryPeriod, pending.MaxPending(), pending.Pending, fn)
+	}
+	return retry(ctx, retryAttempts, retryPeriod, fn)
+}
+
+// fieldLogger is implemented by reporters embedding a log entry
+type fieldLogger interface {
+	WithFields(log.Fields) *log.Entry
+}
+
+// warnSlow wraps the status function and logs a warning with the causes
+// returned by diagnose each time another threshold has elapsed without success
+func warnSlow(entry *log.Entry, operation string, threshold time.Duration, diagnose func() ([]string, error), fn func() error) func() error {
+	start := time.Now()
+	next := threshold
+	return func() error {
+		err := fn()
+		if err == nil || threshold <= 0 {
+			return err
+		}
+		elapsed := time.Since(start)
+		if elapsed < next {
+			return err
+		}
+		next = elapsed + threshold
+		var causes []string
+		if diagnose != nil {
+			var errDiagnose error
+			causes, errDiagnose = diagnose()
+			if errDiagnose != nil {
+				causes = append(causes, fmt.Sprintf("failed to collect diagnostics: %v", errDiagnose))
+			}
+		}
+		entry.WithFields(log.Fields{
+			"elapsed": elapsed.Round(time.Second).String(),
+			"error":   err.Error(),
+			"causes":  causes,
+		}).Warningf("%v is taking longer than %v", operation, threshold)
+		return err
+	}
+}
+
+// DiagnosePods returns conditions, container states and recent events
+// of the pods matching the selector that are not running and ready
+func DiagnosePods(client *kubernetes.Clientset, namespace string, matchLabels map[string]string) ([]string, error) {
+	pods, err := listPods(client, namespace, matchLabels)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(pods) == 0 {
+		return []string{fmt.Sprintf("no pods matching %v found in namespace %v",
+			labels.Set(matchLabels), namespace)}, nil
+	}
+	var causes []string
+	for i, pod := range notReadyPods(pods) {
+		if i == maxDiagnosedPods {
+			causes = append(causes, "more pods are not ready")
+			break
+		}
+		causes = append(causes, diagnosePod(pod)...)
+		preemption, err := preemptionCauses(client, pod)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		causes = append(causes, preemption...)
+		events, err := podEvents(client, pod)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		causes = append(causes, events...)
+	}
+	return causes, nil
+}
+
+func listPods(client *kubernetes.Clientset, namespace string, matchLabels map[string]string) ([]v1.Pod, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(metav1.ListOptions{
+		LabelSelector: labels.Set(matchLabels).AsSelector().String(),
+	})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	return pods.Items, nil
+}
+
+// notReadyPods returns the pods that are not running and ready
+func notReadyPods(pods []v1.Pod) []v1.Pod {
+	var out []v1.Pod
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodRunning || !isPodReadyConditionTrue(pod.Status) {
+			out = append(out, pod)
+		}
+	}
+	return out
+}
+
+func diagnosePod(pod v1.Pod) []string {
+	meta := FormatMeta(pod.ObjectMeta)
+	var causes []string
+	for _, cond := range pod.Status.Conditions {
+		if cond.Status == v1.ConditionTrue {
+			continue
+		}
+		cause := fmt.Sprintf("pod %v: %v=%v for %v", meta, cond.Type, cond.Status, since(cond.LastTransitionTime))
+		if cond.Reason != "" {
+			cause = fmt.Sprintf("%v: %v %v", cause, cond.Reason, cond.Message)
+		}
+		causes = append(causes, cause)
+	}
+	var statuses []v1.ContainerStatus
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		switch {
+		case status.State.Waiting != nil:
+			causes = append(causes, fmt.Sprintf("pod %v: container %v is waiting: %v %v",
+				meta, status.Name, status.State.Waiting.Reason, status.State.Waiting.Message))
+		case status.RestartCount != 0 && status.LastTerminationState.Terminated != nil:
+			terminated := status.LastTerminationState.Terminated
+			causes = append(causes, fmt.Sprintf("pod %v: container %v restarted %v times, last exit code %v: %v",
+				meta, status.Name, status.RestartCount, terminated.ExitCode, terminated.Reason))
+		}
+	}
+	return causes
+}
+
+// podEvents returns the most recent events of the pod,
+// e.g. "pod kube-system/app: Pulling: pulling image "app:1.0" for 4m0s"
+func podEvents(client *kubernetes.Clientset, pod v1.Pod) ([]string, error) {
+	items, err := recentEvents(client, pod.Namespace, KindPod, pod.Name, maxPodEvents)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var causes []string
+	for _, event := range items {
+		causes = append(causes, fmt.Sprintf("pod %v: %v: %v for %v",
+			FormatMeta(pod.ObjectMeta), event.Reason, event.Message, since(event.FirstTimestamp)))
+	}
+	return causes, nil
+}
+
+// recentEvents returns up to limit most recent events of the object
+// sorted by the last timestamp
+func recentEvents(client *kubernetes.Clientset, namespace, kind, name string, limit int) ([]v1.Event, error) {
+	events, err := client.CoreV1().Events(namespace).List(metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": kind,
+			"involvedObject.name": name,
+		}.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	items := events.Items
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LastTimestamp.Before(&items[j].LastTimestamp)
+	})
+	if len(items) > limit {
+		items = items[len(items)-limit:]
+	}
+	return items, nil
+}
+
+// diagnoseManifest diagnoses pods of the workload described by the manifest,
+// returns no causes for resources without pods
+func diagnoseManifest(client *kubernetes.Clientset, data []byte) ([]string, error) {
+	namespace, selector, err := manifestSelector(data)
+	if err != nil || selector == nil {
+		return nil, trace.Wrap(err)
+	}
+	return DiagnosePods(client, namespace, selector)
+}
+
+// manifestSelector returns the namespace and the labels selecting pods
+// of the workload described by the manifest, nil selector for resources without pods
+func manifestSelector(data []byte) (namespace string, selector map[string]string, err error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	if len(objects) == 0 {
+		return "", nil, nil
+	}
+	object := objects[0]
+	selector, ok := podSelector(object)
+	if !ok {
+		return "", nil, nil
+	}
+	return Namespace(object.GetNamespace()), selector, nil
+}
+
+// podSelector returns the labels selecting pods of the workload
+func podSelector(object *unstructured.Unstructured) (map[string]string, bool) {
+	switch object.GetKind() {
+	case KindReplicationController:
+		selector, ok, _ := unstructured.NestedStringMap(object.Object, "spec", "selector")
+		return selector, ok && len(selector) != 0
+	case KindJob:
+		selector, ok, _ := unstructured.NestedStringMap(object.Object, "spec", "selector", "matchLabels")
+		if ok && len(selector) != 0 {
+			return selector, true
+		}
+		return map[string]string{JobNameLabel: object.GetName()}, true
+	case KindDaemonSet, KindDeployment, KindStatefulSet, KindReplicaSet:
+		selector, ok, _ := unstructured.NestedStringMap(object.Object, "spec", "selector", "matchLabels")
+		return selector, ok && len(selector) != 0
+	}
+	return nil, false
+}
+
+func since(t metav1.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	return time.Since(t.Time).Round(time.Second)
+}
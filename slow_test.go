@@ -0,0 +1,107 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type SlowSuite struct{}
+
+var _ = Suite(&SlowSuite{})
+
+func (s *SlowSuite) TestDiagnosePod(c *C) {
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionTrue},
+				{Type: v1.PodReady, Status: v1.ConditionFalse, Reason: "ContainersNotReady", Message: "containers with unready status: [app]"},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name: "app",
+					State: v1.ContainerState{
+						Waiting: &v1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"},
+					},
+				},
+			},
+		},
+	}
+	causes := diagnosePod(pod)
+	c.Assert(causes, HasLen, 2)
+	c.Assert(causes[0], Matches, "pod default/app: Ready=False for .*: ContainersNotReady .*")
+	c.Assert(causes[1], Equals, "pod default/app: container app is waiting: ImagePullBackOff Back-off pulling image")
+}
+
+func (s *SlowSuite) TestPodSelector(c *C) {
+	objects, err := DecodeObjects([]byte(`apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: web
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+`))
+	c.Assert(err, IsNil)
+	selector, ok := podSelector(objects[0])
+	c.Assert(ok, Equals, true)
+	c.Assert(selector, DeepEquals, map[string]string{"job-name": "migrate"})
+	selector, ok = podSelector(objects[1])
+	c.Assert(ok, Equals, true)
+	c.Assert(selector, DeepEquals, map[string]string{"app": "web"})
+	_, ok = podSelector(objects[2])
+	c.Assert(ok, Equals, false)
+}
+
+func (s *SlowSuite) TestDescribeUsage(c *C) {
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name: "app",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("512Mi")},
+				},
+			}},
+		},
+	}
+	usage := PodUsage{Containers: []ContainerUsage{{
+		Name: "app",
+		Usage: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("250m"),
+			v1.ResourceMemory: resource.MustParse("500Mi"),
+		},
+	}}}
+	c.Assert(describeUsage(pod, usage), DeepEquals, []string{
+		"pod default/app: container app uses cpu 250m, memory 500Mi (97% of limit 512Mi)",
+	})
+}
@@ -0,0 +1,358 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// KindVolumeSnapshot is the kind of the volume snapshots
+	KindVolumeSnapshot = "VolumeSnapshot"
+	// VolumeSnapshotV1alpha1 is the alpha version of the volume snapshot API
+	VolumeSnapshotV1alpha1 = "snapshot.storage.k8s.io/v1alpha1"
+	// VolumeSnapshotV1beta1 is the beta version of the volume snapshot API
+	VolumeSnapshotV1beta1 = "snapshot.storage.k8s.io/v1beta1"
+	// kindPersistentVolumeClaim is the kind of the persistent volume claims
+	kindPersistentVolumeClaim = "PersistentVolumeClaim"
+	// volumeSnapshotGroup is the API group of the volume snapshots
+	volumeSnapshotGroup = "snapshot.storage.k8s.io"
+)
+
+// SnapshotConfig configures the snapshots of the volumes of the workloads
+// taken before the workloads are replaced or deleted by a changeset
+type SnapshotConfig struct {
+	// ClassName is the volume snapshot class, the default class if unset
+	ClassName string
+	// APIVersion is the version of the volume snapshot API, VolumeSnapshotV1alpha1 if unset
+	APIVersion string
+	// Restore replaces the claims with claims provisioned from the snapshots
+	// when the changeset is reverted
+	Restore bool
+}
+
+// CheckAndSetDefaults validates the config and sets defaults
+func (c *SnapshotConfig) CheckAndSetDefaults() error {
+	if c.APIVersion == "" {
+		c.APIVersion = VolumeSnapshotV1alpha1
+	}
+	if c.APIVersion != VolumeSnapshotV1alpha1 && c.APIVersion != VolumeSnapshotV1beta1 {
+		return trace.BadParameter("unsupported volume snapshot API version %q", c.APIVersion)
+	}
+	return nil
+}
+
+// VolumeSnapshotRef references the snapshot of a claim taken before a changeset operation
+type VolumeSnapshotRef struct {
+	// APIVersion is the version of the volume snapshot API
+	APIVersion string `json:"apiVersion"`
+	// Namespace is the namespace of the claim and the snapshot
+	Namespace string `json:"namespace"`
+	// Claim is the name of the claim
+	Claim string `json:"claim"`
+	// Snapshot is the name of the snapshot
+	Snapshot string `json:"snapshot"`
+	// ClaimSpec is the spec of the claim when the snapshot was taken
+	ClaimSpec *SnapshotClaimSpec `json:"claimSpec,omitempty"`
+}
+
+// SnapshotClaimSpec is the spec of the claim recorded with the snapshot,
+// so that the claim is restored as it was even if it was changed or deleted since
+type SnapshotClaimSpec struct {
+	// StorageClassName is the storage class of the claim
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes lists the access modes of the claim
+	AccessModes []v1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	// Size is the requested storage size of the claim
+	Size string `json:"size,omitempty"`
+	// Labels are the labels of the claim
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// newSnapshotClaimSpec returns the spec of the claim to record with its snapshot
+func newSnapshotClaimSpec(claim v1.PersistentVolumeClaim) *SnapshotClaimSpec {
+	spec := &SnapshotClaimSpec{
+		StorageClassName: claim.Spec.StorageClassName,
+		AccessModes:      claim.Spec.AccessModes,
+		Labels:           claim.Labels,
+	}
+	if size, ok := claim.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+		spec.Size = size.String()
+	}
+	return spec
+}
+
+// String returns a human readable reference to the snapshot
+func (r VolumeSnapshotRef) String() string {
+	return fmt.Sprintf("%v/%v of claim %v", r.Namespace, r.Snapshot, r.Claim)
+}
+
+// snapshotVolumes takes snapshots of the bound claims used by the workload,
+// waits until the snapshots are taken and returns the references to them.
+// Does nothing if snapshots are not configured or the object has no claims
+func (cs *Changeset) snapshotVolumes(ctx context.Context, obj metav1.Object) ([]VolumeSnapshotRef, error) {
+	if cs.Snapshots == nil {
+		return nil, nil
+	}
+	names, err := workloadClaims(obj)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	namespace := Namespace(obj.GetNamespace())
+	claims := cs.Client.CoreV1().PersistentVolumeClaims(namespace)
+	suffix := time.Now().UTC().Format("20060102-150405")
+	var refs []VolumeSnapshotRef
+	for _, name := range names {
+		claim, err := claims.Get(name, metav1.GetOptions{})
+		if err != nil {
+			if err := ConvertError(err); trace.IsNotFound(err) {
+				continue
+			}
+			return nil, ConvertError(err)
+		}
+		if claim.Spec.VolumeName == "" {
+			continue
+		}
+		ref := VolumeSnapshotRef{
+			APIVersion: cs.Snapshots.APIVersion,
+			Namespace:  namespace,
+			Claim:      name,
+			Snapshot:   snapshotName(name, suffix),
+			ClaimSpec:  newSnapshotClaimSpec(*claim),
+		}
+		log.Infof("taking snapshot %v", ref)
+		if err := cs.Objects.Apply(ctx, newVolumeSnapshot(ref, cs.Snapshots.ClassName)); err != nil {
+			return nil, trace.Wrap(err, "failed to snapshot claim %v/%v: %v", namespace, name, err)
+		}
+		refs = append(refs, ref)
+	}
+	for _, ref := range refs {
+		err := retry(ctx, DefaultRetryAttempts, DefaultRetryPeriod, func() error {
+			snapshot, err := cs.Objects.Get(ctx, snapshotObjectRef(ref))
+			if err != nil {
+				return trace.Wrap(err)
+			}
+			return checkSnapshotTaken(snapshot)
+		})
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return refs, nil
+}
+
+// snapshotName returns the name of the snapshot of the claim, the name
+// of the claim is truncated so that the name is a valid object name
+func snapshotName(claim, suffix string) string {
+	if max := validation.DNS1123SubdomainMaxLength - len(suffix) - 1; len(claim) > max {
+		claim = claim[:max]
+	}
+	return claim + "-" + suffix
+}
+
+// restoreSnapshots replaces the claims of the operation with claims provisioned
+// from the snapshots taken before the operation. The resource created by the operation
+// is deleted first, so that its pods release the claims
+func (cs *Changeset) restoreSnapshots(ctx context.Context, op ChangesetItem) error {
+	if op.To != "" {
+		ref, err := ManifestRef([]byte(op.To))
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		ref.Namespace = Namespace(ref.Namespace)
+		log.Infof("deleting %v to restore its volumes from snapshots", ref)
+		if err := cs.Objects.Delete(ctx, *ref); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	for _, snapshot := range op.Snapshots {
+		if err := cs.restoreSnapshot(ctx, snapshot); err != nil {
+			return trace.Wrap(err, "failed to restore %v: %v", snapshot, err)
+		}
+	}
+	return nil
+}
+
+// restoreSnapshot recreates the claim with the spec recorded with the snapshot
+// provisioned from the snapshot. Snapshots recorded without the claim spec
+// are restored with the spec of the existing claim
+func (cs *Changeset) restoreSnapshot(ctx context.Context, snapshot VolumeSnapshotRef) error {
+	log.Infof("restoring %v", snapshot)
+	claims := cs.Client.CoreV1().PersistentVolumeClaims(snapshot.Namespace)
+	if snapshot.ClaimSpec == nil {
+		claim, err := claims.Get(snapshot.Claim, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		snapshot.ClaimSpec = newSnapshotClaimSpec(*claim)
+	}
+	restored, err := newRestoredClaim(snapshot)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := ConvertError(claims.Delete(snapshot.Claim, nil)); err != nil && !trace.IsNotFound(err) {
+		return trace.Wrap(err)
+	}
+	err = waitForObjectDeletion(func() error {
+		_, err := claims.Get(snapshot.Claim, metav1.GetOptions{})
+		return ConvertError(err)
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(cs.Objects.Apply(ctx, restored))
+}
+
+// newRestoredClaim returns the claim with the recorded spec provisioned from the snapshot
+func newRestoredClaim(snapshot VolumeSnapshotRef) (*unstructured.Unstructured, error) {
+	spec := snapshot.ClaimSpec
+	if spec.Size == "" {
+		return nil, trace.BadParameter("missing size of claim %v/%v", snapshot.Namespace, snapshot.Claim)
+	}
+	size, err := resource.ParseQuantity(spec.Size)
+	if err != nil {
+		return nil, trace.BadParameter("invalid size %q of claim %v/%v", spec.Size, snapshot.Namespace, snapshot.Claim)
+	}
+	claim := v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshot.Claim,
+			Namespace: snapshot.Namespace,
+			Labels:    spec.Labels,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: spec.StorageClassName,
+			AccessModes:      spec.AccessModes,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+		},
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&claim)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	restored := &unstructured.Unstructured{Object: object}
+	restored.SetAPIVersion(V1)
+	restored.SetKind(kindPersistentVolumeClaim)
+	unstructured.RemoveNestedField(restored.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(restored.Object, "status")
+	err = unstructured.SetNestedMap(restored.Object, map[string]interface{}{
+		"apiGroup": volumeSnapshotGroup,
+		"kind":     KindVolumeSnapshot,
+		"name":     snapshot.Snapshot,
+	}, "spec", "dataSource")
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return restored, nil
+}
+
+// workloadClaims returns the sorted names of the claims used by the pods of the workload
+func workloadClaims(obj metav1.Object) ([]string, error) {
+	seen := make(map[string]bool)
+	if statefulSet, ok := obj.(*appsv1.StatefulSet); ok {
+		for _, claim := range statefulSetClaims(statefulSet) {
+			seen[claim.Name] = true
+		}
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	volumes, _, err := unstructured.NestedSlice(object, "spec", "template", "spec", "volumes")
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, volume := range volumes {
+		fields, ok := volume.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, err := unstructured.NestedString(fields, "persistentVolumeClaim", "claimName")
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if name != "" {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// newVolumeSnapshot returns the volume snapshot of the claim in the version of the API of the reference
+func newVolumeSnapshot(ref VolumeSnapshotRef, className string) *unstructured.Unstructured {
+	spec := make(map[string]interface{})
+	switch ref.APIVersion {
+	case VolumeSnapshotV1alpha1:
+		spec["source"] = map[string]interface{}{"kind": kindPersistentVolumeClaim, "name": ref.Claim}
+		if className != "" {
+			spec["snapshotClassName"] = className
+		}
+	default:
+		spec["source"] = map[string]interface{}{"persistentVolumeClaimName": ref.Claim}
+		if className != "" {
+			spec["volumeSnapshotClassName"] = className
+		}
+	}
+	snapshot := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": ref.APIVersion,
+		"kind":       KindVolumeSnapshot,
+		"spec":       spec,
+	}}
+	snapshot.SetName(ref.Snapshot)
+	snapshot.SetNamespace(ref.Namespace)
+	return snapshot
+}
+
+// snapshotObjectRef returns the object reference to the snapshot
+func snapshotObjectRef(ref VolumeSnapshotRef) ObjectRef {
+	return ObjectRef{APIVersion: ref.APIVersion, Kind: KindVolumeSnapshot, Namespace: ref.Namespace, Name: ref.Snapshot}
+}
+
+// checkSnapshotTaken returns CompareFailed until the snapshot is taken,
+// i.e. the point in time of the snapshot is recorded or it is ready to use,
+// and BadParameter if taking the snapshot failed
+func checkSnapshotTaken(snapshot *unstructured.Unstructured) error {
+	if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found {
+		return trace.BadParameter("snapshot %v/%v failed: %v", snapshot.GetNamespace(), snapshot.GetName(), message)
+	}
+	if ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); ready {
+		return nil
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(snapshot.Object, "status", "creationTime"); found {
+		return nil
+	}
+	return trace.CompareFailed("snapshot %v/%v is not taken yet", snapshot.GetNamespace(), snapshot.GetName())
+}
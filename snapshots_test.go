@@ -0,0 +1,136 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"strings"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type SnapshotsSuite struct{}
+
+var _ = Suite(&SnapshotsSuite{})
+
+func (s *SnapshotsSuite) TestWorkloadClaims(c *C) {
+	claimVolume := func(claim string) v1.Volume {
+		return v1.Volume{Name: claim, VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		}}
+	}
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{Template: v1.PodTemplateSpec{Spec: v1.PodSpec{
+			Volumes: []v1.Volume{claimVolume("uploads"), {Name: "tmp"}, claimVolume("cache")},
+		}}},
+	}
+	claims, err := workloadClaims(deployment)
+	c.Assert(err, IsNil)
+	c.Assert(claims, DeepEquals, []string{"cache", "uploads"})
+
+	replicas := int32(2)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db"},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:             &replicas,
+			Template:             v1.PodTemplateSpec{Spec: v1.PodSpec{Volumes: []v1.Volume{claimVolume("backup")}}},
+			VolumeClaimTemplates: []v1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}},
+		},
+	}
+	claims, err = workloadClaims(statefulSet)
+	c.Assert(err, IsNil)
+	c.Assert(claims, DeepEquals, []string{"backup", "data-db-0", "data-db-1"})
+
+	claims, err = workloadClaims(&v1.ConfigMap{})
+	c.Assert(err, IsNil)
+	c.Assert(claims, HasLen, 0)
+}
+
+func (s *SnapshotsSuite) TestVolumeSnapshot(c *C) {
+	ref := VolumeSnapshotRef{APIVersion: VolumeSnapshotV1alpha1, Namespace: "apps", Claim: "data", Snapshot: "data-20180101-000000"}
+	snapshot := newVolumeSnapshot(ref, "csi")
+	c.Assert(snapshot.GetName(), Equals, ref.Snapshot)
+	c.Assert(snapshot.GetNamespace(), Equals, "apps")
+	c.Assert(snapshot.Object["spec"], DeepEquals, map[string]interface{}{
+		"source":            map[string]interface{}{"kind": "PersistentVolumeClaim", "name": "data"},
+		"snapshotClassName": "csi",
+	})
+
+	ref.APIVersion = VolumeSnapshotV1beta1
+	snapshot = newVolumeSnapshot(ref, "")
+	c.Assert(snapshot.GetAPIVersion(), Equals, VolumeSnapshotV1beta1)
+	c.Assert(snapshot.Object["spec"], DeepEquals, map[string]interface{}{
+		"source": map[string]interface{}{"persistentVolumeClaimName": "data"},
+	})
+
+	c.Assert(trace.IsCompareFailed(checkSnapshotTaken(snapshot)), Equals, true)
+	c.Assert(unstructured.SetNestedField(snapshot.Object, "2018-01-01T00:00:00Z", "status", "creationTime"), IsNil)
+	c.Assert(checkSnapshotTaken(snapshot), IsNil)
+	c.Assert(unstructured.SetNestedField(snapshot.Object, "volume not found", "status", "error", "message"), IsNil)
+	c.Assert(checkSnapshotTaken(snapshot), ErrorMatches, ".*volume not found")
+
+	name := snapshotName(strings.Repeat("a", 300), "20180101-000000")
+	c.Assert(len(name), Equals, 253)
+	c.Assert(strings.HasSuffix(name, "a-20180101-000000"), Equals, true)
+}
+
+func (s *SnapshotsSuite) TestRestoredClaim(c *C) {
+	storageClass := "ssd"
+	claim := v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "apps", Labels: map[string]string{"app": "db"}},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+			VolumeName: "pv-1",
+		},
+	}
+	ref := VolumeSnapshotRef{
+		APIVersion: VolumeSnapshotV1alpha1,
+		Namespace:  "apps",
+		Claim:      "data",
+		Snapshot:   "data-20180101-000000",
+		ClaimSpec:  newSnapshotClaimSpec(claim),
+	}
+	restored, err := newRestoredClaim(ref)
+	c.Assert(err, IsNil)
+	c.Assert(restored.GetKind(), Equals, kindPersistentVolumeClaim)
+	c.Assert(restored.GetName(), Equals, "data")
+	c.Assert(restored.GetNamespace(), Equals, "apps")
+	c.Assert(restored.GetLabels(), DeepEquals, map[string]string{"app": "db"})
+	c.Assert(restored.Object["spec"], DeepEquals, map[string]interface{}{
+		"storageClassName": "ssd",
+		"accessModes":      []interface{}{"ReadWriteOnce"},
+		"resources":        map[string]interface{}{"requests": map[string]interface{}{"storage": "10Gi"}},
+		"dataSource": map[string]interface{}{
+			"apiGroup": volumeSnapshotGroup,
+			"kind":     KindVolumeSnapshot,
+			"name":     "data-20180101-000000",
+		},
+	})
+
+	ref.ClaimSpec.Size = ""
+	_, err = newRestoredClaim(ref)
+	c.Assert(trace.IsBadParameter(err), Equals, true)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+const (
+	// StdinSource is the path that refers to the standard input
+	StdinSource = "-"
+	// MaxManifestSize limits the size of manifests loaded from readers and URLs
+	MaxManifestSize = 64 << 20
+	// DefaultSourceTimeout limits fetching manifests from URLs
+	DefaultSourceTimeout = time.Minute
+)
+
+// Source loads manifests
+type Source interface {
+	// Load returns the manifest stream
+	Load(ctx context.Context) ([]byte, error)
+	// String returns a human readable source description
+	String() string
+}
+
+// NewSource returns a source for the path: "-" refers to the reader,
+// http and https URLs are fetched, anything else is a file, a directory or a glob pattern.
+// Plain HTTP URLs are allowed as the caller has chosen the URL, like kubectl does
+func NewSource(path string, recursive bool, stdin io.Reader) Source {
+	switch {
+	case path == StdinSource:
+		return &ReaderSource{Name: "stdin", Reader: stdin}
+	case strings.HasPrefix(path, "https://"):
+		return &URLSource{URL: path}
+	case strings.HasPrefix(path, "http://"):
+		return &URLSource{URL: path, AllowHTTP: true}
+	}
+	return &FileSource{Path: path, Recursive: recursive}
+}
+
+// FileSource loads manifests from a file, a directory or a glob pattern
+type FileSource struct {
+	// Path is the file, directory or glob pattern
+	Path string
+	// Recursive includes manifests in subdirectories
+	Recursive bool
+}
+
+// Load returns the manifest stream
+func (s *FileSource) Load(ctx context.Context) ([]byte, error) {
+	return ReadManifests(s.Path, s.Recursive)
+}
+
+// String returns the path
+func (s *FileSource) String() string {
+	return s.Path
+}
+
+// ReaderSource loads manifests from a reader
+type ReaderSource struct {
+	// Name describes the reader
+	Name string
+	// Reader is the manifest reader
+	Reader io.Reader
+}
+
+// Load reads the manifest stream
+func (s *ReaderSource) Load(ctx context.Context) ([]byte, error) {
+	if s.Reader == nil {
+		return nil, trace.BadParameter("missing parameter Reader")
+	}
+	return readLimited(s.Reader)
+}
+
+// String returns the reader name
+func (s *ReaderSource) String() string {
+	return s.Name
+}
+
+// URLSource fetches manifests from a URL
+type URLSource struct {
+	// URL is the manifest URL
+	URL string
+	// CACert is the optional PEM-encoded CA certificate bundle
+	// used to verify the server in addition to system roots
+	CACert []byte
+	// Token is the optional bearer token
+	Token string
+	// Username and Password are the optional basic auth credentials
+	Username string
+	Password string
+	// AllowHTTP allows plain HTTP URLs without credentials
+	AllowHTTP bool
+	// Timeout limits the request, defaults to DefaultSourceTimeout
+	Timeout time.Duration
+}
+
+// Load fetches the manifest stream
+func (s *URLSource) Load(ctx context.Context) ([]byte, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, trace.BadParameter("invalid URL %q: %v", s.URL, err)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && s.AllowHTTP) {
+		return nil, trace.BadParameter("unsupported URL scheme %q, use https", u.Scheme)
+	}
+	if u.Scheme == "http" && (s.Token != "" || s.Username != "") {
+		return nil, trace.BadParameter("refusing to send credentials to %v over plain HTTP, use https", u.Host)
+	}
+	client, err := s.client()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	req = req.WithContext(ctx)
+	switch {
+	case s.Token != "":
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", s.Token))
+	case s.Username != "":
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, trace.NotFound("%v not found", s.URL)
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return nil, trace.AccessDenied("access to %v denied: %v", s.URL, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, trace.BadParameter("failed to fetch %v: %v", s.URL, resp.Status)
+	}
+	return readLimited(resp.Body)
+}
+
+// String returns the URL
+func (s *URLSource) String() string {
+	return s.URL
+}
+
+func (s *URLSource) client() (*http.Client, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultSourceTimeout
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if len(s.CACert) != 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(s.CACert) {
+			return nil, trace.BadParameter("no PEM-encoded certificates found in CA")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// GitSource loads manifests from a git repository
+type GitSource struct {
+	GitSourceConfig
+}
+
+// Load clones the repository and returns the manifest stream
+func (s *GitSource) Load(ctx context.Context) ([]byte, error) {
+	return LoadGit(ctx, s.GitSourceConfig)
+}
+
+// String returns the repository, ref and path
+func (s *GitSource) String() string {
+	return fmt.Sprintf("%v@%v:%v", s.URL, s.Ref, s.Path)
+}
+
+// readLimited reads up to MaxManifestSize bytes from the reader
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxManifestSize+1))
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	if len(data) > MaxManifestSize {
+		return nil, trace.LimitExceeded("manifests exceed %v bytes", MaxManifestSize)
+	}
+	return data, nil
+}
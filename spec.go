@@ -62,6 +62,76 @@ type ChangesetItem struct {
 	UID               string    `json:"uid"`
 	Status            string    `json:"status"`
 	CreationTimestamp time.Time `json:"time"`
+	// Key identifies the requested operation, so that re-running an interrupted
+	// apply skips operations that have already completed
+	Key string `json:"key,omitempty"`
+	// CompletionTimestamp is the time the operation has completed
+	CompletionTimestamp *time.Time `json:"completionTime,omitempty"`
+	// ReadyTimestamp is the time the status check of the operation first succeeded
+	ReadyTimestamp *time.Time `json:"readyTime,omitempty"`
+	// StatusHistory lists the statuses of the resource observed during status waits,
+	// recorded if the changeset is configured to record the status history
+	StatusHistory []StatusSnapshot `json:"statusHistory,omitempty"`
+	// Snapshots lists the snapshots of the volumes of the workload
+	// taken before the operation, used to restore the volumes on revert
+	Snapshots []VolumeSnapshotRef `json:"snapshots,omitempty"`
+	// Initiator identifies who initiated the operation
+	Initiator string `json:"initiator,omitempty"`
+}
+
+// MaxStatusSnapshots is the maximum number of status snapshots
+// kept in the status history of a changeset operation
+const MaxStatusSnapshots = 50
+
+// StatusSnapshot is a status of the resource observed during a status wait
+type StatusSnapshot struct {
+	// Time is the time the status was first observed
+	Time time.Time `json:"time"`
+	// Count is the number of consecutive status checks that observed the status
+	Count int `json:"count"`
+	// Ready is set if the status check succeeded
+	Ready bool `json:"ready,omitempty"`
+	// Message is the error returned by the status check
+	Message string `json:"message,omitempty"`
+}
+
+// recordStatus adds the result of the status check to the status history,
+// consecutive checks with the same result are counted in a single snapshot
+// and only the last MaxStatusSnapshots snapshots are kept
+func (c *ChangesetItem) recordStatus(now time.Time, err error) {
+	snapshot := StatusSnapshot{Time: now, Count: 1, Ready: err == nil}
+	if err != nil {
+		snapshot.Message = err.Error()
+	}
+	if len(c.StatusHistory) != 0 {
+		last := &c.StatusHistory[len(c.StatusHistory)-1]
+		if last.Ready == snapshot.Ready && last.Message == snapshot.Message {
+			last.Count++
+			return
+		}
+	}
+	c.StatusHistory = append(c.StatusHistory, snapshot)
+	if len(c.StatusHistory) > MaxStatusSnapshots {
+		c.StatusHistory = c.StatusHistory[len(c.StatusHistory)-MaxStatusSnapshots:]
+	}
+}
+
+// Duration returns the time it took to perform the operation,
+// or 0 if the operation has not completed
+func (c ChangesetItem) Duration() time.Duration {
+	if c.CompletionTimestamp == nil {
+		return 0
+	}
+	return c.CompletionTimestamp.Sub(c.CreationTimestamp)
+}
+
+// WaitDuration returns the time it took the resource to become ready
+// after the operation has completed, or 0 if it is not known yet
+func (c ChangesetItem) WaitDuration() time.Duration {
+	if c.CompletionTimestamp == nil || c.ReadyTimestamp == nil {
+		return 0
+	}
+	return c.ReadyTimestamp.Sub(*c.CompletionTimestamp)
 }
 
 type OperationInfo struct {
@@ -81,13 +151,13 @@ func (o *OperationInfo) Kind() string {
 
 func (o *OperationInfo) String() string {
 	if o.From != nil && o.To == nil {
-		return fmt.Sprintf("delete %v %v", o.From.Kind, formatMeta(o.From.ObjectMeta))
+		return fmt.Sprintf("delete %v %v", o.From.Kind, FormatMeta(o.From.ObjectMeta))
 	}
 	if o.From != nil && o.To != nil {
-		return fmt.Sprintf("update %v %v", o.To.Kind, formatMeta(o.To.ObjectMeta))
+		return fmt.Sprintf("update %v %v", o.To.Kind, FormatMeta(o.To.ObjectMeta))
 	}
 	if o.From == nil && o.To != nil {
-		return fmt.Sprintf("upsert %v %v", o.To.Kind, formatMeta(o.To.ObjectMeta))
+		return fmt.Sprintf("upsert %v %v", o.To.Kind, FormatMeta(o.To.ObjectMeta))
 	}
 	return "invalid operation: both resources cannot be empty"
 }
@@ -18,6 +18,7 @@ package rigging
 
 import (
 	"context"
+	"time"
 
 	"github.com/gravitational/trace"
 	log "github.com/sirupsen/logrus"
@@ -25,6 +26,7 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -38,7 +40,7 @@ func NewStatefulSetControl(config StatefulSetConfig) (*StatefulSetControl, error
 	return &StatefulSetControl{
 		StatefulSetConfig: config,
 		Entry: log.WithFields(log.Fields{
-			"statefulset": formatMeta(config.StatefulSet.ObjectMeta),
+			"statefulset": FormatMeta(config.StatefulSet.ObjectMeta),
 		}),
 	}, nil
 }
@@ -49,6 +51,20 @@ type StatefulSetConfig struct {
 	*appsv1.StatefulSet
 	// Client is k8s client
 	Client *kubernetes.Clientset
+	// Nodes restricts the nodes checked by Status
+	Nodes NodeFilter
+	// StrictReadiness, if set, makes Status also require that no container
+	// of the stateful set pods has restarted within this window
+	StrictReadiness time.Duration
+	// Recorder posts events about the operations on the stateful set,
+	// defaults to a recorder using Client
+	Recorder *EventRecorder
+	// PreserveClaims makes Upsert rebind the volumes of the claims of the replaced
+	// stateful set that the new stateful set would not use, e.g. after a volume claim
+	// template is renamed, to the matching claims of the new stateful set
+	PreserveClaims bool
+	// WaitConfig configures the status wait of UpsertAndWait
+	WaitConfig
 }
 
 // CheckAndSetDefaults validates this configuration object and sets defaults
@@ -60,6 +76,9 @@ func (c *StatefulSetConfig) CheckAndSetDefaults() error {
 	if c.Client == nil {
 		errors = append(errors, trace.BadParameter("missing parameter Client"))
 	}
+	if c.Recorder == nil {
+		c.Recorder = NewEventRecorder(c.Client)
+	}
 	return trace.NewAggregate(errors...)
 }
 
@@ -68,11 +87,15 @@ func (c *StatefulSetConfig) CheckAndSetDefaults() error {
 type StatefulSetControl struct {
 	StatefulSetConfig
 	*log.Entry
+	// restartGeneration is the generation of the stateful set after the last restart
+	restartGeneration int64
 }
 
 // Upsert creates or updates a statefulset resource
-func (c *StatefulSetControl) Upsert(ctx context.Context) error {
-	c.Infof("Upsert %v", formatMeta(c.StatefulSet.ObjectMeta))
+func (c *StatefulSetControl) Upsert(ctx context.Context) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Upsert", err) }()
+	c.Infof("Upsert %v", FormatMeta(c.StatefulSet.ObjectMeta))
 
 	collection := c.Client.AppsV1().StatefulSets(c.StatefulSet.Namespace)
 	currentResource, err := collection.Get(c.StatefulSet.Name, metav1.GetOptions{})
@@ -84,7 +107,14 @@ func (c *StatefulSetControl) Upsert(ctx context.Context) error {
 		currentResource = nil
 	}
 
+	var claims []v1.PersistentVolumeClaim
 	if currentResource != nil {
+		if c.PreserveClaims {
+			claims, err = c.listClaims(currentResource)
+			if err != nil {
+				return trace.Wrap(err)
+			}
+		}
 		control, err := NewStatefulSetControl(StatefulSetConfig{StatefulSet: currentResource, Client: c.Client})
 		if err != nil {
 			return trace.Wrap(err)
@@ -96,12 +126,30 @@ func (c *StatefulSetControl) Upsert(ctx context.Context) error {
 		}
 	}
 
+	// the pods of the replaced stateful set are gone, so their claims can be rebound
+	if err := c.rebindClaims(ctx, claims); err != nil {
+		return trace.Wrap(err)
+	}
+
 	c.Info("Creating new statefulset.")
 	c.StatefulSet.UID = ""
 	c.StatefulSet.SelfLink = ""
 	c.StatefulSet.ResourceVersion = ""
 
-	err = withExponentialBackoff(func() error {
+	var oldUID types.UID
+	if currentResource != nil {
+		oldUID = currentResource.UID
+	}
+	var attempt int
+	err = recreateObject(oldUID, func() (metav1.Object, error) {
+		statefulSet, err := collection.Get(c.StatefulSet.Name, metav1.GetOptions{})
+		return statefulSet, ConvertError(err)
+	}, func() error {
+		attempt++
+		if attempt > 1 {
+			c.Recorder.Eventf(objectReference(APIVersionFor(KindStatefulSet), KindStatefulSet, c.StatefulSet.ObjectMeta), v1.EventTypeWarning, ReasonRetry,
+				"Retrying create, attempt %v", attempt)
+		}
 		_, err = collection.Create(c.StatefulSet)
 		return ConvertError(err)
 	})
@@ -110,7 +158,7 @@ func (c *StatefulSetControl) Upsert(ctx context.Context) error {
 }
 
 // collectPods returns pods created by this statefulset
-func (c *StatefulSetControl) collectPods(statefulSet *appsv1.StatefulSet) (map[string]v1.Pod, error) {
+func (c *StatefulSetControl) collectPods(statefulSet *appsv1.StatefulSet) ([]v1.Pod, error) {
 	var labels map[string]string
 	if statefulSet.Spec.Selector != nil {
 		labels = statefulSet.Spec.Selector.MatchLabels
@@ -122,8 +170,10 @@ func (c *StatefulSetControl) collectPods(statefulSet *appsv1.StatefulSet) (map[s
 }
 
 // Delete deletes this statefulset resource
-func (c *StatefulSetControl) Delete(ctx context.Context, cascade bool) error {
-	c.Infof("Deleting statefulset %v.", formatMeta(c.StatefulSet.ObjectMeta))
+func (c *StatefulSetControl) Delete(ctx context.Context, cascade bool) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Delete", err) }()
+	c.Infof("Deleting statefulset %v.", FormatMeta(c.StatefulSet.ObjectMeta))
 
 	collection := c.Client.AppsV1().StatefulSets(c.StatefulSet.Namespace)
 	currentResource, err := collection.Get(c.StatefulSet.Name, metav1.GetOptions{})
@@ -137,7 +187,7 @@ func (c *StatefulSetControl) Delete(ctx context.Context, cascade bool) error {
 		return trace.Wrap(err)
 	}
 
-	c.Infof("Deleting current statefulset %v.", formatMeta(currentResource.ObjectMeta))
+	c.Infof("Deleting current statefulset %v.", FormatMeta(currentResource.ObjectMeta))
 	deletePolicy := metav1.DeletePropagationForeground
 	err = collection.Delete(c.StatefulSet.Name, &metav1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
@@ -157,10 +207,43 @@ func (c *StatefulSetControl) Delete(ctx context.Context, cascade bool) error {
 	if !cascade {
 		c.Debug("Cascade not set, returning.")
 	}
-	err = deletePods(pods, currentPods, *c.Entry)
+	err = deletePods(ctx, pods, currentPods, *c.Entry)
 	return trace.Wrap(err)
 }
 
+// Restart triggers a rolling restart of the statefulset's pods
+// by updating the restart annotation on the pod template
+func (c *StatefulSetControl) Restart(ctx context.Context) (err error) {
+	c.Entry = contextEntry(ctx, c.Entry)
+	defer func() { c.recordEvent("Restart", err) }()
+	c.Infof("Restarting statefulset %v.", FormatMeta(c.StatefulSet.ObjectMeta))
+
+	collection := c.Client.AppsV1().StatefulSets(c.StatefulSet.Namespace)
+	return RetryOnConflict(ctx, func() error {
+		currentResource, err := collection.Get(c.StatefulSet.Name, metav1.GetOptions{})
+		if err != nil {
+			return ConvertError(err)
+		}
+		setRestartAnnotation(&currentResource.Spec.Template.ObjectMeta)
+		updated, err := collection.Update(currentResource)
+		if err != nil {
+			return ConvertError(err)
+		}
+		c.restartGeneration = updated.Generation
+		return nil
+	})
+}
+
+// RolloutStatus returns CompareFailed until the stateful set controller
+// has observed the last restart and replaced the pods of the stateful set
+func (c *StatefulSetControl) RolloutStatus() error {
+	currentResource, err := c.Client.AppsV1().StatefulSets(c.StatefulSet.Namespace).Get(c.StatefulSet.Name, metav1.GetOptions{})
+	if err != nil {
+		return ConvertError(err)
+	}
+	return trace.Wrap(checkStatefulSetRolledOut(currentResource, c.restartGeneration))
+}
+
 func (c *StatefulSetControl) nodeSelector() labels.Selector {
 	set := make(labels.Set)
 	for key, val := range c.StatefulSet.Spec.Template.Spec.NodeSelector {
@@ -169,6 +252,12 @@ func (c *StatefulSetControl) nodeSelector() labels.Selector {
 	return set.AsSelector()
 }
 
+// UpsertAndWait upserts the stateful set and waits for it to become ready
+// with the attempts, period and timeout of WaitConfig
+func (c *StatefulSetControl) UpsertAndWait(ctx context.Context) error {
+	return c.upsertAndWait(ctx, c)
+}
+
 // Status returns status of pods for this resource
 func (c *StatefulSetControl) Status() error {
 	collection := c.Client.AppsV1().StatefulSets(c.StatefulSet.Namespace)
@@ -187,5 +276,22 @@ func (c *StatefulSetControl) Status() error {
 	if err != nil {
 		return ConvertError(err)
 	}
-	return checkRunning(currentPods, nodes.Items, c.Entry)
+	items := nodesForOS(nodes.Items, currentResource.Spec.Template.Spec, c.Entry)
+	if err := checkNodes(currentPods, items, c.Nodes, c.Entry); err != nil {
+		return trace.Wrap(err)
+	}
+	return checkRecentRestarts(currentPods, c.StrictReadiness)
+}
+
+// recordEvent posts an event about the action on the stateful set
+func (c *StatefulSetControl) recordEvent(action string, err error) {
+	if c.Recorder == nil {
+		return
+	}
+	meta := c.StatefulSet.ObjectMeta
+	current, errGet := c.Client.AppsV1().StatefulSets(meta.Namespace).Get(meta.Name, metav1.GetOptions{})
+	if errGet == nil {
+		meta = current.ObjectMeta
+	}
+	c.Recorder.Record(objectReference(APIVersionFor(KindStatefulSet), KindStatefulSet, meta), action, err)
 }
@@ -0,0 +1,223 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StatusPhase is the phase of a resource reported by ResourceStatus
+type StatusPhase string
+
+const (
+	// StatusReady means that the resource is ready
+	StatusReady StatusPhase = "Ready"
+	// StatusProgressing means that the resource is not ready yet
+	StatusProgressing StatusPhase = "Progressing"
+	// StatusFailed means that the resource has failed, e.g. a job
+	// has exceeded its deadline, and is not expected to become ready
+	StatusFailed StatusPhase = "Failed"
+	// StatusNotFound means that the resource does not exist
+	StatusNotFound StatusPhase = "NotFound"
+)
+
+// ResourceStatus is the structured state of a resource, so that
+// tools can render the progress of the resources becoming ready
+type ResourceStatus struct {
+	// Phase is the phase of the resource
+	Phase StatusPhase `json:"phase"`
+	// Replicas is the desired number of pods of workloads,
+	// the number of completions of jobs
+	Replicas int32 `json:"replicas,omitempty"`
+	// ReadyReplicas is the number of ready pods of workloads,
+	// the number of succeeded pods of jobs
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// Message is the reason the resource is not ready
+	Message string `json:"message,omitempty"`
+	// Conditions are the conditions of the resource status
+	Conditions []StatusCondition `json:"conditions,omitempty"`
+}
+
+// StatusCondition is a condition of the resource status
+type StatusCondition struct {
+	// Type is the condition type, e.g. Available
+	Type string `json:"type"`
+	// Status is True, False or Unknown
+	Status string `json:"status"`
+	// Reason is the reason of the last transition of the condition
+	Reason string `json:"reason,omitempty"`
+	// Message is the human readable details of the last transition
+	Message string `json:"message,omitempty"`
+}
+
+// StatusDescriber is implemented by status reporters that can describe
+// the state of the resource in addition to checking whether it is ready
+type StatusDescriber interface {
+	// DescribeStatus returns the state of the resource, errors are returned
+	// only if the state could not be retrieved
+	DescribeStatus() (*ResourceStatus, error)
+}
+
+// DescribeStatus returns the state of the resource of the status reporter.
+// If the reporter does not implement StatusDescriber, the phase and the
+// message are derived from the result of Status
+func DescribeStatus(reporter StatusReporter) (*ResourceStatus, error) {
+	if describer, ok := reporter.(StatusDescriber); ok {
+		return describer.DescribeStatus()
+	}
+	err := reporter.Status()
+	if trace.IsNotFound(err) {
+		return notFoundStatus(err), nil
+	}
+	var status ResourceStatus
+	status.setPhase(err)
+	return &status, nil
+}
+
+// setPhase sets the phase and the message from the result of the status check
+func (s *ResourceStatus) setPhase(err error) {
+	switch {
+	case err == nil:
+		s.Phase = StatusReady
+		return
+	case trace.IsCompareFailed(err), trace.IsNotFound(err):
+		// pods not found on the nodes are not scheduled yet
+		s.Phase = StatusProgressing
+	default:
+		s.Phase = StatusFailed
+	}
+	s.Message = err.Error()
+}
+
+// notFoundStatus returns the status of the resource that was not found
+func notFoundStatus(err error) *ResourceStatus {
+	return &ResourceStatus{Phase: StatusNotFound, Message: err.Error()}
+}
+
+// describeError returns the status of the resource that failed to be
+// retrieved with the error, the error if it is not NotFound
+func describeError(err error) (*ResourceStatus, error) {
+	err = ConvertError(err)
+	if trace.IsNotFound(err) {
+		return notFoundStatus(err), nil
+	}
+	return nil, trace.Wrap(err)
+}
+
+// DescribeStatus returns the replicas, conditions and phase of the daemon set
+func (c *DSControl) DescribeStatus() (*ResourceStatus, error) {
+	current, err := c.Client.AppsV1().DaemonSets(c.daemonSet.Namespace).Get(c.daemonSet.Name, metav1.GetOptions{})
+	if err != nil {
+		return describeError(err)
+	}
+	status := ResourceStatus{
+		Replicas:      current.Status.DesiredNumberScheduled,
+		ReadyReplicas: current.Status.NumberReady,
+	}
+	for _, cond := range current.Status.Conditions {
+		status.Conditions = append(status.Conditions, StatusCondition{
+			Type: string(cond.Type), Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message,
+		})
+	}
+	status.setPhase(c.Status())
+	return &status, nil
+}
+
+// DescribeStatus returns the replicas, conditions and phase of the deployment
+func (c *DeploymentControl) DescribeStatus() (*ResourceStatus, error) {
+	current, err := c.Client.AppsV1().Deployments(c.deployment.Namespace).Get(c.deployment.Name, metav1.GetOptions{})
+	if err != nil {
+		return describeError(err)
+	}
+	status := ResourceStatus{
+		Replicas:      replicasOrDefault(current.Spec.Replicas),
+		ReadyReplicas: current.Status.ReadyReplicas,
+	}
+	for _, cond := range current.Status.Conditions {
+		status.Conditions = append(status.Conditions, StatusCondition{
+			Type: string(cond.Type), Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message,
+		})
+	}
+	status.setPhase(c.Status())
+	return &status, nil
+}
+
+// DescribeStatus returns the replicas, conditions and phase of the stateful set
+func (c *StatefulSetControl) DescribeStatus() (*ResourceStatus, error) {
+	current, err := c.Client.AppsV1().StatefulSets(c.StatefulSet.Namespace).Get(c.StatefulSet.Name, metav1.GetOptions{})
+	if err != nil {
+		return describeError(err)
+	}
+	status := ResourceStatus{
+		Replicas:      replicasOrDefault(current.Spec.Replicas),
+		ReadyReplicas: current.Status.ReadyReplicas,
+	}
+	for _, cond := range current.Status.Conditions {
+		status.Conditions = append(status.Conditions, StatusCondition{
+			Type: string(cond.Type), Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message,
+		})
+	}
+	status.setPhase(c.Status())
+	return &status, nil
+}
+
+// DescribeStatus returns the replicas, conditions and phase of the replication controller
+func (c *RCControl) DescribeStatus() (*ResourceStatus, error) {
+	current, err := c.Client.CoreV1().ReplicationControllers(c.replicationController.Namespace).Get(c.replicationController.Name, metav1.GetOptions{})
+	if err != nil {
+		return describeError(err)
+	}
+	status := ResourceStatus{
+		Replicas:      replicasOrDefault(current.Spec.Replicas),
+		ReadyReplicas: current.Status.ReadyReplicas,
+	}
+	for _, cond := range current.Status.Conditions {
+		status.Conditions = append(status.Conditions, StatusCondition{
+			Type: string(cond.Type), Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message,
+		})
+	}
+	status.setPhase(c.Status())
+	return &status, nil
+}
+
+// DescribeStatus returns the completions, succeeded pods, conditions and phase of the job
+func (c *JobControl) DescribeStatus() (*ResourceStatus, error) {
+	current, err := c.BatchV1().Jobs(c.Job.Namespace).Get(c.Job.Name, metav1.GetOptions{})
+	if err != nil {
+		return describeError(err)
+	}
+	status := ResourceStatus{
+		Replicas:      replicasOrDefault(current.Spec.Completions),
+		ReadyReplicas: current.Status.Succeeded,
+	}
+	for _, cond := range current.Status.Conditions {
+		status.Conditions = append(status.Conditions, StatusCondition{
+			Type: string(cond.Type), Status: string(cond.Status), Reason: cond.Reason, Message: cond.Message,
+		})
+	}
+	status.setPhase(c.Status())
+	return &status, nil
+}
+
+// replicasOrDefault returns the number of replicas, 1 if unset like the API server defaults it
+func replicasOrDefault(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type StatusSuite struct{}
+
+var _ = Suite(&StatusSuite{})
+
+func (s *StatusSuite) TestDescribeStatus(c *C) {
+	tcs := []struct {
+		err     error
+		phase   StatusPhase
+		message string
+	}{
+		{phase: StatusReady},
+		{err: trace.CompareFailed("1 of 3 replicas available"), phase: StatusProgressing, message: "1 of 3 replicas available"},
+		{err: trace.NotFound("configmap app not found"), phase: StatusNotFound, message: "configmap app not found"},
+		{err: &JobTimeoutError{Job: "default/migrate", Deadline: 60, Message: "deadline exceeded"}, phase: StatusFailed},
+	}
+	for i, tc := range tcs {
+		comment := Commentf("test case %v", i+1)
+		status, err := DescribeStatus(statusFunc(func() error { return tc.err }))
+		c.Assert(err, IsNil, comment)
+		c.Assert(status.Phase, Equals, tc.phase, comment)
+		if tc.message != "" {
+			c.Assert(status.Message, Equals, tc.message, comment)
+		}
+	}
+
+	// pods not found by workload status checks are not scheduled yet
+	var status ResourceStatus
+	status.setPhase(trace.NotFound("no pod found on node node-1"))
+	c.Assert(status.Phase, Equals, StatusProgressing)
+}
+
+// statusFunc is a status reporter returning the result of the function
+type statusFunc func() error
+
+func (f statusFunc) Status() error {
+	return f()
+}
+
+func (f statusFunc) Infof(message string, args ...interface{}) {}
@@ -0,0 +1,408 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	apiextensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	serializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// ChangesetStore persists changesets
+type ChangesetStore interface {
+	// Init prepares the store for use
+	Init(ctx context.Context) error
+	// Create creates a new changeset, returns AlreadyExists if it exists
+	Create(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error)
+	// Get returns the changeset, returns NotFound if it does not exist
+	Get(ctx context.Context, namespace, name string) (*ChangesetResource, error)
+	// Update updates the existing changeset, returns CompareFailed
+	// if the changeset has been modified since it was read
+	Update(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error)
+	// Delete deletes the changeset, returns NotFound if it does not exist
+	Delete(ctx context.Context, namespace, name string) error
+	// List returns changesets in the namespace
+	List(ctx context.Context, namespace string) (*ChangesetList, error)
+}
+
+// NewCRDStore returns a changeset store backed by the changeset
+// custom resource in the cluster
+func NewCRDStore(config *rest.Config) (*CRDStore, error) {
+	if config == nil {
+		return nil, trace.BadParameter("missing parameter Config")
+	}
+	cfg := *config
+	cfg.APIPath = "/apis"
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+
+	cfg.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}
+	cfg.GroupVersion = &schema.GroupVersion{Group: ChangesetGroup, Version: ChangesetVersion}
+
+	clt, err := rest.RESTClientFor(&cfg)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+
+	apiclient, err := apiextensionsclientset.NewForConfig(&cfg)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	return &CRDStore{client: clt, APIExtensionsClient: apiclient}, nil
+}
+
+// CRDStore stores changesets as custom resources in the cluster
+type CRDStore struct {
+	client *rest.RESTClient
+	// APIExtensionsClient is a client for the extensions server
+	APIExtensionsClient *apiextensionsclientset.Clientset
+}
+
+// Init creates the changeset custom resource definition
+func (s *CRDStore) Init(ctx context.Context) error {
+	log.Debug("changeset init")
+
+	// kubernetes 1.8 or newer
+	crd := &apiextensions.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ChangesetResourceName,
+		},
+		Spec: apiextensions.CustomResourceDefinitionSpec{
+			Group:   ChangesetGroup,
+			Version: ChangesetVersion,
+			Scope:   ChangesetScope,
+			Names: apiextensions.CustomResourceDefinitionNames{
+				Kind:     KindChangeset,
+				Plural:   ChangesetPlural,
+				Singular: ChangesetSingular,
+			},
+		},
+	}
+
+	_, err := s.APIExtensionsClient.ApiextensionsV1beta1().CustomResourceDefinitions().Create(crd)
+	err = ConvertError(err)
+	if err != nil {
+		if !trace.IsAlreadyExists(err) {
+			return trace.Wrap(err)
+		}
+	}
+	// wait for the controller to init by trying to list stuff
+	return retry(ctx, 30, time.Second, func() error {
+		_, err := s.List(ctx, DefaultNamespace)
+		return err
+	})
+}
+
+// Create creates a new changeset
+func (s *CRDStore) Create(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
+	data, err := json.Marshal(tr)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var raw runtime.Unknown
+	err = s.client.Post().
+		SubResource("namespaces", tr.Namespace, ChangesetCollection).
+		Body(data).
+		Do().
+		Into(&raw)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	var result ChangesetResource
+	if err := json.Unmarshal(raw.Raw, &result); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &result, nil
+}
+
+// Get returns the changeset
+func (s *CRDStore) Get(ctx context.Context, namespace, name string) (*ChangesetResource, error) {
+	var raw runtime.Unknown
+	err := s.client.Get().
+		SubResource("namespaces", namespace, ChangesetCollection, name).
+		Do().
+		Into(&raw)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	var result ChangesetResource
+	if err := json.Unmarshal(raw.Raw, &result); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &result, nil
+}
+
+// Update updates the changeset
+func (s *CRDStore) Update(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
+	data, err := json.Marshal(tr)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var raw runtime.Unknown
+	err = s.client.Put().
+		SubResource("namespaces", tr.Namespace, ChangesetCollection, tr.Name).
+		Body(data).
+		Do().
+		Into(&raw)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	var result ChangesetResource
+	if err := json.Unmarshal(raw.Raw, &result); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &result, nil
+}
+
+// Delete deletes the changeset
+func (s *CRDStore) Delete(ctx context.Context, namespace, name string) error {
+	var raw runtime.Unknown
+	err := s.client.Delete().
+		SubResource("namespaces", namespace, ChangesetCollection, name).
+		Do().
+		Into(&raw)
+	if err != nil {
+		return ConvertError(err)
+	}
+	return nil
+}
+
+// List returns changesets in the namespace
+func (s *CRDStore) List(ctx context.Context, namespace string) (*ChangesetList, error) {
+	var raw runtime.Unknown
+	err := s.client.Get().
+		SubResource("namespaces", namespace, ChangesetCollection).
+		Do().
+		Into(&raw)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	var result ChangesetList
+	if err := json.Unmarshal(raw.Raw, &result); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &result, nil
+}
+
+// NewFileStore returns a changeset store keeping changesets
+// as JSON files in the directory, one subdirectory per namespace
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, trace.BadParameter("missing parameter dir")
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+// FileStore stores changesets in local files, it can record operations
+// before the cluster API server is available. Concurrent use is safe
+// within a single process only
+type FileStore struct {
+	// Dir is the directory with changesets
+	Dir string
+	mu  sync.Mutex
+}
+
+// Init creates the store directory
+func (s *FileStore) Init(ctx context.Context) error {
+	return trace.ConvertSystemError(os.MkdirAll(s.Dir, 0700))
+}
+
+// Create creates a new changeset
+func (s *FileStore) Create(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.read(tr.Namespace, tr.Name)
+	if err == nil {
+		return nil, trace.AlreadyExists("changeset %v/%v already exists", tr.Namespace, tr.Name)
+	}
+	if !trace.IsNotFound(err) {
+		return nil, trace.Wrap(err)
+	}
+	out := *tr
+	out.UID = ""
+	out.ResourceVersion = "1"
+	out.CreationTimestamp = metav1.Now()
+	if err := s.write(&out); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &out, nil
+}
+
+// Get returns the changeset
+func (s *FileStore) Get(ctx context.Context, namespace, name string) (*ChangesetResource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(namespace, name)
+}
+
+// Update updates the changeset, the resource version of the changeset
+// has to match the stored one
+func (s *FileStore) Update(ctx context.Context, tr *ChangesetResource) (*ChangesetResource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, err := s.read(tr.Namespace, tr.Name)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if tr.ResourceVersion != "" && tr.ResourceVersion != existing.ResourceVersion {
+		return nil, trace.CompareFailed("changeset %v/%v has been modified", tr.Namespace, tr.Name)
+	}
+	version, _ := strconv.Atoi(existing.ResourceVersion)
+	out := *tr
+	out.CreationTimestamp = existing.CreationTimestamp
+	out.ResourceVersion = strconv.Itoa(version + 1)
+	if err := s.write(&out); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &out, nil
+}
+
+// Delete deletes the changeset
+func (s *FileStore) Delete(ctx context.Context, namespace, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, err := s.path(namespace, name)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.ConvertSystemError(os.Remove(path))
+}
+
+// List returns changesets in the namespace sorted by name
+func (s *FileStore) List(ctx context.Context, namespace string) (*ChangesetList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	namespace = Namespace(namespace)
+	if err := checkStoreNamespace(namespace); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(s.Dir, namespace))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, trace.ConvertSystemError(err)
+	}
+	list := &ChangesetList{
+		TypeMeta: metav1.TypeMeta{Kind: "ChangesetList", APIVersion: ChangesetAPIVersion},
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tr, err := s.read(namespace, name)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		list.Items = append(list.Items, *tr)
+	}
+	return list, nil
+}
+
+// path returns the path of the changeset file. Returns BadParameter
+// if the namespace or name are not valid names that could be used
+// to escape the store directory
+func (s *FileStore) path(namespace, name string) (string, error) {
+	namespace = Namespace(namespace)
+	if err := checkStoreNamespace(namespace); err != nil {
+		return "", trace.Wrap(err)
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+		return "", trace.BadParameter("invalid changeset name %q: %v", name, strings.Join(errs, ", "))
+	}
+	return filepath.Join(s.Dir, namespace, name+".json"), nil
+}
+
+// checkStoreNamespace returns BadParameter if the namespace is not a DNS-1123 label
+func checkStoreNamespace(namespace string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+		return trace.BadParameter("invalid changeset namespace %q: %v", namespace, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (s *FileStore) read(namespace, name string) (*ChangesetResource, error) {
+	path, err := s.path(namespace, name)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, trace.NotFound("changeset %v/%v not found", namespace, name)
+		}
+		return nil, trace.ConvertSystemError(err)
+	}
+	var tr ChangesetResource
+	if err := json.Unmarshal(data, &tr); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &tr, nil
+}
+
+// write atomically replaces the changeset file
+func (s *FileStore) write(tr *ChangesetResource) error {
+	data, err := json.MarshalIndent(tr, "", "  ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	path, err := s.path(tr.Namespace, tr.Name)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+tr.Name)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return trace.ConvertSystemError(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return trace.ConvertSystemError(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	return trace.ConvertSystemError(os.Rename(tmp.Name(), path))
+}
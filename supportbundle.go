@@ -0,0 +1,205 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SupportBundleErrorsFile is the name of the file listing
+// the failures to collect parts of the support bundle
+const SupportBundleErrorsFile = "errors.txt"
+
+// CollectSupportBundle writes a tar.gz support bundle to w with the workloads,
+// services and pods matching the selector in the namespaces, all events
+// of the namespaces, the tail of the container logs and the node conditions.
+// Secrets and config maps are not collected. Failures to collect parts
+// of the bundle are listed in SupportBundleErrorsFile instead of failing the collection
+func CollectSupportBundle(ctx context.Context, client *kubernetes.Clientset, namespaces []string, selector labels.Selector, w io.Writer) error {
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	bundle := &supportBundle{files: make(map[string][]byte)}
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	bundle.addJSON("nodes.json", func() interface{} { return nodeSummaries(nodes.Items) }, err)
+	for _, namespace := range namespaces {
+		if err := ctx.Err(); err != nil {
+			return trace.Wrap(err)
+		}
+		bundle.collectNamespace(client, Namespace(namespace), selector)
+	}
+	if len(bundle.errors) != 0 {
+		bundle.files[SupportBundleErrorsFile] = []byte(strings.Join(bundle.errors, "\n") + "\n")
+	}
+	return trace.Wrap(writeTarGz(w, bundle.files))
+}
+
+// supportBundle holds the collected files and collection failures
+type supportBundle struct {
+	files  map[string][]byte
+	errors []string
+}
+
+// addJSON adds the file with the JSON encoded object returned by fn,
+// or records the error if the object could not be retrieved
+func (b *supportBundle) addJSON(name string, fn func() interface{}, err error) {
+	if err != nil {
+		b.errors = append(b.errors, fmt.Sprintf("%v: %v", name, ConvertError(err)))
+		return
+	}
+	data, err := json.MarshalIndent(fn(), "", "  ")
+	if err != nil {
+		b.errors = append(b.errors, fmt.Sprintf("%v: %v", name, err))
+		return
+	}
+	b.files[name] = data
+}
+
+// collectNamespace collects the workloads, pods, events and logs of the namespace
+func (b *supportBundle) collectNamespace(client *kubernetes.Clientset, namespace string, selector labels.Selector) {
+	options := metav1.ListOptions{LabelSelector: selector.String()}
+	file := func(name string) string {
+		return path.Join(namespace, name)
+	}
+	deployments, err := client.AppsV1().Deployments(namespace).List(options)
+	b.addJSON(file("deployments.json"), func() interface{} { return deployments }, err)
+	daemonSets, err := client.AppsV1().DaemonSets(namespace).List(options)
+	b.addJSON(file("daemonsets.json"), func() interface{} { return daemonSets }, err)
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(options)
+	b.addJSON(file("statefulsets.json"), func() interface{} { return statefulSets }, err)
+	jobs, err := client.BatchV1().Jobs(namespace).List(options)
+	b.addJSON(file("jobs.json"), func() interface{} { return jobs }, err)
+	services, err := client.CoreV1().Services(namespace).List(options)
+	b.addJSON(file("services.json"), func() interface{} { return services }, err)
+	events, err := client.CoreV1().Events(namespace).List(metav1.ListOptions{})
+	b.addJSON(file("events.json"), func() interface{} { return sortedEvents(events.Items) }, err)
+	pods, err := client.CoreV1().Pods(namespace).List(options)
+	b.addJSON(file("pods.json"), func() interface{} { return pods }, err)
+	if err != nil {
+		return
+	}
+	for _, pod := range pods.Items {
+		for container, logs := range reportPodLogs(client, pod) {
+			b.files[file(path.Join("logs", pod.Name, container+".log"))] = []byte(logs)
+		}
+	}
+}
+
+// nodeSummary describes the node conditions relevant to scheduling
+type nodeSummary struct {
+	Name          string             `json:"name"`
+	Labels        map[string]string  `json:"labels,omitempty"`
+	Unschedulable bool               `json:"unschedulable,omitempty"`
+	Taints        []v1.Taint         `json:"taints,omitempty"`
+	Conditions    []v1.NodeCondition `json:"conditions"`
+	Capacity      v1.ResourceList    `json:"capacity,omitempty"`
+	Allocatable   v1.ResourceList    `json:"allocatable,omitempty"`
+	NodeInfo      v1.NodeSystemInfo  `json:"nodeInfo"`
+}
+
+func nodeSummaries(nodes []v1.Node) []nodeSummary {
+	summaries := make([]nodeSummary, 0, len(nodes))
+	for _, node := range nodes {
+		summaries = append(summaries, nodeSummary{
+			Name:          node.Name,
+			Labels:        node.Labels,
+			Unschedulable: node.Spec.Unschedulable,
+			Taints:        node.Spec.Taints,
+			Conditions:    node.Status.Conditions,
+			Capacity:      node.Status.Capacity,
+			Allocatable:   node.Status.Allocatable,
+			NodeInfo:      node.Status.NodeInfo,
+		})
+	}
+	return summaries
+}
+
+// sortedEvents returns the events sorted by the last timestamp
+func sortedEvents(events []v1.Event) []v1.Event {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	return events
+}
+
+// SupportBundleWriter is a ReportWriter collecting a support bundle of the
+// namespaces of the failed changeset resources into the directory,
+// named <namespace>-<changeset>-<unix time>-support.tar.gz
+type SupportBundleWriter struct {
+	// Client is k8s client
+	Client *kubernetes.Clientset
+	// Dir is the directory of the support bundles
+	Dir string
+	// Selector selects the collected workloads and pods, defaults to all
+	Selector labels.Selector
+}
+
+// WriteReport collects the support bundle of the failed changeset
+func (w SupportBundleWriter) WriteReport(ctx context.Context, report FailureReport) error {
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	name := strings.TrimSuffix(report.fileName(), ".json") + "-support.tar.gz"
+	f, err := os.Create(filepath.Join(w.Dir, name))
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	namespaces := reportNamespaces(report)
+	log.Infof("collecting support bundle of namespaces %v into %v", namespaces, f.Name())
+	if err := CollectSupportBundle(ctx, w.Client, namespaces, w.Selector, f); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.ConvertSystemError(f.Close())
+}
+
+// reportNamespaces returns the sorted namespaces of the report resources,
+// the default namespace if the report has no namespaced resources
+func reportNamespaces(report FailureReport) []string {
+	seen := make(map[string]bool)
+	var namespaces []string
+	for _, resource := range report.Resources {
+		if IsClusterScoped(resource.Ref.Kind) {
+			continue
+		}
+		namespace := Namespace(resource.Ref.Namespace)
+		if !seen[namespace] {
+			seen[namespace] = true
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		return []string{DefaultNamespace}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+type SupportBundleSuite struct{}
+
+var _ = Suite(&SupportBundleSuite{})
+
+func (s *SupportBundleSuite) TestCollectSupportBundle(c *C) {
+	var selectors []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			w.Write([]byte(`{"items": [{"metadata": {"name": "node-1"},
+				"status": {"conditions": [{"type": "Ready", "status": "False", "reason": "KubeletNotReady"}]}}]}`))
+		case "/apis/apps/v1/namespaces/apps/deployments":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure",
+				"message": "deployments.apps is forbidden", "reason": "Forbidden", "code": 403}`))
+		case "/api/v1/namespaces/apps/pods":
+			selectors = append(selectors, r.URL.Query().Get("labelSelector"))
+			w.Write([]byte(`{"items": [{"metadata": {"name": "web", "namespace": "apps"},
+				"spec": {"containers": [{"name": "app"}]}}]}`))
+		case "/api/v1/namespaces/apps/pods/web/log":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("listening on :8080\n"))
+		default:
+			w.Write([]byte(`{"items": []}`))
+		}
+	}))
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	err = CollectSupportBundle(context.TODO(), client, []string{"apps"}, labels.SelectorFromSet(labels.Set{"app": "web"}), &buf)
+	c.Assert(err, IsNil)
+	c.Assert(selectors, DeepEquals, []string{"app=web"})
+
+	files := make(map[string]string)
+	gz, err := gzip.NewReader(&buf)
+	c.Assert(err, IsNil)
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(reader)
+		c.Assert(err, IsNil)
+		files[header.Name] = string(data)
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	c.Assert(names, HasLen, 9, Commentf("%v", names))
+	c.Assert(files["apps/logs/web/app.log"], Equals, "listening on :8080\n")
+	c.Assert(strings.Contains(files["nodes.json"], "KubeletNotReady"), Equals, true)
+	c.Assert(files[SupportBundleErrorsFile], Matches, "apps/deployments.json: .*forbidden\n")
+	c.Assert(files["apps/deployments.json"], Equals, "")
+}
@@ -0,0 +1,250 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// NamespaceVar is the variable substituted with the target namespace
+// by NamespaceTemplate, e.g. name: ${NAMESPACE}-config
+const NamespaceVar = "NAMESPACE"
+
+// NamespaceTemplate is a transformer that instantiates a manifest stream
+// in the target namespace: it substitutes ${NAMESPACE} references, e.g. in
+// names and labels, moves all namespaced resources to the namespace and
+// binds the roles to the service accounts of the namespace.
+// Cluster-scoped resources whose names do not reference ${NAMESPACE} are
+// shared by all the instances and are dropped, see SharedObjects.
+// Escaped $${NAMESPACE} references are unescaped to ${NAMESPACE},
+// other variables are left intact
+type NamespaceTemplate struct {
+	// Namespace is the target namespace
+	Namespace string
+}
+
+// Transform instantiates the manifest stream in the target namespace
+func (t NamespaceTemplate) Transform(data []byte) ([]byte, error) {
+	if t.Namespace == "" {
+		return nil, trace.BadParameter("missing parameter Namespace")
+	}
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	// the service accounts of the namespaces the template is written for
+	// are moved to the target namespace along with their bindings
+	sourceNamespaces := map[string]bool{"": true}
+	var instance []*unstructured.Unstructured
+	for _, object := range objects {
+		if isSharedObject(object) {
+			log.Debugf("skip shared %v %v", object.GetKind(), object.GetName())
+			continue
+		}
+		if !IsClusterScoped(object.GetKind()) {
+			sourceNamespaces[object.GetNamespace()] = true
+		}
+		instance = append(instance, object)
+	}
+	data, err = EncodeObjects(instance)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	objects, err = DecodeObjects(expandNamespace(data, t.Namespace))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, object := range objects {
+		if !IsClusterScoped(object.GetKind()) {
+			object.SetNamespace(t.Namespace)
+		}
+		switch object.GetKind() {
+		case KindRoleBinding, KindClusterRoleBinding:
+			if err := bindNamespace(object, sourceNamespaces, t.Namespace); err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
+	}
+	return EncodeObjects(objects)
+}
+
+// SharedObjects is a transformer that keeps the cluster-scoped resources of
+// a NamespaceTemplate manifest stream shared by all of its instances,
+// i.e. those whose names do not reference ${NAMESPACE}
+type SharedObjects struct{}
+
+// Transform returns the shared resources of the manifest stream,
+// returns BadParameter if a shared resource references ${NAMESPACE}
+func (SharedObjects) Transform(data []byte) ([]byte, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var shared []*unstructured.Unstructured
+	for _, object := range objects {
+		if !isSharedObject(object) {
+			continue
+		}
+		data, err := object.MarshalJSON()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if referencesNamespace(data) {
+			return nil, trace.BadParameter("%v %v is shared by all namespaces but references ${%v}, reference it in the name to create it per namespace",
+				object.GetKind(), object.GetName(), NamespaceVar)
+		}
+		shared = append(shared, object)
+	}
+	data, err = EncodeObjects(shared)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return expandNamespace(data, ""), nil
+}
+
+// isSharedObject returns true if the resource is cluster-scoped
+// and is not named after the namespace
+func isSharedObject(object *unstructured.Unstructured) bool {
+	return IsClusterScoped(object.GetKind()) && !referencesNamespace([]byte(object.GetName()))
+}
+
+// referencesNamespace returns true if the data has unescaped ${NAMESPACE} references
+func referencesNamespace(data []byte) bool {
+	for _, match := range namespaceVarRegexp.FindAll(data, -1) {
+		if match[1] != '$' {
+			return true
+		}
+	}
+	return false
+}
+
+// expandNamespace substitutes ${NAMESPACE} references with the namespace
+// and unescapes $${NAMESPACE} sequences
+func expandNamespace(data []byte, namespace string) []byte {
+	return namespaceVarRegexp.ReplaceAllFunc(data, func(match []byte) []byte {
+		if match[1] == '$' {
+			return match[1:]
+		}
+		return []byte(namespace)
+	})
+}
+
+// bindNamespace moves the service account subjects of the role binding
+// in the source namespaces to the namespace
+func bindNamespace(object *unstructured.Unstructured, sourceNamespaces map[string]bool, namespace string) error {
+	subjects, ok, err := unstructured.NestedSlice(object.Object, "subjects")
+	if err != nil || !ok {
+		return trace.Wrap(err)
+	}
+	for _, subject := range subjects {
+		fields, ok := subject.(map[string]interface{})
+		if !ok || fields["kind"] != KindServiceAccount {
+			continue
+		}
+		source, _ := fields["namespace"].(string)
+		if sourceNamespaces[source] {
+			fields["namespace"] = namespace
+		}
+	}
+	return trace.Wrap(unstructured.SetNestedSlice(object.Object, subjects, "subjects"))
+}
+
+// namespaceVarRegexp matches ${NAMESPACE} references and escaped $${NAMESPACE} sequences
+var namespaceVarRegexp = regexp.MustCompile(`\$?\$\{` + NamespaceVar + `\}`)
+
+// ApplyToNamespaces applies an instance of the bundle to each of the namespaces,
+// e.g. per tenant, see NamespaceTemplate. Each instance is tracked in a separate
+// changeset named after the changeset of the bundle and the namespace and is
+// reverted independently if it fails. The namespaces should exist.
+// The cluster-scoped resources shared by the instances, see SharedObjects,
+// are applied first in the changeset of the bundle, so that reverting
+// an instance does not delete them for the other namespaces.
+// Namespaces are applied in order, failures are aggregated
+func (a *BundleArchive) ApplyToNamespaces(ctx context.Context, config ApplyConfig, namespaces []string) error {
+	if len(namespaces) == 0 {
+		return trace.BadParameter("missing parameter namespaces")
+	}
+	if config.Changeset == nil {
+		return trace.BadParameter("missing parameter Changeset")
+	}
+	if config.ChangesetName == "" {
+		config.ChangesetName = a.Metadata.Name + "-" + a.Metadata.Version
+	}
+	if err := a.applyShared(ctx, config); err != nil {
+		return trace.Wrap(err, "failed to apply the resources shared by namespaces")
+	}
+	var errors []error
+	for _, namespace := range namespaces {
+		if err := ctx.Err(); err != nil {
+			return trace.Wrap(err)
+		}
+		instance := config
+		instance.ChangesetName = config.ChangesetName + "-" + namespace
+		instance.Transformers = append([]Transformer{NamespaceTemplate{Namespace: namespace}}, config.Transformers...)
+		log.Infof("apply %v:%v to namespace %v", a.Metadata.Name, a.Metadata.Version, namespace)
+		if err := a.Apply(ctx, instance); err != nil {
+			errors = append(errors, trace.Wrap(err, "namespace %v: %v", namespace, err))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// applyShared applies the cluster-scoped resources of all waves and hooks
+// shared by the namespace instances of the bundle in the changeset of the bundle,
+// the changeset is reverted if they fail to apply
+func (a *BundleArchive) applyShared(ctx context.Context, config ApplyConfig) error {
+	var buf bytes.Buffer
+	for _, wave := range a.Waves() {
+		buf.Write(a.Manifests(wave))
+	}
+	for _, hook := range a.Metadata.Hooks {
+		buf.WriteString("---\n")
+		buf.Write(a.Files[hook.File])
+		buf.WriteString("\n")
+	}
+	data, err := Transform(buf.Bytes(), append([]Transformer{SharedObjects{}}, config.Transformers...)...)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data, ok, err := filterManifests(config.Filter, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if !ok || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	namespace := Namespace(config.ChangesetNamespace)
+	log.Infof("apply %v:%v shared by namespaces", a.Metadata.Name, a.Metadata.Version)
+	err = config.Changeset.Upsert(ctx, namespace, config.ChangesetName, data)
+	if err == nil {
+		err = config.Changeset.Status(ctx, namespace, config.ChangesetName, config.RetryAttempts, config.RetryPeriod)
+	}
+	if err == nil {
+		return trace.Wrap(config.Changeset.Freeze(ctx, namespace, config.ChangesetName))
+	}
+	errRevert := config.Changeset.Revert(ctx, namespace, config.ChangesetName)
+	if errRevert != nil {
+		log.Errorf("failed to revert: %v", trace.DebugReport(errRevert))
+	}
+	return trace.Wrap(&RollbackError{Err: err, RevertErr: errRevert})
+}
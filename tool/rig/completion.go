@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/gravitational/rigging"
+
+	"github.com/gravitational/trace"
+	"gopkg.in/alecthomas/kingpin.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// completionTimeout limits the cluster queries made while completing
+// a command line, so a slow or unreachable cluster does not block the shell
+const completionTimeout = 3 * time.Second
+
+// Supported shells for the completion command
+const (
+	shellBash = "bash"
+	shellZsh  = "zsh"
+	shellFish = "fish"
+)
+
+// fishCompletionTemplate is the fish counterpart of the bash and zsh scripts
+// generated by kingpin, it passes the words typed so far to --completion-bash
+const fishCompletionTemplate = `
+function __%[1]s_complete
+    set -l args (commandline -opc)
+    set -e args[1]
+    %[1]s --completion-bash $args (commandline -ct) 2>/dev/null
+end
+complete -c %[1]s -f -a '(__%[1]s_complete)'
+`
+
+// writeCompletionScript writes the completion script of the application for the shell
+func writeCompletionScript(w io.Writer, app *kingpin.Application, shell string) error {
+	var template string
+	switch shell {
+	case shellBash:
+		template = kingpin.BashCompletionTemplate
+	case shellZsh:
+		template = kingpin.ZshCompletionTemplate
+	case shellFish:
+		_, err := fmt.Fprintf(w, fishCompletionTemplate, app.Name)
+		return trace.Wrap(err)
+	default:
+		return trace.BadParameter("unsupported shell %q, supported are %v, %v and %v", shell, shellBash, shellZsh, shellFish)
+	}
+	context, err := app.ParseContext(nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	app.Writer(w)
+	return trace.Wrap(app.UsageForContextWithTemplate(context, 2, template))
+}
+
+// completer queries the cluster for changeset, namespace and resource names
+// offered as completions. The flags are read when hints are requested,
+// after kingpin has parsed the words typed so far
+type completer struct {
+	kubeConfig *string
+	proxy      *string
+	caFile     *string
+	serverName *string
+	namespace  *string
+}
+
+// client returns a client with a short timeout for completion queries
+func (c *completer) client() (*kubernetes.Clientset, *rest.Config, error) {
+	config, err := rigging.NewRESTConfig(rigging.ClientConfig{
+		KubeConfig:    *c.kubeConfig,
+		Proxy:         *c.proxy,
+		CAFile:        *c.caFile,
+		TLSServerName: *c.serverName,
+	})
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	config.Timeout = completionTimeout
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	return client, config, nil
+}
+
+// changesets returns names of changesets in the namespace of the changesets.
+// As with all hints, errors are not reported and result in no completions
+func (c *completer) changesets() []string {
+	names, err := c.changesetNames()
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+func (c *completer) changesetNames() ([]string, error) {
+	_, config, err := c.client()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	store, err := rigging.NewCRDStore(config)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	list, err := store.List(ctx, *c.namespace)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// namespaces returns names of the cluster namespaces
+func (c *completer) namespaces() []string {
+	client, _, err := c.client()
+	if err != nil {
+		return nil
+	}
+	list, err := client.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resources returns a hint action listing resources in the namespace
+// in form of references accepted by rigging.ParseRef, e.g. deployments/app.
+// Changesets are included if withChangesets is set
+func (c *completer) resources(namespace *string, withChangesets bool) kingpin.HintAction {
+	return func() []string {
+		var refs []string
+		if withChangesets {
+			names, _ := c.changesetNames()
+			refs = append(refs, resourceRefs("cs", names)...)
+		}
+		client, _, err := c.client()
+		if err != nil {
+			return refs
+		}
+		for _, lister := range resourceListers(client, *namespace) {
+			names, err := lister.list()
+			if err != nil {
+				continue
+			}
+			refs = append(refs, resourceRefs(lister.shortcut, names)...)
+		}
+		return refs
+	}
+}
+
+// resourceLister lists names of resources of a kind
+type resourceLister struct {
+	// shortcut is the kind shortcut accepted by rigging.ParseShortcut
+	shortcut string
+	list     func() ([]string, error)
+}
+
+// resourceListers returns listers of resources rig operates on in the namespace
+func resourceListers(client *kubernetes.Clientset, namespace string) []resourceLister {
+	options := metav1.ListOptions{}
+	return []resourceLister{
+		{shortcut: "deployments", list: func() ([]string, error) {
+			list, err := client.AppsV1().Deployments(namespace).List(options)
+			if err != nil {
+				return nil, rigging.ConvertError(err)
+			}
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, item.Name)
+			}
+			return names, nil
+		}},
+		{shortcut: "ds", list: func() ([]string, error) {
+			list, err := client.AppsV1().DaemonSets(namespace).List(options)
+			if err != nil {
+				return nil, rigging.ConvertError(err)
+			}
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, item.Name)
+			}
+			return names, nil
+		}},
+		{shortcut: "jobs", list: func() ([]string, error) {
+			list, err := client.BatchV1().Jobs(namespace).List(options)
+			if err != nil {
+				return nil, rigging.ConvertError(err)
+			}
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, item.Name)
+			}
+			return names, nil
+		}},
+		{shortcut: "svc", list: func() ([]string, error) {
+			list, err := client.CoreV1().Services(namespace).List(options)
+			if err != nil {
+				return nil, rigging.ConvertError(err)
+			}
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, item.Name)
+			}
+			return names, nil
+		}},
+		{shortcut: "configmaps", list: func() ([]string, error) {
+			list, err := client.CoreV1().ConfigMaps(namespace).List(options)
+			if err != nil {
+				return nil, rigging.ConvertError(err)
+			}
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, item.Name)
+			}
+			return names, nil
+		}},
+		{shortcut: "secrets", list: func() ([]string, error) {
+			list, err := client.CoreV1().Secrets(namespace).List(options)
+			if err != nil {
+				return nil, rigging.ConvertError(err)
+			}
+			var names []string
+			for _, item := range list.Items {
+				names = append(names, item.Name)
+			}
+			return names, nil
+		}},
+	}
+}
+
+// resourceRefs returns sorted references to the named resources of a kind
+func resourceRefs(shortcut string, names []string) []string {
+	refs := make([]string, 0, len(names))
+	for _, name := range names {
+		refs = append(refs, fmt.Sprintf("%v/%v", shortcut, name))
+	}
+	sort.Strings(refs)
+	return refs
+}
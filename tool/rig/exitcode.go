@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/gravitational/rigging"
+
+	"github.com/gravitational/trace"
+)
+
+// Exit codes of rig, scripts and CI pipelines can branch on them
+// instead of parsing the output
+const (
+	// exitCodeValidation is returned when the command line, the manifests
+	// or the resources are rejected as invalid
+	exitCodeValidation = 2
+	// exitCodeApplyFailed is returned when resources failed to apply
+	exitCodeApplyFailed = 3
+	// exitCodeStatusTimeout is returned when resources have not become
+	// ready before the status check gave up
+	exitCodeStatusTimeout = 4
+	// exitCodeRolledBack is returned when the changeset has been reverted
+	// after a failure
+	exitCodeRolledBack = 5
+	// exitCodeRollbackFailed is returned when reverting the changeset failed
+	exitCodeRollbackFailed = 6
+	// exitCodeError is returned for all other errors
+	exitCodeError = 255
+)
+
+// applyCommands lists commands that create, update or delete resources
+var applyCommands = map[string]bool{
+	"upsert":       true,
+	"configmap":    true,
+	"delete":       true,
+	"cs uninstall": true,
+	"bundle apply": true,
+	"restart":      true,
+	"reconcile":    true,
+}
+
+// statusCommands lists commands that wait for resources to become ready
+var statusCommands = map[string]bool{
+	"status": true,
+	"wait":   true,
+}
+
+// exitCode returns the exit code for the error returned by the command
+func exitCode(command string, err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case rigging.IsRollbackFailed(err):
+		return exitCodeRollbackFailed
+	case rigging.IsRolledBack(err):
+		return exitCodeRolledBack
+	case trace.IsBadParameter(err):
+		return exitCodeValidation
+	case command == "revert":
+		return exitCodeRollbackFailed
+	case statusCommands[command]:
+		return exitCodeStatusTimeout
+	case applyCommands[command]:
+		return exitCodeApplyFailed
+	}
+	return exitCodeError
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log/syslog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -20,76 +26,196 @@ import (
 	"github.com/gravitational/trace"
 	log "github.com/sirupsen/logrus"
 	logrusSyslog "github.com/sirupsen/logrus/hooks/syslog"
+	"golang.org/x/crypto/ssh/terminal"
 	"gopkg.in/alecthomas/kingpin.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 func main() {
 	var quiet bool
-	if err := run(&quiet); err != nil {
+	var command string
+	if err := run(&quiet, &command); err != nil {
 		log.Error(trace.DebugReport(err))
 		if !quiet {
 			fmt.Printf("ERROR: %v\n", err.Error())
 		}
-		os.Exit(255)
+		os.Exit(exitCode(command, err))
 	}
 }
 
-func run(quiet *bool) error {
+func run(quiet *bool, command *string) error {
+	hints := &completer{}
 	var (
 		app = kingpin.New("rig", "CLI utility to simplify K8s updates")
 
 		debug      = app.Flag("debug", "turn on debug logging").Bool()
 		kubeConfig = app.Flag("kubeconfig", "path to kubeconfig").Default(filepath.Join(os.Getenv("HOME"), ".kube", "config")).String()
-		namespace  = app.Flag("namespace", "Namespace of the changesets").Default(rigging.DefaultNamespace).String()
+		namespace  = app.Flag("namespace", "Namespace of the changesets").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).String()
+		proxy      = app.Flag("proxy", "URL of the HTTPS proxy the API server is reached through, overrides HTTPS_PROXY").String()
+		caFile     = app.Flag("certificate-authority", "path to the CA bundle used to verify the API server").String()
+		serverName = app.Flag("tls-server-name", "server name used to verify the API server certificate").String()
+		initiator  = app.Flag("initiator", "identity recorded as the initiator of changesets and operations, defaults to the kubeconfig user or service account").Envar(initiatorEnvVar).String()
+		monitoring = app.Flag("monitoring-readiness", "wait for Prometheus Operator resources, e.g. ServiceMonitor or Prometheus, to be reconciled when checking readiness").Bool()
 
-		cupsert          = app.Command("upsert", "Upsert resources in the context of a changeset")
-		cupsertChangeset = Ref(cupsert.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).Required())
-		cupsertFile      = cupsert.Flag("file", "file with new resource spec").Short('f').Required().String()
+		cupsert           = app.Command("upsert", "Upsert resources in the context of a changeset")
+		cupsertChangeset  = Ref(cupsert.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
+		cupsertFile       = cupsert.Flag("file", "file, directory, glob pattern or https URL with new resource specs, - for stdin").Short('f').Required().String()
+		cupsertRecursive  = cupsert.Flag("recursive", "include manifests in subdirectories of the file directory").Short('R').Bool()
+		cupsertTransform  = transformations(cupsert)
+		cupsertFilter     = filters(cupsert)
+		cupsertFailure    = failurePolicy(cupsert)
+		cupsertLock       = locking(cupsert)
+		cupsertOwner      = ownership(cupsert)
+		cupsertPrereqs    = prerequisites(cupsert)
+		cupsertImages     = imageChecks(cupsert)
+		cupsertSource     = sources(cupsert)
+		cupsertPreflight  = cupsert.Flag("preflight", "dry-run create pods from workload templates before applying").Bool()
+		cupsertRecreate   = cupsert.Flag("auto-recreate", "replace pods of deployments whose host ports or ReadWriteOnce volumes prevent a rolling update with Recreate strategy").Bool()
+		cupsertClaims     = cupsert.Flag("preserve-claims", "rebind volumes of claims that replaced stateful sets would no longer use to their matching new claims").Bool()
+		cupsertDependents = cupsert.Flag("restart-dependents", "restart workloads that reference updated config maps and secrets whose data changed").Bool()
+		cupsertVerify     = verification(cupsert)
+		cupsertSnapshots  = snapshots(cupsert)
+		cupsertConfirm    = confirmation(cupsert)
 
 		cupsertConfigMap          = app.Command("configmap", "Upsert configmap in the context of a changeset")
-		cupsertConfigMapChangeset = Ref(cupsertConfigMap.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).Required())
+		cupsertConfigMapChangeset = Ref(cupsertConfigMap.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
 		cupsertConfigMapName      = cupsertConfigMap.Arg("name", "ConfigMap name").Required().String()
-		cupsertConfigMapNamespace = cupsertConfigMap.Flag("resource-namespace", "ConfigMap namespace").Default(rigging.DefaultNamespace).String()
+		cupsertConfigMapNamespace = cupsertConfigMap.Flag("resource-namespace", "ConfigMap namespace").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).String()
 		cupsertConfigMapFiles     = cupsertConfigMap.Flag("from-file", "files or directories with contents").Strings()
 		cupsertConfigMapLiterals  = cupsertConfigMap.Flag("from-literal", "literals in form of key=val").Strings()
 
 		cstatus         = app.Command("status", "Check status of all operations in a changeset")
-		cstatusResource = Ref(cstatus.Arg("resource", "resource to check, e.g. tx/tx1").Required())
+		cstatusResource = Ref(cstatus.Arg("resource", "resource to check, e.g. tx/tx1").HintAction(hints.resources(namespace, true)).Required())
 		cstatusAttempts = cstatus.Flag("retry-attempts", "file with new daemon set spec").Default("1").Int()
 		cstatusPeriod   = cstatus.Flag("retry-period", "file with new daemon set spec").Default(fmt.Sprintf("%v", rigging.DefaultRetryPeriod)).Duration()
+		cstatusSlow     = cstatus.Flag("slow-threshold", "duration of the status wait after which a warning with suggested causes is logged").Default(fmt.Sprintf("%v", rigging.DefaultSlowOperationThreshold)).Duration()
+		cstatusDebug    = cstatus.Flag("debug-image", "image of the ephemeral debug container attached to stuck pods of a changeset").String()
+		cstatusReport   = cstatus.Flag("report-dir", "directory to write a failure report to if the changeset fails").String()
+		cstatusStrict   = cstatus.Flag("strict-readiness", "also require that no container has restarted within this window, e.g. 2m").Duration()
+		cstatusPending  = cstatus.Flag("pending-timeout", "how long job pods may wait to be scheduled or for image pulls without consuming retry attempts").Default(rigging.DefaultPendingTimeout.String()).Duration()
+		cstatusMetrics  = cstatus.Flag("sample-metrics", "include CPU and memory usage of pods that are not ready from metrics-server in warnings and errors").Bool()
+		cstatusReportCM = cstatus.Flag("report-configmap", "store a failure report in a config map in the changeset namespace if the changeset fails").Bool()
+		cstatusSupport  = cstatus.Flag("support-bundle-dir", "directory to collect a support bundle of the changeset namespaces to if the changeset fails").String()
+		cstatusNodes    = cstatus.Flag("node", "check daemon set pods on this node only, can be repeated").Strings()
+		cstatusSelector = cstatus.Flag("node-selector", "check daemon set pods on nodes matching this label selector only").String()
+		cstatusExpected = cstatus.Flag("expected-nodes", "succeed once daemon set pods are ready on this many nodes").Int()
+		cstatusHistory  = cstatus.Flag("record-history", "record the statuses observed during the wait in the changeset").Bool()
 
 		cget          = app.Command("get", "Display one or many changesets")
-		cgetChangeset = Ref(cget.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar))
+		cgetChangeset = Ref(cget.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets))
 		cgetOut       = cget.Flag("output", "output type, one of 'yaml' or 'text'").Short('o').Default("").String()
 
 		ctr = app.Command("cs", "low level operations on changesets")
 
 		ctrDelete          = ctr.Command("delete", "Delete a changeset by name")
 		ctrDeleteForce     = ctrDelete.Flag("force", "Ignore error if resource is not found").Bool()
-		ctrDeleteChangeset = Ref(ctrDelete.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar).Required())
+		ctrDeleteChangeset = Ref(ctrDelete.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
+
+		ctrUninstall          = ctr.Command("uninstall", "Delete the resources created by a changeset, dependents first, and wait for them to be deleted")
+		ctrUninstallChangeset = Ref(ctrUninstall.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
+		ctrUninstallConfirm   = confirmation(ctrUninstall)
+
+		ctrReport          = ctr.Command("report", "Write a JSON report with specs, statuses, events and pod logs of changeset resources that are not ready")
+		ctrReportChangeset = Ref(ctrReport.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
+		ctrReportOutput    = ctrReport.Flag("output", "report file, defaults to stdout").Short('o').String()
 
 		crevert          = app.Command("revert", "Revert the changeset")
-		crevertChangeset = Ref(crevert.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).Required())
+		crevertChangeset = Ref(crevert.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
+		crevertRestore   = crevert.Flag("restore-snapshots", "restore volumes from the snapshots taken before the reverted operations").Bool()
 
 		cfreeze          = app.Command("freeze", "Freeze the changeset")
-		cfreezeChangeset = Ref(cfreeze.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).Required())
+		cfreezeChangeset = Ref(cfreeze.Flag("changeset", "name of the changeset").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
 
 		cdelete                  = app.Command("delete", "Delete a resource in a context of a changeset")
 		cdeleteForce             = cdelete.Flag("force", "Ignore error if resource is not found").Bool()
 		cdeleteCascade           = cdelete.Flag("cascade", "Delete sub resouces, e.g. Pods for Daemonset").Default("true").Bool()
-		cdeleteChangeset         = Ref(cdelete.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar).Required())
-		cdeleteResource          = Ref(cdelete.Arg("resource", "Resource name to delete").Required())
-		cdeleteResourceNamespace = cdelete.Flag("resource-namespace", "Resource namespace").Default(rigging.DefaultNamespace).String()
+		cdeleteChangeset         = Ref(cdelete.Flag("changeset", "Changeset name").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).Required())
+		cdeleteResourceNamespace = cdelete.Flag("resource-namespace", "Resource namespace").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).String()
+		cdeleteResource          = Ref(cdelete.Arg("resource", "Resource name to delete").HintAction(hints.resources(cdeleteResourceNamespace, false)).Required())
+		cdeleteConfirm           = confirmation(cdelete)
+
+		cdrift          = app.Command("drift", "Report resources modified in the cluster since they were applied")
+		cdriftFile      = cdrift.Flag("file", "file with desired resource specs").Short('f').Required().String()
+		cdriftNamespace = cdrift.Flag("resource-namespace", "Default namespace of the resources").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).String()
+
+		cplan          = app.Command("plan", "Show the actions applying resources would take without changing the cluster")
+		cplanFile      = cplan.Flag("file", "file with desired resource specs").Short('f').Required().String()
+		cplanNamespace = cplan.Flag("resource-namespace", "Default namespace of the resources").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).String()
+		cplanOut       = cplan.Flag("output", "output type, one of 'text' or 'json'").Short('o').Default(outputText).String()
+
+		creconcile          = app.Command("reconcile", "Continuously re-apply resources modified or deleted in the cluster")
+		creconcileFile      = creconcile.Flag("file", "file with desired resource specs").Short('f').Required().String()
+		creconcileNamespace = creconcile.Flag("resource-namespace", "Default namespace of the resources").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).String()
+		creconcileInterval  = creconcile.Flag("interval", "period between drift checks").Default(rigging.DefaultReconcileInterval.String()).Duration()
+
+		cwait          = app.Command("wait", "Wait for resources to become ready, e.g. cert-manager certificates")
+		cwaitFile      = cwait.Flag("file", "file with resource specs").Short('f').Required().String()
+		cwaitNamespace = cwait.Flag("resource-namespace", "Default namespace of the resources").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).String()
+		cwaitAttempts  = cwait.Flag("retry-attempts", "number of status attempts for each resource").Default(fmt.Sprintf("%v", rigging.DefaultRetryAttempts)).Int()
+		cwaitPeriod    = cwait.Flag("retry-period", "period between status attempts").Default(fmt.Sprintf("%v", rigging.DefaultRetryPeriod)).Duration()
+
+		cbundle = app.Command("bundle", "operations on bundle archives")
+
+		cbundlePack       = cbundle.Command("pack", "Pack a bundle directory with bundle.yaml into a tar.gz archive")
+		cbundlePackDir    = cbundlePack.Arg("dir", "bundle directory").Required().String()
+		cbundlePackOutput = cbundlePack.Flag("output", "archive file").Short('o').Required().String()
+
+		cbundleApply          = cbundle.Command("apply", "Apply a bundle archive in the context of a changeset")
+		cbundleApplyFile      = cbundleApply.Arg("file", "bundle archive, several archives are composed into one changeset in the order of their requirements").Required().Strings()
+		cbundleApplyChangeset = cbundleApply.Flag("changeset", "name of the changeset, defaults to the bundle name and version").Short('c').Envar(changesetEnvVar).HintAction(hints.changesets).String()
+		cbundleApplyAttempts  = cbundleApply.Flag("retry-attempts", "number of status attempts for each wave").Default(fmt.Sprintf("%v", rigging.DefaultRetryAttempts)).Int()
+		cbundleApplyPeriod    = cbundleApply.Flag("retry-period", "period between status attempts").Default(fmt.Sprintf("%v", rigging.DefaultRetryPeriod)).Duration()
+		cbundleApplyVerify    = verification(cbundleApply)
+		cbundleApplyFilter    = filters(cbundleApply)
+		cbundleApplyTransform = transformations(cbundleApply)
+		cbundleApplyImages    = imageChecks(cbundleApply)
+		cbundleApplyApproval  = cbundleApply.Flag("approval-file", "file approving approval gates with \"approve\" or rejecting them with \"reject\", gates are approved interactively if unset").String()
+		cbundleApplyTargets   = cbundleApply.Flag("target-namespace", "apply an instance of the bundle to this namespace with ${NAMESPACE} substituted, tracked in a changeset per namespace, can be repeated").Strings()
+		cbundleApplyFailure   = failurePolicy(cbundleApply)
+		cbundleApplyLock      = locking(cbundleApply)
+		cbundleApplyOwner     = ownership(cbundleApply)
+		cbundleApplyPrereqs   = prerequisites(cbundleApply)
+		cbundleApplyMaxSize   = cbundleApply.Flag("max-unpacked-size", "maximum uncompressed size of each bundle archive").Default("256MiB").Bytes()
+
+		crestart          = app.Command("restart", "Restart daemon sets, stateful sets and deployments in batches, e.g. after CA rotation")
+		crestartSelector  = crestart.Flag("selector", "label selector of the workloads to restart in all namespaces").Short('l').String()
+		crestartBatchSize = crestart.Flag("batch-size", "number of workloads restarted at once").Default("1").Int()
+		crestartNodes     = crestart.Flag("check-nodes", "require all nodes to be ready after each batch").Default("true").Bool()
+
+		csign     = app.Command("sign", "Write a detached signature of a file next to it")
+		csignFile = csign.Arg("file", "file to sign").Required().String()
+		csignKey  = csign.Flag("key", "PEM-encoded ECDSA or RSA private key").Required().String()
+
+		csupport          = app.Command("support-bundle", "Collect workloads, events, pod logs and node conditions into a tarball for troubleshooting")
+		csupportNamespace = csupport.Flag("resource-namespace", "namespace to collect, can be repeated").HintAction(hints.namespaces).Default(rigging.DefaultNamespace).Strings()
+		csupportSelector  = csupport.Flag("selector", "label selector of the collected workloads and pods").Short('l').String()
+		csupportOutput    = csupport.Flag("output", "path of the tarball").Short('o').Required().String()
+
+		cserve       = app.Command("serve", "Serve changeset operations over an HTTP JSON API for remote tools")
+		cserveAddr   = cserve.Flag("listen-addr", "address to listen on").Default("127.0.0.1:8080").String()
+		cserveToken  = cserve.Flag("token", "bearer token clients have to present").Envar(apiTokenEnvVar).String()
+		cserveNoAuth = cserve.Flag("insecure-no-auth", "serve requests without authentication").Bool()
+		cserveCert   = cserve.Flag("tls-cert", "TLS certificate file, the API is served over plain HTTP if unset").String()
+		cserveKey    = cserve.Flag("tls-key", "TLS private key file").String()
+		cserveFail   = failurePolicy(cserve)
+
+		ccompletion      = app.Command("completion", "Print the shell completion script, e.g. source <(rig completion bash)")
+		ccompletionShell = ccompletion.Arg("shell", "shell to print the script for: bash, zsh or fish").Required().Enum(shellBash, shellZsh, shellFish)
 	)
+	hints.kubeConfig, hints.proxy, hints.caFile, hints.serverName, hints.namespace = kubeConfig, proxy, caFile, serverName, namespace
 	app.Flag("quiet", "Suppress program output").Short('q').BoolVar(quiet)
 
 	cmd, err := app.Parse(os.Args[1:])
 	if err != nil {
-		return trace.Wrap(err)
+		return trace.BadParameter("%v", err)
+	}
+	*command = cmd
+
+	if cmd == ccompletion.FullCommand() {
+		return writeCompletionScript(os.Stdout, app, *ccompletionShell)
 	}
 
 	switch {
@@ -101,12 +227,26 @@ func run(quiet *bool) error {
 		InitLoggerCLI()
 	}
 
-	client, config, err := getClient(*kubeConfig)
+	if *monitoring {
+		rigging.RegisterMonitoringReadiness()
+	}
+
+	clientConfig := rigging.ClientConfig{
+		KubeConfig:    *kubeConfig,
+		Proxy:         *proxy,
+		CAFile:        *caFile,
+		TLSServerName: *serverName,
+	}
+	client, config, err := getClient(clientConfig)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	if *initiator == "" {
+		*initiator = rigging.DefaultInitiator(clientConfig)
+	}
+	ctx = rigging.WithInitiator(ctx, *initiator)
 	go func() {
 		exitSignals := make(chan os.Signal, 1)
 		signal.Notify(exitSignals, syscall.SIGTERM, syscall.SIGINT)
@@ -120,19 +260,102 @@ func run(quiet *bool) error {
 
 	switch cmd {
 	case cupsert.FullCommand():
-		return upsert(ctx, client, config, *namespace, *cupsertChangeset, *cupsertFile)
+		transformers, err := cupsertTransform.transformers()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		filter, err := cupsertFilter.filter()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if filter != nil {
+			transformers = append(transformers, filter)
+		}
+		source, err := cupsertSource.source(*cupsertFile, *cupsertRecursive)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if err := cupsertPrereqs.wait(ctx, client); err != nil {
+			return trace.Wrap(err)
+		}
+		return cupsertLock.run(ctx, client, *namespace, cupsertChangeset.Name, func(ctx context.Context) error {
+			return upsert(ctx, client, config, *namespace, *cupsertChangeset, source, cupsertVerify, transformers, *cupsertPreflight, *cupsertRecreate, *cupsertClaims, *cupsertDependents, cupsertFailure.policy(), cupsertImages.config(), cupsertOwner, cupsertSnapshots.config(), cupsertConfirm)
+		})
 	case cstatus.FullCommand():
-		return status(ctx, client, config, *namespace, *cstatusResource, *cstatusAttempts, *cstatusPeriod)
+		var reportWriters []rigging.ReportWriter
+		if *cstatusReport != "" {
+			reportWriters = append(reportWriters, rigging.FileReportWriter{Dir: *cstatusReport})
+		}
+		if *cstatusReportCM {
+			reportWriters = append(reportWriters, rigging.ConfigMapReportWriter{Client: client})
+		}
+		if *cstatusSupport != "" {
+			reportWriters = append(reportWriters, rigging.SupportBundleWriter{Client: client, Dir: *cstatusSupport})
+		}
+		nodes := rigging.NodeFilter{Names: *cstatusNodes, ExpectedCount: *cstatusExpected}
+		if *cstatusSelector != "" {
+			nodes.Selector, err = labels.Parse(*cstatusSelector)
+			if err != nil {
+				return trace.BadParameter("invalid node selector %q: %v", *cstatusSelector, err)
+			}
+		}
+		return status(ctx, client, config, *namespace, *cstatusResource, *cstatusAttempts, *cstatusPeriod, *cstatusSlow, *cstatusDebug, reportWriters, nodes, *cstatusMetrics, *cstatusStrict, *cstatusPending, *cstatusHistory)
 	case cget.FullCommand():
 		return get(ctx, client, config, *namespace, *cgetChangeset, *cgetOut)
 	case cdelete.FullCommand():
-		return deleteResource(ctx, client, config, *namespace, *cdeleteChangeset, *cdeleteResourceNamespace, *cdeleteResource, *cdeleteCascade, *cdeleteForce)
+		return deleteResource(ctx, client, config, *namespace, *cdeleteChangeset, *cdeleteResourceNamespace, *cdeleteResource, *cdeleteCascade, *cdeleteForce, cdeleteConfirm)
 	case ctrDelete.FullCommand():
 		return csDelete(ctx, client, config, *namespace, *ctrDeleteChangeset, *ctrDeleteForce)
+	case ctrUninstall.FullCommand():
+		return uninstall(ctx, client, config, *namespace, *ctrUninstallChangeset, ctrUninstallConfirm)
+	case ctrReport.FullCommand():
+		return report(ctx, client, config, *namespace, *ctrReportChangeset, *ctrReportOutput)
 	case crevert.FullCommand():
-		return revert(ctx, client, config, *namespace, *crevertChangeset)
+		return revert(ctx, client, config, *namespace, *crevertChangeset, *crevertRestore)
 	case cfreeze.FullCommand():
 		return freeze(ctx, client, config, *namespace, *cfreezeChangeset)
+	case cdrift.FullCommand():
+		return drift(ctx, *cdriftNamespace, *cdriftFile)
+	case cplan.FullCommand():
+		return plan(ctx, *cplanNamespace, *cplanFile, *cplanOut)
+	case creconcile.FullCommand():
+		return reconcile(ctx, *creconcileNamespace, *creconcileFile, *creconcileInterval)
+	case cwait.FullCommand():
+		return waitReady(ctx, *cwaitNamespace, *cwaitFile, *cwaitAttempts, *cwaitPeriod)
+	case cbundlePack.FullCommand():
+		return bundlePack(*cbundlePackDir, *cbundlePackOutput)
+	case cbundleApply.FullCommand():
+		filter, err := cbundleApplyFilter.filter()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		transformers, err := cbundleApplyTransform.transformers()
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if err := cbundleApplyPrereqs.wait(ctx, client); err != nil {
+			return trace.Wrap(err)
+		}
+		return cbundleApplyLock.run(ctx, client, *namespace, *cbundleApplyChangeset, func(ctx context.Context) error {
+			return bundleApply(ctx, client, config, *cbundleApplyFile, int64(*cbundleApplyMaxSize), cbundleApplyVerify, cbundleApplyFailure.policy(), cbundleApplyOwner, rigging.ApplyConfig{
+				ChangesetNamespace: *namespace,
+				ChangesetName:      *cbundleApplyChangeset,
+				RetryAttempts:      *cbundleApplyAttempts,
+				RetryPeriod:        *cbundleApplyPeriod,
+				Filter:             filter,
+				Transformers:       transformers,
+				ImageCheck:         cbundleApplyImages.config(),
+				Approver:           gateApprover(*cbundleApplyApproval),
+			}, *cbundleApplyTargets)
+		})
+	case crestart.FullCommand():
+		return rollingRestart(ctx, client, *crestartSelector, *crestartBatchSize, *crestartNodes)
+	case csign.FullCommand():
+		return sign(*csignFile, *csignKey)
+	case csupport.FullCommand():
+		return supportBundle(ctx, client, *csupportNamespace, *csupportSelector, *csupportOutput)
+	case cserve.FullCommand():
+		return serve(ctx, client, config, *cserveAddr, *cserveToken, *cserveNoAuth, *cserveCert, *cserveKey, cserveFail.policy())
 	case cupsertConfigMap.FullCommand():
 		return upsertConfigMap(ctx, client, config, *namespace, *cupsertConfigMapChangeset, *cupsertConfigMapName, *cupsertConfigMapNamespace, *cupsertConfigMapFiles, *cupsertConfigMapLiterals)
 	}
@@ -140,26 +363,638 @@ func run(quiet *bool) error {
 	return trace.BadParameter("unsupported command: %v", cmd)
 }
 
-func getClient(configPath string) (*kubernetes.Clientset, *rest.Config, error) {
-	// creates the in-cluster config
-	config, err := rest.InClusterConfig()
-	if err == nil {
-		client, err := kubernetes.NewForConfig(config)
+func getClient(clientConfig rigging.ClientConfig) (*kubernetes.Clientset, *rest.Config, error) {
+	client, config, err := rigging.NewClient(clientConfig)
+	return client, config, trace.Wrap(err)
+}
+
+// transformFlags holds flags to transform manifests before they are applied
+type transformFlags struct {
+	vars         map[string]string
+	strict       bool
+	upgrade      bool
+	harden       bool
+	requests     string
+	limits       string
+	sidecars     []string
+	profile      string
+	profilesPath string
+	pullSecret   string
+	registry     string
+	username     string
+	password     string
+	dockerConfig string
+	plugins      []string
+}
+
+// transformations adds flags to transform the command's manifests
+func transformations(cmd *kingpin.CmdClause) *transformFlags {
+	flags := transformFlags{vars: make(map[string]string)}
+	cmd.Flag("var", "variables substituted for ${VAR} references in the file, in form of key=val").StringMapVar(&flags.vars)
+	cmd.Flag("strict", "fail if the file references undefined variables").BoolVar(&flags.strict)
+	cmd.Flag("upgrade-api-versions", "upgrade resources from deprecated API versions, e.g. extensions/v1beta1 Deployment to apps/v1").BoolVar(&flags.upgrade)
+	cmd.Flag("harden", "enforce non-root, read-only root filesystem and dropped capabilities on pod templates").BoolVar(&flags.harden)
+	cmd.Flag("default-requests", "resource requests set on containers lacking them, e.g. cpu=100m,memory=128Mi").StringVar(&flags.requests)
+	cmd.Flag("default-limits", "resource limits set on containers lacking them, e.g. cpu=1,memory=512Mi").StringVar(&flags.limits)
+	cmd.Flag("sidecar", "file with a sidecar container, its volumes and workload selector to inject").StringsVar(&flags.sidecars)
+	cmd.Flag("profile", "scheduling profile applied to all workloads, e.g. controlplane, worker or gpu").StringVar(&flags.profile)
+	cmd.Flag("profiles-file", "file with additional scheduling profiles").StringVar(&flags.profilesPath)
+	cmd.Flag("registry", "private registry server, its credentials are added as an image pull secret to every namespace, service account and pod template").StringVar(&flags.registry)
+	cmd.Flag("registry-username", "private registry username").StringVar(&flags.username)
+	cmd.Flag("registry-password", "private registry password").Envar(registryPasswordEnvVar).StringVar(&flags.password)
+	cmd.Flag("registry-config", "docker config JSON file with the registry credentials, alternative to --registry").StringVar(&flags.dockerConfig)
+	cmd.Flag("pull-secret-name", "name of the image pull secret").Default(rigging.DefaultImagePullSecretName).StringVar(&flags.pullSecret)
+	cmd.Flag("kubectl-plugin", "kubectl plugin with arguments the manifests are passed through last, e.g. neat").StringsVar(&flags.plugins)
+	return &flags
+}
+
+// transformers returns the transformers requested by the flags in the order they are applied
+func (t *transformFlags) transformers() ([]rigging.Transformer, error) {
+	var transformers []rigging.Transformer
+	if len(t.vars) != 0 || t.strict {
+		transformers = append(transformers, rigging.EnvSubst{Vars: t.vars, Strict: t.strict})
+	}
+	if t.upgrade {
+		transformers = append(transformers, rigging.APIVersionUpgrade{})
+	}
+	if t.harden {
+		transformers = append(transformers, rigging.SecurityHardening{})
+	}
+	if t.requests != "" || t.limits != "" {
+		defaults, err := resourceDefaults(t.requests, t.limits)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		transformers = append(transformers, defaults)
+	}
+	for _, path := range t.sidecars {
+		data, err := ReadPath(path)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		injector, err := rigging.ParseSidecarInjector(data)
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to parse %v", path)
+		}
+		transformers = append(transformers, injector)
+	}
+	if t.profile != "" {
+		profile, err := schedulingProfile(t.profile, t.profilesPath)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		transformers = append(transformers, profile)
+	}
+	switch {
+	case t.dockerConfig != "":
+		data, err := ReadPath(t.dockerConfig)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		transformers = append(transformers, rigging.ImagePullSecret{Name: t.pullSecret, DockerConfig: data})
+	case t.registry != "":
+		secret, err := rigging.NewImagePullSecret(t.pullSecret, t.registry, t.username, t.password)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		transformers = append(transformers, secret)
+	}
+	for _, in := range t.plugins {
+		plugin, err := rigging.ParseKubectlPlugin(rigging.Kubectl{}, in)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		transformers = append(transformers, plugin)
+	}
+	return transformers, nil
+}
+
+func schedulingProfile(name, profilesPath string) (*rigging.SchedulingProfile, error) {
+	var profiles []rigging.SchedulingProfile
+	if profilesPath != "" {
+		data, err := ReadPath(profilesPath)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		profiles, err = rigging.ParseSchedulingProfiles(data)
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to parse %v", profilesPath)
+		}
+	}
+	return rigging.FindSchedulingProfile(name, profiles)
+}
+
+func resourceDefaults(requests, limits string) (*rigging.ResourceDefaults, error) {
+	var defaults rigging.ResourceDefaults
+	var err error
+	defaults.Default.Requests, err = rigging.ParseResourceList(requests)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defaults.Default.Limits, err = rigging.ParseResourceList(limits)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &defaults, nil
+}
+
+// filterFlags holds flags to select the resources to apply
+type filterFlags struct {
+	includeKinds    []string
+	excludeKinds    []string
+	includeNames    []string
+	excludeNames    []string
+	selector        string
+	excludeSelector string
+}
+
+// filters adds flags to select the command's resources by kind, name and labels
+func filters(cmd *kingpin.CmdClause) *filterFlags {
+	var flags filterFlags
+	cmd.Flag("include-kind", "apply only resources of this kind, e.g. Deployment").StringsVar(&flags.includeKinds)
+	cmd.Flag("exclude-kind", "skip resources of this kind").StringsVar(&flags.excludeKinds)
+	cmd.Flag("include-name", "apply only resources with this name or glob pattern").StringsVar(&flags.includeNames)
+	cmd.Flag("exclude-name", "skip resources with this name or glob pattern").StringsVar(&flags.excludeNames)
+	cmd.Flag("selector", "apply only resources matching the label selector, e.g. app=api").Short('l').StringVar(&flags.selector)
+	cmd.Flag("exclude-selector", "skip resources matching the label selector").StringVar(&flags.excludeSelector)
+	return &flags
+}
+
+// filter returns the resource filter requested by the flags, nil if no flags are set
+func (f *filterFlags) filter() (*rigging.ResourceFilter, error) {
+	filter := rigging.ResourceFilter{
+		IncludeKinds: f.includeKinds,
+		ExcludeKinds: f.excludeKinds,
+		IncludeNames: f.includeNames,
+		ExcludeNames: f.excludeNames,
+	}
+	if f.selector != "" {
+		selector, err := labels.Parse(f.selector)
+		if err != nil {
+			return nil, trace.Wrap(err, "invalid selector %q", f.selector)
+		}
+		filter.Selector = selector
+	}
+	if f.excludeSelector != "" {
+		selector, err := labels.Parse(f.excludeSelector)
+		if err != nil {
+			return nil, trace.Wrap(err, "invalid selector %q", f.excludeSelector)
+		}
+		filter.ExcludeSelector = selector
+	}
+	if filter.IsEmpty() {
+		return nil, nil
+	}
+	return &filter, nil
+}
+
+// failureFlags holds flags governing the failures of individual resources
+type failureFlags struct {
+	mode        string
+	ignoreKinds []string
+	quotaWait   time.Duration
+}
+
+// failurePolicy adds flags to set the failure policy of the command
+func failurePolicy(cmd *kingpin.CmdClause) *failureFlags {
+	var flags failureFlags
+	cmd.Flag("on-failure", "fail-fast stops at the first resource that fails, continue applies all resources and reports the failures").
+		Default(string(rigging.FailFast)).EnumVar(&flags.mode, string(rigging.FailFast), string(rigging.ContinueAndReport))
+	cmd.Flag("ignore-failures", "kind of the resources whose failures are ignored").StringsVar(&flags.ignoreKinds)
+	cmd.Flag("quota-wait", "how long to retry creations rejected because of an exhausted resource quota, 0 disables the retries").
+		Default(rigging.DefaultQuotaWait.String()).DurationVar(&flags.quotaWait)
+	return &flags
+}
+
+// policy returns the failure policy requested by the flags
+func (f *failureFlags) policy() rigging.FailurePolicy {
+	quotaWait := f.quotaWait
+	if quotaWait == 0 {
+		// zero value of the policy means the default wait
+		quotaWait = -1
+	}
+	return rigging.FailurePolicy{
+		Mode:        rigging.FailureMode(f.mode),
+		IgnoreKinds: f.ignoreKinds,
+		QuotaWait:   quotaWait,
+	}
+}
+
+// lockFlags holds flags to run the command under a cluster-wide lock
+type lockFlags struct {
+	name     string
+	duration time.Duration
+}
+
+// locking adds flags to run the command under a cluster-wide lock
+func locking(cmd *kingpin.CmdClause) *lockFlags {
+	var flags lockFlags
+	cmd.Flag("lock", "name of the cluster-wide lock held while applying, e.g. the application name, fails if another operator holds it").StringVar(&flags.name)
+	cmd.Flag("lock-duration", "time after which a lock that is no longer renewed is stale and can be taken over").
+		Default(rigging.DefaultLockDuration.String()).DurationVar(&flags.duration)
+	return &flags
+}
+
+// run runs fn holding the lock if one is requested by the flags
+func (f *lockFlags) run(ctx context.Context, client *kubernetes.Clientset, namespace, changeset string, fn func(context.Context) error) error {
+	if f.name == "" {
+		return fn(ctx)
+	}
+	return rigging.WithLock(ctx, rigging.LockConfig{
+		Name:       f.name,
+		Namespace:  namespace,
+		Changeset:  changeset,
+		Duration:   f.duration,
+		ConfigMaps: client.CoreV1(),
+	}, fn)
+}
+
+// prereqFlags holds flags to wait for the cluster prerequisites before applying
+type prereqFlags struct {
+	enabled       bool
+	minReadyNodes int
+	dnsService    string
+}
+
+// prerequisites adds flags to wait for the cluster prerequisites before applying
+func prerequisites(cmd *kingpin.CmdClause) *prereqFlags {
+	var flags prereqFlags
+	cmd.Flag("wait-prereqs", "wait for cluster DNS endpoints, the default service account and ready nodes before applying").BoolVar(&flags.enabled)
+	cmd.Flag("min-ready-nodes", "number of ready nodes required by --wait-prereqs").
+		Default(fmt.Sprint(rigging.DefaultMinReadyNodes)).IntVar(&flags.minReadyNodes)
+	cmd.Flag("dns-service", "namespace/name of the cluster DNS service checked by --wait-prereqs").
+		Default(rigging.DefaultDNSNamespace + "/" + rigging.DefaultDNSService).StringVar(&flags.dnsService)
+	return &flags
+}
+
+// wait waits for the cluster prerequisites if requested by the flags
+func (f *prereqFlags) wait(ctx context.Context, client *kubernetes.Clientset) error {
+	if !f.enabled {
+		return nil
+	}
+	parts := strings.SplitN(f.dnsService, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return trace.BadParameter("expected DNS service in form namespace/name, got %q", f.dnsService)
+	}
+	prereqs, err := rigging.NewClusterPrereqs(rigging.ClusterPrereqsConfig{
+		Client:        client.CoreV1(),
+		DNSNamespace:  parts[0],
+		DNSService:    parts[1],
+		MinReadyNodes: f.minReadyNodes,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(rigging.PollStatus(ctx, 0, 0, prereqs))
+}
+
+// ownerFlags holds flags controlling the ownership of the applied resources
+type ownerFlags struct {
+	owner      string
+	forceAdopt bool
+	helm       string
+}
+
+// ownership adds flags controlling the ownership of the applied resources
+func ownership(cmd *kingpin.CmdClause) *ownerFlags {
+	var flags ownerFlags
+	cmd.Flag("owner", "application owning the resources, recorded on them to detect other applications updating them").StringVar(&flags.owner)
+	cmd.Flag("force-adopt", "take over resources owned by another application or managed by another tool instead of failing").BoolVar(&flags.forceAdopt)
+	cmd.Flag("helm", "ignore, warn about or fail on resources managed by Helm releases").
+		Default(string(rigging.HelmIgnore)).EnumVar(&flags.helm, string(rigging.HelmIgnore), string(rigging.HelmWarn), string(rigging.HelmFail))
+	return &flags
+}
+
+// printOutcomes prints the outcome of each resource if the error
+// is an aggregate apply error
+func printOutcomes(err error) {
+	applyErr, ok := trace.Unwrap(err).(*rigging.ApplyError)
+	if !ok {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "Resource\tOutcome\tError\n")
+	for _, outcome := range applyErr.Outcomes {
+		var message string
+		if outcome.Error != nil {
+			message = outcome.Error.Error()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", outcome.Ref, outcome.Status, message)
+	}
+}
+
+// imageCheckFlags holds flags to verify the images before they are applied
+type imageCheckFlags struct {
+	check    bool
+	insecure []string
+}
+
+// imageChecks adds flags to verify that the command's images exist in their registries
+func imageChecks(cmd *kingpin.CmdClause) *imageCheckFlags {
+	var flags imageCheckFlags
+	cmd.Flag("check-images", "verify that all images exist in their registries before applying").BoolVar(&flags.check)
+	cmd.Flag("insecure-registry", "registry accessed over plain HTTP when checking images").StringsVar(&flags.insecure)
+	return &flags
+}
+
+// config returns the image check configuration, nil if images are not checked
+func (f *imageCheckFlags) config() *rigging.ImageCheckConfig {
+	if !f.check {
+		return nil
+	}
+	return &rigging.ImageCheckConfig{InsecureRegistries: f.insecure}
+}
+
+// snapshotFlags holds flags controlling the snapshots of the volumes of the workloads
+type snapshotFlags struct {
+	snapshot   bool
+	class      string
+	apiVersion string
+}
+
+// snapshots adds flags to snapshot the volumes of the workloads before they are replaced or deleted
+func snapshots(cmd *kingpin.CmdClause) *snapshotFlags {
+	var flags snapshotFlags
+	cmd.Flag("snapshot-volumes", "snapshot volumes of workloads before they are replaced or deleted").BoolVar(&flags.snapshot)
+	cmd.Flag("snapshot-class", "volume snapshot class, the default class if unset").StringVar(&flags.class)
+	cmd.Flag("snapshot-api-version", "version of the volume snapshot API").
+		Default(rigging.VolumeSnapshotV1alpha1).EnumVar(&flags.apiVersion, rigging.VolumeSnapshotV1alpha1, rigging.VolumeSnapshotV1beta1)
+	return &flags
+}
+
+// config returns the snapshot configuration, nil if volumes are not snapshotted
+func (f *snapshotFlags) config() *rigging.SnapshotConfig {
+	if !f.snapshot {
+		return nil
+	}
+	return &rigging.SnapshotConfig{ClassName: f.class, APIVersion: f.apiVersion}
+}
+
+// confirmFlags holds flags controlling the interactive confirmation of the command's changes
+type confirmFlags struct {
+	yes bool
+}
+
+// confirmation adds the flag to skip the confirmation of the command's changes
+func confirmation(cmd *kingpin.CmdClause) *confirmFlags {
+	var flags confirmFlags
+	cmd.Flag("yes", "do not ask to confirm the changes, they are only confirmed if stdin is a terminal").Short('y').BoolVar(&flags.yes)
+	return &flags
+}
+
+// required returns true if the changes have to be confirmed,
+// i.e. --yes is not set and stdin is a terminal
+func (f *confirmFlags) required() bool {
+	return !f.yes && terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// confirm asks to confirm the changes if required,
+// returns CompareFailed if they are not confirmed
+func (f *confirmFlags) confirm(prompt string) error {
+	if !f.required() {
+		return nil
+	}
+	fmt.Printf("%v [y/N]: ", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return trace.ConvertSystemError(err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return trace.CompareFailed("changes were not confirmed")
+}
+
+// confirmUpsert shows the changes upserting the resources would make
+// and asks to confirm them if required
+func confirmUpsert(ctx context.Context, changeset rigging.Ref, data []byte, confirm *confirmFlags) error {
+	if !confirm.required() {
+		return nil
+	}
+	bundle, err := rigging.NewBundle(changeset.Name, rigging.DefaultNamespace, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	plan, err := rigging.PlanUpsert(ctx, bundle)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if !plan.HasChanges() {
+		return nil
+	}
+	if err := plan.WriteTable(os.Stdout); err != nil {
+		return trace.Wrap(err)
+	}
+	return confirm.confirm(fmt.Sprintf("Apply the changes in changeset %v?", changeset.Name))
+}
+
+// verifyFlags holds flags to verify files before they are applied
+type verifyFlags struct {
+	publicKeys []string
+	checksum   string
+}
+
+// verification adds flags to verify the command's file
+func verification(cmd *kingpin.CmdClause) *verifyFlags {
+	var flags verifyFlags
+	cmd.Flag("public-key", "trusted PEM-encoded public key, if set, the file should have a valid detached signature in <file>.sig").StringsVar(&flags.publicKeys)
+	cmd.Flag("checksum", "expected hex-encoded SHA-256 checksum of the file").StringVar(&flags.checksum)
+	return &flags
+}
+
+// read reads the file at the path verifying its signature and checksum if requested
+func (v *verifyFlags) read(path string) ([]byte, error) {
+	if len(v.publicKeys) == 0 {
+		data, err := ReadPath(path)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		return data, trace.Wrap(v.verifyChecksum(data))
+	}
+	var keys []crypto.PublicKey
+	for _, keyPath := range v.publicKeys {
+		data, err := ReadPath(keyPath)
 		if err != nil {
-			return nil, nil, trace.Wrap(err)
+			return nil, trace.Wrap(err)
 		}
-		return client, config, nil
+		parsed, err := rigging.ParsePublicKeys(data)
+		if err != nil {
+			return nil, trace.Wrap(err, "failed to parse %v", keyPath)
+		}
+		keys = append(keys, parsed...)
+	}
+	abs, err := NormalizePath(path)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	data, err := rigging.VerifyFile(abs, keys)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return data, trace.Wrap(v.verifyChecksum(data))
+}
+
+// load loads the manifests from the source verifying the checksum if requested,
+// signatures can only be verified for single local files
+func (v *verifyFlags) load(ctx context.Context, source rigging.Source) ([]byte, error) {
+	if len(v.publicKeys) != 0 {
+		file, ok := source.(*rigging.FileSource)
+		if !ok {
+			return nil, trace.BadParameter("signatures can only be verified for local files, use --git-verify-signature for git sources")
+		}
+		files, err := rigging.ExpandPaths(file.Path, file.Recursive)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if len(files) != 1 || files[0] != file.Path {
+			return nil, trace.BadParameter("signatures can only be verified for a single file, %v is a directory or a glob pattern", file.Path)
+		}
+		return v.read(file.Path)
+	}
+	data, err := source.Load(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return data, trace.Wrap(v.verifyChecksum(data))
+}
+
+func (v *verifyFlags) verifyChecksum(data []byte) error {
+	if v.checksum == "" {
+		return nil
 	}
+	return rigging.VerifyChecksum(data, v.checksum)
+}
+
+func supportBundle(ctx context.Context, client *kubernetes.Clientset, namespaces []string, selector, output string) error {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	if err := rigging.CollectSupportBundle(ctx, client, namespaces, parsed, f); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := f.Close(); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	fmt.Printf("support bundle written to %v\n", output)
+	return nil
+}
 
-	config, err = clientcmd.BuildConfigFromFlags("", configPath)
+func sign(filePath, keyPath string) error {
+	keyData, err := ReadPath(keyPath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	key, err := rigging.ParsePrivateKey(keyData)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data, err := ReadPath(filePath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	signature, err := rigging.Sign(data, key)
 	if err != nil {
-		return nil, nil, trace.Wrap(err)
+		return trace.Wrap(err)
 	}
-	client, err := kubernetes.NewForConfig(config)
+	err = ioutil.WriteFile(filePath+rigging.SignatureExtension, signature, 0644)
 	if err != nil {
-		return nil, nil, trace.Wrap(err)
+		return trace.ConvertSystemError(err)
+	}
+	fmt.Printf("signature written to %v%v\n", filePath, rigging.SignatureExtension)
+	return nil
+}
+
+func serve(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, addr, token string, noAuth bool, certFile, keyFile string, policy rigging.FailurePolicy) error {
+	if token == "" && !noAuth {
+		return trace.BadParameter("provide the API token with --token or disable authentication with --insecure-no-auth")
+	}
+	if (certFile == "") != (keyFile == "") {
+		return trace.BadParameter("both --tls-cert and --tls-key are required to serve over TLS")
+	}
+	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
+		Client:        client,
+		Config:        config,
+		FailurePolicy: policy,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	serverConfig := rigging.ServerConfig{
+		Changeset:            cs,
+		AllowUnauthenticated: noAuth,
+	}
+	if token != "" {
+		serverConfig.Authenticator = rigging.TokenAuthenticator{Tokens: map[string]string{token: "token"}}
+	}
+	handler, err := rigging.NewServer(serverConfig)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Infof("serving API on %v", addr)
+	if certFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// sourceFlags holds flags to load the command's file from git or URL sources
+type sourceFlags struct {
+	git    rigging.GitSourceConfig
+	caPath string
+	token  string
+}
+
+// sources adds flags to load the command's file from git or URL sources
+func sources(cmd *kingpin.CmdClause) *sourceFlags {
+	var flags sourceFlags
+	cmd.Flag("git-url", "load the file from this git repository, the file path is relative to the repository root").StringVar(&flags.git.URL)
+	cmd.Flag("git-ref", "git branch, tag or commit to check out").StringVar(&flags.git.Ref)
+	cmd.Flag("git-ssh-key", "private key to authenticate to the git repository over SSH").StringVar(&flags.git.SSHKeyPath)
+	cmd.Flag("git-token", "token to authenticate to the git repository over HTTPS").Envar(gitTokenEnvVar).StringVar(&flags.git.Token)
+	cmd.Flag("git-verify-signature", "require a valid signature of the checked out commit").BoolVar(&flags.git.VerifySignature)
+	cmd.Flag("url-ca", "CA certificate to verify the server if the file is an https URL").StringVar(&flags.caPath)
+	cmd.Flag("url-token", "bearer token to authenticate if the file is an https URL").Envar(urlTokenEnvVar).StringVar(&flags.token)
+	return &flags
+}
+
+// source returns the source of the file path, - stands for the standard input
+func (f *sourceFlags) source(path string, recursive bool) (rigging.Source, error) {
+	if f.git.URL != "" {
+		config := f.git
+		config.Path = path
+		config.Recursive = recursive
+		return &rigging.GitSource{GitSourceConfig: config}, nil
+	}
+	source := rigging.NewSource(path, recursive, os.Stdin)
+	if urlSource, ok := source.(*rigging.URLSource); ok {
+		urlSource.Token = f.token
+		if f.caPath != "" {
+			ca, err := ReadPath(f.caPath)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			urlSource.CACert = ca
+		}
 	}
-	return client, config, nil
+	return source, nil
 }
 
 func Ref(s kingpin.Settings) *rigging.Ref {
@@ -168,13 +1003,18 @@ func Ref(s kingpin.Settings) *rigging.Ref {
 	return r
 }
 
-func revert(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, changeset rigging.Ref) error {
+func revert(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, changeset rigging.Ref, restoreSnapshots bool) error {
 	if changeset.Kind != rigging.KindChangeset {
 		return trace.BadParameter("expected %v, got %v", rigging.KindChangeset, changeset.Kind)
 	}
+	var snapshots *rigging.SnapshotConfig
+	if restoreSnapshots {
+		snapshots = &rigging.SnapshotConfig{Restore: true}
+	}
 	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
-		Client: client,
-		Config: config,
+		Client:    client,
+		Config:    config,
+		Snapshots: snapshots,
 	})
 	if err != nil {
 		return trace.Wrap(err)
@@ -206,10 +1046,16 @@ func freeze(ctx context.Context, client *kubernetes.Clientset, config *rest.Conf
 	return nil
 }
 
-func deleteResource(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, changeset rigging.Ref, resourceNamespace string, resource rigging.Ref, cascade, force bool) error {
+func deleteResource(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, changeset rigging.Ref, resourceNamespace string, resource rigging.Ref, cascade, force bool, confirm *confirmFlags) error {
 	if changeset.Kind != rigging.KindChangeset {
 		return trace.BadParameter("expected %v, got %v", rigging.KindChangeset, changeset.Kind)
 	}
+	if confirm.required() {
+		fmt.Printf("%v in namespace %v will be deleted, cascade: %v\n", resource, resourceNamespace, cascade)
+		if err := confirm.confirm(fmt.Sprintf("Delete %v in changeset %v?", resource, changeset.Name)); err != nil {
+			return trace.Wrap(err)
+		}
+	}
 	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
 		Client: client,
 		Config: config,
@@ -229,6 +1075,28 @@ func deleteResource(ctx context.Context, client *kubernetes.Clientset, config *r
 	return nil
 }
 
+func uninstall(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, changeset rigging.Ref, confirm *confirmFlags) error {
+	if changeset.Kind != rigging.KindChangeset {
+		return trace.BadParameter("expected %v, got %v", rigging.KindChangeset, changeset.Kind)
+	}
+	if err := confirm.confirm(fmt.Sprintf("Delete resources created by changeset %v?", changeset.Name)); err != nil {
+		return trace.Wrap(err)
+	}
+	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
+		Client: client,
+		Config: config,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	err = cs.DeleteChangesetResources(ctx, namespace, changeset.Name)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Printf("resources of changeset %v deleted, recorded in changeset %v \n", changeset.Name, rigging.UninstallChangesetName(changeset.Name))
+	return nil
+}
+
 func upsertConfigMap(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, changesetNamespace string, changeset rigging.Ref, configMapName, configMapNamespace string, files []string, literals []string) error {
 	if changeset.Kind != rigging.KindChangeset {
 		return trace.BadParameter("expected %v, got %v", rigging.KindChangeset, changeset.Kind)
@@ -256,36 +1124,236 @@ func upsertConfigMap(ctx context.Context, client *kubernetes.Clientset, config *
 	return nil
 }
 
-func upsert(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, changeset rigging.Ref, filePath string) error {
+func upsert(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, changeset rigging.Ref, source rigging.Source, verify *verifyFlags, transformers []rigging.Transformer, preflight, autoRecreate, preserveClaims, restartDependents bool, policy rigging.FailurePolicy, imageCheck *rigging.ImageCheckConfig, owner *ownerFlags, snapshots *rigging.SnapshotConfig, confirm *confirmFlags) error {
 	if changeset.Kind != rigging.KindChangeset {
 		return trace.BadParameter("expected %v, got %v", rigging.KindChangeset, changeset.Kind)
 	}
-	data, err := ReadPath(filePath)
+	if err := rigging.CheckKubectl(); err != nil {
+		return trace.Wrap(err)
+	}
+	data, err := verify.load(ctx, source)
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	data, err = rigging.Transform(data, transformers...)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if preflight {
+		if err := rigging.PreflightPodTemplates(ctx, client, rigging.DefaultNamespace, data); err != nil {
+			return trace.Wrap(err, "preflight failed")
+		}
+	}
+	if imageCheck != nil {
+		imageCheck.Client = client
+		imageCheck.Namespace = rigging.DefaultNamespace
+		if err := rigging.PreflightImages(ctx, *imageCheck, data); err != nil {
+			return trace.Wrap(err, "image preflight failed")
+		}
+	}
+	if err := confirmUpsert(ctx, changeset, data, confirm); err != nil {
+		return trace.Wrap(err)
+	}
 	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
-		Client: client,
-		Config: config,
+		Client:            client,
+		Config:            config,
+		FailurePolicy:     policy,
+		Owner:             owner.owner,
+		ForceAdopt:        owner.forceAdopt,
+		HelmPolicy:        rigging.HelmPolicy(owner.helm),
+		AutoRecreate:      autoRecreate,
+		PreserveClaims:    preserveClaims,
+		RestartDependents: restartDependents,
+		Snapshots:         snapshots,
 	})
 	if err != nil {
 		return trace.Wrap(err)
 	}
 	err = cs.Upsert(ctx, namespace, changeset.Name, data)
 	if err != nil {
+		printOutcomes(err)
 		return trace.Wrap(err)
 	}
 	fmt.Printf("changeset %v updated \n", changeset.Name)
 	return nil
 }
 
+func plan(ctx context.Context, namespace string, filePath string, output string) error {
+	data, err := rigging.NewSource(filePath, false, os.Stdin).Load(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	bundle, err := rigging.NewBundle(filepath.Base(filePath), namespace, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	plan, err := rigging.Plan(ctx, bundle)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	switch output {
+	case outputJSON:
+		return plan.WriteJSON(os.Stdout)
+	case outputText, "":
+		return plan.WriteTable(os.Stdout)
+	}
+	return trace.BadParameter("unsupported output format %q, supported are: %v, %v", output, outputText, outputJSON)
+}
+
+func drift(ctx context.Context, namespace string, filePath string) error {
+	data, err := rigging.NewSource(filePath, false, os.Stdin).Load(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	bundle, err := rigging.NewBundle(filepath.Base(filePath), namespace, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	drifts, err := rigging.DetectDrift(ctx, bundle)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if len(drifts) == 0 {
+		fmt.Printf("no drift detected\n")
+		return nil
+	}
+	for _, drift := range drifts {
+		fmt.Printf("%v\n", drift)
+	}
+	return trace.CompareFailed("%v resources have drifted", len(drifts))
+}
+
+func reconcile(ctx context.Context, namespace string, filePath string, interval time.Duration) error {
+	data, err := rigging.NewSource(filePath, false, os.Stdin).Load(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	bundle, err := rigging.NewBundle(filepath.Base(filePath), namespace, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	reconciler, err := rigging.NewReconciler(rigging.ReconcilerConfig{
+		Bundle:   bundle,
+		Interval: interval,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(reconciler.Run(ctx))
+}
+
+func waitReady(ctx context.Context, namespace string, filePath string, retryAttempts int, retryPeriod time.Duration) error {
+	data, err := rigging.NewSource(filePath, false, os.Stdin).Load(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	bundle, err := rigging.NewBundle(filepath.Base(filePath), namespace, data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := rigging.WaitReady(ctx, bundle, retryAttempts, retryPeriod); err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Printf("%v resources are ready\n", len(bundle.Objects))
+	return nil
+}
+
+func bundlePack(dir, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+	if err := rigging.Pack(dir, f); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := f.Close(); err != nil {
+		return trace.ConvertSystemError(err)
+	}
+	fmt.Printf("bundle %v packed into %v\n", dir, output)
+	return nil
+}
+
+// gateApprover returns the approver of the approval gates of bundles
+func gateApprover(approvalFile string) rigging.Approver {
+	if approvalFile != "" {
+		return rigging.FileApprover{Path: approvalFile}
+	}
+	return rigging.PromptApprover{}
+}
+
+func bundleApply(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, filePaths []string, maxSize int64,
+	verify *verifyFlags, policy rigging.FailurePolicy, owner *ownerFlags, applyConfig rigging.ApplyConfig, targetNamespaces []string) error {
+	if err := rigging.CheckKubectl(); err != nil {
+		return trace.Wrap(err)
+	}
+	archives := make([]*rigging.BundleArchive, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		data, err := verify.read(filePath)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		archive, err := rigging.UnpackWithLimit(bytes.NewReader(data), maxSize)
+		if err != nil {
+			return trace.Wrap(err, "failed to unpack %v", filePath)
+		}
+		archives = append(archives, archive)
+	}
+	archive := archives[0]
+	if len(archives) > 1 {
+		var err error
+		archive, err = rigging.ComposeBundles(archives...)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	ownerName := owner.owner
+	if ownerName == "" {
+		ownerName = archive.Metadata.Name
+	}
+	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
+		Client:        client,
+		Config:        config,
+		FailurePolicy: policy,
+		Owner:         ownerName,
+		ForceAdopt:    owner.forceAdopt,
+		HelmPolicy:    rigging.HelmPolicy(owner.helm),
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	applyConfig.Changeset = cs
+	if len(targetNamespaces) != 0 {
+		err = archive.ApplyToNamespaces(ctx, applyConfig, targetNamespaces)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		fmt.Printf("bundle %v:%v applied to namespaces %v\n", archive.Metadata.Name, archive.Metadata.Version, strings.Join(targetNamespaces, ", "))
+		return nil
+	}
+	err = archive.Apply(ctx, applyConfig)
+	if err != nil {
+		printOutcomes(err)
+		return trace.Wrap(err)
+	}
+	fmt.Printf("bundle %v:%v applied\n", archive.Metadata.Name, archive.Metadata.Version)
+	return nil
+}
+
 func status(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, resource rigging.Ref,
-	retryAttempts int, retryPeriod time.Duration) error {
+	retryAttempts int, retryPeriod, slowThreshold time.Duration, debugImage string, reportWriters []rigging.ReportWriter, nodes rigging.NodeFilter, sampleMetrics bool, strictReadiness, pendingTimeout time.Duration, recordHistory bool) error {
 	switch resource.Kind {
 	case rigging.KindChangeset:
 		cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
-			Client: client,
-			Config: config,
+			Client:                 client,
+			Config:                 config,
+			SlowOperationThreshold: slowThreshold,
+			DebugImage:             debugImage,
+			ReportWriters:          reportWriters,
+			SampleMetrics:          sampleMetrics,
+			StrictReadiness:        strictReadiness,
+			PendingTimeout:         pendingTimeout,
+			RecordStatusHistory:    recordHistory,
 		})
 		if err != nil {
 			return trace.Wrap(err)
@@ -302,26 +1370,29 @@ func status(ctx context.Context, client *kubernetes.Clientset, config *rest.Conf
 			return trace.Wrap(err)
 		}
 		updater, err := rigging.NewDSControl(rigging.DSConfig{
-			DaemonSet: ds,
-			Client:    client,
+			DaemonSet:       ds,
+			Client:          client,
+			Nodes:           nodes,
+			StrictReadiness: strictReadiness,
 		})
 		if err != nil {
 			return trace.Wrap(err)
 		}
-		return rigging.PollStatus(ctx, retryAttempts, retryPeriod, updater)
+		return rigging.PollStatusWithThreshold(ctx, retryAttempts, retryPeriod, slowThreshold, updater)
 	case rigging.KindDeployment:
 		deployment, err := client.Apps().Deployments(namespace).Get(resource.Name, metav1.GetOptions{})
 		if err != nil {
 			return trace.Wrap(err)
 		}
 		updater, err := rigging.NewDeploymentControl(rigging.DeploymentConfig{
-			Deployment: deployment,
-			Client:     client,
+			Deployment:      deployment,
+			Client:          client,
+			StrictReadiness: strictReadiness,
 		})
 		if err != nil {
 			return trace.Wrap(err)
 		}
-		return rigging.PollStatus(ctx, retryAttempts, retryPeriod, updater)
+		return rigging.PollStatusWithThreshold(ctx, retryAttempts, retryPeriod, slowThreshold, updater)
 	}
 	return trace.BadParameter("don't know how to check status of %v", resource.Kind)
 }
@@ -331,10 +1402,60 @@ const (
 	outputText = "text"
 	outputJSON = "json"
 	// humanDateFormat is a human readable date formatting
-	humanDateFormat = "Mon Jan _2 15:04 UTC"
-	changesetEnvVar = "RIG_CHANGESET"
+	humanDateFormat        = "Mon Jan _2 15:04 UTC"
+	changesetEnvVar        = "RIG_CHANGESET"
+	gitTokenEnvVar         = "RIG_GIT_TOKEN"
+	urlTokenEnvVar         = "RIG_URL_TOKEN"
+	registryPasswordEnvVar = "RIG_REGISTRY_PASSWORD"
+	apiTokenEnvVar         = "RIG_API_TOKEN"
+	initiatorEnvVar        = "RIG_INITIATOR"
 )
 
+func rollingRestart(ctx context.Context, client *kubernetes.Clientset, selector string, batchSize int, checkNodes bool) error {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return trace.BadParameter("invalid selector %q: %v", selector, err)
+	}
+	var checker rigging.HealthChecker
+	if checkNodes {
+		nodes, err := rigging.NewNodesReporter(rigging.NodesConfig{Client: client})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		checker = func(ctx context.Context) error {
+			return rigging.PollStatus(ctx, 0, 0, nodes)
+		}
+	}
+	if err := rigging.RollingRestart(ctx, client, parsed, batchSize, checker); err != nil {
+		return trace.Wrap(err)
+	}
+	fmt.Printf("restarted workloads matching %q\n", selector)
+	return nil
+}
+
+func report(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, ref rigging.Ref, output string) error {
+	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
+		Client: client,
+		Config: config,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	report, err := cs.Report(ctx, namespace, ref.Name, nil)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if output == "" {
+		fmt.Printf("%s\n", data)
+		return nil
+	}
+	return trace.ConvertSystemError(ioutil.WriteFile(output, data, 0644))
+}
+
 func get(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, ref rigging.Ref, output string) error {
 	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
 		Client: client,
@@ -365,9 +1486,10 @@ func get(ctx context.Context, client *kubernetes.Clientset, config *rest.Config,
 			w := new(tabwriter.Writer)
 			w.Init(os.Stdout, 0, 8, 1, '\t', 0)
 			defer w.Flush()
-			fmt.Fprintf(w, "Name\tCreated\tStatus\tOperations\n")
+			fmt.Fprintf(w, "Name\tCreated\tStatus\tOperations\tInitiator\n")
 			for _, tr := range changesets.Items {
-				fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", tr.Name, tr.CreationTimestamp.Format(humanDateFormat), tr.Spec.Status, len(tr.Spec.Items))
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", tr.Name, tr.CreationTimestamp.Format(humanDateFormat), tr.Spec.Status, len(tr.Spec.Items),
+					tr.Annotations[rigging.InitiatorAnnotation])
 			}
 			return nil
 		}
@@ -388,8 +1510,7 @@ func get(ctx context.Context, client *kubernetes.Clientset, config *rest.Config,
 		fmt.Printf("Changeset %v in namespace %v\n\n", tr.Name, tr.Namespace)
 		w := new(tabwriter.Writer)
 		w.Init(os.Stdout, 0, 8, 1, '\t', 0)
-		defer w.Flush()
-		fmt.Fprintf(w, "Operation\tTime\tStatus\tDescription\n")
+		fmt.Fprintf(w, "Operation\tTime\tStatus\tDuration\tWait\tInitiator\tDescription\n")
 		for i, op := range tr.Spec.Items {
 			var info string
 			opInfo, err := rigging.GetOperationInfo(op)
@@ -398,12 +1519,37 @@ func get(ctx context.Context, client *kubernetes.Clientset, config *rest.Config,
 			} else {
 				info = opInfo.String()
 			}
-			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", i, op.CreationTimestamp.Format(humanDateFormat), op.Status, info)
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", i, op.CreationTimestamp.Format(humanDateFormat), op.Status,
+				op.Duration(), op.WaitDuration(), op.Initiator, info)
 		}
+		w.Flush()
+		printStatusHistory(tr.Spec.Items)
 		return nil
 	}
 }
 
+// printStatusHistory prints the statuses observed during status waits
+// of the operations that have recorded them
+func printStatusHistory(items []rigging.ChangesetItem) {
+	for i, op := range items {
+		if len(op.StatusHistory) == 0 {
+			continue
+		}
+		fmt.Printf("\nStatus history of operation %v\n\n", i)
+		w := new(tabwriter.Writer)
+		w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+		fmt.Fprintf(w, "Time\tChecks\tStatus\n")
+		for _, snapshot := range op.StatusHistory {
+			status := "ready"
+			if !snapshot.Ready {
+				status = snapshot.Message
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", snapshot.Time.Format(humanDateFormat), snapshot.Count, status)
+		}
+		w.Flush()
+	}
+}
+
 func csDelete(ctx context.Context, client *kubernetes.Clientset, config *rest.Config, namespace string, tr rigging.Ref, force bool) error {
 	cs, err := rigging.NewChangeset(ctx, rigging.ChangesetConfig{
 		Client: client,
@@ -0,0 +1,124 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Transformer modifies manifests before they are applied
+type Transformer interface {
+	// Transform returns the transformed manifest stream
+	Transform(data []byte) ([]byte, error)
+}
+
+// Transform applies the transformers to the manifest stream in order
+func Transform(data []byte, transformers ...Transformer) ([]byte, error) {
+	var err error
+	for _, transformer := range transformers {
+		data, err = transformer.Transform(data)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return data, nil
+}
+
+// PodSpecTransformer modifies pod specs of all workloads in the manifest stream,
+// objects without pod templates are left intact
+type PodSpecTransformer func(object *unstructured.Unstructured, spec *v1.PodSpec) error
+
+// Transform applies the function to pod specs of all workloads
+func (fn PodSpecTransformer) Transform(data []byte) ([]byte, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, object := range objects {
+		spec, err := GetPodSpec(object)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+		if err := fn(object, spec); err != nil {
+			return nil, trace.Wrap(err, "failed to transform %v %v", object.GetKind(), object.GetName())
+		}
+		if err := SetPodSpec(object, *spec); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return EncodeObjects(objects)
+}
+
+// EnvSubst substitutes ${VAR} references in manifests.
+// Use $${VAR} to produce a literal ${VAR}
+type EnvSubst struct {
+	// Vars maps variable names to values
+	Vars map[string]string
+	// Strict fails the transformation if any referenced variable is undefined,
+	// otherwise undefined variables are replaced with empty strings
+	Strict bool
+	// FromEnv looks up variables missing from Vars in the process environment
+	FromEnv bool
+}
+
+// Transform substitutes variable references in the manifest stream
+func (e EnvSubst) Transform(data []byte) ([]byte, error) {
+	missing := make(map[string]struct{})
+	out := envVarRegexp.ReplaceAllFunc(data, func(match []byte) []byte {
+		if strings.HasPrefix(string(match), "$$") {
+			return match[1:]
+		}
+		name := string(match[2 : len(match)-1])
+		if value, ok := e.lookup(name); ok {
+			return []byte(value)
+		}
+		missing[name] = struct{}{}
+		return nil
+	})
+	if e.Strict && len(missing) != 0 {
+		var names []string
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, trace.BadParameter("undefined variables: %v", strings.Join(names, ", "))
+	}
+	return out, nil
+}
+
+func (e EnvSubst) lookup(name string) (string, bool) {
+	if value, ok := e.Vars[name]; ok {
+		return value, true
+	}
+	if e.FromEnv {
+		return os.LookupEnv(name)
+	}
+	return "", false
+}
+
+// envVarRegexp matches ${VAR} references and escaped $${VAR} sequences
+var envVarRegexp = regexp.MustCompile(`\$?\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
@@ -0,0 +1,305 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type TransformSuite struct{}
+
+var _ = Suite(&TransformSuite{})
+
+func (s *TransformSuite) TestEnvSubst(c *C) {
+	tcs := []struct {
+		subst  EnvSubst
+		input  string
+		output string
+		error  bool
+	}{
+		{
+			subst:  EnvSubst{Vars: map[string]string{"IMAGE": "nginx:1.15"}},
+			input:  "image: ${IMAGE}",
+			output: "image: nginx:1.15",
+		},
+		{
+			subst:  EnvSubst{Vars: map[string]string{"A": "a"}},
+			input:  "value: ${A}-${B}",
+			output: "value: a-",
+		},
+		{
+			subst: EnvSubst{Vars: map[string]string{"A": "a"}, Strict: true},
+			input: "value: ${A}-${B}",
+			error: true,
+		},
+		{
+			subst:  EnvSubst{Strict: true},
+			input:  "command: echo $${HOME} $HOME",
+			output: "command: echo ${HOME} $HOME",
+		},
+	}
+	for i, tc := range tcs {
+		comment := Commentf("test case %v", i+1)
+		out, err := tc.subst.Transform([]byte(tc.input))
+		if tc.error {
+			c.Assert(err, NotNil, comment)
+		} else {
+			c.Assert(err, IsNil, comment)
+			c.Assert(string(out), Equals, tc.output, comment)
+		}
+	}
+}
+
+func (s *TransformSuite) TestSecurityHardening(c *C) {
+	data := []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+        securityContext:
+          capabilities:
+            add: [NET_ADMIN, NET_BIND_SERVICE]
+      - name: proxy
+        image: proxy:1.0
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+`)
+	hardening := SecurityHardening{
+		ExemptContainers:    []string{"proxy"},
+		AllowedCapabilities: []v1.Capability{"NET_BIND_SERVICE"},
+	}
+	out, err := hardening.Transform(data)
+	c.Assert(err, IsNil)
+	objects, err := DecodeObjects(out)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 2)
+	spec, err := GetPodSpec(objects[0])
+	c.Assert(err, IsNil)
+	sc := spec.Containers[0].SecurityContext
+	c.Assert(*sc.RunAsNonRoot, Equals, true)
+	c.Assert(*sc.ReadOnlyRootFilesystem, Equals, true)
+	c.Assert(*sc.AllowPrivilegeEscalation, Equals, false)
+	c.Assert(sc.Capabilities.Add, DeepEquals, []v1.Capability{"NET_BIND_SERVICE"})
+	c.Assert(sc.Capabilities.Drop, DeepEquals, []v1.Capability{"ALL"})
+	c.Assert(spec.Containers[1].SecurityContext, IsNil)
+}
+
+func (s *TransformSuite) TestPodSpecKeepsUnknownFields(c *C) {
+	data := []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      topologySpreadConstraints:
+      - maxSkew: 1
+        topologyKey: zone
+        whenUnsatisfiable: DoNotSchedule
+      securityContext:
+        seccompProfile:
+          type: RuntimeDefault
+      containers:
+      - name: app
+        image: app:1.0
+        securityContext:
+          seccompProfile:
+            type: RuntimeDefault
+`)
+	out, err := SecurityHardening{}.Transform(data)
+	c.Assert(err, IsNil)
+	objects, err := DecodeObjects(out)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 1)
+	spec := objects[0].Object["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	constraints, found, err := unstructured.NestedSlice(spec, "topologySpreadConstraints")
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, true)
+	c.Assert(constraints, HasLen, 1)
+	profile, _, err := unstructured.NestedString(spec, "securityContext", "seccompProfile", "type")
+	c.Assert(err, IsNil)
+	c.Assert(profile, Equals, "RuntimeDefault")
+	nonRoot, _, err := unstructured.NestedBool(spec, "securityContext", "runAsNonRoot")
+	c.Assert(err, IsNil)
+	c.Assert(nonRoot, Equals, true)
+	containers, _, err := unstructured.NestedSlice(spec, "containers")
+	c.Assert(err, IsNil)
+	container := containers[0].(map[string]interface{})
+	profile, _, err = unstructured.NestedString(container, "securityContext", "seccompProfile", "type")
+	c.Assert(err, IsNil)
+	c.Assert(profile, Equals, "RuntimeDefault")
+	readOnly, _, err := unstructured.NestedBool(container, "securityContext", "readOnlyRootFilesystem")
+	c.Assert(err, IsNil)
+	c.Assert(readOnly, Equals, true)
+}
+
+func (s *TransformSuite) TestImagePullSecret(c *C) {
+	data := []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: apps
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: api
+  namespace: apps
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: apps
+spec:
+  template:
+    spec:
+      containers:
+      - name: api
+        image: registry.example.com/api:1.0
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrate
+        image: registry.example.com/migrate:1.0
+`)
+	secret, err := NewImagePullSecret("", "registry.example.com", "user", "pass")
+	c.Assert(err, IsNil)
+	out, err := secret.Transform(data)
+	c.Assert(err, IsNil)
+	objects, err := DecodeObjects(out)
+	c.Assert(err, IsNil)
+	var refs []string
+	for _, object := range objects {
+		refs = append(refs, object.GetKind()+"/"+Namespace(object.GetNamespace())+"/"+object.GetName())
+	}
+	c.Assert(refs, DeepEquals, []string{
+		"Namespace/default/apps",
+		"Secret/apps/rigging-registry",
+		"ServiceAccount/apps/api",
+		"Deployment/apps/api",
+		"Secret/default/rigging-registry",
+		"Job/default/migrate",
+	})
+	c.Assert(objects[1].Object["type"], Equals, string(v1.SecretTypeDockerConfigJson))
+	secrets, _, err := unstructured.NestedSlice(objects[2].Object, "imagePullSecrets")
+	c.Assert(err, IsNil)
+	c.Assert(secrets, DeepEquals, []interface{}{map[string]interface{}{"name": DefaultImagePullSecretName}})
+	for _, object := range []*unstructured.Unstructured{objects[3], objects[5]} {
+		spec, err := GetPodSpec(object)
+		c.Assert(err, IsNil)
+		c.Assert(spec.ImagePullSecrets, DeepEquals, []v1.LocalObjectReference{{Name: DefaultImagePullSecretName}})
+	}
+}
+
+func (s *TransformSuite) TestNamespaceTemplate(c *C) {
+	data := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ${NAMESPACE}-config
+  namespace: apps
+  labels:
+    tenant: ${NAMESPACE}
+data:
+  greeting: $${NAMESPACE} ${GREETING}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: ${NAMESPACE}-reader
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: shared-reader
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: ${NAMESPACE}-reader
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: shared-reader
+subjects:
+- kind: ServiceAccount
+  name: app
+  namespace: apps
+- kind: ServiceAccount
+  name: monitoring
+  namespace: kube-system
+- kind: ServiceAccount
+  name: default
+- kind: User
+  name: admin
+`)
+	out, err := NamespaceTemplate{Namespace: "tenant-a"}.Transform(data)
+	c.Assert(err, IsNil)
+	objects, err := DecodeObjects(out)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 3)
+	c.Assert(objects[0].GetNamespace(), Equals, "tenant-a")
+	c.Assert(objects[0].GetName(), Equals, "tenant-a-config")
+	c.Assert(objects[0].GetLabels(), DeepEquals, map[string]string{"tenant": "tenant-a"})
+	greeting, _, err := unstructured.NestedString(objects[0].Object, "data", "greeting")
+	c.Assert(err, IsNil)
+	c.Assert(greeting, Equals, "${NAMESPACE} ${GREETING}")
+	c.Assert(objects[1].GetNamespace(), Equals, "")
+	c.Assert(objects[1].GetName(), Equals, "tenant-a-reader")
+	c.Assert(objects[2].GetName(), Equals, "tenant-a-reader")
+	subjects, _, err := unstructured.NestedSlice(objects[2].Object, "subjects")
+	c.Assert(err, IsNil)
+	var namespaces []interface{}
+	for _, subject := range subjects {
+		namespaces = append(namespaces, subject.(map[string]interface{})["namespace"])
+	}
+	c.Assert(namespaces, DeepEquals, []interface{}{"tenant-a", "kube-system", "tenant-a", nil})
+
+	out, err = SharedObjects{}.Transform(data)
+	c.Assert(err, IsNil)
+	objects, err = DecodeObjects(out)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 1)
+	c.Assert(objects[0].GetName(), Equals, "shared-reader")
+
+	_, err = SharedObjects{}.Transform([]byte(`apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: shared
+subjects:
+- kind: ServiceAccount
+  name: app
+  namespace: ${NAMESPACE}
+`))
+	c.Assert(trace.IsBadParameter(err), Equals, true, Commentf("%v", err))
+}
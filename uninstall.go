@@ -0,0 +1,220 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"sort"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeleteChangesetResources deletes the resources created by the changeset,
+// dependents before their dependencies, e.g. deployments before the config maps,
+// service accounts and roles they use, and waits for each resource to be deleted.
+// Resources the changeset has only updated are left in place, and resources
+// that have already been deleted are skipped.
+// The uninstall can be resumed if it fails. The deletions of the kinds changesets
+// support are recorded in the changeset named UninstallChangesetName, so that
+// it can be reverted to restore the resources, resources of other kinds
+// are deleted with Objects without being recorded and can not be restored.
+// The uninstall changeset is frozen once all resources are deleted
+func (cs *Changeset) DeleteChangesetResources(ctx context.Context, changesetNamespace, changesetName string) error {
+	ctx = cs.logContext(ctx)
+	tr, err := cs.get(ctx, changesetNamespace, changesetName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	log := contextLogger(ctx).WithFields(log.Fields{
+		"cs": tr.String(),
+	})
+	resources, err := createdResources(tr.Spec.Items)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	uninstallName := UninstallChangesetName(changesetName)
+	var deleted bool
+	for _, resource := range resources {
+		err := cs.getResource(resource.ref)
+		if trace.IsNotFound(err) {
+			log.Infof("%v has already been deleted, skipping", resource.ref)
+			continue
+		}
+		if trace.IsNotImplemented(err) {
+			// changesets can not record the deletion of kinds without typed support
+			log.Infof("Deleting %v", resource.ref)
+			err := DeleteObject(ctx, cs.Objects, resource.ref, DeleteOptions{WaitForFinalizers: true, Timeout: deleteTimeout})
+			if err != nil {
+				return trace.Wrap(err, "failed to delete %v", resource.ref)
+			}
+			continue
+		}
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		log.Infof("Deleting %v", resource.ref)
+		err = cs.DeleteResource(ctx, changesetNamespace, uninstallName, resource.ref.Namespace,
+			Ref{Kind: resource.ref.Kind, Name: resource.ref.Name}, true)
+		if err != nil && !trace.IsNotFound(err) {
+			return trace.Wrap(err)
+		}
+		deleted = true
+		err = waitForObjectDeletion(func() error {
+			return cs.getResource(resource.ref)
+		})
+		if err != nil {
+			return trace.Wrap(err, "failed to wait for %v to be deleted", resource.ref)
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	return trace.Wrap(cs.Freeze(ctx, changesetNamespace, uninstallName))
+}
+
+// UninstallChangesetName returns the name of the changeset
+// recording the deletion of the resources of the changeset
+func UninstallChangesetName(changesetName string) string {
+	return changesetName + "-uninstall"
+}
+
+// getResource returns NotFound if the resource does not exist,
+// and NotImplemented if changesets can not delete resources of its kind
+func (cs *Changeset) getResource(ref ObjectRef) error {
+	options := metav1.GetOptions{}
+	var err error
+	switch ref.Kind {
+	case KindDaemonSet:
+		_, err = cs.Client.AppsV1().DaemonSets(ref.Namespace).Get(ref.Name, options)
+	case KindStatefulSet:
+		_, err = cs.Client.AppsV1().StatefulSets(ref.Namespace).Get(ref.Name, options)
+	case KindJob:
+		_, err = cs.Client.BatchV1().Jobs(ref.Namespace).Get(ref.Name, options)
+	case KindReplicationController:
+		_, err = cs.Client.CoreV1().ReplicationControllers(ref.Namespace).Get(ref.Name, options)
+	case KindDeployment:
+		_, err = cs.Client.AppsV1().Deployments(ref.Namespace).Get(ref.Name, options)
+	case KindService:
+		_, err = cs.Client.CoreV1().Services(ref.Namespace).Get(ref.Name, options)
+	case KindConfigMap:
+		_, err = cs.Client.CoreV1().ConfigMaps(ref.Namespace).Get(ref.Name, options)
+	case KindSecret:
+		_, err = cs.Client.CoreV1().Secrets(ref.Namespace).Get(ref.Name, options)
+	case KindServiceAccount:
+		_, err = cs.Client.CoreV1().ServiceAccounts(ref.Namespace).Get(ref.Name, options)
+	case KindRole:
+		_, err = cs.Client.RbacV1().Roles(ref.Namespace).Get(ref.Name, options)
+	case KindClusterRole:
+		_, err = cs.Client.RbacV1().ClusterRoles().Get(ref.Name, options)
+	case KindRoleBinding:
+		_, err = cs.Client.RbacV1().RoleBindings(ref.Namespace).Get(ref.Name, options)
+	case KindClusterRoleBinding:
+		_, err = cs.Client.RbacV1().ClusterRoleBindings().Get(ref.Name, options)
+	case KindPodSecurityPolicy:
+		_, err = cs.Client.ExtensionsV1beta1().PodSecurityPolicies().Get(ref.Name, options)
+	default:
+		return trace.NotImplemented("unsupported kind %v", ref.Kind)
+	}
+	return ConvertError(err)
+}
+
+// createdResource is a resource created by a changeset
+type createdResource struct {
+	ref    ObjectRef
+	header *ResourceHeader
+	// data is the last spec of the resource applied by the changeset
+	data string
+}
+
+// createdResources returns the resources created and not deleted by the
+// operations, in the order they should be deleted: by deletionTier of their kind
+// and within a tier in the reverse order of creation
+func createdResources(items []ChangesetItem) ([]createdResource, error) {
+	var resources []createdResource
+	created := make(map[string]int)
+	for _, item := range items {
+		info, err := GetOperationInfo(item)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		header := info.To
+		if header == nil {
+			header = info.From
+		}
+		if header == nil {
+			continue
+		}
+		ref := ObjectRef{
+			APIVersion: header.APIVersion,
+			Kind:       header.Kind,
+			Name:       header.Name,
+		}
+		if !IsClusterScoped(header.Kind) {
+			ref.Namespace = Namespace(header.Namespace)
+		}
+		index, ok := created[ref.key()]
+		switch {
+		case info.To == nil && ok:
+			// the changeset has deleted the resource it created
+			resources[index].data = ""
+		case info.To != nil && ok:
+			resources[index].header = info.To
+			resources[index].data = item.To
+		case info.To != nil && info.From == nil:
+			created[ref.key()] = len(resources)
+			resources = append(resources, createdResource{ref: ref, header: info.To, data: item.To})
+		}
+	}
+	var out []createdResource
+	for i := len(resources) - 1; i >= 0; i-- {
+		if resources[i].data != "" {
+			out = append(out, resources[i])
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return deletionTier(out[i].ref.Kind) < deletionTier(out[j].ref.Kind)
+	})
+	return out, nil
+}
+
+// deletionTier returns the order in which resources of the kind are deleted,
+// so that no resource is deleted while the resources that use it still exist.
+// Custom resources are deleted first while the operators processing their
+// finalizers are still running, namespaces and custom resource definitions last
+func deletionTier(kind string) int {
+	switch kind {
+	case KindDeployment, KindDaemonSet, KindStatefulSet, KindReplicationController, KindJob:
+		return 1
+	case KindService:
+		return 2
+	case KindConfigMap, KindSecret:
+		return 3
+	case KindRoleBinding, KindClusterRoleBinding:
+		return 4
+	case KindRole, KindClusterRole:
+		return 5
+	case KindServiceAccount:
+		return 6
+	case KindPodSecurityPolicy:
+		return 7
+	case KindNamespace, "CustomResourceDefinition":
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type UninstallSuite struct{}
+
+var _ = Suite(&UninstallSuite{})
+
+func (s *UninstallSuite) TestCreatedResources(c *C) {
+	spec := func(kind, name, version string) string {
+		return fmt.Sprintf("apiVersion: v1\nkind: %v\nmetadata:\n  name: %v\n  labels:\n    version: %q\n", kind, name, version)
+	}
+	items := []ChangesetItem{
+		{To: spec(KindNamespace, "apps", "1")},
+		{To: spec("CustomResourceDefinition", "certificates.cert-manager.io", "1")},
+		{To: spec(KindServiceAccount, "app", "1")},
+		{To: spec(KindConfigMap, "app", "1")},
+		{To: spec(KindDeployment, "app", "1")},
+		{To: spec(KindService, "app", "1")},
+		// custom resources are deleted before the operators processing their finalizers
+		{To: spec("Certificate", "app", "1")},
+		// updated, not created by the changeset
+		{From: spec(KindSecret, "shared", "1"), To: spec(KindSecret, "shared", "2")},
+		{From: spec(KindConfigMap, "app", "1"), To: spec(KindConfigMap, "app", "2")},
+		{To: spec(KindConfigMap, "temp", "1")},
+		{From: spec(KindConfigMap, "temp", "1")},
+		{To: spec(KindDaemonSet, "agent", "1")},
+	}
+	resources, err := createdResources(items)
+	c.Assert(err, IsNil)
+	var refs []string
+	for _, resource := range resources {
+		refs = append(refs, resource.ref.String())
+	}
+	c.Assert(refs, DeepEquals, []string{
+		"Certificate/default/app",
+		"DaemonSet/default/agent",
+		"Deployment/default/app",
+		"Service/default/app",
+		"ConfigMap/default/app",
+		"ServiceAccount/default/app",
+		"CustomResourceDefinition/certificates.cert-manager.io",
+		"Namespace/apps",
+	})
+	c.Assert(resources[4].header.Labels["version"], Equals, "2")
+}
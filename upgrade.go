@@ -0,0 +1,469 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// UpgradePhase is a phase of the upgrade workflow
+type UpgradePhase string
+
+const (
+	// PhasePreflight dry-runs workload pod templates
+	PhasePreflight UpgradePhase = "preflight"
+	// PhasePlan plans the changes of all waves and waits for the approval
+	PhasePlan UpgradePhase = "plan"
+	// PhaseBackup backs up state before any changes are made
+	PhaseBackup UpgradePhase = "backup"
+	// PhaseApply applies waves in the context of the changeset
+	PhaseApply UpgradePhase = "apply"
+	// PhaseRelease resumes the rollout of deployments staged in paused state
+	PhaseRelease UpgradePhase = "release"
+	// PhaseHealthCheck waits for all resources to become ready
+	PhaseHealthCheck UpgradePhase = "health-check"
+	// PhaseCommit freezes the changeset
+	PhaseCommit UpgradePhase = "commit"
+	// PhaseRollback reverts the changeset after a failure
+	PhaseRollback UpgradePhase = "rollback"
+)
+
+// UpgradeWave is a group of manifests applied together
+type UpgradeWave struct {
+	// Name is the wave name
+	Name string
+	// Data is the manifest stream
+	Data []byte
+}
+
+// UpgradeHook is called before or after a phase of the upgrade workflow
+type UpgradeHook func(ctx context.Context, phase UpgradePhase) error
+
+// UpgradeConfig is an upgrade workflow configuration
+type UpgradeConfig struct {
+	// Changeset is the changeset client
+	Changeset *Changeset
+	// ChangesetNamespace is the namespace of the changeset
+	ChangesetNamespace string
+	// ChangesetName is the name of the changeset
+	ChangesetName string
+	// Client is k8s client
+	Client *kubernetes.Clientset
+	// Waves lists manifests applied in order, each wave is applied
+	// once the previous one is ready
+	Waves []UpgradeWave
+	// Filter optionally selects the resources of waves to apply,
+	// waves left without resources are skipped
+	Filter *ResourceFilter
+	// SkipPreflight disables the dry-run of workload pod templates
+	SkipPreflight bool
+	// ImageCheck optionally verifies during preflight that the images
+	// of all waves exist in their registries
+	ImageCheck *ImageCheckConfig
+	// Approver is an optional approver of the plan, if set, the workflow
+	// plans the changes of all waves and blocks until the plan is approved
+	Approver Approver
+	// StagedRollout applies deployments of all waves with paused rollouts
+	// and releases them together once all waves have been applied
+	StagedRollout bool
+	// Backup is an optional function that backs up state before the upgrade
+	Backup func(ctx context.Context) error
+	// HealthCheck is an optional function with additional health checks
+	// run after all resources are ready
+	HealthCheck func(ctx context.Context) error
+	// Before is an optional hook called before each phase,
+	// an error aborts the workflow
+	Before UpgradeHook
+	// After is an optional hook called after each successful phase,
+	// an error aborts the workflow
+	After UpgradeHook
+	// RetryAttempts is the number of status attempts of each wave
+	RetryAttempts int
+	// RetryPeriod is the period between status attempts
+	RetryPeriod time.Duration
+}
+
+// CheckAndSetDefaults validates this configuration object and sets defaults
+func (c *UpgradeConfig) CheckAndSetDefaults() error {
+	var errors []error
+	if c.Changeset == nil {
+		errors = append(errors, trace.BadParameter("missing parameter Changeset"))
+	}
+	if c.ChangesetName == "" {
+		errors = append(errors, trace.BadParameter("missing parameter ChangesetName"))
+	}
+	if c.Client == nil {
+		errors = append(errors, trace.BadParameter("missing parameter Client"))
+	}
+	if len(c.Waves) == 0 {
+		errors = append(errors, trace.BadParameter("missing parameter Waves"))
+	}
+	c.ChangesetNamespace = Namespace(c.ChangesetNamespace)
+	return trace.NewAggregate(errors...)
+}
+
+// filterWaves returns the waves with resources matching the filter
+func filterWaves(filter *ResourceFilter, waves []UpgradeWave) ([]UpgradeWave, error) {
+	var out []UpgradeWave
+	for _, wave := range waves {
+		data, ok, err := filterManifests(filter, wave.Data)
+		if err != nil {
+			return nil, trace.Wrap(err, "wave %v", wave.Name)
+		}
+		if ok {
+			out = append(out, UpgradeWave{Name: wave.Name, Data: data})
+		}
+	}
+	return out, nil
+}
+
+// NewUpgradeWorkflow returns a new upgrade workflow
+func NewUpgradeWorkflow(config UpgradeConfig) (*UpgradeWorkflow, error) {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	waves, err := filterWaves(config.Filter, config.Waves)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if len(waves) == 0 {
+		return nil, trace.NotFound("no resources match the filter")
+	}
+	config.Waves = waves
+	return &UpgradeWorkflow{
+		UpgradeConfig: config,
+		Entry: log.WithFields(log.Fields{
+			"cs": config.ChangesetNamespace + "/" + config.ChangesetName,
+		}),
+	}, nil
+}
+
+// UpgradeWorkflow runs the canonical upgrade sequence: preflight, optional
+// plan approval, backup, apply by waves, health checks and commit. If apply or health checks fail,
+// the changeset is rolled back
+type UpgradeWorkflow struct {
+	UpgradeConfig
+	*log.Entry
+}
+
+// Run runs the upgrade workflow, if it fails after the changeset has been
+// updated, the changeset is rolled back and RollbackError is returned
+func (u *UpgradeWorkflow) Run(ctx context.Context) error {
+	if !u.SkipPreflight {
+		if err := u.phase(ctx, PhasePreflight, u.preflight); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if u.Approver != nil {
+		if err := u.phase(ctx, PhasePlan, u.plan); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if u.Backup != nil {
+		if err := u.phase(ctx, PhaseBackup, u.Backup); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	err := u.phase(ctx, PhaseApply, u.apply)
+	if err == nil && u.StagedRollout {
+		err = u.phase(ctx, PhaseRelease, u.release)
+	}
+	if err == nil {
+		err = u.phase(ctx, PhaseHealthCheck, u.healthCheck)
+	}
+	if err != nil {
+		u.Warningf("upgrade failed, rolling back: %v", err)
+		errRollback := u.phase(ctx, PhaseRollback, u.rollback)
+		return trace.Wrap(&RollbackError{Err: err, RevertErr: errRollback})
+	}
+	return trace.Wrap(u.phase(ctx, PhaseCommit, u.commit))
+}
+
+// phase runs the phase function surrounded by hooks
+func (u *UpgradeWorkflow) phase(ctx context.Context, phase UpgradePhase, fn func(context.Context) error) error {
+	if err := ctx.Err(); err != nil && phase != PhaseRollback {
+		return trace.Wrap(err)
+	}
+	u.Infof("%v", phase)
+	if u.Before != nil {
+		if err := u.Before(ctx, phase); err != nil {
+			return trace.Wrap(err, "%v hook failed", phase)
+		}
+	}
+	if err := fn(ctx); err != nil {
+		return trace.Wrap(err, "%v failed", phase)
+	}
+	if u.After != nil {
+		if err := u.After(ctx, phase); err != nil {
+			return trace.Wrap(err, "%v hook failed", phase)
+		}
+	}
+	return nil
+}
+
+func (u *UpgradeWorkflow) preflight(ctx context.Context) error {
+	var bundle bytes.Buffer
+	for _, wave := range u.Waves {
+		bundle.WriteString("---\n")
+		bundle.Write(wave.Data)
+		bundle.WriteString("\n")
+	}
+	for _, wave := range u.Waves {
+		if err := preflightPodTemplates(ctx, u.Client, DefaultNamespace, wave.Data, bundle.Bytes()); err != nil {
+			return trace.Wrap(err, "wave %v", wave.Name)
+		}
+		if u.ImageCheck == nil {
+			continue
+		}
+		imageCheck := *u.ImageCheck
+		if imageCheck.Client == nil {
+			imageCheck.Client = u.Client
+		}
+		if err := PreflightImages(ctx, imageCheck, wave.Data); err != nil {
+			return trace.Wrap(err, "wave %v", wave.Name)
+		}
+	}
+	return nil
+}
+
+// plan plans the changes of all waves and waits for the approval
+func (u *UpgradeWorkflow) plan(ctx context.Context) error {
+	result := &ChangePlan{Bundle: u.ChangesetName}
+	for _, wave := range u.Waves {
+		bundle, err := NewBundle(wave.Name, DefaultNamespace, wave.Data)
+		if err != nil {
+			return trace.Wrap(err, "wave %v", wave.Name)
+		}
+		if u.Client != nil {
+			bundle.Discovery = u.Client.Discovery()
+		}
+		wavePlan, err := Plan(ctx, bundle)
+		if err != nil {
+			return trace.Wrap(err, "wave %v", wave.Name)
+		}
+		result.Changes = append(result.Changes, wavePlan.Changes...)
+	}
+	u.Infof("plan: %v", result)
+	return trace.Wrap(u.Approver.Approve(ctx, result))
+}
+
+func (u *UpgradeWorkflow) apply(ctx context.Context) error {
+	for _, wave := range u.Waves {
+		u.Infof("apply wave %v", wave.Name)
+		data := wave.Data
+		if u.StagedRollout {
+			var err error
+			data, err = pauseDeployments(data)
+			if err != nil {
+				return trace.Wrap(err)
+			}
+		}
+		err := u.Changeset.Upsert(ctx, u.ChangesetNamespace, u.ChangesetName, data)
+		if err != nil {
+			return trace.Wrap(err, "failed to apply wave %v", wave.Name)
+		}
+		if u.StagedRollout {
+			// paused deployments do not become ready until released,
+			// the other resources of the wave are ready before the next wave
+			if err := u.waitUnpaused(ctx, wave); err != nil {
+				return trace.Wrap(err, "wave %v is not ready", wave.Name)
+			}
+			continue
+		}
+		err = u.Changeset.Status(ctx, u.ChangesetNamespace, u.ChangesetName, u.RetryAttempts, u.RetryPeriod)
+		if err != nil {
+			return trace.Wrap(err, "wave %v is not ready", wave.Name)
+		}
+	}
+	return nil
+}
+
+// waitUnpaused waits for the resources of the wave applied with a staged
+// rollout to become ready, except for the paused deployments
+func (u *UpgradeWorkflow) waitUnpaused(ctx context.Context, wave UpgradeWave) error {
+	bundle, err := NewBundle(wave.Name, DefaultNamespace, wave.Data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var objects []*unstructured.Unstructured
+	for _, object := range bundle.Objects {
+		if object.GetKind() != KindDeployment {
+			objects = append(objects, object)
+		}
+	}
+	bundle.Objects = objects
+	live := u.Changeset.Objects
+	if live == nil {
+		live = KubectlObjects{}
+	}
+	return trace.Wrap(waitReady(ctx, live, bundle, u.RetryAttempts, u.RetryPeriod))
+}
+
+// release resumes rollouts of the deployments of all waves, records
+// the released deployments in the changeset and waits for it to become ready
+func (u *UpgradeWorkflow) release(ctx context.Context) error {
+	var deployments []*unstructured.Unstructured
+	for _, wave := range u.Waves {
+		objects, err := DecodeObjects(wave.Data)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		for _, object := range objects {
+			if object.GetKind() != KindDeployment {
+				continue
+			}
+			deployments = append(deployments, object)
+			if paused, _, _ := unstructured.NestedBool(object.Object, "spec", "paused"); paused {
+				// the manifest pauses the deployment itself
+				continue
+			}
+			control, err := NewDeploymentControl(DeploymentConfig{
+				Deployment: &appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      object.GetName(),
+						Namespace: Namespace(object.GetNamespace()),
+					},
+				},
+				Client: u.Client,
+			})
+			if err != nil {
+				return trace.Wrap(err)
+			}
+			if err := control.ResumeRollout(ctx); err != nil {
+				return trace.Wrap(err)
+			}
+		}
+	}
+	if err := u.Changeset.recordReleased(ctx, u.ChangesetNamespace, u.ChangesetName, deployments); err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(u.Changeset.Status(ctx, u.ChangesetNamespace, u.ChangesetName, u.RetryAttempts, u.RetryPeriod))
+}
+
+// recordReleased replaces the paused deployments recorded by the last operations
+// of the changeset with the released deployments from the manifests, so that
+// the changeset records the desired state of the deployments
+func (cs *Changeset) recordReleased(ctx context.Context, changesetNamespace, changesetName string, deployments []*unstructured.Unstructured) error {
+	tr, err := cs.get(ctx, changesetNamespace, changesetName)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	for _, deployment := range deployments {
+		item := lastOperation(tr, deployment)
+		if item == nil {
+			continue
+		}
+		objects, err := DecodeObjects([]byte(item.To))
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		paused, found, err := unstructured.NestedBool(deployment.Object, "spec", "paused")
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if found {
+			err = unstructured.SetNestedField(objects[0].Object, paused, "spec", "paused")
+		} else {
+			unstructured.RemoveNestedField(objects[0].Object, "spec", "paused")
+		}
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		to, err := EncodeObjects(objects)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		item.To = string(to)
+	}
+	_, err = cs.update(ctx, tr)
+	return trace.Wrap(err)
+}
+
+// lastOperation returns the last operation of the changeset that applied the object,
+// nil if there is none
+func lastOperation(tr *ChangesetResource, object *unstructured.Unstructured) *ChangesetItem {
+	for i := len(tr.Spec.Items) - 1; i >= 0; i-- {
+		item := &tr.Spec.Items[i]
+		if item.To == "" {
+			continue
+		}
+		header, err := ParseResourceHeader(strings.NewReader(item.To))
+		if err != nil {
+			continue
+		}
+		if header.Kind == object.GetKind() && Namespace(header.Namespace) == Namespace(object.GetNamespace()) &&
+			header.Name == object.GetName() {
+			return item
+		}
+	}
+	return nil
+}
+
+// pauseDeployments sets the paused flag on deployments in the manifest stream
+func pauseDeployments(data []byte) ([]byte, error) {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, object := range objects {
+		if object.GetKind() != KindDeployment {
+			continue
+		}
+		if err := unstructured.SetNestedField(object.Object, true, "spec", "paused"); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	}
+	return EncodeObjects(objects)
+}
+
+func (u *UpgradeWorkflow) healthCheck(ctx context.Context) error {
+	var data bytes.Buffer
+	for _, wave := range u.Waves {
+		data.WriteString("---\n")
+		data.Write(wave.Data)
+		data.WriteString("\n")
+	}
+	bundle, err := NewBundle(u.ChangesetName, DefaultNamespace, data.Bytes())
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := WaitReady(ctx, bundle, u.RetryAttempts, u.RetryPeriod); err != nil {
+		return trace.Wrap(err)
+	}
+	if u.HealthCheck != nil {
+		return trace.Wrap(u.HealthCheck(ctx))
+	}
+	return nil
+}
+
+func (u *UpgradeWorkflow) commit(ctx context.Context) error {
+	return trace.Wrap(u.Changeset.Freeze(ctx, u.ChangesetNamespace, u.ChangesetName))
+}
+
+func (u *UpgradeWorkflow) rollback(ctx context.Context) error {
+	return trace.Wrap(u.Changeset.Revert(ctx, u.ChangesetNamespace, u.ChangesetName))
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type UpgradeSuite struct{}
+
+var _ = Suite(&UpgradeSuite{})
+
+func (s *UpgradeSuite) TestRecordReleased(c *C) {
+	store, err := NewFileStore(c.MkDir())
+	c.Assert(err, IsNil)
+	c.Assert(store.Init(context.TODO()), IsNil)
+	cs := &Changeset{ChangesetConfig: ChangesetConfig{Store: store}}
+	tr, err := cs.Create(context.TODO(), DefaultNamespace, "upgrade")
+	c.Assert(err, IsNil)
+
+	manifest := []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: default
+spec:
+  replicas: 2
+`)
+	paused, err := pauseDeployments(manifest)
+	c.Assert(err, IsNil)
+	tr.Spec.Items = append(tr.Spec.Items, ChangesetItem{To: string(paused), Status: OpStatusCompleted})
+	_, err = cs.update(context.TODO(), tr)
+	c.Assert(err, IsNil)
+
+	deployments, err := DecodeObjects(manifest)
+	c.Assert(err, IsNil)
+	c.Assert(cs.recordReleased(context.TODO(), DefaultNamespace, "upgrade", deployments), IsNil)
+
+	tr, err = cs.get(context.TODO(), DefaultNamespace, "upgrade")
+	c.Assert(err, IsNil)
+	objects, err := DecodeObjects([]byte(tr.Spec.Items[0].To))
+	c.Assert(err, IsNil)
+	_, found, err := unstructured.NestedBool(objects[0].Object, "spec", "paused")
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, false)
+	replicas, _, err := unstructured.NestedInt64(objects[0].Object, "spec", "replicas")
+	c.Assert(err, IsNil)
+	c.Assert(replicas, Equals, int64(2))
+}
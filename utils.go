@@ -1,12 +1,11 @@
 package rigging
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os/exec"
+	"sort"
 	"time"
 
 	"github.com/gravitational/trace"
@@ -16,20 +15,42 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
-type action string
+// Action is a kubectl action performed on resources
+type Action string
 
 const (
-	ActionCreate  action = "create"
-	ActionDelete  action = "delete"
-	ActionReplace action = "replace"
-	ActionApply   action = "apply"
+	ActionCreate  Action = "create"
+	ActionDelete  Action = "delete"
+	ActionReplace Action = "replace"
+	ActionApply   Action = "apply"
+	ActionGet     Action = "get"
+	ActionPatch   Action = "patch"
 )
 
+// ParseAction parses the action name, returns BadParameter if the action is not supported
+func ParseAction(in string) (Action, error) {
+	act := Action(in)
+	if err := act.Check(); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return act, nil
+}
+
+// Check returns BadParameter if the action is not supported
+func (a Action) Check() error {
+	switch a {
+	case ActionCreate, ActionDelete, ActionReplace, ActionApply, ActionGet, ActionPatch:
+		return nil
+	}
+	return trace.BadParameter("unsupported action %q", string(a))
+}
+
 // StatusReporter reports the status of the resource.
 type StatusReporter interface {
 	// Status returns the state of the resource.
@@ -39,64 +60,47 @@ type StatusReporter interface {
 	Infof(message string, args ...interface{})
 }
 
+// CheckKubectl verifies that the default kubectl binary is available
+// and compatible with the API server, see Kubectl.Check
+func CheckKubectl() error {
+	_, err := Kubectl{}.Check()
+	return trace.Wrap(err)
+}
+
 // KubeCommand returns an exec.Command for kubectl with the supplied arguments.
 func KubeCommand(args ...string) *exec.Cmd {
-	return exec.Command("/usr/local/bin/kubectl", args...)
+	return Kubectl{}.Command(args...)
 }
 
 // FromFile performs action on the Kubernetes resources specified in the path supplied as an argument.
-func FromFile(act action, path string) ([]byte, error) {
-	cmd := KubeCommand(string(act), "-f", path)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return out, trace.Wrap(err)
-	}
-	return out, nil
+// The path is loaded with NewSource, e.g. a file, a directory, a glob pattern or a URL, see Kubectl.FromFile.
+// Additional arguments are passed to kubectl, e.g. the patch for ActionPatch
+func FromFile(act Action, path string, args ...string) ([]byte, error) {
+	return Kubectl{}.FromFile(act, path, args...)
 }
 
-// FromStdin performs action on the Kubernetes resources specified in the string supplied as an argument.
-func FromStdIn(act action, data string) ([]byte, error) {
-	cmd := KubeCommand(string(act), "-f", "-")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, trace.Wrap(err)
-	}
-
-	var b bytes.Buffer
-	cmd.Stdout = &b
-	cmd.Stderr = &b
-
-	if err := cmd.Start(); err != nil {
-		return b.Bytes(), trace.Wrap(err)
-	}
-
-	io.WriteString(stdin, data)
-	stdin.Close()
-
-	if err := cmd.Wait(); err != nil {
-		log.Errorf("%v", err)
-		return b.Bytes(), trace.Wrap(err)
-	}
+// FromFileRecursive performs action on the Kubernetes resources specified in the path
+// supplied as an argument including manifests in all subdirectories
+func FromFileRecursive(act Action, path string, args ...string) ([]byte, error) {
+	return Kubectl{}.FromFileRecursive(act, path, args...)
+}
 
-	return b.Bytes(), nil
+// FromStdin performs action on the Kubernetes resources specified in the string supplied as an argument.
+// Additional arguments are passed to kubectl
+func FromStdIn(act Action, data string, args ...string) ([]byte, error) {
+	return Kubectl{}.FromStdIn(act, data, args...)
 }
 
-// PollStatus polls status periodically
+// PollStatus polls status periodically, logs a warning with diagnostics
+// if the wait exceeds DefaultSlowOperationThreshold
 func PollStatus(ctx context.Context, retryAttempts int, retryPeriod time.Duration, reporter StatusReporter) error {
-	if retryAttempts == 0 {
-		retryAttempts = DefaultRetryAttempts
-	}
-	if retryPeriod == 0 {
-		retryPeriod = DefaultRetryPeriod
-	}
-	reporter.Infof("Checking status retryAttempts=%v, retryPeriod=%v", retryAttempts, retryPeriod)
-
-	return retry(ctx, retryAttempts, retryPeriod, reporter.Status)
+	return PollStatusWithThreshold(ctx, retryAttempts, retryPeriod, DefaultSlowOperationThreshold, reporter)
 }
 
-// CollectPods collects pods matched by fn
+// CollectPods collects pods matched by fn sorted by UID, the node of each pod
+// is in its Spec.NodeName, see CollectChildren to collect children of any kind
 func CollectPods(namespace string, matchLabels map[string]string, entry *log.Entry, client *kubernetes.Clientset,
-	fn func(metav1.OwnerReference) bool) (map[string]v1.Pod, error) {
+	fn func(metav1.OwnerReference) bool) ([]v1.Pod, error) {
 	set := make(labels.Set)
 	for key, val := range matchLabels {
 		set[key] = val
@@ -109,28 +113,58 @@ func CollectPods(namespace string, matchLabels map[string]string, entry *log.Ent
 		return nil, ConvertError(err)
 	}
 
-	pods := make(map[string]v1.Pod, 0)
+	var pods []v1.Pod
 	for _, pod := range podList.Items {
 		for _, ref := range pod.OwnerReferences {
 			if fn(ref) {
-				pods[pod.Spec.NodeName] = pod
-				entry.Infof("found pod %v on node %v", formatMeta(pod.ObjectMeta), pod.Spec.NodeName)
+				pods = append(pods, pod)
+				entry.Infof("found pod %v on node %v", FormatMeta(pod.ObjectMeta), pod.Spec.NodeName)
+				break
 			}
 		}
 	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].UID < pods[j].UID
+	})
 	return pods, nil
 }
 
+// podOnNode returns the pod scheduled on the node. If there are several,
+// e.g. while a daemon set pod is being replaced, pods that are not being
+// deleted take precedence, then the most recently created one
+func podOnNode(pods []v1.Pod, node string) (*v1.Pod, bool) {
+	var found *v1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Spec.NodeName != node {
+			continue
+		}
+		if found == nil || newerPod(pod, found) {
+			found = pod
+		}
+	}
+	return found, found != nil
+}
+
+// newerPod returns true if the pod a is not being deleted while b is,
+// or if both are in the same state and a has been created after b
+func newerPod(a, b *v1.Pod) bool {
+	if (a.DeletionTimestamp == nil) != (b.DeletionTimestamp == nil) {
+		return a.DeletionTimestamp == nil
+	}
+	return b.CreationTimestamp.Before(&a.CreationTimestamp)
+}
+
 func retry(ctx context.Context, times int, period time.Duration, fn func() error) error {
 	if times < 1 {
 		return nil
 	}
 	err := fn()
 	for i := 1; i < times && err != nil; i += 1 {
-		log.Infof("attempt %v, result: %v, retry in %v", i+1, trace.DebugReport(err), period)
+		contextLogger(ctx).Infof("attempt %v, result: %v, retry in %v", i+1, trace.DebugReport(err), period)
 		select {
 		case <-ctx.Done():
-			log.Infof("context is closing, return")
+			warnCancelled(ctx, err)
 			return err
 		case <-time.After(period):
 		}
@@ -163,6 +197,15 @@ func withRecover(fn func() error, recoverFn func() error) error {
 	return nil
 }
 
+// setRestartAnnotation updates the restart annotation on the pod template
+// metadata so that the controller replaces the pods
+func setRestartAnnotation(meta *metav1.ObjectMeta) {
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[RestartedAtAnnotation] = time.Now().UTC().Format(time.RFC3339Nano)
+}
+
 func nodeSelector(spec *v1.PodSpec) labels.Selector {
 	set := make(labels.Set)
 	for key, val := range spec.NodeSelector {
@@ -171,7 +214,7 @@ func nodeSelector(spec *v1.PodSpec) labels.Selector {
 	return set.AsSelector()
 }
 
-func checkRunning(pods map[string]v1.Pod, nodes []v1.Node, entry *log.Entry) error {
+func checkRunning(pods []v1.Pod, nodes []v1.Node, entry *log.Entry) error {
 	ready, err := checkRunningAndReady(pods, nodes, entry)
 	if ready || err == errPodCompleted {
 		return nil
@@ -179,14 +222,14 @@ func checkRunning(pods map[string]v1.Pod, nodes []v1.Node, entry *log.Entry) err
 	return trace.Wrap(err)
 }
 
-func checkRunningAndReady(pods map[string]v1.Pod, nodes []v1.Node, entry *log.Entry) (bool, error) {
+func checkRunningAndReady(pods []v1.Pod, nodes []v1.Node, entry *log.Entry) (bool, error) {
 	for _, node := range nodes {
-		pod, ok := pods[node.Name]
+		pod, ok := podOnNode(pods, node.Name)
 		if !ok {
 			entry.Infof("no pod found on node %v", node.Name)
 			return false, trace.NotFound("no pod found on node %v", node.Name)
 		}
-		meta := formatMeta(pod.ObjectMeta)
+		meta := FormatMeta(pod.ObjectMeta)
 		switch pod.Status.Phase {
 		case v1.PodFailed, v1.PodSucceeded:
 			entry.Infof("node %v: pod %v is %q", node.Name, meta, pod.Status.Phase)
@@ -198,6 +241,10 @@ func checkRunningAndReady(pods map[string]v1.Pod, nodes []v1.Node, entry *log.En
 			}
 			return ready, nil
 		default:
+			if reason := schedulingReason(*pod); reason != "" {
+				return false, trace.CompareFailed("pod %v is not running yet, status: %q, ready: false: %v",
+					meta, pod.Status.Phase, reason)
+			}
 			return false, trace.CompareFailed("pod %v is not running yet, status: %q, ready: false",
 				meta, pod.Status.Phase)
 		}
@@ -205,6 +252,39 @@ func checkRunningAndReady(pods map[string]v1.Pod, nodes []v1.Node, entry *log.En
 	return false, trace.NotFound("no pods %v found on any nodes %v", pods, nodes)
 }
 
+// checkRecentRestarts returns CompareFailed if a container of the pods has restarted
+// within the window, so that pods that are ready but slowly crash-looping are not ready
+func checkRecentRestarts(pods []v1.Pod, window time.Duration) error {
+	if window <= 0 {
+		return nil
+	}
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		var statuses []v1.ContainerStatus
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.RestartCount == 0 {
+				continue
+			}
+			var restarted time.Time
+			if terminated := status.LastTerminationState.Terminated; terminated != nil {
+				restarted = terminated.FinishedAt.Time
+			}
+			if running := status.State.Running; running != nil && running.StartedAt.After(restarted) {
+				restarted = running.StartedAt.Time
+			}
+			if since := time.Since(restarted); since < window {
+				return trace.CompareFailed("pod %v: container %v restarted %v ago, %v restarts in total",
+					FormatMeta(pod.ObjectMeta), status.Name, since.Round(time.Second), status.RestartCount)
+			}
+		}
+	}
+	return nil
+}
+
 // errPodCompleted is returned by checkRunningAndReady to indicate that
 // the pod has already reached completed state.
 var errPodCompleted = fmt.Errorf("pod ran to completion")
@@ -250,6 +330,9 @@ func ConvertErrorWithContext(err error, format string, args ...interface{}) erro
 		message = fmt.Sprintf("%v: %v", fmt.Sprintf(format, args...), message)
 	}
 
+	if denied := admissionDenied(statusErr); denied != nil {
+		return trace.Wrap(denied, "%v", message)
+	}
 	status := statusErr.Status()
 	switch {
 	case status.Code == http.StatusConflict && status.Reason == metav1.StatusReasonAlreadyExists:
@@ -273,71 +356,56 @@ func isEmptyDetails(details *metav1.StatusDetails) bool {
 	return false
 }
 
-// withExponentialBackoff retries the specified function fn exponentially.
-// If fn returns an already exists error, the operation is retried - any other error
-// aborts the execution.
-// It expects fn to return errors converted to trace type hierarchy with ConvertError
-func withExponentialBackoff(fn func() error) error {
-	const initialDelay = 1 * time.Second
-	backoff := wait.Backoff{
-		Duration: initialDelay,
-		Factor:   2.0,
-		Steps:    10,
-	}
-	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
-		err := fn()
-		if err != nil && !trace.IsAlreadyExists(err) {
-			// abort
-			return false, trace.Wrap(err)
+// recreateObject creates the object replacing the deleted object with the UID oldUID.
+// If the create fails because the deleted object has not been removed yet,
+// it waits for the object to be gone and retries, up to recreateAttempts times.
+// An object with a different UID has been created by someone else and is not waited for.
+// It expects get and create to return errors converted to trace type hierarchy with ConvertError
+func recreateObject(oldUID types.UID, get func() (metav1.Object, error), create func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := create()
+		if err == nil || !trace.IsAlreadyExists(err) || oldUID == "" {
+			return trace.Wrap(err)
 		}
-		if trace.IsAlreadyExists(err) {
-			// retry
-			return false, nil
+		if attempt == recreateAttempts {
+			return trace.Wrap(err, "failed to create after %v attempts: %v", attempt, err)
 		}
-		return true, nil
-	})
-	return trace.Wrap(err)
-}
-
-func deletePodsList(podIface corev1.PodInterface, pods []v1.Pod, entry log.Entry) error {
-	for _, pod := range pods {
-		entry.Debugf("deleting pod %v", pod.Name)
-		err := ConvertError(podIface.Delete(pod.Name, nil))
-		if err != nil && !trace.IsNotFound(err) {
-			return ConvertError(err)
+		log.Infof("previous object with UID %v still exists, waiting for deletion", oldUID)
+		err = waitForObjectDeletion(func() error {
+			current, err := get()
+			if err != nil {
+				return err
+			}
+			if current.GetUID() != oldUID {
+				return trace.AlreadyExists("%v has been recreated with UID %v", current.GetName(), current.GetUID())
+			}
+			return nil
+		})
+		if err != nil {
+			return trace.Wrap(err)
 		}
 	}
-
-	return trace.Wrap(waitForPodsList(podIface, pods, entry))
 }
 
-func deletePods(podIface corev1.PodInterface, pods map[string]v1.Pod, entry log.Entry) error {
-	for _, pod := range pods {
-		entry.Debugf("deleting pod %v", pod.Name)
-		err := ConvertError(podIface.Delete(pod.Name, nil))
-		if err != nil && !trace.IsNotFound(err) {
-			return ConvertError(err)
-		}
-	}
-
-	return trace.Wrap(waitForPods(podIface, pods, entry))
+// recreateAttempts is the maximum number of create attempts of recreateObject
+const recreateAttempts = 3
+
+// deletePods evicts the pods in order of ascending priority respecting
+// disruption budgets and waits for them to be deleted. As the owner of the
+// pods is being deleted or rolled back, a pod whose eviction is blocked
+// by a disruption budget for longer than podEvictionTimeout is deleted
+func deletePods(ctx context.Context, podIface corev1.PodInterface, pods []v1.Pod, entry log.Entry) error {
+	return trace.Wrap(EvictPods(ctx, podIface, pods, EvictionConfig{
+		Timeout:         podEvictionTimeout,
+		DeleteOnTimeout: true,
+	}, &entry))
 }
 
-func waitForPodsList(podIface corev1.PodInterface, pods []v1.Pod, entry log.Entry) error {
-	var errors []error
-	for _, pod := range pods {
-		err := waitForObjectDeletion(func() error {
-			_, err := podIface.Get(pod.Name, metav1.GetOptions{})
-			return ConvertError(err)
-		})
-		if err != nil {
-			errors = append(errors, err)
-		}
-	}
-	return trace.NewAggregate(errors...)
-}
+// podEvictionTimeout limits waiting for disruption budgets
+// to allow evicting the pods of a deleted owner
+const podEvictionTimeout = 2 * time.Minute
 
-func waitForPods(podIface corev1.PodInterface, pods map[string]v1.Pod, entry log.Entry) error {
+func waitForPodsList(podIface corev1.PodInterface, pods []v1.Pod, entry log.Entry) error {
 	var errors []error
 	for _, pod := range pods {
 		err := waitForObjectDeletion(func() error {
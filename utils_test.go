@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type UtilsSuite struct{}
+
+var _ = Suite(&UtilsSuite{})
+
+func (s *UtilsSuite) TestRecreateObject(c *C) {
+	const oldUID = types.UID("old")
+	tcs := []struct {
+		comment string
+		oldUID  types.UID
+		// exists lists the results of the create attempts, true means AlreadyExists
+		exists []bool
+		// current is the UID of the live object, empty if it has been deleted
+		current  types.UID
+		attempts int
+		error    bool
+	}{
+		{comment: "created", oldUID: oldUID, exists: []bool{false}, attempts: 1},
+		{comment: "deleted object lingers", oldUID: oldUID, exists: []bool{true, false}, attempts: 2},
+		{comment: "recreated by someone else", oldUID: oldUID, exists: []bool{true}, current: "new", attempts: 1, error: true},
+		{comment: "no previous object", exists: []bool{true}, attempts: 1, error: true},
+		{comment: "attempts exhausted", oldUID: oldUID, exists: []bool{true, true, true}, attempts: recreateAttempts, error: true},
+	}
+	for _, tc := range tcs {
+		comment := Commentf(tc.comment)
+		var attempts int
+		get := func() (metav1.Object, error) {
+			if tc.current == "" {
+				return nil, trace.NotFound("not found")
+			}
+			return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", UID: tc.current}}, nil
+		}
+		create := func() error {
+			attempts++
+			if tc.exists[attempts-1] {
+				return trace.AlreadyExists("already exists")
+			}
+			return nil
+		}
+		err := recreateObject(tc.oldUID, get, create)
+		if tc.error {
+			c.Assert(trace.IsAlreadyExists(err), Equals, true, comment)
+		} else {
+			c.Assert(err, IsNil, comment)
+		}
+		c.Assert(attempts, Equals, tc.attempts, comment)
+	}
+}
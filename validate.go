@@ -0,0 +1,162 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// TotalAnnotationSizeLimit is the maximum total size of annotation keys and values
+	TotalAnnotationSizeLimit = 256 * 1024
+	// maxCronJobNameLength leaves room for the suffix of the job names
+	// created by the cron job controller
+	maxCronJobNameLength = 52
+)
+
+// ValidateManifests validates metadata of all objects in the manifest stream,
+// returns BadParameter listing every violation
+func ValidateManifests(data []byte) error {
+	objects, err := DecodeObjects(data)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var violations []string
+	for _, object := range objects {
+		for _, violation := range ValidateMetadata(object) {
+			violations = append(violations, fmt.Sprintf("%v %v: %v", object.GetKind(), object.GetName(), violation))
+		}
+	}
+	if len(violations) != 0 {
+		return trace.BadParameter("invalid metadata:\n%v", strings.Join(violations, "\n"))
+	}
+	return nil
+}
+
+// ValidateMetadata validates the name, namespace, labels and annotations
+// of the object and the labels of its pod template, if any,
+// against the rules enforced by the API server
+func ValidateMetadata(object *unstructured.Unstructured) []string {
+	var violations []string
+	name := object.GetName()
+	if name == "" && object.GetGenerateName() == "" {
+		violations = append(violations, "name is required")
+	} else if name != "" {
+		violations = append(violations, prefixEach("name", validateName(object.GetKind(), name))...)
+	}
+	if namespace := object.GetNamespace(); namespace != "" {
+		violations = append(violations, prefixEach("namespace", validation.IsDNS1123Label(namespace))...)
+	}
+	violations = append(violations, validateLabels("labels", object.GetLabels())...)
+	violations = append(violations, validateAnnotations("annotations", object.GetAnnotations())...)
+	path := podSpecPath(object.GetKind())
+	if len(path) > 1 {
+		metaPath := append(append([]string(nil), path[:len(path)-1]...), "metadata")
+		labels, _, _ := unstructured.NestedStringMap(object.Object, append(metaPath, "labels")...)
+		violations = append(violations, validateLabels("pod template labels", labels)...)
+		annotations, _, _ := unstructured.NestedStringMap(object.Object, append(metaPath, "annotations")...)
+		violations = append(violations, validateAnnotations("pod template annotations", annotations)...)
+	}
+	return violations
+}
+
+// validateName validates the object name according to the rules of the kind
+func validateName(kind, name string) []string {
+	switch kind {
+	case KindService:
+		return validation.IsDNS1035Label(name)
+	case KindNamespace:
+		return validation.IsDNS1123Label(name)
+	case KindCronJob:
+		errs := validation.IsDNS1123Subdomain(name)
+		if len(name) > maxCronJobNameLength {
+			errs = append(errs, validation.MaxLenError(maxCronJobNameLength))
+		}
+		return errs
+	case KindJob:
+		// job name is used as a label value of its pods
+		errs := validation.IsDNS1123Subdomain(name)
+		if len(name) > validation.LabelValueMaxLength {
+			errs = append(errs, validation.MaxLenError(validation.LabelValueMaxLength))
+		}
+		return errs
+	case KindRole, KindClusterRole, KindRoleBinding, KindClusterRoleBinding:
+		return validatePathSegmentName(name)
+	}
+	return validation.IsDNS1123Subdomain(name)
+}
+
+// validatePathSegmentName validates names that are only required
+// to be usable as a segment of the resource path
+func validatePathSegmentName(name string) []string {
+	if name == "." || name == ".." {
+		return []string{fmt.Sprintf("may not be %q", name)}
+	}
+	var errs []string
+	for _, illegal := range []string{"/", "%"} {
+		if strings.Contains(name, illegal) {
+			errs = append(errs, fmt.Sprintf("may not contain %q", illegal))
+		}
+	}
+	return errs
+}
+
+func validateLabels(field string, labels map[string]string) []string {
+	var violations []string
+	for _, key := range sortedStringKeys(labels) {
+		violations = append(violations, prefixEach(fmt.Sprintf("%v key %q", field, key), validation.IsQualifiedName(key))...)
+		violations = append(violations, prefixEach(fmt.Sprintf("%v value %q", field, labels[key]), validation.IsValidLabelValue(labels[key]))...)
+	}
+	return violations
+}
+
+func validateAnnotations(field string, annotations map[string]string) []string {
+	var violations []string
+	var size int
+	for _, key := range sortedStringKeys(annotations) {
+		violations = append(violations, prefixEach(fmt.Sprintf("%v key %q", field, key), validation.IsQualifiedName(strings.ToLower(key)))...)
+		size += len(key) + len(annotations[key])
+	}
+	if size > TotalAnnotationSizeLimit {
+		violations = append(violations, fmt.Sprintf("%v: total size %v bytes must be no more than %v bytes",
+			field, size, TotalAnnotationSizeLimit))
+	}
+	return violations
+}
+
+func prefixEach(prefix string, errs []string) []string {
+	out := make([]string, 0, len(errs))
+	for _, err := range errs {
+		out = append(out, fmt.Sprintf("%v: %v", prefix, err))
+	}
+	return out
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
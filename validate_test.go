@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ValidateSuite struct{}
+
+var _ = Suite(&ValidateSuite{})
+
+func (s *ValidateSuite) TestValidateManifests(c *C) {
+	err := ValidateManifests([]byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  labels:
+    app: web
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: system:app
+`))
+	c.Assert(err, IsNil)
+
+	err = ValidateManifests([]byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: 1app
+  namespace: Kube_System
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: App
+  labels:
+    app: ` + strings.Repeat("a", 64) + `
+spec:
+  template:
+    metadata:
+      labels:
+        bad/key/name: web
+`))
+	c.Assert(err, NotNil)
+	message := err.Error()
+	for _, violation := range []string{
+		"Service 1app: name:",
+		"Service 1app: namespace:",
+		"Deployment App: name:",
+		"Deployment App: labels value",
+		`Deployment App: pod template labels key "bad/key/name"`,
+	} {
+		c.Assert(strings.Contains(message, violation), Equals, true, Commentf("missing %q in %v", violation, message))
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+)
+
+// WaitConfig configures how UpsertAndWait of the controls waits
+// for the upserted resource to become ready
+type WaitConfig struct {
+	// RetryAttempts is the number of status attempts, DefaultRetryAttempts if unset
+	RetryAttempts int
+	// RetryPeriod is the period between status attempts, DefaultRetryPeriod if unset
+	RetryPeriod time.Duration
+	// Timeout limits the duration of the wait regardless of the attempts left,
+	// not limited if unset
+	Timeout time.Duration
+}
+
+// upsertReporter upserts a resource and reports its status
+type upsertReporter interface {
+	StatusReporter
+	// Upsert creates or updates the resource
+	Upsert(ctx context.Context) error
+}
+
+// upsertAndWait upserts the resource and polls its status until it is ready
+func (c WaitConfig) upsertAndWait(ctx context.Context, control upsertReporter) error {
+	if err := control.Upsert(ctx); err != nil {
+		return trace.Wrap(err)
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	return trace.Wrap(PollStatus(ctx, c.RetryAttempts, c.RetryPeriod, control))
+}
@@ -0,0 +1,74 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"context"
+	"time"
+
+	"github.com/gravitational/trace"
+	. "gopkg.in/check.v1"
+)
+
+type WaitSuite struct{}
+
+var _ = Suite(&WaitSuite{})
+
+func (s *WaitSuite) TestUpsertAndWait(c *C) {
+	ctx := context.Background()
+	control := &fakeUpserter{readyAfter: 3}
+	config := WaitConfig{RetryAttempts: 5, RetryPeriod: time.Millisecond}
+	c.Assert(config.upsertAndWait(ctx, control), IsNil)
+	c.Assert(control.upserts, Equals, 1)
+	c.Assert(control.checks, Equals, 3)
+
+	control = &fakeUpserter{readyAfter: 10}
+	c.Assert(config.upsertAndWait(ctx, control), NotNil)
+	c.Assert(control.checks, Equals, 5)
+
+	control = &fakeUpserter{readyAfter: 10}
+	config = WaitConfig{RetryAttempts: 1000, RetryPeriod: 10 * time.Millisecond, Timeout: 50 * time.Millisecond}
+	c.Assert(config.upsertAndWait(ctx, control), NotNil)
+	c.Assert(control.checks < 10, Equals, true, Commentf("checks: %v", control.checks))
+
+	control = &fakeUpserter{upsertErr: trace.BadParameter("invalid spec")}
+	c.Assert(trace.IsBadParameter(config.upsertAndWait(ctx, control)), Equals, true)
+	c.Assert(control.checks, Equals, 0)
+}
+
+// fakeUpserter becomes ready after the number of status checks
+type fakeUpserter struct {
+	readyAfter int
+	upsertErr  error
+	upserts    int
+	checks     int
+}
+
+func (f *fakeUpserter) Upsert(ctx context.Context) error {
+	f.upserts++
+	return f.upsertErr
+}
+
+func (f *fakeUpserter) Status() error {
+	f.checks++
+	if f.checks < f.readyAfter {
+		return trace.CompareFailed("not ready")
+	}
+	return nil
+}
+
+func (f *fakeUpserter) Infof(message string, args ...interface{}) {}
@@ -0,0 +1,224 @@
+/*
+Copyright (C) 2018 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rigging
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+
+	goyaml "github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// DecodeObjects decodes all documents in the specified manifest stream
+// as unstructured objects, skipping empty documents
+func DecodeObjects(data []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), DefaultBufferSize)
+	var objects []*unstructured.Unstructured
+	for {
+		var raw runtime.Unknown
+		err := decoder.Decode(&raw)
+		if err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, trace.Wrap(err)
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || bytes.Equal(raw.Raw, []byte("null")) {
+			continue
+		}
+		var object unstructured.Unstructured
+		if err := object.UnmarshalJSON(raw.Raw); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		objects = append(objects, &object)
+	}
+}
+
+// EncodeObjects encodes the objects as a multi-document YAML manifest stream
+func EncodeObjects(objects []*unstructured.Unstructured) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, object := range objects {
+		data, err := object.MarshalJSON()
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		data, err = goyaml.JSONToYAML(data)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// podSpecPath returns the path to the pod spec in the object of the specified kind
+// or nil, if objects of this kind do not have a pod spec
+func podSpecPath(kind string) []string {
+	switch kind {
+	case KindPod:
+		return []string{"spec"}
+	case KindCronJob:
+		return []string{"spec", "jobTemplate", "spec", "template", "spec"}
+	case KindDaemonSet, KindDeployment, KindReplicaSet, KindStatefulSet,
+		KindJob, KindReplicationController:
+		return []string{"spec", "template", "spec"}
+	}
+	return nil
+}
+
+// GetPodSpec returns the pod spec of the specified workload object.
+// Returns NotFound if the object does not have a pod spec
+func GetPodSpec(object *unstructured.Unstructured) (*v1.PodSpec, error) {
+	path := podSpecPath(object.GetKind())
+	if path == nil {
+		return nil, trace.NotFound("%v %v has no pod template", object.GetKind(), object.GetName())
+	}
+	fields, found, err := unstructured.NestedMap(object.Object, path...)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if !found {
+		return nil, trace.NotFound("%v %v has no pod template", object.GetKind(), object.GetName())
+	}
+	var spec v1.PodSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(fields, &spec); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &spec, nil
+}
+
+// SetPodSpec updates the pod spec of the specified workload object in place
+// with the changes made to the spec returned by GetPodSpec. Fields of the
+// object unknown to the pod spec type of the client API version, e.g.
+// seccompProfile or topologySpreadConstraints, are left intact
+func SetPodSpec(object *unstructured.Unstructured, spec v1.PodSpec) error {
+	path := podSpecPath(object.GetKind())
+	if path == nil {
+		return trace.BadParameter("%v %v has no pod template", object.GetKind(), object.GetName())
+	}
+	updated, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&spec)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	current, found, err := unstructured.NestedFieldNoCopy(object.Object, path...)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	fields, ok := current.(map[string]interface{})
+	if !found || !ok {
+		return trace.Wrap(unstructured.SetNestedField(object.Object, updated, path...))
+	}
+	// known are the fields of the current spec as seen through the pod spec type,
+	// changes are the differences between the known and the updated fields
+	var knownSpec v1.PodSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(fields, &knownSpec); err != nil {
+		return trace.Wrap(err)
+	}
+	known, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&knownSpec)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	mergeFields(fields, known, updated)
+	return nil
+}
+
+// mergeFields applies the changes between the known and the updated fields
+// to the current fields in place, fields of the current map missing
+// from the known map are not touched
+func mergeFields(current, known, updated map[string]interface{}) {
+	keys := make(map[string]bool, len(known)+len(updated))
+	for key := range known {
+		keys[key] = true
+	}
+	for key := range updated {
+		keys[key] = true
+	}
+	for key := range keys {
+		updatedValue, inUpdated := updated[key]
+		knownValue, inKnown := known[key]
+		if !inUpdated {
+			delete(current, key)
+			continue
+		}
+		if inKnown && reflect.DeepEqual(updatedValue, knownValue) {
+			continue
+		}
+		switch currentValue := current[key].(type) {
+		case map[string]interface{}:
+			if updatedMap, ok := updatedValue.(map[string]interface{}); ok {
+				knownMap, _ := knownValue.(map[string]interface{})
+				mergeFields(currentValue, knownMap, updatedMap)
+				continue
+			}
+		case []interface{}:
+			if updatedList, ok := updatedValue.([]interface{}); ok {
+				knownList, _ := knownValue.([]interface{})
+				current[key] = mergeList(currentValue, knownList, updatedList)
+				continue
+			}
+		}
+		current[key] = updatedValue
+	}
+}
+
+// mergeList returns the updated list with the changes merged into the current
+// elements. Elements are matched by name, e.g. containers or volumes,
+// or by position if the elements have no names
+func mergeList(current, known, updated []interface{}) []interface{} {
+	merged := make([]interface{}, 0, len(updated))
+	for i, item := range updated {
+		updatedMap, ok := item.(map[string]interface{})
+		if !ok {
+			merged = append(merged, item)
+			continue
+		}
+		currentMap, knownMap := listElement(current, i, updatedMap), listElement(known, i, updatedMap)
+		if currentMap == nil {
+			merged = append(merged, item)
+			continue
+		}
+		mergeFields(currentMap, knownMap, updatedMap)
+		merged = append(merged, currentMap)
+	}
+	return merged
+}
+
+// listElement returns the element of the list with the name of the element,
+// or the element at the index if the element has no name
+func listElement(list []interface{}, index int, element map[string]interface{}) map[string]interface{} {
+	name, ok := element["name"].(string)
+	if !ok {
+		if index >= len(list) {
+			return nil
+		}
+		item, _ := list[index].(map[string]interface{})
+		return item
+	}
+	for _, item := range list {
+		if item, ok := item.(map[string]interface{}); ok && item["name"] == name {
+			return item
+		}
+	}
+	return nil
+}